@@ -0,0 +1,228 @@
+// Command e2e is an end-to-end smoke test of the tiered cache in a real
+// Ollama. It builds Ollama with the tiered cache installed, starts it
+// with a small model against a store in a temporary directory, holds a
+// conversation that runs past the context window, and checks through
+// the runner's admin API that blocks were snapshotted to the store and
+// later restored from it.
+//
+// Usage:
+//
+//	e2e -version v0.16.1
+//	e2e -ollama /path/to/ollama
+//
+// With -ollama it clones that checkout's HEAD rather than the release,
+// so the checkout itself is left alone. It needs git, Go and a C
+// compiler (Ollama's CPU backend builds with cgo), and the network for
+// the clone and the model unless they are local: the model is pulled
+// into the usual models directory (OLLAMA_MODELS), so later runs reuse
+// it. It exits 0 if both checks pass and 1 otherwise, printing the
+// tiered cache's lines of the server log.
+//
+// With -build-only it stops once the patched Ollama builds, with no
+// server or model: a check that the patch, or with -mode generate the
+// templates, still compiles against the release.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+func main() {
+	src := flag.String("src", ".", "ollama-kv-cache-tiering repository to install from")
+	ollama := flag.String("ollama", "", "Ollama checkout to clone instead of the release")
+	version := flag.String("version", "", "Ollama release to clone and test (e.g. v0.16.1)")
+	repo := flag.String("repo", "https://github.com/ollama/ollama.git", "Ollama repository to clone the release from")
+	mode := flag.String("mode", "auto", "patch-ollama apply -mode, or generate")
+	model := flag.String("model", "gemma3:270m", "model to converse with; small, and run by Ollama's own engine")
+	numCtx := flag.Int("ctx", 512, "context window, small to force context shifts")
+	turns := flag.Int("turns", 12, "most turns before giving up on a restore")
+	timeout := flag.Duration("timeout", 30*time.Minute, "limit on the whole run")
+	keep := flag.Bool("keep", false, "keep the work directory (kept anyway on failure)")
+	buildOnly := flag.Bool("build-only", false, "stop once the patched Ollama builds, without a server or model")
+	flag.Parse()
+	if *ollama == "" && *version == "" || flag.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "usage: e2e -version v0.16.1 | -ollama /path/to/ollama [flags]")
+		os.Exit(2)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+	defer cancel()
+	work, err := os.MkdirTemp("", "kv-e2e-")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "e2e: %v\n", err)
+		os.Exit(1)
+	}
+	r := &run{
+		src: absPath(*src), work: work, model: *model,
+		numCtx: *numCtx, turns: *turns,
+		buildOnly: *buildOnly,
+	}
+	status := r.main(ctx, *ollama, *version, *repo, *mode)
+	if status == 0 && !*keep {
+		os.RemoveAll(work)
+	} else {
+		fmt.Printf("work directory kept: %s\n", work)
+	}
+	os.Exit(status)
+}
+
+// run is one smoke test.
+type run struct {
+	src, work string
+	model     string
+	numCtx    int
+	turns     int
+	buildOnly bool
+}
+
+// main runs the test, returning the exit status.
+func (r *run) main(ctx context.Context, ollama, version, repo, mode string) int {
+	checkout := filepath.Join(r.work, "ollama")
+	bin := filepath.Join(r.work, "bin", "ollama")
+
+	step("clone Ollama")
+	var err error
+	if ollama != "" {
+		err = command(ctx, "", "git", "clone", "--quiet", absPath(ollama), checkout)
+	} else {
+		err = command(ctx, "", "git", "clone", "--quiet", "--depth", "1", "--branch", version, repo, checkout)
+	}
+	if err != nil {
+		return failf("%v", err)
+	}
+
+	step("install the tiered cache")
+	args := []string{"run", "./cmd/patch-ollama", "apply", "-mode", mode, "-path", checkout}
+	if mode == "generate" {
+		args = []string{"run", "./cmd/patch-ollama", "generate", "-path", checkout}
+	}
+	if version != "" {
+		args = append(args, "-version", version)
+	}
+	if err := command(ctx, r.src, "go", args...); err != nil {
+		return failf("%v", err)
+	}
+
+	step("build Ollama")
+	if err := command(ctx, checkout, "go", "generate", "./..."); err != nil {
+		return failf("%v", err)
+	}
+	if err := command(ctx, checkout, "go", "build", "-o", bin, "."); err != nil {
+		return failf("%v", err)
+	}
+	if r.buildOnly {
+		fmt.Printf("ok    built %s\n", bin)
+		return 0
+	}
+
+	step("start ollama serve")
+	srv, err := startServer(ctx, bin, r.work, r.numCtx)
+	if err != nil {
+		return failf("%v", err)
+	}
+	defer srv.stop()
+	fmt.Printf("listening on %s, admin API on %s, store in %s\n", srv.addr, srv.admin, srv.store)
+
+	step("pull " + r.model)
+	if err := srv.pull(ctx, r.model); err != nil {
+		return r.fail(srv, "pull %s: %v", r.model, err)
+	}
+
+	step("converse past the context window")
+	st, err := r.converse(ctx, srv)
+	if err != nil {
+		return r.fail(srv, "%v", err)
+	}
+
+	step("check")
+	restores := srv.logCount("tiered: restored KV from disk") + srv.logCount("tiered: extended prefix from disk")
+	failed := false
+	if st.Puts > 0 && st.LocalBlocks+st.RemoteBlocks > 0 {
+		fmt.Printf("ok    snapshotted: %d puts, %d blocks stored\n", st.Puts, st.LocalBlocks+st.RemoteBlocks)
+	} else {
+		fmt.Printf("FAIL  nothing snapshotted: %d puts, %d blocks stored\n", st.Puts, st.LocalBlocks+st.RemoteBlocks)
+		failed = true
+	}
+	if st.Hits > 0 {
+		fmt.Printf("ok    restored: %d block hits, %d restores logged\n", st.Hits, restores)
+	} else {
+		fmt.Printf("FAIL  nothing restored: %d block hits, %d restores logged\n", st.Hits, restores)
+		failed = true
+	}
+	if failed {
+		return r.fail(srv, "the tiered cache was not exercised")
+	}
+	fmt.Println("\npass")
+	return 0
+}
+
+// converse holds the conversation, a turn at a time, until the store
+// has both snapshotted and restored blocks or r.turns run out, and
+// returns the store's statistics after the last turn.
+func (r *run) converse(ctx context.Context, srv *server) (diskstore.Stats, error) {
+	msgs := []message{{Role: "system", Content: "You are a storyteller. Answer every message with a long paragraph continuing the story."}}
+	var st diskstore.Stats
+	for turn := 1; turn <= r.turns; turn++ {
+		msgs = append(msgs, message{Role: "user", Content: fmt.Sprintf("Part %d, please: continue the story where it left off, in detail.", turn)})
+		resp, err := srv.chat(ctx, r.model, msgs, r.numCtx)
+		if err != nil {
+			return st, fmt.Errorf("turn %d: %w", turn, err)
+		}
+		msgs = append(msgs, resp.Message)
+		if st, err = srv.stats(ctx); err != nil {
+			return st, fmt.Errorf("turn %d: admin API: %w", turn, err)
+		}
+		fmt.Printf("turn %2d: %4d prompt tokens, %4d generated; store: %d puts, %d hits, %d blocks\n",
+			turn, resp.PromptEvalCount, resp.EvalCount, st.Puts, st.Hits, st.LocalBlocks+st.RemoteBlocks)
+		if st.Puts > 0 && st.Hits > 0 {
+			break
+		}
+	}
+	return st, nil
+}
+
+// fail reports a failure with the server log's tiered lines.
+func (r *run) fail(srv *server, format string, args ...any) int {
+	fmt.Println("\nserver log, tiered cache lines:")
+	for _, line := range srv.logLines("tiered") {
+		fmt.Println("  " + line)
+	}
+	fmt.Printf("full log: %s\n", srv.log)
+	return failf(format, args...)
+}
+
+func step(name string) {
+	fmt.Printf("\n== %s\n", name)
+}
+
+// command runs a command in dir, its output passed through.
+func command(ctx context.Context, dir, name string, args ...string) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Dir = dir
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s %s: %w", name, args[0], err)
+	}
+	return nil
+}
+
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// failf reports a failure and returns the exit status for it.
+func failf(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "e2e: "+format+"\n", args...)
+	return 1
+}
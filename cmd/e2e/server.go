@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// server is a running ollama serve with tiering on, its output in log.
+type server struct {
+	cmd   *exec.Cmd
+	done  chan error // the result of cmd.Wait
+	addr  string     // of the Ollama API
+	admin string     // of the runner's admin API
+	store string     // the local tier
+	log   string
+}
+
+// startServer starts bin serve with a store under work, one slot with a
+// context window of numCtx, and the admin API on, and waits for its API.
+func startServer(ctx context.Context, bin, work string, numCtx int) (*server, error) {
+	addr, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	admin, err := freePort()
+	if err != nil {
+		return nil, err
+	}
+	s := &server{
+		addr:  addr,
+		admin: admin,
+		store: filepath.Join(work, "store"),
+		log:   filepath.Join(work, "serve.log"),
+		done:  make(chan error, 1),
+	}
+	logf, err := os.Create(s.log)
+	if err != nil {
+		return nil, err
+	}
+	s.cmd = exec.Command(bin, "serve")
+	s.cmd.Stdout, s.cmd.Stderr = logf, logf
+	s.cmd.Env = append(os.Environ(),
+		"OLLAMA_HOST="+addr,
+		"OLLAMA_DEBUG=1", // for the restores logged at debug level
+		"OLLAMA_NUM_PARALLEL=1",
+		fmt.Sprintf("OLLAMA_CONTEXT_LENGTH=%d", numCtx),
+		"OLLAMA_KV_TIERING=1",
+		"OLLAMA_KV_TIER_LOCAL="+s.store,
+		"OLLAMA_KV_TIER_ADMIN="+admin,
+	)
+	if err := s.cmd.Start(); err != nil {
+		logf.Close()
+		return nil, err
+	}
+	go func() {
+		s.done <- s.cmd.Wait()
+		logf.Close()
+	}()
+
+	deadline := time.Now().Add(time.Minute)
+	for {
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+addr+"/api/version", nil)
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return s, nil
+			}
+		}
+		select {
+		case err := <-s.done:
+			s.done <- err
+			return nil, fmt.Errorf("ollama serve exited: %v; see %s", err, s.log)
+		case <-ctx.Done():
+			s.stop()
+			return nil, ctx.Err()
+		case <-time.After(200 * time.Millisecond):
+		}
+		if time.Now().After(deadline) {
+			s.stop()
+			return nil, fmt.Errorf("ollama serve not answering on %s after a minute; see %s", addr, s.log)
+		}
+	}
+}
+
+// stop stops the server, killing it if an interrupt doesn't.
+func (s *server) stop() {
+	if err := s.cmd.Process.Signal(os.Interrupt); err != nil {
+		s.cmd.Process.Kill()
+	}
+	select {
+	case err := <-s.done:
+		s.done <- err
+	case <-time.After(10 * time.Second):
+		s.cmd.Process.Kill()
+	}
+}
+
+// message is a chat message of the Ollama API.
+type message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// chatResponse is the part of a /api/chat response used.
+type chatResponse struct {
+	Message         message `json:"message"`
+	PromptEvalCount int     `json:"prompt_eval_count"`
+	EvalCount       int     `json:"eval_count"`
+}
+
+// pull pulls model, if the models directory lacks it.
+func (s *server) pull(ctx context.Context, model string) error {
+	return s.post(ctx, "/api/pull", map[string]any{"model": model, "stream": false}, nil)
+}
+
+// chat sends msgs to model with a context window of numCtx, asking for up
+// to half a window of reply so that the conversation outgrows it.
+func (s *server) chat(ctx context.Context, model string, msgs []message, numCtx int) (chatResponse, error) {
+	var resp chatResponse
+	err := s.post(ctx, "/api/chat", map[string]any{
+		"model":    model,
+		"messages": msgs,
+		"stream":   false,
+		"options": map[string]any{
+			"num_ctx":     numCtx,
+			"num_predict": numCtx / 2,
+			"seed":        1,
+		},
+	}, &resp)
+	return resp, err
+}
+
+// stats returns the store's statistics from the runner's admin API.
+func (s *server) stats(ctx context.Context) (diskstore.Stats, error) {
+	var st diskstore.Stats
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+s.admin+"/stats", nil)
+	if err != nil {
+		return st, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("GET /stats: %s", resp.Status)
+	}
+	return st, json.NewDecoder(resp.Body).Decode(&st)
+}
+
+// post posts body to the Ollama API as JSON, decoding the response into
+// out if set.
+func (s *server) post(ctx context.Context, path string, body, out any) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, "http://"+s.addr+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("POST %s: %s: %s", path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		_, err = io.Copy(io.Discard, resp.Body)
+		return err
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// logLines returns the lines of the server log containing substr.
+func (s *server) logLines(substr string) []string {
+	f, err := os.Open(s.log)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	var lines []string
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if strings.Contains(sc.Text(), substr) {
+			lines = append(lines, sc.Text())
+		}
+	}
+	return lines
+}
+
+// logCount returns how many lines of the server log contain substr.
+func (s *server) logCount(substr string) int {
+	return len(s.logLines(substr))
+}
+
+// freePort returns a loopback address with a port free to listen on.
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
@@ -0,0 +1,135 @@
+// Command kvblockd serves a diskstore over the network so GPU nodes with
+// little local disk can spill KV blocks to a storage node without NFS.
+//
+// Usage:
+//
+//	kvblockd -listen :11600 -local /srv/kv-cache -local-gb 2000
+//
+// On the GPU node, point the runner at it with
+// OLLAMA_KV_TIER_REMOTE_ADDR=storage-node:11600, or set
+// diskstore.Config.RemoteTier to diskstore.NewRemoteClient(addr, 0).
+// Several kvblockds holding the same blocks are used as replicas, with
+// failover and hedged reads, through a URL naming them all:
+// OLLAMA_KV_TIER_REMOTE_URL=kvblock://node-a:11600,node-b:11600?hedge=20ms
+// (see diskstore.OpenTierURL).
+//
+// -remote-url gives kvblockd itself such a tier below its own disk, in
+// place of -remote: any URL OpenTierURL takes, budgeted by -remote-gb.
+//
+// With -settings, the settings a store can change at runtime are read
+// from a JSON file in the admin API's form (see diskstore.Settings), e.g.
+// {"local": bytes, "remote": bytes, "eviction": "lfu"}, over -local-gb
+// and -remote-gb. Edit it and send SIGHUP to apply them without a
+// restart; blocks are moved or dropped to fit new budgets. -budget-file
+// is its old name.
+//
+// The protocol has no authentication; listen on a trusted network only.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+func main() {
+	listen := flag.String("listen", ":11600", "address to serve blocks on")
+	admin := flag.String("admin", "", "optional address for the admin API")
+	local := flag.String("local", "/var/lib/kvblockd", "directory for blocks")
+	remote := flag.String("remote", "", "optional second-tier directory (e.g. HDD)")
+	remoteURL := flag.String("remote-url", "", "optional second-tier service instead of -remote (e.g. kvblock://a:11600,b:11600)")
+	localGB := flag.Int64("local-gb", 100, "budget for -local in GB")
+	remoteGB := flag.Int64("remote-gb", 0, "budget for -remote in GB")
+	compress := flag.Bool("compress", false, "zstd-compress stored blocks")
+	settings := flag.String("settings", "", "optional JSON settings file, re-read on SIGHUP")
+	flag.StringVar(settings, "budget-file", "", "old name of -settings")
+	flag.Parse()
+
+	cfg := diskstore.Config{
+		LocalPath:    *local,
+		RemotePath:   *remote,
+		LocalBudget:  *localGB << 30,
+		RemoteBudget: *remoteGB << 30,
+		Compress:     *compress,
+	}
+	if *remoteURL != "" {
+		if *remote != "" {
+			fmt.Fprintln(os.Stderr, "kvblockd: -remote and -remote-url are exclusive")
+			os.Exit(2)
+		}
+		t, err := diskstore.OpenTierURL(*remoteURL, 0)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvblockd: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.RemoteTier = t
+	}
+	if *settings != "" {
+		st, err := diskstore.ReadSettings(*settings, cfg.Settings())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvblockd: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.ApplySettings(st)
+	}
+
+	store, err := diskstore.New(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvblockd: %v\n", err)
+		os.Exit(1)
+	}
+	if *settings != "" {
+		if err := store.WatchSettings(*settings, syscall.SIGHUP); err != nil {
+			store.Close()
+			fmt.Fprintf(os.Stderr, "kvblockd: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	servers := []*http.Server{{Addr: *listen, Handler: store.BlockServiceHandler()}}
+	if *admin != "" {
+		servers = append(servers, &http.Server{Addr: *admin, Handler: store.AdminHandler()})
+	}
+
+	errc := make(chan error, len(servers))
+	for _, srv := range servers {
+		go func() {
+			slog.Info("kvblockd listening", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	code := 0
+	select {
+	case s := <-sig:
+		slog.Info("kvblockd shutting down", "signal", s)
+	case err := <-errc:
+		slog.Error("kvblockd server failed", "error", err)
+		code = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(ctx)
+	}
+	// Persist the index only after in-flight requests have finished.
+	if err := store.Close(); err != nil {
+		slog.Error("kvblockd: close store", "error", err)
+		code = 1
+	}
+	os.Exit(code)
+}
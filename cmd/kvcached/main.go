@@ -0,0 +1,159 @@
+// Command kvcached runs the KV block store as a sidecar of the Ollama
+// runner on the same machine, serving it over a Unix domain socket, so
+// the store can be restarted or upgraded without restarting the runner.
+//
+// Usage:
+//
+//	kvcached -socket /run/kvcached.sock -local /var/lib/kvcached -local-gb 200
+//
+// Point the runner at it with OLLAMA_KV_TIER_SOCKET=/run/kvcached.sock,
+// or set diskstore.Config.RemoteTier to diskstore.NewSocketClient(path,
+// 0): the runner keeps its blocks in the daemon once they leave its own
+// local tier, which can then be small. While the daemon is down the
+// runner runs degraded, as with any remote tier that is away.
+//
+// With -settings, budgets, codecs, bandwidth limits and the eviction
+// policy are read from a JSON file as kvblockd's are, and re-read on
+// SIGHUP.
+//
+// The socket is created with -socket-mode permissions; give the runner's
+// user access through its group. A socket file left by a daemon that
+// died is replaced; one a running daemon answers on is not.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+func main() {
+	socket := flag.String("socket", "/run/kvcached.sock", "Unix domain socket to serve blocks on")
+	mode := flag.String("socket-mode", "0660", "permissions of the socket, in octal")
+	admin := flag.String("admin", "", "optional address for the admin API")
+	local := flag.String("local", "/var/lib/kvcached", "directory for blocks")
+	remote := flag.String("remote", "", "optional second-tier directory (e.g. NFS)")
+	localGB := flag.Int64("local-gb", 100, "budget for -local in GB")
+	remoteGB := flag.Int64("remote-gb", 0, "budget for -remote in GB")
+	compress := flag.Bool("compress", false, "zstd-compress stored blocks")
+	settings := flag.String("settings", "", "optional JSON settings file, re-read on SIGHUP")
+	flag.Parse()
+
+	perm, err := strconv.ParseUint(*mode, 8, 32)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvcached: -socket-mode %q: not an octal mode\n", *mode)
+		os.Exit(2)
+	}
+
+	cfg := diskstore.Config{
+		LocalPath:    *local,
+		RemotePath:   *remote,
+		LocalBudget:  *localGB << 30,
+		RemoteBudget: *remoteGB << 30,
+		Compress:     *compress,
+	}
+	if *settings != "" {
+		st, err := diskstore.ReadSettings(*settings, cfg.Settings())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvcached: %v\n", err)
+			os.Exit(1)
+		}
+		cfg.ApplySettings(st)
+	}
+
+	// The socket first: a second daemon must not open the store.
+	l, err := listenSocket(*socket, os.FileMode(perm))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvcached: %v\n", err)
+		os.Exit(1)
+	}
+	store, err := diskstore.New(cfg)
+	if err == nil && *settings != "" {
+		if err = store.WatchSettings(*settings, syscall.SIGHUP); err != nil {
+			store.Close()
+		}
+	}
+	if err != nil {
+		l.Close()
+		fmt.Fprintf(os.Stderr, "kvcached: %v\n", err)
+		os.Exit(1)
+	}
+
+	blocks := &http.Server{Handler: store.BlockServiceHandler()}
+	servers := []*http.Server{blocks}
+	errc := make(chan error, 2)
+	go func() {
+		slog.Info("kvcached listening", "socket", *socket)
+		if err := blocks.Serve(l); !errors.Is(err, http.ErrServerClosed) {
+			errc <- err
+		}
+	}()
+	if *admin != "" {
+		srv := &http.Server{Addr: *admin, Handler: store.AdminHandler()}
+		servers = append(servers, srv)
+		go func() {
+			slog.Info("kvcached admin API listening", "addr", srv.Addr)
+			if err := srv.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errc <- err
+			}
+		}()
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+	code := 0
+	select {
+	case s := <-sig:
+		slog.Info("kvcached shutting down", "signal", s)
+	case err := <-errc:
+		slog.Error("kvcached server failed", "error", err)
+		code = 1
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, srv := range servers {
+		srv.Shutdown(ctx) // closes l, which removes the socket file
+	}
+	// Persist the index only after in-flight requests have finished.
+	if err := store.Close(); err != nil {
+		slog.Error("kvcached: close store", "error", err)
+		code = 1
+	}
+	os.Exit(code)
+}
+
+// listenSocket listens on the Unix domain socket at path with
+// permissions perm, replacing a stale socket file but not one a daemon
+// still answers on.
+func listenSocket(path string, perm os.FileMode) (net.Listener, error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.DialTimeout("unix", path, time.Second); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%s: another kvcached is serving it", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("remove stale socket: %w", err)
+		}
+	}
+	l, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, perm); err != nil {
+		l.Close()
+		return nil, err
+	}
+	return l, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// The bench command runs Store.Bench against the tiers the global flags
+// name and tells, from each tier's sequential read rate, whether
+// restoring a position beats prefilling it: a position of an f16 cache
+// is 2 (keys and values) × layers × kv-dim × 2 bytes, read from every
+// layer.
+
+type benchReport struct {
+	diskstore.BenchResult
+	PositionBytes int64   `json:"position_bytes"`
+	PrefillTPS    float64 `json:"prefill_tps"`
+
+	// RestoreTPS is the positions per second each tier restores.
+	RestoreTPS map[string]float64 `json:"restore_tps"`
+}
+
+func cmdBench(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	blocks := fs.Int("blocks", 64, "blocks written and read per phase")
+	blockSize := fs.String("block-size", "512K", "size of each block")
+	tps := fs.Float64("prefill-tps", 1000, "prefill throughput in tokens per second to compare restores with")
+	layers := fs.Int("layers", 48, "model layers")
+	kvDim := fs.Int("kv-dim", 1024, "KV heads × head dimension")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+	size, err := parseSize(*blockSize)
+	if err != nil || size >= unlimited || size <= 0 || *blocks <= 0 || *tps <= 0 || *layers <= 0 || *kvDim <= 0 {
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl bench [-blocks N] [-block-size 512K] [-prefill-tps N] [-layers N] [-kv-dim N] [-json]")
+		return 2
+	}
+
+	res, err := store.Bench(diskstore.BenchOptions{BlockBytes: int(size), Blocks: *blocks})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: bench: %v\n", err)
+		return 1
+	}
+	rep := benchReport{
+		BenchResult:   res,
+		PositionBytes: 2 * int64(*layers) * int64(*kvDim) * 2,
+		PrefillTPS:    *tps,
+		RestoreTPS:    make(map[string]float64),
+	}
+	for _, t := range res.Tiers {
+		rep.RestoreTPS[t.Tier] = t.Phase(diskstore.BenchSeqRead).Throughput() / float64(rep.PositionBytes)
+	}
+	if *asJSON {
+		return printJSON(rep)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "tier\tphase\tops\tthroughput\tp50\tp99\n")
+	for _, t := range res.Tiers {
+		for _, p := range t.Phases {
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s/s\t%s\t%s\n", t.Tier, p.Name, p.Ops, formatSize(int64(p.Throughput())),
+				p.P50.Round(time.Microsecond), p.P99.Round(time.Microsecond))
+		}
+	}
+	w.Flush()
+
+	fmt.Printf("\na position is %s (%d layers, kv-dim %d, f16); prefill runs at %.0f tokens/s\n",
+		formatSize(rep.PositionBytes), *layers, *kvDim, *tps)
+	for _, t := range res.Tiers {
+		restore := rep.RestoreTPS[t.Tier]
+		verdict := "restore beats recompute"
+		if restore < *tps {
+			verdict = "recompute beats restore"
+		}
+		fmt.Printf("%s: restores %.0f positions/s, %.1fx prefill: %s\n", t.Tier, restore, restore / *tps, verdict)
+	}
+	return 0
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// The du command prints Stats' usage breakdown as a table, largest
+// sequences first, layers and age buckets in order, with each row's
+// share of all stored bytes.
+
+type duRow struct {
+	Name string `json:"name"`
+	diskstore.UsageStats
+}
+
+func cmdDu(store *diskstore.Store, args []string) int {
+	return runDu(store.Stats(), args)
+}
+
+func runDu(st diskstore.Stats, args []string) int {
+	fs := flag.NewFlagSet("du", flag.ExitOnError)
+	by := fs.String("by", "seq", "break usage down by seq, layer or age")
+	top := fs.Int("top", 0, "with -by seq: only the N largest sequences")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	var rows []duRow
+	switch *by {
+	case "seq":
+		for seq, u := range st.BySeq {
+			rows = append(rows, duRow{seqName(seq), u})
+		}
+		sort.Slice(rows, func(i, j int) bool { return rows[i].Bytes() > rows[j].Bytes() })
+		if *top > 0 && len(rows) > *top {
+			rows = rows[:*top]
+		}
+	case "layer":
+		layers := make([]int, 0, len(st.ByLayer))
+		for layer := range st.ByLayer {
+			layers = append(layers, layer)
+		}
+		sort.Ints(layers)
+		for _, layer := range layers {
+			rows = append(rows, duRow{strconv.Itoa(layer), st.ByLayer[layer]})
+		}
+	case "age":
+		prev := "0"
+		for _, a := range st.ByAge {
+			name := "> " + prev
+			if a.MaxAge > 0 {
+				name = "< " + formatAge(a.MaxAge)
+				prev = formatAge(a.MaxAge)
+			}
+			rows = append(rows, duRow{name, a.UsageStats})
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl du [-by seq|layer|age] [-top N] [-json]")
+		return 2
+	}
+	if *asJSON {
+		return printJSON(rows)
+	}
+
+	total := st.LocalUsed + st.RemoteUsed
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\tblocks\tlocal\tremote\tlogical\tshare\n", *by)
+	for _, r := range rows {
+		share := 0.0
+		if total > 0 {
+			share = 100 * float64(r.Bytes()) / float64(total)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%.1f%%\n", r.Name, r.Blocks,
+			formatSize(r.LocalBytes), formatSize(r.RemoteBytes), formatSize(r.Logical), share)
+	}
+	w.Flush()
+	return 0
+}
+
+// seqName labels the sequences holding blocks that belong to no slot.
+func seqName(seq int) string {
+	switch seq {
+	case diskstore.PrefixSeq:
+		return "prefix"
+	case diskstore.EncoderSeq:
+		return "encoder"
+	}
+	return strconv.Itoa(seq)
+}
+
+// formatAge prints an age bucket bound in hours or days.
+func formatAge(d time.Duration) string {
+	if d >= 24*time.Hour {
+		return fmt.Sprintf("%dd", d/(24*time.Hour))
+	}
+	return fmt.Sprintf("%dh", d/time.Hour)
+}
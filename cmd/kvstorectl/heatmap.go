@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"text/tabwriter"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// The heatmap command dumps a sequence's Heatmap for plotting: per block
+// as JSON or CSV, or as a table of position runs with their reads and
+// tiers.
+
+type heatmapFlags struct {
+	seq    int
+	format string
+	out    string
+}
+
+func parseHeatmapFlags(args []string) (heatmapFlags, bool) {
+	fs := flag.NewFlagSet("heatmap", flag.ExitOnError)
+	var f heatmapFlags
+	fs.IntVar(&f.seq, "seq", -3, "sequence to map (-1 for prefix-addressed blocks)")
+	fs.StringVar(&f.format, "format", "text", "text, json or csv")
+	fs.StringVar(&f.out, "o", "", "output file (default stdout)")
+	fs.Parse(args)
+	if f.seq == -3 || f.format != "text" && f.format != "json" && f.format != "csv" {
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl heatmap -seq N [-format text|json|csv] [-o file]")
+		return f, false
+	}
+	return f, true
+}
+
+func cmdHeatmap(store *diskstore.Store, args []string) int {
+	f, ok := parseHeatmapFlags(args)
+	if !ok {
+		return 2
+	}
+	return printHeatmap(store.Heatmap(f.seq), f)
+}
+
+func cmdLiveHeatmap(addr string, args []string) int {
+	f, ok := parseHeatmapFlags(args)
+	if !ok {
+		return 2
+	}
+	resp, err := http.Get(fmt.Sprintf("%s/heatmap?seq=%d", addr, f.seq))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	var h diskstore.Heatmap
+	if resp.StatusCode != http.StatusOK {
+		err = fmt.Errorf("GET %s/heatmap: %s", addr, resp.Status)
+	} else {
+		err = json.NewDecoder(resp.Body).Decode(&h)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	return printHeatmap(h, f)
+}
+
+func printHeatmap(h diskstore.Heatmap, f heatmapFlags) int {
+	var w io.Writer = os.Stdout
+	if f.out != "" {
+		file, err := os.Create(f.out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: heatmap: %v\n", err)
+			return 1
+		}
+		defer file.Close()
+		w = file
+	}
+
+	var err error
+	switch f.format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(h)
+	case "csv":
+		err = h.WriteCSV(w)
+	default:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "positions\thits\tlocal blocks\tremote blocks\n")
+		for _, s := range h.Positions {
+			fmt.Fprintf(tw, "%d-%d\t%d\t%d\t%d\n", s.BeginPos, s.EndPos, s.Hits, s.LocalBlocks, s.RemoteBlocks)
+		}
+		err = tw.Flush()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: heatmap: %v\n", err)
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// runLive runs a command against a running store's admin API.
+func runLive(addr, cmd string, args []string) int {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	addr = strings.TrimSuffix(addr, "/")
+
+	switch cmd {
+	case "stats":
+		return cmdLiveStats(addr, args)
+	case "budget":
+		return cmdLiveBudget(addr, args)
+	case "events":
+		return cmdLiveEvents(addr, args)
+	case "du":
+		st, err := fetchStats(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+			return 1
+		}
+		return runDu(st, args)
+	case "heatmap":
+		return cmdLiveHeatmap(addr, args)
+	default:
+		fmt.Fprintf(os.Stderr, "kvstorectl: %q is not available with -admin\n", cmd)
+		return 2
+	}
+}
+
+func cmdLiveStats(addr string, args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	watch := fs.Duration("watch", 0, "print rates every interval")
+	compression := fs.Bool("compression", false, "break compression down by dtype and layer")
+	fs.Parse(args)
+
+	st, err := fetchStats(addr)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	if *watch <= 0 {
+		return printStats(st, nil, *asJSON, *compression)
+	}
+	return watchStats(addr, st, *watch)
+}
+
+// cmdLiveBudget prints the running store's budgets or, with -local or
+// -remote, changes them, printing what the store did to fit them.
+func cmdLiveBudget(addr string, args []string) int {
+	fs := flag.NewFlagSet("budget", flag.ExitOnError)
+	local := fs.String("local", "", "new local tier budget (e.g. 20G)")
+	remote := fs.String("remote", "", "new remote tier budget (e.g. 5T)")
+	fs.Parse(args)
+
+	body := make(map[string]int64)
+	for name, v := range map[string]string{"local": *local, "remote": *remote} {
+		if v == "" {
+			continue
+		}
+		n, err := parseSize(v)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: -%s: %v\n", name, err)
+			return 2
+		}
+		body[name] = n
+	}
+
+	var resp *http.Response
+	var err error
+	if len(body) == 0 {
+		resp, err = http.Get(addr + "/budget")
+	} else {
+		data, _ := json.Marshal(body)
+		req, _ := http.NewRequest(http.MethodPut, addr+"/budget", bytes.NewReader(data))
+		resp, err = http.DefaultClient.Do(req)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		fmt.Fprintf(os.Stderr, "kvstorectl: %s /budget: %s: %s\n", resp.Request.Method, resp.Status, bytes.TrimSpace(msg))
+		return 1
+	}
+	var res struct {
+		Local, Remote  int64
+		Moved, Dropped int
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: decode budget: %v\n", err)
+		return 1
+	}
+	fmt.Printf("local %s, remote %s\n", formatSize(res.Local), formatSize(res.Remote))
+	if len(body) > 0 {
+		fmt.Printf("moved %d blocks to remote, dropped %d\n", res.Moved, res.Dropped)
+	}
+	return 0
+}
+
+// cmdLiveEvents prints the running store's block events as they happen,
+// until interrupted.
+func cmdLiveEvents(addr string, args []string) int {
+	fs := flag.NewFlagSet("events", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print one JSON event per line")
+	fs.Parse(args)
+
+	resp, err := http.Get(addr + "/events/stream")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		fmt.Fprintf(os.Stderr, "kvstorectl: GET %s/events/stream: %s\n", addr, resp.Status)
+		return 1
+	}
+	sc := bufio.NewScanner(resp.Body)
+	for sc.Scan() {
+		data, ok := strings.CutPrefix(sc.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if *asJSON {
+			fmt.Println(data)
+			continue
+		}
+		var ev diskstore.Event
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: decode event: %v\n", err)
+			continue
+		}
+		fmt.Printf("%s  %-7s  %-6s  %8s  %s\n", ev.At.Format(time.TimeOnly), ev.Kind, ev.Tier, formatSize(int64(ev.Size)), ev.Key)
+	}
+	if err := sc.Err(); err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// watchStats prints one line of rates per interval until interrupted.
+// Rates are deltas of the store's cumulative counters; the hit ratio is
+// over the interval's lookups only, so it shows the effect of a change
+// rather than the lifetime average.
+func watchStats(addr string, prev diskstore.Stats, interval time.Duration) int {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "time\tputs/s\thits/s\tmisses/s\thit%%\tevict/s\tlocal\tremote\t\n")
+	w.Flush()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	last := time.Now()
+	for now := range t.C {
+		cur, err := fetchStats(addr)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+			continue
+		}
+		if cur.Puts < prev.Puts || cur.Hits < prev.Hits || cur.Misses < prev.Misses {
+			// The runner restarted; start over from its new counters.
+			fmt.Println("counters reset")
+			prev, last = cur, now
+			continue
+		}
+
+		secs := now.Sub(last).Seconds()
+		rate := func(cur, prev int64) string {
+			return fmt.Sprintf("%.1f", float64(cur-prev)/secs)
+		}
+		ratio := "-"
+		if lookups := (cur.Hits - prev.Hits) + (cur.Misses - prev.Misses); lookups > 0 {
+			ratio = fmt.Sprintf("%.1f", 100*float64(cur.Hits-prev.Hits)/float64(lookups))
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t\n", now.Format(time.TimeOnly),
+			rate(cur.Puts, prev.Puts), rate(cur.Hits, prev.Hits), rate(cur.Misses, prev.Misses),
+			ratio, rate(cur.Evictions, prev.Evictions), formatSize(cur.LocalUsed), formatSize(cur.RemoteUsed))
+		w.Flush()
+		prev, last = cur, now
+	}
+	return 0
+}
+
+func fetchStats(addr string) (diskstore.Stats, error) {
+	var st diskstore.Stats
+	resp, err := http.Get(addr + "/stats")
+	if err != nil {
+		return st, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return st, fmt.Errorf("GET %s/stats: %s", addr, resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return st, fmt.Errorf("decode stats: %w", err)
+	}
+	return st, nil
+}
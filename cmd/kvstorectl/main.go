@@ -0,0 +1,710 @@
+// Command kvstorectl inspects and maintains a diskstore directory offline.
+//
+// Usage:
+//
+//	kvstorectl [global flags] <command> [command flags]
+//
+// Commands:
+//
+//	stats     print storage statistics
+//	ls        list blocks (filter with -seq, -layer, -tier, -ns, -older, -idle)
+//	tree      show namespaces, sequences, layer coverage and position ranges
+//	du        break disk usage down by sequence, layer or age
+//	heatmap   dump a sequence's per-block reads and tiers as JSON or CSV
+//	rm-seq    remove every block of a sequence
+//	gc        drop index entries with missing files and delete orphan files
+//	verify    check every block against its checksum and size, optionally repairing
+//	compact   pack cold remote blocks into archive bundles, rewrite sparse ones
+//	migrate   move blocks between the local and remote tiers, or upgrade the store format
+//	export    write a sequence to a portable archive, llama.cpp session or LMCache chunks
+//	import    load a sequence from an archive or llama.cpp session
+//	sessions  list named sessions and the sequences holding them
+//	profiles  list the built-in namespace policy profiles
+//	report    summarize cache effectiveness from the stats history
+//	replay    re-run a recorded operation trace against a store and report its hits
+//	bench     time writes and reads on each tier and compare restores with prefill
+//	simulate  project hits, GPU time saved and disk usage of a workload before deploying
+//
+// migrate upgrades a store written in an older on-disk format in place
+// before anything else; with -format it does only that. The runner and
+// the other commands refuse such a store until it has been upgraded.
+//
+// -sharding moves a store's blocks to another directory shard scheme as
+// it is opened, before the command runs:
+//
+//	kvstorectl -sharding hash2 migrate -format
+//
+// Commands that change the store need the Ollama runner using it stopped
+// first: kvstorectl opens the directory directly and rewrites its index
+// on exit. stats, ls, tree, du and heatmap open it read-only and run
+// alongside the runner, showing the index as the runner last saved it
+// (when it last closed the store). With -admin, stats, du and heatmap
+// instead query a running runner's admin API (OLLAMA_KV_TIER_ADMIN),
+// and -watch prints per-interval rates; budget reads or changes its
+// budgets, moving and dropping blocks to fit; events follows its block
+// puts, evictions, promotions and removals as they happen:
+//
+//	kvstorectl -admin 127.0.0.1:11500 stats -watch 5s
+//	kvstorectl -admin 127.0.0.1:11500 budget -local 10G
+//	kvstorectl -admin 127.0.0.1:11500 events
+//
+// report reads the stats history the store records and, like profiles,
+// is safe to run while the runner is up:
+//
+//	kvstorectl report -since 7d
+//
+// replay benchmarks a store configuration on a trace the runner recorded
+// with OLLAMA_KV_TIER_TRACE, writing the replayed blocks to the store
+// the global flags name, so give it scratch directories:
+//
+//	kvstorectl -local /tmp/replay -local-budget 2G replay -trace kv.trace -eviction lfu
+//
+// simulate needs no store: it runs a synthetic workload, or a trace,
+// against the budgets it is given in a temporary directory:
+//
+//	kvstorectl simulate -local-budget 50G -remote-budget 1T -conversations 500 -resume 0.3
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// readOnly are the commands that only read the store, and so run while
+// a runner has it open, on the index it last saved.
+var readOnly = map[string]bool{"stats": true, "ls": true, "tree": true, "du": true, "heatmap": true}
+
+// unlimited is the budget used when none is given on the command line, so
+// maintenance commands never trigger evictions of their own.
+const unlimited = 1 << 62
+
+func main() {
+	global := flag.NewFlagSet("kvstorectl", flag.ExitOnError)
+	local := global.String("local", "/tmp/ollama-kv-cache", "local tier directory")
+	remote := global.String("remote", "", "remote tier directory")
+	stripes := global.String("stripes", "", "local stripe directories as path[:budgetGB], comma-separated, if the local tier is striped")
+	localBudget := global.String("local-budget", "", "local tier budget (e.g. 20G); default unlimited")
+	remoteBudget := global.String("remote-budget", "", "remote tier budget (e.g. 5T); default unlimited")
+	compress := global.Bool("compress", false, "enable zstd for blocks written by this command")
+	sharding := global.String("sharding", "", "move the store's blocks to this shard scheme (seq, hash or hash2) when opening it")
+	admin := global.String("admin", "", "query a running store's admin API at this address instead of opening the directory")
+	global.Usage = usage(global)
+	global.Parse(os.Args[1:])
+
+	if global.NArg() == 0 {
+		global.Usage()
+		os.Exit(2)
+	}
+
+	cmd, args := global.Arg(0), global.Args()[1:]
+	switch cmd {
+	case "profiles":
+		os.Exit(cmdProfiles(args))
+	case "report":
+		os.Exit(cmdReport(*local, args))
+	case "simulate":
+		os.Exit(cmdSimulate(args))
+	}
+	if *admin != "" {
+		os.Exit(runLive(*admin, cmd, args))
+	}
+
+	lb, err := parseSize(*localBudget)
+	if err != nil {
+		fatalf("-local-budget: %v", err)
+	}
+	rb, err := parseSize(*remoteBudget)
+	if err != nil {
+		fatalf("-remote-budget: %v", err)
+	}
+	ls, err := diskstore.ParseStripes(*stripes)
+	if err != nil {
+		fatalf("-stripes: %v", err)
+	}
+
+	store, err := diskstore.New(diskstore.Config{
+		LocalPath:    *local,
+		RemotePath:   *remote,
+		LocalBudget:  lb,
+		RemoteBudget: rb,
+		Compress:     *compress,
+		LocalStripes: ls,
+		ReadOnly:     readOnly[cmd],
+		Sharding:     *sharding,
+
+		UpgradeFormat: cmd == "migrate",
+	})
+	if errors.Is(err, diskstore.ErrLocked) {
+		fatalf("%v\nstop the runner first, or use -admin against it", err)
+	}
+	if err != nil {
+		fatalf("%v", err)
+	}
+
+	code := run(store, cmd, args)
+	if err := store.Close(); err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		code = 1
+	}
+	os.Exit(code)
+}
+
+func run(store *diskstore.Store, cmd string, args []string) int {
+	switch cmd {
+	case "stats":
+		return cmdStats(store, args)
+	case "ls":
+		return cmdLs(store, args)
+	case "tree":
+		return cmdTree(store, args)
+	case "du":
+		return cmdDu(store, args)
+	case "heatmap":
+		return cmdHeatmap(store, args)
+	case "rm-seq":
+		return cmdRmSeq(store, args)
+	case "gc":
+		return cmdGC(store, args)
+	case "verify":
+		return cmdVerify(store, args)
+	case "compact":
+		return cmdCompact(store, args)
+	case "migrate":
+		return cmdMigrate(store, args)
+	case "export":
+		return cmdExport(store, args)
+	case "import":
+		return cmdImport(store, args)
+	case "sessions":
+		return cmdSessions(store, args)
+	case "replay":
+		return cmdReplay(store, args)
+	case "bench":
+		return cmdBench(store, args)
+	default:
+		fmt.Fprintf(os.Stderr, "kvstorectl: unknown command %q\n", cmd)
+		return 2
+	}
+}
+
+func cmdStats(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	watch := fs.Duration("watch", 0, "print rates every interval (needs -admin)")
+	compression := fs.Bool("compression", false, "break compression down by dtype and layer")
+	fs.Parse(args)
+	if *watch > 0 {
+		fmt.Fprintln(os.Stderr, "kvstorectl: stats -watch needs -admin: an offline store has no traffic")
+		return 2
+	}
+
+	return printStats(store.Stats(), store.Fingerprint(), *asJSON, *compression)
+}
+
+func printStats(st diskstore.Stats, fp *diskstore.Fingerprint, asJSON, compression bool) int {
+	if asJSON {
+		return printJSON(st)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "tier\tblocks\tlogical\tused\tbudget\n")
+	fmt.Fprintf(w, "local\t%d\t%s\t%s\t%s\n", st.LocalBlocks, formatSize(st.LocalLogical), formatSize(st.LocalUsed), formatSize(st.LocalBudget))
+	fmt.Fprintf(w, "remote\t%d\t%s\t%s\t%s\n", st.RemoteBlocks, formatSize(st.RemoteLogical), formatSize(st.RemoteUsed), formatSize(st.RemoteBudget))
+	w.Flush()
+	if len(st.Namespaces) > 0 {
+		fmt.Println()
+		w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(w, "namespace\tprofile\tlocal blocks\tlocal used\tremote blocks\tremote used\n")
+		names := make([]string, 0, len(st.Namespaces))
+		for name := range st.Namespaces {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			n := st.Namespaces[name]
+			if name == "" {
+				name = "(default)"
+			}
+			profile := n.Profile
+			if profile == "" {
+				profile = "-"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%d\t%s\t%d\t%s\n", name, profile, n.LocalBlocks, formatSize(n.LocalUsed),
+				n.RemoteBlocks, formatSize(n.RemoteUsed))
+		}
+		w.Flush()
+	}
+	if fp != nil {
+		fmt.Printf("model: %s\n", fp)
+	}
+	if c := st.Compression; c.Blocks > 0 {
+		fmt.Printf("compression: %s logical in %s stored (%.2fx), encode %s, decode %s since open\n",
+			formatSize(c.Logical), formatSize(c.Stored), c.Ratio,
+			st.EncodeTime.Round(time.Millisecond), st.DecodeTime.Round(time.Millisecond))
+	}
+	if compression && st.Compression.Blocks > 0 {
+		printCompression(st)
+	}
+	if st.BundlePhysical > 0 {
+		fmt.Printf("bundles: %s on disk, %s released from them\n", formatSize(st.BundlePhysical), formatSize(st.BundleDeadBytes))
+	} else if st.BundleDeadBytes > 0 {
+		fmt.Printf("dead bundle space: %s\n", formatSize(st.BundleDeadBytes))
+	}
+	if st.DiskFullDrops > 0 {
+		fmt.Printf("dropped on a full disk: %d blocks\n", st.DiskFullDrops)
+	}
+	if st.Queued > 0 || st.Coalesced > 0 {
+		fmt.Printf("write queue: %d queued, %d puts coalesced\n", st.Queued, st.Coalesced)
+	}
+	if st.ReadRepairs > 0 || st.CorruptReads > 0 {
+		fmt.Printf("read repairs: %d, corrupt reads: %d\n", st.ReadRepairs, st.CorruptReads)
+	}
+	return 0
+}
+
+// printCompression prints the compression of the blocks per dtype and
+// per layer.
+func printCompression(st diskstore.Stats) {
+	row := func(w *tabwriter.Writer, name string, c diskstore.CompressionStats) {
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%.2fx\t%s\t%d\n", name, c.Blocks, formatSize(c.Logical), formatSize(c.Stored),
+			c.Ratio, c.EncodeTime.Round(time.Microsecond), c.Incompressible)
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "dtype\tblocks\tlogical\tstored\tratio\tencode\traw\n")
+	dtypes := make([]string, 0, len(st.CompressionByDType))
+	for d := range st.CompressionByDType {
+		dtypes = append(dtypes, d)
+	}
+	sort.Strings(dtypes)
+	for _, d := range dtypes {
+		row(w, d, st.CompressionByDType[d])
+	}
+	w.Flush()
+
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "layer\tblocks\tlogical\tstored\tratio\tencode\traw\n")
+	layers := make([]int, 0, len(st.CompressionByLayer))
+	for l := range st.CompressionByLayer {
+		layers = append(layers, l)
+	}
+	sort.Ints(layers)
+	for _, l := range layers {
+		row(w, strconv.Itoa(l), st.CompressionByLayer[l])
+	}
+	w.Flush()
+}
+
+func cmdProfiles(args []string) int {
+	fs := flag.NewFlagSet("profiles", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	profiles := diskstore.Profiles()
+	if *asJSON {
+		return printJSON(profiles)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "profile\tpriority\teviction\tcompress\tttl\tmin positions\n")
+	for _, p := range profiles {
+		ttl := "-"
+		if p.TTL > 0 {
+			ttl = p.TTL.String()
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%t\t%s\t%d\n", p.Name, p.Priority, p.Eviction, p.Compress, ttl, p.MinPositions)
+	}
+	w.Flush()
+	return 0
+}
+
+func cmdLs(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	seq := fs.Int("seq", -1, "only this sequence")
+	layer := fs.Int("layer", -1, "only this layer")
+	tier := fs.String("tier", "", "only this tier (local or remote)")
+	ns := fs.String("ns", "", "only this namespace (\"-\" for the default one)")
+	older := fs.Duration("older", 0, "only blocks stored at least this long ago")
+	idle := fs.Duration("idle", 0, "only blocks not read for at least this long")
+	sortBy := fs.String("sort", "", "order by \"ratio\" (least compressible first) or \"encode\" (slowest first)")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	filter := diskstore.BlockFilter{Tier: *tier, MinAge: *older, MinIdle: *idle}
+	if *seq >= 0 {
+		filter.Seqs = []int{*seq}
+	}
+	if *layer >= 0 {
+		filter.Layers = []int{*layer}
+	}
+	switch *ns {
+	case "":
+	case "-":
+		filter.Namespaces = []string{""}
+	default:
+		filter.Namespaces = []string{*ns}
+	}
+	blocks := slices.Collect(store.Iter(filter))
+	switch *sortBy {
+	case "":
+	case "ratio":
+		sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].CompressionRatio() < blocks[j].CompressionRatio() })
+	case "encode":
+		sort.SliceStable(blocks, func(i, j int) bool { return blocks[i].EncodeTime > blocks[j].EncodeTime })
+	default:
+		fmt.Fprintf(os.Stderr, "kvstorectl: ls: unknown -sort %q\n", *sortBy)
+		return 2
+	}
+
+	if *asJSON {
+		return printJSON(blocks)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "key\ttier\tdtype\tsize\tstored\tcodec\tencode\thits\taccessed\n")
+	for _, b := range blocks {
+		tier := b.Tier
+		if b.Bundle != nil {
+			tier += " (bundle)"
+		}
+		if b.Pinned {
+			tier += " (pinned)"
+		}
+		stored, codec, encode := "-", "-", "-"
+		if b.StoredBytes > 0 {
+			stored = formatSize(int64(b.StoredBytes))
+			encode = b.EncodeTime.Round(time.Microsecond).String()
+		}
+		if b.Codec != "" {
+			codec = fmt.Sprintf("%s %.1fx", b.Codec, b.CompressionRatio())
+		} else if b.Incompressible {
+			codec = "raw (incompressible)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", b.Key, tier, b.DTypeStr,
+			formatSize(int64(b.SizeBytes)), stored, codec, encode, b.Hits, b.AccessedAt.Format(time.DateTime))
+	}
+	w.Flush()
+	return 0
+}
+
+func cmdRmSeq(store *diskstore.Store, args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl rm-seq <seq>")
+		return 2
+	}
+	seq, err := strconv.Atoi(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: invalid seq %q\n", args[0])
+		return 2
+	}
+	fmt.Printf("removed %d blocks\n", store.RemoveSeq(seq))
+	return 0
+}
+
+func cmdGC(store *diskstore.Store, args []string) int {
+	res, err := store.GC()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: gc: %v\n", err)
+		return 1
+	}
+	fmt.Printf("dropped %d missing blocks, deleted %d orphan files (%s)\n",
+		res.MissingBlocks, res.OrphanFiles, formatSize(res.FreedBytes))
+	if res.FromReplicas > 0 {
+		fmt.Printf("moved %d blocks with a lost local file to their remote replica\n", res.FromReplicas)
+	}
+	return 0
+}
+
+func cmdVerify(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the report as JSON")
+	repair := fs.Bool("repair", false, "rewrite missing and corrupt blocks from an intact copy on the other tier or a replica")
+	decode := fs.Bool("decode", false, "also decode every block and check its logical size")
+	fs.Parse(args)
+
+	rep := store.Scrub(diskstore.ScrubOptions{Repair: *repair, Decode: *decode})
+	if *asJSON {
+		printJSON(rep)
+	} else {
+		for _, p := range rep.Problems {
+			state := ""
+			if p.Repaired {
+				state = " (repaired)"
+			}
+			fmt.Printf("%-10s  %-6s  %s%s", p.Kind, p.Tier, p.Key, state)
+			if p.Detail != "" {
+				fmt.Printf(": %s", p.Detail)
+			}
+			fmt.Println()
+		}
+		fmt.Printf("checked %d blocks (%s) in %s: %d problems, %d repaired\n",
+			rep.Checked, formatSize(rep.Bytes), rep.Duration.Round(time.Millisecond), len(rep.Problems), rep.Repaired)
+	}
+	if rep.Unrepaired() > 0 {
+		return 1
+	}
+	return 0
+}
+
+func cmdCompact(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("compact", flag.ExitOnError)
+	age := fs.Duration("age", 24*time.Hour, "archive remote blocks not accessed for this long")
+	dead := fs.Float64("dead", 0.5, "also rewrite bundles with at least this share of dead space (0 disables)")
+	fs.Parse(args)
+
+	res, err := store.CompactRemote(*age)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: compact: %v\n", err)
+		return 1
+	}
+	fmt.Printf("archived %d blocks into %d bundles (%s)\n", res.Blocks, res.Bundles, formatSize(res.Bytes))
+
+	if *dead > 0 {
+		res, err := store.CompactBundles(*dead)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: compact: %v\n", err)
+			return 1
+		}
+		fmt.Printf("rewrote %d blocks into %d bundles (%s)\n", res.Blocks, res.Bundles, formatSize(res.Bytes))
+	}
+	return 0
+}
+
+func cmdMigrate(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	seq := fs.Int("seq", -1, "only this sequence (default all)")
+	to := fs.String("to", "", "destination tier: local or remote")
+	format := fs.Bool("format", false, "only upgrade the store's on-disk format")
+	fs.Parse(args)
+
+	if from, cur := store.FormatUpgrade(); from != cur {
+		fmt.Printf("upgraded store format %d to %d\n", from, cur)
+	} else if *format {
+		fmt.Printf("store format %d is current\n", cur)
+	}
+	if from, cur := store.Sharding(); from != cur {
+		fmt.Printf("moved blocks from %s to %s shard directories\n", from, cur)
+	}
+	if *format {
+		return 0
+	}
+
+	n, err := store.Migrate(*seq, *to)
+	fmt.Printf("moved %d blocks to %s\n", n, *to)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: migrate: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func cmdExport(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	seq := fs.Int("seq", -1, "sequence to export")
+	out := fs.String("o", "", "output file (default stdout); with -format lmcache, the output directory")
+	format := fs.String("format", "archive", "archive, llama for a llama.cpp session file, or lmcache for LMCache chunk files")
+	transposeV := fs.Bool("transpose-v", false, "with -format llama: write values transposed, for llama.cpp without flash attention")
+	model := fs.String("model", "", "with -format lmcache: model name vLLM serves (default: the fingerprint's digest)")
+	chunkSize := fs.Int("chunk-size", 256, "with -format lmcache: positions per chunk")
+	worldSize := fs.Int("world-size", 1, "with -format lmcache: vLLM tensor-parallel size")
+	workerID := fs.Int("worker-id", 0, "with -format lmcache: vLLM tensor-parallel rank")
+	fs.Parse(args)
+	switch {
+	case *seq < 0, *format != "archive" && *format != "llama" && *format != "lmcache",
+		*format == "lmcache" && (*out == "" || *chunkSize <= 0):
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl export -seq N [-format archive|llama] [-transpose-v] [-o file]")
+		fmt.Fprintln(os.Stderr, "       kvstorectl export -seq N -format lmcache [-model name] [-chunk-size N] [-world-size N] [-worker-id N] -o dir")
+		return 2
+	}
+
+	if *format == "lmcache" {
+		n, err := store.ExportLMCache(*seq, *out, diskstore.LMCacheOptions{
+			Model:     *model,
+			ChunkSize: int32(*chunkSize),
+			WorldSize: *worldSize,
+			WorkerID:  *workerID,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: export: %v\n", err)
+			return 1
+		}
+		fmt.Printf("exported %d chunks to %s\n", n, *out)
+		return 0
+	}
+
+	w := os.Stdout
+	if *out != "" {
+		f, err := os.Create(*out)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: export: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+	if *format == "llama" {
+		n, err := store.ExportLlamaSession(*seq, w, diskstore.LlamaSessionOptions{TransposeV: *transposeV})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: export: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "exported %d positions\n", n)
+		return 0
+	}
+	n, err := store.ExportSeq(*seq, w)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: export: %v\n", err)
+		return 1
+	}
+	fmt.Fprintf(os.Stderr, "exported %d blocks\n", n)
+	return 0
+}
+
+func cmdImport(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	seq := fs.Int("seq", -1, "store under this sequence (default: the exported one)")
+	in := fs.String("i", "", "input file (default stdin)")
+	format := fs.String("format", "archive", "archive, or llama for a llama.cpp session file")
+	blockSize := fs.Int("block-size", 256, "with -format llama: positions per stored block")
+	fs.Parse(args)
+	if *format != "archive" && *format != "llama" || *format == "llama" && (*seq < 0 || *blockSize <= 0) {
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl import [-seq N] [-format archive|llama] [-block-size N] [-i file]")
+		fmt.Fprintln(os.Stderr, "       (-format llama needs -seq)")
+		return 2
+	}
+
+	r := os.Stdin
+	if *in != "" {
+		f, err := os.Open(*in)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: import: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		r = f
+	}
+	if *format == "llama" {
+		n, err := store.ImportLlamaSession(r, *seq, diskstore.LlamaSessionOptions{BlockSize: int32(*blockSize)})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: import: %v\n", err)
+			return 1
+		}
+		fmt.Printf("imported %d positions\n", n)
+		return 0
+	}
+	n, err := store.ImportSeqAs(r, *seq)
+	fmt.Printf("imported %d blocks\n", n)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: import: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+func cmdSessions(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("sessions", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print JSON")
+	rm := fs.String("rm", "", "remove this session and its blocks")
+	fs.Parse(args)
+
+	if *rm != "" {
+		n, err := store.RemoveSession(*rm)
+		fmt.Printf("removed %d blocks\n", n)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: sessions: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	sessions := store.Sessions()
+	if *asJSON {
+		return printJSON(sessions)
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "session\tseq\tblocks\n")
+	for _, sess := range sessions {
+		seq := strconv.Itoa(sess.Seq)
+		if sess.Parked {
+			seq = "parked"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\n", sess.ID, seq, len(store.Blocks(sess.Seq)))
+	}
+	w.Flush()
+	return 0
+}
+
+func usage(fs *flag.FlagSet) func() {
+	return func() {
+		fmt.Fprintln(os.Stderr, "Usage: kvstorectl [flags] <stats|ls|tree|du|heatmap|rm-seq|gc|verify|compact|migrate|export|import|sessions|profiles|report|replay|bench|simulate> [args]")
+		fmt.Fprintln(os.Stderr)
+		fs.PrintDefaults()
+	}
+}
+
+func printJSON(v any) int {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseSize parses byte sizes like "512M", "20G" or "5T". An empty string
+// means unlimited.
+func parseSize(s string) (int64, error) {
+	if s == "" {
+		return unlimited, nil
+	}
+	mult := int64(1)
+	switch suffix := strings.ToUpper(s[len(s)-1:]); suffix {
+	case "K":
+		mult = 1 << 10
+	case "M":
+		mult = 1 << 20
+	case "G":
+		mult = 1 << 30
+	case "T":
+		mult = 1 << 40
+	}
+	if mult > 1 {
+		s = s[:len(s)-1]
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * mult, nil
+}
+
+func formatSize(n int64) string {
+	if n >= unlimited {
+		return "unlimited"
+	}
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f%ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func fatalf(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, "kvstorectl: "+format+"\n", args...)
+	os.Exit(1)
+}
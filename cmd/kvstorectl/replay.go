@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// The replay command is a benchmark: it re-runs a trace recorded with
+// OLLAMA_KV_TIER_TRACE against the store the global flags describe, so
+// budgets, a remote tier or an eviction policy can be tried on a real
+// workload before the runner is given them. The replayed blocks are
+// written to that store; point -local and -remote at scratch
+// directories.
+
+func cmdReplay(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	trace := fs.String("trace", "", "trace file to replay")
+	speed := fs.Float64("speed", 0, "replay at this multiple of the recorded pace (0: as fast as possible)")
+	eviction := fs.String("eviction", "", "eviction policy, lru or lfu (default the store's)")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+	if *trace == "" {
+		fmt.Fprintln(os.Stderr, "usage: kvstorectl replay -trace file [-speed x] [-eviction lru|lfu] [-json]")
+		return 2
+	}
+
+	if *eviction != "" {
+		settings := store.Settings()
+		settings.Eviction = *eviction
+		if _, err := store.Reload(settings); err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: replay: %v\n", err)
+			return 2
+		}
+	}
+	if st := store.Stats(); st.LocalBlocks+st.RemoteBlocks > 0 {
+		fmt.Fprintf(os.Stderr, "kvstorectl: replay: store already holds %d blocks; results include them\n",
+			st.LocalBlocks+st.RemoteBlocks)
+	}
+
+	f, err := os.Open(*trace)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: replay: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	tr, err := diskstore.NewTraceReader(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: replay: %v\n", err)
+		return 1
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	res, err := diskstore.Replay(ctx, store, tr, diskstore.ReplayOptions{Speed: *speed})
+	code := 0
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: replay: %v\n", err)
+		code = 1
+	}
+	if *asJSON {
+		if c := printJSON(res); c != 0 {
+			return c
+		}
+		return code
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "operations\t%d in %s (recorded over %s)\n", res.Ops,
+		res.Duration.Round(time.Millisecond), res.Recorded.Round(time.Millisecond))
+	fmt.Fprintf(w, "puts\t%d\n", res.Puts)
+	fmt.Fprintf(w, "removals\t%d\n", res.Removes)
+	if res.Errors > 0 {
+		fmt.Fprintf(w, "errors\t%d\n", res.Errors)
+	}
+	fmt.Fprintf(w, "reads\t%d\n", res.Gets)
+	if res.Gets > 0 {
+		fmt.Fprintf(w, "hits\t%d (%.1f%%): %d local, %d remote\n", res.Hits, 100*res.HitRatio(), res.LocalHits, res.RemoteHits)
+		fmt.Fprintf(w, "recorded hits\t%d (%.1f%%)\n", res.RecordedHits, 100*float64(res.RecordedHits)/float64(res.Gets))
+		fmt.Fprintf(w, "read latency\tp50 %s, p99 %s\n", res.GetP50, res.GetP99)
+	}
+	fmt.Fprintf(w, "evictions\t%d\n", res.Evictions)
+	w.Flush()
+	return code
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// hoursPerMonth converts GB-hours to GB-months for disk pricing.
+const hoursPerMonth = 730
+
+// report is the JSON form of the report command.
+type report struct {
+	Since        time.Time                           `json:"since"`
+	Until        time.Time                           `json:"until"`
+	SampledHours float64                             `json:"sampled_hours"`
+	Namespaces   map[string]diskstore.HistorySummary `json:"namespaces"`
+
+	// Assumptions behind the estimates.
+	PrefillTokensPerSecond float64 `json:"prefill_tokens_per_second"`
+	LocalCostPerGBMonth    float64 `json:"local_cost_per_gb_month"`
+	RemoteCostPerGBMonth   float64 `json:"remote_cost_per_gb_month"`
+}
+
+func cmdReport(localPath string, args []string) int {
+	fs := flag.NewFlagSet("report", flag.ExitOnError)
+	sinceFlag := fs.String("since", "7d", "report on this much history (e.g. 7d, 36h)")
+	tps := fs.Float64("prefill-tps", 1000, "prefill throughput in tokens per GPU-second, for GPU time saved")
+	localCost := fs.Float64("local-cost", 0.08, "local tier cost in $ per GB-month")
+	remoteCost := fs.Float64("remote-cost", 0.02, "remote tier cost in $ per GB-month")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	span, err := parseSince(*sinceFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: report: -since: %v\n", err)
+		return 2
+	}
+	if *tps <= 0 {
+		fmt.Fprintln(os.Stderr, "kvstorectl: report: -prefill-tps must be positive")
+		return 2
+	}
+
+	now := time.Now()
+	samples, err := diskstore.ReadStatsHistory(localPath, now.Add(-span))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: report: %v\n", err)
+		return 1
+	}
+	r := report{
+		Since:                  now.Add(-span),
+		Until:                  now,
+		Namespaces:             diskstore.SummarizeHistory(samples),
+		PrefillTokensPerSecond: *tps,
+		LocalCostPerGBMonth:    *localCost,
+		RemoteCostPerGBMonth:   *remoteCost,
+	}
+	for _, s := range samples {
+		r.SampledHours += s.End.Sub(s.Start).Hours()
+	}
+	if *asJSON {
+		return printJSON(r)
+	}
+	if len(samples) == 0 {
+		fmt.Printf("no stats history since %s in %s\n", r.Since.Format(time.DateTime), localPath)
+		return 0
+	}
+
+	fmt.Printf("KV cache report %s to %s (%.1fh sampled)\n\n",
+		r.Since.Format(time.DateTime), r.Until.Format(time.DateTime), r.SampledHours)
+
+	names := make([]string, 0, len(r.Namespaces))
+	for name := range r.Namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var total diskstore.HistorySummary
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "namespace\thits\tmisses\thit ratio\ttokens saved\tGPU-seconds saved\tavg disk\tdisk cost\t\n")
+	row := func(name string, h diskstore.HistorySummary) {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%.1f%%\t%d\t%.0f\t%s\t$%.2f\t\n", name, h.Hits, h.Misses, 100*h.HitRatio(),
+			h.RestoredPositions, float64(h.RestoredPositions)/(*tps),
+			formatSize(int64((h.LocalByteHours+h.RemoteByteHours)/max(r.SampledHours, 1e-9))),
+			diskCost(h, *localCost, *remoteCost))
+	}
+	for _, name := range names {
+		h := r.Namespaces[name]
+		total.Hits += h.Hits
+		total.Misses += h.Misses
+		total.RestoredPositions += h.RestoredPositions
+		total.LocalByteHours += h.LocalByteHours
+		total.RemoteByteHours += h.RemoteByteHours
+		if name == "" {
+			name = "(default)"
+		}
+		row(name, h)
+	}
+	if len(names) > 1 {
+		row("total", total)
+	}
+	w.Flush()
+
+	fmt.Printf("\nGPU-seconds assume %.0f prefill tokens/s; disk cost assumes $%.2f (local) and $%.2f (remote) per GB-month.\n",
+		*tps, *localCost, *remoteCost)
+	return 0
+}
+
+// diskCost prices the usage in h.
+func diskCost(h diskstore.HistorySummary, local, remote float64) float64 {
+	const gb = 1 << 30
+	return (h.LocalByteHours*local + h.RemoteByteHours*remote) / gb / hoursPerMonth
+}
+
+// parseSince parses a duration, also accepting whole days such as "7d".
+func parseSince(s string) (time.Duration, error) {
+	var d time.Duration
+	var err error
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		var n int
+		n, err = strconv.Atoi(days)
+		d = time.Duration(n) * 24 * time.Hour
+	} else {
+		d, err = time.ParseDuration(s)
+	}
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid duration %q", s)
+	}
+	return d, nil
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"text/tabwriter"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// The simulate command runs diskstore.Simulate on a synthetic workload
+// described by its flags, or on a recorded trace with -trace, against
+// the budgets given, and prints the projected hits, the prefill time
+// they save and the disk usage over the workload's time. It needs no
+// store: the simulated one lives in a temporary directory.
+
+// simulation is the JSON form of the simulate command.
+type simulation struct {
+	diskstore.ReplayResult
+	PrefillTokensPerSecond float64       `json:"prefill_tokens_per_second"`
+	PrefillSaved           time.Duration `json:"prefill_saved_ns"`
+	LocalBudget            int64         `json:"local_budget"`
+	RemoteBudget           int64         `json:"remote_budget,omitempty"`
+}
+
+func cmdSimulate(args []string) int {
+	fs := flag.NewFlagSet("simulate", flag.ExitOnError)
+	localBudget := fs.String("local-budget", "20G", "local tier budget to simulate")
+	remoteBudget := fs.String("remote-budget", "", "remote tier budget to simulate (default no remote tier)")
+	eviction := fs.String("eviction", diskstore.EvictLRU, "eviction policy, lru or lfu")
+	trace := fs.String("trace", "", "replay this recorded trace instead of a synthetic workload")
+	var w diskstore.Workload
+	fs.IntVar(&w.Conversations, "conversations", 100, "conversations in the workload")
+	fs.IntVar(&w.Concurrency, "concurrency", 4, "conversations running at once")
+	fs.IntVar(&w.Turns, "turns", 5, "average turns per conversation")
+	fs.IntVar(&w.TurnTokens, "turn-tokens", 500, "average tokens a turn adds, prompt and reply")
+	fs.IntVar(&w.ContextSize, "context", 8192, "context size; a conversation ends when it fills")
+	fs.Float64Var(&w.Resume, "resume", 0.5, "share of later turns that restore the conversation from disk")
+	fs.DurationVar(&w.Gap, "gap", 30*time.Second, "time between turns of a conversation")
+	fs.IntVar(&w.Layers, "layers", 32, "model layers")
+	fs.IntVar(&w.BlockSize, "block-size", 256, "positions per block")
+	fs.IntVar(&w.PositionBytes, "position-bytes", 2048, "bytes of one position of one layer's keys or values")
+	fs.Uint64Var(&w.Seed, "seed", 1, "workload seed")
+	tps := fs.Float64("prefill-tps", 1000, "prefill throughput in tokens per GPU-second, for GPU time saved")
+	sample := fs.Duration("sample", 10*time.Minute, "report usage every this much workload time")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	lb, err := parseSize(*localBudget)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: simulate: -local-budget: %v\n", err)
+		return 2
+	}
+	var rb int64
+	if *remoteBudget != "" {
+		if rb, err = parseSize(*remoteBudget); err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: simulate: -remote-budget: %v\n", err)
+			return 2
+		}
+	}
+	if *tps <= 0 {
+		fmt.Fprintln(os.Stderr, "kvstorectl: simulate: -prefill-tps must be positive")
+		return 2
+	}
+
+	var src diskstore.TraceSource = w.Ops()
+	if *trace != "" {
+		f, err := os.Open(*trace)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: simulate: %v\n", err)
+			return 1
+		}
+		defer f.Close()
+		if src, err = diskstore.NewTraceReader(f); err != nil {
+			fmt.Fprintf(os.Stderr, "kvstorectl: simulate: %v\n", err)
+			return 1
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cfg := diskstore.Config{LocalBudget: lb, RemoteBudget: rb, Eviction: *eviction}
+	res, err := diskstore.Simulate(ctx, cfg, src, diskstore.ReplayOptions{SampleEvery: *sample})
+	code := 0
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "kvstorectl: simulate: %v\n", err)
+		code = 1
+	}
+	sim := simulation{
+		ReplayResult:           res,
+		PrefillTokensPerSecond: *tps,
+		PrefillSaved:           res.PrefillSaved(*tps),
+		LocalBudget:            lb,
+		RemoteBudget:           rb,
+	}
+	if *asJSON {
+		if c := printJSON(sim); c != 0 {
+			return c
+		}
+		return code
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "workload\t%d operations over %s, simulated in %s\n", res.Ops,
+		res.Recorded.Round(time.Second), res.Duration.Round(time.Millisecond))
+	fmt.Fprintf(tw, "blocks stored\t%d\n", res.Puts)
+	fmt.Fprintf(tw, "blocks read\t%d\n", res.Gets)
+	if res.Gets > 0 {
+		fmt.Fprintf(tw, "hits\t%d (%.1f%%): %d local, %d remote\n", res.Hits, 100*res.HitRatio(), res.LocalHits, res.RemoteHits)
+	}
+	if res.ReadPositions > 0 {
+		fmt.Fprintf(tw, "positions restored\t%d of %d (%.1f%%)\n", res.HitPositions, res.ReadPositions,
+			100*float64(res.HitPositions)/float64(res.ReadPositions))
+	}
+	fmt.Fprintf(tw, "GPU time saved\t%s at %.0f tokens/s\n", sim.PrefillSaved.Round(time.Second), *tps)
+	fmt.Fprintf(tw, "evictions\t%d\n", res.Evictions)
+	tw.Flush()
+
+	if len(res.Usage) > 0 {
+		fmt.Println()
+		tw = tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "time\tblocks\tlocal (of %s)\tremote\n", formatSize(lb))
+		for _, u := range res.Usage {
+			over := ""
+			if u.LocalBytes > lb {
+				over = " over budget"
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%s%s\t%s\n", u.At.Round(time.Second), u.Blocks, formatSize(u.LocalBytes), over, formatSize(u.RemoteBytes))
+		}
+		tw.Flush()
+	}
+	return code
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// The tree command groups the index as namespace → sequence → layer
+// coverage → position ranges. Ranges stored for the same layers on the
+// same tier that adjoin are collapsed into one line.
+
+type treeNamespace struct {
+	Name      string    `json:"name"`
+	Bytes     int64     `json:"bytes"`
+	Sequences []treeSeq `json:"sequences"`
+}
+
+type treeSeq struct {
+	Seq      int            `json:"seq"`
+	Prefix   bool           `json:"prefix,omitempty"`  // prefix-addressed blocks (diskstore.PrefixSeq)
+	Encoder  bool           `json:"encoder,omitempty"` // encoder outputs (diskstore.EncoderSeq)
+	Bytes    int64          `json:"bytes"`
+	Coverage []treeCoverage `json:"coverage"`
+}
+
+// treeCoverage is a set of layers and halves and the ranges stored for
+// exactly that set.
+type treeCoverage struct {
+	Layers string      `json:"layers"`
+	Bytes  int64       `json:"bytes"`
+	Ranges []treeRange `json:"ranges"`
+}
+
+type treeRange struct {
+	Begin  int32  `json:"begin"`
+	End    int32  `json:"end"`
+	Tier   string `json:"tier"`
+	Blocks int    `json:"blocks"`
+	Bytes  int64  `json:"bytes"`
+}
+
+func cmdTree(store *diskstore.Store, args []string) int {
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	seq := fs.Int("seq", -3, "only this sequence (-1 for prefix-addressed blocks, -2 for encoder outputs)")
+	ns := fs.String("ns", "", "only this namespace (\"-\" for the default one)")
+	asJSON := fs.Bool("json", false, "print JSON")
+	fs.Parse(args)
+
+	var blocks []diskstore.BlockMeta
+	for _, b := range store.AllBlocks() {
+		if *seq != -3 && b.Key.Seq != *seq {
+			continue
+		}
+		switch {
+		case *ns == "-" && b.Key.Namespace != "":
+			continue
+		case *ns != "" && *ns != "-" && b.Key.Namespace != *ns:
+			continue
+		}
+		blocks = append(blocks, b)
+	}
+	tree := buildTree(blocks)
+
+	if *asJSON {
+		return printJSON(tree)
+	}
+	if len(tree) == 0 {
+		fmt.Println("no blocks")
+		return 0
+	}
+	for _, n := range tree {
+		name := n.Name
+		if name == "" {
+			name = "(default)"
+		}
+		fmt.Printf("%s  %d sequences  %s\n", name, len(n.Sequences), formatSize(n.Bytes))
+		for _, s := range n.Sequences {
+			label := fmt.Sprintf("seq %d", s.Seq)
+			switch {
+			case s.Prefix:
+				label = "prefixes"
+			case s.Encoder:
+				label = "encoder outputs"
+			}
+			fmt.Printf("  %s  %s\n", label, formatSize(s.Bytes))
+			for _, c := range s.Coverage {
+				fmt.Printf("    %s  %s\n", c.Layers, formatSize(c.Bytes))
+				for _, r := range c.Ranges {
+					fmt.Printf("      %d-%d  %s  %s  %d blocks\n", r.Begin, r.End, r.Tier, formatSize(r.Bytes), r.Blocks)
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// span is what is stored for one position range of a sequence.
+type span struct {
+	begin, end int32
+	keys, vals map[int]bool // layers
+	tokens     bool         // a token manifest (diskstore.ManifestLayer)
+	tiers      map[string]bool
+	blocks     int
+	bytes      int64
+}
+
+// buildTree groups blocks by namespace, sequence, position range and the
+// layers stored for it, sorted, with adjoining ranges collapsed.
+func buildTree(blocks []diskstore.BlockMeta) []treeNamespace {
+	type seqKey struct {
+		ns  string
+		seq int
+	}
+	type rangeKey struct{ begin, end int32 }
+	spans := make(map[seqKey]map[rangeKey]*span)
+	for _, b := range blocks {
+		sk := seqKey{b.Key.Namespace, b.Key.Seq}
+		if spans[sk] == nil {
+			spans[sk] = make(map[rangeKey]*span)
+		}
+		rk := rangeKey{b.Key.BeginPos, b.Key.EndPos}
+		sp := spans[sk][rk]
+		if sp == nil {
+			sp = &span{begin: rk.begin, end: rk.end, keys: map[int]bool{}, vals: map[int]bool{}, tiers: map[string]bool{}}
+			spans[sk][rk] = sp
+		}
+		switch {
+		case b.Key.Layer == diskstore.ManifestLayer:
+			sp.tokens = true
+		case b.Key.IsKey:
+			sp.keys[b.Key.Layer] = true
+		default:
+			sp.vals[b.Key.Layer] = true
+		}
+		sp.tiers[b.Tier] = true
+		sp.blocks++
+		sp.bytes += int64(b.SizeBytes)
+	}
+
+	byNS := make(map[string]*treeNamespace)
+	for sk, ranges := range spans {
+		sorted := make([]*span, 0, len(ranges))
+		for _, sp := range ranges {
+			sorted = append(sorted, sp)
+		}
+		sort.Slice(sorted, func(i, j int) bool {
+			if sorted[i].begin != sorted[j].begin {
+				return sorted[i].begin < sorted[j].begin
+			}
+			return sorted[i].end < sorted[j].end
+		})
+
+		ts := treeSeq{Seq: sk.seq, Prefix: sk.seq == diskstore.PrefixSeq, Encoder: sk.seq == diskstore.EncoderSeq}
+		coverage := make(map[string]int) // label to index in ts.Coverage
+		for _, sp := range sorted {
+			label := coverageLabel(sp.keys, sp.vals)
+			if sp.tokens {
+				label += " +tokens"
+			}
+			i, ok := coverage[label]
+			if !ok {
+				i = len(ts.Coverage)
+				coverage[label] = i
+				ts.Coverage = append(ts.Coverage, treeCoverage{Layers: label})
+			}
+			c := &ts.Coverage[i]
+			r := treeRange{Begin: sp.begin, End: sp.end, Tier: tierLabel(sp.tiers), Blocks: sp.blocks, Bytes: sp.bytes}
+			if last := len(c.Ranges) - 1; last >= 0 && c.Ranges[last].End == r.Begin && c.Ranges[last].Tier == r.Tier {
+				c.Ranges[last].End = r.End
+				c.Ranges[last].Blocks += r.Blocks
+				c.Ranges[last].Bytes += r.Bytes
+			} else {
+				c.Ranges = append(c.Ranges, r)
+			}
+			c.Bytes += sp.bytes
+			ts.Bytes += sp.bytes
+		}
+
+		n := byNS[sk.ns]
+		if n == nil {
+			n = &treeNamespace{Name: sk.ns}
+			byNS[sk.ns] = n
+		}
+		n.Sequences = append(n.Sequences, ts)
+		n.Bytes += ts.Bytes
+	}
+
+	out := make([]treeNamespace, 0, len(byNS))
+	for _, n := range byNS {
+		sort.Slice(n.Sequences, func(i, j int) bool { return n.Sequences[i].Seq < n.Sequences[j].Seq })
+		out = append(out, *n)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// coverageLabel describes the layers stored for keys and values, e.g.
+// "layers 0-31 K+V" or "K layers 0-31, V layers 0-15".
+func coverageLabel(keys, vals map[int]bool) string {
+	k, v := layerRanges(keys), layerRanges(vals)
+	switch {
+	case k == v:
+		return "layers " + k + " K+V"
+	case v == "":
+		return "layers " + k + " K"
+	case k == "":
+		return "layers " + v + " V"
+	}
+	return "K layers " + k + ", V layers " + v
+}
+
+// layerRanges formats a set of layers as sorted ranges, e.g. "0-3,8".
+func layerRanges(set map[int]bool) string {
+	layers := make([]int, 0, len(set))
+	for l := range set {
+		layers = append(layers, l)
+	}
+	sort.Ints(layers)
+
+	var parts []string
+	for i := 0; i < len(layers); {
+		j := i
+		for j+1 < len(layers) && layers[j+1] == layers[j]+1 {
+			j++
+		}
+		if i == j {
+			parts = append(parts, fmt.Sprint(layers[i]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", layers[i], layers[j]))
+		}
+		i = j + 1
+	}
+	return strings.Join(parts, ",")
+}
+
+// tierLabel names the tiers a range is on, "local+remote" when split.
+func tierLabel(tiers map[string]bool) string {
+	names := make([]string, 0, len(tiers))
+	for t := range tiers {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "+")
+}
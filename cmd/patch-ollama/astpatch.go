@@ -0,0 +1,459 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// Structural patching: git apply wants every context line of a hunk where
+// it was, so an upstream edit next to a hook, or a reindent, breaks it.
+// In the ast mode (-mode ast, or -mode auto when git apply --check fails)
+// the unified diff is still the source of the changes, but each group of
+// added and removed lines is placed by structure: the function the hunk
+// header names is found with go/ast, and the group goes at a statement
+// boundary inside it whose neighbouring lines match the group's nearest
+// context lines, whitespace ignored. Removed lines must match whole
+// statements. Added imports join the import declaration, and the file is
+// gofmt'd. New files are written whole. A group that fits nowhere, or in
+// more than one place equally well, fails the whole patch, naming it.
+
+// fileDiff is the part of a patch for one file.
+type fileDiff struct {
+	path    string
+	created bool
+	hunks   []hunk
+}
+
+// hunk is a hunk of a fileDiff.
+type hunk struct {
+	fn    string   // text after the header's second @@: the enclosing declaration
+	lines []string // with their ' ', '-' or '+' prefix
+}
+
+// change is a run of added and removed lines of a hunk with the context
+// nearest it.
+type change struct {
+	before, after  string // nearest non-blank context lines, "" if none
+	removed, added []string
+}
+
+// parsePatch splits a unified diff by file and hunk.
+func parsePatch(body []byte) ([]fileDiff, error) {
+	var diffs []fileDiff
+	var h *hunk
+	for n, line := range strings.Split(string(body), "\n") {
+		var cur *fileDiff
+		if len(diffs) > 0 {
+			cur = &diffs[len(diffs)-1]
+		}
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			diffs = append(diffs, fileDiff{})
+			h = nil
+		case cur == nil || strings.HasPrefix(line, `\`):
+		case h == nil && strings.HasPrefix(line, "--- "):
+			cur.created = line == "--- /dev/null"
+		case h == nil && strings.HasPrefix(line, "+++ b/"):
+			cur.path = strings.TrimPrefix(line, "+++ b/")
+		case strings.HasPrefix(line, "@@ "):
+			// The line numbers, if any, are not used: "@@ @@ func F(" will do.
+			end := strings.Index(line[2:], " @@")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: malformed hunk header", n+1)
+			}
+			cur.hunks = append(cur.hunks, hunk{fn: strings.TrimSpace(line[2+end+3:])})
+			h = &cur.hunks[len(cur.hunks)-1]
+		case h != nil && line == "":
+			h.lines = append(h.lines, " ")
+		case h != nil && strings.ContainsAny(line[:1], " +-"):
+			h.lines = append(h.lines, line)
+		}
+	}
+	for _, d := range diffs {
+		if d.path == "" {
+			return nil, errors.New("a diff names no file")
+		}
+	}
+	return diffs, nil
+}
+
+// changes returns the runs of changed lines of h, in order.
+func (h hunk) changes() []change {
+	var out []change
+	context := func(from, step int) string {
+		for i := from; i >= 0 && i < len(h.lines); i += step {
+			if h.lines[i][0] != ' ' {
+				return ""
+			}
+			if s := strings.TrimSpace(h.lines[i][1:]); s != "" {
+				return h.lines[i][1:]
+			}
+		}
+		return ""
+	}
+	for i := 0; i < len(h.lines); {
+		if h.lines[i][0] == ' ' {
+			i++
+			continue
+		}
+		c := change{before: context(i-1, -1)}
+		for ; i < len(h.lines) && h.lines[i][0] != ' '; i++ {
+			if h.lines[i][0] == '+' {
+				c.added = append(c.added, h.lines[i][1:])
+			} else {
+				c.removed = append(c.removed, h.lines[i][1:])
+			}
+		}
+		c.after = context(i, 1)
+		out = append(out, c)
+	}
+	return out
+}
+
+// structural applies the patch file at patch to the checkout at dir
+// structurally, returning the files it creates or changes without
+// writing them.
+func structural(dir, patch string) (map[string][]byte, error) {
+	body, err := os.ReadFile(patch)
+	if err != nil {
+		return nil, err
+	}
+	diffs, err := parsePatch(body)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(patch), err)
+	}
+	return applyStructural(dir, diffs)
+}
+
+// applyStructural returns the files the patch creates or changes in the
+// checkout at dir, patched, without writing them.
+func applyStructural(dir string, diffs []fileDiff) (map[string][]byte, error) {
+	out := make(map[string][]byte)
+	for _, d := range diffs {
+		path := filepath.Join(dir, filepath.FromSlash(d.path))
+		if d.created {
+			if _, err := os.Stat(path); err == nil {
+				return nil, fmt.Errorf("%s exists already", d.path)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			var b strings.Builder
+			for _, h := range d.hunks {
+				for _, line := range h.lines {
+					if line[0] == '+' {
+						b.WriteString(line[1:] + "\n")
+					}
+				}
+			}
+			out[d.path] = []byte(b.String())
+			continue
+		}
+
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range d.hunks {
+			for _, c := range h.changes() {
+				if src, err = applyChange(src, h.fn, c); err != nil {
+					return nil, fmt.Errorf("%s: %w", d.path, err)
+				}
+			}
+		}
+		if src, err = format.Source(src); err != nil {
+			return nil, fmt.Errorf("%s: patched file does not parse: %w", d.path, err)
+		}
+		out[d.path] = src
+	}
+	return out, nil
+}
+
+// importSpec matches a line of an import declaration.
+var importSpec = regexp.MustCompile(`^\s*([A-Za-z_][A-Za-z0-9_]*\s+|\.\s+)?"[^"]+"\s*$`)
+
+// applyChange applies c, of a hunk whose header names fn, to src.
+func applyChange(src []byte, fn string, c change) ([]byte, error) {
+	fset := token.NewFileSet()
+	file, err := parser.ParseFile(fset, "", src, parser.ParseComments)
+	if err != nil {
+		return nil, err
+	}
+	if len(c.removed) == 0 && allMatch(c.added, importSpec) {
+		return addImports(fset, file, src, c.added)
+	}
+
+	// The statement lists to look in: those of the function the hunk
+	// names if the file still has it, or else of every function.
+	var scope []*ast.FuncDecl
+	name, recv := parseFuncHeader(fn)
+	for _, decl := range file.Decls {
+		if f, ok := decl.(*ast.FuncDecl); ok && f.Body != nil && f.Name.Name == name && recvName(f) == recv {
+			scope = append(scope, f)
+		}
+	}
+	where := "function " + name
+	if len(scope) == 0 {
+		where = "any function"
+		for _, decl := range file.Decls {
+			if f, ok := decl.(*ast.FuncDecl); ok && f.Body != nil {
+				scope = append(scope, f)
+			}
+		}
+	}
+
+	lines := bytes.Split(src, []byte("\n"))
+	lineOf := func(p token.Pos) int { return fset.Position(p).Line - 1 }
+	offset := func(line int) int { // of the start of line
+		off := 0
+		for _, l := range lines[:line] {
+			off += len(l) + 1
+		}
+		return off
+	}
+	// nonBlank returns the first non-blank line from line on, by step.
+	nonBlank := func(line, step int) string {
+		for ; line >= 0 && line < len(lines); line += step {
+			if s := strings.TrimSpace(string(lines[line])); s != "" {
+				return s
+			}
+		}
+		return ""
+	}
+	removed := squash(strings.Join(c.removed, "\n"))
+
+	// A site is where the change can go: lines [from, to) of src, at a
+	// statement boundary, scored by the context lines it matches; removed
+	// statements count as one match, as they must match to be a site.
+	type site struct{ from, to, score int }
+	var sites []site
+	// visit finds the sites in a statement list, whose block opens at
+	// open and closes at end.
+	visit := func(list []ast.Stmt, open, end token.Pos) {
+		for i := 0; i <= len(list); i++ {
+			// Statements sharing a line with what precedes them can't
+			// be reached by line.
+			if i < len(list) && (lineOf(list[i].Pos()) == lineOf(open) || i > 0 && lineOf(list[i].Pos()) == lineOf(list[i-1].End())) {
+				continue
+			}
+			// The change takes the place of list[i:j].
+			j := i
+			if removed != "" {
+				if i == len(list) {
+					continue
+				}
+				var text string
+				for j < len(list) && len(squash(text)) < len(removed) {
+					text = string(src[fset.Position(list[i].Pos()).Offset:fset.Position(list[j].End()).Offset])
+					j++
+				}
+				if squash(text) != removed {
+					continue
+				}
+			}
+			first := lineOf(end) // of what follows the change
+			if j < len(list) {
+				first = lineOf(list[j].Pos())
+			}
+			from := lineOf(end)
+			if i < len(list) {
+				from = lineOf(list[i].Pos())
+			}
+			score := 0
+			if removed != "" {
+				score++
+			}
+			if c.before != "" && squash(nonBlank(from-1, -1)) == squash(c.before) {
+				score++
+			}
+			if c.after != "" && squash(nonBlank(first, 1)) == squash(c.after) {
+				score++
+			}
+			if score > 0 {
+				sites = append(sites, site{offset(from), offset(first), score})
+			}
+		}
+	}
+	for _, f := range scope {
+		ast.Inspect(f.Body, func(n ast.Node) bool {
+			switch n := n.(type) {
+			case *ast.BlockStmt:
+				visit(n.List, n.Lbrace, n.Rbrace)
+			case *ast.CaseClause:
+				visit(n.Body, n.Colon, clauseEnd(n, f))
+			case *ast.CommClause:
+				visit(n.Body, n.Colon, clauseEnd(n, f))
+			}
+			return true
+		})
+	}
+
+	best, ties := site{score: -1}, 0
+	for _, s := range sites {
+		switch {
+		case s.score > best.score:
+			best, ties = s, 1
+		case s.score == best.score:
+			ties++
+		}
+	}
+	describe := strings.TrimSpace(firstOf(c.added, c.removed))
+	switch {
+	case ties == 0:
+		return nil, fmt.Errorf("no place in %s for the change starting %q (between %q and %q)", where, describe, strings.TrimSpace(c.before), strings.TrimSpace(c.after))
+	case ties > 1:
+		return nil, fmt.Errorf("%d places in %s fit the change starting %q equally well", ties, where, describe)
+	}
+	var text string
+	if len(c.added) > 0 {
+		text = strings.Join(c.added, "\n") + "\n"
+	}
+	if removed == "" {
+		best.to = best.from
+	}
+	return append(append(append([]byte(nil), src[:best.from]...), text...), src[best.to:]...), nil
+}
+
+// addImports adds the import specs to the file's first parenthesized
+// import declaration, skipping those it has: standard library packages
+// at its start, with the first group, and the rest at its end.
+func addImports(fset *token.FileSet, file *ast.File, src []byte, specs []string) ([]byte, error) {
+	for _, decl := range file.Decls {
+		gen, ok := decl.(*ast.GenDecl)
+		if !ok || gen.Tok != token.IMPORT || !gen.Lparen.IsValid() {
+			continue
+		}
+		have := make(map[string]bool)
+		for _, s := range gen.Specs {
+			have[s.(*ast.ImportSpec).Path.Value] = true
+		}
+		var std, other string
+		for _, s := range specs {
+			path := strings.TrimSpace(s[strings.Index(s, `"`):])
+			if have[path] {
+				continue
+			}
+			if first, _, _ := strings.Cut(path, "/"); strings.Contains(first, ".") || len(gen.Specs) == 0 {
+				other += "\t" + strings.TrimSpace(s) + "\n"
+			} else {
+				std += "\t" + strings.TrimSpace(s) + "\n"
+			}
+		}
+		out := append([]byte(nil), src[:fset.Position(gen.Rparen).Offset]...)
+		out = append(out, other...)
+		out = append(out, src[fset.Position(gen.Rparen).Offset:]...)
+		if std != "" {
+			// Specs start a line; the first group starts with the first.
+			start := fset.Position(gen.Specs[0].Pos())
+			off := start.Offset - (start.Column - 1)
+			out = append(append(append([]byte(nil), out[:off]...), std...), out[off:]...)
+		}
+		return out, nil
+	}
+	return nil, errors.New("no parenthesized import declaration to add imports to")
+}
+
+// parseFuncHeader returns the function and receiver type a hunk header
+// names, e.g. "LoadCacheSlot" and "InputCache" for
+// "func (c *InputCache) LoadCacheSlot(prompt ...".
+func parseFuncHeader(fn string) (name, recv string) {
+	rest, ok := strings.CutPrefix(fn, "func ")
+	if !ok {
+		return "", ""
+	}
+	if strings.HasPrefix(rest, "(") {
+		end := strings.Index(rest, ")")
+		if end < 0 {
+			return "", ""
+		}
+		fields := strings.Fields(rest[1:end])
+		recv = strings.TrimLeft(fields[len(fields)-1], "*")
+		if i := strings.Index(recv, "["); i >= 0 {
+			recv = recv[:i]
+		}
+		rest = strings.TrimSpace(rest[end+1:])
+	}
+	name, _, _ = strings.Cut(rest, "(")
+	name, _, _ = strings.Cut(name, "[")
+	return strings.TrimSpace(name), recv
+}
+
+// recvName returns the receiver type name of f, "" for a function.
+func recvName(f *ast.FuncDecl) string {
+	if f.Recv == nil || len(f.Recv.List) == 0 {
+		return ""
+	}
+	t := f.Recv.List[0].Type
+	if star, ok := t.(*ast.StarExpr); ok {
+		t = star.X
+	}
+	switch t := t.(type) {
+	case *ast.Ident:
+		return t.Name
+	case *ast.IndexExpr:
+		if id, ok := t.X.(*ast.Ident); ok {
+			return id.Name
+		}
+	case *ast.IndexListExpr:
+		if id, ok := t.X.(*ast.Ident); ok {
+			return id.Name
+		}
+	}
+	return ""
+}
+
+// clauseEnd returns where the statements of c, a case of a switch or
+// select in f, end: at the next case, or the closing brace.
+func clauseEnd(c ast.Stmt, f *ast.FuncDecl) token.Pos {
+	end := c.End()
+	ast.Inspect(f.Body, func(n ast.Node) bool {
+		if b, ok := n.(*ast.BlockStmt); ok {
+			for i, s := range b.List {
+				if s != c {
+					continue
+				}
+				if i+1 < len(b.List) {
+					end = b.List[i+1].Pos()
+				} else {
+					end = b.Rbrace
+				}
+				return false
+			}
+		}
+		return true
+	})
+	return end
+}
+
+// squash drops the whitespace of s, for comparing code however it is
+// laid out.
+func squash(s string) string {
+	return strings.Join(strings.Fields(s), "")
+}
+
+func allMatch(lines []string, re *regexp.Regexp) bool {
+	for _, l := range lines {
+		if strings.TrimSpace(l) != "" && !re.MatchString(l) {
+			return false
+		}
+	}
+	return len(lines) > 0
+}
+
+func firstOf(a, b []string) string {
+	for _, l := range append(a, b...) {
+		if strings.TrimSpace(l) != "" {
+			return l
+		}
+	}
+	return ""
+}
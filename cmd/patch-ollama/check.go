@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The dry run behind check: before anything in an Ollama checkout is
+// touched, it confirms that the checkout is Ollama at a version the
+// patch matrix has a patch for (see matrix.go), that the declarations the
+// patch hooks into are still where the patch expects them, and that git
+// would apply the patch cleanly, or, in the auto and ast modes, that it
+// applies structurally (see astpatch.go), or, for generate, that the
+// templates render and fit the checkout (see generate.go). Once the directory is known to be
+// Ollama every check runs, so one pass reports every problem, each with
+// what to do about it.
+
+// hook is a declaration of Ollama's that the patch changes or builds on.
+type hook struct {
+	file, decl, why string
+}
+
+var hooks = []hook{
+	{"kvcache/causal.go", "type Causal struct", "kvcache/tiered.go adapts it"},
+	{"kvcache/encoder.go", "type EncoderCache struct", "kvcache/tiered.go adapts it"},
+	{"runner/ollamarunner/cache.go", "func NewInputCache(", "the tiered cache is created there"},
+	{"runner/ollamarunner/cache.go", "func (c *InputCache) LoadCacheSlot(", "prefix restores hook in there"},
+	{"runner/ollamarunner/cache.go", "func (c *InputCache) ShiftCacheSlot(", "snapshots before a context shift hook in there"},
+	{"runner/ollamarunner/runner.go", "func (s *Server) inputs(", "stored image embeddings are looked up there"},
+}
+
+// check verifies that a patch applies to the Ollama checkout in dir,
+// printing a line per check, and returns the patch's path, the mode to
+// apply it in and the exit status. The patch is the one the matrix of the
+// repository at src has for the checkout's version, or version if set,
+// unless patch names one. mode is "git", "ast", or "auto" for git unless
+// git apply --check fails and the patch applies structurally; with
+// "generate" the templates stand in for the patch, and "" is returned
+// for it.
+func check(dir, src, version, patch, mode string) (string, string, int) {
+	failed := 0
+	fail := func(problem, fix string, args ...any) {
+		fmt.Printf("FAIL  %s\n      fix: %s\n", problem, fmt.Sprintf(fix, args...))
+		failed++
+	}
+	ok := func(format string, args ...any) {
+		fmt.Printf("ok    "+format+"\n", args...)
+	}
+
+	var rels []release
+	if patch == "" {
+		var err error
+		if rels, err = loadMatrix(src); err != nil {
+			fmt.Fprintf(os.Stderr, "patch-ollama: %v\n", err)
+			fmt.Fprintln(os.Stderr, "  run from the ollama-kv-cache-tiering checkout, or give it with -src")
+			return "", "", 2
+		}
+	} else if _, err := os.Stat(patch); err != nil {
+		fmt.Fprintf(os.Stderr, "patch-ollama: %v\n", err)
+		return "", "", 2
+	}
+
+	mod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil || !bytes.Contains(mod, []byte("module github.com/ollama/ollama\n")) {
+		fmt.Fprintf(os.Stderr, "patch-ollama: %s is not an Ollama checkout (no go.mod for github.com/ollama/ollama)\n", dir)
+		fmt.Fprintln(os.Stderr, "  clone it with: git clone https://github.com/ollama/ollama.git")
+		return "", "", 1
+	}
+	ok("%s is an Ollama checkout", dir)
+
+	// The release to suggest checking out when this one won't do.
+	suggest := "a supported release"
+	if t := latestTested(rels); t != "" {
+		suggest = t
+	}
+	switch {
+	case version == "" && mode == "generate":
+		version, err = detectVersion(dir)
+	case version == "":
+		version, err = detectRelease(dir)
+	}
+	switch {
+	case mode == "generate" && err != nil:
+		fail(fmt.Sprintf("cannot tell the Ollama version: %v", err),
+			"fetch the release tags (git -C %s fetch --tags), or give the version with -version", dir)
+		version = ""
+	case mode == "generate":
+		ok("Ollama %s; generating from %s", version, templatesDir)
+	case patch != "" && err != nil:
+		ok("Ollama of unknown version; patch %s as given", filepath.Base(patch))
+	case patch != "":
+		ok("Ollama %s; patch %s as given", version, filepath.Base(patch))
+	case err != nil:
+		fail(fmt.Sprintf("cannot tell the Ollama version: %v", err),
+			"fetch the release tags (git -C %s fetch --tags), or give the version with -version", dir)
+	default:
+		if r, err := selectRelease(rels, version); err != nil {
+			fail(fmt.Sprintf("Ollama %s: %v", version, err),
+				"git -C %s checkout %s, or try another patch with -patch", dir, suggest)
+		} else {
+			patch = r.path(src)
+			suggest = r.tested
+			ok("Ollama %s: %s, tested on %s", version, r.patch, r.tested)
+		}
+	}
+
+	for _, h := range hooks {
+		src, err := os.ReadFile(filepath.Join(dir, h.file))
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			fail(fmt.Sprintf("%s: missing (%s)", h.file, h.why),
+				"this Ollama version moved it; check out %s", suggest)
+		case err != nil:
+			fail(fmt.Sprintf("%s: %v", h.file, err),
+				"this Ollama version moved it; check out %s", suggest)
+		case !bytes.Contains(src, []byte(h.decl)):
+			fail(fmt.Sprintf("%s: %q not found (%s)", h.file, h.decl, h.why),
+				"this Ollama version renamed or moved it; check out %s", suggest)
+		default:
+			ok("%s: %s", h.file, strings.TrimSuffix(h.decl, "("))
+		}
+	}
+
+	// A patch that reverses cleanly is already applied, which is worth
+	// saying rather than reporting every hunk as failing.
+	if mode == "generate" {
+		patch = ""
+		if version != "" {
+			if _, err := render(dir, src, version); err != nil {
+				fail("the templates do not fit: "+err.Error(),
+					"check out %s in %s, or adapt %s to this version", suggest, dir, templatesDir)
+			} else {
+				ok("the templates render and fit Ollama %s", version)
+			}
+		}
+	} else if patch != "" {
+		patch = absPath(patch)
+		base := filepath.Base(patch)
+		var out string
+		if mode != "ast" {
+			out, err = git(dir, "apply", "--check", patch)
+		}
+		if _, rerr := git(dir, "apply", "--check", "--reverse", patch); rerr == nil {
+			fail("the patch is already applied",
+				"nothing to do; to reapply, revert it first: git -C %s apply --reverse %s", dir, patch)
+		} else if mode != "ast" && err == nil {
+			mode = "git"
+			ok("git apply --check %s", base)
+		} else if mode == "git" {
+			fail("git apply --check failed:\n"+indent(out),
+				"check out %s in %s, rebase the hunks above onto this version, or try -mode ast", suggest, dir)
+		} else if _, serr := structural(dir, patch); serr != nil {
+			if mode == "auto" {
+				fail("git apply --check failed:\n"+indent(out)+"\n      nor does it apply structurally: "+serr.Error(),
+					"check out %s in %s, or rebase the hunks above onto this version", suggest, dir)
+			} else {
+				fail("the patch does not apply structurally: "+serr.Error(),
+					"check out %s in %s, or rebase the patch onto this version", suggest, dir)
+			}
+		} else {
+			if mode == "auto" {
+				ok("git apply --check %s failed, but it applies structurally (ast mode)", base)
+			} else {
+				ok("%s applies structurally", base)
+			}
+			mode = "ast"
+		}
+	}
+
+	fmt.Println()
+	if failed > 0 {
+		fmt.Printf("%d check(s) failed; nothing was changed.\n", failed)
+		return patch, mode, 1
+	}
+	fmt.Printf("%s is ready to patch.\n", dir)
+	return patch, mode, 0
+}
+
+// git runs git in dir and returns its trimmed output, stderr included.
+func git(dir string, args ...string) (string, error) {
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	out, err := cmd.CombinedOutput()
+	text := strings.TrimSpace(string(out))
+	if err != nil && text != "" {
+		return text, fmt.Errorf("%w: %s", err, firstLine(text))
+	}
+	return text, err
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(s, "\n")
+	return line
+}
+
+func indent(s string) string {
+	return "        " + strings.ReplaceAll(s, "\n", "\n        ")
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Building an image: docker-build runs docker build on dockerFile with
+// this repository as the context. The Dockerfile does the rest in the
+// build container, cloning the Ollama release, installing with apply or
+// generate and building, so the result depends only on the release, this
+// repository's commit and the base images, and needs no Go toolchain on
+// the host. The version is checked against the patch matrix first, to
+// refuse one with no patch before anything is cloned.
+
+// dockerFile builds the image, relative to this repository's root.
+const dockerFile = "docker/Dockerfile"
+
+// cmdDockerBuild builds an image of Ollama release version, cloned from
+// repo, with the tiered cache installed in mode (see check, or
+// "generate"), and tags it tag, or "ollama-tiered:<version>". docker is
+// the command to build with, e.g. podman; platform, if set, is passed to
+// it.
+func cmdDockerBuild(src, version, mode, repo, tag, platform, docker string) int {
+	src = absPath(src)
+	if version == "" {
+		return failf("docker-build needs the Ollama release to build, e.g. -version v0.16.1")
+	}
+	if _, _, err := parseSeries(version); err != nil {
+		return failf("%v", err)
+	}
+	if mode == "generate" {
+		if _, err := templateFiles(filepath.Join(src, templatesDir)); err != nil {
+			return failf("%v", err)
+		}
+		fmt.Printf("Ollama %s, generated from %s\n", version, templatesDir)
+	} else {
+		rels, err := loadMatrix(src)
+		if err != nil {
+			return failf("%v", err)
+		}
+		r, err := selectRelease(rels, version)
+		if err != nil {
+			return failf("%v", err)
+		}
+		fmt.Printf("Ollama %s: %s, tested on %s\n", version, r.patch, r.tested)
+	}
+	if tag == "" {
+		tag = "ollama-tiered:" + version
+	}
+
+	args := []string{"build",
+		"-f", filepath.Join(src, dockerFile),
+		"--build-arg", "OLLAMA_VERSION=" + version,
+		"--build-arg", "OLLAMA_REPO=" + repo,
+		"--build-arg", "PATCH_MODE=" + mode,
+		"-t", tag,
+	}
+	if platform != "" {
+		args = append(args, "--platform", platform)
+	}
+	args = append(args, src)
+	fmt.Printf("%s %s\n\n", docker, strings.Join(args, " "))
+	cmd := exec.Command(docker, args...)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil {
+		return failf("%s build: %v", docker, err)
+	}
+	fmt.Printf("\nbuilt %s; run it with: %s run -d -p 11434:11434 -v ollama:/root/.ollama %s\n", tag, docker, tag)
+	return 0
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"go/format"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+)
+
+// Generating the integration: instead of a patch kept per release, the
+// generate subcommand renders the files of the integration from the
+// text/template files under templatesDir, with the checkout's Ollama
+// version and the module paths (genData) as their data. A *.go.tmpl
+// template renders a new file of the checkout, at its path under
+// templatesDir without the .tmpl, and is gofmt'd: kvcache/tiered.go and
+// the runner's helpers. A *.diff.tmpl template renders a unified diff of
+// Ollama's own files, the runner's hooks and the env-var plumbing in
+// NewInputCache, which is applied structurally (see astpatch.go), so it
+// needs no line numbers. Installing, status and revert then work as for
+// a structural apply.
+
+// templatesDir holds the templates, relative to this repository's root.
+const templatesDir = "patches/templates"
+
+// genData is the data the templates render with.
+type genData struct {
+	Version      string // the checkout's Ollama release, e.g. "v0.16.1"
+	Major, Minor int    // of Version
+	Module       string // this module's path
+	Ollama       string // Ollama's module path, from its go.mod
+}
+
+// moduleLine matches the module directive of a go.mod.
+var moduleLine = regexp.MustCompile(`(?m)^module\s+(\S+)\s*$`)
+
+// render renders the templates of the repository at src for the checkout
+// at dir, at Ollama version, returning the files it creates or changes
+// without writing them.
+func render(dir, src, version string) (map[string][]byte, error) {
+	major, minor, err := parseSeries(version)
+	if err != nil {
+		return nil, fmt.Errorf("generate needs the release: %w", err)
+	}
+	mod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	m := moduleLine.FindSubmatch(mod)
+	if m == nil {
+		return nil, errors.New("go.mod has no module directive")
+	}
+	data := genData{Version: version, Major: major, Minor: minor, Module: modulePath, Ollama: string(m[1])}
+
+	out := make(map[string][]byte)
+	var diffs []fileDiff
+	root := filepath.Join(src, templatesDir)
+	names, err := templateFiles(root)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		text, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return nil, err
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(string(text))
+		if err != nil {
+			return nil, err
+		}
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, data); err != nil {
+			return nil, err
+		}
+		switch {
+		case strings.HasSuffix(name, ".go.tmpl"):
+			target := strings.TrimSuffix(name, ".tmpl")
+			if _, err := os.Stat(filepath.Join(dir, filepath.FromSlash(target))); err == nil {
+				return nil, fmt.Errorf("%s exists already", target)
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+			if out[target], err = format.Source(b.Bytes()); err != nil {
+				return nil, fmt.Errorf("%s does not render to Go: %w", name, err)
+			}
+		case strings.HasSuffix(name, ".diff.tmpl"):
+			d, err := parsePatch(b.Bytes())
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			diffs = append(diffs, d...)
+		}
+	}
+
+	changed, err := applyStructural(dir, diffs)
+	if err != nil {
+		return nil, err
+	}
+	for name, data := range changed {
+		if _, ok := out[name]; ok {
+			return nil, fmt.Errorf("%s is both rendered whole and changed by a diff", name)
+		}
+		out[name] = data
+	}
+	return out, nil
+}
+
+// templateFiles returns the templates under root, slash-separated and
+// relative to it, in order.
+func templateFiles(root string) ([]string, error) {
+	var names []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasSuffix(name, ".go.tmpl") && !strings.HasSuffix(name, ".diff.tmpl") {
+			return fmt.Errorf("%s: not a .go.tmpl or .diff.tmpl template", filepath.Join(templatesDir, name))
+		}
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("%s has no templates", templatesDir)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// templatesDigest returns a digest of the templates of the repository at
+// src, to tell whether they changed since generate ran.
+func templatesDigest(src string) (string, error) {
+	root := filepath.Join(src, templatesDir)
+	names, err := templateFiles(root)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	for _, name := range names {
+		text, err := os.ReadFile(filepath.Join(root, filepath.FromSlash(name)))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s %d\n", name, len(text))
+		h.Write(text)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
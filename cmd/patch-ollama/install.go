@@ -0,0 +1,532 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Installing into a checkout: apply copies this module's packages
+// (diskstore and the tiering logic in kvcache, without their tests) into
+// the Ollama checkout under installDir, points a go.mod replace
+// directive at the copy, so the checkout builds on its own, and applies
+// the patch. It records what it did in a manifest beside the copy, along
+// with go.mod and go.sum as they were and the patch as applied, which is
+// what revert undoes and status reports on, even after this repository
+// has moved on. A step that fails undoes the steps before it.
+//
+// In the ast mode, where the patch is applied structurally, and for
+// generate, git can't reverse the changes, so the files changed are kept
+// as they were under origDir and a digest of each file written is
+// recorded: revert puts the originals back and deletes the files created,
+// once the digests show nothing changed them since.
+
+// installDir is where apply copies the module, relative to the checkout.
+const installDir = "third_party/ollama-kv-cache-tiering"
+
+// modulePath is this module, as the patch imports it.
+const modulePath = "github.com/databloom/ollama-kv-cache-tiering"
+
+const (
+	manifestName = "patch-ollama.json"
+	patchCopy    = "applied.patch"
+	origDir      = "orig" // the files the ast mode changed, as they were
+)
+
+// copied are the files and package directories of this module apply
+// copies.
+var copied = []string{"go.mod", "go.sum", "LICENSE", "diskstore", "kvcache"}
+
+// manifest records an installation.
+type manifest struct {
+	Version     string    `json:"version"` // this repository's, by git describe
+	Patch       string    `json:"patch"`
+	PatchSHA256 string    `json:"patch_sha256"`
+	Ollama      string    `json:"ollama"` // the checkout's version
+	Installed   time.Time `json:"installed"`
+	Files       []string  `json:"files"` // copied, relative to installDir
+
+	// The files the ast mode or generate wrote, by digest as written,
+	// and of them those created; Mode is "" for git.
+	Mode    string            `json:"mode,omitempty"`
+	Written map[string]string `json:"written,omitempty"`
+	Created []string          `json:"created,omitempty"`
+
+	// go.mod and go.sum before apply changed them.
+	GoMod string `json:"go_mod"`
+	GoSum string `json:"go_sum,omitempty"`
+}
+
+// cmdApply installs the integration into the checkout at dir from the
+// repository at src, with the patch and mode check picks (see check), or
+// with mode "generate" from the repository's templates (see generate.go).
+func cmdApply(dir, src, version, patch, mode string) int {
+	dir, src = absPath(dir), absPath(src)
+	if m, err := readManifest(dir); err == nil {
+		fmt.Fprintf(os.Stderr, "patch-ollama: %s already has version %s installed\n", dir, m.Version)
+		fmt.Fprintf(os.Stderr, "  run patch-ollama revert -path %s first\n", dir)
+		return 1
+	}
+	patchPath, mode, status := check(dir, src, version, patch, mode)
+	if status != 0 {
+		return status
+	}
+	fmt.Println()
+
+	// What the changes come from, and its digest.
+	var body []byte
+	name, sum := filepath.Base(patchPath), ""
+	var err error
+	if mode == "generate" {
+		name = templatesDir
+		sum, err = templatesDigest(src)
+	} else if body, err = os.ReadFile(patchPath); err == nil {
+		sum = digest(body)
+	}
+	if err != nil {
+		return failf("%v", err)
+	}
+	gomod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return failf("%v", err)
+	}
+	gosum, err := os.ReadFile(filepath.Join(dir, "go.sum"))
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return failf("%v", err)
+	}
+	ollama, _ := git(dir, "describe", "--tags")
+	m := manifest{
+		Version:     sourceVersion(src),
+		Patch:       name,
+		PatchSHA256: sum,
+		Ollama:      ollama,
+		Installed:   time.Now().UTC().Truncate(time.Second),
+		GoMod:       string(gomod),
+		GoSum:       string(gosum),
+	}
+	if mode != "git" {
+		m.Mode = mode
+	}
+
+	// Until the manifest is written, a failure puts go.mod, go.sum and
+	// the copy back as they were.
+	dst := filepath.Join(dir, installDir)
+	undo := func() {
+		os.WriteFile(filepath.Join(dir, "go.mod"), gomod, 0o644)
+		if gosum != nil {
+			os.WriteFile(filepath.Join(dir, "go.sum"), gosum, 0o644)
+		}
+		removeInstall(dir)
+	}
+
+	if m.Files, err = copyModule(src, dst); err != nil {
+		undo()
+		return failf("copy %s: %v", modulePath, err)
+	}
+	fmt.Printf("copied %d files to %s\n", len(m.Files), installDir)
+
+	if err := goCmd(dir, "mod", "edit", "-replace", modulePath+"=./"+installDir); err != nil {
+		undo()
+		return failf("%v", err)
+	}
+	if err := goCmd(dir, "get", modulePath); err != nil {
+		undo()
+		return failf("%v", err)
+	}
+	fmt.Printf("go.mod requires %s, replaced by the copy\n", modulePath)
+
+	unpatch := func() { git(dir, "apply", "--reverse", patchPath) }
+	if m.Mode != "" {
+		var files map[string][]byte
+		if m.Mode == "generate" {
+			if version == "" {
+				version, _ = detectVersion(dir) // check found it
+			}
+			files, err = render(dir, src, version)
+		} else {
+			files, err = structural(dir, patchPath)
+		}
+		if err == nil {
+			err = writeFiles(dir, files, &m)
+		}
+		if err != nil {
+			restoreFiles(dir, m)
+			undo()
+			return failf("%v", err)
+		}
+		unpatch = func() { restoreFiles(dir, m) }
+		if m.Mode == "generate" {
+			fmt.Printf("generated %d files from %s\n", len(m.Written), m.Patch)
+		} else {
+			fmt.Printf("applied %s structurally to %d files\n", m.Patch, len(m.Written))
+		}
+	} else {
+		if out, err := git(dir, "apply", patchPath); err != nil {
+			undo()
+			return failf("git apply: %v\n%s", err, out)
+		}
+		fmt.Printf("applied %s\n", m.Patch)
+	}
+
+	if body != nil {
+		if err := os.WriteFile(filepath.Join(dst, patchCopy), body, 0o644); err != nil {
+			unpatch()
+			undo()
+			return failf("%v", err)
+		}
+	}
+	if err := writeManifest(dir, m); err != nil {
+		unpatch()
+		undo()
+		return failf("%v", err)
+	}
+	fmt.Printf("\ninstalled version %s; build with: cd %s && go generate ./... && go build .\n", m.Version, dir)
+	return 0
+}
+
+// cmdRevert undoes apply. With force it leaves the patched files alone,
+// for when they were reverted by hand.
+func cmdRevert(dir string, force bool) int {
+	dir = absPath(dir)
+	m, err := readManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return failf("%s has no installation to revert (no %s)", dir, filepath.Join(installDir, manifestName))
+	}
+	if err != nil {
+		return failf("%v", err)
+	}
+
+	applied := filepath.Join(dir, installDir, patchCopy)
+	if !force {
+		var out string
+		var err error
+		modified, created := patchFiles(applied)
+		if m.Mode != "" {
+			modified, created = nil, m.Created
+			for name := range m.Written {
+				if !slices.Contains(created, name) {
+					modified = append(modified, name)
+				}
+			}
+			slices.Sort(modified)
+			if changed := changedFiles(dir, m); len(changed) > 0 {
+				out, err = strings.Join(changed, "\n"), errors.New("changed")
+			}
+		} else {
+			out, err = git(dir, "apply", "--check", "--reverse", applied)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "patch-ollama: the patched files changed since apply; nothing was reverted\n%s\n", indent(out))
+			fmt.Fprintln(os.Stderr, "  revert them by hand, then run revert -force:")
+			if len(modified) > 0 {
+				fmt.Fprintf(os.Stderr, "    git -C %s checkout -- %s\n", dir, strings.Join(modified, " "))
+			}
+			if len(created) > 0 {
+				fmt.Fprintf(os.Stderr, "    (cd %s && rm %s)\n", dir, strings.Join(created, " "))
+			}
+			return 1
+		}
+		if m.Mode != "" {
+			if err := restoreFiles(dir, m); err != nil {
+				return failf("%v", err)
+			}
+		} else if out, err := git(dir, "apply", "--reverse", applied); err != nil {
+			return failf("git apply --reverse: %v\n%s", err, out)
+		}
+		fmt.Printf("reverted %s\n", m.Patch)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(m.GoMod), 0o644); err != nil {
+		return failf("%v", err)
+	}
+	sum := filepath.Join(dir, "go.sum")
+	if m.GoSum != "" {
+		err = os.WriteFile(sum, []byte(m.GoSum), 0o644)
+	} else if err = os.Remove(sum); errors.Is(err, fs.ErrNotExist) {
+		err = nil
+	}
+	if err != nil {
+		return failf("%v", err)
+	}
+	fmt.Println("restored go.mod and go.sum")
+
+	if err := removeInstall(dir); err != nil {
+		return failf("%v", err)
+	}
+	fmt.Printf("removed %s\n\nversion %s uninstalled\n", installDir, m.Version)
+	return 0
+}
+
+// cmdStatus reports what is installed in dir, compared with the patch
+// the repository at src has for it, or patch if set.
+func cmdStatus(dir, src, version, patch string) int {
+	dir, src = absPath(dir), absPath(src)
+	var perr error
+	if patch == "" {
+		patch, perr = matrixPatch(dir, src, version)
+	} else {
+		patch = absPath(patch)
+	}
+	m, err := readManifest(dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		fmt.Printf("%s: not installed\n", dir)
+		// git apply by hand, as the README describes, leaves no
+		// manifest.
+		if _, err := git(dir, "apply", "--check", "--reverse", patch); perr == nil && err == nil {
+			fmt.Println("the patch is applied, but not by patch-ollama apply; undo it with:")
+			fmt.Printf("  git -C %s apply --reverse %s\n", dir, patch)
+		}
+		return 0
+	}
+	if err != nil {
+		return failf("%v", err)
+	}
+
+	fmt.Printf("%s: version %s installed %s\n", dir, m.Version, m.Installed.Local().Format(time.DateTime))
+	fmt.Printf("ollama:    %s when installed\n", m.Ollama)
+	if m.Mode != "" {
+		how := "applied structurally"
+		if m.Mode == "generate" {
+			how = "generated"
+		}
+		fmt.Printf("patch:     %s (sha256 %s), %s\n", m.Patch, m.PatchSHA256[:12], how)
+		if changed := changedFiles(dir, m); len(changed) > 0 {
+			fmt.Printf("           %s changed since apply; revert needs -force\n", strings.Join(changed, ", "))
+		}
+	} else {
+		fmt.Printf("patch:     %s (sha256 %s)\n", m.Patch, m.PatchSHA256[:12])
+		if _, err := git(dir, "apply", "--check", "--reverse", filepath.Join(dir, installDir, patchCopy)); err != nil {
+			fmt.Println("           changed since apply; revert needs -force")
+		}
+	}
+	fmt.Printf("diskstore: %s, %d files\n", installDir, len(m.Files))
+
+	var sum string
+	again := "apply"
+	if m.Mode == "generate" {
+		sum, err = templatesDigest(src)
+		perr, again = nil, "generate"
+	} else {
+		var body []byte
+		body, err = os.ReadFile(patch)
+		sum = digest(body)
+	}
+	switch current := sourceVersion(src); {
+	case perr != nil:
+		fmt.Printf("\ncannot compare with %s: %v\n", src, perr)
+	case err != nil:
+		fmt.Printf("\ncannot compare with %s: %v\n", src, err)
+	case sum != m.PatchSHA256 || current != m.Version:
+		fmt.Printf("\noutdated: %s has version %s; revert and %s again to update\n", src, current, again)
+	default:
+		fmt.Println("\nup to date")
+	}
+	return 0
+}
+
+// copyModule copies this module's files from src into dst and returns
+// their paths relative to dst.
+func copyModule(src, dst string) ([]string, error) {
+	var files []string
+	for _, name := range copied {
+		fi, err := os.Stat(filepath.Join(src, name))
+		if err != nil {
+			return nil, err
+		}
+		names := []string{name}
+		if fi.IsDir() {
+			entries, err := os.ReadDir(filepath.Join(src, name))
+			if err != nil {
+				return nil, err
+			}
+			names = names[:0]
+			for _, e := range entries {
+				if e.Type().IsRegular() && strings.HasSuffix(e.Name(), ".go") && !strings.HasSuffix(e.Name(), "_test.go") {
+					names = append(names, filepath.Join(name, e.Name()))
+				}
+			}
+		}
+		for _, n := range names {
+			data, err := os.ReadFile(filepath.Join(src, n))
+			if err != nil {
+				return nil, err
+			}
+			if err := os.MkdirAll(filepath.Dir(filepath.Join(dst, n)), 0o755); err != nil {
+				return nil, err
+			}
+			if err := os.WriteFile(filepath.Join(dst, n), data, 0o644); err != nil {
+				return nil, err
+			}
+			files = append(files, filepath.ToSlash(n))
+		}
+	}
+	return files, nil
+}
+
+// writeFiles writes files, by path, into the checkout at dir, keeping
+// those it changes under origDir first, and records them in m.
+func writeFiles(dir string, files map[string][]byte, m *manifest) error {
+	m.Written = make(map[string]string)
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		old, err := os.ReadFile(path)
+		switch {
+		case errors.Is(err, fs.ErrNotExist):
+			m.Created = append(m.Created, name)
+		case err != nil:
+			return err
+		default:
+			orig := filepath.Join(dir, installDir, origDir, filepath.FromSlash(name))
+			if err := os.MkdirAll(filepath.Dir(orig), 0o755); err != nil {
+				return err
+			}
+			if err := os.WriteFile(orig, old, 0o644); err != nil {
+				return err
+			}
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		m.Written[name] = digest(data)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// restoreFiles undoes writeFiles: it puts back the files kept under
+// origDir and deletes the ones created.
+func restoreFiles(dir string, m manifest) error {
+	for name := range m.Written {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if slices.Contains(m.Created, name) {
+			if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				return err
+			}
+			continue
+		}
+		old, err := os.ReadFile(filepath.Join(dir, installDir, origDir, filepath.FromSlash(name)))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue // failed before it was written
+		}
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, old, 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// changedFiles returns the files writeFiles wrote that changed since.
+func changedFiles(dir string, m manifest) []string {
+	var changed []string
+	for name, sum := range m.Written {
+		if data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err != nil || digest(data) != sum {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// removeInstall deletes installDir, and third_party if that leaves it
+// empty.
+func removeInstall(dir string) error {
+	if err := os.RemoveAll(filepath.Join(dir, installDir)); err != nil {
+		return err
+	}
+	os.Remove(filepath.Join(dir, filepath.Dir(installDir))) // fails unless empty
+	return nil
+}
+
+// patchFiles returns the files the patch modifies and those it creates.
+func patchFiles(patch string) (modified, created []string) {
+	body, err := os.ReadFile(patch)
+	if err != nil {
+		return nil, nil
+	}
+	newFile := false
+	for _, line := range strings.Split(string(body), "\n") {
+		switch {
+		case strings.HasPrefix(line, "--- "):
+			newFile = line == "--- /dev/null"
+		case strings.HasPrefix(line, "+++ b/"):
+			if name := strings.TrimPrefix(line, "+++ b/"); newFile {
+				created = append(created, name)
+			} else {
+				modified = append(modified, name)
+			}
+		}
+	}
+	return modified, created
+}
+
+func readManifest(dir string) (manifest, error) {
+	var m manifest
+	data, err := os.ReadFile(filepath.Join(dir, installDir, manifestName))
+	if err != nil {
+		return m, err
+	}
+	if err := json.Unmarshal(data, &m); err != nil {
+		return m, fmt.Errorf("%s: %w", manifestName, err)
+	}
+	return m, nil
+}
+
+func writeManifest(dir string, m manifest) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, installDir, manifestName), append(data, '\n'), 0o644)
+}
+
+// absPath returns path made absolute, as git runs in the checkout.
+func absPath(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+// sourceVersion names the commit of the repository at src.
+func sourceVersion(src string) string {
+	v, err := git(src, "describe", "--tags", "--always", "--dirty")
+	if err != nil {
+		return "unknown"
+	}
+	return v
+}
+
+func digest(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// goCmd runs the go command in dir.
+func goCmd(dir string, args ...string) error {
+	cmd := exec.Command("go", args...)
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("go %s: %w\n%s", strings.Join(args, " "), err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// failf reports a failure and returns the exit status for it.
+func failf(format string, args ...any) int {
+	fmt.Fprintf(os.Stderr, "patch-ollama: "+format+"\n", args...)
+	return 1
+}
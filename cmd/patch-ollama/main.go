@@ -1,8 +1,35 @@
-// Command patch-ollama prints the integration guide and optionally
-// applies the patch to a local Ollama checkout.
+// Command patch-ollama prints the integration guide and installs the
+// tiered cache into a local Ollama checkout.
+//
+// Usage:
+//
+//	patch-ollama [guide]
+//	patch-ollama check -path /path/to/ollama
+//	patch-ollama apply -path /path/to/ollama
+//	patch-ollama generate -path /path/to/ollama
+//	patch-ollama docker-build -version v0.16.1
+//	patch-ollama status -path /path/to/ollama
+//	patch-ollama revert -path /path/to/ollama
+//
+// check verifies, without changing anything, that a patch applies to the
+// checkout: that patches/versions has one for its Ollama version, that
+// the functions the patch hooks into are there, and git apply --check.
+// apply runs the same checks, then copies diskstore into the checkout,
+// points its go.mod at the copy, applies the patch and records what it
+// did; revert undoes that, and status reports the version installed.
+// check, apply and status read the patches from the repository given by
+// -src, the current directory by default. With -mode auto, the default,
+// a patch git can't apply is placed structurally instead, by the Go
+// syntax of the files it changes; -mode git and -mode ast force one way.
+// generate installs like apply, but renders the integration from the
+// templates under patches/templates for the checkout's version instead
+// of applying a patch; check -mode generate checks that they fit.
+// docker-build builds a container image of an Ollama release installed
+// that way, by docker/Dockerfile, and tags it.
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 
@@ -10,18 +37,111 @@ import (
 )
 
 func main() {
-	if len(os.Args) > 1 && os.Args[1] == "--help" {
-		fmt.Println("Usage: patch-ollama [--guide]")
-		fmt.Println()
-		fmt.Println("  --guide    Print the integration guide")
-		fmt.Println()
-		fmt.Println("To apply the patch to an Ollama checkout:")
-		fmt.Println("  cd /path/to/ollama")
-		fmt.Println("  git apply /path/to/ollama-kv-cache-tiering/patches/ollama-tiered-kvcache.patch")
-		fmt.Println("  cp -r /path/to/ollama-kv-cache-tiering/diskstore .")
-		fmt.Println("  go build .")
+	if len(os.Args) < 2 || os.Args[1] == "guide" || os.Args[1] == "--guide" {
+		kvcache.PrintIntegrationGuide()
+		return
+	}
+	cmd, args := os.Args[1], os.Args[2:]
+	if cmd == "--check" {
+		// The form before subcommands: --check /path/to/ollama [patch].
+		if len(args) < 1 || len(args) > 2 {
+			fmt.Fprintln(os.Stderr, "usage: patch-ollama --check /path/to/ollama [patch]")
+			os.Exit(2)
+		}
+		patch := ""
+		if len(args) == 2 {
+			patch = args[1]
+		}
+		_, _, status := check(args[0], ".", "", patch, "auto")
+		os.Exit(status)
+	}
+
+	fs := flag.NewFlagSet(cmd, flag.ExitOnError)
+	path := fs.String("path", "", "Ollama checkout")
+	src := fs.String("src", ".", "ollama-kv-cache-tiering repository holding the patches")
+	version := fs.String("version", "", "the checkout's Ollama version, if its release tags are missing (e.g. v0.16.1)")
+	patch := fs.String("patch", "", "patch to use instead of the one patches/versions has for the checkout")
+	mode := fs.String("mode", "auto", "how to apply the patch: git, ast (structurally), or auto for git, else ast; check: generate to check the templates")
+	tag := fs.String("tag", "", "docker-build: the image's tag (default ollama-tiered:<version>)")
+	repo := fs.String("repo", "https://github.com/ollama/ollama.git", "docker-build: the Ollama repository to clone")
+	platform := fs.String("platform", "", "docker-build: the platform to build for, e.g. linux/arm64")
+	docker := fs.String("docker", "docker", "docker-build: the command to build with, e.g. podman")
+	force := fs.Bool("force", false, "revert: leave the patched files alone, having reverted them by hand")
+	switch cmd {
+	case "check", "apply", "generate", "status", "revert", "docker-build":
+	case "help", "-h", "-help", "--help":
+		usage()
 		os.Exit(0)
+	default:
+		fmt.Fprintf(os.Stderr, "patch-ollama: unknown command %q\n\n", cmd)
+		usage()
+		os.Exit(2)
+	}
+	fs.Parse(args)
+	if *path == "" && cmd != "docker-build" || fs.NArg() > 0 {
+		if cmd == "docker-build" {
+			fmt.Fprintln(os.Stderr, "usage: patch-ollama docker-build -version v0.16.1")
+		} else {
+			fmt.Fprintf(os.Stderr, "usage: patch-ollama %s -path /path/to/ollama\n", cmd)
+		}
+		os.Exit(2)
 	}
+	switch {
+	case *mode == "generate" && (cmd == "check" || cmd == "docker-build"):
+	case *mode != "auto" && *mode != "git" && *mode != "ast":
+		fmt.Fprintf(os.Stderr, "patch-ollama: -mode %q: want auto, git or ast\n", *mode)
+		os.Exit(2)
+	}
+
+	switch cmd {
+	case "check":
+		_, _, status := check(*path, absPath(*src), *version, *patch, *mode)
+		os.Exit(status)
+	case "apply":
+		os.Exit(cmdApply(*path, *src, *version, *patch, *mode))
+	case "generate":
+		os.Exit(cmdApply(*path, *src, *version, "", "generate"))
+	case "docker-build":
+		os.Exit(cmdDockerBuild(*src, *version, *mode, *repo, *tag, *platform, *docker))
+	case "status":
+		os.Exit(cmdStatus(*path, *src, *version, *patch))
+	case "revert":
+		os.Exit(cmdRevert(*path, *force))
+	}
+}
 
-	kvcache.PrintIntegrationGuide()
+func usage() {
+	fmt.Println("Usage: patch-ollama [guide | check | apply | generate | status | revert | docker-build] [flags]")
+	fmt.Println()
+	fmt.Println("  guide     Print the integration guide (the default)")
+	fmt.Println("  check     Verify, without changing anything, that the patch for the")
+	fmt.Println("            checkout's Ollama version applies: that there is one, that the")
+	fmt.Println("            functions it hooks into are there, and git apply --check")
+	fmt.Println("  apply     Check, then copy diskstore into the checkout, point its go.mod at")
+	fmt.Println("            the copy and apply the patch, recording a manifest")
+	fmt.Println("  generate  Like apply, but render the integration for the checkout's version")
+	fmt.Println("            from the templates in " + templatesDir + " rather than apply a patch")
+	fmt.Println("  status    Report the version installed in a checkout")
+	fmt.Println("  revert    Undo apply, restoring the checkout")
+	fmt.Println("  docker-build")
+	fmt.Println("            Build and tag a container image of the Ollama release -version,")
+	fmt.Println("            cloned, installed into and built by " + dockerFile)
+	fmt.Println()
+	fmt.Println("Flags:")
+	fmt.Println("  -path     the Ollama checkout (required but for docker-build)")
+	fmt.Println("  -src      this repository, holding the patches (default: the current directory)")
+	fmt.Println("  -version  the checkout's Ollama version, if its release tags are missing")
+	fmt.Println("  -patch    a patch to use instead of the one " + matrixFile + " lists for the version")
+	fmt.Println("  -mode     git, ast to place the patch's changes by Go syntax rather than by")
+	fmt.Println("            line, or auto (the default) for ast where git apply fails;")
+	fmt.Println("            check -mode generate checks the templates instead, and")
+	fmt.Println("            docker-build -mode generate builds with generate")
+	fmt.Println("  -tag, -repo, -platform, -docker")
+	fmt.Println("            docker-build: the image's tag (default ollama-tiered:<version>),")
+	fmt.Println("            the Ollama repository, the platform, and docker or podman")
+	fmt.Println("  -force    revert: leave the patched files alone, having reverted them by hand")
+	fmt.Println()
+	fmt.Println("To install into an Ollama checkout, from this repository:")
+	fmt.Println("  go run ./cmd/patch-ollama apply -path /path/to/ollama")
+	fmt.Println("  cd /path/to/ollama && go generate ./... && go build .")
 }
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The patch matrix: Ollama moves the code the patch hooks into between
+// releases, so patches/versions lists a patch per release series, and
+// check and apply pick the one for the checkout's version, found from
+// its nearest release tag, or refuse a version no patch is kept for. A
+// series is a minor release: v0.16 covers v0.16.0, v0.16.1 and their
+// release candidates. The series "master" covers checkouts past every
+// series listed, such as Ollama's main branch after the last release;
+// a checkout with commits past its nearest release tag is taken to be
+// one, since Ollama tags its releases on main. A line with the patch
+// "-" names a series no patch is kept for, so that it is refused as
+// unsupported rather than as unknown.
+
+// matrixFile lists the patches, relative to this repository's root.
+const matrixFile = "patches/versions"
+
+// release is a line of the matrix.
+type release struct {
+	series string // "v0.16", or "master"
+	patch  string // relative to the matrix's directory, or "-"
+	tested string // the release, or commit, the patch is tested on
+}
+
+// unsupported reports whether r names a series without a patch.
+func (r release) unsupported() bool {
+	return r.patch == "-"
+}
+
+// loadMatrix reads the matrix of the repository at src.
+func loadMatrix(src string) ([]release, error) {
+	f, err := os.Open(filepath.Join(src, matrixFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var rels []release
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		line, _, _ := strings.Cut(sc.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("%s:%d: want <series> <patch> <tested on>", matrixFile, n)
+		}
+		r := release{series: fields[0], patch: fields[1], tested: fields[2]}
+		if _, _, err := parseSeries(r.series); err != nil && r.series != "master" {
+			return nil, fmt.Errorf("%s:%d: %w", matrixFile, n, err)
+		}
+		rels = append(rels, r)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if supported(rels) == "" {
+		return nil, fmt.Errorf("%s lists no patches", matrixFile)
+	}
+	return rels, nil
+}
+
+// path returns the file of r's patch in the repository at src.
+func (r release) path(src string) string {
+	return filepath.Join(src, filepath.Dir(matrixFile), r.patch)
+}
+
+// detectVersion returns the release tag nearest the checkout's HEAD.
+func detectVersion(dir string) (string, error) {
+	return git(dir, "describe", "--tags", "--abbrev=0", "--match", "v[0-9]*")
+}
+
+// detectRelease returns the checkout's version as the matrix knows it:
+// its release tag, or "master" if HEAD is past the tag.
+func detectRelease(dir string) (string, error) {
+	tag, err := detectVersion(dir)
+	if err != nil {
+		return "", err
+	}
+	if desc, err := git(dir, "describe", "--tags", "--match", "v[0-9]*"); err == nil && desc != tag {
+		return "master", nil
+	}
+	return tag, nil
+}
+
+// matrixPatch returns the patch the matrix of the repository at src has
+// for the checkout at dir, taken to be at version if set.
+func matrixPatch(dir, src, version string) (string, error) {
+	rels, err := loadMatrix(src)
+	if err != nil {
+		return "", err
+	}
+	if version == "" {
+		if version, err = detectRelease(dir); err != nil {
+			return "", err
+		}
+	}
+	r, err := selectRelease(rels, version)
+	if err != nil {
+		return "", err
+	}
+	return r.path(src), nil
+}
+
+// selectRelease returns the matrix line for Ollama version, e.g.
+// "v0.16.1", "v0.17.0-rc2" or "master", refusing one marked unsupported.
+func selectRelease(rels []release, version string) (release, error) {
+	r, err := matchRelease(rels, version)
+	if err == nil && r.unsupported() {
+		why := "no patch is kept for " + r.series + ".x"
+		switch {
+		case version == "master":
+			why = "no patch is kept for master"
+		case r.series == "master":
+			why = "it is newer than every release patched, and no patch is kept for master"
+		}
+		err = fmt.Errorf("Ollama %s is not supported: %s; patches are kept for %s", version, why, supported(rels))
+	}
+	return r, err
+}
+
+// matchRelease returns the matrix line covering Ollama version.
+func matchRelease(rels []release, version string) (release, error) {
+	if version == "master" {
+		for _, r := range rels {
+			if r.series == "master" {
+				return r, nil
+			}
+		}
+		return release{}, fmt.Errorf("no patch for Ollama master; patches are kept for %s", supported(rels))
+	}
+	major, minor, err := parseSeries(version)
+	if err != nil {
+		return release{}, err
+	}
+	var master *release
+	newest := true // newer than every series listed
+	for i, r := range rels {
+		if r.series == "master" {
+			master = &rels[i]
+			continue
+		}
+		ma, mi, _ := parseSeries(r.series)
+		if ma == major && mi == minor {
+			return r, nil
+		}
+		if ma > major || ma == major && mi > minor {
+			newest = false
+		}
+	}
+	if newest && master != nil {
+		return *master, nil
+	}
+	return release{}, fmt.Errorf("no patch for Ollama v%d.%d.x; patches are kept for %s", major, minor, supported(rels))
+}
+
+// supported lists the series of the matrix.
+func supported(rels []release) string {
+	var list []string
+	for _, r := range rels {
+		if r.unsupported() {
+			continue
+		}
+		name := r.series + ".x"
+		if r.series == "master" {
+			name = "master"
+		}
+		list = append(list, fmt.Sprintf("%s (tested on %s)", name, r.tested))
+	}
+	return strings.Join(list, ", ")
+}
+
+// latestTested returns the release the newest numbered series is tested
+// on, the one to suggest checking out.
+func latestTested(rels []release) string {
+	best, bestMajor, bestMinor := "", -1, -1
+	for _, r := range rels {
+		ma, mi, err := parseSeries(r.series)
+		if err == nil && !r.unsupported() && (ma > bestMajor || ma == bestMajor && mi > bestMinor) {
+			best, bestMajor, bestMinor = r.tested, ma, mi
+		}
+	}
+	return best
+}
+
+// parseSeries returns the major and minor numbers of a version or series
+// such as "v0.16", "v0.16.1" or "v0.17.0-rc2".
+func parseSeries(v string) (major, minor int, err error) {
+	parts := strings.SplitN(strings.TrimPrefix(v, "v"), ".", 3)
+	if len(parts) >= 2 {
+		major, err = strconv.Atoi(parts[0])
+		if err == nil {
+			minor, err = strconv.Atoi(strings.SplitN(parts[1], "-", 2)[0])
+		}
+		if err == nil && strings.HasPrefix(v, "v") {
+			return major, minor, nil
+		}
+	}
+	return 0, 0, fmt.Errorf("%q is not a release version (vMAJOR.MINOR)", v)
+}
@@ -0,0 +1,41 @@
+package diskstore
+
+import "time"
+
+// recentAccesses is how many read timestamps each block remembers.
+const recentAccesses = 4
+
+// recordAccess notes a read of meta at now. Timestamps are kept as
+// seconds since StoredAt, newest first, so the index stays small while
+// frequency-based policies and heat maps survive restarts.
+// Must be called with s.mu held.
+func recordAccess(meta *BlockMeta, now time.Time) {
+	meta.AccessedAt = now
+	if meta.Hits < ^uint32(0) {
+		meta.Hits++
+	}
+
+	offset := now.Sub(meta.StoredAt) / time.Second
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > time.Duration(^uint32(0)) {
+		offset = time.Duration(^uint32(0))
+	}
+	n := len(meta.Recent)
+	if n < recentAccesses {
+		meta.Recent = append(meta.Recent, 0)
+		n++
+	}
+	copy(meta.Recent[1:n], meta.Recent[:n-1])
+	meta.Recent[0] = uint32(offset)
+}
+
+// RecentAccesses returns the block's most recent read times, newest first.
+func (m BlockMeta) RecentAccesses() []time.Time {
+	times := make([]time.Time, len(m.Recent))
+	for i, off := range m.Recent {
+		times[i] = m.StoredAt.Add(time.Duration(off) * time.Second)
+	}
+	return times
+}
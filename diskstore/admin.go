@@ -0,0 +1,216 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// AdminHandler returns an http.Handler exposing live inspection and control
+// of the store. It is meant to be mounted on an operator-only listener:
+//
+//	GET    /stats            storage statistics
+//	GET    /pressure         write pressure (see Pressure)
+//	GET    /blocks?seq=N     block metadata for a sequence
+//	GET    /heatmap?seq=N    reads and tiers of a sequence's blocks (see
+//	                         Heatmap); &format=csv for CSV
+//	POST   /gc               reconcile index and tier directories
+//	GET    /budget           current budgets
+//	PUT    /budget           set budgets: {"local": bytes, "remote": bytes},
+//	                         answered with the blocks moved and dropped
+//	GET    /settings         runtime settings (see Settings)
+//	PUT    /settings         reload settings, fields left out kept,
+//	                         answered with what changed (see Reload)
+//	GET    /pin?seq=N        whether a sequence is pinned
+//	POST   /pin?seq=N        pin a sequence to the local tier
+//	DELETE /pin?seq=N        unpin a sequence
+//	GET    /expired          sequences whose cache was removed
+//	GET    /expired/stream   server-sent expiry events
+//	GET    /events/stream    server-sent block events (see WatchEvents)
+func (s *Store) AdminHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /stats", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Stats())
+	})
+
+	mux.HandleFunc("GET /pressure", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Pressure())
+	})
+
+	mux.HandleFunc("GET /blocks", func(w http.ResponseWriter, r *http.Request) {
+		seq, ok := seqParam(w, r)
+		if !ok {
+			return
+		}
+		blocks := s.Blocks(seq)
+		if blocks == nil {
+			blocks = []BlockMeta{}
+		}
+		writeJSON(w, http.StatusOK, blocks)
+	})
+
+	mux.HandleFunc("GET /heatmap", func(w http.ResponseWriter, r *http.Request) {
+		seq, ok := seqParam(w, r)
+		if !ok {
+			return
+		}
+		h := s.Heatmap(seq)
+		if r.URL.Query().Get("format") == "csv" {
+			w.Header().Set("Content-Type", "text/csv")
+			h.WriteCSV(w)
+			return
+		}
+		writeJSON(w, http.StatusOK, h)
+	})
+
+	mux.HandleFunc("POST /gc", func(w http.ResponseWriter, r *http.Request) {
+		res, err := s.GC()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, res)
+	})
+
+	mux.HandleFunc("GET /budget", func(w http.ResponseWriter, r *http.Request) {
+		local, remote := s.Budgets()
+		writeJSON(w, http.StatusOK, budgetBody{Local: local, Remote: remote})
+	})
+
+	mux.HandleFunc("PUT /budget", func(w http.ResponseWriter, r *http.Request) {
+		local, remote := s.Budgets()
+		req := budgetBody{Local: local, Remote: remote}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "invalid budget body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Local < 0 || req.Remote < 0 {
+			http.Error(w, "budgets must be non-negative", http.StatusBadRequest)
+			return
+		}
+		res := s.SetBudgets(req.Local, req.Remote)
+		writeJSON(w, http.StatusOK, struct {
+			budgetBody
+			BudgetResult
+		}{req, res})
+	})
+
+	mux.HandleFunc("GET /settings", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Settings())
+	})
+
+	mux.HandleFunc("PUT /settings", func(w http.ResponseWriter, r *http.Request) {
+		req := s.Settings()
+		dec := json.NewDecoder(r.Body)
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			http.Error(w, "invalid settings body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		res, err := s.Reload(req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, http.StatusOK, struct {
+			Settings Settings `json:"settings"`
+			ReloadResult
+		}{s.Settings(), res})
+	})
+
+	mux.HandleFunc("GET /pin", func(w http.ResponseWriter, r *http.Request) {
+		seq, ok := seqParam(w, r)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, pinBody{Seq: seq, Pinned: s.Pinned(seq)})
+	})
+
+	mux.HandleFunc("POST /pin", func(w http.ResponseWriter, r *http.Request) {
+		seq, ok := seqParam(w, r)
+		if !ok {
+			return
+		}
+		n := s.Pin(seq)
+		writeJSON(w, http.StatusOK, pinBody{Seq: seq, Pinned: true, Blocks: n})
+	})
+
+	mux.HandleFunc("DELETE /pin", func(w http.ResponseWriter, r *http.Request) {
+		seq, ok := seqParam(w, r)
+		if !ok {
+			return
+		}
+		n := s.Unpin(seq)
+		writeJSON(w, http.StatusOK, pinBody{Seq: seq, Pinned: false, Blocks: n})
+	})
+
+	mux.HandleFunc("GET /expired", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, s.Expired())
+	})
+
+	mux.HandleFunc("GET /expired/stream", func(w http.ResponseWriter, r *http.Request) {
+		events, stop := s.WatchExpired()
+		defer stop()
+		streamEvents(w, r, events, func(ExpiryEvent) string { return "expired" })
+	})
+
+	mux.HandleFunc("GET /events/stream", func(w http.ResponseWriter, r *http.Request) {
+		events, stop := s.WatchEvents()
+		defer stop()
+		streamEvents(w, r, events, func(ev Event) string { return string(ev.Kind) })
+	})
+
+	return mux
+}
+
+// streamEvents writes events as server-sent events named by name until
+// the client goes away.
+func streamEvents[T any](w http.ResponseWriter, r *http.Request, events <-chan T, name func(T) string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-events:
+			data, _ := json.Marshal(ev)
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name(ev), data)
+			flusher.Flush()
+		}
+	}
+}
+
+type budgetBody struct {
+	Local  int64 `json:"local"`
+	Remote int64 `json:"remote"`
+}
+
+type pinBody struct {
+	Seq    int  `json:"seq"`
+	Pinned bool `json:"pinned"`
+	Blocks int  `json:"blocks"`
+}
+
+func seqParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	seq, err := strconv.Atoi(r.URL.Query().Get("seq"))
+	if err != nil {
+		http.Error(w, "missing or invalid seq parameter", http.StatusBadRequest)
+		return 0, false
+	}
+	return seq, true
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,170 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAdminHandler(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1024 * 1024,
+		RemoteBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for i := int32(0); i < 4; i++ {
+		key := BlockKey{Seq: 5, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+		store.Put(key, "f16", []int{128}, make([]byte, 100))
+	}
+
+	srv := httptest.NewServer(store.AdminHandler())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/blocks?seq=5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var blocks []BlockMeta
+	json.NewDecoder(resp.Body).Decode(&blocks)
+	resp.Body.Close()
+	if len(blocks) != 4 {
+		t.Errorf("/blocks: got %d blocks, want 4", len(blocks))
+	}
+
+	resp, _ = http.Post(srv.URL+"/pin?seq=5", "", nil)
+	resp.Body.Close()
+	if !store.Pinned(5) {
+		t.Error("/pin: sequence not pinned")
+	}
+
+	// Shrinking the local budget must not move pinned blocks.
+	req, _ := http.NewRequest(http.MethodPut, srv.URL+"/budget", strings.NewReader(`{"local": 0}`))
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if local, remote := store.Budgets(); local != 0 || remote != 1024*1024 {
+		t.Errorf("budgets = %d/%d, want 0/%d", local, remote, 1024*1024)
+	}
+	if st := store.Stats(); st.RemoteBlocks != 0 {
+		t.Errorf("pinned blocks migrated: %d remote", st.RemoteBlocks)
+	}
+
+	resp, _ = http.Get(srv.URL + "/blocks")
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("/blocks without seq: status %d, want 400", resp.StatusCode)
+	}
+}
+
+func TestAdminBudgetRebalance(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for i := int32(0); i < 4; i++ {
+		key := BlockKey{Seq: 5, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+		store.Put(key, "f16", []int{128}, make([]byte, 100))
+	}
+
+	srv := httptest.NewServer(store.AdminHandler())
+	defer srv.Close()
+	setBudget := func(body string) (got struct {
+		Local, Remote  int64
+		Moved, Dropped int
+	}) {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/budget", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&got)
+		return got
+	}
+
+	// Two blocks fit on the remote tier; of the other three, one more
+	// block than the local budget allows is dropped.
+	got := setBudget(`{"local": 100, "remote": 250}`)
+	if got.Local != 100 || got.Remote != 250 || got.Moved != 2 || got.Dropped != 1 {
+		t.Errorf("PUT /budget = %+v, want 100/250, 2 moved, 1 dropped", got)
+	}
+	st := store.Stats()
+	if st.LocalUsed != 100 || st.RemoteUsed != 200 {
+		t.Errorf("used = %d/%d, want 100/200", st.LocalUsed, st.RemoteUsed)
+	}
+
+	// Shrinking the remote tier drops its blocks.
+	if got := setBudget(`{"remote": 100}`); got.Dropped != 1 || got.Moved != 0 {
+		t.Errorf("PUT /budget = %+v, want 1 dropped", got)
+	}
+	if st := store.Stats(); st.LocalBlocks != 1 || st.RemoteBlocks != 1 {
+		t.Errorf("blocks = %d/%d, want 1/1", st.LocalBlocks, st.RemoteBlocks)
+	}
+}
+
+func TestAdminSettings(t *testing.T) {
+	store, err := New(Config{
+		LocalPath:     t.TempDir(),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	srv := httptest.NewServer(store.AdminHandler())
+	defer srv.Close()
+
+	put := func(body string) *http.Response {
+		t.Helper()
+		req, _ := http.NewRequest(http.MethodPut, srv.URL+"/settings", strings.NewReader(body))
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+	resp := put(`{"eviction": "lfu", "restore_bandwidth": 1048576}`)
+	var got struct {
+		Settings Settings
+		Changed  []string
+	}
+	json.NewDecoder(resp.Body).Decode(&got)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(got.Changed) != 2 || got.Settings.Eviction != EvictLFU || got.Settings.LocalBudget != 1024*1024 {
+		t.Errorf("PUT /settings: %d, %+v", resp.StatusCode, got)
+	}
+
+	for _, body := range []string{`{"eviction": "mru"}`, `{"evict": "lru"}`} {
+		resp := put(body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("PUT /settings %s: status %d, want 400", body, resp.StatusCode)
+		}
+	}
+	if st := store.Settings(); st.Eviction != EvictLFU || st.RestoreBandwidth != 1<<20 {
+		t.Errorf("Settings = %+v", st)
+	}
+}
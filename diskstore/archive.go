@@ -0,0 +1,186 @@
+package diskstore
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// archiveVersion is bumped when the export layout changes incompatibly.
+const archiveVersion = 1
+
+// ArchiveManifest is the first entry of an exported sequence archive.
+type ArchiveManifest struct {
+	Version    int       `json:"version"`
+	Seq        int       `json:"seq"`
+	Blocks     int       `json:"blocks"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	// Fingerprint of the exporting store's model, if it had one.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// ExportSeq writes every block of seq to w as a zstd-compressed tar
+// archive: a manifest.json followed by a .json metadata entry and a .bin
+// entry holding the decoded tensor bytes for each block. Blocks are
+// exported in logical form, so the importing store applies its own
+// transforms and compression. It returns the number of blocks written.
+func (s *Store) ExportSeq(seq int, w io.Writer) (int, error) {
+	return s.exportBlocks(seq, s.Blocks(seq), w)
+}
+
+// exportBlocks writes blocks of seq as an ExportSeq archive.
+func (s *Store) exportBlocks(seq int, blocks []BlockMeta, w io.Writer) (int, error) {
+	zw, err := newArchiveWriter(w)
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: export: %w", err)
+	}
+	tw := tar.NewWriter(zw)
+
+	now := time.Now()
+	manifest, _ := json.Marshal(ArchiveManifest{
+		Version:    archiveVersion,
+		Seq:        seq,
+		Blocks:     len(blocks),
+		ExportedAt: now,
+
+		Fingerprint: s.fingerprint,
+	})
+	if err := writeTarEntry(tw, "manifest.json", manifest, now); err != nil {
+		return 0, err
+	}
+
+	for i := range blocks {
+		meta := &blocks[i]
+		payload, err := s.readVerified(context.Background(), meta)
+		if err != nil {
+			return i, err
+		}
+		data, err := s.decode(meta, payload, nil)
+		if err != nil {
+			return i, err
+		}
+		metaJSON, _ := json.Marshal(meta)
+		name := "blocks/" + meta.Key.String()
+		if err := writeTarEntry(tw, name+".json", metaJSON, meta.StoredAt); err != nil {
+			return i, err
+		}
+		if err := writeTarEntry(tw, name+".bin", data, meta.StoredAt); err != nil {
+			return i, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return len(blocks), fmt.Errorf("diskstore: export: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return len(blocks), fmt.Errorf("diskstore: export: %w", err)
+	}
+	s.log.Info("exported sequence", "seq", seq, "blocks", len(blocks))
+	return len(blocks), nil
+}
+
+// ImportSeq reads an archive written by ExportSeq and stores its blocks
+// under the sequence ID they were exported with.
+func (s *Store) ImportSeq(r io.Reader) (int, error) {
+	return s.ImportSeqAs(r, -1)
+}
+
+// ImportSeqAs is like ImportSeq but stores the blocks under seq, which
+// avoids clobbering an unrelated conversation that happens to use the
+// exported slot ID on this machine. A negative seq keeps the original.
+func (s *Store) ImportSeqAs(r io.Reader, seq int) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	zr, release, err := newArchiveReader(r)
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: import: %w", err)
+	}
+	defer release()
+	tr := tar.NewReader(zr)
+
+	var manifest *ArchiveManifest
+	var pending *BlockMeta
+	var imported int
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return imported, fmt.Errorf("diskstore: import: %w", err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return imported, fmt.Errorf("diskstore: import %s: %w", hdr.Name, err)
+		}
+
+		switch {
+		case hdr.Name == "manifest.json":
+			manifest = &ArchiveManifest{}
+			if err := json.Unmarshal(body, manifest); err != nil {
+				return imported, fmt.Errorf("diskstore: import manifest: %w", err)
+			}
+			if manifest.Version != archiveVersion {
+				return imported, fmt.Errorf("diskstore: import: unsupported archive version %d", manifest.Version)
+			}
+			if f := manifest.Fingerprint; f != nil && s.fingerprint != nil && *f != *s.fingerprint {
+				return imported, fmt.Errorf("%w: archive is for %s, store holds %s", ErrFingerprintMismatch, *f, *s.fingerprint)
+			}
+		case manifest == nil:
+			return imported, fmt.Errorf("diskstore: import: archive does not start with a manifest")
+		case strings.HasSuffix(hdr.Name, ".json"):
+			pending = &BlockMeta{}
+			if err := json.Unmarshal(body, pending); err != nil {
+				return imported, fmt.Errorf("diskstore: import %s: %w", hdr.Name, err)
+			}
+		case strings.HasSuffix(hdr.Name, ".bin"):
+			if pending == nil || hdr.Name != "blocks/"+pending.Key.String()+".bin" {
+				return imported, fmt.Errorf("diskstore: import: %s has no metadata entry", hdr.Name)
+			}
+			if len(body) != pending.SizeBytes {
+				return imported, fmt.Errorf("diskstore: import %s: got %d bytes, want %d", hdr.Name, len(body), pending.SizeBytes)
+			}
+			key := pending.Key
+			if seq >= 0 {
+				key.Seq = seq
+			}
+			if err := s.Put(key, pending.DTypeStr, pending.Shape, body); err != nil {
+				return imported, err
+			}
+			pending = nil
+			imported++
+		}
+	}
+
+	if manifest == nil {
+		return 0, fmt.Errorf("diskstore: import: empty archive")
+	}
+	if imported != manifest.Blocks {
+		return imported, fmt.Errorf("diskstore: import: archive truncated, got %d of %d blocks", imported, manifest.Blocks)
+	}
+	s.log.Info("imported sequence", "from", manifest.Seq, "to", seq, "blocks", imported)
+	return imported, nil
+}
+
+func writeTarEntry(tw *tar.Writer, name string, data []byte, mtime time.Time) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    int64(len(data)),
+		ModTime: mtime,
+	}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return fmt.Errorf("diskstore: export %s: %w", name, err)
+	}
+	if _, err := tw.Write(data); err != nil {
+		return fmt.Errorf("diskstore: export %s: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportImportSeq(t *testing.T) {
+	requireZstd(t)
+	src, err := New(Config{
+		LocalPath:   filepath.Join(t.TempDir(), "local"),
+		LocalBudget: 1024 * 1024,
+		Compress:    true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer src.Close()
+
+	payload := func(layer int, pos int32) []byte {
+		return bytes.Repeat([]byte{byte(layer), byte(pos)}, 64)
+	}
+	for layer := 0; layer < 2; layer++ {
+		for pos := int32(0); pos < 4; pos++ {
+			for _, isKey := range []bool{true, false} {
+				key := BlockKey{Seq: 3, Layer: layer, BeginPos: pos, EndPos: pos + 1, IsKey: isKey}
+				src.Put(key, "f16", []int{128, 8}, payload(layer, pos))
+			}
+		}
+	}
+	// A block from another sequence must not leak into the export.
+	src.Put(BlockKey{Seq: 4, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}, "f16", []int{128}, make([]byte, 8))
+
+	var buf bytes.Buffer
+	n, err := src.ExportSeq(3, &buf)
+	if err != nil || n != 16 {
+		t.Fatalf("ExportSeq: %d blocks, err %v", n, err)
+	}
+
+	dst, err := New(Config{
+		LocalPath:   filepath.Join(t.TempDir(), "local"),
+		LocalBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer dst.Close()
+
+	n, err = dst.ImportSeqAs(bytes.NewReader(buf.Bytes()), 9)
+	if err != nil || n != 16 {
+		t.Fatalf("ImportSeqAs: %d blocks, err %v", n, err)
+	}
+	got, meta, err := dst.Get(BlockKey{Seq: 9, Layer: 1, BeginPos: 2, EndPos: 3, IsKey: false})
+	if err != nil || meta == nil {
+		t.Fatalf("Get imported block: %v", err)
+	}
+	if !bytes.Equal(got, payload(1, 2)) {
+		t.Error("imported block payload mismatch")
+	}
+	if meta.Compressed {
+		t.Error("imported block should use the destination store's pipeline")
+	}
+
+	// Truncated archives are rejected.
+	if _, err := dst.ImportSeq(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Error("ImportSeq: expected error for truncated archive")
+	}
+}
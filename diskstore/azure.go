@@ -0,0 +1,279 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Azure Blob Storage is reached through its REST API, which keeps custom
+// metadata in x-ms-meta- headers, authenticated with the storage
+// account's Shared Key or a SAS token.
+const (
+	azureVersion    = "2021-08-06"
+	azureMetaPrefix = "X-Ms-Meta-"
+)
+
+// AzureConfig configures an AzureTier.
+type AzureConfig struct {
+	Account   string
+	Container string
+	Prefix    string // prepended to blob names, e.g. "kv/node1"
+
+	// Key is the storage account key (base64) requests are signed with.
+	// Without one, SAS, a shared access signature token, is appended to
+	// every request instead.
+	Key string
+	SAS string
+
+	// Endpoint replaces https://<account>.blob.core.windows.net, e.g.
+	// for Azurite.
+	Endpoint string
+
+	Timeout time.Duration // per request (default 30s)
+}
+
+// AzureTier keeps blocks in an Azure Blob Storage container. It
+// implements Tier, so it can serve as Config.RemoteTier, and Pinger.
+type AzureTier struct {
+	account string
+	base    string // endpoint and container
+	prefix  string
+	key     []byte
+	sas     url.Values
+	client  *http.Client
+}
+
+// NewAzureTier returns a tier storing blocks in cfg.Container.
+func NewAzureTier(cfg AzureConfig) (*AzureTier, error) {
+	if cfg.Account == "" || cfg.Container == "" {
+		return nil, fmt.Errorf("diskstore: azure tier: need an account and a container")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = "https://" + cfg.Account + ".blob.core.windows.net"
+	}
+	t := &AzureTier{
+		account: cfg.Account,
+		base:    strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(cfg.Container),
+		prefix:  strings.Trim(cfg.Prefix, "/"),
+		client:  newObjectClient(cfg.Timeout),
+	}
+	switch {
+	case cfg.Key != "":
+		key, err := base64.StdEncoding.DecodeString(cfg.Key)
+		if err != nil {
+			return nil, fmt.Errorf("diskstore: azure tier: account key: %w", err)
+		}
+		t.key = key
+	case cfg.SAS != "":
+		sas, err := url.ParseQuery(strings.TrimPrefix(cfg.SAS, "?"))
+		if err != nil {
+			return nil, fmt.Errorf("diskstore: azure tier: SAS token: %w", err)
+		}
+		t.sas = sas
+	default:
+		return nil, fmt.Errorf("diskstore: azure tier: need an account key or a SAS token")
+	}
+	return t, nil
+}
+
+// Put stores a block as a block blob.
+func (t *AzureTier) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	req, err := t.request(context.Background(), http.MethodPut, objectName(t.prefix, key), data, func(h http.Header) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("X-Ms-Blob-Type", "BlockBlob")
+		h.Set(azureMetaPrefix+objectMetaDType, dtype)
+		h.Set(azureMetaPrefix+objectMetaShape, formatShape(shape))
+	})
+	if err != nil {
+		return fmt.Errorf("diskstore: azure put %s: %w", key, err)
+	}
+	resp, err := doHTTP(t.client, req)
+	if err != nil {
+		return fmt.Errorf("diskstore: azure put %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get fetches a block. A missing block returns an error wrapping
+// ErrNotFound, like Store.Get.
+func (t *AzureTier) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, giving up when ctx is done.
+func (t *AzureTier) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	req, err := t.request(ctx, http.MethodGet, objectName(t.prefix, key), nil, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: azure get %s: %w", key, err)
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("diskstore: azure get %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: azure get %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: azure get %s: %w", key, err)
+	}
+	meta, err := objectBlock(key, resp.Header, azureMetaPrefix, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: azure get %s: %w", key, err)
+	}
+	return data, meta, nil
+}
+
+// Has reports whether the container holds key. Transport errors report
+// false.
+func (t *AzureTier) Has(key BlockKey) bool {
+	req, err := t.request(context.Background(), http.MethodHead, objectName(t.prefix, key), nil, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := doHTTP(t.client, req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// Delete removes a block's blob. Deleting a missing block is not an
+// error.
+func (t *AzureTier) Delete(key BlockKey) error {
+	req, err := t.request(context.Background(), http.MethodDelete, objectName(t.prefix, key), nil, nil)
+	if err != nil {
+		return fmt.Errorf("diskstore: azure delete %s: %w", key, err)
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: azure delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ping checks that the container is reachable with the tier's
+// credentials.
+func (t *AzureTier) Ping(ctx context.Context) error {
+	req, err := t.request(ctx, http.MethodHead, path.Join(t.prefix, objectProbe), nil, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request returns an authorized request for the blob name, with the
+// headers set sets.
+func (t *AzureTier) request(ctx context.Context, method, name string, body []byte, set func(http.Header)) (*http.Request, error) {
+	u, err := url.Parse(t.base + "/" + escapeObjectPath(name))
+	if err != nil {
+		return nil, err
+	}
+	if t.sas != nil {
+		u.RawQuery = t.sas.Encode()
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Ms-Version", azureVersion)
+	req.Header.Set("X-Ms-Date", time.Now().UTC().Format(http.TimeFormat))
+	if set != nil {
+		set(req.Header)
+	}
+	if t.key != nil {
+		req.Header.Set("Authorization", "SharedKey "+t.account+":"+t.sign(req, len(body)))
+	}
+	return req, nil
+}
+
+// sign returns the Shared Key signature of req, whose body is n bytes.
+func (t *AzureTier) sign(req *http.Request, n int) string {
+	length := ""
+	if n > 0 {
+		length = strconv.Itoa(n)
+	}
+	h := req.Header
+	var b strings.Builder
+	for _, v := range []string{
+		req.Method,
+		h.Get("Content-Encoding"),
+		h.Get("Content-Language"),
+		length,
+		h.Get("Content-Md5"),
+		h.Get("Content-Type"),
+		"", // Date: x-ms-date is signed instead
+		h.Get("If-Modified-Since"),
+		h.Get("If-Match"),
+		h.Get("If-None-Match"),
+		h.Get("If-Unmodified-Since"),
+		h.Get("Range"),
+	} {
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+
+	// Canonicalized headers: every x-ms- header, lowercased and sorted.
+	var names []string
+	for name := range h {
+		if lower := strings.ToLower(name); strings.HasPrefix(lower, "x-ms-") {
+			names = append(names, lower)
+		}
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		b.WriteString(name + ":" + strings.TrimSpace(h.Get(name)) + "\n")
+	}
+
+	// Canonicalized resource: the account, the path and the query
+	// parameters, sorted.
+	b.WriteString("/" + t.account + req.URL.EscapedPath())
+	q := req.URL.Query()
+	params := make([]string, 0, len(q))
+	for name := range q {
+		params = append(params, name)
+	}
+	sort.Strings(params)
+	for _, name := range params {
+		vals := append([]string(nil), q[name]...)
+		sort.Strings(vals)
+		b.WriteString("\n" + strings.ToLower(name) + ":" + strings.Join(vals, ","))
+	}
+
+	mac := hmac.New(sha256.New, t.key)
+	mac.Write([]byte(b.String()))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,80 @@
+package diskstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAzureTier(t *testing.T) {
+	const key = "c2VjcmV0IGFjY291bnQga2V5"
+	verifier, err := NewAzureTier(AzureConfig{Account: "acct", Container: "cache", Key: key})
+	if err != nil {
+		t.Fatalf("NewAzureTier: %v", err)
+	}
+	_, srv := newFakeObjects(t, azureMetaPrefix, func(r *http.Request) bool {
+		want := "SharedKey acct:" + verifier.sign(r, int(r.ContentLength))
+		return r.Header.Get("Authorization") == want && r.Header.Get("X-Ms-Version") != "" &&
+			strings.HasPrefix(r.URL.Path, "/cache/kv/") &&
+			(r.Method != http.MethodPut || r.Header.Get("X-Ms-Blob-Type") == "BlockBlob")
+	})
+	tier, err := NewAzureTier(AzureConfig{Account: "acct", Container: "cache", Prefix: "kv", Key: key, Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewAzureTier: %v", err)
+	}
+	testServiceTier(t, tier)
+
+	// A request signed with another key is refused.
+	wrong, _ := NewAzureTier(AzureConfig{Account: "acct", Container: "cache", Prefix: "kv", Key: "b3RoZXI=", Endpoint: srv.URL})
+	if err := wrong.Put(BlockKey{Seq: 1}, "f16", nil, []byte("x")); err == nil {
+		t.Error("Put with the wrong key succeeded")
+	}
+
+	if _, err := NewAzureTier(AzureConfig{Account: "acct", Container: "cache"}); err == nil {
+		t.Error("NewAzureTier without credentials succeeded")
+	}
+	if _, err := NewAzureTier(AzureConfig{Account: "acct", Container: "cache", Key: "not base64!"}); err == nil {
+		t.Error("NewAzureTier with a malformed key succeeded")
+	}
+}
+
+func TestAzureTierSAS(t *testing.T) {
+	_, srv := newFakeObjects(t, azureMetaPrefix, func(r *http.Request) bool {
+		q := r.URL.Query()
+		return r.Header.Get("Authorization") == "" && q.Get("sig") == "a/b+c=" && q.Get("sp") == "rwd"
+	})
+	tier, err := NewAzureTier(AzureConfig{Account: "acct", Container: "cache", SAS: "?sv=2021-08-06&sp=rwd&sig=a%2Fb%2Bc%3D", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewAzureTier: %v", err)
+	}
+	testServiceTier(t, tier)
+}
+
+func TestAzureSharedKey(t *testing.T) {
+	tier, err := NewAzureTier(AzureConfig{Account: "acct", Container: "cache", Key: "a2V5"})
+	if err != nil {
+		t.Fatalf("NewAzureTier: %v", err)
+	}
+	req, err := tier.request(context.Background(), http.MethodPut, "00/b.kvblk", []byte("12345"), func(h http.Header) {
+		h.Set("Content-Type", "application/octet-stream")
+		h.Set("X-Ms-Blob-Type", "BlockBlob")
+		h.Set(azureMetaPrefix+objectMetaDType, "f16")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("X-Ms-Date", "Fri, 16 Oct 2026 00:00:00 GMT")
+	want := "PUT\n\n\n5\n\napplication/octet-stream\n\n\n\n\n\n\n" +
+		"x-ms-blob-type:BlockBlob\nx-ms-date:Fri, 16 Oct 2026 00:00:00 GMT\n" +
+		"x-ms-meta-kvdtype:f16\nx-ms-version:" + azureVersion + "\n" +
+		"/acct/cache/00/b.kvblk"
+	mac := hmac.New(sha256.New, []byte("key"))
+	mac.Write([]byte(want))
+	if got := tier.sign(req, 5); got != base64.StdEncoding.EncodeToString(mac.Sum(nil)) {
+		t.Errorf("signature doesn't match the string to sign\n%s", want)
+	}
+}
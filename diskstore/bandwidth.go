@@ -0,0 +1,119 @@
+package diskstore
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Remote bandwidth: a remote tier on a network share is shared with
+// other services, and an eviction storm or an archive pass can saturate
+// it. Config.RemoteBandwidth caps the bytes per second read from and
+// written to the remote tier; MigrationBandwidth and RestoreBandwidth
+// cap the two kinds of transfer on their own, so background moves can be
+// held well below what a restore someone is waiting for may use.
+// Transfers wait before they start until their bytes fit under every
+// limit that applies, after allowing a burst of up to bandwidthBurst.
+//
+// Moves run under the store's lock, so a throttled eviction also delays
+// the Put that needed the room; that is the back-pressure a full local
+// tier on a slow share calls for.
+
+// ioClass is the kind of a remote-tier transfer.
+type ioClass int
+
+const (
+	// restoreIO is a read a caller waits for: Get, ReadRange, read
+	// repair.
+	restoreIO ioClass = iota
+	// migrationIO is a background or administrative transfer:
+	// eviction, promotion, Migrate, archiving, Verify.
+	migrationIO
+)
+
+// bandwidthBurst is how far ahead of its rate a limiter lets transfers
+// run after being idle.
+const bandwidthBurst = 100 * time.Millisecond
+
+// rateLimiter paces transfers to a rate in bytes per second. It tracks
+// when the bytes reserved so far will have been sent at that rate.
+type rateLimiter struct {
+	rate float64 // 0 is unlimited
+	sent time.Time
+}
+
+// reserve takes n bytes and returns how long to wait before sending them.
+// Must be called with the bandwidth's lock held.
+func (l *rateLimiter) reserve(now time.Time, n int) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+	if l.sent.Before(now) {
+		l.sent = now
+	}
+	l.sent = l.sent.Add(time.Duration(float64(n) / l.rate * float64(time.Second)))
+	return max(l.sent.Sub(now)-bandwidthBurst, 0)
+}
+
+// bandwidth holds the remote tier's limits.
+type bandwidth struct {
+	mu     sync.Mutex
+	total  rateLimiter
+	class  [2]rateLimiter // by ioClass
+	waited time.Duration
+}
+
+func (b *bandwidth) init(cfg Config) {
+	b.set(cfg.RemoteBandwidth, cfg.RestoreBandwidth, cfg.MigrationBandwidth)
+}
+
+// set changes the limits, in bytes per second; transfers already
+// waiting keep their wait.
+func (b *bandwidth) set(total, restore, migration int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.total.rate = float64(max(total, 0))
+	b.class[restoreIO].rate = float64(max(restore, 0))
+	b.class[migrationIO].rate = float64(max(migration, 0))
+}
+
+// limits returns the limits set.
+func (b *bandwidth) limits() (total, restore, migration int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return int64(b.total.rate), int64(b.class[restoreIO].rate), int64(b.class[migrationIO].rate)
+}
+
+// wait blocks until n more bytes of class may be transferred, or until
+// ctx is done. The bytes stay reserved either way.
+func (b *bandwidth) wait(ctx context.Context, class ioClass, n int) error {
+	b.mu.Lock()
+	now := time.Now()
+	d := max(b.total.reserve(now, n), b.class[class].reserve(now, n))
+	b.waited += d
+	b.mu.Unlock()
+	if d > 0 {
+		return sleepContext(ctx, d)
+	}
+	return nil
+}
+
+func (b *bandwidth) stats() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.waited
+}
+
+// throttle waits for the bandwidth limits before n bytes of class are
+// transferred to or from tier. Only the remote tier is limited.
+func (s *Store) throttle(tier string, class ioClass, n int) {
+	s.throttleContext(context.Background(), tier, class, n)
+}
+
+// throttleContext is throttle giving up when ctx is done.
+func (s *Store) throttleContext(ctx context.Context, tier string, class ioClass, n int) error {
+	if tier == "remote" {
+		return s.bw.wait(ctx, class, n)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	l := rateLimiter{rate: 1000}
+	now := time.Now()
+	if d := l.reserve(now, 100); d != 0 {
+		t.Errorf("first 100ms of bytes: wait %v, want none (burst)", d)
+	}
+	if d := l.reserve(now, 500); d != 500*time.Millisecond {
+		t.Errorf("next 500 bytes: wait %v, want 500ms", d)
+	}
+	// Idle time is not saved up beyond the burst.
+	if d := l.reserve(now.Add(10*time.Second), 200); d != 100*time.Millisecond {
+		t.Errorf("after idling: wait %v, want 100ms", d)
+	}
+	if d := (&rateLimiter{}).reserve(now, 1<<30); d != 0 {
+		t.Errorf("unlimited: wait %v", d)
+	}
+}
+
+func TestMigrationBandwidth(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:          filepath.Join(dir, "local"),
+		RemotePath:         filepath.Join(dir, "remote"),
+		LocalBudget:        1024 * 1024,
+		RemoteBudget:       1024 * 1024,
+		MigrationBandwidth: 100 * 1024,
+		StatsInterval:      -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	// Copy as across filesystems; renames cost no bandwidth.
+	store.renameMoves.Store(false)
+
+	data := bytes.Repeat([]byte{7}, 10*1024)
+	for i := range 4 {
+		key := BlockKey{Seq: 1, Layer: i, BeginPos: 0, EndPos: 4, IsKey: true}
+		if err := store.Put(key, "f16", []int{4}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// 40 KiB at 100 KiB/s, less the burst.
+	start := time.Now()
+	if n, err := store.Migrate(1, "remote"); err != nil || n != 4 {
+		t.Fatalf("Migrate: %d, %v", n, err)
+	}
+	if d := time.Since(start); d < 250*time.Millisecond {
+		t.Errorf("Migrate of 40 KiB took %v, want about 300ms", d)
+	}
+	throttled := store.Stats().RemoteThrottled
+	if throttled < 250*time.Millisecond {
+		t.Errorf("RemoteThrottled = %v after Migrate", throttled)
+	}
+
+	// Restores have no limit of their own.
+	for i := range 4 {
+		key := BlockKey{Seq: 1, Layer: i, BeginPos: 0, EndPos: 4, IsKey: true}
+		if got, _, err := store.Get(key); err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Get: %v", err)
+		}
+	}
+	if got := store.Stats().RemoteThrottled; got != throttled {
+		t.Errorf("Get waited %v for a migration limit", got-throttled)
+	}
+}
@@ -0,0 +1,217 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Batched reads: a restore reads the same positions of every layer and
+// half, each from block files of its own. ReadRanges reads all the block
+// files its ranges need in one batch before assembling them. Built with
+// the iouring tag on Linux the batch is submitted to an io_uring (see
+// uring_linux.go), so thousands of small reads cost a handful of system
+// calls; elsewhere, or where the kernel refuses io_uring, the files are
+// read one after another. Blocks on the remote tier, which has its own
+// throttling and retries, and everything when mapped reads are on, are
+// read as by Get, or in part (see partialread.go).
+
+// RangeResult is what ReadRange returns for one key of ReadRanges.
+type RangeResult struct {
+	Data []byte
+	End  int32
+	Err  error
+}
+
+// ReadRanges is ReadRange for each of keys, reading the block files they
+// need in one batch.
+func (s *Store) ReadRanges(keys []BlockKey) []RangeResult {
+	return s.ReadRangesContext(context.Background(), keys)
+}
+
+// ReadRangesContext is ReadRanges giving up when ctx is done, as
+// GetContext: a range then stops at the first block it could not read in
+// time, with an error wrapping ctx.Err().
+func (s *Store) ReadRangesContext(ctx context.Context, keys []BlockKey) []RangeResult {
+	out := make([]RangeResult, len(keys))
+	plans := make([][]BlockMeta, len(keys))
+	var cost int64
+	for i, key := range keys {
+		out[i].End = key.BeginPos
+		if key.EndPos <= key.BeginPos {
+			continue
+		}
+		cover := s.rangeCover(key)
+		cost += rangeCost(cover, key)
+		plans[i] = planRange(cover, key)
+	}
+	parts := s.planPartial(keys, plans)
+
+	// The blocks to read, once each, the files among them to read up
+	// front, and the memory they hold until assembled.
+	var blocks, files []*BlockMeta
+	seen := make(map[string]bool)
+	for _, plan := range plans {
+		for j := range plan {
+			if meta := &plan[j]; parts[meta] == nil && !seen[meta.Key.String()] {
+				seen[meta.Key.String()] = true
+				blocks = append(blocks, meta)
+			}
+		}
+	}
+	for _, meta := range blocks {
+		if !s.mmap.enabled() && meta.Tier == "local" && meta.Bundle == nil {
+			files = append(files, meta)
+			cost += int64(storedSize(meta))
+		}
+	}
+	parallel := s.decodeJobs != nil && len(blocks) > 1
+	if parallel {
+		for _, meta := range blocks {
+			cost += int64(meta.SizeBytes)
+		}
+	}
+	err := ctx.Err()
+	if err == nil {
+		err = s.reads.acquire(ctx, cost)
+	}
+	if err != nil {
+		for i, key := range keys {
+			if len(plans[i]) > 0 {
+				out[i].Err = fmt.Errorf("diskstore: read range %s: %w", key, err)
+			}
+		}
+		return out
+	}
+	defer s.reads.release(cost)
+
+	parts.fetch(ctx, s)
+	payloads := make(map[string][]byte, len(files))
+	var per time.Duration
+	if len(files) > 0 {
+		start := time.Now()
+		data, errs := s.ring.read(s, files)
+		per = time.Since(start) / time.Duration(len(files))
+		for i, meta := range files {
+			if errs[i] == nil {
+				payloads[meta.Key.String()] = data[i]
+			}
+		}
+	}
+	defer func() {
+		for _, p := range payloads {
+			putBuf(p) // not used, e.g. after an error earlier in the range
+		}
+	}()
+
+	if parallel {
+		return s.assembleDecoded(ctx, keys, plans, blocks, payloads, parts, per, out)
+	}
+
+	// Blocks are decoded into one scratch buffer, the rows wanted copied out.
+	scratch := getBuf(0)
+	defer func() { putBuf(scratch) }()
+	read := parts.wrap(func(meta *BlockMeta) ([]byte, error) {
+		k := meta.Key.String()
+		payload, ok := payloads[k]
+		delete(payloads, k)
+		data, err := s.decodeRead(ctx, meta, payload, ok, per, scratch)
+		if data != nil {
+			scratch = data
+		}
+		return data, err
+	})
+	for i, key := range keys {
+		out[i].Data, out[i].End, out[i].Err = assemble(key, plans[i], read)
+	}
+	return out
+}
+
+// assembleDecoded is the end of ReadRanges with decode workers: blocks
+// are decoded on the workers, each into a buffer of its own, then
+// assembled into out.
+func (s *Store) assembleDecoded(ctx context.Context, keys []BlockKey, plans [][]BlockMeta, blocks []*BlockMeta, payloads map[string][]byte, parts partialReads, per time.Duration, out []RangeResult) []RangeResult {
+	type decoded struct {
+		data []byte
+		err  error
+	}
+	results := make([]decoded, len(blocks))
+	index := make(map[string]int, len(blocks))
+	jobs := make([]func(), len(blocks))
+	for i, meta := range blocks {
+		k := meta.Key.String()
+		index[k] = i
+		payload, ok := payloads[k]
+		delete(payloads, k)
+		jobs[i] = func() {
+			data, err := s.decodeRead(ctx, meta, payload, ok, per, getBuf(meta.SizeBytes)[:0])
+			results[i] = decoded{data, err}
+		}
+	}
+	s.decodeAll(jobs)
+	defer func() {
+		for _, r := range results {
+			putBuf(r.data)
+		}
+	}()
+
+	read := parts.wrap(func(meta *BlockMeta) ([]byte, error) {
+		r := results[index[meta.Key.String()]]
+		return r.data, r.err
+	})
+	for i, key := range keys {
+		out[i].Data, out[i].End, out[i].Err = assemble(key, plans[i], read)
+	}
+	return out
+}
+
+// decodeRead decodes a payload of meta read by the batch into dst, or
+// reads the block as Get does if the batch has no intact copy of it
+// (which also repairs it, see readVerified).
+func (s *Store) decodeRead(ctx context.Context, meta *BlockMeta, payload []byte, ok bool, d time.Duration, dst []byte) ([]byte, error) {
+	if !ok || s.pastTTL(meta, time.Now()) || !s.verify(meta, payload) {
+		putBuf(payload)
+		data, _, err := s.get(ctx, meta.Key, false, dst)
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil // removed since the index scan
+		}
+		return data, err
+	}
+	if err := s.fingerprintFor(meta.Key.Namespace).checkBlock(meta.Key, meta.DTypeStr, meta.Shape); err != nil {
+		return nil, err
+	}
+	start := time.Now()
+	data, err := s.decode(meta, payload, dst)
+	if err != nil {
+		return nil, err
+	}
+	s.countRead(meta, len(data), d+time.Since(start))
+	return data, nil
+}
+
+// readEach reads the local payload files of metas one after another. The
+// payloads are pooled buffers.
+func (s *Store) readEach(metas []*BlockMeta) ([][]byte, []error) {
+	data := make([][]byte, len(metas))
+	errs := make([]error, len(metas))
+	for i, meta := range metas {
+		data[i], errs[i] = s.readLocal(s.metaPath(meta))
+	}
+	return data, errs
+}
+
+// openBlock opens meta's local block file for reading, with O_DIRECT if
+// the local tier uses direct I/O, and reports whether it did.
+func (s *Store) openBlock(meta *BlockMeta) (*os.File, bool, error) {
+	path := s.metaPath(meta)
+	if s.directEnabled() {
+		f, err := os.OpenFile(path, os.O_RDONLY|oDirect, 0)
+		if !s.directRefused(err) {
+			return f, err == nil, err
+		}
+	}
+	f, err := os.Open(path)
+	return f, false, err
+}
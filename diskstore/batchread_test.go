@@ -0,0 +1,67 @@
+package diskstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRanges(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Two layers of two blocks of four positions, one byte per row.
+	key := func(layer int, begin, end int32) BlockKey {
+		return BlockKey{Seq: 1, Layer: layer, BeginPos: begin, EndPos: end, IsKey: true}
+	}
+	rows := func(layer int) []byte {
+		return []byte{byte(layer), 1, 2, 3, 4, 5, 6, 7}
+	}
+	for layer := range 2 {
+		if err := store.Put(key(layer, 0, 4), "f16", []int{1}, rows(layer)[:4]); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		if err := store.Put(key(layer, 4, 8), "f16", []int{1}, rows(layer)[4:]); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Corrupt layer 1's second block, leaving an intact copy on the
+	// remote tier for the batch to fall back to.
+	bad := key(1, 4, 8)
+	remote := store.blockPath(bad, "remote", 0)
+	os.MkdirAll(filepath.Dir(remote), 0755)
+	if err := os.WriteFile(remote, rows(1)[4:], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(store.blockPath(bad, "local", 0), []byte("xxxx"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	keys := []BlockKey{key(0, 2, 8), key(1, 0, 8), key(2, 0, 8), key(0, 3, 3)}
+	want := []RangeResult{
+		{Data: rows(0)[2:], End: 8},
+		{Data: rows(1), End: 8},
+		{End: 0},
+		{End: 3},
+	}
+	for i, got := range store.ReadRanges(keys) {
+		if got.Err != nil || !bytes.Equal(got.Data, want[i].Data) || got.End != want[i].End {
+			t.Errorf("range %s: got %v end %d, %v; want %v end %d", keys[i], got.Data, got.End, got.Err, want[i].Data, want[i].End)
+		}
+	}
+	if n := store.Stats().ReadRepairs; n != 1 {
+		t.Errorf("ReadRepairs = %d, want 1", n)
+	}
+}
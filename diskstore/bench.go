@@ -0,0 +1,216 @@
+package diskstore
+
+import (
+	"crypto/rand"
+	"fmt"
+	mrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"slices"
+	"time"
+)
+
+// Benchmarks: Bench writes and reads a set of blocks on each tier of the
+// store, in position order and shuffled, and times every operation, so
+// a new disk or mount can be checked against the prefill speed it has
+// to beat before the runner relies on it. Remote writes are timed as
+// the moves eviction makes. The blocks are random bytes, which codecs
+// can't shrink, and live in their own namespace for the run; reads
+// right after the writes are likely served from the page cache, so
+// local read rates are an upper bound.
+
+// benchNamespace holds Bench's blocks while it runs.
+const benchNamespace = "bench.tmp"
+
+// BenchOptions configures Bench.
+type BenchOptions struct {
+	// BlockBytes is the size of each block (default 512 KiB: 256
+	// positions of one layer's keys for 8 KV heads of 128 f16).
+	BlockBytes int
+
+	// Blocks is how many blocks each phase writes or reads (default 64).
+	Blocks int
+}
+
+// BenchPhase is the timing of one phase of Bench.
+type BenchPhase struct {
+	Name     string        `json:"name"`
+	Ops      int           `json:"ops"`
+	Bytes    int64         `json:"bytes"`
+	Duration time.Duration `json:"duration_ns"`
+	P50      time.Duration `json:"p50_ns"`
+	P99      time.Duration `json:"p99_ns"`
+}
+
+// Throughput returns the phase's bytes per second.
+func (p BenchPhase) Throughput() float64 {
+	if p.Duration <= 0 {
+		return 0
+	}
+	return float64(p.Bytes) / p.Duration.Seconds()
+}
+
+// BenchTier is the phases Bench ran on one tier.
+type BenchTier struct {
+	Tier   string       `json:"tier"`
+	Phases []BenchPhase `json:"phases"`
+}
+
+// Phase returns the tier's phase called name, or a zero phase.
+func (t BenchTier) Phase(name string) BenchPhase {
+	for _, p := range t.Phases {
+		if p.Name == name {
+			return p
+		}
+	}
+	return BenchPhase{}
+}
+
+// BenchResult is what Bench measured.
+type BenchResult struct {
+	BlockBytes int         `json:"block_bytes"`
+	Blocks     int         `json:"blocks"`
+	Tiers      []BenchTier `json:"tiers"`
+}
+
+// Bench phase names.
+const (
+	BenchSeqWrite    = "seq write"
+	BenchRandomWrite = "random write"
+	BenchSeqRead     = "seq read"
+	BenchRandomRead  = "random read"
+)
+
+// Bench measures write and read throughput and latency on the local
+// tier and, if there is one, the remote tier. It refuses to run when a
+// tier lacks the room for the blocks, rather than evict stored ones, and
+// removes its blocks before returning.
+func (s *Store) Bench(opts BenchOptions) (BenchResult, error) {
+	if err := s.writable(); err != nil {
+		return BenchResult{}, err
+	}
+	if opts.BlockBytes <= 0 {
+		opts.BlockBytes = 512 << 10
+	}
+	if opts.Blocks <= 0 {
+		opts.Blocks = 64
+	}
+	res := BenchResult{BlockBytes: opts.BlockBytes, Blocks: opts.Blocks}
+
+	// Every block is written again before the first copy is released.
+	need := int64(opts.BlockBytes) * int64(opts.Blocks+1)
+	s.mu.RLock()
+	localFree, remoteFree := s.localBudget-s.localUsed, s.remoteBudget-s.remoteUsed
+	s.mu.RUnlock()
+	if need > localFree || s.hasRemote() && need > remoteFree {
+		return res, fmt.Errorf("%w: bench needs %d bytes free on each tier", ErrBudgetExceeded, need)
+	}
+
+	keys := make([]BlockKey, opts.Blocks)
+	for i := range keys {
+		keys[i] = BlockKey{Namespace: benchNamespace, Layer: i, EndPos: 1, IsKey: true}
+	}
+	defer func() {
+		s.mu.Lock()
+		s.dropBlocks(func(meta *BlockMeta) bool { return meta.Key.Namespace == benchNamespace })
+		s.mu.Unlock()
+		for _, base := range []string{s.localPath, s.remotePath} {
+			if base != "" {
+				dir := nsPath(base, benchNamespace)
+				os.RemoveAll(dir)
+				os.Remove(filepath.Dir(dir)) // if no other namespace is stored
+			}
+		}
+	}()
+	data := make([]byte, opts.BlockBytes)
+	rand.Read(data)
+	shuffled := slices.Clone(keys)
+	mrand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+
+	put := func(key BlockKey) error { return s.Put(key, "f16", nil, data) }
+	get := func(key BlockKey) error {
+		_, _, err := s.Get(key)
+		return err
+	}
+	move := func(tier string) func(BlockKey) error {
+		return func(key BlockKey) error {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			meta, ok := s.index[key.String()]
+			if !ok {
+				return fmt.Errorf("%w: %s", ErrNotFound, key)
+			}
+			_, err := s.moveBlock(meta, tier)
+			return err
+		}
+	}
+
+	type step struct {
+		name string
+		keys []BlockKey
+		op   func(BlockKey) error
+	}
+	run := func(t *BenchTier, steps ...step) error {
+		for _, st := range steps {
+			phase, err := s.benchPhase(st.name, st.keys, int64(opts.BlockBytes), st.op)
+			if err != nil {
+				return err
+			}
+			t.Phases = append(t.Phases, phase)
+		}
+		return nil
+	}
+
+	local := BenchTier{Tier: "local"}
+	err := run(&local,
+		step{BenchSeqWrite, keys, put},
+		step{BenchRandomWrite, shuffled, put},
+		step{BenchSeqRead, keys, get},
+		step{BenchRandomRead, shuffled, get})
+	if err != nil {
+		return res, err
+	}
+	res.Tiers = append(res.Tiers, local)
+	if !s.hasRemote() {
+		return res, nil
+	}
+
+	// The random writes need the blocks back on the local tier first.
+	remote := BenchTier{Tier: "remote"}
+	if err := run(&remote, step{BenchSeqWrite, keys, move("remote")}); err != nil {
+		return res, err
+	}
+	for _, key := range keys {
+		if err := move("local")(key); err != nil {
+			return res, fmt.Errorf("diskstore: bench: %w", err)
+		}
+	}
+	err = run(&remote,
+		step{BenchRandomWrite, shuffled, move("remote")},
+		step{BenchSeqRead, keys, get},
+		step{BenchRandomRead, shuffled, get})
+	if err != nil {
+		return res, err
+	}
+	res.Tiers = append(res.Tiers, remote)
+	return res, nil
+}
+
+// benchPhase runs op on each key in turn and times it.
+func (s *Store) benchPhase(name string, keys []BlockKey, size int64, op func(BlockKey) error) (BenchPhase, error) {
+	phase := BenchPhase{Name: name, Ops: len(keys), Bytes: size * int64(len(keys))}
+	latencies := make([]time.Duration, 0, len(keys))
+	start := time.Now()
+	for _, key := range keys {
+		t := time.Now()
+		if err := op(key); err != nil {
+			return phase, fmt.Errorf("diskstore: bench %s: %w", name, err)
+		}
+		latencies = append(latencies, time.Since(t))
+	}
+	phase.Duration = time.Since(start)
+	slices.Sort(latencies)
+	phase.P50 = latencies[len(latencies)/2]
+	phase.P99 = latencies[len(latencies)*99/100]
+	return phase, nil
+}
@@ -0,0 +1,58 @@
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBench(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	kept := BlockKey{Seq: 1, EndPos: 1, IsKey: true}
+	store.Put(kept, "f16", []int{8}, make([]byte, 16))
+
+	res, err := store.Bench(BenchOptions{BlockBytes: 4096, Blocks: 8})
+	if err != nil {
+		t.Fatalf("Bench: %v", err)
+	}
+	if len(res.Tiers) != 2 || res.Tiers[0].Tier != "local" || res.Tiers[1].Tier != "remote" {
+		t.Fatalf("tiers = %+v", res.Tiers)
+	}
+	for _, tier := range res.Tiers {
+		for _, name := range []string{BenchSeqWrite, BenchRandomWrite, BenchSeqRead, BenchRandomRead} {
+			p := tier.Phase(name)
+			if p.Ops != 8 || p.Bytes != 8*4096 || p.Throughput() <= 0 || p.P99 < p.P50 {
+				t.Errorf("%s %s = %+v", tier.Tier, name, p)
+			}
+		}
+	}
+
+	// Only the block stored before is left.
+	st := store.Stats()
+	if st.LocalBlocks+st.RemoteBlocks != 1 || st.RemoteBlocks != 0 {
+		t.Errorf("after bench: %d local, %d remote blocks; want the 1 stored before", st.LocalBlocks, st.RemoteBlocks)
+	}
+	if _, _, err := store.Get(kept); err != nil {
+		t.Errorf("Get stored block: %v", err)
+	}
+	if _, err := os.Stat(nsPath(filepath.Join(dir, "local"), benchNamespace)); !os.IsNotExist(err) {
+		t.Errorf("bench namespace dir left behind: %v", err)
+	}
+
+	// Without the room, nothing is evicted.
+	if _, err := store.Bench(BenchOptions{BlockBytes: 512 << 10, Blocks: 4}); !errors.Is(err, ErrBudgetExceeded) {
+		t.Errorf("Bench over budget: %v, want ErrBudgetExceeded", err)
+	}
+}
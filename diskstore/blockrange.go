@@ -0,0 +1,176 @@
+package diskstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// ReadRange returns the rows for positions [key.BeginPos, key.EndPos) of
+// key's namespace, sequence, layer and half, assembled from whichever
+// blocks cover them: a larger stored block is sliced and several smaller
+// ones are concatenated. Restores therefore keep working when the
+// snapshot block size changed between runs.
+//
+// Rows are assumed to be equal-sized within a block (its payload divided
+// by the positions it spans). ReadRange returns the bytes of the longest
+// covered prefix and the position where coverage stops, which is
+// key.BeginPos if nothing is stored.
+func (s *Store) ReadRange(key BlockKey) ([]byte, int32, error) {
+	return s.ReadRangeContext(context.Background(), key)
+}
+
+// ReadRangeContext is ReadRange giving up when ctx is done (see
+// ReadRangesContext).
+func (s *Store) ReadRangeContext(ctx context.Context, key BlockKey) ([]byte, int32, error) {
+	r := s.ReadRangesContext(ctx, []BlockKey{key})[0]
+	return r.Data, r.End, r.Err
+}
+
+// planRange returns the blocks ReadRange reads for key out of cover, in
+// order: from key.BeginPos on, each time the block reaching furthest.
+func planRange(cover []BlockMeta, key BlockKey) []BlockMeta {
+	var plan []BlockMeta
+	for pos := key.BeginPos; pos < key.EndPos; {
+		meta := nextCover(cover, pos)
+		if meta == nil {
+			break
+		}
+		plan = append(plan, *meta)
+		pos = min(meta.Key.EndPos, key.EndPos)
+	}
+	return plan
+}
+
+// assemble returns the rows of key from the blocks planRange chose, read
+// through read, and the position where they stop.
+func assemble(key BlockKey, plan []BlockMeta, read func(meta *BlockMeta) ([]byte, error)) ([]byte, int32, error) {
+	var out []byte
+	rowSize := -1
+	pos := key.BeginPos
+	for i := range plan {
+		best := plan[i].Key
+		data, err := read(&plan[i])
+		if err != nil {
+			return out, pos, err
+		}
+		if data == nil {
+			break // removed since the index scan
+		}
+		rows := int(best.EndPos - best.BeginPos)
+		if len(data)%rows != 0 {
+			return out, pos, fmt.Errorf("diskstore: block %s: %d bytes do not split into %d rows", best, len(data), rows)
+		}
+		if rs := len(data) / rows; rowSize < 0 {
+			rowSize = rs
+		} else if rs != rowSize {
+			return out, pos, fmt.Errorf("diskstore: block %s: row size %d, earlier blocks have %d", best, rs, rowSize)
+		}
+
+		end := min(best.EndPos, key.EndPos)
+		from := int(pos-best.BeginPos) * rowSize
+		to := int(end-best.BeginPos) * rowSize
+		out = append(out, data[from:to]...)
+		pos = end
+	}
+	return out, pos, nil
+}
+
+// Span is a half-open range of positions, stored with one BlockMeta.Shift.
+type Span struct {
+	Begin, End int32
+	Shift      int32
+}
+
+// Coverage returns the runs of positions in [key.BeginPos, key.EndPos)
+// stored for key's namespace, sequence, layer and half, sorted and with
+// overlapping or adjoining blocks of the same shift merged. Unlike
+// ReadRange it reads only the index, and it reports every run, not just
+// the first.
+func (s *Store) Coverage(key BlockKey) []Span {
+	var out []Span
+	for _, meta := range s.rangeCover(key) {
+		sp := Span{max(meta.Key.BeginPos, key.BeginPos), min(meta.Key.EndPos, key.EndPos), meta.Shift}
+		if n := len(out); n > 0 && sp.Begin <= out[n-1].End && sp.Shift == out[n-1].Shift {
+			out[n-1].End = max(out[n-1].End, sp.End)
+			continue
+		}
+		out = append(out, sp)
+	}
+	return out
+}
+
+// HasRange is Coverage along with how many positions of the range its
+// runs cover, counting positions stored at several shifts once. A restore
+// planner learns from it in one index lookup how much of a range it could
+// read and how much it would recompute, without a Has per block.
+func (s *Store) HasRange(key BlockKey) ([]Span, int32) {
+	spans := s.Coverage(key)
+	var covered int32
+	end := key.BeginPos
+	for _, sp := range spans {
+		if from := max(sp.Begin, end); sp.End > from {
+			covered += sp.End - from
+			end = sp.End
+		}
+	}
+	return spans, covered
+}
+
+// rangeCover returns copies of the blocks of key's namespace, sequence,
+// layer and half overlapping its positions, by BeginPos and, among blocks
+// starting at the same position, the one reaching furthest first.
+func (s *Store) rangeCover(key BlockKey) []BlockMeta {
+	if !s.mayHaveGroup(key) {
+		return nil
+	}
+	s.mu.RLock()
+	var cover []BlockMeta
+	for _, meta := range s.index {
+		k := meta.Key
+		if k.Namespace == key.Namespace && k.Seq == key.Seq && k.Prefix == key.Prefix && k.Layer == key.Layer &&
+			k.IsKey == key.IsKey && k.BeginPos < key.EndPos && k.EndPos > key.BeginPos {
+			cover = append(cover, *meta)
+		}
+	}
+	s.mu.RUnlock()
+
+	sort.Slice(cover, func(i, j int) bool {
+		a, b := cover[i].Key, cover[j].Key
+		if a.BeginPos != b.BeginPos {
+			return a.BeginPos < b.BeginPos
+		}
+		return a.EndPos > b.EndPos
+	})
+	return cover
+}
+
+// nextCover returns the block in cover extending furthest past pos, or
+// nil if none covers it.
+func nextCover(cover []BlockMeta, pos int32) *BlockMeta {
+	var best *BlockMeta
+	for i := range cover {
+		m := &cover[i]
+		if m.Key.BeginPos > pos {
+			break
+		}
+		if m.Key.EndPos > pos && (best == nil || m.Key.EndPos > best.Key.EndPos) {
+			best = m
+		}
+	}
+	return best
+}
+
+// BlockSpans counts stored blocks by the number of positions they span.
+// More than one span, or one differing from the configured snapshot block
+// size, means the block size changed between runs.
+func (s *Store) BlockSpans() map[int32]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	spans := make(map[int32]int)
+	for _, meta := range s.index {
+		spans[meta.Key.EndPos-meta.Key.BeginPos]++
+	}
+	return spans
+}
@@ -0,0 +1,110 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReadRangeAcrossBlockSizes(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	const rowSize = 4
+	row := func(pos int) []byte { return bytes.Repeat([]byte{byte(pos)}, rowSize) }
+	rows := func(begin, end int) []byte {
+		var b []byte
+		for p := begin; p < end; p++ {
+			b = append(b, row(p)...)
+		}
+		return b
+	}
+	put := func(begin, end int32) {
+		key := BlockKey{Seq: 1, Layer: 0, BeginPos: begin, EndPos: end, IsKey: true}
+		if err := store.Put(key, "f16", []int{rowSize / 2}, rows(int(begin), int(end))); err != nil {
+			t.Fatalf("Put %d-%d: %v", begin, end, err)
+		}
+	}
+
+	// An earlier run used 8-position blocks, a later one 2-position blocks.
+	put(0, 8)
+	put(8, 10)
+	put(10, 12)
+	put(16, 24)
+
+	tests := []struct {
+		begin, end int32
+		wantEnd    int32
+	}{
+		{2, 6, 6},   // split one large block
+		{6, 12, 12}, // merge a large block with small ones
+		{4, 20, 12}, // stop at the gap
+		{12, 16, 12},
+		{17, 19, 19},
+	}
+	for _, tc := range tests {
+		key := BlockKey{Seq: 1, Layer: 0, BeginPos: tc.begin, EndPos: tc.end, IsKey: true}
+		got, end, err := store.ReadRange(key)
+		if err != nil {
+			t.Fatalf("ReadRange %d-%d: %v", tc.begin, tc.end, err)
+		}
+		if end != tc.wantEnd {
+			t.Errorf("ReadRange %d-%d: covered to %d, want %d", tc.begin, tc.end, end, tc.wantEnd)
+		}
+		if want := rows(int(tc.begin), int(tc.wantEnd)); !bytes.Equal(got, want) {
+			t.Errorf("ReadRange %d-%d: got %v, want %v", tc.begin, tc.end, got, want)
+		}
+	}
+
+	spans := store.BlockSpans()
+	if spans[8] != 2 || spans[2] != 2 {
+		t.Errorf("BlockSpans: got %v", spans)
+	}
+
+	// Rows stored after a context shift adjoin but are not merged.
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 24, EndPos: 28, IsKey: true}
+	if err := store.PutShifted(key, "f16", []int{rowSize / 2}, rows(24, 28), 4); err != nil {
+		t.Fatalf("PutShifted: %v", err)
+	}
+
+	for _, tc := range []struct {
+		begin, end int32
+		want       []Span
+	}{
+		{0, 30, []Span{{0, 12, 0}, {16, 24, 0}, {24, 28, 4}}},
+		{4, 18, []Span{{4, 12, 0}, {16, 18, 0}}},
+		{12, 16, nil},
+	} {
+		got := store.Coverage(BlockKey{Seq: 1, Layer: 0, BeginPos: tc.begin, EndPos: tc.end, IsKey: true})
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("Coverage %d-%d = %v, want %v", tc.begin, tc.end, got, tc.want)
+		}
+	}
+
+	// Rows stored at two shifts are counted once.
+	key = BlockKey{Seq: 1, Layer: 0, BeginPos: 26, EndPos: 30, IsKey: true}
+	if err := store.PutShifted(key, "f16", []int{rowSize / 2}, rows(26, 30), 8); err != nil {
+		t.Fatalf("PutShifted: %v", err)
+	}
+	for _, tc := range []struct {
+		begin, end int32
+		want       int32
+	}{
+		{0, 40, 12 + 8 + 6},
+		{10, 27, 2 + 8 + 3},
+		{12, 16, 0},
+	} {
+		spans, covered := store.HasRange(BlockKey{Seq: 1, Layer: 0, BeginPos: tc.begin, EndPos: tc.end, IsKey: true})
+		if covered != tc.want {
+			t.Errorf("HasRange %d-%d covers %d positions in %v, want %d", tc.begin, tc.end, covered, spans, tc.want)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package diskstore
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"sync/atomic"
+)
+
+// Negative lookups: most probes of a prompt being processed ask for
+// blocks that aren't stored. A Bloom filter over the index answers those
+// without taking s.mu or scanning the index. It holds every indexed
+// block's key, for Has, and its group: namespace, sequence or prefix,
+// layer and half, for the range lookups (Coverage, ReadRange, GetRange).
+//
+// Adds set bits atomically under s.mu; lookups read them without it. A
+// filter can't forget, so it is rebuilt from the index once removals
+// have left it too stale, or entries have outgrown it.
+
+const (
+	bloomBitsPerEntry = 10 // about 1% false positives with bloomHashes
+	bloomHashes       = 7
+	bloomMinEntries   = 1024
+)
+
+type bloomFilter struct {
+	seed maphash.Seed
+	bits []atomic.Uint64
+	m    uint64 // len(bits) * 64
+
+	// Guarded by s.mu.
+	capacity int // entries it was sized for
+	added    int // entries added, counting duplicates
+	removed  int // blocks removed since it was built
+}
+
+func newBloomFilter(capacity int) *bloomFilter {
+	capacity = max(capacity, bloomMinEntries)
+	words := (capacity*bloomBitsPerEntry + 63) / 64
+	return &bloomFilter{
+		seed:     maphash.MakeSeed(),
+		bits:     make([]atomic.Uint64, words),
+		m:        uint64(words) * 64,
+		capacity: capacity,
+	}
+}
+
+// hash hashes key's group or, if whole, the key itself.
+func (f *bloomFilter) hash(key BlockKey, whole bool) uint64 {
+	var h maphash.Hash
+	h.SetSeed(f.seed)
+	h.WriteString(key.Namespace)
+	h.WriteByte(0)
+	h.WriteString(key.Prefix)
+	var b [26]byte
+	binary.LittleEndian.PutUint64(b[0:], uint64(key.Seq))
+	binary.LittleEndian.PutUint64(b[8:], uint64(key.Layer))
+	if key.IsKey {
+		b[16] = 1
+	}
+	n := 17
+	if whole {
+		b[17] = 1
+		binary.LittleEndian.PutUint32(b[18:], uint32(key.BeginPos))
+		binary.LittleEndian.PutUint32(b[22:], uint32(key.EndPos))
+		n = len(b)
+	}
+	h.Write(b[:n])
+	return h.Sum64()
+}
+
+// add sets h's bits.
+func (f *bloomFilter) add(h uint64) {
+	h1, h2 := h, h>>32|1
+	for i := range uint64(bloomHashes) {
+		bit := (h1 + i*h2) % f.m
+		f.bits[bit/64].Or(1 << (bit % 64))
+	}
+}
+
+// has reports whether h's bits are all set: whether what hashed to h
+// may have been added.
+func (f *bloomFilter) has(h uint64) bool {
+	h1, h2 := h, h>>32|1
+	for i := range uint64(bloomHashes) {
+		bit := (h1 + i*h2) % f.m
+		if f.bits[bit/64].Load()&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// rebuildBloom replaces the filter with one built from the index. Must
+// be called with s.mu held, or before the store is shared.
+func (s *Store) rebuildBloom() {
+	f := newBloomFilter(2 * len(s.index))
+	for _, meta := range s.index {
+		f.add(f.hash(meta.Key, true))
+		f.add(f.hash(meta.Key, false))
+	}
+	f.added = 2 * len(s.index)
+	s.bloom.Store(f)
+}
+
+// bloomChanged keeps the filter up to date with key's index entry, added,
+// changed or removed. Must be called with s.mu held.
+func (s *Store) bloomChanged(key BlockKey) {
+	f := s.bloom.Load()
+	if _, ok := s.index[key.String()]; !ok {
+		// Its bits stay set; enough such and lookups stop saving much.
+		if f.removed++; f.removed > f.capacity/4 {
+			s.rebuildBloom()
+		}
+		return
+	}
+	hk, hg := f.hash(key, true), f.hash(key, false)
+	if f.has(hk) && f.has(hg) {
+		return // changed in place, or a false positive
+	}
+	if f.added += 2; f.added > f.capacity {
+		s.rebuildBloom()
+		return
+	}
+	f.add(hk)
+	f.add(hg)
+}
+
+// mayHave reports whether key may be indexed; if not, it surely isn't.
+func (s *Store) mayHave(key BlockKey) bool {
+	f := s.bloom.Load()
+	if f.has(f.hash(key, true)) {
+		return true
+	}
+	s.bloomSkips.Add(1)
+	return false
+}
+
+// mayHaveGroup reports whether blocks of key's namespace, sequence or
+// prefix, layer and half may be indexed; if not, there are surely none.
+func (s *Store) mayHaveGroup(key BlockKey) bool {
+	f := s.bloom.Load()
+	if f.has(f.hash(key, false)) {
+		return true
+	}
+	s.bloomSkips.Add(1)
+	return false
+}
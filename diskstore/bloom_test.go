@@ -0,0 +1,75 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestBloomFilter(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(seq int, i int32) BlockKey {
+		return BlockKey{Seq: seq, Layer: 1, BeginPos: i * 4, EndPos: i*4 + 4, IsKey: true}
+	}
+	// More blocks than the first filter was sized for.
+	const n = 600
+	for i := range int32(n) {
+		if err := store.Put(key(1, i), "f16", []int{8}, make([]byte, 16)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if c := store.bloom.Load().capacity; c < 2*n {
+		t.Errorf("filter sized for %d entries after %d blocks", c, n)
+	}
+	for i := range int32(n) {
+		if !store.Has(key(1, i)) {
+			t.Fatalf("Has(%s) = false for a stored block", key(1, i))
+		}
+	}
+	if got := store.Stats().BloomSkips; got != 0 {
+		t.Errorf("BloomSkips = %d for stored blocks, want 0", got)
+	}
+
+	// Misses rarely get past the filter.
+	const misses = 10000
+	for i := range int32(misses) {
+		if store.Has(key(2, i)) || store.Has(key(1, n+i)) {
+			t.Fatalf("Has = true for a missing block")
+		}
+	}
+	if got := store.Stats().BloomSkips; got < 2*misses*95/100 {
+		t.Errorf("BloomSkips = %d of %d misses", got, 2*misses)
+	}
+	before := store.Stats().BloomSkips
+	if spans := store.Coverage(BlockKey{Seq: 2, Layer: 1, BeginPos: 0, EndPos: 64, IsKey: true}); spans != nil {
+		t.Errorf("Coverage of a missing sequence = %v", spans)
+	}
+	if spans := store.Coverage(BlockKey{Seq: 1, Layer: 1, BeginPos: 0, EndPos: 8, IsKey: true}); len(spans) != 1 || spans[0].End != 8 {
+		t.Errorf("Coverage = %v, want [0,8)", spans)
+	}
+	if got := store.Stats().BloomSkips; got != before+1 {
+		t.Errorf("BloomSkips rose by %d for one missing range, want 1", got-before)
+	}
+
+	// Removed blocks are forgotten once the filter is rebuilt.
+	for i := range int32(n) {
+		if err := store.Delete(key(1, i)); err != nil {
+			t.Fatalf("Delete: %v", err)
+		}
+	}
+	if f := store.bloom.Load(); f.removed >= n {
+		t.Errorf("filter not rebuilt after %d removals", f.removed)
+	}
+	if store.Has(key(1, n-1)) {
+		t.Error("Has = true for a deleted block")
+	}
+}
@@ -0,0 +1,65 @@
+package diskstore
+
+import (
+	"io"
+	"os"
+	"sync"
+)
+
+// Buffer pool: context shifts and restores read and write blocks of the
+// same few sizes over and over. The payloads Put writes, the files Get
+// reads and the blocks ReadRange copies rows out of are dropped right
+// after, so they are kept here for the next block instead of left to the
+// GC.
+
+// maxPooledBuf is the largest buffer kept. Bigger ones are left to the GC
+// so one outsized block doesn't stay pinned in memory.
+const maxPooledBuf = 64 << 20
+
+var bufPool sync.Pool // of *[]byte
+
+// getBuf returns a buffer of length n, reusing a pooled one if it is
+// large enough.
+func getBuf(n int) []byte {
+	if p, ok := bufPool.Get().(*[]byte); ok {
+		if cap(*p) >= n {
+			return (*p)[:n]
+		}
+		bufPool.Put(p)
+	}
+	return make([]byte, n)
+}
+
+// putBuf hands b back to the pool. Nothing may use b afterwards.
+func putBuf(b []byte) {
+	if b == nil || cap(b) > maxPooledBuf {
+		return
+	}
+	b = b[:0]
+	bufPool.Put(&b)
+}
+
+// cloneBuf copies b into a pooled buffer.
+func cloneBuf(b []byte) []byte {
+	return append(getBuf(len(b))[:0], b...)
+}
+
+// readFile is os.ReadFile into a pooled buffer, which the caller may hand
+// back with putBuf.
+func readFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	buf := getBuf(int(fi.Size()))
+	if _, err := io.ReadFull(f, buf); err != nil {
+		putBuf(buf)
+		return nil, err
+	}
+	return buf, nil
+}
@@ -0,0 +1,47 @@
+package diskstore
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGetInto(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		store, err := New(Config{LocalPath: t.TempDir(), LocalBudget: 1 << 20, Compress: compress, StatsInterval: -1})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 64, IsKey: true}
+		data := bytes.Repeat([]byte("kv rows "), 64)
+		if err := store.Put(key, "f16", []int{8}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+
+		dst := make([]byte, 0, 1024)
+		got, _, err := store.GetInto(key, dst)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("compress=%v: GetInto = %q, %v", compress, got, err)
+		}
+		if &got[:1][0] != &dst[:1][0] {
+			t.Errorf("compress=%v: GetInto did not decode into dst", compress)
+		}
+
+		// Too small a buffer is grown.
+		got, _, err = store.GetInto(key, make([]byte, 3))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Errorf("compress=%v: GetInto a short buffer = %q, %v", compress, got, err)
+		}
+
+		// ReadRange decodes into a scratch buffer; the rows it returns
+		// must not change when the buffer is reused.
+		rows, end, err := store.ReadRange(BlockKey{Seq: 1, BeginPos: 8, EndPos: 16, IsKey: true})
+		if err != nil || end != 16 || !bytes.Equal(rows, data[64:128]) {
+			t.Errorf("compress=%v: ReadRange = %q, %d, %v", compress, rows, end, err)
+		}
+		store.Get(key)
+		if !bytes.Equal(rows, data[64:128]) {
+			t.Errorf("compress=%v: ReadRange result changed by a later Get", compress)
+		}
+		store.Close()
+	}
+}
@@ -0,0 +1,493 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// Archive bundles pack many small remote-tier blocks into one file so NFS
+// doesn't have to carry an inode per token position. A bundle is laid out
+// as
+//
+//	magic | payload... | index (JSON) | index offset (uint64 LE) | magic
+//
+// The embedded index maps block keys to byte ranges, so a bundle can be
+// read back (or the store index rebuilt) from the file alone, and single
+// blocks are fetched with ranged reads.
+//
+// A bundle's space is allocated up front (fallocate, on Linux), so a
+// full disk stops compaction before it writes anything rather than
+// midway, and a block released from a bundle has its range punched out
+// of the file where the filesystem supports holes, freeing its disk
+// blocks without a rewrite. The remote tier's usage counts what bundle
+// files take on disk, their index, rounding and unpunched dead bytes
+// included, rather than only their live blocks' payload.
+const (
+	bundleMagic   = "KVBUNDL1"
+	bundleDir     = "bundles"
+	bundleExt     = ".kvbundle"
+	bundleTrailer = 8 + len(bundleMagic)
+
+	defaultArchiveMinBlocks = 16
+	defaultArchiveMaxBytes  = 1 << 30
+	defaultCompactDeadRatio = 0.5
+)
+
+// BundleRef locates a block inside an archive bundle on the remote tier.
+type BundleRef struct {
+	File   string `json:"file"` // file name under <remote>/bundles
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// bundleEntry is one record of a bundle's embedded index.
+type bundleEntry struct {
+	Key    string `json:"key"`
+	Offset int64  `json:"offset"`
+	Length int64  `json:"length"`
+}
+
+// bundleInfo tracks how many indexed blocks still live in a bundle, and
+// how many of its payload bytes are dead (released blocks whose space is
+// only reclaimed by rewriting the bundle or punching it out). physical is
+// the disk space of the file, and charged what of it the remote usage
+// counts beyond the live blocks' payload.
+type bundleInfo struct {
+	live     int
+	size     int64 // payload bytes, excluding header and index
+	dead     int64
+	physical int64
+	charged  int64
+}
+
+// ArchiveResult summarises a remote-tier compaction pass.
+type ArchiveResult struct {
+	Bundles int   `json:"bundles"`
+	Blocks  int   `json:"blocks"`
+	Bytes   int64 `json:"bytes"`
+}
+
+// CompactRemote packs remote-tier blocks not accessed within maxAge into
+// archive bundles and removes their loose files. Batches smaller than the
+// configured minimum are left alone so bundles stay worth their overhead.
+func (s *Store) CompactRemote(maxAge time.Duration) (ArchiveResult, error) {
+	if err := s.writable(); err != nil {
+		return ArchiveResult{}, err
+	}
+	var res ArchiveResult
+	if s.remotePath == "" {
+		return res, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := time.Now().Add(-maxAge)
+	var cold []*BlockMeta
+	for _, meta := range s.index {
+		if meta.Tier == "remote" && meta.Bundle == nil && meta.Share == "" && meta.AccessedAt.Before(cutoff) {
+			cold = append(cold, meta)
+		}
+	}
+	if len(cold) < s.archiveMinBlocks {
+		return res, nil
+	}
+	// Keep a sequence's blocks adjacent so restores read nearby ranges.
+	sort.Slice(cold, func(i, j int) bool {
+		a, b := cold[i].Key, cold[j].Key
+		if a.Seq != b.Seq {
+			return a.Seq < b.Seq
+		}
+		if a.BeginPos != b.BeginPos {
+			return a.BeginPos < b.BeginPos
+		}
+		if a.Layer != b.Layer {
+			return a.Layer < b.Layer
+		}
+		return a.IsKey
+	})
+
+	for len(cold) > 0 {
+		n, size, err := s.writeBundle(cold)
+		if err != nil {
+			return res, err
+		}
+		res.Bundles++
+		res.Blocks += n
+		res.Bytes += size
+		cold = cold[n:]
+		if len(cold) < s.archiveMinBlocks {
+			break
+		}
+	}
+
+	if res.Blocks > 0 {
+		s.log.Info("archived remote blocks", "bundles", res.Bundles, "blocks", res.Blocks, "bytes", res.Bytes)
+	}
+	return res, nil
+}
+
+// CompactBundles rewrites bundles whose dead bytes make up at least
+// minDeadRatio of their payload, packing the surviving blocks into new
+// bundles and deleting the old ones. This bounds space amplification on
+// long-running stores where blocks keep getting removed or promoted out
+// of their bundles.
+func (s *Store) CompactBundles(minDeadRatio float64) (ArchiveResult, error) {
+	if err := s.writable(); err != nil {
+		return ArchiveResult{}, err
+	}
+	var res ArchiveResult
+	if s.remotePath == "" {
+		return res, nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	live := make(map[string][]*BlockMeta)
+	for _, meta := range s.index {
+		if meta.Bundle != nil {
+			live[meta.Bundle.File] = append(live[meta.Bundle.File], meta)
+		}
+	}
+
+	// Pick candidates up front; writeBundle adds to s.bundles.
+	var names []string
+	for name, info := range s.bundles {
+		if info.dead > 0 && info.size > 0 && float64(info.dead)/float64(info.size) >= minDeadRatio {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var reclaimed int64
+	for _, name := range names {
+		dead := s.bundles[name].dead
+		metas := live[name]
+		sort.Slice(metas, func(i, j int) bool { return metas[i].Bundle.Offset < metas[j].Bundle.Offset })
+		for len(metas) > 0 {
+			n, size, err := s.writeBundle(metas)
+			if err != nil {
+				return res, err
+			}
+			if size > 0 {
+				res.Bundles++
+			}
+			res.Bytes += size
+			metas = metas[n:]
+		}
+		res.Blocks += len(live[name])
+		reclaimed += dead
+	}
+
+	if res.Blocks > 0 {
+		s.log.Info("compacted bundles", "rewritten", res.Blocks, "new_bundles", res.Bundles, "reclaimed", reclaimed)
+	}
+	return res, nil
+}
+
+// deadBundleBytes sums the dead bytes of all bundles. Must be called with
+// s.mu held.
+func (s *Store) deadBundleBytes() int64 {
+	var dead int64
+	for _, info := range s.bundles {
+		dead += info.dead
+	}
+	return dead
+}
+
+// bundlePhysical sums the disk space of all bundles. Must be called with
+// s.mu held.
+func (s *Store) bundlePhysical() int64 {
+	var n int64
+	for _, info := range s.bundles {
+		n += info.physical
+	}
+	return n
+}
+
+// chargeBundle records a bundle's disk space, from path's allocated size,
+// and counts what it takes beyond its live blocks' payload towards the
+// remote usage. Must be called with s.mu held.
+func (s *Store) chargeBundle(info *bundleInfo, path string) {
+	if st, err := os.Stat(path); err == nil {
+		info.physical = allocatedSize(st)
+	}
+	extra := max(info.physical-(info.size-info.dead), 0)
+	s.remoteUsed += extra - info.charged
+	info.charged = extra
+}
+
+// bundleReserve is the size writeBundle preallocates for a bundle of
+// metas: the payload it packs before reaching the bundle size, and an
+// allowance for each block's index entry.
+func (s *Store) bundleReserve(metas []*BlockMeta) int64 {
+	size := int64(len(bundleMagic) + bundleTrailer)
+	var payload int64
+	for i, meta := range metas {
+		if i > 0 && payload >= s.archiveMaxBytes {
+			break
+		}
+		payload += int64(storedSize(meta))
+		size += int64(storedSize(meta)) + 128
+	}
+	return size
+}
+
+// bundlePayloadSize returns the payload bytes of a bundle file, read from
+// its trailer.
+func bundlePayloadSize(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	if info.Size() < int64(len(bundleMagic)+bundleTrailer) {
+		return 0, fmt.Errorf("diskstore: bundle %s: truncated", path)
+	}
+	trailer := make([]byte, bundleTrailer)
+	if _, err := f.ReadAt(trailer, info.Size()-int64(bundleTrailer)); err != nil {
+		return 0, err
+	}
+	if !bytes.Equal(trailer[8:], []byte(bundleMagic)) {
+		return 0, fmt.Errorf("diskstore: bundle %s: bad trailer", path)
+	}
+	return int64(binary.LittleEndian.Uint64(trailer)) - int64(len(bundleMagic)), nil
+}
+
+// writeBundle writes a prefix of metas into a new bundle, bounded by the
+// configured bundle size, and repoints their index entries at it. It
+// returns how many blocks were consumed. Must be called with s.mu held.
+func (s *Store) writeBundle(metas []*BlockMeta) (int, int64, error) {
+	dir := filepath.Join(s.remotePath, bundleDir)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, 0, fmt.Errorf("diskstore: create bundle dir: %w", err)
+	}
+	name := fmt.Sprintf("bundle-%d%s", time.Now().UnixNano(), bundleExt)
+	path := filepath.Join(dir, name)
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return 0, 0, fmt.Errorf("diskstore: create bundle: %w", err)
+	}
+	fail := func(err error) (int, int64, error) {
+		f.Close()
+		os.Remove(path)
+		return 0, 0, fmt.Errorf("diskstore: write bundle %s: %w", name, err)
+	}
+	if err := preallocate(f, s.bundleReserve(metas)); err != nil {
+		return fail(fmt.Errorf("preallocate: %w", err))
+	}
+
+	if _, err := f.WriteString(bundleMagic); err != nil {
+		return fail(err)
+	}
+	offset := int64(len(bundleMagic))
+
+	var entries []bundleEntry
+	var packed []*BlockMeta
+	var examined int
+	for _, meta := range metas {
+		if len(packed) > 0 && offset >= s.archiveMaxBytes {
+			break
+		}
+		examined++
+		payload, err := s.readPayload(context.Background(), meta, migrationIO)
+		if err != nil || !s.verify(meta, payload) {
+			// Leave unreadable blocks loose for read repair or GC.
+			s.log.Warn("archive: skipping unreadable block", "key", meta.Key, "error", err)
+			continue
+		}
+		if _, err := f.Write(payload); err != nil {
+			return fail(err)
+		}
+		entries = append(entries, bundleEntry{Key: meta.Key.String(), Offset: offset, Length: int64(len(payload))})
+		packed = append(packed, meta)
+		offset += int64(len(payload))
+	}
+	if len(packed) == 0 {
+		f.Close()
+		os.Remove(path)
+		return examined, 0, nil
+	}
+
+	index, err := json.Marshal(entries)
+	if err != nil {
+		return fail(err)
+	}
+	trailer := make([]byte, 8, bundleTrailer)
+	binary.LittleEndian.PutUint64(trailer, uint64(offset))
+	trailer = append(trailer, bundleMagic...)
+	if _, err := f.Write(append(index, trailer...)); err != nil {
+		return fail(err)
+	}
+	// Gives back what was reserved for blocks skipped or over the
+	// estimate.
+	if err := f.Truncate(offset + int64(len(index)+len(trailer))); err != nil {
+		return fail(err)
+	}
+	if err := f.Sync(); err != nil {
+		return fail(err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(path)
+		return 0, 0, fmt.Errorf("diskstore: close bundle %s: %w", name, err)
+	}
+
+	for i, meta := range packed {
+		// Drops the loose file, or the reference into an older bundle
+		// being compacted.
+		if err := s.removePayload(meta); err != nil {
+			s.log.Warn("archive: remove old copy", "key", meta.Key, "error", err)
+		}
+		meta.Bundle = &BundleRef{File: name, Offset: entries[i].Offset, Length: entries[i].Length}
+		s.indexChanged(meta.Key)
+	}
+	info := &bundleInfo{live: len(packed), size: offset - int64(len(bundleMagic))}
+	s.bundles[name] = info
+	s.chargeBundle(info, path)
+
+	return examined, offset - int64(len(bundleMagic)), nil
+}
+
+// readBundled fetches a block's payload with a ranged read of its bundle.
+func (s *Store) readBundled(ref *BundleRef) ([]byte, error) {
+	f, err := os.Open(filepath.Join(s.remotePath, bundleDir, ref.File))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	buf := make([]byte, ref.Length)
+	if _, err := f.ReadAt(buf, ref.Offset); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf, nil
+}
+
+// releaseBundled drops a block's reference to its bundle and deletes the
+// bundle once nothing in the index points into it. Must be called with
+// s.mu held.
+func (s *Store) releaseBundled(meta *BlockMeta) {
+	ref := meta.Bundle
+	if ref == nil {
+		return
+	}
+	meta.Bundle = nil
+	s.indexChanged(meta.Key)
+	info, ok := s.bundles[ref.File]
+	if !ok {
+		return
+	}
+	info.live--
+	info.dead += ref.Length
+	path := filepath.Join(s.remotePath, bundleDir, ref.File)
+	if info.live > 0 {
+		s.punchBundle(path, ref)
+		s.chargeBundle(info, path)
+		return
+	}
+	delete(s.bundles, ref.File)
+	s.remoteUsed -= info.charged
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warn("remove empty bundle", "path", path, "error", err)
+	}
+}
+
+// punchBundle frees the disk blocks of a released block's range of its
+// bundle, where the filesystem can.
+func (s *Store) punchBundle(path string, ref *BundleRef) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	if err := punchHole(f, ref.Offset, ref.Length); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+		s.log.Debug("punch released block out of bundle", "bundle", ref.File, "error", err)
+	}
+}
+
+// ReadBundleIndex returns the embedded index of a bundle file, mapping
+// block key strings to their byte ranges within it.
+func ReadBundleIndex(path string) (map[string]BundleRef, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := info.Size()
+	if size < int64(len(bundleMagic)+bundleTrailer) {
+		return nil, fmt.Errorf("diskstore: bundle %s: truncated", path)
+	}
+	trailer := make([]byte, bundleTrailer)
+	if _, err := f.ReadAt(trailer, size-int64(bundleTrailer)); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(trailer[8:], []byte(bundleMagic)) {
+		return nil, fmt.Errorf("diskstore: bundle %s: bad trailer", path)
+	}
+	indexOff := int64(binary.LittleEndian.Uint64(trailer))
+	indexLen := size - int64(bundleTrailer) - indexOff
+	if indexOff < int64(len(bundleMagic)) || indexLen < 0 {
+		return nil, fmt.Errorf("diskstore: bundle %s: bad index offset", path)
+	}
+	raw := make([]byte, indexLen)
+	if _, err := f.ReadAt(raw, indexOff); err != nil {
+		return nil, err
+	}
+	var entries []bundleEntry
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, fmt.Errorf("diskstore: bundle %s: decode index: %w", path, err)
+	}
+
+	name := filepath.Base(path)
+	refs := make(map[string]BundleRef, len(entries))
+	for _, e := range entries {
+		refs[e.Key] = BundleRef{File: name, Offset: e.Offset, Length: e.Length}
+	}
+	return refs, nil
+}
+
+// archiveLoop periodically compacts the remote tier until the store is
+// closed.
+func (s *Store) archiveLoop(age, interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			if _, err := s.CompactRemote(age); err != nil {
+				s.log.Warn("periodic archive failed", "error", err)
+			}
+			if s.compactDeadRatio > 0 {
+				if _, err := s.CompactBundles(s.compactDeadRatio); err != nil {
+					s.log.Warn("periodic bundle compaction failed", "error", err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,257 @@
+package diskstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompactRemote(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:        filepath.Join(dir, "local"),
+		RemotePath:       filepath.Join(dir, "remote"),
+		LocalBudget:      0, // everything spills to remote
+		RemoteBudget:     1024 * 1024,
+		ArchiveMinBlocks: 4,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	payload := func(i int32) []byte { return bytes.Repeat([]byte{byte(i)}, 100+int(i)) }
+	for i := int32(0); i < 8; i++ {
+		key := BlockKey{Seq: 1, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+		store.Put(key, "f16", []int{128}, payload(i))
+	}
+	// The last Put stays local until something pushes it out.
+	store.SetBudgets(0, 1024*1024)
+
+	res, err := store.CompactRemote(0)
+	if err != nil {
+		t.Fatalf("CompactRemote: %v", err)
+	}
+	if res.Bundles != 1 || res.Blocks != 8 {
+		t.Fatalf("CompactRemote = %+v, want 1 bundle of 8 blocks", res)
+	}
+	loose, _ := filepath.Glob(filepath.Join(cfg.RemotePath, "*", "*.kvblk"))
+	if len(loose) != 0 {
+		t.Errorf("%d loose remote files left after compaction", len(loose))
+	}
+	bundles, _ := filepath.Glob(filepath.Join(cfg.RemotePath, bundleDir, "*"+bundleExt))
+	if len(bundles) != 1 {
+		t.Fatalf("found %d bundle files, want 1", len(bundles))
+	}
+	refs, err := ReadBundleIndex(bundles[0])
+	if err != nil || len(refs) != 8 {
+		t.Fatalf("ReadBundleIndex: %d entries, err %v", len(refs), err)
+	}
+	store.Close()
+
+	// Bundled blocks must survive a reopen and be readable by range.
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	for i := int32(0); i < 8; i++ {
+		key := BlockKey{Seq: 1, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+		got, meta, err := store.Get(key)
+		if err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+		if meta.Bundle == nil {
+			t.Errorf("Get %d: block not bundled", i)
+		}
+		if !bytes.Equal(got, payload(i)) {
+			t.Fatalf("Get %d: payload mismatch", i)
+		}
+	}
+
+	// Removing the last live block deletes the bundle.
+	store.RemoveSeq(1)
+	if _, err := os.Stat(bundles[0]); !os.IsNotExist(err) {
+		t.Errorf("bundle still present after all blocks removed: %v", err)
+	}
+}
+
+func TestCompactRemoteBelowMinimum(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:        filepath.Join(dir, "local"),
+		RemotePath:       filepath.Join(dir, "remote"),
+		LocalBudget:      0,
+		RemoteBudget:     1024 * 1024,
+		ArchiveMinBlocks: 10,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for i := int32(0); i < 3; i++ {
+		key := BlockKey{Seq: 0, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+		store.Put(key, "f16", []int{128}, make([]byte, 64))
+	}
+	res, err := store.CompactRemote(0)
+	if err != nil {
+		t.Fatalf("CompactRemote: %v", err)
+	}
+	if res.Bundles != 0 {
+		t.Errorf("CompactRemote bundled %d blocks below the minimum", res.Blocks)
+	}
+}
+
+func TestCompactBundlesDeadSpace(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:        filepath.Join(dir, "local"),
+		RemotePath:       filepath.Join(dir, "remote"),
+		RemoteBudget:     1024 * 1024,
+		ArchiveMinBlocks: 4,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := func(i int32) BlockKey { return BlockKey{Seq: 1, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true} }
+	payload := func(i int32) []byte { return bytes.Repeat([]byte{byte(i)}, 100) }
+	for i := int32(0); i < 8; i++ {
+		store.Put(key(i), "f16", []int{128}, payload(i))
+	}
+	store.SetBudgets(0, 1024*1024)
+	if _, err := store.CompactRemote(0); err != nil {
+		t.Fatalf("CompactRemote: %v", err)
+	}
+	old, _ := filepath.Glob(filepath.Join(cfg.RemotePath, bundleDir, "*"+bundleExt))
+	if len(old) != 1 {
+		t.Fatalf("found %d bundle files, want 1", len(old))
+	}
+
+	for i := int32(0); i < 5; i++ {
+		store.Delete(key(i))
+	}
+	if got := store.Stats().BundleDeadBytes; got != 500 {
+		t.Errorf("BundleDeadBytes = %d, want 500", got)
+	}
+	store.Close()
+
+	// Dead space is recovered from the bundle trailer on reopen.
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if got := store.Stats().BundleDeadBytes; got != 500 {
+		t.Errorf("after reopen: BundleDeadBytes = %d, want 500", got)
+	}
+
+	// 5/8 dead is below a 0.7 threshold.
+	if res, _ := store.CompactBundles(0.7); res.Blocks != 0 {
+		t.Errorf("CompactBundles(0.7) rewrote %d blocks, want 0", res.Blocks)
+	}
+	res, err := store.CompactBundles(0.5)
+	if err != nil {
+		t.Fatalf("CompactBundles: %v", err)
+	}
+	if res.Blocks != 3 || res.Bundles != 1 || res.Bytes != 300 {
+		t.Errorf("CompactBundles = %+v, want 3 blocks in 1 bundle of 300 bytes", res)
+	}
+	if _, err := os.Stat(old[0]); !os.IsNotExist(err) {
+		t.Errorf("old bundle still present: %v", err)
+	}
+	if got := store.Stats().BundleDeadBytes; got != 0 {
+		t.Errorf("after compaction: BundleDeadBytes = %d, want 0", got)
+	}
+	for i := int32(5); i < 8; i++ {
+		got, meta, err := store.Get(key(i))
+		if err != nil || meta == nil || meta.Bundle == nil || !bytes.Equal(got, payload(i)) {
+			t.Errorf("Get %d after compaction: bundled=%v err=%v", i, meta != nil && meta.Bundle != nil, err)
+		}
+	}
+}
+
+func TestBundleDiskSpace(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:        filepath.Join(dir, "local"),
+		RemotePath:       filepath.Join(dir, "remote"),
+		RemoteBudget:     1 << 30,
+		ArchiveMinBlocks: 4,
+		StatsInterval:    -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	key := func(i int32) BlockKey { return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true} }
+	for i := range int32(8) {
+		store.Put(key(i), "f16", []int{8192}, bytes.Repeat([]byte{byte(i + 1)}, 16<<10))
+	}
+	store.SetBudgets(0, 1<<30)
+	if _, err := store.CompactRemote(0); err != nil {
+		t.Fatalf("CompactRemote: %v", err)
+	}
+
+	// The remote tier's usage is the bundle's disk space.
+	st := store.Stats()
+	if st.BundlePhysical < 8*16<<10 || st.RemoteUsed != st.BundlePhysical {
+		t.Fatalf("BundlePhysical = %d, RemoteUsed = %d; want the same, at least the payload", st.BundlePhysical, st.RemoteUsed)
+	}
+	bundles, _ := filepath.Glob(filepath.Join(cfg.RemotePath, bundleDir, "*"+bundleExt))
+	if info, err := os.Stat(bundles[0]); err != nil || info.Size() > st.BundlePhysical+4096 {
+		t.Fatalf("bundle file: %v, %v; want no more than its payload, index and trailer", info, err)
+	}
+
+	// A released block's range is punched out where the filesystem can,
+	// and the space counted no more.
+	if err := store.Delete(key(3)); err != nil {
+		t.Fatal(err)
+	}
+	after := store.Stats()
+	if after.RemoteUsed != after.BundlePhysical {
+		t.Errorf("after Delete: RemoteUsed = %d, BundlePhysical = %d; want the same", after.RemoteUsed, after.BundlePhysical)
+	}
+	if holesSupported(t, cfg.RemotePath) && after.BundlePhysical > st.BundlePhysical-12<<10 {
+		t.Errorf("BundlePhysical = %d after releasing 16 KiB of %d, want it punched out", after.BundlePhysical, st.BundlePhysical)
+	}
+	for _, i := range []int32{2, 4} {
+		if got, _, err := store.Get(key(i)); err != nil || got[0] != byte(i+1) || got[len(got)-1] != byte(i+1) {
+			t.Errorf("Get %d next to the hole: %v", i, err)
+		}
+	}
+	used := store.Stats().RemoteUsed
+	store.Close()
+
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if st := store.Stats(); st.RemoteUsed != used {
+		t.Errorf("RemoteUsed = %d after reopening, want %d", st.RemoteUsed, used)
+	}
+}
+
+// holesSupported reports whether punching a hole in a file in dir frees
+// its disk blocks.
+func holesSupported(t *testing.T, dir string) bool {
+	path := filepath.Join(dir, "probe")
+	defer os.Remove(path)
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.Write(make([]byte, 64<<10))
+	f.Sync()
+	before, _ := f.Stat()
+	if punchHole(f, 0, 64<<10) != nil {
+		return false
+	}
+	after, _ := f.Stat()
+	return allocatedSize(after) < allocatedSize(before)
+}
@@ -0,0 +1,140 @@
+package diskstore
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+)
+
+// Tier chains: Config.Tiers extends the local and remote pair to an
+// ordered list of tiers, fastest first, e.g. RAM (a tmpfs directory) →
+// NVMe → SATA SSD → NFS → a block service. New opens every tier below
+// the local one as a Store of its own, with that tier's budget,
+// compression and eviction policy, whose remote tier is the next one
+// down, and uses the first of them as its remote tier.
+//
+// Demotion cascades: a tier over its budget evicts its blocks to the
+// next, as the local tier does to the remote one, and the last tier
+// keeps what it is given past its budget, as a store without a remote
+// tier does. Promotion skips ahead: a block prefetched from anywhere
+// below moves straight to the local tier. A tier's payloads are the
+// encoded payloads of the tier above, so a tier compresses only what
+// the tiers above it left uncompressed.
+
+// TierConfig configures one tier of a chain below the local tier (see
+// Config.Tiers).
+type TierConfig struct {
+	// Path is the tier's directory. Service, instead, makes the last
+	// tier of the chain a block service such as a RemoteClient.
+	Path    string
+	Service Tier
+
+	// Budget is the most bytes the tier holds before evicting to the
+	// next one.
+	Budget int64
+
+	// Compress zstd-compresses the blocks the tier stores.
+	Compress bool
+
+	// Eviction orders the tier's blocks for moving to the next one
+	// (EvictLRU, the default, or EvictLFU).
+	Eviction string
+}
+
+// openChain opens cfg's store on top of the chain cfg.Tiers, which the
+// store closes with itself.
+func openChain(cfg Config) (*Store, error) {
+	if cfg.RemotePath != "" || cfg.RemoteTier != nil {
+		return nil, fmt.Errorf("diskstore: Tiers and RemotePath or RemoteTier are mutually exclusive")
+	}
+	for i, t := range cfg.Tiers {
+		switch {
+		case (t.Path == "") == (t.Service == nil):
+			return nil, fmt.Errorf("diskstore: tier %d needs either a path or a service", i+1)
+		case t.Service != nil && i < len(cfg.Tiers)-1:
+			return nil, fmt.Errorf("diskstore: tier %d: only the last tier may be a service", i+1)
+		}
+	}
+
+	next, rest := cfg.Tiers[0], cfg.Tiers[1:]
+	top := cfg
+	top.Tiers = nil
+	if top.RemoteBudget == 0 {
+		for _, t := range cfg.Tiers {
+			top.RemoteBudget += t.Budget
+		}
+	}
+	if next.Service != nil {
+		top.RemoteTier = next.Service
+		return New(top)
+	}
+
+	logger := cfg.Logger
+	if logger == nil {
+		logger = slog.Default()
+	}
+	below, err := New(Config{
+		LocalPath:   next.Path,
+		LocalBudget: next.Budget,
+		Compress:    next.Compress,
+		Eviction:    next.Eviction,
+		Tiers:       rest,
+
+		Logger:            logger.With("tier", next.Path),
+		LogLevel:          cfg.LogLevel,
+		Fingerprint:       cfg.Fingerprint,
+		NamespaceByModel:  cfg.NamespaceByModel,
+		StreamMoves:       cfg.StreamMoves,
+		MigrationWorkers:  cfg.MigrationWorkers,
+		IndexBackend:      cfg.IndexBackend,
+		IndexSyncInterval: cfg.IndexSyncInterval,
+		StatsInterval:     cfg.StatsInterval,
+		ReadOnly:          cfg.ReadOnly,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: open tier %s: %w", next.Path, err)
+	}
+	top.RemoteTier = below
+	s, err := New(top)
+	if err != nil {
+		return nil, errors.Join(err, below.Close())
+	}
+	s.below = below
+	return s, nil
+}
+
+// ParseTiers parses a chain of tiers below the local one, fastest
+// first, from a comma-separated list of path:budgetGB[:option...], the
+// options being zstd, lru and lfu; e.g. "/mnt/sata:200:zstd,/mnt/nfs:2000:lfu".
+// An empty spec is no chain.
+func ParseTiers(spec string) ([]TierConfig, error) {
+	var tiers []TierConfig
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		fields := strings.Split(s, ":")
+		if len(fields) < 2 || fields[0] == "" {
+			return nil, fmt.Errorf("diskstore: tier %q: want path:budgetGB[:option...]", s)
+		}
+		gb, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || gb < 0 {
+			return nil, fmt.Errorf("diskstore: tier %q: invalid budget %q", s, fields[1])
+		}
+		t := TierConfig{Path: fields[0], Budget: int64(gb * 1024 * 1024 * 1024)}
+		for _, opt := range fields[2:] {
+			switch opt {
+			case "zstd":
+				t.Compress = true
+			case EvictLRU, EvictLFU:
+				t.Eviction = opt
+			default:
+				return nil, fmt.Errorf("diskstore: tier %q: unknown option %q", s, opt)
+			}
+		}
+		tiers = append(tiers, t)
+	}
+	return tiers, nil
+}
@@ -0,0 +1,119 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestTierChain(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "nvme"),
+		LocalBudget: 300,
+		Tiers: []TierConfig{
+			{Path: filepath.Join(dir, "sata"), Budget: 300, Eviction: EvictLFU},
+			{Path: filepath.Join(dir, "nfs"), Budget: 1024 * 1024, Compress: zstdAvailable},
+		},
+		StatsInterval: -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	data := func(i int32) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 100)
+	}
+	const n = 10
+	for i := range int32(n) {
+		if err := store.Put(key(i), "f16", []int{50}, data(i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Blocks cascade down the chain as each tier fills.
+	sata, nfs := store.below, store.below.below
+	if sata == nil || nfs == nil || nfs.below != nil {
+		t.Fatal("chain not opened as three tiers")
+	}
+	for name, tier := range map[string]*Store{"nvme": store, "sata": sata, "nfs": nfs} {
+		if used := tier.Stats().LocalUsed; tier != nfs && used != 300 {
+			t.Errorf("%s holds %d bytes, want 300", name, used)
+		}
+	}
+	if got := len(nfs.AllBlocks()); got != 4 {
+		t.Errorf("last tier holds %d blocks, want 4", got)
+	}
+	for i := range int32(n) {
+		got, _, err := store.Get(key(i))
+		if err != nil || !bytes.Equal(got, data(i)) {
+			t.Errorf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+	}
+
+	// A block prefetched from the bottom moves straight to the top.
+	if store.PrefetchRange(key(0)) != 1 {
+		t.Fatal("PrefetchRange queued nothing")
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Stats().Prefetched == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if nfs.Has(key(0)) || store.Blocks(1)[0].Tier != "local" {
+		t.Error("prefetched block not moved to the top tier")
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Every tier keeps its blocks across a reopen.
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	for i := range int32(n) {
+		if got, _, err := store.Get(key(i)); err != nil || !bytes.Equal(got, data(i)) {
+			t.Errorf("Get(%d) after reopen = %d bytes, %v", i, len(got), err)
+		}
+	}
+	store.Close()
+
+	bad := cfg
+	bad.RemotePath = filepath.Join(dir, "remote")
+	if _, err := New(bad); err == nil {
+		t.Error("New accepted Tiers with a RemotePath")
+	}
+	bad = cfg
+	bad.Tiers = []TierConfig{{Service: store}, {Path: filepath.Join(dir, "nfs")}}
+	if _, err := New(bad); err == nil {
+		t.Error("New accepted a service above another tier")
+	}
+}
+
+func TestParseTiers(t *testing.T) {
+	tiers, err := ParseTiers("/mnt/sata:200:zstd, /mnt/nfs:0.5:lfu")
+	if err != nil {
+		t.Fatalf("ParseTiers: %v", err)
+	}
+	want := []TierConfig{
+		{Path: "/mnt/sata", Budget: 200 << 30, Compress: true},
+		{Path: "/mnt/nfs", Budget: 1 << 29, Eviction: EvictLFU},
+	}
+	if !reflect.DeepEqual(tiers, want) {
+		t.Errorf("ParseTiers = %+v, want %+v", tiers, want)
+	}
+	if tiers, err := ParseTiers(""); err != nil || tiers != nil {
+		t.Errorf("ParseTiers(\"\") = %v, %v", tiers, err)
+	}
+	for _, spec := range []string{"/mnt/sata", "/mnt/sata:x", "/mnt/sata:1:fast", ":1"} {
+		if _, err := ParseTiers(spec); err == nil {
+			t.Errorf("ParseTiers(%q) accepted", spec)
+		}
+	}
+}
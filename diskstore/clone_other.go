@@ -0,0 +1,10 @@
+//go:build !linux
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+)
+
+func cloneFile(dst, src *os.File) error { return errors.ErrUnsupported }
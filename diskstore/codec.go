@@ -0,0 +1,212 @@
+package diskstore
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Per-tier codecs: Config.LocalCodec and Config.RemoteCodec replace
+// Compress with a compression stage for each tier, so that the local
+// tier can keep blocks raw or in S2 for restore speed while the remote
+// one, where space costs more than time, holds them in high-level zstd.
+// Put compresses with the local tier's codec, after the Transforms. With
+// Config.RecompressOnMigrate a block moving between the tiers, an
+// eviction, a prefetch or a placement rule sending it straight to the
+// remote tier, is recompressed with its destination's codec on the way;
+// otherwise it keeps the codec it was written with. Recompressing swaps
+// only the last stage of a block, so blocks whose chain compresses
+// before another transform (e.g. an encryption stage) keep theirs, as
+// do replicated and forked blocks, whose payloads are shared.
+//
+// Every stage name is recorded as before; zstd levels need no record, as
+// any zstd decoder reads them all.
+
+const (
+	// Codec names for Config.LocalCodec and RemoteCodec; CodecZstd takes a
+	// level after a colon, e.g. "zstd:19".
+	CodecNone = "none"
+	CodecS2   = "s2"
+	CodecZstd = "zstd"
+
+	// s2TransformName is the name recorded for the S2 stage.
+	s2TransformName = "s2"
+)
+
+// tierCodec is the compression stage of a tier.
+type tierCodec struct {
+	spec  string    // as configured, normalized
+	stage Transform // nil for CodecNone
+}
+
+// newTierCodec returns the codec spec names.
+func newTierCodec(spec string) (tierCodec, error) {
+	name, level, hasLevel := strings.Cut(spec, ":")
+	switch {
+	case name == "" || name == CodecNone:
+		return tierCodec{spec: CodecNone}, nil
+	case name == CodecS2 && !hasLevel:
+		return tierCodec{spec: CodecS2, stage: s2Transform{}}, nil
+	case name == CodecZstd:
+		n := 3 // zstd's default
+		if hasLevel {
+			var err error
+			if n, err = strconv.Atoi(level); err != nil || n < 1 || n > 22 {
+				return tierCodec{}, fmt.Errorf("diskstore: codec %q: zstd level must be 1 to 22", spec)
+			}
+		}
+		enc, err := newZstdEncoder(n)
+		if err != nil {
+			return tierCodec{}, err
+		}
+		return tierCodec{spec: CodecZstd + ":" + strconv.Itoa(n), stage: enc}, nil
+	}
+	return tierCodec{}, fmt.Errorf("diskstore: unknown codec %q (want none, s2 or zstd[:level])", spec)
+}
+
+// openCodecs sets up the tier codecs of cfg, registering the stages
+// that decode them.
+func (s *Store) openCodecs(cfg Config) error {
+	s.codecs = make(map[string]tierCodec)
+	for tier, spec := range map[string]string{"local": cfg.LocalCodec, "remote": cfg.RemoteCodec} {
+		c, err := newTierCodec(spec)
+		if err != nil {
+			for _, c := range s.codecs {
+				c.close()
+			}
+			return err
+		}
+		s.codecs[tier] = c
+	}
+	if _, ok := s.transforms[zstdTransformName]; !ok {
+		t, err := newZstdTransform(s.decodeWorkers)
+		if err != nil {
+			return err
+		}
+		s.zstd = t
+		s.transforms[zstdTransformName] = t
+	}
+	if _, ok := s.transforms[s2TransformName]; !ok {
+		s.transforms[s2TransformName] = s2Transform{}
+	}
+	s.recompress = cfg.RecompressOnMigrate
+	return nil
+}
+
+func (c tierCodec) close() {
+	if z, ok := c.stage.(*zstdTransform); ok {
+		z.close()
+	}
+}
+
+// isCodec reports whether the stage name is a compression stage.
+func isCodec(name string) bool {
+	return name == zstdTransformName || name == s2TransformName
+}
+
+// codecOf returns the compression stage among stages, "" if none.
+func codecOf(stages []string) string {
+	for i := len(stages) - 1; i >= 0; i-- {
+		if isCodec(stages[i]) {
+			return stages[i]
+		}
+	}
+	return ""
+}
+
+// recoding is a block's encoding after recompression.
+type recoding struct {
+	stages         []string
+	checksum       uint32
+	took           time.Duration
+	incompressible bool // dst's codec skipped (see entropy.go)
+}
+
+// apply records r in meta.
+func (r *recoding) apply(meta *BlockMeta) {
+	meta.Transforms = r.stages
+	meta.Compressed = hasTransform(r.stages, zstdTransformName)
+	meta.Codec = codecOf(r.stages)
+	meta.Checksum = r.checksum
+	meta.EncodeTime += r.took
+	meta.Incompressible = r.incompressible
+}
+
+// recodes reports whether meta's block is recompressed moving to dst.
+func (s *Store) recodes(meta *BlockMeta, dst string) bool {
+	if !s.recompress || meta.Tier == dst || meta.Replica || meta.Share != "" || meta.Incompressible || s.keepsReplica(meta, dst) {
+		return false
+	}
+	if s.codecs[meta.Tier].spec == s.codecs[dst].spec {
+		return false
+	}
+	// Only the last stage may be a codec.
+	stages := meta.Transforms
+	if len(stages) == 0 && meta.Compressed {
+		stages = []string{zstdTransformName}
+	}
+	for i, name := range stages {
+		if isCodec(name) && i < len(stages)-1 {
+			return false
+		}
+	}
+	return true
+}
+
+// recode returns meta's stored payload recompressed with dst's codec.
+// It takes over payload.
+func (s *Store) recode(meta *BlockMeta, payload []byte, dst string) ([]byte, *recoding, error) {
+	if !s.verify(meta, payload) {
+		return nil, nil, fmt.Errorf("%w: recompress block %s: checksum mismatch", ErrCorrupted, meta.Key)
+	}
+	start := time.Now()
+	stages := meta.Transforms
+	if len(stages) == 0 && meta.Compressed {
+		stages = []string{zstdTransformName}
+	}
+	data := payload
+	if n := len(stages); n > 0 && isCodec(stages[n-1]) {
+		t, ok := s.transforms[stages[n-1]]
+		if !ok {
+			return nil, nil, fmt.Errorf("diskstore: block %s: transform %q not configured", meta.Key, stages[n-1])
+		}
+		out, err := t.Decode(payload)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: recompress block %s: %w", ErrCorrupted, meta.Key, err)
+		}
+		putBuf(payload)
+		data, stages = out, stages[:n-1]
+	}
+	stages = append([]string(nil), stages...)
+	skipped := false
+	if t := s.codecs[dst].stage; t != nil && s.incompressible(meta.DTypeStr, data) {
+		skipped = true
+	} else if t != nil {
+		out, err := t.Encode(data)
+		if err != nil {
+			return nil, nil, fmt.Errorf("diskstore: transform %s: %w", t.Name(), err)
+		}
+		data, stages = out, append(stages, t.Name())
+	}
+	return data, &recoding{stages: stages, checksum: checksum(data), took: time.Since(start), incompressible: skipped}, nil
+}
+
+// recodePayload is transferPayload for a block recompressed on the way
+// to dst.
+func (s *Store) recodePayload(meta *BlockMeta, dst string, stripe int) (transfer, error) {
+	payload, err := s.readPayload(context.Background(), meta, migrationIO)
+	if err != nil {
+		return transfer{}, err
+	}
+	data, r, err := s.recode(meta, payload, dst)
+	if err != nil {
+		return transfer{}, err
+	}
+	if err := s.writePayload(meta, dst, stripe, data); err != nil {
+		return transfer{}, err
+	}
+	putBuf(data)
+	return transfer{n: int64(len(data)), recoded: r}, nil
+}
@@ -0,0 +1,153 @@
+package diskstore
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+)
+
+func TestNewTierCodec(t *testing.T) {
+	requireZstd(t)
+	for spec, want := range map[string]string{"": CodecNone, "none": CodecNone, "s2": CodecS2, "zstd": "zstd:3", "zstd:19": "zstd:19"} {
+		c, err := newTierCodec(spec)
+		if err != nil || c.spec != want {
+			t.Errorf("newTierCodec(%q) = %q, %v; want %q", spec, c.spec, err, want)
+		}
+		c.close()
+	}
+	for _, bad := range []string{"lz4", "s2:3", "zstd:0", "zstd:23", "zstd:x"} {
+		if _, err := newTierCodec(bad); err == nil {
+			t.Errorf("newTierCodec(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestRecompressOnMigrate(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	open := func(recompress bool, transforms ...Transform) *Store {
+		store, err := New(Config{
+			LocalPath:           filepath.Join(dir, "local"),
+			RemotePath:          filepath.Join(dir, "remote"),
+			LocalBudget:         1024 * 1024,
+			RemoteBudget:        1024 * 1024,
+			LocalCodec:          CodecS2,
+			RemoteCodec:         "zstd:19",
+			RecompressOnMigrate: recompress,
+			Transforms:          transforms,
+			StatsInterval:       -1,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return store
+	}
+	// Values of two bits: S2, with no entropy coder, barely shrinks them.
+	rng := rand.New(rand.NewPCG(1, 2))
+	data := make([]byte, 4096)
+	for i := range data {
+		data[i] = byte(rng.IntN(4))
+	}
+	check := func(store *Store, key BlockKey, tier, codec string) {
+		t.Helper()
+		got, meta, err := store.Get(key)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Get = %d bytes, %v", len(got), err)
+		}
+		if meta.Tier != tier || meta.Codec != codec || meta.StoredBytes >= len(data) {
+			t.Errorf("block on %s in %q, %d bytes stored; want %s in %q", meta.Tier, meta.Codec, meta.StoredBytes, tier, codec)
+		}
+	}
+
+	store := open(true)
+	key := BlockKey{Seq: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	if err := store.Put(key, "f16", []int{2048}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	check(store, key, "local", CodecS2)
+	local := store.Stats().LocalUsed
+
+	// Evicted, the block is recompressed to zstd, and smaller.
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	check(store, key, "remote", CodecZstd)
+	if st := store.Stats(); st.RemoteUsed >= local || st.LocalUsed != 0 {
+		t.Errorf("used %d local, %d remote; want less than %d remote", st.LocalUsed, st.RemoteUsed, local)
+	}
+	// Promoted, it is S2 again.
+	if _, err := store.Migrate(1, "local"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	check(store, key, "local", CodecS2)
+	if res := store.Verify(); len(res.Corrupt)+len(res.Missing) > 0 {
+		t.Errorf("Verify: %+v", res)
+	}
+	store.Close()
+
+	// Without RecompressOnMigrate a block keeps its codec.
+	store = open(false)
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	check(store, key, "remote", CodecS2)
+	store.Close()
+
+	// Nor is a block compressed before another stage recompressed.
+	store = open(true, xorTransform{k: 0x5a})
+	defer store.Close()
+	key2 := BlockKey{Seq: 2, BeginPos: 0, EndPos: 4, IsKey: true}
+	if err := store.Put(key2, "f16", []int{2048}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := store.Migrate(2, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	check(store, key2, "remote", CodecZstd)
+}
+
+func TestRecompressEvictionBatch(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:           filepath.Join(dir, "local"),
+		RemotePath:          filepath.Join(dir, "remote"),
+		LocalBudget:         1024 * 1024,
+		RemoteBudget:        1024 * 1024,
+		LocalCodec:          CodecNone,
+		RemoteCodec:         CodecZstd,
+		RecompressOnMigrate: true,
+		MigrationWorkers:    4,
+		StatsInterval:       -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	data := bytes.Repeat([]byte("kv rows "), 128)
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	for i := range int32(4) {
+		if err := store.Put(key(i), "f16", []int{512}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if res := store.SetBudgets(1, 1024*1024); res.Moved != 4 {
+		t.Fatalf("SetBudgets = %+v, want 4 moved", res)
+	}
+	for i := range int32(4) {
+		got, meta, err := store.Get(key(i))
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+		if meta.Tier != "remote" || meta.Codec != CodecZstd || meta.StoredBytes >= len(data) {
+			t.Errorf("block %d on %s in %q, %d bytes stored", i, meta.Tier, meta.Codec, meta.StoredBytes)
+		}
+	}
+	if st := store.Stats(); st.RemoteUsed >= int64(4*len(data)) {
+		t.Errorf("RemoteUsed = %d, want under %d", st.RemoteUsed, 4*len(data))
+	}
+}
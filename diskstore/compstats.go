@@ -0,0 +1,80 @@
+package diskstore
+
+import "time"
+
+// Compression statistics: Stats reports how much the Put pipeline
+// (compression, quantization or any other Transform) saves on the blocks
+// held, in total, per layer and per dtype, and how long it took, so that
+// users can tell whether it is worth its CPU time for their model. A
+// block's logical size is its decoded size and its stored size what it
+// takes on disk; blocks sharing data through ForkSeq each count, and a
+// replicated block counts once.
+
+// CompressionStats is the size of a set of blocks before and after the
+// Put pipeline.
+type CompressionStats struct {
+	Blocks  int   `json:"blocks"`
+	Logical int64 `json:"logical"`
+	Stored  int64 `json:"stored"`
+
+	// Ratio is Logical / Stored, 1 for blocks stored as they are.
+	Ratio float64 `json:"ratio"`
+
+	// EncodeTime is the time the blocks' Put pipelines took.
+	EncodeTime time.Duration `json:"encode_time"`
+
+	// Incompressible counts the blocks stored without their compression
+	// stage (see entropy.go).
+	Incompressible int `json:"incompressible,omitempty"`
+}
+
+func (c *CompressionStats) add(meta *BlockMeta) {
+	c.Blocks++
+	c.Logical += int64(meta.SizeBytes)
+	c.Stored += int64(storedSize(meta))
+	c.EncodeTime += meta.EncodeTime
+	if meta.Incompressible {
+		c.Incompressible++
+	}
+}
+
+func (c *CompressionStats) finish() {
+	if c.Stored > 0 {
+		c.Ratio = float64(c.Logical) / float64(c.Stored)
+	}
+}
+
+// compressionStats returns the compression of all blocks, by layer and by
+// dtype. Must be called with s.mu held.
+func (s *Store) compressionStats() (CompressionStats, map[int]CompressionStats, map[string]CompressionStats) {
+	var total CompressionStats
+	byLayer := make(map[int]CompressionStats)
+	byDType := make(map[string]CompressionStats)
+	for _, meta := range s.index {
+		total.add(meta)
+		l := byLayer[meta.Key.Layer]
+		l.add(meta)
+		byLayer[meta.Key.Layer] = l
+		d := byDType[meta.DTypeStr]
+		d.add(meta)
+		byDType[meta.DTypeStr] = d
+	}
+	total.finish()
+	for k, c := range byLayer {
+		c.finish()
+		byLayer[k] = c
+	}
+	for k, c := range byDType {
+		c.finish()
+		byDType[k] = c
+	}
+	if len(s.index) == 0 {
+		byLayer, byDType = nil, nil
+	}
+	return total, byLayer, byDType
+}
+
+// countDecode records time spent reversing the Put pipeline.
+func (s *Store) countDecode(d time.Duration) {
+	s.decodeNanos.Add(int64(d))
+}
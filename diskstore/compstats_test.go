@@ -0,0 +1,58 @@
+package diskstore
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestCompressionStats(t *testing.T) {
+	if !zstdAvailable {
+		t.Skip("built without zstd")
+	}
+	store, err := New(Config{
+		LocalPath:     t.TempDir(),
+		LocalBudget:   1024 * 1024,
+		Compress:      true,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Layer 0 compresses well, layer 1 (random bytes) not at all.
+	noise := make([]byte, 4096)
+	rand.Read(noise)
+	for begin := int32(0); begin < 8; begin += 4 {
+		k0 := BlockKey{Seq: 1, Layer: 0, BeginPos: begin, EndPos: begin + 4, IsKey: true}
+		if err := store.Put(k0, "f16", []int{2048}, bytes.Repeat([]byte{1}, 4096)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		k1 := BlockKey{Seq: 1, Layer: 1, BeginPos: begin, EndPos: begin + 4, IsKey: true}
+		if err := store.Put(k1, "q8_0", []int{4096}, noise); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if _, _, err := store.Get(BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 4, IsKey: true}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+
+	st := store.Stats()
+	if c := st.Compression; c.Blocks != 4 || c.Logical != 4*4096 || c.Stored != st.LocalUsed || c.Ratio <= 1 {
+		t.Errorf("Compression = %+v, local used %d", c, st.LocalUsed)
+	}
+	l0, l1 := st.CompressionByLayer[0], st.CompressionByLayer[1]
+	if l0.Blocks != 2 || l0.Ratio < 10 || l1.Blocks != 2 || l1.Ratio > 1.01 {
+		t.Errorf("by layer: 0 %+v, 1 %+v", l0, l1)
+	}
+	if f16, q8 := st.CompressionByDType["f16"], st.CompressionByDType["q8_0"]; f16 != l0 || q8 != l1 {
+		t.Errorf("by dtype: f16 %+v, q8_0 %+v", f16, q8)
+	}
+	if l0.EncodeTime+l1.EncodeTime != st.Compression.EncodeTime || st.EncodeTime < st.Compression.EncodeTime {
+		t.Errorf("encode time: layers %v + %v, total %v, since open %v", l0.EncodeTime, l1.EncodeTime, st.Compression.EncodeTime, st.EncodeTime)
+	}
+	if st.DecodeTime <= 0 {
+		t.Error("no decode time after a Get")
+	}
+}
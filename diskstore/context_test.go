@@ -0,0 +1,66 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestContextCancelsReads(t *testing.T) {
+	tier := &flakyTier{blocks: make(map[string][]byte)}
+	store, err := New(Config{
+		LocalPath:              filepath.Join(t.TempDir(), "local"),
+		LocalBudget:            1024 * 1024,
+		RemoteBudget:           1024 * 1024,
+		RemoteTier:             tier,
+		RemoteFailureThreshold: 1,
+		StatsInterval:          -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 4, IsKey: true}
+	data := []byte("sixteen bytes...")
+	if err := store.Put(key, "f16", []int{8}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n, err := store.Migrate(1, "remote"); err != nil || n != 1 {
+		t.Fatalf("Migrate: %d, %v", n, err)
+	}
+
+	// Without a remote timeout a hung read only ends with its context,
+	// and the tier isn't blamed for it.
+	hang := make(chan struct{})
+	defer close(hang)
+	tier.set(0, hang)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if _, _, err := store.GetContext(ctx, key); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("GetContext from a hung tier: %v, want the deadline", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("GetContext from a hung tier took %v", d)
+	}
+	if _, end, err := store.ReadRangeContext(ctx, key); !errors.Is(err, context.DeadlineExceeded) || end != 0 {
+		t.Errorf("ReadRangeContext after the deadline: end %d, %v", end, err)
+	}
+	if st := store.Stats(); !st.RemoteDownUntil.IsZero() || st.RemoteTimeouts != 0 || st.CorruptReads != 0 {
+		t.Errorf("abandoned reads counted against the tier: %+v", st)
+	}
+
+	// A cancelled Put stores nothing.
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	other := BlockKey{Seq: 2, Layer: 0, BeginPos: 0, EndPos: 4, IsKey: true}
+	if err := store.PutContext(cancelled, other, "f16", []int{8}, data); !errors.Is(err, context.Canceled) {
+		t.Errorf("PutContext with a cancelled context: %v", err)
+	}
+	if store.Has(other) {
+		t.Error("cancelled PutContext stored the block")
+	}
+}
@@ -0,0 +1,42 @@
+package diskstore
+
+import "sync"
+
+// Decode workers: decompressing blocks, not reading them, bounds how fast
+// a restore from an SSD runs when blocks are compressed. With
+// Config.DecodeWorkers above one, ReadRanges hands the blocks of a batch
+// to a pool of that many goroutines, which decode them concurrently, each
+// into a buffer of its own; the rows are then assembled in order on the
+// caller's goroutine. The built-in zstd stage gets as many decoders.
+
+// decodeLoop is a decode worker, running jobs until Close.
+func (s *Store) decodeLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case job := <-s.decodeJobs:
+			job()
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// decodeAll runs jobs on the decode workers and waits for them. Jobs
+// handed over once the store is closing run on the caller's goroutine.
+func (s *Store) decodeAll(jobs []func()) {
+	var wg sync.WaitGroup
+	wg.Add(len(jobs))
+	for _, job := range jobs {
+		run := func() {
+			defer wg.Done()
+			job()
+		}
+		select {
+		case s.decodeJobs <- run:
+		case <-s.done:
+			run()
+		}
+	}
+	wg.Wait()
+}
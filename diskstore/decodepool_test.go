@@ -0,0 +1,58 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRangesDecodeWorkers(t *testing.T) {
+	if !zstdAvailable {
+		t.Skip("built without zstd")
+	}
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		Compress:      true,
+		DecodeWorkers: 4,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Sixteen layers of two compressed blocks, the last layer's second
+	// block missing.
+	const layers = 16
+	rows := func(layer int) []byte {
+		return bytes.Repeat([]byte{byte(layer)}, 64)
+	}
+	var keys []BlockKey
+	for layer := range layers {
+		for begin := int32(0); begin < 8; begin += 4 {
+			if layer == layers-1 && begin == 4 {
+				continue
+			}
+			key := BlockKey{Seq: 1, Layer: layer, BeginPos: begin, EndPos: begin + 4, IsKey: true}
+			if err := store.Put(key, "f16", []int{4}, rows(layer)[:32]); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+		keys = append(keys, BlockKey{Seq: 1, Layer: layer, BeginPos: 2, EndPos: 8, IsKey: true})
+	}
+
+	for i, got := range store.ReadRanges(keys) {
+		want, end := rows(i)[:48], int32(8)
+		if i == layers-1 {
+			want, end = rows(i)[:16], 4
+		}
+		if got.Err != nil || !bytes.Equal(got.Data, want) || got.End != end {
+			t.Errorf("layer %d: got %d bytes end %d, %v; want %d bytes end %d", i, len(got.Data), got.End, got.Err, len(want), end)
+		}
+	}
+	if n := store.Stats().Hits; n != 2*layers-1 {
+		t.Errorf("Hits = %d, want %d", n, 2*layers-1)
+	}
+}
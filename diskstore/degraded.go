@@ -0,0 +1,130 @@
+package diskstore
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Degraded mode: when the remote tier goes away (an NFS mount dropped, a
+// block service down), the store keeps serving and storing local blocks.
+// Every Config.RemoteCheckInterval a health check looks for the marker
+// file the store keeps in the remote directory, or pings a block service
+// implementing Pinger. While it fails the store is degraded: remote-tier
+// operations fail at once with ErrRemoteUnavailable, Puts write to the
+// local tier past its budget instead of evicting, and removals of remote
+// blocks are queued. Once the check passes again the store re-syncs: it
+// replays the queued removals and evicts the local tier back within its
+// budget. Stats.Degraded reports the state, which the circuit breaker
+// (see remoteio.go) sets too while it is open.
+//
+// Queued removals are kept in memory only; after a restart the files are
+// orphans, removed by the next GC.
+
+// remoteMarker is the file whose presence tells the health check that
+// the remote directory is the store's and not a bare mount point.
+const remoteMarker = ".kvtier-remote"
+
+// defaultRemoteCheckInterval is how often the remote tier is checked.
+const defaultRemoteCheckInterval = 10 * time.Second
+
+// markRemote creates the remote directory's marker if it is missing.
+func markRemote(dir string) error {
+	path := filepath.Join(dir, remoteMarker)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return os.WriteFile(path, nil, 0644)
+}
+
+// Degraded reports whether the remote tier is unavailable, by the last
+// health check or the circuit breaker.
+func (s *Store) Degraded() bool {
+	return s.degraded.Load() || !s.rio.available()
+}
+
+// probeRemote checks that the remote tier is there, within the remote
+// I/O timeout.
+func (s *Store) probeRemote() error {
+	_, err := runTimeout(context.Background(), &s.rio, func() (struct{}, error) {
+		if s.remote != nil {
+			if p, ok := s.remote.(Pinger); ok {
+				return struct{}{}, p.Ping(context.Background())
+			}
+			return struct{}{}, nil
+		}
+		_, err := os.Stat(filepath.Join(s.remotePath, remoteMarker))
+		return struct{}{}, err
+	})
+	return err
+}
+
+func (s *Store) remoteCheckLoop(interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.checkRemote()
+		}
+	}
+}
+
+// checkRemote runs the health check, entering or leaving degraded mode.
+func (s *Store) checkRemote() {
+	err := s.probeRemote()
+	if err != nil {
+		if !s.degraded.Swap(true) {
+			s.log.Warn("remote tier unavailable, serving local blocks only", "error", err)
+		}
+		return
+	}
+	was := s.degraded.Swap(false)
+	s.rio.observe(nil)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if was || len(s.pendingRemovals) > 0 {
+		removed, evicted := s.resyncRemote()
+		s.log.Info("remote tier available, re-synced", "removed", removed, "evicted", evicted)
+	}
+}
+
+// resyncRemote replays the queued removals of remote blocks and moves
+// local blocks to the remote tier until the local tier is within budget.
+// Must be called with s.mu held.
+func (s *Store) resyncRemote() (removed, evicted int) {
+	pending := s.pendingRemovals
+	s.pendingRemovals = nil
+	for i := range pending {
+		meta := &pending[i]
+		if m, ok := s.index[meta.Key.String()]; ok && m.Tier == "remote" {
+			continue // stored on the remote tier again since
+		}
+		if err := s.removePayload(meta); err != nil {
+			s.log.Warn("remove block queued while degraded", "key", meta.Key, "error", err)
+			continue
+		}
+		removed++
+	}
+	for s.localUsed > s.localBudget {
+		n := s.evictLocal(nil, s.localUsed-s.localBudget)
+		if n == 0 {
+			break
+		}
+		evicted += n
+	}
+	return removed, evicted
+}
+
+// deferRemoval queues the removal of meta's remote payload for the next
+// re-sync. Must be called with s.mu held.
+func (s *Store) deferRemoval(meta *BlockMeta) {
+	m := *meta
+	m.Bundle = nil
+	s.pendingRemovals = append(s.pendingRemovals, m)
+}
@@ -0,0 +1,87 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDegradedMode(t *testing.T) {
+	dir := t.TempDir()
+	remote := filepath.Join(dir, "remote")
+	store, err := New(Config{
+		LocalPath:           filepath.Join(dir, "local"),
+		RemotePath:          remote,
+		LocalBudget:         64,
+		RemoteBudget:        1024 * 1024,
+		RemoteCheckInterval: -1, // checked by hand below
+		StatsInterval:       -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(seq, layer int) BlockKey {
+		return BlockKey{Seq: seq, Layer: layer, BeginPos: 0, EndPos: 4, IsKey: true}
+	}
+	data := bytes.Repeat([]byte{1}, 32)
+	for layer := range 4 {
+		if err := store.Put(key(1, layer), "f16", []int{16}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if st := store.Stats(); st.RemoteBlocks != 2 || st.Degraded {
+		t.Fatalf("before unmounting: %d remote blocks, degraded %v", st.RemoteBlocks, st.Degraded)
+	}
+
+	// The mount goes away.
+	if err := os.Rename(remote, remote+".gone"); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(remote, 0755) // the bare mount point
+	store.checkRemote()
+	if !store.Stats().Degraded {
+		t.Fatal("not degraded after the remote tier went away")
+	}
+
+	// Local blocks are served and Puts land locally, past the budget.
+	if got, _, err := store.Get(key(1, 3)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get of a local block while degraded: %v", err)
+	}
+	if _, _, err := store.Get(key(1, 0)); !errors.Is(err, ErrRemoteUnavailable) {
+		t.Errorf("Get of a remote block while degraded: %v, want ErrRemoteUnavailable", err)
+	}
+	for layer := range 3 {
+		if err := store.Put(key(2, layer), "f16", []int{16}, data); err != nil {
+			t.Fatalf("Put while degraded: %v", err)
+		}
+	}
+	if got := store.Stats().LocalUsed; got != 160 {
+		t.Errorf("LocalUsed while degraded = %d, want 160", got)
+	}
+	store.RemoveSeq(1)
+	waitReaped(t, store)
+
+	// The mount returns: the removal is replayed and the local tier
+	// evicted back within its budget.
+	os.Remove(remote)
+	if err := os.Rename(remote+".gone", remote); err != nil {
+		t.Fatal(err)
+	}
+	store.checkRemote()
+	st := store.Stats()
+	if st.Degraded || st.LocalUsed > 64 || st.RemoteBlocks != 1 {
+		t.Errorf("after re-sync: degraded %v, LocalUsed %d, %d remote blocks", st.Degraded, st.LocalUsed, st.RemoteBlocks)
+	}
+	for layer := range 2 {
+		if _, err := os.Stat(store.blockPath(key(1, layer), "remote", 0)); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("removed block %d still on the remote tier: %v", layer, err)
+		}
+	}
+	if got, _, err := store.Get(key(2, 0)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get of a block evicted on re-sync: %v", err)
+	}
+}
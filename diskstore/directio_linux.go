@@ -0,0 +1,8 @@
+package diskstore
+
+import "syscall"
+
+// directIOAvailable reports whether this platform has O_DIRECT.
+const directIOAvailable = true
+
+const oDirect = syscall.O_DIRECT
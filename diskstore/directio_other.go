@@ -0,0 +1,8 @@
+//go:build !linux
+
+package diskstore
+
+const directIOAvailable = false
+
+// oDirect is never used: Config.DirectIO is turned off on this platform.
+const oDirect = 0
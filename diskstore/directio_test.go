@@ -0,0 +1,43 @@
+package diskstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"unsafe"
+)
+
+func TestDirectIO(t *testing.T) {
+	for _, n := range []int{0, 1, directIOAlign, 3 * directIOAlign / 2} {
+		buf := alignedBuf(n)
+		if len(buf) != n || uintptr(unsafe.Pointer(unsafe.SliceData(buf)))%directIOAlign != 0 {
+			t.Errorf("alignedBuf(%d): len %d at %p", n, len(buf), unsafe.SliceData(buf))
+		}
+	}
+
+	dir := t.TempDir()
+	store, err := New(Config{LocalPath: dir, LocalBudget: 1 << 20, DirectIO: true, MmapReads: true, StatsInterval: -1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	if store.mmap.on {
+		t.Error("mapped reads on with direct I/O")
+	}
+
+	// Payloads that aren't a whole number of blocks are padded on write
+	// and truncated back.
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 5, IsKey: true}
+	data := bytes.Repeat([]byte{1, 2, 3, 4, 5}, 1001)
+	if err := store.Put(key, "f16", []int{8}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if fi, err := os.Stat(store.blockPath(key, "local", 0)); err != nil || fi.Size() != int64(len(data)) {
+		t.Fatalf("block file: %v, %v; want %d bytes", fi, err, len(data))
+	}
+	got, _, err := store.Get(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get = %d bytes, %v", len(got), err)
+	}
+	t.Logf("direct I/O in use: %v", store.directEnabled())
+}
@@ -0,0 +1,97 @@
+package diskstore
+
+import "time"
+
+// Free-space budgets: a fixed byte budget goes stale as other data fills
+// the disk. With Config.LocalBudgetPercent (or RemoteBudgetPercent) the
+// tier's budget is instead re-derived every BudgetInterval from its
+// filesystem's free space: that percentage of the space the tier could
+// use, which is the free space plus what the store already holds there.
+// LocalMinFree (RemoteMinFree) is free space always left, lowering the
+// budget as the disk fills, with or without a percentage; a fixed budget
+// set alongside caps the derived one. A shrinking local budget moves
+// blocks to the remote tier as SetBudgets does. A remote block service
+// has no filesystem to ask, so its budget stays fixed.
+
+// defaultBudgetInterval is how often free-space budgets are re-derived.
+const defaultBudgetInterval = time.Minute
+
+// diskBudget derives a tier's budget from its filesystem's free space.
+type diskBudget struct {
+	percent float64 // of the space the tier could use; 0 is off
+	minFree int64
+	fixed   int64 // the configured budget, capping the derived one
+}
+
+// enabled reports whether the budget depends on free space.
+func (b diskBudget) enabled() bool {
+	return b.percent > 0 || b.minFree > 0
+}
+
+// derive returns the budget of a tier at path holding used bytes.
+func (b diskBudget) derive(path string, used int64) (int64, error) {
+	free, err := diskFree(path)
+	if err != nil {
+		return 0, err
+	}
+	budget := used + free - b.minFree
+	if b.percent > 0 {
+		budget = min(budget, int64(float64(used+free)*b.percent/100))
+	}
+	if b.fixed > 0 {
+		budget = min(budget, b.fixed)
+	}
+	return max(budget, 0), nil
+}
+
+// refreshBudgets re-derives the free-space budgets and applies them if
+// either moved by more than 1%.
+func (s *Store) refreshBudgets() {
+	s.mu.RLock()
+	local, remote := s.localBudget, s.remoteBudget
+	localUsed, remoteUsed := s.localUsed, s.remoteUsed
+	localDisk, remoteDisk := s.localDisk, s.remoteDisk // Reload may change them
+	s.mu.RUnlock()
+
+	newLocal, newRemote := local, remote
+	if localDisk.enabled() {
+		b, err := localDisk.derive(s.localPath, localUsed)
+		if err != nil {
+			s.log.Warn("free-space budget", "tier", "local", "error", err)
+		} else {
+			newLocal = b
+		}
+	}
+	if remoteDisk.enabled() && !s.Degraded() {
+		b, err := remoteDisk.derive(s.remotePath, remoteUsed)
+		if err != nil {
+			s.log.Warn("free-space budget", "tier", "remote", "error", err)
+		} else {
+			newRemote = b
+		}
+	}
+	if budgetMoved(local, newLocal) || budgetMoved(remote, newRemote) {
+		s.SetBudgets(newLocal, newRemote)
+	}
+}
+
+// budgetMoved reports whether a budget changed from old to new by more
+// than 1%.
+func budgetMoved(old, new int64) bool {
+	d := new - old
+	return d > old/100 || -d > old/100
+}
+
+func (s *Store) budgetLoop(interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.refreshBudgets()
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestFreeSpaceBudget(t *testing.T) {
+	dir := t.TempDir()
+	free, err := diskFree(dir)
+	if err != nil {
+		t.Skipf("free space: %v", err)
+	}
+	store, err := New(Config{
+		LocalPath:          filepath.Join(dir, "local"),
+		RemotePath:         filepath.Join(dir, "remote"),
+		LocalBudgetPercent: 50,
+		RemoteBudget:       1024 * 1024,
+		StatsInterval:      -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Other writers share the filesystem, so allow for some drift.
+	st := store.Stats()
+	if st.LocalBudget < free*4/10 || st.LocalBudget > free*6/10 {
+		t.Errorf("LocalBudget = %d, want about half of %d free", st.LocalBudget, free)
+	}
+	if st.RemoteBudget != 1024*1024 {
+		t.Errorf("RemoteBudget = %d, want the fixed budget", st.RemoteBudget)
+	}
+
+	// A floor above the free space leaves no budget.
+	store.localDisk.minFree = free * 2
+	store.refreshBudgets()
+	if n := store.Stats().LocalBudget; n != 0 {
+		t.Errorf("LocalBudget = %d, want 0", n)
+	}
+}
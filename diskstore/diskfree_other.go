@@ -0,0 +1,14 @@
+//go:build !(linux || darwin || freebsd || dragonfly || windows)
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+)
+
+func diskFree(path string) (int64, error) {
+	return 0, errors.New("diskstore: free space not available on this platform")
+}
+
+func allocatedSize(info os.FileInfo) int64 { return info.Size() }
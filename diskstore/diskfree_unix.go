@@ -0,0 +1,28 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package diskstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// diskFree returns the bytes available to unprivileged users on the
+// filesystem holding path.
+func diskFree(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// allocatedSize returns the disk space info's file takes, which is less
+// than its size if it is sparse and more if preallocated or rounded up to
+// whole blocks.
+func allocatedSize(info os.FileInfo) int64 {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int64(st.Blocks) * 512
+	}
+	return info.Size()
+}
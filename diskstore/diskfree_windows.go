@@ -0,0 +1,30 @@
+//go:build windows
+
+package diskstore
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceEx = kernel32.NewProc("GetDiskFreeSpaceExW")
+
+// diskFree returns the bytes available to the calling user on the
+// volume holding path.
+func diskFree(path string) (int64, error) {
+	p, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, err
+	}
+	var avail uint64
+	r, _, err := procGetDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(p)), uintptr(unsafe.Pointer(&avail)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return int64(avail), nil
+}
+
+// allocatedSize returns info's size: compressed and sparse files are
+// rare on the volumes a store uses.
+func allocatedSize(info os.FileInfo) int64 { return info.Size() }
@@ -0,0 +1,34 @@
+package diskstore
+
+// Full disks: the local budget is only as good as the space actually
+// free, and a disk shared with other data, or a budget set too high,
+// fills before the store thinks it should. A Put that finds the local
+// disk full (ENOSPC, or the user's quota) doesn't fail: the block isn't
+// stored, as if never offered, a block of the same key stays (see
+// replace.go), the partial file is removed and Stats.DiskFullDrops
+// counts it, so a context shift that snapshots its blocks goes on and
+// the runner recomputes them if they are needed.
+// The block service (see remote.go) is the exception: it refuses such a
+// block with 507 Insufficient Storage, an error wrapping
+// ErrBudgetExceeded for the RemoteClient, so a node evicting to it keeps
+// its local copy instead of freeing a block that was never stored.
+// With Config.ShrinkOnDiskFull the local budget also drops to what the
+// store holds, so later Puts first evict to the remote tier instead of
+// running into the same wall; SetBudgets, Reload or a free-space budget
+// (see diskbudget.go) raise it again.
+
+// dropOnDiskFull gives up storing meta's block after its write found
+// the local disk full with err. Must be called with s.mu held.
+func (s *Store) dropOnDiskFull(meta *BlockMeta, err error) {
+	s.diskFullDrops++
+	if !s.diskFull {
+		s.diskFull = true
+		s.log.Warn("local disk full, dropping blocks", "key", meta.Key, "used", s.localUsed, "budget", s.localBudget, "error", err)
+	} else {
+		s.log.Debug("local disk full, dropped block", "key", meta.Key)
+	}
+	if s.shrinkOnFull && s.localUsed < s.localBudget {
+		s.log.Warn("shrinking local budget to what the disk holds", "from", s.localBudget, "to", s.localUsed)
+		s.localBudget = s.localUsed
+	}
+}
@@ -0,0 +1,12 @@
+//go:build unix && !(linux || darwin || freebsd || dragonfly)
+
+package diskstore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// diskFull reports whether err is a write refused for want of space.
+// Quotas have no errno of their own on every unix.
+func diskFull(err error) bool { return errors.Is(err, syscall.ENOSPC) }
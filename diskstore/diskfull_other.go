@@ -0,0 +1,7 @@
+//go:build !unix && !windows
+
+package diskstore
+
+// diskFull can't tell a full disk from other write errors where errors
+// aren't errnos, as on Plan 9: a Put there fails with the write's error.
+func diskFull(err error) bool { return false }
@@ -0,0 +1,191 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPutDiskFull(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full to fail writes with ENOSPC")
+	}
+	store, err := New(Config{
+		LocalPath:        t.TempDir(),
+		LocalBudget:      1 << 20,
+		StatsInterval:    -1,
+		ShrinkOnDiskFull: true,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	stored := BlockKey{Seq: 1, EndPos: 1, IsKey: true}
+	if err := store.Put(stored, "f16", []int{50}, make([]byte, 100)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// A block file that is /dev/full fails its write with ENOSPC.
+	full := BlockKey{Seq: 1, BeginPos: 1, EndPos: 2, IsKey: true}
+	path := store.blockPath(full, "local", 0)
+	if err := os.Symlink("/dev/full", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(full, "f16", []int{50}, make([]byte, 100)); err != nil {
+		t.Fatalf("Put on a full disk: %v, want it dropped", err)
+	}
+	if store.Has(full) {
+		t.Error("block stored on a full disk")
+	}
+	if _, err := os.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("partial block file left: %v", err)
+	}
+	st := store.Stats()
+	if st.DiskFullDrops != 1 || st.LocalBlocks != 1 {
+		t.Errorf("DiskFullDrops = %d, LocalBlocks = %d; want 1, 1", st.DiskFullDrops, st.LocalBlocks)
+	}
+	if st.LocalBudget != st.LocalUsed || st.LocalUsed != 100 {
+		t.Errorf("local budget %d, used %d; want both shrunk to 100", st.LocalBudget, st.LocalUsed)
+	}
+
+	// Once there is space again, the block is stored.
+	if err := store.Put(full, "f16", []int{50}, make([]byte, 100)); err != nil || !store.Has(full) {
+		t.Errorf("Put after the disk has space: %v", err)
+	}
+}
+
+func TestReplaceDiskFull(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full to fail writes with ENOSPC")
+	}
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	key := BlockKey{Seq: 1, EndPos: 1, IsKey: true}
+	old, replacement := bytes.Repeat([]byte{1}, 100), bytes.Repeat([]byte{2}, 100)
+	if err := store.Put(key, "f16", []int{50}, old); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// The new block's write fails with ENOSPC: the remote one it was
+	// to replace is kept.
+	path := store.blockPath(key, "local", 0)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err := os.Symlink("/dev/full", path); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(key, "f16", []int{50}, replacement); err != nil {
+		t.Fatalf("Put on a full disk: %v, want it dropped", err)
+	}
+	if data, meta, err := store.Get(key); err != nil || !bytes.Equal(data, old) || meta.Tier != "remote" {
+		t.Fatalf("after a dropped replacement: %v; want the old block on the remote tier", err)
+	}
+	st := store.Stats()
+	if st.DiskFullDrops != 1 || st.RemoteBlocks != 1 || st.RemoteUsed != 100 || st.LocalUsed != 0 {
+		t.Errorf("stats %+v; want one drop and the old block counted once", st)
+	}
+
+	// With space again it is replaced.
+	os.Remove(path)
+	if err := store.Put(key, "f16", []int{50}, replacement); err != nil {
+		t.Fatalf("Put with space: %v", err)
+	}
+	if data, meta, err := store.Get(key); err != nil || !bytes.Equal(data, replacement) || meta.Tier != "local" {
+		t.Errorf("replaced block: %v, want the new data on the local tier", err)
+	}
+	if st := store.Stats(); st.RemoteUsed != 0 || st.LocalUsed != 100 {
+		t.Errorf("local used %d, remote used %d; want 100, 0", st.LocalUsed, st.RemoteUsed)
+	}
+
+	// Replacing a block keeps a fork sharing its file intact.
+	src, fork := BlockKey{Seq: 3, EndPos: 1, IsKey: true}, BlockKey{Seq: 4, EndPos: 1, IsKey: true}
+	store.Put(src, "f16", []int{50}, old)
+	if _, err := store.ForkSeq(3, 4); err != nil {
+		t.Fatalf("ForkSeq: %v", err)
+	}
+	if err := store.Put(src, "f16", []int{50}, replacement); err != nil {
+		t.Fatalf("Put over a forked block: %v", err)
+	}
+	if data, _, err := store.Get(fork); err != nil || !bytes.Equal(data, old) {
+		t.Errorf("fork after replacing its source: %v, want the old data", err)
+	}
+	if res, err := store.GC(); err != nil || res.OrphanFiles != 0 || res.MissingBlocks != 0 {
+		t.Errorf("GC = %+v, %v; want nothing left aside", res, err)
+	}
+}
+
+func TestServiceDiskFull(t *testing.T) {
+	if _, err := os.Stat("/dev/full"); err != nil {
+		t.Skip("no /dev/full to fail writes with ENOSPC")
+	}
+	dir := t.TempDir()
+	node, err := New(Config{LocalPath: filepath.Join(dir, "node"), LocalBudget: 1 << 20, StatsInterval: -1})
+	if err != nil {
+		t.Fatalf("New node: %v", err)
+	}
+	defer node.Close()
+	srv := httptest.NewServer(node.BlockServiceHandler())
+	defer srv.Close()
+	gpu, err := New(Config{
+		LocalPath:     filepath.Join(dir, "gpu"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+		RemoteTier:    NewRemoteClient(srv.URL, time.Second),
+	})
+	if err != nil {
+		t.Fatalf("New gpu: %v", err)
+	}
+	defer gpu.Close()
+
+	key := BlockKey{Seq: 1, EndPos: 1, IsKey: true}
+	data := bytes.Repeat([]byte{7}, 100)
+	if err := gpu.Put(key, "f16", []int{50}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	path := node.blockPath(key, "local", 0)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("/dev/full", path); err != nil {
+		t.Fatal(err)
+	}
+
+	// The node refuses the block, and the evicting store keeps it.
+	if _, err := gpu.Migrate(1, "remote"); !errors.Is(err, ErrBudgetExceeded) {
+		t.Fatalf("Migrate to a full node: %v, want ErrBudgetExceeded", err)
+	}
+	if got, meta, err := gpu.Get(key); err != nil || meta.Tier != "local" || !bytes.Equal(got, data) {
+		t.Fatalf("after a refused eviction: %v, %+v; want the local block", err, meta)
+	}
+	if node.Has(key) {
+		t.Error("node holds a block it refused")
+	}
+	if gpu.Degraded() || gpu.Stats().RemoteRetries != 0 {
+		t.Error("a refused block counted as a remote tier failure")
+	}
+
+	os.Remove(path)
+	if n, err := gpu.Migrate(1, "remote"); err != nil || n != 1 {
+		t.Fatalf("Migrate once the node has space: %d, %v", n, err)
+	}
+	if got, _, err := gpu.Get(key); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get from the node: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package diskstore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// diskFull reports whether err is a write refused for want of space,
+// on the disk or in the user's quota.
+func diskFull(err error) bool {
+	return errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EDQUOT)
+}
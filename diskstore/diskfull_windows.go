@@ -0,0 +1,19 @@
+//go:build windows
+
+package diskstore
+
+import (
+	"errors"
+	"syscall"
+)
+
+// Windows errors for a full disk.
+const (
+	errorHandleDiskFull syscall.Errno = 39
+	errorDiskFull       syscall.Errno = 112
+)
+
+// diskFull reports whether err is a write refused for want of space.
+func diskFull(err error) bool {
+	return errors.Is(err, errorDiskFull) || errors.Is(err, errorHandleDiskFull)
+}
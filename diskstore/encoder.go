@@ -0,0 +1,48 @@
+package diskstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Encoder outputs: a multimodal model with an encoder cache (Ollama's
+// EncoderCache, holding an image's cross-attention keys and values) can
+// store that output keyed by the hash of the image, so the same image in
+// a later request skips the vision encoder. Such keys carry the image
+// hash in BlockKey.Prefix and EncoderSeq as their sequence, and cover
+// rows [0, n) of the output.
+
+// EncoderSeq is the sequence ID of encoder output blocks.
+const EncoderSeq = -2
+
+// ImageHash returns the hash EncoderKey expects for an image's data.
+func ImageHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:16])
+}
+
+// EncoderKey returns the key of layer's encoder output of n rows for the
+// image hashing to hash.
+func EncoderKey(ns, hash string, layer int, n int32, isKey bool) BlockKey {
+	return BlockKey{
+		Namespace: ns,
+		Seq:       EncoderSeq,
+		Prefix:    hash,
+		Layer:     layer,
+		EndPos:    n,
+		IsKey:     isKey,
+	}
+}
+
+// EncoderLen returns the number of rows of the encoder output stored for
+// the image hashing to hash in ns, if any layer of it is stored.
+func (s *Store) EncoderLen(ns, hash string) (int32, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, meta := range s.index {
+		if k := meta.Key; k.Seq == EncoderSeq && k.Prefix == hash && k.Namespace == ns {
+			return k.EndPos, true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,57 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestEncoderKeys(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+		Fingerprint: &Fingerprint{ModelDigest: "mllama", NLayers: 4, DType: "f16"},
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	image := []byte("not really a png")
+	hash := ImageHash(image)
+	if hash != ImageHash(bytes.Clone(image)) || hash == ImageHash([]byte("another image")) {
+		t.Fatalf("ImageHash is not a function of the data: %s", hash)
+	}
+
+	// Encoder outputs are f32 although the cache is f16.
+	key := EncoderKey("", hash, 1, 6, true)
+	if err := store.Put(key, "f32", []int{4}, bytes.Repeat([]byte{7}, 6*16)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if err := store.Put(PrefixKey("", hash, 1, 0, 6, true), "f32", []int{4}, make([]byte, 6*16)); err == nil {
+		t.Error("Put accepted an f32 prefix block")
+	}
+	if key.String() == PrefixKey("", hash, 1, 0, 6, true).String() {
+		t.Errorf("encoder and prefix keys share the name %s", key)
+	}
+	store.Close()
+
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if n, ok := store.EncoderLen("", hash); !ok || n != 6 {
+		t.Errorf("EncoderLen = %d, %v; want 6, true", n, ok)
+	}
+	if _, ok := store.EncoderLen("", ImageHash([]byte("another image"))); ok {
+		t.Error("EncoderLen found an image that was never stored")
+	}
+	if err := store.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, ok := store.TakeExpired(EncoderSeq); ok {
+		t.Error("encoder outputs reported as an expired sequence")
+	}
+}
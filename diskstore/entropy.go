@@ -0,0 +1,67 @@
+package diskstore
+
+import "math"
+
+// Incompressible blocks: quantized KV (q8_0, q4_0) barely compresses, so
+// running zstd on it spends CPU for a few percent. With
+// Config.IncompressibleEntropy set, the compression stage of the Put
+// pipeline, and recompression on migration (see codec.go), first takes
+// the Shannon entropy of a sample of the bytes it would compress and
+// passes them through unchanged if it reaches that many bits per byte.
+// Config.RawDTypes skips the stage outright for the dtypes listed. The
+// block's BlockMeta records the decision as Incompressible; such a block
+// keeps no compression stage when it moves between tiers either. Left
+// with no stage at all, it can also be memory-mapped (see mmap.go) and
+// read in part from a WebDAV tier (see partialread.go).
+//
+// The sample is entropySamples windows of entropyWindow bytes spread
+// evenly over the data, or all of it when shorter: order-0 entropy
+// misses repeated sequences that are not skewed byte values, but KV
+// tensors have few of those, and a page of histogram counting costs a
+// fraction of a compression pass.
+
+const (
+	entropySamples = 16
+	entropyWindow  = 256
+)
+
+// sampleEntropy returns the entropy of a sample of data, in bits per
+// byte: 0 for a single repeated byte, near 8 for random data.
+func sampleEntropy(data []byte) float64 {
+	var counts [256]int
+	n := 0
+	if len(data) <= entropySamples*entropyWindow {
+		for _, b := range data {
+			counts[b]++
+		}
+		n = len(data)
+	} else {
+		stride := (len(data) - entropyWindow) / (entropySamples - 1)
+		for i := range entropySamples {
+			for _, b := range data[i*stride : i*stride+entropyWindow] {
+				counts[b]++
+			}
+		}
+		n = entropySamples * entropyWindow
+	}
+	if n == 0 {
+		return 0
+	}
+	h := 0.0
+	for _, c := range counts {
+		if c > 0 {
+			p := float64(c) / float64(n)
+			h -= p * math.Log2(p)
+		}
+	}
+	return h
+}
+
+// incompressible reports whether data, for a block of dtype about to be
+// compressed, is to be stored as it is.
+func (s *Store) incompressible(dtype string, data []byte) bool {
+	if s.rawDTypes[dtype] {
+		return true
+	}
+	return s.entropyLimit > 0 && sampleEntropy(data) >= s.entropyLimit
+}
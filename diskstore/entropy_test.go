@@ -0,0 +1,101 @@
+package diskstore
+
+import (
+	"bytes"
+	"math/rand/v2"
+	"path/filepath"
+	"testing"
+)
+
+func TestSampleEntropy(t *testing.T) {
+	rng := rand.New(rand.NewPCG(1, 2))
+	random := make([]byte, 1<<20)
+	twoBits := make([]byte, 1<<20)
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+		twoBits[i] = byte(rng.IntN(4))
+	}
+	for _, tc := range []struct {
+		name     string
+		data     []byte
+		min, max float64
+	}{
+		{"empty", nil, 0, 0},
+		{"zeros", make([]byte, 1<<20), 0, 0},
+		{"two bits", twoBits, 1.95, 2},
+		{"random", random, 7.9, 8},
+		{"short random", random[:1000], 7.5, 8},
+	} {
+		if h := sampleEntropy(tc.data); h < tc.min || h > tc.max {
+			t.Errorf("%s: entropy %.3f, want %g to %g", tc.name, h, tc.min, tc.max)
+		}
+	}
+}
+
+func TestIncompressibleBlocks(t *testing.T) {
+	requireZstd(t)
+	if _, err := New(Config{LocalPath: t.TempDir(), IncompressibleEntropy: 9}); err == nil {
+		t.Error("New with IncompressibleEntropy 9 succeeded")
+	}
+
+	rng := rand.New(rand.NewPCG(3, 4))
+	random := make([]byte, 4096)
+	for i := range random {
+		random[i] = byte(rng.Uint32())
+	}
+	text := bytes.Repeat([]byte("kv rows "), 512)
+	open := func(cfg Config) *Store {
+		dir := t.TempDir()
+		cfg.LocalPath = filepath.Join(dir, "local")
+		cfg.RemotePath = filepath.Join(dir, "remote")
+		cfg.LocalBudget, cfg.RemoteBudget = 1024*1024, 1024*1024
+		cfg.IncompressibleEntropy = 7.5
+		cfg.RawDTypes = []string{"q8_0"}
+		cfg.StatsInterval = -1
+		store, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	put := func(store *Store, pos int32, dtype string, data []byte) BlockKey {
+		t.Helper()
+		key := BlockKey{Seq: 1, BeginPos: pos, EndPos: pos + 1, IsKey: true}
+		if err := store.Put(key, dtype, []int{len(data)}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return key
+	}
+	check := func(store *Store, key BlockKey, data []byte, codec string, incompressible bool) {
+		t.Helper()
+		got, meta, err := store.Get(key)
+		if err != nil || !bytes.Equal(got, data) {
+			t.Fatalf("Get = %d bytes, %v", len(got), err)
+		}
+		if meta.Codec != codec || meta.Incompressible != incompressible {
+			t.Errorf("block %s in %q, incompressible %v; want %q, %v", key, meta.Codec, meta.Incompressible, codec, incompressible)
+		}
+	}
+
+	// Random bytes and q8_0 blocks skip zstd; text doesn't.
+	store := open(Config{Compress: true})
+	check(store, put(store, 0, "f16", random), random, "", true)
+	check(store, put(store, 1, "f16", text), text, zstdTransformName, false)
+	check(store, put(store, 2, "q8_0", text), text, "", true)
+	if c := store.Stats().Compression; c.Blocks != 3 || c.Incompressible != 2 {
+		t.Errorf("Compression = %+v, want 2 of 3 blocks incompressible", c)
+	}
+
+	// A block sent raw by the local codec is checked as it is
+	// recompressed for the remote tier, and stays raw.
+	store = open(Config{LocalCodec: CodecNone, RemoteCodec: CodecZstd, RecompressOnMigrate: true})
+	k0 := put(store, 0, "f16", random)
+	k1 := put(store, 1, "f16", text)
+	check(store, k0, random, "", false)
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	check(store, k0, random, "", true)
+	check(store, k1, text, CodecZstd, false)
+}
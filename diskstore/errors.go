@@ -0,0 +1,35 @@
+package diskstore
+
+import "errors"
+
+// Failure modes callers can branch on with errors.Is. Errors returned by
+// the store wrap at most one of them, along with the cause.
+var (
+	// ErrNotFound is returned for a block that isn't stored, or is past
+	// its TTL.
+	ErrNotFound = errors.New("diskstore: block not found")
+
+	// ErrCorrupted is returned for a stored block that can't be read
+	// back intact: its payload is missing, fails its checksum or doesn't
+	// decode, and no intact copy was found on the other tier.
+	ErrCorrupted = errors.New("diskstore: block corrupted")
+
+	// ErrTierUnavailable is returned when the tier holding a block can't
+	// be reached: a remote tier operation failed on every try, or the
+	// tier is marked unavailable (ErrRemoteUnavailable).
+	ErrTierUnavailable = errors.New("diskstore: tier unavailable")
+
+	// ErrBudgetExceeded is returned when an operation would take a tier
+	// over its budget.
+	ErrBudgetExceeded = errors.New("diskstore: tier budget exceeded")
+)
+
+// kindError is a sentinel error of its own that also matches a broader
+// one, as ErrRemoteUnavailable matches ErrTierUnavailable.
+type kindError struct {
+	msg  string
+	kind error
+}
+
+func (e *kindError) Error() string { return e.msg }
+func (e *kindError) Unwrap() error { return e.kind }
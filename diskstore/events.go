@@ -0,0 +1,68 @@
+package diskstore
+
+import "time"
+
+// Store events: WatchEvents streams what happens to blocks as it happens,
+// for systems that mirror, audit or visualize the cache. Like expiry
+// watchers, event watchers never slow the store down: a watcher that
+// doesn't keep up misses events, counted in Stats.DroppedEvents.
+
+// EventKind is what happened to a block.
+type EventKind string
+
+const (
+	EventPut     EventKind = "put"     // stored by Put
+	EventEvict   EventKind = "evict"   // moved to the remote tier
+	EventPromote EventKind = "promote" // moved to the local tier
+	EventRemove  EventKind = "remove"  // deleted, dropped over budget or past its TTL
+	EventGC      EventKind = "gc"      // dropped by GC, its payload missing
+)
+
+// eventBuffer is how many events a watcher's channel holds.
+const eventBuffer = 256
+
+// Event reports a change to one block. Tier is where the block is now,
+// or was for removals; Size is its stored size.
+type Event struct {
+	Kind EventKind `json:"kind"`
+	Key  BlockKey  `json:"key"`
+	Tier string    `json:"tier"`
+	Size int       `json:"size"`
+	At   time.Time `json:"at"`
+}
+
+// emit sends an event about meta to the watchers. Must be called with
+// s.mu held.
+func (s *Store) emit(kind EventKind, meta *BlockMeta) {
+	if len(s.eventWatchers) == 0 {
+		return
+	}
+	ev := Event{Kind: kind, Key: meta.Key, Tier: meta.Tier, Size: storedSize(meta), At: time.Now()}
+	for ch := range s.eventWatchers {
+		select {
+		case ch <- ev:
+		default:
+			s.droppedEvents++
+		}
+	}
+}
+
+// WatchEvents subscribes to block events. Events are dropped rather than
+// blocking the store if the channel is not drained. Call the returned
+// function to unsubscribe.
+func (s *Store) WatchEvents() (<-chan Event, func()) {
+	ch := make(chan Event, eventBuffer)
+
+	s.mu.Lock()
+	s.eventWatchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.eventWatchers[ch]; ok {
+			delete(s.eventWatchers, ch)
+			close(ch)
+		}
+	}
+}
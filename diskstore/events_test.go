@@ -0,0 +1,79 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWatchEvents(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	events, stop := store.WatchEvents()
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	expect := func(kind EventKind, k BlockKey, tier string) {
+		t.Helper()
+		select {
+		case ev := <-events:
+			if ev.Kind != kind || ev.Key != k || ev.Tier != tier || ev.Size != 100 {
+				t.Errorf("event %+v, want %s of %s on %s, 100 bytes", ev, kind, k, tier)
+			}
+		default:
+			t.Errorf("no event, want %s of %s", kind, k)
+		}
+	}
+
+	for i := range int32(2) {
+		if err := store.Put(key(i), "f16", []int{50}, make([]byte, 100)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		expect(EventPut, key(i), "local")
+	}
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	for range 2 {
+		ev := <-events
+		if ev.Kind != EventEvict || ev.Tier != "remote" {
+			t.Errorf("event %+v, want an eviction to remote", ev)
+		}
+	}
+	if err := store.Delete(key(0)); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	expect(EventRemove, key(0), "remote")
+	if _, err := store.Migrate(1, "local"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	expect(EventPromote, key(1), "local")
+	os.Remove(store.blockPath(key(1), "local", 0))
+	if _, err := store.GC(); err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	expect(EventGC, key(1), "local")
+
+	// A watcher that doesn't drain its channel misses events.
+	for i := range int32(eventBuffer + 3) {
+		store.Put(BlockKey{Seq: 2, BeginPos: i, EndPos: i + 1}, "f16", []int{50}, make([]byte, 100))
+	}
+	if got := store.Stats().DroppedEvents; got != 3 {
+		t.Errorf("DroppedEvents = %d, want 3", got)
+	}
+	stop()
+	if _, ok := <-events; !ok {
+		t.Error("channel closed before its buffered events were read")
+	}
+}
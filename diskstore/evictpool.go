@@ -0,0 +1,124 @@
+package diskstore
+
+import (
+	"sort"
+	"sync"
+)
+
+// Migration workers: evicting one block at a time leaves a Put that must
+// clear a lot of headroom, ahead of a big snapshot, waiting on as many
+// sequential remote writes. With Config.MigrationWorkers above one, the
+// loops that bring the local tier within budget instead pick every block
+// it takes to free the bytes needed, in eviction order and within the
+// remote budgets, and move their payloads to the remote tier, a
+// directory or a service such as an object store (see objecttier.go), on
+// that many goroutines at once. The store's lock stays held, as it does
+// for a single move, so no one sees a block half moved: once the batch's
+// transfers are done, each moved block's index entry and usage are
+// updated in one step; a block whose transfer failed stays local. A
+// replicated block (see replicate.go) has nothing to transfer: its local
+// file is just deleted when its turn comes.
+
+// evictLocal moves local blocks accepted by match (nil = any) to the
+// remote tier to free at least need bytes and returns how many moved.
+// Without migration workers it moves just the one going first.
+// Must be called with s.mu held.
+func (s *Store) evictLocal(match func(*BlockMeta) bool, need int64) int {
+	var victims []*BlockMeta
+	if s.migrationWorkers > 0 && need > 0 && s.hasRemote() && !s.Degraded() {
+		victims = s.evictionBatch(match, need)
+	}
+	if len(victims) <= 1 {
+		if s.evictOldestLocal(match) {
+			return 1
+		}
+		return 0
+	}
+
+	type result struct {
+		transfer
+		err error
+	}
+	results := make([]result, len(victims))
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+	for range min(s.migrationWorkers, len(victims)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				if victims[i].Replica {
+					continue
+				}
+				t, err := s.transferPayload(victims[i], "remote", 0)
+				results[i] = result{t, err}
+			}
+		}()
+	}
+	for i := range victims {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	moved := 0
+	for i, meta := range victims {
+		r := results[i]
+		replica := meta.Replica
+		if replica {
+			r.n, r.err = s.moveBlock(meta, "remote")
+		}
+		if r.err != nil {
+			s.log.Warn("evict to remote failed", "key", meta.Key, "error", r.err)
+			continue
+		}
+		if !replica {
+			s.finishMove(meta, "remote", 0, r.transfer)
+		}
+		s.evictions++
+		s.log.Debug("evicted block to remote", "key", meta.Key, "size", r.n)
+		moved++
+	}
+	return moved
+}
+
+// evictionBatch picks the unpinned local blocks accepted by match to go
+// first until they add up to need bytes, stopping short of the first one
+// the remote tier or its namespace's remote budget can't take along with
+// those before it. Must be called with s.mu held.
+func (s *Store) evictionBatch(match func(*BlockMeta) bool, need int64) []*BlockMeta {
+	var local []*BlockMeta
+	for _, meta := range s.index {
+		if meta.Tier == "local" && !meta.Pinned && !s.keepsLocal(meta) && (match == nil || match(meta)) {
+			local = append(local, meta)
+		}
+	}
+	sort.SliceStable(local, func(i, j int) bool { return s.evictsBefore(local[i], local[j]) })
+
+	var batch []*BlockMeta
+	var total, remote int64
+	nsTotal := make(map[string]int64)
+	for _, meta := range local {
+		if total >= need {
+			break
+		}
+		size := int64(storedSize(meta))
+		if meta.Replica {
+			batch = append(batch, meta) // already on the remote tier
+			total += size
+			continue
+		}
+		ns := meta.Key.Namespace
+		if s.remoteUsed+remote+size > s.remoteBudget || !s.nsFitsRemote(ns, nsTotal[ns]+size) {
+			break
+		}
+		if !s.fileMove(meta, "remote") && !s.recodes(meta, "remote") && (meta.Bundle != nil || !s.onService("remote")) {
+			break // only file copies, recompressions and service writes run side by side
+		}
+		batch = append(batch, meta)
+		total += size
+		remote += size
+		nsTotal[ns] += size
+	}
+	return batch
+}
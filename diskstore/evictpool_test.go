@@ -0,0 +1,64 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestMigrationWorkers(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:        filepath.Join(dir, "local"),
+		RemotePath:       filepath.Join(dir, "remote"),
+		LocalBudget:      1000,
+		RemoteBudget:     1024 * 1024,
+		MigrationWorkers: 4,
+		StatsInterval:    -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	store.renameMoves.Store(false)
+
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	data := func(i int32) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 100)
+	}
+	for i := range int32(10) {
+		if err := store.Put(key(i), "f16", []int{50}, data(i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Making room for a big block moves the eight going first at once.
+	if err := store.Put(key(10), "f16", []int{400}, make([]byte, 800)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	st := store.Stats()
+	if st.Evictions != 8 || st.LocalUsed != 1000 || st.RemoteUsed != 800 {
+		t.Errorf("Evictions = %d, LocalUsed = %d, RemoteUsed = %d; want 8, 1000, 800",
+			st.Evictions, st.LocalUsed, st.RemoteUsed)
+	}
+	for i := range int32(10) {
+		meta := store.index[key(i).String()]
+		if want := i < 8; (meta.Tier == "remote") != want {
+			t.Errorf("block %d on the %s tier", i, meta.Tier)
+		}
+		got, _, err := store.Get(key(i))
+		if err != nil || !bytes.Equal(got, data(i)) {
+			t.Errorf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+	}
+
+	// So does shrinking the local budget, counting each block moved.
+	if res := store.SetBudgets(100, 1024*1024); res.Moved != 2 || res.Dropped != 0 {
+		t.Errorf("SetBudgets = %+v, want 2 moved", res)
+	}
+	if st := store.Stats(); st.Evictions != 10 || st.LocalUsed != 100 {
+		t.Errorf("Evictions = %d, LocalUsed = %d; want 10, 100", st.Evictions, st.LocalUsed)
+	}
+}
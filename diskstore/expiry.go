@@ -0,0 +1,87 @@
+package diskstore
+
+import "time"
+
+// ExpiryEvent reports that a sequence's disk-cached KV is gone, so the next
+// request for it pays full prefill cost.
+type ExpiryEvent struct {
+	Seq int       `json:"seq"`
+	At  time.Time `json:"at"`
+}
+
+// markExpired records that seq has no blocks left and notifies watchers.
+// Must be called with s.mu held.
+func (s *Store) markExpired(seq int) {
+	if seq == PrefixSeq || seq == EncoderSeq {
+		return // shared blocks, no sequence to notify
+	}
+	ev := ExpiryEvent{Seq: seq, At: time.Now()}
+	s.expired[seq] = ev.At
+	for ch := range s.expiryWatchers {
+		select {
+		case ch <- ev:
+		default: // slow watcher; it can still poll Expired
+		}
+	}
+	if s.onExpire != nil {
+		go s.onExpire(ev)
+	}
+	s.log.Info("sequence cache expired", "seq", seq)
+}
+
+// hasSeq reports whether any block of seq is indexed.
+// Must be called with s.mu held.
+func (s *Store) hasSeq(seq int) bool {
+	for _, meta := range s.index {
+		if meta.Key.Seq == seq {
+			return true
+		}
+	}
+	return false
+}
+
+// Expired returns the sequences whose cached blocks were removed and that
+// have not been written to or acknowledged since.
+func (s *Store) Expired() []ExpiryEvent {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	events := make([]ExpiryEvent, 0, len(s.expired))
+	for seq, at := range s.expired {
+		events = append(events, ExpiryEvent{Seq: seq, At: at})
+	}
+	return events
+}
+
+// TakeExpired reports whether seq's cache expired and clears the flag, so
+// the signal is delivered to a client once.
+func (s *Store) TakeExpired(seq int) (ExpiryEvent, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	at, ok := s.expired[seq]
+	if ok {
+		delete(s.expired, seq)
+	}
+	return ExpiryEvent{Seq: seq, At: at}, ok
+}
+
+// WatchExpired subscribes to expiry events. Events are dropped rather than
+// blocking the store if the channel is not drained. Call the returned
+// function to unsubscribe.
+func (s *Store) WatchExpired() (<-chan ExpiryEvent, func()) {
+	ch := make(chan ExpiryEvent, 16)
+
+	s.mu.Lock()
+	s.expiryWatchers[ch] = struct{}{}
+	s.mu.Unlock()
+
+	return ch, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if _, ok := s.expiryWatchers[ch]; ok {
+			delete(s.expiryWatchers, ch)
+			close(ch)
+		}
+	}
+}
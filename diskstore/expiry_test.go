@@ -0,0 +1,58 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestExpiryNotification(t *testing.T) {
+	dir := t.TempDir()
+	notified := make(chan ExpiryEvent, 1)
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+		OnExpire:    func(ev ExpiryEvent) { notified <- ev },
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	events, stop := store.WatchExpired()
+	defer stop()
+
+	key := BlockKey{Seq: 7, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+	store.RemoveSeq(7)
+
+	select {
+	case ev := <-events:
+		if ev.Seq != 7 {
+			t.Errorf("watch: got seq %d, want 7", ev.Seq)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("watch: no expiry event")
+	}
+	select {
+	case <-notified:
+	case <-time.After(time.Second):
+		t.Fatal("OnExpire not called")
+	}
+
+	if _, ok := store.TakeExpired(7); !ok {
+		t.Error("TakeExpired: want true on first call")
+	}
+	if _, ok := store.TakeExpired(7); ok {
+		t.Error("TakeExpired: want false after the flag was taken")
+	}
+
+	// New data for the sequence clears a pending expiry.
+	store.RemoveSeq(7) // no blocks: no new expiry
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+	store.RemoveSeq(7)
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+	if len(store.Expired()) != 0 {
+		t.Errorf("Expired = %v, want none after a fresh Put", store.Expired())
+	}
+}
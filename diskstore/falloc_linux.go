@@ -0,0 +1,35 @@
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// fallocate modes.
+const (
+	fallocKeepSize  = 0x1
+	fallocPunchHole = 0x2
+)
+
+// preallocate reserves disk blocks for the first size bytes of f,
+// growing it to size, so running out of space fails here rather than
+// partway through the writes. Filesystems without fallocate are left to
+// allocate as they write.
+func preallocate(f *os.File, size int64) error {
+	err := syscall.Fallocate(int(f.Fd()), 0, 0, size)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return nil
+	}
+	return err
+}
+
+// punchHole releases the disk blocks under n bytes of f from off,
+// which read back as zeros, keeping the file's size.
+func punchHole(f *os.File, off, n int64) error {
+	err := syscall.Fallocate(int(f.Fd()), fallocPunchHole|fallocKeepSize, off, n)
+	if errors.Is(err, syscall.EOPNOTSUPP) || errors.Is(err, syscall.ENOSYS) {
+		return errors.ErrUnsupported
+	}
+	return err
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+)
+
+func preallocate(f *os.File, size int64) error { return nil }
+
+func punchHole(f *os.File, off, n int64) error { return errors.ErrUnsupported }
@@ -0,0 +1,133 @@
+package diskstore
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// ErrFingerprintMismatch is returned when blocks were written for a
+// different model than the one the store is opened or read for.
+var ErrFingerprintMismatch = errors.New("diskstore: model fingerprint mismatch")
+
+// modelsDir holds per-model stores when Config.NamespaceByModel is set.
+const modelsDir = "models"
+
+// Fingerprint identifies the model a store's KV blocks belong to. Blocks
+// from a model with a different layer count, head layout or cache dtype
+// would copy cleanly into tensors of the same byte size and silently
+// corrupt attention, so stores refuse them instead.
+type Fingerprint struct {
+	ModelDigest string `json:"model_digest"`
+	NLayers     int    `json:"n_layers"`
+	NKVHeads    int    `json:"n_kv_heads"`
+	HeadDim     int    `json:"head_dim"`
+	DType       string `json:"dtype"`
+}
+
+// ID returns a short stable hash of the fingerprint, used as the
+// directory name when stores are namespaced by model.
+func (f Fingerprint) ID() string {
+	data, _ := json.Marshal(f)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:6])
+}
+
+func (f Fingerprint) String() string {
+	return fmt.Sprintf("%s (layers=%d kv_heads=%d head_dim=%d dtype=%s)",
+		f.ModelDigest, f.NLayers, f.NKVHeads, f.HeadDim, f.DType)
+}
+
+// checkBlock reports whether a block's layout is consistent with f. Zero
+// fields in f are not checked. Shapes follow the cache tensor layout
+// [headDim, numKVHeads, positions].
+func (f *Fingerprint) checkBlock(key BlockKey, dtype string, shape []int) error {
+	if f == nil {
+		return nil
+	}
+	switch {
+	// Encoder outputs are in the model's compute type, not the cache
+	// type, and token manifests hold tokens.
+	case f.DType != "" && dtype != f.DType && key.Seq != EncoderSeq && key.Layer != ManifestLayer:
+		return fmt.Errorf("%w: block %s has dtype %s, model uses %s", ErrFingerprintMismatch, key, dtype, f.DType)
+	case f.NLayers > 0 && key.Layer >= f.NLayers:
+		return fmt.Errorf("%w: block %s is for layer %d, model has %d", ErrFingerprintMismatch, key, key.Layer, f.NLayers)
+	case f.HeadDim > 0 && len(shape) > 0 && shape[0] != f.HeadDim:
+		return fmt.Errorf("%w: block %s has head dim %d, model uses %d", ErrFingerprintMismatch, key, shape[0], f.HeadDim)
+	case f.NKVHeads > 0 && len(shape) > 1 && shape[1] != f.NKVHeads:
+		return fmt.Errorf("%w: block %s has %d kv heads, model uses %d", ErrFingerprintMismatch, key, shape[1], f.NKVHeads)
+	}
+	return nil
+}
+
+// Fingerprint returns the model fingerprint the store validates blocks
+// against, or nil if it has none.
+func (s *Store) Fingerprint() *Fingerprint {
+	if s.fingerprint == nil {
+		return nil
+	}
+	f := *s.fingerprint
+	return &f
+}
+
+func fingerprintPath(dir string) string {
+	return filepath.Join(dir, "fingerprint.json")
+}
+
+// loadTierFingerprints reconciles want with the records in a local and
+// an optional remote directory. The remote tier may be shared, so it
+// carries its own record.
+func loadTierFingerprints(local, remote string, want *Fingerprint) (*Fingerprint, error) {
+	if err := os.MkdirAll(local, 0755); err != nil {
+		return nil, fmt.Errorf("diskstore: create local dir: %w", err)
+	}
+	fp, err := loadFingerprint(local, want)
+	if err != nil || remote == "" {
+		return fp, err
+	}
+	if err := os.MkdirAll(remote, 0755); err != nil {
+		return nil, fmt.Errorf("diskstore: create remote dir: %w", err)
+	}
+	rfp, err := loadFingerprint(remote, fp)
+	if err != nil {
+		return nil, err
+	}
+	if fp == nil {
+		fp = rfp
+	}
+	return fp, nil
+}
+
+// loadFingerprint reconciles want with the fingerprint recorded in dir.
+// An empty directory adopts want; a store opened without a fingerprint
+// (e.g. by kvstorectl) adopts the recorded one. A conflict is an error.
+func loadFingerprint(dir string, want *Fingerprint) (*Fingerprint, error) {
+	path := fingerprintPath(dir)
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if want == nil {
+			return nil, nil
+		}
+		data, _ := json.MarshalIndent(want, "", "  ")
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return nil, fmt.Errorf("diskstore: write fingerprint: %w", err)
+		}
+		return want, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: read fingerprint: %w", err)
+	}
+
+	var have Fingerprint
+	if err := json.Unmarshal(data, &have); err != nil {
+		return nil, fmt.Errorf("diskstore: decode fingerprint %s: %w", path, err)
+	}
+	if want != nil && *want != have {
+		return nil, fmt.Errorf("%w: %s holds %s, opened for %s", ErrFingerprintMismatch, dir, have, *want)
+	}
+	return &have, nil
+}
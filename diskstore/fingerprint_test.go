@@ -0,0 +1,78 @@
+package diskstore
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestFingerprintValidation(t *testing.T) {
+	dir := t.TempDir()
+	qwen := &Fingerprint{ModelDigest: "sha256:aaaa", NLayers: 2, NKVHeads: 8, HeadDim: 128, DType: "f16"}
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		RemotePath:  filepath.Join(dir, "remote"),
+		LocalBudget: 1024 * 1024,
+		Fingerprint: qwen,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := BlockKey{Seq: 0, Layer: 1, BeginPos: 0, EndPos: 1, IsKey: true}
+	if err := store.Put(key, "f16", []int{128, 8, 1}, make([]byte, 64)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	bad := []struct {
+		name  string
+		key   BlockKey
+		dtype string
+		shape []int
+	}{
+		{"dtype", key, "q8_0", []int{128, 8, 1}},
+		{"layer", BlockKey{Seq: 0, Layer: 2, EndPos: 1, IsKey: true}, "f16", []int{128, 8, 1}},
+		{"head dim", key, "f16", []int{64, 8, 1}},
+		{"kv heads", key, "f16", []int{128, 4, 1}},
+	}
+	for _, tc := range bad {
+		if err := store.Put(tc.key, tc.dtype, tc.shape, make([]byte, 64)); !errors.Is(err, ErrFingerprintMismatch) {
+			t.Errorf("Put with wrong %s: got %v, want ErrFingerprintMismatch", tc.name, err)
+		}
+	}
+	store.Close()
+
+	// Opening for another model is refused.
+	llama := *qwen
+	llama.ModelDigest = "sha256:bbbb"
+	cfg.Fingerprint = &llama
+	if _, err := New(cfg); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Fatalf("New with other model: got %v, want ErrFingerprintMismatch", err)
+	}
+
+	// Opening without a fingerprint adopts the recorded one.
+	cfg.Fingerprint = nil
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New without fingerprint: %v", err)
+	}
+	if fp := store.Fingerprint(); fp == nil || *fp != *qwen {
+		t.Errorf("Fingerprint: got %v, want %v", fp, qwen)
+	}
+	if data, _, err := store.Get(key); err != nil || data == nil {
+		t.Errorf("Get after reopen: %v", err)
+	}
+	store.Close()
+
+	// Namespacing keeps both models' caches side by side.
+	cfg.Fingerprint = &llama
+	cfg.NamespaceByModel = true
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New namespaced: %v", err)
+	}
+	defer store.Close()
+	if _, _, err := store.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("namespaced store sees the other model's blocks (err %v)", err)
+	}
+}
@@ -0,0 +1,123 @@
+package diskstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Periodic flushing: a "json" index is otherwise written only by Close,
+// so a runner killed mid-generation loses every block it stored since it
+// opened the store. Every FlushInterval the store makes what it has
+// accepted durable (Sync): it waits for the write queue, syncs the
+// local tier's block files to disk, rewrites index.json if the index
+// changed, syncs a "journal" index and flushes the operation trace.
+// With Config.Context it also syncs as soon as the context is done, so
+// a runner that cancels a context on SIGTERM keeps its metadata even if
+// it never gets to Close.
+//
+// Block files are synced a filesystem at a time, with syncfs on Linux
+// and sync on other Unixes, before the index naming them is written;
+// index.json and the other files replaceFile writes are synced along
+// with their directory. Windows has no filesystem sync, so there block
+// files are left to the system to write back. Neither are the files of
+// the remote tier synced: a share or a RemoteTier service keeps what it
+// has been handed by its own rules.
+
+const defaultFlushInterval = time.Minute
+
+// Sync writes everything the store has accepted to disk: queued blocks,
+// the index and the operation trace. Close does the same; Sync leaves
+// the store open. It does nothing on a read-only store.
+func (s *Store) Sync() error {
+	if s.readOnly {
+		return nil
+	}
+	s.Flush()
+	err := s.syncBlocks()
+	if s.journal != nil {
+		err = errors.Join(err, s.syncIndex())
+	} else {
+		err = errors.Join(err, s.flushIndex())
+	}
+	return errors.Join(err, s.trace.flush())
+}
+
+// syncBlocks syncs the filesystems of the local tier's directories, so
+// the block files an index about to be written names are on disk.
+func (s *Store) syncBlocks() error {
+	var err error
+	for _, base := range s.localBases() {
+		err = errors.Join(err, syncFS(base))
+	}
+	if err != nil {
+		s.log.Error("sync block files", "error", err)
+		return fmt.Errorf("diskstore: sync block files: %w", err)
+	}
+	return nil
+}
+
+// flushIndex rewrites index.json if the index changed since it was last
+// written.
+func (s *Store) flushIndex() error {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+
+	s.mu.Lock()
+	gen := s.indexGen
+	if gen == s.savedGen {
+		s.mu.Unlock()
+		return nil
+	}
+	// Encoded under the lock, since entries change in place.
+	data, err := json.MarshalIndent(indexFile{Format: storeFormat, Blocks: s.index}, "", "  ")
+	s.mu.Unlock()
+	if err != nil {
+		s.log.Error("encode index", "error", err)
+		return fmt.Errorf("diskstore: encode index: %w", err)
+	}
+	if err := replaceFile(s.indexPath(), data); err != nil {
+		s.log.Error("write index", "path", s.indexPath(), "error", err)
+		return fmt.Errorf("diskstore: write index: %w", err)
+	}
+
+	s.mu.Lock()
+	s.savedGen = gen
+	s.mu.Unlock()
+	s.log.Debug("flushed index", "bytes", len(data))
+	return s.removeJournal()
+}
+
+// flushLoop syncs the store every interval (if positive) and once ctx
+// (if non-nil) is done, until the store is closed.
+func (s *Store) flushLoop(ctx context.Context, interval time.Duration) {
+	defer s.wg.Done()
+	var tick <-chan time.Time
+	if interval > 0 {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		tick = t.C
+	}
+	var ctxDone <-chan struct{}
+	if ctx != nil {
+		ctxDone = ctx.Done()
+	}
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-tick:
+			if err := s.Sync(); err != nil {
+				s.log.Warn("periodic flush", "error", err)
+			}
+		case <-ctxDone:
+			ctxDone = nil
+			s.log.Info("context done, syncing store", "cause", context.Cause(ctx))
+			if err := s.Sync(); err != nil {
+				s.log.Error("sync store", "error", err)
+			}
+		}
+	}
+}
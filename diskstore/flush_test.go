@@ -0,0 +1,89 @@
+package diskstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// indexedOnDisk reports whether a read-only store opened on dir sees key,
+// that is whether key made it into the index on disk.
+func indexedOnDisk(t *testing.T, dir string, key BlockKey) bool {
+	t.Helper()
+	ro, err := New(Config{LocalPath: dir, ReadOnly: true})
+	if err != nil {
+		t.Fatalf("New read-only: %v", err)
+	}
+	defer ro.Close()
+	return ro.Has(key)
+}
+
+func TestSync(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "local")
+	store, err := New(Config{
+		LocalPath:     dir,
+		LocalBudget:   1 << 20,
+		WriteQueue:    8,
+		FlushInterval: -1,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 1, EndPos: 4, IsKey: true}
+	if err := store.PutAsync(key, "f16", []int{4}, make([]byte, 64)); err != nil {
+		t.Fatalf("PutAsync: %v", err)
+	}
+	if indexedOnDisk(t, dir, key) {
+		t.Fatal("block indexed on disk before Sync")
+	}
+	if err := store.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	if !indexedOnDisk(t, dir, key) {
+		t.Error("block not indexed on disk after Sync")
+	}
+}
+
+func TestFlushLoop(t *testing.T) {
+	waitIndexed := func(dir string, key BlockKey) bool {
+		for deadline := time.Now().Add(2 * time.Second); time.Now().Before(deadline); time.Sleep(5 * time.Millisecond) {
+			if indexedOnDisk(t, dir, key) {
+				return true
+			}
+		}
+		return false
+	}
+	key := BlockKey{Seq: 1, EndPos: 4, IsKey: true}
+
+	t.Run("interval", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "local")
+		store, err := New(Config{LocalPath: dir, LocalBudget: 1 << 20, FlushInterval: 10 * time.Millisecond, StatsInterval: -1})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer store.Close()
+		store.Put(key, "f16", []int{4}, make([]byte, 64))
+		if !waitIndexed(dir, key) {
+			t.Error("block not indexed on disk by the periodic flush")
+		}
+	})
+
+	t.Run("context", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "local")
+		ctx, cancel := context.WithCancel(context.Background())
+		store, err := New(Config{LocalPath: dir, LocalBudget: 1 << 20, FlushInterval: -1, Context: ctx, StatsInterval: -1})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		defer store.Close()
+		store.Put(key, "f16", []int{4}, make([]byte, 64))
+		cancel()
+		if !waitIndexed(dir, key) {
+			t.Error("block not indexed on disk after the context was done")
+		}
+	})
+}
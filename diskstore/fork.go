@@ -0,0 +1,233 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Shared blocks: when Ollama forks a sequence (CopyPrefix), the cells it
+// copies hold the same KV data as the source. ForkSeq gives the new
+// sequence its own index entries for the source's blocks, but backs them
+// with hard links to the same payload files instead of copies. Entries
+// backed by one file form a share group (BlockMeta.Share); the group's
+// data counts once towards usage and is released when its last entry is
+// removed.
+//
+// Payload files are never modified in place (Put of an existing key
+// removes the old file and writes a new one), so a shared block is
+// copy-on-write: replacing or moving one entry of a group leaves the
+// others as they were.
+
+// ForkSeq gives dst every block of src, sharing the payloads where the
+// filesystem allows. It fails if dst already has blocks, as RenameSeq
+// does. It returns the number of blocks forked.
+func (s *Store) ForkSeq(src, dst int) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	if src == dst {
+		return 0, nil
+	}
+	s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.hasSeq(dst) {
+		return 0, fmt.Errorf("diskstore: fork seq %d: seq %d already has blocks", src, dst)
+	}
+	n, _, err := s.shareSeq(src, dst, math.MaxInt32)
+	s.log.Debug("forked sequence", "src", src, "dst", dst, "blocks", n)
+	return n, err
+}
+
+// CopySeq makes dst hold what src holds below endPos, as Ollama's
+// CopyPrefix does for the cells in memory: dst's own blocks are removed,
+// src's blocks ending at or before endPos are shared as by ForkSeq, and a
+// block straddling endPos is copied with only its rows below endPos. It
+// returns the number of blocks dst was given.
+func (s *Store) CopySeq(src, dst int, endPos int32) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	if src == dst {
+		return 0, nil
+	}
+	s.Flush()
+	s.mu.Lock()
+	s.dropSeq(dst)
+	n, cut, err := s.shareSeq(src, dst, endPos)
+	s.mu.Unlock()
+	if err != nil {
+		return n, err
+	}
+
+	for _, meta := range cut {
+		ok, err := s.putTruncated(meta, dst, endPos)
+		if err != nil {
+			return n, fmt.Errorf("diskstore: copy %s: %w", meta.Key, err)
+		}
+		if ok {
+			n++
+		}
+	}
+	s.log.Debug("copied sequence", "src", src, "dst", dst, "end", endPos, "blocks", n)
+	return n, nil
+}
+
+// TruncateSeq removes the blocks of seq that reach past endPos, whole,
+// so that seq holds at most its first endPos positions, and returns how
+// many it removed. Blocks still queued by PutAsync are written first.
+func (s *Store) TruncateSeq(seq int, endPos int32) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.trace.record(TraceOp{Op: TraceTruncate, Key: BlockKey{Seq: seq, EndPos: endPos}})
+	n := s.dropBlocks(func(meta *BlockMeta) bool {
+		return meta.Key.Seq == seq && meta.Key.EndPos > endPos
+	})
+	s.log.Debug("truncated sequence", "seq", seq, "end", endPos, "blocks", n)
+	return n, nil
+}
+
+// shareSeq gives dst the blocks of src that end at or before endPos and
+// returns how many it gave, and copies of those that straddle endPos.
+// Must be called with s.mu held.
+func (s *Store) shareSeq(src, dst int, endPos int32) (int, []BlockMeta, error) {
+	var whole []*BlockMeta
+	var cut []BlockMeta
+	for _, meta := range s.index {
+		switch {
+		case meta.Key.Seq != src || meta.Key.BeginPos >= endPos:
+		case meta.Key.EndPos <= endPos:
+			whole = append(whole, meta)
+		default:
+			cut = append(cut, *meta)
+		}
+	}
+
+	var n int
+	for _, meta := range whole {
+		newKey := meta.Key
+		newKey.Seq = dst
+		if err := s.shareBlock(meta, newKey); err != nil {
+			return n, nil, fmt.Errorf("diskstore: fork %s: %w", meta.Key, err)
+		}
+		n++
+	}
+	if n > 0 {
+		delete(s.expired, dst)
+	}
+	return n, cut, nil
+}
+
+// putTruncated stores the rows of meta's block below endPos as a block
+// of dst. It reports false if the block has gone since.
+func (s *Store) putTruncated(meta BlockMeta, dst int, endPos int32) (bool, error) {
+	data, _, err := s.Get(meta.Key)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	rows := int(meta.Key.EndPos - meta.Key.BeginPos)
+	if len(data)%rows != 0 {
+		return false, fmt.Errorf("%d bytes do not split into %d rows", len(data), rows)
+	}
+	key := meta.Key
+	key.Seq, key.EndPos = dst, endPos
+	keep := int(endPos-meta.Key.BeginPos) * (len(data) / rows)
+	return true, s.PutShifted(key, meta.DTypeStr, meta.Shape, data[:keep], meta.Shift)
+}
+
+// shareBlock indexes a copy of meta under key, hard-linking its payload
+// file into the same share group if it has one and copying it otherwise.
+// Must be called with s.mu held.
+func (s *Store) shareBlock(meta *BlockMeta, key BlockKey) error {
+	dup := *meta
+	dup.Key = key
+	dup.Pinned = s.pinned[key.Seq]
+	dup.Bundle = nil
+	dup.Hits = 0
+	dup.Recent = nil
+	dup.Share = ""
+	dup.Replica = false
+
+	if err := s.linkPayload(meta, key); err == nil {
+		if meta.Share == "" {
+			meta.Share = fmt.Sprintf("%s@%d", meta.Key, time.Now().UnixNano())
+			s.indexChanged(meta.Key)
+			s.shares[meta.Share] = 1
+		}
+		dup.Share = meta.Share
+		s.shares[dup.Share]++
+		s.index[key.String()] = &dup
+		s.indexChanged(key)
+		return nil
+	}
+
+	// Bundled, on a block service, or on a filesystem without hard
+	// links: store a copy of its own.
+	data, err := s.readPayload(context.Background(), meta, migrationIO)
+	if err != nil {
+		return err
+	}
+	if err := s.writePayload(&dup, dup.Tier, dup.Stripe, data); err != nil {
+		return err
+	}
+	s.index[key.String()] = &dup
+	s.indexChanged(key)
+	s.addUsage(&dup, dup.Tier, int64(storedSize(&dup)))
+	return nil
+}
+
+// linkPayload hard-links meta's payload file to where key's block lives
+// on the same tier. Must be called with s.mu held.
+func (s *Store) linkPayload(meta *BlockMeta, key BlockKey) error {
+	if meta.Bundle != nil || s.onService(meta.Tier) {
+		return fmt.Errorf("block is not a file of its own")
+	}
+	path := s.blockPath(key, meta.Tier, meta.Stripe)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Link(s.metaPath(meta), path)
+}
+
+// unshare takes meta out of its share group and reports whether the
+// payload's data is now released, because meta was its only or last
+// entry. Must be called with s.mu held.
+func (s *Store) unshare(meta *BlockMeta) bool {
+	if meta.Share == "" {
+		return true
+	}
+	s.shares[meta.Share]--
+	last := s.shares[meta.Share] <= 0
+	if last {
+		delete(s.shares, meta.Share)
+	}
+	meta.Share = ""
+	s.indexChanged(meta.Key)
+	return last
+}
+
+// releaseUsage subtracts n bytes of meta from its tier's usage unless
+// other entries of its share group still hold them, and takes meta out
+// of the group, along with the remote replica's usage if it has one.
+// Must be called with s.mu held.
+func (s *Store) releaseUsage(meta *BlockMeta, n int64) {
+	if meta.Replica {
+		s.addUsage(meta, "remote", -n)
+	}
+	if s.unshare(meta) {
+		s.addUsage(meta, meta.Tier, -n)
+	}
+}
@@ -0,0 +1,166 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestForkSeqSharesBlocks(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := func(seq int, begin int32) BlockKey {
+		return BlockKey{Seq: seq, Layer: 0, BeginPos: begin, EndPos: begin + 4, IsKey: true}
+	}
+	prefix := [][]byte{[]byte("prefix block 0.."), []byte("prefix block 1..")}
+	for i, data := range prefix {
+		if err := store.Put(key(0, int32(4*i)), "f16", []int{4}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	used := store.Stats().LocalUsed
+
+	n, err := store.ForkSeq(0, 1)
+	if err != nil || n != 2 {
+		t.Fatalf("ForkSeq: got %d, %v; want 2 blocks", n, err)
+	}
+	if got := store.Stats().LocalUsed; got != used {
+		t.Errorf("LocalUsed after fork: got %d, want %d (shared, not copied)", got, used)
+	}
+	if _, err := store.ForkSeq(0, 1); err == nil {
+		t.Error("ForkSeq into a sequence with blocks succeeded")
+	}
+
+	// Replacing a block of the fork leaves the source's copy alone.
+	forked := []byte("forked block 1..")
+	if err := store.Put(key(1, 4), "f16", []int{4}, forked); err != nil {
+		t.Fatalf("Put forked: %v", err)
+	}
+	if data, _, _ := store.Get(key(0, 4)); !bytes.Equal(data, prefix[1]) {
+		t.Errorf("source after replacing the fork's block: got %q, want %q", data, prefix[1])
+	}
+	if data, _, _ := store.Get(key(1, 4)); !bytes.Equal(data, forked) {
+		t.Errorf("fork after Put: got %q, want %q", data, forked)
+	}
+	if got, want := store.Stats().LocalUsed, used+int64(len(forked)); got != want {
+		t.Errorf("LocalUsed after replacing a shared block: got %d, want %d", got, want)
+	}
+
+	// Dropping the source keeps the shared block for the fork.
+	if n := store.RemoveSeq(0); n != 2 {
+		t.Errorf("RemoveSeq(0): removed %d blocks, want 2", n)
+	}
+	if data, _, err := store.Get(key(1, 0)); err != nil || !bytes.Equal(data, prefix[0]) {
+		t.Fatalf("fork after removing the source: got %q, %v", data, err)
+	}
+	if got, want := store.Stats().LocalUsed, int64(len(prefix[0])+len(forked)); got != want {
+		t.Errorf("LocalUsed after removing the source: got %d, want %d", got, want)
+	}
+
+	// Usage survives a restart, counting each group once.
+	if _, err := store.ForkSeq(1, 2); err != nil {
+		t.Fatalf("ForkSeq(1, 2): %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if got, want := store.Stats().LocalUsed, int64(len(prefix[0])+len(forked)); got != want {
+		t.Errorf("LocalUsed after reopen: got %d, want %d", got, want)
+	}
+	store.RemoveSeq(1)
+	store.RemoveSeq(2)
+	if got := store.Stats().LocalUsed; got != 0 {
+		t.Errorf("LocalUsed after removing every sequence: got %d, want 0", got)
+	}
+}
+
+func TestCopySeq(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Blocks of four positions, two bytes each.
+	key := func(seq int, begin, end int32) BlockKey {
+		return BlockKey{Seq: seq, Layer: 0, BeginPos: begin, EndPos: end, IsKey: true}
+	}
+	store.Put(key(0, 0, 4), "f16", []int{2}, []byte("aabbccdd"))
+	store.PutShifted(key(0, 4, 8), "f16", []int{2}, []byte("eeffgghh"), 2)
+	store.Put(key(0, 8, 12), "f16", []int{2}, []byte("iijjkkll"))
+	store.Put(key(1, 0, 4), "f16", []int{2}, []byte("old dst."))
+	store.Put(key(1, 20, 24), "f16", []int{2}, []byte("old dst."))
+
+	n, err := store.CopySeq(0, 1, 6)
+	if err != nil || n != 2 {
+		t.Fatalf("CopySeq: got %d, %v; want 2 blocks", n, err)
+	}
+	if data, _, _ := store.Get(key(1, 0, 4)); !bytes.Equal(data, []byte("aabbccdd")) {
+		t.Errorf("shared block: got %q", data)
+	}
+	data, meta, _ := store.Get(key(1, 4, 6))
+	if !bytes.Equal(data, []byte("eeff")) || meta == nil || meta.Shift != 2 {
+		t.Errorf("truncated block: got %q shift %v", data, meta)
+	}
+	for _, k := range []BlockKey{key(1, 8, 12), key(1, 20, 24), key(1, 4, 8)} {
+		if store.Has(k) {
+			t.Errorf("dst still has %s", k)
+		}
+	}
+	if data, _, _ := store.Get(key(0, 4, 8)); !bytes.Equal(data, []byte("eeffgghh")) {
+		t.Errorf("source block after copy: got %q", data)
+	}
+	if got, want := store.Stats().LocalUsed, int64(24+4); got != want {
+		t.Errorf("LocalUsed: got %d, want %d", got, want)
+	}
+}
+
+func TestTruncateSeq(t *testing.T) {
+	store, err := New(Config{
+		LocalPath:     t.TempDir(),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+		WriteQueue:    8,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(seq int, begin, end int32) BlockKey {
+		return BlockKey{Seq: seq, Layer: 0, BeginPos: begin, EndPos: end, IsKey: true}
+	}
+	store.Put(key(0, 0, 4), "f16", []int{2}, []byte("aabbccdd"))
+	store.Put(key(0, 4, 8), "f16", []int{2}, []byte("eeffgghh"))
+	store.PutAsync(key(0, 8, 12), "f16", []int{2}, []byte("iijjkkll"))
+	store.Put(key(1, 8, 12), "f16", []int{2}, []byte("other..."))
+
+	// The block straddling 6 goes whole, as does the queued one.
+	if n, err := store.TruncateSeq(0, 6); err != nil || n != 2 {
+		t.Fatalf("TruncateSeq: got %d, %v; want 2 blocks", n, err)
+	}
+	for k, want := range map[BlockKey]bool{key(0, 0, 4): true, key(0, 4, 8): false, key(0, 8, 12): false, key(1, 8, 12): true} {
+		if store.Has(k) != want {
+			t.Errorf("Has(%s) = %v, want %v", k, !want, want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Store format: index.json records the on-disk format of the store it
+// indexes, so a later version that lays blocks or the index out
+// differently recognizes an older store instead of misreading it. New
+// refuses a store in any other format than storeFormat with
+// ErrStoreFormat. With Config.UpgradeFormat (kvstorectl migrate -format)
+// it first upgrades an older one in place, running formatMigrations in
+// order under the store's lock, each leaving a complete store of the
+// next format behind it: a migration cut short by a crash is picked up
+// again from the format it recorded.
+//
+// A store without an index.json yet (new, or journaled and never
+// compacted) is taken to be in the current format.
+
+// storeFormat is the format New writes. Bump it with a migration
+// appended to formatMigrations when the layout changes incompatibly.
+const storeFormat = 2
+
+// ErrStoreFormat is returned by New for a store in a format this
+// version doesn't read: an older one without Config.UpgradeFormat, or a
+// newer one.
+var ErrStoreFormat = errors.New("diskstore: unsupported store format")
+
+// indexFile is the layout of index.json.
+type indexFile struct {
+	Format int                   `json:"format"`
+	Blocks map[string]*BlockMeta `json:"blocks"`
+}
+
+// formatMigration upgrades a store from format from to from+1.
+type formatMigration struct {
+	from int
+	desc string
+	run  func(s *Store) error
+}
+
+// formatMigrations are the upgrades from each older format, in order.
+var formatMigrations = []formatMigration{
+	{1, "record the format in index.json", wrapIndex},
+}
+
+// indexFormat returns the format of a store whose index.json holds data.
+// Format 1 stores, from before formats were recorded, hold the bare map
+// of blocks.
+func indexFormat(data []byte) (int, error) {
+	var head struct {
+		Format int `json:"format"`
+	}
+	if err := json.Unmarshal(data, &head); err != nil {
+		return 0, err
+	}
+	return max(head.Format, 1), nil
+}
+
+// checkFormat fails with ErrStoreFormat unless the store is in the
+// current format, upgrading an older one first if upgrade is set. An
+// index.json that can't be read is left to loadIndex to report.
+func (s *Store) checkFormat(upgrade bool) error {
+	s.formatFrom = storeFormat
+	data, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return nil
+	}
+	format, err := indexFormat(data)
+	if err != nil {
+		return nil
+	}
+	s.formatFrom = format
+	switch {
+	case format > storeFormat:
+		return fmt.Errorf("%w: store format %d is newer than this version's %d", ErrStoreFormat, format, storeFormat)
+	case format == storeFormat:
+		return nil
+	case !upgrade || s.readOnly:
+		return fmt.Errorf("%w: store format %d is older than this version's %d; upgrade it with kvstorectl migrate -format", ErrStoreFormat, format, storeFormat)
+	}
+	for _, m := range formatMigrations {
+		if m.from < format {
+			continue
+		}
+		s.log.Info("upgrading store format", "from", m.from, "to", m.from+1, "step", m.desc)
+		if err := m.run(s); err != nil {
+			s.log.Error("upgrade store format", "from", m.from, "error", err)
+			return fmt.Errorf("diskstore: upgrade store format %d to %d: %w", m.from, m.from+1, err)
+		}
+	}
+	return nil
+}
+
+// FormatUpgrade returns the format the store was in when it was opened
+// and the one it is in now, which differ if New upgraded it.
+func (s *Store) FormatUpgrade() (from, to int) {
+	return s.formatFrom, storeFormat
+}
+
+// wrapIndex upgrades format 1 to 2: the bare map of blocks in index.json
+// moves into an indexFile recording the format. Entries are carried
+// over as they are.
+func wrapIndex(s *Store) error {
+	data, err := os.ReadFile(s.indexPath())
+	if err != nil {
+		return err
+	}
+	var blocks map[string]json.RawMessage
+	if err := json.Unmarshal(data, &blocks); err != nil {
+		return err
+	}
+	out, err := json.MarshalIndent(struct {
+		Format int                        `json:"format"`
+		Blocks map[string]json.RawMessage `json:"blocks"`
+	}{2, blocks}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return replaceFile(s.indexPath(), out)
+}
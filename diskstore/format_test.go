@@ -0,0 +1,79 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStoreFormat(t *testing.T) {
+	cfg := Config{
+		LocalPath:     filepath.Join(t.TempDir(), "local"),
+		LocalBudget:   1 << 20,
+		StatsInterval: -1,
+	}
+	key := BlockKey{Seq: 1, EndPos: 4, IsKey: true}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if err := store.Put(key, "f16", []int{4}, make([]byte, 32)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	store.Close()
+
+	// Rewrite the index as format 1 wrote it: the bare map of blocks.
+	path := filepath.Join(cfg.LocalPath, "index.json")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f struct {
+		Format int                        `json:"format"`
+		Blocks map[string]json.RawMessage `json:"blocks"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil || f.Format != storeFormat || len(f.Blocks) != 1 {
+		t.Fatalf("index.json = %s, %v; want format %d with one block", data, err, storeFormat)
+	}
+	legacy, _ := json.Marshal(f.Blocks)
+	os.WriteFile(path, legacy, 0644)
+
+	ro := cfg
+	ro.ReadOnly, ro.UpgradeFormat = true, true
+	for _, c := range []Config{cfg, ro} {
+		if _, err := New(c); !errors.Is(err, ErrStoreFormat) {
+			t.Fatalf("New (read-only %v) of a format 1 store: %v, want ErrStoreFormat", c.ReadOnly, err)
+		}
+	}
+
+	up := cfg
+	up.UpgradeFormat = true
+	store, err = New(up)
+	if err != nil {
+		t.Fatalf("New with UpgradeFormat: %v", err)
+	}
+	if from, to := store.FormatUpgrade(); from != 1 || to != storeFormat {
+		t.Errorf("FormatUpgrade = %d, %d; want 1, %d", from, to, storeFormat)
+	}
+	if _, _, err := store.Get(key); err != nil {
+		t.Errorf("Get after upgrade: %v", err)
+	}
+	store.Close()
+
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New of the upgraded store: %v", err)
+	}
+	if from, _ := store.FormatUpgrade(); from != storeFormat {
+		t.Errorf("upgraded store opened in format %d", from)
+	}
+	store.Close()
+
+	// A later version's store is refused, upgrade or not.
+	os.WriteFile(path, []byte(`{"format": 99, "blocks": {}}`), 0644)
+	if _, err := New(up); !errors.Is(err, ErrStoreFormat) {
+		t.Errorf("New of a format 99 store: %v, want ErrStoreFormat", err)
+	}
+}
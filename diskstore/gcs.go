@@ -0,0 +1,345 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Google Cloud Storage is reached through its XML API, which keeps custom
+// metadata in x-goog-meta- headers, authenticated with OAuth2 access
+// tokens (see gcsTokens).
+const (
+	gcsEndpoint   = "https://storage.googleapis.com"
+	gcsMetaPrefix = "X-Goog-Meta-"
+	gcsScope      = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// GCSConfig configures a GCSTier.
+type GCSConfig struct {
+	Bucket string
+	Prefix string // prepended to object names, e.g. "kv/node1"
+
+	// Token, if set, is an OAuth2 access token sent as is. Otherwise
+	// tokens are obtained with the service account key in the JSON file
+	// CredentialsFile or, without one, from the VM's metadata server.
+	Token           string
+	CredentialsFile string
+
+	// Endpoint replaces https://storage.googleapis.com, e.g. for an
+	// emulator.
+	Endpoint string
+
+	Timeout time.Duration // per request (default 30s)
+}
+
+// GCSTier keeps blocks in a Google Cloud Storage bucket. It implements
+// Tier, so it can serve as Config.RemoteTier, and Pinger.
+type GCSTier struct {
+	base   string // endpoint and bucket
+	prefix string
+	client *http.Client
+	tokens *gcsTokens
+}
+
+// NewGCSTier returns a tier storing blocks in cfg.Bucket.
+func NewGCSTier(cfg GCSConfig) (*GCSTier, error) {
+	if cfg.Bucket == "" {
+		return nil, fmt.Errorf("diskstore: gcs tier: no bucket")
+	}
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = gcsEndpoint
+	}
+	t := &GCSTier{
+		base:   strings.TrimSuffix(endpoint, "/") + "/" + url.PathEscape(cfg.Bucket),
+		prefix: strings.Trim(cfg.Prefix, "/"),
+		client: newObjectClient(cfg.Timeout),
+		tokens: &gcsTokens{token: cfg.Token},
+	}
+	switch {
+	case cfg.Token != "":
+		// sent as is, with no fetch
+	case cfg.CredentialsFile != "":
+		key, err := loadServiceAccount(cfg.CredentialsFile)
+		if err != nil {
+			return nil, err
+		}
+		t.tokens.fetch = func(ctx context.Context) (*tokenResponse, error) {
+			return key.token(ctx, t.client)
+		}
+	default:
+		t.tokens.fetch = func(ctx context.Context) (*tokenResponse, error) {
+			return metadataToken(ctx, t.client)
+		}
+	}
+	return t, nil
+}
+
+// Put stores a block as an object.
+func (t *GCSTier) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	req, err := t.request(context.Background(), http.MethodPut, key, data)
+	if err != nil {
+		return fmt.Errorf("diskstore: gcs put %s: %w", key, err)
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set(gcsMetaPrefix+objectMetaDType, dtype)
+	req.Header.Set(gcsMetaPrefix+objectMetaShape, formatShape(shape))
+	resp, err := doHTTP(t.client, req)
+	if err != nil {
+		return fmt.Errorf("diskstore: gcs put %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get fetches a block. A missing block returns an error wrapping
+// ErrNotFound, like Store.Get.
+func (t *GCSTier) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, giving up when ctx is done.
+func (t *GCSTier) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	req, err := t.request(ctx, http.MethodGet, key, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: gcs get %s: %w", key, err)
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("diskstore: gcs get %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: gcs get %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: gcs get %s: %w", key, err)
+	}
+	meta, err := objectBlock(key, resp.Header, gcsMetaPrefix, data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: gcs get %s: %w", key, err)
+	}
+	return data, meta, nil
+}
+
+// Has reports whether the bucket holds key. Transport errors report
+// false.
+func (t *GCSTier) Has(key BlockKey) bool {
+	req, err := t.request(context.Background(), http.MethodHead, key, nil)
+	if err != nil {
+		return false
+	}
+	resp, err := doHTTP(t.client, req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// Delete removes a block's object. Deleting a missing block is not an
+// error.
+func (t *GCSTier) Delete(key BlockKey) error {
+	req, err := t.request(context.Background(), http.MethodDelete, key, nil)
+	if err != nil {
+		return fmt.Errorf("diskstore: gcs delete %s: %w", key, err)
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: gcs delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ping checks that the bucket is reachable with the tier's credentials.
+func (t *GCSTier) Ping(ctx context.Context) error {
+	req, err := t.newRequest(ctx, http.MethodHead, t.base+"/"+escapeObjectPath(path.Join(t.prefix, objectProbe)), nil)
+	if err != nil {
+		return err
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request returns an authorized request for key's object.
+func (t *GCSTier) request(ctx context.Context, method string, key BlockKey, body []byte) (*http.Request, error) {
+	return t.newRequest(ctx, method, t.base+"/"+escapeObjectPath(objectName(t.prefix, key)), body)
+}
+
+func (t *GCSTier) newRequest(ctx context.Context, method, u string, body []byte) (*http.Request, error) {
+	token, err := t.tokens.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req, nil
+}
+
+// gcsTokens hands out OAuth2 access tokens, fetching a new one when the
+// last is about to expire.
+type gcsTokens struct {
+	fetch func(ctx context.Context) (*tokenResponse, error)
+
+	mu     sync.Mutex
+	token  string
+	expiry time.Time
+}
+
+// tokenResponse is an OAuth2 token endpoint's answer.
+type tokenResponse struct {
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"` // seconds
+}
+
+func (g *gcsTokens) get(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.fetch == nil || g.token != "" && time.Until(g.expiry) > time.Minute {
+		return g.token, nil
+	}
+	tok, err := g.fetch(ctx)
+	if err != nil {
+		return "", fmt.Errorf("gcs access token: %w", err)
+	}
+	if tok.AccessToken == "" {
+		return "", errors.New("gcs access token: empty token")
+	}
+	g.token = tok.AccessToken
+	g.expiry = time.Now().Add(time.Duration(tok.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+// metadataToken gets the access token of the VM's service account from
+// the GCE metadata server (GCE_METADATA_HOST, if set, replaces its
+// address).
+func metadataToken(ctx context.Context, client *http.Client) (*tokenResponse, error) {
+	host := os.Getenv("GCE_METADATA_HOST")
+	if host == "" {
+		host = "metadata.google.internal"
+	}
+	u := "http://" + host + "/computeMetadata/v1/instance/service-accounts/default/token?scopes=" + url.QueryEscape(gcsScope)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	return requestToken(client, req)
+}
+
+// serviceAccount is the part of a service account key file a token
+// request needs.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+func loadServiceAccount(path string) (*serviceAccount, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: gcs credentials: %w", err)
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(data, &sa); err != nil {
+		return nil, fmt.Errorf("diskstore: gcs credentials %s: %w", path, err)
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil || sa.ClientEmail == "" || sa.TokenURI == "" {
+		return nil, fmt.Errorf("diskstore: gcs credentials %s: not a service account key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("diskstore: gcs credentials %s: private key is not RSA", path)
+	}
+	sa.key = key
+	return &sa, nil
+}
+
+// token exchanges a signed JWT asserting the service account for an
+// access token.
+func (sa *serviceAccount) token(ctx context.Context, client *http.Client) (*tokenResponse, error) {
+	now := time.Now()
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]any{
+		"iss":   sa.ClientEmail,
+		"scope": gcsScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	enc := base64.RawURLEncoding
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return requestToken(client, req)
+}
+
+func requestToken(client *http.Client, req *http.Request) (*tokenResponse, error) {
+	resp, err := doHTTP(client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var tok tokenResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return nil, fmt.Errorf("decode token: %w", err)
+	}
+	return &tok, nil
+}
@@ -0,0 +1,144 @@
+package diskstore
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestGCSTier(t *testing.T) {
+	_, srv := newFakeObjects(t, gcsMetaPrefix, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer secret" && strings.HasPrefix(r.URL.Path, "/bucket/kv/")
+	})
+	tier, err := NewGCSTier(GCSConfig{Bucket: "bucket", Prefix: "kv", Token: "secret", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewGCSTier: %v", err)
+	}
+	testServiceTier(t, tier)
+
+	if _, err := NewGCSTier(GCSConfig{}); err == nil {
+		t.Error("NewGCSTier without a bucket succeeded")
+	}
+}
+
+func TestGCSServiceAccount(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The token endpoint checks the assertion's signature and claims.
+	var issued atomic.Int32
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parts := strings.Split(r.FormValue("assertion"), ".")
+		if len(parts) != 3 || r.FormValue("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+		sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+		if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, sum[:], sig); err != nil {
+			http.Error(w, "bad signature", http.StatusUnauthorized)
+			return
+		}
+		claims, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		var c struct{ Iss, Scope string }
+		json.Unmarshal(claims, &c)
+		if c.Iss != "kv@project.iam.gserviceaccount.com" || c.Scope != gcsScope {
+			http.Error(w, "bad claims", http.StatusUnauthorized)
+			return
+		}
+		issued.Add(1)
+		json.NewEncoder(w).Encode(tokenResponse{AccessToken: "issued", ExpiresIn: 3600})
+	}))
+	defer tokens.Close()
+
+	creds := filepath.Join(t.TempDir(), "key.json")
+	data, _ := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "kv@project.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokens.URL,
+	})
+	if err := os.WriteFile(creds, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, srv := newFakeObjects(t, gcsMetaPrefix, func(r *http.Request) bool {
+		return r.Header.Get("Authorization") == "Bearer issued"
+	})
+	tier, err := NewGCSTier(GCSConfig{Bucket: "bucket", CredentialsFile: creds, Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewGCSTier: %v", err)
+	}
+	testServiceTier(t, tier)
+	if n := issued.Load(); n != 1 {
+		t.Errorf("%d tokens issued, want 1 reused", n)
+	}
+}
+
+func TestStoreWithGCSTier(t *testing.T) {
+	objects, srv := newFakeObjects(t, gcsMetaPrefix, func(r *http.Request) bool { return true })
+	objects.failPuts = 1
+	tier, err := NewGCSTier(GCSConfig{Bucket: "bucket", Token: "t", Endpoint: srv.URL})
+	if err != nil {
+		t.Fatalf("NewGCSTier: %v", err)
+	}
+	store, err := New(Config{
+		LocalPath:        t.TempDir(),
+		LocalBudget:      1000,
+		RemoteBudget:     1024 * 1024,
+		RemoteTier:       tier,
+		RemoteBackoff:    time.Millisecond,
+		MigrationWorkers: 4,
+		StatsInterval:    -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	data := func(i int32) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 100)
+	}
+	for i := range int32(10) {
+		if err := store.Put(key(i), "f16", []int{50}, data(i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// Making room for a big block evicts a batch to the bucket, one write
+	// of which is retried.
+	if err := store.Put(key(10), "f16", []int{300}, bytes.Repeat([]byte{10}, 600)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	st := store.Stats()
+	if st.RemoteBlocks != 6 || objects.count() != 6 || st.RemoteRetries != 1 {
+		t.Errorf("%d remote blocks, %d objects, %d retries; want 6, 6, 1", st.RemoteBlocks, objects.count(), st.RemoteRetries)
+	}
+	for i := range int32(10) {
+		if got, _, err := store.Get(key(i)); err != nil || !bytes.Equal(got, data(i)) {
+			t.Errorf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+	}
+}
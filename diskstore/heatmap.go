@@ -0,0 +1,121 @@
+package diskstore
+
+import (
+	"encoding/csv"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Access heatmaps: Heatmap dumps how often each block of a sequence was
+// read and where it sits, so a plot of layer against position shows
+// which parts of a long context are restored and which sit cold. Hits
+// and read times are those the index keeps (see access.go), so they
+// count since each block was stored, across restarts.
+
+// HeatCell is one block of a heatmap.
+type HeatCell struct {
+	Layer      int       `json:"layer"`
+	IsKey      bool      `json:"is_key"`
+	BeginPos   int32     `json:"begin_pos"`
+	EndPos     int32     `json:"end_pos"`
+	Tier       string    `json:"tier"`
+	Hits       uint32    `json:"hits"`
+	StoredAt   time.Time `json:"stored_at"`
+	AccessedAt time.Time `json:"accessed_at"`
+}
+
+// HeatSpan is a run of positions every block of which covers either all
+// or none, with the reads and tiers of the blocks covering it summed
+// over layers and halves.
+type HeatSpan struct {
+	BeginPos     int32  `json:"begin_pos"`
+	EndPos       int32  `json:"end_pos"`
+	Hits         uint64 `json:"hits"`
+	LocalBlocks  int    `json:"local_blocks"`
+	RemoteBlocks int    `json:"remote_blocks"`
+}
+
+// Heatmap is the access record of a sequence's blocks: Cells per block
+// by layer, half and position, Positions per position run across
+// layers.
+type Heatmap struct {
+	Seq       int        `json:"seq"`
+	Cells     []HeatCell `json:"cells"`
+	Positions []HeatSpan `json:"positions"`
+}
+
+// Heatmap returns the access record of seq's blocks in the default
+// namespace.
+func (s *Store) Heatmap(seq int) Heatmap {
+	h := Heatmap{Seq: seq, Cells: []HeatCell{}, Positions: []HeatSpan{}}
+	var bounds []int32
+	for meta := range s.Iter(BlockFilter{Seqs: []int{seq}, Namespaces: []string{""}}) {
+		k := meta.Key
+		if k.Layer < 0 {
+			continue // token manifests
+		}
+		h.Cells = append(h.Cells, HeatCell{
+			Layer:      k.Layer,
+			IsKey:      k.IsKey,
+			BeginPos:   k.BeginPos,
+			EndPos:     k.EndPos,
+			Tier:       meta.Tier,
+			Hits:       meta.Hits,
+			StoredAt:   meta.StoredAt,
+			AccessedAt: meta.AccessedAt,
+		})
+		bounds = append(bounds, k.BeginPos, k.EndPos)
+	}
+	slices.Sort(bounds)
+	bounds = slices.Compact(bounds)
+
+	for i := 1; i < len(bounds); i++ {
+		span := HeatSpan{BeginPos: bounds[i-1], EndPos: bounds[i]}
+		for _, c := range h.Cells {
+			if c.BeginPos >= span.EndPos || c.EndPos <= span.BeginPos {
+				continue
+			}
+			span.Hits += uint64(c.Hits)
+			if c.Tier == "local" {
+				span.LocalBlocks++
+			} else {
+				span.RemoteBlocks++
+			}
+		}
+		if span.LocalBlocks+span.RemoteBlocks > 0 {
+			h.Positions = append(h.Positions, span)
+		}
+	}
+	return h
+}
+
+// WriteCSV writes h's cells as CSV with a header row, one row per block.
+func (h Heatmap) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"seq", "layer", "half", "begin_pos", "end_pos", "tier", "hits", "stored_at", "accessed_at"})
+	for _, c := range h.Cells {
+		half := "v"
+		if c.IsKey {
+			half = "k"
+		}
+		accessed := ""
+		if !c.AccessedAt.IsZero() {
+			accessed = c.AccessedAt.UTC().Format(time.RFC3339)
+		}
+		cw.Write([]string{
+			strconv.Itoa(h.Seq),
+			strconv.Itoa(c.Layer),
+			half,
+			strconv.Itoa(int(c.BeginPos)),
+			strconv.Itoa(int(c.EndPos)),
+			c.Tier,
+			strconv.FormatUint(uint64(c.Hits), 10),
+			c.StoredAt.UTC().Format(time.RFC3339),
+			accessed,
+		})
+	}
+	cw.Flush()
+	return cw.Error()
+}
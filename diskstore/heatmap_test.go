@@ -0,0 +1,70 @@
+package diskstore
+
+import (
+	"bytes"
+	"encoding/csv"
+	"path/filepath"
+	"testing"
+)
+
+func TestHeatmap(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Layer 0 in two blocks of 4, layer 1 in one of 8; the first block of
+	// layer 0 is read twice, layer 1 once, then moved to the remote tier.
+	put := func(layer int, begin, end int32) BlockKey {
+		key := BlockKey{Seq: 3, Layer: layer, BeginPos: begin, EndPos: end, IsKey: true}
+		if err := store.Put(key, "f16", []int{4}, make([]byte, 8*(end-begin))); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return key
+	}
+	first := put(0, 0, 4)
+	put(0, 4, 8)
+	wide := put(1, 0, 8)
+	store.Put(ManifestKey(BlockKey{Seq: 3, EndPos: 8}), ManifestDType, nil, EncodeTokens(make([]int32, 8)))
+	store.Get(first)
+	store.Get(first)
+	store.Get(wide)
+	store.mu.Lock()
+	store.moveBlock(store.index[wide.String()], "remote")
+	store.mu.Unlock()
+
+	h := store.Heatmap(3)
+	if len(h.Cells) != 3 {
+		t.Fatalf("%d cells, want 3 without the token manifest", len(h.Cells))
+	}
+	if c := h.Cells[0]; c.Layer != 0 || c.BeginPos != 0 || c.Hits != 2 || c.Tier != "local" || c.AccessedAt.IsZero() {
+		t.Errorf("first cell %+v", c)
+	}
+	want := []HeatSpan{
+		{BeginPos: 0, EndPos: 4, Hits: 3, LocalBlocks: 1, RemoteBlocks: 1},
+		{BeginPos: 4, EndPos: 8, Hits: 1, LocalBlocks: 1, RemoteBlocks: 1},
+	}
+	if len(h.Positions) != len(want) || h.Positions[0] != want[0] || h.Positions[1] != want[1] {
+		t.Errorf("Positions = %+v, want %+v", h.Positions, want)
+	}
+
+	var buf bytes.Buffer
+	if err := h.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil || len(rows) != 4 || rows[0][0] != "seq" || rows[3][5] != "remote" || rows[1][6] != "2" {
+		t.Errorf("CSV = %v, %v", rows, err)
+	}
+	if h := store.Heatmap(9); len(h.Cells) != 0 || h.Positions == nil {
+		t.Errorf("heatmap of an empty sequence = %+v", h)
+	}
+}
@@ -0,0 +1,222 @@
+package diskstore
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Stats history: every Config.StatsInterval the store appends one
+// StatsSample line to stats-history.jsonl in the local tier, holding the
+// traffic of the interval and the usage at its end per namespace. Unlike
+// Stats, which resets when the store is reopened, the history survives
+// restarts and backs longer-term reports (kvstorectl report).
+const (
+	statsHistoryFile     = "stats-history.jsonl"
+	defaultStatsInterval = 10 * time.Minute
+
+	// statsHistoryKeep is how far back samples are kept; older ones are
+	// dropped when the store is opened.
+	statsHistoryKeep = 90 * 24 * time.Hour
+)
+
+// StatsSample is one interval of the stats history.
+type StatsSample struct {
+	Start      time.Time                 `json:"start"`
+	End        time.Time                 `json:"end"`
+	Namespaces map[string]SampleCounters `json:"namespaces"`
+}
+
+// SampleCounters are a namespace's counters for one sample. Traffic
+// counts cover the sample's interval; usage is taken at its end.
+type SampleCounters struct {
+	Puts   int64 `json:"puts,omitempty"`
+	Hits   int64 `json:"hits,omitempty"`
+	Misses int64 `json:"misses,omitempty"`
+
+	// RestoredPositions counts token positions loaded back into a KV
+	// cache instead of being recomputed, as reported by RecordRestored.
+	RestoredPositions int64 `json:"restored_positions,omitempty"`
+
+	LocalUsed  int64 `json:"local_used"`
+	RemoteUsed int64 `json:"remote_used"`
+}
+
+// HistorySummary totals a namespace's samples.
+type HistorySummary struct {
+	Puts              int64 `json:"puts"`
+	Hits              int64 `json:"hits"`
+	Misses            int64 `json:"misses"`
+	RestoredPositions int64 `json:"restored_positions"`
+
+	// Usage integrated over the sampled time, in byte-hours.
+	LocalByteHours  float64 `json:"local_byte_hours"`
+	RemoteByteHours float64 `json:"remote_byte_hours"`
+}
+
+// HitRatio returns Hits / (Hits + Misses), or 0 without reads.
+func (h HistorySummary) HitRatio() float64 {
+	if h.Hits+h.Misses == 0 {
+		return 0
+	}
+	return float64(h.Hits) / float64(h.Hits+h.Misses)
+}
+
+// SummarizeHistory totals samples per namespace. Usage is counted for the
+// length of each sample, so time the store was closed costs nothing.
+func SummarizeHistory(samples []StatsSample) map[string]HistorySummary {
+	out := make(map[string]HistorySummary)
+	for _, sample := range samples {
+		hours := sample.End.Sub(sample.Start).Hours()
+		for ns, c := range sample.Namespaces {
+			sum := out[ns]
+			sum.Puts += c.Puts
+			sum.Hits += c.Hits
+			sum.Misses += c.Misses
+			sum.RestoredPositions += c.RestoredPositions
+			sum.LocalByteHours += float64(c.LocalUsed) * hours
+			sum.RemoteByteHours += float64(c.RemoteUsed) * hours
+			out[ns] = sum
+		}
+	}
+	return out
+}
+
+// ReadStatsHistory returns the samples of the store whose local tier is
+// localPath that end after since, oldest first. It reads the file
+// directly, so it works while a runner has the store open. A store
+// without history yields no samples.
+func ReadStatsHistory(localPath string, since time.Time) ([]StatsSample, error) {
+	f, err := os.Open(filepath.Join(localPath, statsHistoryFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: read stats history: %w", err)
+	}
+	defer f.Close()
+
+	var out []StatsSample
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var sample StatsSample
+		if err := json.Unmarshal(sc.Bytes(), &sample); err != nil {
+			continue // torn by a crash mid-append
+		}
+		if sample.End.After(since) {
+			out = append(out, sample)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return out, fmt.Errorf("diskstore: read stats history: %w", err)
+	}
+	return out, nil
+}
+
+// RecordRestored adds n positions of ns restored from the store to the
+// stats history, so reports can tell how much prefill it saved.
+func (s *Store) RecordRestored(ns string, n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sampleFor(ns).RestoredPositions += int64(n)
+}
+
+// sampleFor returns the current interval's counters of ns. Must be called
+// with s.mu held.
+func (s *Store) sampleFor(ns string) *SampleCounters {
+	c := s.sample[ns]
+	if c == nil {
+		c = &SampleCounters{}
+		s.sample[ns] = c
+	}
+	return c
+}
+
+// takeSample ends the current interval and returns it. Must be called
+// with s.mu held.
+func (s *Store) takeSample(now time.Time) StatsSample {
+	sample := StatsSample{Start: s.sampleStart, End: now, Namespaces: make(map[string]SampleCounters)}
+	for ns, c := range s.sample {
+		sample.Namespaces[ns] = *c
+	}
+	for ns, u := range s.nsUsed {
+		c := sample.Namespaces[ns]
+		c.LocalUsed, c.RemoteUsed = u.local, u.remote
+		sample.Namespaces[ns] = c
+	}
+	s.sample = make(map[string]*SampleCounters)
+	s.sampleStart = now
+	return sample
+}
+
+// flushStats appends the current interval to the history. With skipIdle
+// set, an interval without traffic is dropped, so that opening a store
+// for a read-only maintenance command leaves no sample behind.
+func (s *Store) flushStats(skipIdle bool) {
+	s.mu.Lock()
+	traffic := len(s.sample) > 0
+	sample := s.takeSample(time.Now())
+	s.mu.Unlock()
+	if skipIdle && !traffic {
+		return
+	}
+
+	line, err := json.Marshal(sample)
+	if err != nil {
+		s.log.Warn("encode stats sample", "error", err)
+		return
+	}
+	path := filepath.Join(s.localPath, statsHistoryFile)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		s.log.Warn("write stats history", "path", path, "error", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		s.log.Warn("write stats history", "path", path, "error", err)
+	}
+}
+
+// pruneStatsHistory drops samples older than statsHistoryKeep.
+func (s *Store) pruneStatsHistory() {
+	cutoff := time.Now().Add(-statsHistoryKeep)
+	all, err := ReadStatsHistory(s.localPath, time.Time{})
+	if err != nil || len(all) == 0 || all[0].End.After(cutoff) {
+		return
+	}
+	var buf []byte
+	for _, sample := range all {
+		if !sample.End.After(cutoff) {
+			continue
+		}
+		line, err := json.Marshal(sample)
+		if err != nil {
+			return
+		}
+		buf = append(append(buf, line...), '\n')
+	}
+	path := filepath.Join(s.localPath, statsHistoryFile)
+	if err := os.WriteFile(path, buf, 0644); err != nil {
+		s.log.Warn("prune stats history", "path", path, "error", err)
+	}
+}
+
+// statsLoop appends a sample every interval until the store is closed.
+func (s *Store) statsLoop(interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.flushStats(false)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStatsHistory(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *Store {
+		store, err := New(Config{LocalPath: dir, LocalBudget: 1 << 20})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return store
+	}
+
+	before := time.Now()
+	store := open()
+	key := BlockKey{Namespace: "chat", Seq: 1, Layer: 0, BeginPos: 0, EndPos: 16, IsKey: true}
+	store.Put(key, "f16", []int{8}, make([]byte, 256))
+	store.Get(key)
+	store.Get(key)
+	store.Get(BlockKey{Namespace: "chat", Seq: 2, Layer: 0, BeginPos: 0, EndPos: 16, IsKey: true})
+	store.RecordRestored("chat", 16)
+	store.Close()
+
+	// Reopening without traffic adds no sample.
+	open().Close()
+
+	samples, err := ReadStatsHistory(dir, before)
+	if err != nil {
+		t.Fatalf("ReadStatsHistory: %v", err)
+	}
+	if len(samples) != 1 {
+		t.Fatalf("got %d samples, want 1", len(samples))
+	}
+	got := samples[0].Namespaces["chat"]
+	want := SampleCounters{Puts: 1, Hits: 2, Misses: 1, RestoredPositions: 16, LocalUsed: 256}
+	if got != want {
+		t.Errorf("sample = %+v, want %+v", got, want)
+	}
+	if samples, _ := ReadStatsHistory(dir, time.Now()); len(samples) != 0 {
+		t.Errorf("samples ending before since were returned: %+v", samples)
+	}
+
+	sum := SummarizeHistory([]StatsSample{
+		{Start: before, End: before.Add(time.Hour), Namespaces: map[string]SampleCounters{"chat": {Hits: 3, Misses: 1, LocalUsed: 100}}},
+		{Start: before.Add(2 * time.Hour), End: before.Add(4 * time.Hour), Namespaces: map[string]SampleCounters{"chat": {Hits: 1, LocalUsed: 50}}},
+	})["chat"]
+	if sum.Hits != 4 || sum.HitRatio() != 0.8 || sum.LocalByteHours != 200 {
+		t.Errorf("summary = %+v (hit ratio %v)", sum, sum.HitRatio())
+	}
+
+	// Samples past the retention window are dropped on open; torn lines
+	// are skipped.
+	old := `{"start":"2000-01-01T00:00:00Z","end":"2000-01-01T00:10:00Z","namespaces":{}}` + "\n"
+	path := filepath.Join(dir, statsHistoryFile)
+	data, _ := os.ReadFile(path)
+	os.WriteFile(path, append([]byte(old), append(data, `{"start":`...)...), 0644)
+	open().Close()
+	if samples, _ := ReadStatsHistory(dir, time.Time{}); len(samples) != 1 {
+		t.Errorf("after pruning got %d samples, want 1", len(samples))
+	}
+}
@@ -0,0 +1,256 @@
+package diskstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Journaled index: with IndexBackend "journal" the index isn't rewritten
+// whole on Close. Changed entries are appended to index.journal every
+// IndexSyncInterval, one JSON line per block holding its entry or, for a
+// removed block, none. Once the journal outgrows index.json it is folded
+// into it: the snapshot is rewritten and the journal emptied.
+//
+// Compaction streams the snapshot from the store's own entries, taking
+// s.mu for compactBatch entries at a time, so the store isn't held up
+// while a large index is rewritten and no second copy of it is kept.
+//
+// Loading reads the journal before the snapshot and replays it on top.
+// Every sync appends the changes before any compaction, so the journal's
+// last word on each block matches the snapshot written after it, or
+// predates a change made while it was written. Replaying a journal that
+// a crash or a concurrent compaction left behind so takes the index back
+// to the last sync at most, as a crash loses the changes since anyway;
+// the next sync journals them.
+//
+// This is the backend's scope: it makes saving the index incremental.
+// The index is still loaded whole and held in memory, and looked up by
+// scanning it; no embedded database (SQLite, bbolt) backs it.
+
+// Index backends for Config.IndexBackend.
+const (
+	IndexJSON    = "json"
+	IndexJournal = "journal"
+)
+
+const (
+	journalFile              = "index.journal"
+	defaultIndexSyncInterval = 5 * time.Second
+)
+
+// minJournalCompact is the smallest journal folded into the snapshot, so
+// a small store isn't rewritten on every sync.
+var minJournalCompact int64 = 1 << 20
+
+// compactBatch is how many entries compaction encodes per hold of s.mu.
+const compactBatch = 1024
+
+// journalEntry is one line of the journal.
+type journalEntry struct {
+	Key  string     `json:"key"`
+	Meta *BlockMeta `json:"meta,omitempty"` // nil = removed
+}
+
+// indexJournal is the open journal of a writer with IndexBackend
+// "journal". dirty is guarded by s.mu; the rest belongs to syncIndex.
+type indexJournal struct {
+	f        *os.File
+	size     int64 // bytes in the journal
+	snapshot int64 // bytes in index.json
+	dirty    map[string]struct{}
+}
+
+func (s *Store) journalPath() string {
+	return filepath.Join(s.localPath, journalFile)
+}
+
+// openJournal opens the journal for appending, cutting off whatever
+// follows its first valid bytes: a line torn by a crash.
+func (s *Store) openJournal(valid int64) error {
+	f, err := os.OpenFile(s.journalPath(), os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("diskstore: open index journal: %w", err)
+	}
+	if err := f.Truncate(valid); err != nil {
+		f.Close()
+		return fmt.Errorf("diskstore: open index journal: %w", err)
+	}
+	j := &indexJournal{f: f, size: valid, dirty: make(map[string]struct{})}
+	if info, err := os.Stat(s.indexPath()); err == nil {
+		j.snapshot = info.Size()
+	}
+	s.journal = j
+	return nil
+}
+
+// replayJournal applies journal data to the loaded index and returns how
+// many of its bytes are whole, valid lines.
+func (s *Store) replayJournal(data []byte) int64 {
+	var valid int64
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break // torn by a crash, or still being written
+		}
+		var e journalEntry
+		if err := json.Unmarshal(data[:i], &e); err != nil || e.Key == "" {
+			s.log.Warn("decode index journal, ignoring the rest", "path", s.journalPath(), "offset", valid, "error", err)
+			break
+		}
+		if e.Meta != nil {
+			s.index[e.Key] = e.Meta
+		} else {
+			delete(s.index, e.Key)
+		}
+		data = data[i+1:]
+		valid += int64(i + 1)
+	}
+	return valid
+}
+
+// syncIndex appends the entries changed since the last sync to the
+// journal, then compacts it if it has outgrown the snapshot.
+func (s *Store) syncIndex() error {
+	s.indexMu.Lock()
+	defer s.indexMu.Unlock()
+	j := s.journal
+	s.mu.Lock()
+	if len(j.dirty) == 0 {
+		s.mu.Unlock()
+		return nil
+	}
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	keys := make([]string, 0, len(j.dirty))
+	for k := range j.dirty {
+		keys = append(keys, k)
+		// Encoded under the lock, since entries change in place.
+		enc.Encode(journalEntry{Key: k, Meta: s.index[k]})
+	}
+	clear(j.dirty)
+	s.mu.Unlock()
+
+	_, err := j.f.Write(buf.Bytes())
+	if err == nil {
+		err = j.f.Sync()
+	}
+	if err != nil {
+		// Try again next time.
+		s.mu.Lock()
+		for _, k := range keys {
+			j.dirty[k] = struct{}{}
+		}
+		s.mu.Unlock()
+		s.log.Error("write index journal", "path", s.journalPath(), "error", err)
+		return fmt.Errorf("diskstore: write index journal: %w", err)
+	}
+	j.size += int64(buf.Len())
+	if j.size <= max(j.snapshot, minJournalCompact) {
+		return nil
+	}
+
+	var n int64
+	if err := replaceFileFunc(s.indexPath(), func(w io.Writer) (err error) {
+		n, err = s.streamIndex(w)
+		return err
+	}); err != nil {
+		s.log.Error("compact index journal", "path", s.indexPath(), "error", err)
+		return fmt.Errorf("diskstore: compact index journal: %w", err)
+	}
+	j.snapshot = n
+	if err := j.f.Truncate(0); err != nil {
+		s.log.Error("compact index journal", "path", s.journalPath(), "error", err)
+		return fmt.Errorf("diskstore: compact index journal: %w", err)
+	}
+	j.size = 0
+	s.log.Debug("compacted index journal", "bytes", n)
+	return nil
+}
+
+// streamIndex writes the index to w as index.json, holding s.mu for
+// compactBatch entries at a time, and returns the bytes written. An
+// entry added once it started is left to the journal.
+func (s *Store) streamIndex(w io.Writer) (int64, error) {
+	s.mu.RLock()
+	keys := make([]string, 0, len(s.index))
+	for k := range s.index {
+		keys = append(keys, k)
+	}
+	s.mu.RUnlock()
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `{"format":%d,"blocks":{`, storeFormat)
+	var n int64
+	first := true
+	for len(keys) > 0 {
+		batch := keys[:min(compactBatch, len(keys))]
+		keys = keys[len(batch):]
+		s.mu.RLock()
+		for _, k := range batch {
+			meta, ok := s.index[k]
+			if !ok {
+				continue // removed meanwhile
+			}
+			if !first {
+				buf.WriteByte(',')
+			}
+			first = false
+			name, _ := json.Marshal(k)
+			buf.Write(name)
+			buf.WriteByte(':')
+			if err := json.NewEncoder(&buf).Encode(meta); err != nil {
+				s.mu.RUnlock()
+				return n, err
+			}
+			buf.Truncate(buf.Len() - 1) // Encode's newline
+		}
+		s.mu.RUnlock()
+		m, err := w.Write(buf.Bytes())
+		n += int64(m)
+		if err != nil {
+			return n, err
+		}
+		buf.Reset()
+	}
+	buf.WriteString("}}")
+	m, err := w.Write(buf.Bytes())
+	return n + int64(m), err
+}
+
+// closeJournal syncs the journal a last time and closes it.
+func (s *Store) closeJournal() error {
+	err := s.syncIndex()
+	if cerr := s.journal.f.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("diskstore: close index journal: %w", cerr)
+	}
+	return err
+}
+
+// removeJournal deletes a journal a "json" store has folded into the
+// snapshot it just wrote.
+func (s *Store) removeJournal() error {
+	if err := os.Remove(s.journalPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("diskstore: remove index journal: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) indexSyncLoop(interval time.Duration) {
+	defer s.wg.Done()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.syncIndex()
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestIndexJournal(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:         filepath.Join(dir, "local"),
+		RemotePath:        filepath.Join(dir, "remote"),
+		LocalBudget:       1024 * 1024,
+		RemoteBudget:      1024 * 1024,
+		StatsInterval:     -1,
+		IndexBackend:      IndexJournal,
+		IndexSyncInterval: -1,
+	}
+	open := func(cfg Config) *Store {
+		t.Helper()
+		store, err := New(cfg)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		return store
+	}
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	snapshot := filepath.Join(cfg.LocalPath, "index.json")
+	journal := filepath.Join(cfg.LocalPath, journalFile)
+
+	store := open(cfg)
+	for i := range int32(3) {
+		if err := store.Put(key(i), "f16", []int{50}, make([]byte, 100)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if err := store.syncIndex(); err != nil {
+		t.Fatalf("syncIndex: %v", err)
+	}
+	// A reader sees what the writer synced.
+	ro := cfg
+	ro.ReadOnly = true
+	reader := open(ro)
+	if got := len(reader.AllBlocks()); got != 3 {
+		t.Errorf("reader sees %d blocks, want 3", got)
+	}
+	reader.Close()
+
+	if err := store.Delete(key(0)); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(snapshot); !os.IsNotExist(err) {
+		t.Errorf("index.json written without compaction: %v", err)
+	}
+
+	// A line torn by a crash is ignored, and cut off by the next writer.
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"key":"torn`)
+	f.Close()
+	store = open(cfg)
+	blocks := store.AllBlocks()
+	if len(blocks) != 2 || blocks[0].Tier != "remote" {
+		t.Fatalf("reopened with %+v, want 2 remote blocks", blocks)
+	}
+	if used := store.Stats().RemoteUsed; used != 200 {
+		t.Errorf("RemoteUsed = %d, want 200", used)
+	}
+	if err := store.Put(key(3), "f16", []int{50}, make([]byte, 100)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	store.Close()
+
+	// Compaction folds the journal into index.json.
+	defer func(n int64) { minJournalCompact = n }(minJournalCompact)
+	minJournalCompact = 0
+	store = open(cfg)
+	store.Pin(1)
+	if err := store.syncIndex(); err != nil {
+		t.Fatalf("syncIndex: %v", err)
+	}
+	if info, err := os.Stat(journal); err != nil || info.Size() != 0 {
+		t.Errorf("journal not emptied by compaction: %v", err)
+	}
+	store.Close()
+
+	// A "json" store takes over a journaled one.
+	cfg.IndexBackend = ""
+	store = open(cfg)
+	blocks = store.AllBlocks()
+	if len(blocks) != 3 || !blocks[0].Pinned {
+		t.Errorf("reopened with %+v, want 3 pinned blocks", blocks)
+	}
+	store.Close()
+	if _, err := os.Stat(journal); !os.IsNotExist(err) {
+		t.Errorf("journal left behind by a json store: %v", err)
+	}
+
+	cfg.IndexBackend = "sqlite"
+	if _, err := New(cfg); err == nil {
+		t.Error("New accepted an unknown index backend")
+	}
+}
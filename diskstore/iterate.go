@@ -0,0 +1,70 @@
+package diskstore
+
+import (
+	"iter"
+	"slices"
+	"time"
+)
+
+// BlockFilter selects blocks for Iter. Each field left at its zero value
+// matches every block; the fields set must all match.
+type BlockFilter struct {
+	Seqs       []int
+	Layers     []int
+	Namespaces []string // "" is the default namespace
+	Tier       string   // "local" or "remote"
+
+	// MinAge and MaxAge bound the time since a block was stored, MinIdle
+	// the time since it was last read.
+	MinAge, MaxAge time.Duration
+	MinIdle        time.Duration
+}
+
+// match reports whether meta passes f at now.
+func (f *BlockFilter) match(meta *BlockMeta, now time.Time) bool {
+	switch {
+	case f.Seqs != nil && !slices.Contains(f.Seqs, meta.Key.Seq):
+		return false
+	case f.Layers != nil && !slices.Contains(f.Layers, meta.Key.Layer):
+		return false
+	case f.Namespaces != nil && !slices.Contains(f.Namespaces, meta.Key.Namespace):
+		return false
+	case f.Tier != "" && meta.Tier != f.Tier:
+		return false
+	}
+	age := now.Sub(meta.StoredAt)
+	if age < f.MinAge || f.MaxAge > 0 && age > f.MaxAge {
+		return false
+	}
+	return now.Sub(meta.AccessedAt) >= f.MinIdle
+}
+
+// Iter returns the blocks matching f, ordered like Blocks, for use with
+// range:
+//
+//	for meta := range store.Iter(diskstore.BlockFilter{Tier: "remote", MinIdle: time.Hour}) {
+//		...
+//	}
+//
+// The blocks are those indexed when iteration starts; the loop body may
+// call the store, including to change it.
+func (s *Store) Iter(f BlockFilter) iter.Seq[BlockMeta] {
+	return func(yield func(BlockMeta) bool) {
+		now := time.Now()
+		var blocks []BlockMeta
+		s.mu.RLock()
+		for _, meta := range s.index {
+			if f.match(meta, now) {
+				blocks = append(blocks, *meta)
+			}
+		}
+		s.mu.RUnlock()
+		sortBlocks(blocks)
+
+		for _, meta := range blocks {
+			if !yield(meta) {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestIterFilters(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for seq := range 3 {
+		for layer := range 2 {
+			key := BlockKey{Seq: seq, Layer: layer, BeginPos: 0, EndPos: 4, IsKey: true}
+			if seq == 2 {
+				key.Namespace = "qwen"
+			}
+			if err := store.Put(key, "f16", []int{8}, make([]byte, 16)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+	}
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	// Seq 0 was stored, and last read, two hours ago.
+	store.mu.Lock()
+	for _, meta := range store.index {
+		if meta.Key.Seq == 0 {
+			meta.StoredAt = time.Now().Add(-2 * time.Hour)
+			meta.AccessedAt = meta.StoredAt
+		}
+	}
+	store.mu.Unlock()
+
+	type block struct{ seq, layer int }
+	collect := func(f BlockFilter) []block {
+		var out []block
+		for meta := range store.Iter(f) {
+			out = append(out, block{meta.Key.Seq, meta.Key.Layer})
+		}
+		return out
+	}
+	for _, tc := range []struct {
+		name string
+		f    BlockFilter
+		want []block
+	}{
+		{"all", BlockFilter{}, []block{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {2, 0}, {2, 1}}},
+		{"seq", BlockFilter{Seqs: []int{0, 2}}, []block{{0, 0}, {0, 1}, {2, 0}, {2, 1}}},
+		{"layer", BlockFilter{Layers: []int{1}}, []block{{0, 1}, {1, 1}, {2, 1}}},
+		{"tier", BlockFilter{Tier: "remote"}, []block{{1, 0}, {1, 1}}},
+		{"default namespace", BlockFilter{Namespaces: []string{""}, Layers: []int{0}}, []block{{0, 0}, {1, 0}}},
+		{"old", BlockFilter{MinAge: time.Hour}, []block{{0, 0}, {0, 1}}},
+		{"new", BlockFilter{MaxAge: time.Hour, Layers: []int{0}}, []block{{1, 0}, {2, 0}}},
+		{"idle", BlockFilter{MinIdle: time.Hour, Tier: "local"}, []block{{0, 0}, {0, 1}}},
+	} {
+		if got := collect(tc.f); !slices.Equal(got, tc.want) {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	// Breaking out stops the walk, and the loop body may change the
+	// store.
+	n := 0
+	for meta := range store.Iter(BlockFilter{}) {
+		if err := store.Delete(meta.Key); err != nil {
+			t.Fatalf("Delete during Iter: %v", err)
+		}
+		if n++; n == 2 {
+			break
+		}
+	}
+	if got := len(store.AllBlocks()); got != 4 {
+		t.Errorf("%d blocks left after deleting 2, want 4", got)
+	}
+}
@@ -0,0 +1,533 @@
+package diskstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// llama.cpp session files: llama.cpp saves a context's prompt cache
+// (llama-cli --prompt-cache, llama_state_save_file) as the prompt's
+// tokens followed by the context state, whose KV part holds, for every
+// layer, a row of keys and one of values per cell. Those are the rows a
+// block stores, so a prefix computed by llama.cpp can seed a store and a
+// stored sequence can be handed to llama.cpp, as long as both run the
+// same model with the same cache type.
+//
+// The layout read and written is that of session version 9 with KV
+// streams, little-endian:
+//
+//	magic "ggsn" u32, version u32, n_tokens u32, tokens i32[n_tokens]
+//	n_outputs u32, output positions i32[n_outputs]
+//	n_logits u64, logits f32[n_logits]
+//	n_embd u64, embeddings f32[n_embd]
+//	n_stream u32, then per stream:
+//	  n_cells u32, per cell: pos i32, n_seq u32, seq ids i32[n_seq]
+//	  v_trans u32, n_layer u32
+//	  per layer: k type i32, k row size u64, k rows
+//	  per layer: v type i32, v row size u64, v rows          (v_trans 0)
+//	  per layer: v type i32, v elem size u32, n_embd_v u32,
+//	             v elements grouped by dimension            (v_trans 1)
+//
+// Logits and embeddings are not stored and are written empty: llama.cpp
+// recomputes the last prompt token of a session it loads. Import takes
+// the cells of llama.cpp sequence 0 and stops at the first gap in their
+// positions or the end of the tokens. Anything that does not parse
+// exactly, including files from other llama.cpp versions, is an error
+// rather than a partial import.
+
+const (
+	llamaSessionMagic   = 0x6767736e // "ggsn"
+	llamaSessionVersion = 9
+)
+
+// Sanity limits on the counts a session file declares, so a corrupt one
+// fails instead of allocating without bound.
+const (
+	llamaMaxTokens  = 1 << 24
+	llamaMaxLayers  = 1 << 12
+	llamaMaxRowSize = 1 << 24
+)
+
+// llamaTypes maps normalized dtype names to ggml type IDs, with the
+// bytes and elements of a ggml block of that type.
+var llamaTypes = map[string]struct {
+	id          int32
+	size, elems int
+	name        string
+}{
+	"f32":  {0, 4, 1, "f32"},
+	"f16":  {1, 2, 1, "f16"},
+	"q40":  {2, 18, 32, "q4_0"},
+	"q80":  {8, 34, 32, "q8_0"},
+	"bf16": {30, 2, 1, "bf16"},
+}
+
+// normalizeDType folds dtype spellings ("F16", "DTypeQ80", "q8_0") to
+// the keys of llamaTypes.
+func normalizeDType(dtype string) string {
+	d := strings.ToLower(dtype)
+	d = strings.TrimPrefix(d, "dtype")
+	return strings.ReplaceAll(d, "_", "")
+}
+
+// llamaTypeOf returns the ggml type ID of dtype.
+func llamaTypeOf(dtype string) (int32, error) {
+	t, ok := llamaTypes[normalizeDType(dtype)]
+	if !ok {
+		return 0, fmt.Errorf("diskstore: dtype %q has no llama.cpp equivalent", dtype)
+	}
+	return t.id, nil
+}
+
+// LlamaSessionOptions configures ExportLlamaSession and
+// ImportLlamaSession.
+type LlamaSessionOptions struct {
+	// BlockSize is how many positions each block ImportLlamaSession
+	// stores spans, 256 if zero.
+	BlockSize int32
+
+	// TransposeV makes ExportLlamaSession write values transposed, as
+	// llama.cpp keeps them without flash attention; llama.cpp refuses
+	// a session whose value layout differs from its own.
+	TransposeV bool
+}
+
+// ExportLlamaSession writes positions 0 on of seq to w as a llama.cpp
+// session file and returns how many positions it wrote: as far as
+// every layer's keys and values and the token manifest (TieredConfig's
+// audit, or checkpoints) are stored unshifted without a gap.
+func (s *Store) ExportLlamaSession(seq int, w io.Writer, opts LlamaSessionOptions) (int32, error) {
+	layers, dtype := s.seqLayers(seq)
+	if layers == 0 {
+		return 0, fmt.Errorf("diskstore: export seq %d: no blocks", seq)
+	}
+	typ, err := llamaTypeOf(dtype)
+	if err != nil {
+		return 0, err
+	}
+	if opts.TransposeV && llamaTypes[normalizeDType(dtype)].elems != 1 {
+		return 0, fmt.Errorf("diskstore: export seq %d: %s values can't be transposed", seq, dtype)
+	}
+
+	n := s.storedPrefix(seq, layers)
+	if n == 0 {
+		return 0, fmt.Errorf("diskstore: export seq %d: no stored prefix with tokens and every layer's keys and values", seq)
+	}
+
+	data, _, err := s.ReadRange(ManifestKey(BlockKey{Seq: seq, EndPos: n}))
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+	}
+	bw := &binWriter{w: bufio.NewWriterSize(w, 1<<20)}
+	bw.put(uint32(llamaSessionMagic), uint32(llamaSessionVersion), uint32(n))
+	bw.write(data)
+	// No outputs, logits or embeddings; one stream of n cells of seq 0.
+	bw.put(uint32(0), uint64(0), uint64(0), uint32(1), uint32(n))
+	for pos := int32(0); pos < n; pos++ {
+		bw.put(pos, uint32(1), int32(0))
+	}
+	vTrans := uint32(0)
+	if opts.TransposeV {
+		vTrans = 1
+	}
+	bw.put(vTrans, uint32(layers))
+
+	for _, isKey := range []bool{true, false} {
+		for layer := range layers {
+			rows, end, err := s.ReadRange(BlockKey{Seq: seq, Layer: layer, IsKey: isKey, EndPos: n})
+			if err == nil && end < n {
+				err = fmt.Errorf("layer %d: stored positions end at %d", layer, end)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+			}
+			rowSize := len(rows) / int(n)
+			if isKey || !opts.TransposeV {
+				bw.put(typ, uint64(rowSize))
+				bw.write(rows)
+				continue
+			}
+			el := llamaTypes[normalizeDType(dtype)].size
+			bw.put(typ, uint32(el), uint32(rowSize/el))
+			bw.write(transposeRows(rows, int(n), el))
+		}
+	}
+	if err := bw.flush(); err != nil {
+		return 0, fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+	}
+	s.log.Info("exported llama.cpp session", "seq", seq, "positions", n, "layers", layers)
+	return n, nil
+}
+
+// storedPrefix returns how many positions from 0 the token manifest and
+// both halves of each of seq's layers hold unshifted without a gap.
+func (s *Store) storedPrefix(seq, layers int) int32 {
+	prefix := func(key BlockKey) int32 {
+		key.EndPos = math.MaxInt32
+		spans := s.Coverage(key)
+		if len(spans) == 0 || spans[0].Begin > 0 || spans[0].Shift != 0 {
+			return 0
+		}
+		return spans[0].End
+	}
+	n := prefix(ManifestKey(BlockKey{Seq: seq}))
+	for layer := range layers {
+		for _, isKey := range []bool{true, false} {
+			n = min(n, prefix(BlockKey{Seq: seq, Layer: layer, IsKey: isKey}))
+		}
+	}
+	return n
+}
+
+// seqLayers returns how many layers seq's blocks cover and their dtype.
+func (s *Store) seqLayers(seq int) (int, string) {
+	var layers int
+	var dtype string
+	for meta := range s.Iter(BlockFilter{Seqs: []int{seq}, Namespaces: []string{""}}) {
+		if meta.Key.Layer >= 0 {
+			layers = max(layers, meta.Key.Layer+1)
+			dtype = meta.DTypeStr
+		}
+	}
+	return layers, dtype
+}
+
+// ImportLlamaSession reads a llama.cpp session file from r and stores
+// the positions of its sequence 0 as seq, with a token manifest,
+// replacing seq's blocks. It returns how many positions it stored. A
+// store with a fingerprint checks the file's layer count, row sizes and
+// cache type against it.
+func (s *Store) ImportLlamaSession(r io.Reader, seq int, opts LlamaSessionOptions) (int32, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	bs := opts.BlockSize
+	if bs <= 0 {
+		bs = 256
+	}
+	sess, err := readLlamaSession(bufio.NewReaderSize(r, 1<<20))
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: import llama.cpp session: %w", err)
+	}
+	if err := s.checkLlamaSession(sess); err != nil {
+		return 0, err
+	}
+	if sess.n == 0 {
+		return 0, fmt.Errorf("diskstore: import llama.cpp session: no positions of sequence 0 with tokens")
+	}
+	if _, err := s.TruncateSeq(seq, 0); err != nil {
+		return 0, err
+	}
+
+	shape := func(rowSize int) []int {
+		if f := s.fingerprint; f != nil && f.HeadDim > 0 && f.NKVHeads > 0 {
+			return []int{f.HeadDim, f.NKVHeads}
+		}
+		t := llamaTypes[normalizeDType(sess.dtype)]
+		return []int{rowSize / t.size * t.elems, 1}
+	}
+	for b := int32(0); b*bs < sess.n; b++ {
+		begin, end := b*bs, min(sess.n, (b+1)*bs)
+		key := BlockKey{Seq: seq, BeginPos: begin, EndPos: end}
+		if err := s.Put(ManifestKey(key), ManifestDType, nil, EncodeTokens(sess.tokens[begin:end])); err != nil {
+			return begin, err
+		}
+		for layer := range sess.keys {
+			for _, half := range []struct {
+				rows  [][]byte
+				isKey bool
+			}{{sess.keys[layer], true}, {sess.values[layer], false}} {
+				k := key
+				k.Layer, k.IsKey = layer, half.isKey
+				data := make([]byte, 0, int(end-begin)*len(half.rows[0]))
+				for pos := begin; pos < end; pos++ {
+					data = append(data, half.rows[pos]...)
+				}
+				if err := s.Put(k, sess.dtype, shape(len(half.rows[0])), data); err != nil {
+					return begin, err
+				}
+			}
+		}
+	}
+	s.log.Info("imported llama.cpp session", "seq", seq, "positions", sess.n, "layers", len(sess.keys))
+	return sess.n, nil
+}
+
+// checkLlamaSession checks sess against the store's fingerprint.
+func (s *Store) checkLlamaSession(sess *llamaSession) error {
+	f := s.fingerprint
+	if f == nil {
+		return nil
+	}
+	switch {
+	case f.NLayers > 0 && len(sess.keys) != f.NLayers:
+		return fmt.Errorf("%w: session has %d layers, model has %d", ErrFingerprintMismatch, len(sess.keys), f.NLayers)
+	case f.DType != "" && normalizeDType(f.DType) != normalizeDType(sess.dtype):
+		return fmt.Errorf("%w: session cache type is %s, model uses %s", ErrFingerprintMismatch, sess.dtype, f.DType)
+	}
+	if f.HeadDim > 0 && f.NKVHeads > 0 && len(sess.keys) > 0 {
+		t := llamaTypes[normalizeDType(sess.dtype)]
+		if got, want := len(sess.keys[0][0])/t.size*t.elems, f.HeadDim*f.NKVHeads; got != want {
+			return fmt.Errorf("%w: session rows hold %d elements, model's %d", ErrFingerprintMismatch, got, want)
+		}
+	}
+	// The store's own spelling, so blocks match the fingerprint.
+	if f.DType != "" {
+		sess.dtype = f.DType
+	}
+	return nil
+}
+
+// llamaSession is what ImportLlamaSession keeps of a session file: the
+// tokens and each layer's key and value rows of positions [0, n).
+type llamaSession struct {
+	tokens       []int32
+	n            int32
+	dtype        string
+	keys, values [][][]byte // [layer][pos]
+}
+
+// readLlamaSession parses a session file.
+func readLlamaSession(r io.Reader) (*llamaSession, error) {
+	br := &binReader{r: r}
+	var magic, version, nTokens uint32
+	br.get(&magic, &version, &nTokens)
+	switch {
+	case br.err != nil:
+		return nil, br.err
+	case magic != llamaSessionMagic:
+		return nil, fmt.Errorf("not a llama.cpp session file (magic %#x)", magic)
+	case version != llamaSessionVersion:
+		return nil, fmt.Errorf("session version %d, want %d", version, llamaSessionVersion)
+	case nTokens > llamaMaxTokens:
+		return nil, fmt.Errorf("%d tokens", nTokens)
+	}
+	sess := &llamaSession{tokens: make([]int32, nTokens)}
+	br.get(sess.tokens)
+
+	var nOutputs uint32
+	br.get(&nOutputs)
+	br.skip(int64(nOutputs) * 4)
+	var nLogits, nEmbd uint64
+	br.get(&nLogits)
+	br.skip(int64(nLogits) * 4)
+	br.get(&nEmbd)
+	br.skip(int64(nEmbd) * 4)
+
+	var nStream uint32
+	br.get(&nStream)
+	if br.err == nil && (nStream == 0 || nStream > 256) {
+		return nil, fmt.Errorf("%d KV streams", nStream)
+	}
+	for stream := uint32(0); stream < nStream && br.err == nil; stream++ {
+		if err := sess.readStream(br); err != nil {
+			return nil, err
+		}
+	}
+	if br.err != nil {
+		return nil, br.err
+	}
+	var extra [1]byte
+	if n, _ := io.ReadFull(r, extra[:]); n > 0 {
+		return nil, errors.New("trailing data after the KV cache")
+	}
+	return sess, nil
+}
+
+// readStream reads one KV stream, keeping its rows of sequence 0 if it
+// holds any.
+func (sess *llamaSession) readStream(br *binReader) error {
+	var nCells uint32
+	br.get(&nCells)
+	if br.err == nil && nCells > llamaMaxTokens {
+		return fmt.Errorf("%d cells", nCells)
+	}
+	// The cell of each position of sequence 0.
+	cellOf := make(map[int32]int)
+	for i := 0; i < int(nCells) && br.err == nil; i++ {
+		var pos int32
+		var nSeq uint32
+		br.get(&pos, &nSeq)
+		if br.err == nil && nSeq > 1<<16 {
+			return fmt.Errorf("cell %d has %d sequences", i, nSeq)
+		}
+		seqs := make([]int32, nSeq)
+		br.get(seqs)
+		for _, s := range seqs {
+			if s == 0 {
+				cellOf[pos] = i
+			}
+		}
+	}
+	var vTrans, nLayer uint32
+	br.get(&vTrans, &nLayer)
+	if br.err != nil {
+		return br.err
+	}
+	if nLayer == 0 || nLayer > llamaMaxLayers {
+		return fmt.Errorf("%d layers", nLayer)
+	}
+	n := int32(0)
+	for n < int32(len(sess.tokens)) {
+		if _, ok := cellOf[n]; !ok {
+			break
+		}
+		n++
+	}
+	keep := n > 0 && sess.keys == nil
+	if keep {
+		sess.n = n
+		sess.keys = make([][][]byte, nLayer)
+		sess.values = make([][][]byte, nLayer)
+	}
+
+	// rows splits a layer's rows in cell order into rows by position.
+	rows := func(data []byte, rowSize int) [][]byte {
+		out := make([][]byte, n)
+		for pos := range out {
+			c := cellOf[int32(pos)]
+			out[pos] = data[c*rowSize : (c+1)*rowSize]
+		}
+		return out
+	}
+	for _, isKey := range []bool{true, false} {
+		for layer := 0; layer < int(nLayer); layer++ {
+			var typ int32
+			br.get(&typ)
+			dtype, err := llamaDType(typ)
+			if br.err == nil && err != nil {
+				return err
+			}
+			var rowSize, el int
+			if isKey || vTrans == 0 {
+				var size uint64
+				br.get(&size)
+				rowSize = int(min(size, llamaMaxRowSize+1))
+			} else {
+				var elSize, nEmbd uint32
+				br.get(&elSize, &nEmbd)
+				el, rowSize = int(elSize), int(min(uint64(elSize)*uint64(nEmbd), llamaMaxRowSize+1))
+			}
+			if br.err != nil {
+				return br.err
+			}
+			if rowSize == 0 || rowSize > llamaMaxRowSize {
+				return fmt.Errorf("layer %d: row size %d", layer, rowSize)
+			}
+			data := br.bytes(int(nCells) * rowSize)
+			if br.err != nil {
+				return br.err
+			}
+			if !keep {
+				continue
+			}
+			if sess.dtype != "" && dtype != sess.dtype {
+				return fmt.Errorf("layer %d is %s, earlier layers %s", layer, dtype, sess.dtype)
+			}
+			sess.dtype = dtype
+			if isKey {
+				sess.keys[layer] = rows(data, rowSize)
+				continue
+			}
+			if vTrans != 0 {
+				data = transposeRows(data, rowSize/el, el)
+			}
+			sess.values[layer] = rows(data, rowSize)
+		}
+	}
+	return br.err
+}
+
+// llamaDType returns the canonical dtype name of a ggml type ID.
+func llamaDType(typ int32) (string, error) {
+	for _, t := range llamaTypes {
+		if t.id == typ {
+			return t.name, nil
+		}
+	}
+	return "", fmt.Errorf("ggml type %d is not a KV cache type", typ)
+}
+
+// transposeRows transposes a matrix of rows elements of el bytes each,
+// stored row after row: element j of row i moves to row j, column i.
+func transposeRows(data []byte, rows, el int) []byte {
+	cols := len(data) / (rows * el)
+	out := make([]byte, len(data))
+	for i := 0; i < rows; i++ {
+		for j := 0; j < cols; j++ {
+			copy(out[(j*rows+i)*el:(j*rows+i+1)*el], data[(i*cols+j)*el:(i*cols+j+1)*el])
+		}
+	}
+	return out
+}
+
+// binWriter writes little-endian values, keeping the first error.
+type binWriter struct {
+	w   *bufio.Writer
+	err error
+}
+
+func (b *binWriter) put(vs ...any) {
+	for _, v := range vs {
+		if b.err == nil {
+			b.err = binary.Write(b.w, binary.LittleEndian, v)
+		}
+	}
+}
+
+func (b *binWriter) write(p []byte) {
+	if b.err == nil {
+		_, b.err = b.w.Write(p)
+	}
+}
+
+func (b *binWriter) flush() error {
+	if b.err == nil {
+		b.err = b.w.Flush()
+	}
+	return b.err
+}
+
+// binReader reads little-endian values, keeping the first error; a file
+// that ends early is io.ErrUnexpectedEOF.
+type binReader struct {
+	r   io.Reader
+	err error
+}
+
+func (b *binReader) get(vs ...any) {
+	for _, v := range vs {
+		if b.err == nil {
+			b.err = binary.Read(b.r, binary.LittleEndian, v)
+			if errors.Is(b.err, io.EOF) {
+				b.err = io.ErrUnexpectedEOF
+			}
+		}
+	}
+}
+
+func (b *binReader) bytes(n int) []byte {
+	if b.err != nil {
+		return nil
+	}
+	p := make([]byte, n)
+	if _, err := io.ReadFull(b.r, p); err != nil {
+		b.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	return p
+}
+
+func (b *binReader) skip(n int64) {
+	if b.err != nil {
+		return
+	}
+	if m, _ := io.CopyN(io.Discard, b.r, n); m < n {
+		b.err = io.ErrUnexpectedEOF
+	}
+}
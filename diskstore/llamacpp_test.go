@@ -0,0 +1,111 @@
+package diskstore
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+)
+
+// putLlamaSeq stores positions [0, n) of seq for two layers in blocks of
+// four, with tokens 100 on, and returns the rows it stored by layer and
+// half.
+func putLlamaSeq(t *testing.T, store *Store, seq int, n int32) map[BlockKey][]byte {
+	t.Helper()
+	rows := make(map[BlockKey][]byte)
+	for layer := 0; layer < 2; layer++ {
+		for _, isKey := range []bool{true, false} {
+			data := make([]byte, n*8)
+			for i := range data {
+				data[i] = byte(i*7 + layer*31 + len(rows))
+			}
+			rows[BlockKey{Seq: seq, Layer: layer, IsKey: isKey, EndPos: n}] = data
+			for begin := int32(0); begin < n; begin += 4 {
+				end := min(n, begin+4)
+				key := BlockKey{Seq: seq, Layer: layer, IsKey: isKey, BeginPos: begin, EndPos: end}
+				if err := store.Put(key, "f16", []int{4, 1}, data[begin*8:end*8]); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+			}
+		}
+	}
+	tokens := make([]int32, n)
+	for i := range tokens {
+		tokens[i] = 100 + int32(i)
+	}
+	store.Put(ManifestKey(BlockKey{Seq: seq, EndPos: n}), ManifestDType, nil, EncodeTokens(tokens))
+	return rows
+}
+
+func TestLlamaSession(t *testing.T) {
+	dir := t.TempDir()
+	open := func(name string, fp *Fingerprint) *Store {
+		store, err := New(Config{
+			LocalPath:     filepath.Join(dir, name),
+			LocalBudget:   1 << 20,
+			StatsInterval: -1,
+			Fingerprint:   fp,
+		})
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		t.Cleanup(func() { store.Close() })
+		return store
+	}
+	src := open("src", nil)
+	rows := putLlamaSeq(t, src, 1, 10)
+
+	for i, transpose := range []bool{false, true} {
+		name := func(s string) string { return fmt.Sprintf("%s%d", s, i) }
+		var buf bytes.Buffer
+		opts := LlamaSessionOptions{BlockSize: 8, TransposeV: transpose}
+		if n, err := src.ExportLlamaSession(1, &buf, opts); err != nil || n != 10 {
+			t.Fatalf("ExportLlamaSession = %d, %v; want 10", n, err)
+		}
+		file := buf.Bytes()
+		var head [4]uint32
+		binary.Read(bytes.NewReader(file), binary.LittleEndian, &head)
+		if head != [4]uint32{llamaSessionMagic, llamaSessionVersion, 10, 100} {
+			t.Errorf("session starts %v", head)
+		}
+
+		dst := open(name("dst"), &Fingerprint{NLayers: 2, NKVHeads: 1, HeadDim: 4, DType: "f16"})
+		if n, err := dst.ImportLlamaSession(bytes.NewReader(file), 7, opts); err != nil || n != 10 {
+			t.Fatalf("ImportLlamaSession (transpose %v) = %d, %v; want 10", transpose, n, err)
+		}
+		for key, want := range rows {
+			key.Seq = 7
+			if got, end, err := dst.ReadRange(key); err != nil || end != 10 || !bytes.Equal(got, want) {
+				t.Errorf("transpose %v: %s holds %d bytes to %d, %v; want the exported rows", transpose, key, len(got), end, err)
+			}
+		}
+		if got := len(dst.Blocks(7)); got != 2*5 {
+			t.Errorf("imported %d blocks, want 10 of up to 8 positions", got)
+		}
+		if tokens, end, _ := dst.ReadRange(ManifestKey(BlockKey{Seq: 7, EndPos: 10})); end != 10 || DecodeTokens(tokens)[9] != 109 {
+			t.Errorf("imported tokens %v", DecodeTokens(tokens))
+		}
+
+		// A short file, another model and another file are refused.
+		if _, err := open(name("short"), nil).ImportLlamaSession(bytes.NewReader(file[:len(file)-1]), 1, opts); err == nil {
+			t.Error("import of a truncated session succeeded")
+		}
+		if _, err := open(name("other"), &Fingerprint{NLayers: 3}).ImportLlamaSession(bytes.NewReader(file), 1, opts); !errors.Is(err, ErrFingerprintMismatch) {
+			t.Errorf("import into a store of another model: %v", err)
+		}
+		if _, err := open(name("junk"), nil).ImportLlamaSession(bytes.NewReader([]byte("not a session file")), 1, opts); err == nil {
+			t.Error("import of junk succeeded")
+		}
+	}
+
+	// Without tokens there is nothing llama.cpp could use.
+	src.RemoveSeq(1)
+	for key, data := range rows {
+		src.Put(key, "f16", []int{4, 1}, data)
+	}
+	if _, err := src.ExportLlamaSession(1, &bytes.Buffer{}, LlamaSessionOptions{}); err == nil {
+		t.Error("export without a token manifest succeeded")
+	}
+}
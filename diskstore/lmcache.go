@@ -0,0 +1,190 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// LMCache export: LMCache, the KV cache layer vLLM loads through its KV
+// connector, keeps a local disk cache as one file per chunk of prompt
+// tokens, named after the chunk's cache engine key
+// (fmt@model@world_size@worker_id@chunk_hash) and holding the raw
+// tensor of the chunk in its KV_2LTD layout: keys then values, each
+// layer's rows position by position. ExportLMCache writes a sequence the
+// same way, so a vLLM deployment of the same model can take over a
+// prefix an Ollama node computed.
+//
+// LMCache hashes chunks with its token database, whose hash depends on
+// the LMCache version and its Python hash seed, so the chunk hashes here
+// are this store's chained prefix hashes (ChainPrefixHash) instead.
+// lmcache_index.json lists every chunk with its file, positions and
+// tokens; an adapter re-keys the files by running the tokens through
+// LMCache's own token database (see README).
+
+// LMCacheIndexFile is the name of the chunk index ExportLMCache writes
+// next to the chunk files.
+const LMCacheIndexFile = "lmcache_index.json"
+
+// lmcacheIndexVersion is bumped when the export layout changes
+// incompatibly.
+const lmcacheIndexVersion = 1
+
+// lmcacheDTypes maps normalized dtype names to the torch dtypes vLLM
+// caches hold; quantized caches have no vLLM equivalent.
+var lmcacheDTypes = map[string]string{
+	"f16":  "float16",
+	"bf16": "bfloat16",
+	"f32":  "float32",
+}
+
+// LMCacheOptions configures ExportLMCache. The model, world size and
+// worker ID go into every chunk's cache engine key and must match the
+// consuming vLLM deployment.
+type LMCacheOptions struct {
+	// Model is the model name vLLM serves, e.g. "meta-llama/Llama-3.1-8B";
+	// the fingerprint's model digest if empty.
+	Model string
+
+	// ChunkSize is how many positions each chunk spans, 256 (LMCache's
+	// default chunk_size) if zero.
+	ChunkSize int32
+
+	// WorldSize and WorkerID are the tensor-parallel size and rank,
+	// 1 and 0 if zero.
+	WorldSize int
+	WorkerID  int
+}
+
+// LMCacheIndex is the content of LMCacheIndexFile.
+type LMCacheIndex struct {
+	Version    int       `json:"version"`
+	Seq        int       `json:"seq"`
+	ExportedAt time.Time `json:"exported_at"`
+
+	Format    string `json:"fmt"`
+	Model     string `json:"model_name"`
+	WorldSize int    `json:"world_size"`
+	WorkerID  int    `json:"worker_id"`
+	ChunkSize int32  `json:"chunk_size"`
+
+	// DType is the torch dtype of the chunk tensors, and KVShape the
+	// shape of a full chunk: [2, layers, chunk_size, row elements].
+	DType   string `json:"dtype"`
+	KVShape []int  `json:"kv_shape"`
+
+	Chunks []LMCacheChunk `json:"chunks"`
+
+	// Fingerprint of the exporting store's model, if it had one.
+	Fingerprint *Fingerprint `json:"fingerprint,omitempty"`
+}
+
+// LMCacheChunk describes one chunk file.
+type LMCacheChunk struct {
+	File     string  `json:"file"`
+	Hash     string  `json:"chunk_hash"`
+	BeginPos int32   `json:"begin_pos"`
+	EndPos   int32   `json:"end_pos"`
+	Tokens   []int32 `json:"tokens"`
+}
+
+// ExportLMCache writes positions 0 on of seq to dir as LMCache chunk
+// files and an LMCacheIndexFile, as far as every layer's keys and values
+// and the token manifest are stored unshifted without a gap; the last
+// chunk may be partial. It returns the number of chunks written.
+func (s *Store) ExportLMCache(seq int, dir string, opts LMCacheOptions) (int, error) {
+	layers, dtype := s.seqLayers(seq)
+	if layers == 0 {
+		return 0, fmt.Errorf("diskstore: export seq %d: no blocks", seq)
+	}
+	torchType, ok := lmcacheDTypes[normalizeDType(dtype)]
+	if !ok {
+		return 0, fmt.Errorf("diskstore: export seq %d: dtype %q has no vLLM equivalent", seq, dtype)
+	}
+	n := s.storedPrefix(seq, layers)
+	if n == 0 {
+		return 0, fmt.Errorf("diskstore: export seq %d: no stored prefix with tokens and every layer's keys and values", seq)
+	}
+
+	idx := LMCacheIndex{
+		Version:     lmcacheIndexVersion,
+		Seq:         seq,
+		ExportedAt:  time.Now(),
+		Format:      "vllm",
+		Model:       opts.Model,
+		WorldSize:   max(opts.WorldSize, 1),
+		WorkerID:    opts.WorkerID,
+		ChunkSize:   opts.ChunkSize,
+		DType:       torchType,
+		Fingerprint: s.fingerprint,
+	}
+	if idx.Model == "" && s.fingerprint != nil {
+		idx.Model = s.fingerprint.ModelDigest
+	}
+	if idx.Model == "" {
+		return 0, fmt.Errorf("diskstore: export seq %d: no model name and no fingerprint", seq)
+	}
+	if idx.ChunkSize <= 0 {
+		idx.ChunkSize = 256
+	}
+
+	data, _, err := s.ReadRange(ManifestKey(BlockKey{Seq: seq, EndPos: n}))
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+	}
+	tokens := DecodeTokens(data)
+
+	// Every layer's rows, keys then values: the 2 and L of KV_2LTD.
+	halves := make([][]byte, 0, 2*layers)
+	for _, isKey := range []bool{true, false} {
+		for layer := range layers {
+			rows, end, err := s.ReadRange(BlockKey{Seq: seq, Layer: layer, IsKey: isKey, EndPos: n})
+			if err == nil && end < n {
+				err = fmt.Errorf("layer %d: stored positions end at %d", layer, end)
+			}
+			if err != nil {
+				return 0, fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+			}
+			halves = append(halves, rows)
+		}
+	}
+	rowSize := len(halves[0]) / int(n)
+	elSize := llamaTypes[normalizeDType(dtype)].size
+	idx.KVShape = []int{2, layers, int(idx.ChunkSize), rowSize / elSize}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return 0, fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+	}
+	var hash string
+	for begin := int32(0); begin < n; begin += idx.ChunkSize {
+		end := min(n, begin+idx.ChunkSize)
+		hash = ChainPrefixHash(hash, tokens[begin:end])
+		chunk := make([]byte, 0, len(halves)*int(end-begin)*rowSize)
+		for _, rows := range halves {
+			chunk = append(chunk, rows[int(begin)*rowSize:int(end)*rowSize]...)
+		}
+		key := fmt.Sprintf("%s@%s@%d@%d@%s", idx.Format, idx.Model, idx.WorldSize, idx.WorkerID, hash)
+		name := strings.ReplaceAll(key, "/", "-") + ".pt"
+		if err := os.WriteFile(filepath.Join(dir, name), chunk, 0644); err != nil {
+			return len(idx.Chunks), fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+		}
+		idx.Chunks = append(idx.Chunks, LMCacheChunk{
+			File:     name,
+			Hash:     hash,
+			BeginPos: begin,
+			EndPos:   end,
+			Tokens:   tokens[begin:end],
+		})
+	}
+
+	// The index goes last, so a directory with one lists complete chunks.
+	out, _ := json.MarshalIndent(idx, "", "  ")
+	if err := os.WriteFile(filepath.Join(dir, LMCacheIndexFile), out, 0644); err != nil {
+		return len(idx.Chunks), fmt.Errorf("diskstore: export seq %d: %w", seq, err)
+	}
+	s.log.Info("exported LMCache chunks", "seq", seq, "positions", n, "chunks", len(idx.Chunks), "dir", dir)
+	return len(idx.Chunks), nil
+}
@@ -0,0 +1,70 @@
+package diskstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestExportLMCache(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	rows := putLlamaSeq(t, store, 1, 10)
+
+	out := filepath.Join(dir, "lmcache")
+	opts := LMCacheOptions{Model: "org/model", ChunkSize: 4}
+	if n, err := store.ExportLMCache(1, out, opts); err != nil || n != 3 {
+		t.Fatalf("ExportLMCache = %d, %v; want 3 chunks", n, err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(out, LMCacheIndexFile))
+	if err != nil {
+		t.Fatalf("reading index: %v", err)
+	}
+	var idx LMCacheIndex
+	if err := json.Unmarshal(data, &idx); err != nil {
+		t.Fatalf("index: %v", err)
+	}
+	if idx.DType != "float16" || len(idx.KVShape) != 4 || idx.KVShape[1] != 2 || idx.KVShape[3] != 4 || len(idx.Chunks) != 3 {
+		t.Fatalf("index = %+v", idx)
+	}
+
+	var hash string
+	for i, c := range idx.Chunks {
+		begin, end := int32(i*4), min(10, int32(i*4+4))
+		hash = ChainPrefixHash(hash, c.Tokens)
+		if c.BeginPos != begin || c.EndPos != end || c.Tokens[0] != 100+begin || c.Hash != hash {
+			t.Errorf("chunk %d = %+v", i, c)
+		}
+		if want := "vllm@org-model@1@0@" + hash + ".pt"; c.File != want {
+			t.Errorf("chunk %d file %q, want %q", i, c.File, want)
+		}
+		// KV_2LTD: keys of each layer, then values of each layer.
+		var want []byte
+		for _, isKey := range []bool{true, false} {
+			for layer := 0; layer < 2; layer++ {
+				want = append(want, rows[BlockKey{Seq: 1, Layer: layer, IsKey: isKey, EndPos: 10}][begin*8:end*8]...)
+			}
+		}
+		if got, err := os.ReadFile(filepath.Join(out, c.File)); err != nil || !bytes.Equal(got, want) {
+			t.Errorf("chunk %d holds %d bytes, %v; want %d in KV_2LTD order", i, len(got), err, len(want))
+		}
+	}
+
+	if _, err := store.ExportLMCache(1, out, LMCacheOptions{}); err == nil {
+		t.Error("export without a model name succeeded")
+	}
+	if _, err := store.ExportLMCache(2, out, opts); err == nil {
+		t.Error("export of an empty sequence succeeded")
+	}
+}
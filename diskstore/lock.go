@@ -0,0 +1,172 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Multi-process safety: a Store keeps its index in memory and writes it
+// back on Close, so two processes writing one local tier directory would
+// overwrite each other's index. New takes an exclusive flock on a lock
+// file in the directory, and a second writer, in this process or another,
+// fails with ErrLocked. The kernel drops the lock when its holder exits,
+// however it exits. On Windows LockFileEx plays flock's part.
+//
+// flock doesn't reach across hosts on every network filesystem, and some
+// refuse it, so the lock file also holds a lease: the holder's host, pid
+// and an expiry it renews every leaseRenew. A lease held from another
+// host, or from a live process where flock isn't available, keeps other
+// writers out until it expires. A lease whose holder is gone (expired, or
+// its pid no longer running on this host) is taken over.
+//
+// With Config.ReadOnly a store takes no lock and writes nothing. It
+// serves the index as last saved, which a writer does when it closes, so
+// kvstorectl can look at a directory a runner has open. Methods that
+// would change the store return ErrReadOnly, or do nothing where they
+// return no error.
+
+// ErrLocked is returned by New when another writer has the local tier
+// directory open.
+var ErrLocked = errors.New("diskstore: local tier is open in another store")
+
+// ErrReadOnly is returned for changes to a store opened with
+// Config.ReadOnly.
+var ErrReadOnly = errors.New("diskstore: store is read-only")
+
+// errLockHeld and errLockUnsupported are flockFile's answers when it
+// doesn't get the lock.
+var (
+	errLockHeld        = errors.New("lock held")
+	errLockUnsupported = errors.New("locks not supported")
+)
+
+const (
+	lockFile   = ".kvtier-lock"
+	leaseTTL   = 30 * time.Second
+	leaseRenew = 10 * time.Second
+)
+
+// lease records who holds a directory's lock.
+type lease struct {
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Expires time.Time `json:"expires"`
+}
+
+func (l lease) String() string {
+	return fmt.Sprintf("pid %d on %s until %s", l.PID, l.Host, l.Expires.Format(time.RFC3339))
+}
+
+// holds reports whether the lease l keeps me out. With the flock taken, a
+// holder on this host is known to be gone: it would have kept the lock.
+func (l lease) holds(me lease, flocked bool) bool {
+	if l.PID == 0 || time.Now().After(l.Expires) {
+		return false
+	}
+	if l.Host != me.Host {
+		return true
+	}
+	return !flocked && processAlive(l.PID)
+}
+
+// dirLock is a writer's hold on a local tier directory.
+type dirLock struct {
+	f     *os.File
+	lease lease
+}
+
+// lockDir takes dir's lock and lease for a writer.
+func lockDir(dir string) (*dirLock, error) {
+	f, err := os.OpenFile(filepath.Join(dir, lockFile), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: open lock: %w", err)
+	}
+	flocked := true
+	switch err := flockFile(f); {
+	case errors.Is(err, errLockHeld):
+		defer f.Close()
+		if held, err := readLease(f); err == nil {
+			return nil, fmt.Errorf("%w: %s is held by %s", ErrLocked, dir, held)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrLocked, dir)
+	case errors.Is(err, errLockUnsupported):
+		flocked = false
+	case err != nil:
+		f.Close()
+		return nil, fmt.Errorf("diskstore: lock %s: %w", dir, err)
+	}
+
+	host, _ := os.Hostname()
+	l := &dirLock{f: f, lease: lease{Host: host, PID: os.Getpid()}}
+	if held, err := readLease(f); err == nil && held.holds(l.lease, flocked) {
+		l.release()
+		return nil, fmt.Errorf("%w: %s is leased by %s", ErrLocked, dir, held)
+	}
+	if err := l.renew(); err != nil {
+		l.release()
+		return nil, fmt.Errorf("diskstore: write lease: %w", err)
+	}
+	return l, nil
+}
+
+// readLease reads the lease in the lock file f.
+func readLease(f *os.File) (lease, error) {
+	var l lease
+	data, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<16))
+	if err != nil {
+		return l, err
+	}
+	err = json.Unmarshal(data, &l)
+	return l, err
+}
+
+// renew extends the lease by leaseTTL.
+func (l *dirLock) renew() error {
+	l.lease.Expires = time.Now().Add(leaseTTL)
+	data, err := json.Marshal(l.lease)
+	if err != nil {
+		return err
+	}
+	if err := l.f.Truncate(0); err != nil {
+		return err
+	}
+	_, err = l.f.WriteAt(data, 0)
+	return err
+}
+
+// release clears the lease and drops the lock. The file stays: removing
+// it would race with another process opening it to lock.
+func (l *dirLock) release() {
+	l.f.Truncate(0)
+	unflockFile(l.f)
+	l.f.Close()
+}
+
+func (s *Store) leaseLoop() {
+	defer s.wg.Done()
+	t := time.NewTicker(leaseRenew)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			if err := s.lock.renew(); err != nil {
+				s.log.Warn("renew lease", "error", err)
+			}
+		}
+	}
+}
+
+// writable returns ErrReadOnly if the store was opened read-only.
+func (s *Store) writable() error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	return nil
+}
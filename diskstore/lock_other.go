@@ -0,0 +1,13 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly || windows)
+
+package diskstore
+
+import "os"
+
+// Without flock the lease alone guards the directory.
+func flockFile(f *os.File) error { return errLockUnsupported }
+
+func unflockFile(f *os.File) {}
+
+// processAlive can't tell here, so a lease lasts until it expires.
+func processAlive(pid int) bool { return true }
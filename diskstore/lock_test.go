@@ -0,0 +1,77 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestLocking(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{LocalPath: dir, LocalBudget: 1024 * 1024, StatsInterval: -1}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+
+	if _, err := New(cfg); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second writer: err %v, want ErrLocked", err)
+	}
+
+	// A reader opens alongside the writer, but sees only what the writer
+	// has saved.
+	ro := cfg
+	ro.ReadOnly = true
+	reader, err := New(ro)
+	if err != nil {
+		t.Fatalf("read-only New: %v", err)
+	}
+	if reader.Has(key) {
+		t.Error("reader sees an unsaved block")
+	}
+	reader.Close()
+	store.Close()
+
+	reader, err = New(ro)
+	if err != nil {
+		t.Fatalf("read-only New: %v", err)
+	}
+	defer reader.Close()
+	if !reader.Has(key) {
+		t.Error("reader doesn't see the saved block")
+	}
+	if err := reader.Put(key, "f16", []int{128}, make([]byte, 64)); !errors.Is(err, ErrReadOnly) {
+		t.Errorf("read-only Put: err %v, want ErrReadOnly", err)
+	}
+	if n := reader.RemoveSeq(1); n != 0 || !reader.Has(key) {
+		t.Errorf("read-only RemoveSeq removed %d blocks", n)
+	}
+
+	host, _ := os.Hostname()
+	for _, tc := range []struct {
+		name   string
+		lease  lease
+		locked bool
+	}{
+		{"live lease from another host", lease{"elsewhere", 1, time.Now().Add(time.Minute)}, true},
+		{"expired lease from another host", lease{"elsewhere", 1, time.Now().Add(-time.Second)}, false},
+		{"lease of a process gone from this host", lease{host, os.Getpid(), time.Now().Add(time.Minute)}, false},
+	} {
+		data, _ := json.Marshal(tc.lease)
+		if err := os.WriteFile(filepath.Join(dir, lockFile), data, 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := New(cfg)
+		if got := errors.Is(err, ErrLocked); got != tc.locked {
+			t.Errorf("%s: err %v, locked %v, want %v", tc.name, err, got, tc.locked)
+		}
+		if err == nil {
+			s.Close()
+		}
+	}
+}
@@ -0,0 +1,68 @@
+//go:build windows
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32         = syscall.NewLazyDLL("kernel32.dll")
+	procLockFileEx   = kernel32.NewProc("LockFileEx")
+	procUnlockFileEx = kernel32.NewProc("UnlockFileEx")
+)
+
+const (
+	lockfileFailImmediately = 0x1
+	lockfileExclusiveLock   = 0x2
+
+	errorNotSupported syscall.Errno = 50
+
+	processQueryLimitedInformation = 0x1000
+	stillActive                    = 259
+)
+
+// lockOffsetHigh places the locked byte far past the lease: Windows
+// locks are mandatory, and one over the lease would keep other
+// processes from reading who holds the directory.
+const lockOffsetHigh = 0x7fffffff
+
+// flockFile takes an exclusive lock on f without waiting.
+func flockFile(f *os.File) error {
+	var ol syscall.Overlapped
+	ol.OffsetHigh = lockOffsetHigh
+	r, _, err := procLockFileEx.Call(f.Fd(), lockfileExclusiveLock|lockfileFailImmediately, 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+	switch {
+	case r != 0:
+		return nil
+	case errors.Is(err, errorLockViolation):
+		return errLockHeld
+	case errors.Is(err, errorNotSupported), errors.Is(err, syscall.EWINDOWS):
+		return errLockUnsupported
+	}
+	return err
+}
+
+func unflockFile(f *os.File) {
+	var ol syscall.Overlapped
+	ol.OffsetHigh = lockOffsetHigh
+	procUnlockFileEx.Call(f.Fd(), 0, 1, 0, uintptr(unsafe.Pointer(&ol)))
+}
+
+// processAlive reports whether pid is running on this host.
+func processAlive(pid int) bool {
+	h, err := syscall.OpenProcess(processQueryLimitedInformation, false, uint32(pid))
+	if err != nil {
+		// Running as another user, or gone.
+		return errors.Is(err, syscall.ERROR_ACCESS_DENIED)
+	}
+	defer syscall.CloseHandle(h)
+	var code uint32
+	if err := syscall.GetExitCodeProcess(h, &code); err != nil {
+		return true
+	}
+	return code == stillActive
+}
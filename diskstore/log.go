@@ -0,0 +1,39 @@
+package diskstore
+
+import (
+	"context"
+	"log/slog"
+)
+
+// newLogger returns the logger a Store writes to. A nil base falls back to
+// slog.Default(); a non-nil level drops records below it regardless of how
+// the base handler is configured, so diskstore can be quieter (or, with a
+// permissive base handler, noisier) than the host process.
+func newLogger(base *slog.Logger, level slog.Leveler) *slog.Logger {
+	if base == nil {
+		base = slog.Default()
+	}
+	if level != nil {
+		base = slog.New(&levelHandler{level: level, Handler: base.Handler()})
+	}
+	return base.With("component", "diskstore")
+}
+
+// levelHandler filters records below a minimum level before handing them
+// to the wrapped handler.
+type levelHandler struct {
+	level slog.Leveler
+	slog.Handler
+}
+
+func (h *levelHandler) Enabled(ctx context.Context, l slog.Level) bool {
+	return l >= h.level.Level() && h.Handler.Enabled(ctx, l)
+}
+
+func (h *levelHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *levelHandler) WithGroup(name string) slog.Handler {
+	return &levelHandler{level: h.level, Handler: h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,37 @@
+package diskstore
+
+import (
+	"bytes"
+	"log/slog"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoggerLevel(t *testing.T) {
+	var buf bytes.Buffer
+	base := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 10, // every Put overruns the budget
+		Logger:      base,
+		LogLevel:    slog.LevelWarn,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+
+	out := buf.String()
+	if !strings.Contains(out, "local budget exceeded") {
+		t.Errorf("expected budget warning, got %q", out)
+	}
+	if strings.Contains(out, "level=DEBUG") {
+		t.Errorf("debug records not filtered: %q", out)
+	}
+}
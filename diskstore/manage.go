@@ -0,0 +1,336 @@
+package diskstore
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// Blocks returns metadata for every stored block of a sequence, ordered by
+// layer, key/value and position.
+func (s *Store) Blocks(seq int) []BlockMeta {
+	return slices.Collect(s.Iter(BlockFilter{Seqs: []int{seq}}))
+}
+
+// AllBlocks returns metadata for every stored block, ordered like Blocks.
+func (s *Store) AllBlocks() []BlockMeta {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	results := make([]BlockMeta, 0, len(s.index))
+	for _, meta := range s.index {
+		results = append(results, *meta)
+	}
+	sortBlocks(results)
+	return results
+}
+
+func sortBlocks(blocks []BlockMeta) {
+	sort.Slice(blocks, func(i, j int) bool {
+		a, b := blocks[i].Key, blocks[j].Key
+		if a.Seq != b.Seq {
+			return a.Seq < b.Seq
+		}
+		if a.Layer != b.Layer {
+			return a.Layer < b.Layer
+		}
+		if a.IsKey != b.IsKey {
+			return a.IsKey
+		}
+		return a.BeginPos < b.BeginPos
+	})
+}
+
+// Budgets returns the current local and remote byte budgets.
+func (s *Store) Budgets() (local, remote int64) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.localBudget, s.remoteBudget
+}
+
+// BudgetResult reports how SetBudgets brought usage under new budgets.
+type BudgetResult struct {
+	// Moved are local blocks migrated to the remote tier.
+	Moved int `json:"moved"`
+	// Dropped are blocks deleted because no tier had room for them.
+	Dropped int `json:"dropped"`
+}
+
+// SetBudgets changes the tier budgets on a running store and rebalances
+// to fit them. Blocks beyond the remote budget are deleted, then local
+// blocks beyond the local budget migrate to the remote tier while it has
+// room, and those left over are deleted too, in eviction order. Pinned
+// blocks are kept. While the store is degraded the local tier is left
+// over budget until the re-sync.
+func (s *Store) SetBudgets(local, remote int64) BudgetResult {
+	if s.readOnly {
+		return BudgetResult{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.applyBudgets(local, remote)
+}
+
+// applyBudgets is SetBudgets with s.mu held.
+func (s *Store) applyBudgets(local, remote int64) BudgetResult {
+	s.localBudget = local
+	s.remoteBudget = remote
+
+	var res BudgetResult
+	for s.remoteUsed > s.remoteBudget && s.dropOldest("remote") {
+		res.Dropped++
+	}
+	for s.localUsed > s.localBudget {
+		n := s.evictLocal(nil, s.localUsed-s.localBudget)
+		if n == 0 {
+			break
+		}
+		res.Moved += n
+	}
+	for s.localUsed > s.localBudget && !s.Degraded() && s.dropOldest("local") {
+		res.Dropped++
+	}
+	s.log.Info("budgets changed", "local", local, "remote", remote,
+		"local_used", s.localUsed, "remote_used", s.remoteUsed,
+		"moved", res.Moved, "dropped", res.Dropped)
+	return res
+}
+
+// dropOldest deletes the unpinned block on tier that would be evicted
+// first. Must be called with s.mu held.
+func (s *Store) dropOldest(tier string) bool {
+	var oldest *BlockMeta
+	for _, meta := range s.index {
+		if meta.Tier == tier && !meta.Pinned && (oldest == nil || s.evictsBefore(meta, oldest)) {
+			oldest = meta
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	if err := s.removePayload(oldest); err != nil {
+		s.log.Warn("drop block over budget", "key", oldest.Key, "tier", tier, "error", err)
+		return false
+	}
+	s.releaseUsage(oldest, int64(storedSize(oldest)))
+	delete(s.index, oldest.Key.String())
+	s.indexChanged(oldest.Key)
+	s.emit(EventRemove, oldest)
+	if !s.hasSeq(oldest.Key.Seq) {
+		s.markExpired(oldest.Key.Seq)
+	}
+	return true
+}
+
+// Pin keeps every current and future block of seq on the local tier.
+// Pinned blocks are skipped when making room on the local tier, and with
+// Config.Replicate they are copied to the remote tier (see replicate.go).
+func (s *Store) Pin(seq int) int {
+	return s.setPinned(seq, true)
+}
+
+// Unpin reverses Pin.
+func (s *Store) Unpin(seq int) int {
+	return s.setPinned(seq, false)
+}
+
+// Pinned reports whether seq is pinned.
+func (s *Store) Pinned(seq int) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.pinned[seq]
+}
+
+func (s *Store) setPinned(seq int, pinned bool) int {
+	if s.readOnly {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if pinned {
+		s.pinned[seq] = true
+	} else {
+		delete(s.pinned, seq)
+	}
+	var n int
+	for _, meta := range s.index {
+		if meta.Key.Seq == seq {
+			meta.Pinned = pinned
+			s.indexChanged(meta.Key)
+			n++
+		}
+	}
+	if pinned && s.replicate {
+		s.replicateSeq(seq)
+	}
+	return n
+}
+
+// GCResult summarises a garbage-collection pass.
+type GCResult struct {
+	// MissingBlocks are index entries dropped because their file is gone.
+	MissingBlocks int `json:"missing_blocks"`
+	// FromReplicas are local blocks whose file is gone that now live on
+	// their remote replica (see replicate.go).
+	FromReplicas int `json:"from_replicas,omitempty"`
+	// OrphanFiles are block files on disk with no index entry, deleted.
+	OrphanFiles int `json:"orphan_files"`
+	// FreedBytes is the size of the deleted orphan files.
+	FreedBytes int64 `json:"freed_bytes"`
+}
+
+// GC reconciles the index with the tier directories: entries whose block
+// file has disappeared are dropped, and block files the index doesn't know
+// about (e.g. left by a crash before the index was saved) are deleted.
+// A replicated block whose local file has disappeared falls back to its
+// remote replica instead of being dropped.
+func (s *Store) GC() (GCResult, error) {
+	if err := s.writable(); err != nil {
+		return GCResult{}, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var res GCResult
+	touched := make(map[int]bool)
+	known := make(map[string]bool, len(s.index))
+	for k, meta := range s.index {
+		if meta.Replica && s.remotePath != "" {
+			known[s.blockPath(meta.Key, "remote", 0)] = true
+		}
+		if s.onService(meta.Tier) {
+			continue // the block service reconciles its own directories
+		}
+		path := s.metaPath(meta)
+		if meta.Bundle != nil {
+			path = filepath.Join(s.remotePath, bundleDir, meta.Bundle.File)
+		}
+		if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+			if meta.Replica && s.hasReplica(meta) {
+				s.dropLocalCopy(meta, false)
+				res.FromReplicas++
+				continue
+			}
+			s.releaseUsage(meta, int64(storedSize(meta)))
+			s.releaseBundled(meta)
+			delete(s.index, k)
+			s.indexChanged(meta.Key)
+			s.emit(EventGC, meta)
+			touched[meta.Key.Seq] = true
+			res.MissingBlocks++
+			continue
+		}
+		known[path] = true
+	}
+	for seq := range touched {
+		if !s.hasSeq(seq) {
+			s.markExpired(seq)
+		}
+	}
+
+	bases := append([]string{s.remotePath}, s.localBases()...)
+	if !slices.Contains(bases, s.localPath) {
+		bases = append(bases, s.localPath) // blocks left from before striping
+	}
+	for _, base := range bases {
+		if base == "" || (base == s.remotePath && s.Degraded()) {
+			continue
+		}
+		err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if d.IsDir() && path == filepath.Join(base, modelsDir) {
+				// Per-model namespaces are separate stores.
+				return filepath.SkipDir
+			}
+			if d.IsDir() || !strings.HasSuffix(path, ".kvblk") || known[path] {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil
+			}
+			if err := os.Remove(path); err != nil {
+				s.log.Warn("gc: remove orphan", "path", path, "error", err)
+				return nil
+			}
+			res.OrphanFiles++
+			res.FreedBytes += info.Size()
+			return nil
+		})
+		if err != nil {
+			return res, err
+		}
+	}
+
+	s.log.Info("gc complete", "missing", res.MissingBlocks, "from_replicas", res.FromReplicas, "orphans", res.OrphanFiles, "freed", res.FreedBytes)
+	return res, nil
+}
+
+// VerifyResult reports the outcome of a checksum pass over the store.
+type VerifyResult struct {
+	Checked int        `json:"checked"`
+	Missing []BlockKey `json:"missing,omitempty"`
+	Corrupt []BlockKey `json:"corrupt,omitempty"`
+}
+
+// Verify reads every stored payload and checks it against its recorded
+// checksum and size. It reports problems without repairing them; Scrub
+// reports them in more detail and can repair them.
+func (s *Store) Verify() VerifyResult {
+	rep := s.Scrub(ScrubOptions{})
+	res := VerifyResult{Checked: rep.Checked}
+	for _, p := range rep.Problems {
+		if p.Kind == ScrubMissing {
+			res.Missing = append(res.Missing, p.Key)
+		} else {
+			res.Corrupt = append(res.Corrupt, p.Key)
+		}
+	}
+	return res
+}
+
+// Migrate moves the blocks of seq (or of every sequence if seq < 0) to
+// tier, stopping with an error when the destination budget is exhausted.
+// It returns the number of blocks moved.
+func (s *Store) Migrate(seq int, tier string) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	if tier != "local" && tier != "remote" {
+		return 0, fmt.Errorf("diskstore: unknown tier %q", tier)
+	}
+	if tier == "remote" && !s.hasRemote() {
+		return 0, fmt.Errorf("diskstore: no remote tier configured")
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var moved int
+	for _, meta := range s.index {
+		if meta.Tier == tier || (seq >= 0 && meta.Key.Seq != seq) {
+			continue
+		}
+		used, budget := s.localUsed, s.localBudget
+		if tier == "remote" {
+			used, budget = s.remoteUsed, s.remoteBudget
+		}
+		if used+int64(storedSize(meta)) > budget {
+			return moved, fmt.Errorf("%w: %s tier full after %d blocks", ErrBudgetExceeded, tier, moved)
+		}
+		if _, err := s.moveBlock(meta, tier); err != nil {
+			return moved, fmt.Errorf("diskstore: migrate %s: %w", meta.Key, err)
+		}
+		moved++
+	}
+	s.log.Info("migrated blocks", "seq", seq, "tier", tier, "blocks", moved)
+	return moved, nil
+}
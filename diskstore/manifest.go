@@ -0,0 +1,42 @@
+package diskstore
+
+import "encoding/binary"
+
+// Token manifests: a snapshot can store, next to a run of KV blocks, the
+// tokens at the same positions as a block of layer ManifestLayer holding
+// one little-endian int32 per position. Manifest blocks are keyed and
+// tiered like any other block, so ReadRange reads them across block sizes,
+// and a restore can check the stored rows were computed for the prompt it
+// resumes.
+
+const (
+	// ManifestLayer is the layer of token manifest blocks.
+	ManifestLayer = -1
+
+	// ManifestDType is the dtype recorded for token manifest blocks.
+	ManifestDType = "i32"
+)
+
+// ManifestKey returns the key of the token manifest for key's positions.
+func ManifestKey(key BlockKey) BlockKey {
+	key.Layer, key.IsKey = ManifestLayer, false
+	return key
+}
+
+// EncodeTokens returns the payload of a token manifest block.
+func EncodeTokens(tokens []int32) []byte {
+	b := make([]byte, 4*len(tokens))
+	for i, t := range tokens {
+		binary.LittleEndian.PutUint32(b[4*i:], uint32(t))
+	}
+	return b
+}
+
+// DecodeTokens returns the tokens of a token manifest payload.
+func DecodeTokens(b []byte) []int32 {
+	tokens := make([]int32, len(b)/4)
+	for i := range tokens {
+		tokens[i] = int32(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+	return tokens
+}
@@ -0,0 +1,40 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestTokenManifest(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+		Fingerprint: &Fingerprint{ModelDigest: "llama", NLayers: 4, HeadDim: 8, DType: "f16"},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	tokens := []int32{1, -2, 300, 1 << 20, 5, 6}
+	if got := DecodeTokens(EncodeTokens(tokens)); !slices.Equal(got, tokens) {
+		t.Fatalf("DecodeTokens(EncodeTokens(%v)) = %v", tokens, got)
+	}
+
+	// Two runs of different sizes, in spite of the f16 fingerprint.
+	for _, r := range []struct{ begin, end int32 }{{0, 4}, {4, 6}} {
+		key := ManifestKey(BlockKey{Seq: 1, Layer: 2, BeginPos: r.begin, EndPos: r.end, IsKey: true})
+		if err := store.Put(key, ManifestDType, nil, EncodeTokens(tokens[r.begin:r.end])); err != nil {
+			t.Fatalf("Put %d-%d: %v", r.begin, r.end, err)
+		}
+	}
+	data, end, err := store.ReadRange(ManifestKey(BlockKey{Seq: 1, BeginPos: 2, EndPos: 8}))
+	if err != nil || end != 6 {
+		t.Fatalf("ReadRange = %d, %v; want end 6", end, err)
+	}
+	if got := DecodeTokens(data); !slices.Equal(got, tokens[2:]) {
+		t.Errorf("read tokens %v, want %v", got, tokens[2:])
+	}
+}
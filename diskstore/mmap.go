@@ -0,0 +1,63 @@
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"sync/atomic"
+)
+
+// Mapped reads: with Config.MmapReads, reads of local-tier blocks that a
+// caller decodes into its own buffer (GetInto, and ReadRange for
+// restores) map the block file instead of reading it. The rows are copied
+// from the page cache straight into the caller's buffer, saving the read
+// syscalls and the copy into an intermediate buffer. Only blocks stored
+// without transforms qualify, since anything else must be decoded from a
+// copy anyway. Where mapping is not possible (other platforms, or
+// filesystems such as some FUSE mounts that refuse it) the read falls
+// back to the normal path, and after mmapFailLimit failures in a row the
+// store stops trying.
+
+const mmapFailLimit = 8
+
+var errEmptyMapping = errors.New("diskstore: cannot map an empty file")
+
+// mmapState tracks whether mapped reads are on and still working.
+type mmapState struct {
+	on    bool
+	fails atomic.Int32
+}
+
+func (m *mmapState) enabled() bool {
+	return m.on && m.fails.Load() < mmapFailLimit
+}
+
+// readMapped copies meta's data into dst[:0] through a mapping of its
+// file and reports whether it could. A false return leaves the read to
+// the normal path, which also repairs a copy that fails its checksum.
+func (s *Store) readMapped(m *BlockMeta, dst []byte) ([]byte, bool) {
+	if dst == nil || !s.mmap.enabled() {
+		return nil, false
+	}
+	s.mu.RLock()
+	meta := *m
+	s.mu.RUnlock()
+	if meta.Tier != "local" || meta.Bundle != nil || len(meta.Transforms) > 0 || meta.Compressed {
+		return nil, false
+	}
+	data, unmap, err := mapFile(s.metaPath(&meta))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, errEmptyMapping) {
+			return nil, false
+		}
+		if s.mmap.fails.Add(1) == mmapFailLimit {
+			s.log.Warn("mapped reads failing, reading blocks instead", "error", err)
+		}
+		return nil, false
+	}
+	defer unmap()
+	s.mmap.fails.Store(0)
+	if !s.verify(&meta, data) {
+		return nil, false
+	}
+	return append(dst[:0], data...), true
+}
@@ -0,0 +1,11 @@
+//go:build !(linux || darwin || freebsd || netbsd || openbsd || dragonfly)
+
+package diskstore
+
+import "errors"
+
+const mmapAvailable = false
+
+func mapFile(path string) ([]byte, func(), error) {
+	return nil, nil, errors.New("diskstore: mmap not supported on this platform")
+}
@@ -0,0 +1,58 @@
+package diskstore
+
+import (
+	"bytes"
+	"os"
+	"testing"
+)
+
+func TestMmapReads(t *testing.T) {
+	if !mmapAvailable {
+		t.Skip("no mmap on this platform")
+	}
+	store, err := New(Config{LocalPath: t.TempDir(), LocalBudget: 1 << 20, MmapReads: true, StatsInterval: -1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 16, IsKey: true}
+	data := bytes.Repeat([]byte("rowdata!"), 16)
+	if err := store.Put(key, "f16", []int{8}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	meta := store.index[key.String()]
+	if got, ok := store.readMapped(meta, []byte{}); !ok || !bytes.Equal(got, data) {
+		t.Fatalf("readMapped = %q, %v", got, ok)
+	}
+	got, _, err := store.GetInto(key, nil)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("GetInto = %q, %v", got, err)
+	}
+	rows, end, err := store.ReadRange(BlockKey{Seq: 1, BeginPos: 4, EndPos: 8, IsKey: true})
+	if err != nil || end != 8 || !bytes.Equal(rows, data[32:64]) {
+		t.Errorf("ReadRange = %q, %d, %v", rows, end, err)
+	}
+
+	// A copy failing its checksum is left to the normal path, which
+	// reports it.
+	path := store.blockPath(key, "local", 0)
+	if err := os.WriteFile(path, bytes.Repeat([]byte("x"), len(data)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.readMapped(meta, []byte{}); ok {
+		t.Error("readMapped accepted a corrupt block")
+	}
+	if _, _, err := store.GetInto(key, nil); err == nil {
+		t.Error("GetInto of a corrupt block succeeded")
+	}
+
+	// Missing files don't count as mapping failures.
+	os.Remove(path)
+	for range mmapFailLimit {
+		store.readMapped(meta, []byte{})
+	}
+	if !store.mmap.enabled() {
+		t.Error("mapped reads turned off by missing files")
+	}
+}
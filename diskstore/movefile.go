@@ -0,0 +1,176 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// File moves: a block moved between two directory tiers on the same
+// filesystem is renamed, which moves no data at all. Across filesystems
+// it is copied file to file in chunks, checked against the block's
+// checksum as it is read, instead of being read into memory whole and
+// written back. Moves into a local tier with direct I/O, out of bundles
+// and to or from block services still go through memory.
+//
+// Streamed moves: with Config.StreamMoves, io.Copy between the files lets
+// the kernel copy (copy_file_range, or sendfile/splice) where the platform
+// supports it, after trying a reflink (FICLONE on Linux), so an eviction
+// storm moves gigabytes without holding or touching them in user space.
+// The copy is then read back through CRC-32C and checked against the
+// block's checksum before the source is removed.
+
+// moveChunk is the size of the reads and writes of a chunked copy.
+const moveChunk = 1 << 20
+
+// fileMove reports whether meta moves to dst file to file (see above).
+func (s *Store) fileMove(meta *BlockMeta, dst string) bool {
+	if meta.Bundle != nil || s.onService(meta.Tier) || s.onService(dst) {
+		return false
+	}
+	return s.streamMoves || s.renameMoves.Load() || dst != "local" || !s.directIO.Load()
+}
+
+// renamePayload moves meta's file to dst, in stripe if it is the local
+// tier, by renaming it and returns its
+// size, or -1 if the tiers turn out not to share a filesystem after all
+// (a bind mount), after which moves copy.
+func (s *Store) renamePayload(meta *BlockMeta, dst string, stripe int) (int64, error) {
+	from, to := s.metaPath(meta), s.blockPath(meta.Key, dst, stripe)
+	n, err := remoteCall(context.Background(), s, func() (int64, error) {
+		info, err := os.Stat(from)
+		if err != nil {
+			return 0, err
+		}
+		if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+			return 0, err
+		}
+		if err := renameFile(from, to); crossDevice(err) {
+			return -1, nil
+		} else if err != nil {
+			return 0, err
+		}
+		return info.Size(), nil
+	})
+	if n < 0 {
+		s.log.Info("tiers are on different filesystems, copying moved blocks", "local", s.localPath, "remote", s.remotePath)
+		s.renameMoves.Store(false)
+	}
+	return n, err
+}
+
+// copyPayload copies meta's file on its tier to dst, in stripe if it is
+// the local tier, and returns its size.
+// One of the tiers is the remote one, so the copy is retried as remote
+// tier I/O (see remoteio.go). Must be called with s.mu held.
+func (s *Store) copyPayload(meta *BlockMeta, dst string, stripe int) (int64, error) {
+	s.throttle(meta.Tier, migrationIO, storedSize(meta))
+	s.throttle(dst, migrationIO, storedSize(meta))
+	from, to, sum, stream := s.metaPath(meta), s.blockPath(meta.Key, dst, stripe), meta.Checksum, s.streamMoves
+	return remoteCall(context.Background(), s, func() (int64, error) { return copyBlockFile(from, to, sum, stream) })
+}
+
+// copyBlockFile copies the block file from to the path to, checking it
+// against the payload checksum sum: streamed, by reading the copy back,
+// else in chunks as they are read.
+func copyBlockFile(from, path string, sum uint32, stream bool) (int64, error) {
+	src, err := os.Open(from)
+	if err != nil {
+		return 0, err
+	}
+	defer src.Close()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	var n int64
+	if stream {
+		n, err = copyFile(tmp, src)
+		if err == nil {
+			err = verifyFile(tmp, sum)
+		}
+	} else {
+		n, err = copyChunked(tmp, src, sum)
+	}
+	if err == nil {
+		err = renameFile(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return n, nil
+}
+
+// copyFile writes src to a new file at path, as a reflink where the
+// filesystem allows.
+func copyFile(path string, src *os.File) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	var n int64
+	if err = cloneFile(f, src); err == nil {
+		var info os.FileInfo
+		if info, err = src.Stat(); err == nil {
+			n = info.Size()
+		}
+	} else {
+		n, err = io.Copy(f, src)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return n, err
+}
+
+// copyChunked writes src to a new file at path moveChunk bytes at a time,
+// checking what it read against the payload checksum sum.
+func copyChunked(path string, src *os.File, sum uint32) (int64, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+	h := crc32.New(castagnoli)
+	buf := getBuf(moveChunk)
+	// The reader and writer are wrapped so io.CopyBuffer uses buf and not
+	// the files' own copy paths.
+	n, err := io.CopyBuffer(struct{ io.Writer }{f}, io.TeeReader(src, h), buf)
+	putBuf(buf)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil && sum != 0 && h.Sum32() != sum {
+		err = fmt.Errorf("%s: %w", src.Name(), errMoveVerify)
+	}
+	return n, err
+}
+
+// errMoveVerify reports a moved copy that does not match its checksum.
+var errMoveVerify = errors.New("copy does not match checksum")
+
+// verifyFile checks the file at path against a payload checksum; zero
+// (blocks stored before checksums) accepts any content.
+func verifyFile(path string, sum uint32) error {
+	if sum == 0 {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := crc32.New(castagnoli)
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if h.Sum32() != sum {
+		return fmt.Errorf("%s: %w", path, errMoveVerify)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStreamMoves(t *testing.T) {
+	for _, stream := range []bool{true, false} {
+		t.Run(fmt.Sprintf("stream=%v", stream), func(t *testing.T) { testCopyMoves(t, stream) })
+	}
+}
+
+// testCopyMoves checks moves copying blocks between filesystems.
+func testCopyMoves(t *testing.T, stream bool) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StreamMoves:   stream,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	store.renameMoves.Store(false)
+
+	payload := func(seq int) []byte { return bytes.Repeat([]byte{byte(seq)}, 4096) }
+	for seq := 1; seq <= 2; seq++ {
+		key := BlockKey{Seq: seq, EndPos: 16, IsKey: true}
+		if err := store.Put(key, "f16", []int{128}, payload(seq)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	if n, err := store.Migrate(1, "remote"); err != nil || n != 1 {
+		t.Fatalf("Migrate(1) = %d, %v", n, err)
+	}
+	key := BlockKey{Seq: 1, EndPos: 16, IsKey: true}
+	data, meta, err := store.Get(key)
+	if err != nil || meta.Tier != "remote" || !bytes.Equal(data, payload(1)) {
+		t.Fatalf("Get after move: tier %v, %d bytes, %v", meta, len(data), err)
+	}
+	if _, err := os.Stat(store.blockPath(key, "local", 0)); !os.IsNotExist(err) {
+		t.Errorf("source left behind: %v", err)
+	}
+	if st := store.Stats(); st.LocalUsed != 4096 || st.RemoteUsed != 4096 {
+		t.Errorf("usage local %d remote %d, want 4096 each", st.LocalUsed, st.RemoteUsed)
+	}
+
+	// A copy of a corrupt file fails verification and keeps the source.
+	bad := BlockKey{Seq: 2, EndPos: 16, IsKey: true}
+	if err := os.WriteFile(store.blockPath(bad, "local", 0), make([]byte, 4096), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Migrate(2, "remote"); !errors.Is(err, errMoveVerify) {
+		t.Errorf("Migrate of a corrupt block: %v, want errMoveVerify", err)
+	}
+	if _, err := os.Stat(store.blockPath(bad, "local", 0)); err != nil {
+		t.Errorf("corrupt source removed: %v", err)
+	}
+	if _, err := os.Stat(store.blockPath(bad, "remote", 0) + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("temporary copy left behind: %v", err)
+	}
+	if blocks := store.Blocks(2); len(blocks) != 1 || blocks[0].Tier != "local" {
+		t.Errorf("corrupt block after failed move: %+v", blocks)
+	}
+}
+
+func TestRenameMoves(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	if !store.renameMoves.Load() {
+		t.Skip("local and remote tier not on one filesystem")
+	}
+
+	key := BlockKey{Seq: 1, EndPos: 16, IsKey: true}
+	payload := bytes.Repeat([]byte{1}, 4096)
+	if err := store.Put(key, "f16", []int{128}, payload); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	before, err := os.Stat(store.blockPath(key, "local", 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tier := range []string{"remote", "local"} {
+		if n, err := store.Migrate(1, tier); err != nil || n != 1 {
+			t.Fatalf("Migrate(%s) = %d, %v", tier, n, err)
+		}
+		after, err := os.Stat(store.blockPath(key, tier, 0))
+		if err != nil || !os.SameFile(before, after) {
+			t.Errorf("block on %s is not the file stored: %v", tier, err)
+		}
+		if _, err := os.Stat(store.blockPath(key, otherTier(tier), 0)); !os.IsNotExist(err) {
+			t.Errorf("source left behind on %s: %v", otherTier(tier), err)
+		}
+	}
+	data, meta, err := store.Get(key)
+	if err != nil || meta.Tier != "local" || !bytes.Equal(data, payload) {
+		t.Fatalf("Get after moves: tier %v, %v", meta, err)
+	}
+	if st := store.Stats(); st.LocalUsed != 4096 || st.RemoteUsed != 0 {
+		t.Errorf("usage local %d remote %d, want 4096 and 0", st.LocalUsed, st.RemoteUsed)
+	}
+}
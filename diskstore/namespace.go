@@ -0,0 +1,175 @@
+package diskstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// nsDir holds the blocks of named namespaces under each tier:
+// <tier>/ns/<namespace>/<shard>/<key>.kvblk. Blocks in the default
+// (empty) namespace keep the flat <tier>/<shard>/ layout.
+const nsDir = "ns"
+
+var validNamespace = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// windowsDevice matches the names Windows keeps for devices, in any case
+// and with any extension, which can't name a directory there. They and
+// names ending in a dot, which Windows strips, are refused everywhere so
+// a store's directories can be copied between platforms.
+var windowsDevice = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\..*)?$`)
+
+// NamespaceConfig sets per-namespace limits for a store shared by several
+// models. Zero budgets mean the namespace is only bound by the store-wide
+// budgets.
+type NamespaceConfig struct {
+	LocalBudget  int64
+	RemoteBudget int64
+
+	// Fingerprint is recorded in the namespace directory and checked like
+	// Config.Fingerprint, but only for blocks of this namespace.
+	Fingerprint *Fingerprint
+
+	// Profile names a built-in policy profile (see Profiles).
+	Profile string
+}
+
+// NamespaceStats reports usage for one namespace.
+type NamespaceStats struct {
+	LocalBlocks  int    `json:"local_blocks"`
+	RemoteBlocks int    `json:"remote_blocks"`
+	LocalUsed    int64  `json:"local_used"`
+	RemoteUsed   int64  `json:"remote_used"`
+	LocalBudget  int64  `json:"local_budget,omitempty"`
+	RemoteBudget int64  `json:"remote_budget,omitempty"`
+	Profile      string `json:"profile,omitempty"`
+}
+
+// nsUsage is the byte usage of one namespace per tier.
+type nsUsage struct {
+	local, remote int64
+}
+
+func checkNamespace(ns string) error {
+	if ns != "" && (!validNamespace.MatchString(ns) || windowsDevice.MatchString(ns) || strings.HasSuffix(ns, ".")) {
+		return fmt.Errorf("diskstore: invalid namespace %q", ns)
+	}
+	return nil
+}
+
+// nsPath returns the directory holding a namespace's blocks on a tier
+// base path.
+func nsPath(base, ns string) string {
+	if ns == "" {
+		return base
+	}
+	return filepath.Join(base, nsDir, ns)
+}
+
+// addUsage adjusts the store-wide, per-namespace and, on a striped
+// local tier, per-stripe usage of a tier by n bytes of meta's. Must be
+// called with s.mu held.
+func (s *Store) addUsage(meta *BlockMeta, tier string, n int64) {
+	ns := meta.Key.Namespace
+	u := s.nsUsed[ns]
+	if u == nil {
+		u = &nsUsage{}
+		s.nsUsed[ns] = u
+	}
+	if tier == "local" {
+		s.localUsed += n
+		u.local += n
+		if meta.Stripe < len(s.stripes) {
+			s.stripes[meta.Stripe].used += n
+		}
+	} else {
+		s.remoteUsed += n
+		u.remote += n
+	}
+}
+
+// nsOverLocal reports whether adding n bytes to ns's local usage would
+// exceed its own budget. Must be called with s.mu held.
+func (s *Store) nsOverLocal(ns string, n int64) bool {
+	cfg, ok := s.namespaces[ns]
+	if !ok || cfg.LocalBudget <= 0 {
+		return false
+	}
+	u := s.nsUsed[ns]
+	return u != nil && u.local+n > cfg.LocalBudget
+}
+
+// nsFitsRemote reports whether ns can take n more bytes on the remote
+// tier. Must be called with s.mu held.
+func (s *Store) nsFitsRemote(ns string, n int64) bool {
+	cfg, ok := s.namespaces[ns]
+	if !ok || cfg.RemoteBudget <= 0 {
+		return true
+	}
+	u := s.nsUsed[ns]
+	return u == nil || u.remote+n <= cfg.RemoteBudget
+}
+
+// fingerprintFor returns the fingerprint blocks of ns are checked against.
+func (s *Store) fingerprintFor(ns string) *Fingerprint {
+	if f, ok := s.nsFingerprints[ns]; ok {
+		return f
+	}
+	return s.fingerprint
+}
+
+// Namespaces lists the namespaces that hold blocks or are configured,
+// sorted by name. The default namespace is reported as "".
+func (s *Store) Namespaces() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	seen := make(map[string]bool)
+	for _, meta := range s.index {
+		seen[meta.Key.Namespace] = true
+	}
+	for ns := range s.namespaces {
+		seen[ns] = true
+	}
+	out := make([]string, 0, len(seen))
+	for ns := range seen {
+		out = append(out, ns)
+	}
+	sort.Strings(out)
+	return out
+}
+
+// namespaceStats computes per-namespace usage. Must be called with s.mu
+// held.
+func (s *Store) namespaceStats() map[string]NamespaceStats {
+	out := make(map[string]NamespaceStats)
+	for _, meta := range s.index {
+		st := out[meta.Key.Namespace]
+		if meta.Tier == "local" {
+			st.LocalBlocks++
+		} else {
+			st.RemoteBlocks++
+		}
+		out[meta.Key.Namespace] = st
+	}
+	for ns, cfg := range s.namespaces {
+		st := out[ns]
+		st.LocalBudget = cfg.LocalBudget
+		st.RemoteBudget = cfg.RemoteBudget
+		out[ns] = st
+	}
+	for ns, p := range s.profiles {
+		st := out[ns]
+		st.Profile = p.Name
+		out[ns] = st
+	}
+	for ns, st := range out {
+		if u := s.nsUsed[ns]; u != nil {
+			st.LocalUsed, st.RemoteUsed = u.local, u.remote
+		}
+		out[ns] = st
+	}
+	return out
+}
@@ -0,0 +1,126 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNamespaces(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1024 * 1024,
+		RemoteBudget: 1024 * 1024,
+		Namespaces: map[string]NamespaceConfig{
+			"small": {LocalBudget: 3000},
+			"qwen":  {Fingerprint: &Fingerprint{ModelDigest: "qwen", HeadDim: 128, DType: "f16"}},
+		},
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	// The same seq/layer/pos in two namespaces are different blocks.
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	qk, lk := key, key
+	qk.Namespace, lk.Namespace = "qwen", "llama"
+	if err := store.Put(qk, "f16", []int{128}, []byte("qwen")); err != nil {
+		t.Fatalf("Put qwen: %v", err)
+	}
+	if err := store.Put(lk, "f16", []int{64}, []byte("llama")); err != nil {
+		t.Fatalf("Put llama: %v", err)
+	}
+	if got, _, _ := store.Get(qk); !bytes.Equal(got, []byte("qwen")) {
+		t.Errorf("Get qwen: got %q", got)
+	}
+	if got, _, _ := store.Get(lk); !bytes.Equal(got, []byte("llama")) {
+		t.Errorf("Get llama: got %q", got)
+	}
+	if got, _, err := store.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("default namespace sees %q, %v", got, err)
+	}
+
+	// Only qwen's namespace is fingerprinted.
+	if err := store.Put(qk, "f16", []int{64}, []byte("bad")); !errors.Is(err, ErrFingerprintMismatch) {
+		t.Errorf("Put mismatched qwen block: got %v", err)
+	}
+	bad := key
+	bad.Namespace = "../escape"
+	if err := store.Put(bad, "f16", []int{64}, []byte("x")); err == nil {
+		t.Error("Put with invalid namespace succeeded")
+	}
+
+	// "small" spills to remote on its own budget; the others stay local.
+	for i := 0; i < 3; i++ {
+		k := BlockKey{Namespace: "small", Seq: 0, BeginPos: int32(i), EndPos: int32(i + 1), IsKey: true}
+		if err := store.Put(k, "f16", []int{64}, make([]byte, 1500)); err != nil {
+			t.Fatalf("Put small %d: %v", i, err)
+		}
+	}
+	st := store.Stats()
+	small := st.Namespaces["small"]
+	if small.LocalUsed > 3000 || small.RemoteBlocks == 0 || small.LocalBudget != 3000 {
+		t.Errorf("small: got %+v", small)
+	}
+	if q := st.Namespaces["qwen"]; q.LocalBlocks != 1 || q.RemoteBlocks != 0 {
+		t.Errorf("qwen: got %+v", q)
+	}
+	if got := store.Namespaces(); len(got) != 3 {
+		t.Errorf("Namespaces: got %v", got)
+	}
+	store.Close()
+
+	// Usage is rebuilt per namespace on reopen.
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if got := store.Stats().Namespaces["small"]; got != small {
+		t.Errorf("small after reopen: got %+v, want %+v", got, small)
+	}
+}
+
+func TestGetNamespaceRange(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{LocalPath: filepath.Join(dir, "local"), LocalBudget: 1 << 20, StatsInterval: -1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	keys := []BlockKey{
+		{Seq: 0, EndPos: 4, IsKey: true},
+		{Namespace: "qwen", Seq: 0, EndPos: 4, IsKey: true},
+		PrefixKey("", PrefixHash([]int32{1, 2, 3, 4}), 0, 0, 4, true),
+	}
+	for _, key := range keys {
+		if err := store.Put(key, "f16", []int{8}, make([]byte, 32)); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	if r := store.GetRange(0, 0, true, 0, 4); len(r) != 1 || r[0].Key != keys[0] {
+		t.Errorf("GetRange = %+v, want only the default namespace's block", r)
+	}
+	if r := store.GetNamespaceRange("qwen", 0, 0, true, 0, 4); len(r) != 1 || r[0].Key != keys[1] {
+		t.Errorf("GetNamespaceRange(qwen) = %+v, want only qwen's block", r)
+	}
+	if r := store.GetRange(PrefixSeq, 0, true, 0, 4); len(r) != 0 {
+		t.Errorf("GetRange(PrefixSeq) = %+v, want no prefix-addressed blocks", r)
+	}
+
+	srv := httptest.NewServer(store.BlockServiceHandler())
+	defer srv.Close()
+	c := NewRemoteClient(srv.URL, time.Second)
+	if r := c.GetRange(0, 0, true, 0, 4); len(r) != 1 || r[0].Key != keys[0] {
+		t.Errorf("remote GetRange = %+v, want only the default namespace's block", r)
+	}
+	if r := c.GetNamespaceRange("qwen", 0, 0, true, 0, 4); len(r) != 1 || r[0].Key != keys[1] {
+		t.Errorf("remote GetNamespaceRange(qwen) = %+v, want only qwen's block", r)
+	}
+}
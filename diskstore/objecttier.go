@@ -0,0 +1,71 @@
+package diskstore
+
+import (
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+)
+
+// Object store tiers: GCSTier and AzureTier keep the remote tier's blocks
+// in a Google Cloud Storage bucket or an Azure Blob Storage container,
+// talking to their REST APIs directly. Like RemoteClient they implement
+// Tier, so the store's timeouts, retries and circuit breaker (see
+// remoteio.go) and its migration workers (see evictpool.go) apply to them
+// as to a block service. Each block is one object, named like its file on
+// a directory tier under an optional prefix, with its dtype and shape in
+// the object's metadata. OpenTierURL (see tier.go) opens one, or a
+// RedisTier, from a URL.
+
+const (
+	// Object metadata names, valid for both services.
+	objectMetaDType = "kvdtype"
+	objectMetaShape = "kvshape"
+
+	// objectProbe is the object Ping asks for: found or not, the answer
+	// shows the store is reachable with the tier's credentials.
+	objectProbe = ".kvping"
+)
+
+// objectName is the name of key's object under prefix.
+func objectName(prefix string, key BlockKey) string {
+	name := path.Join(key.shard(ShardSeq), key.fileName()+".kvblk")
+	if key.Namespace != "" {
+		name = path.Join(nsDir, key.Namespace, name)
+	}
+	return path.Join(prefix, name)
+}
+
+// escapeObjectPath escapes the segments of an object path for a URL.
+func escapeObjectPath(p string) string {
+	segs := strings.Split(p, "/")
+	for i, seg := range segs {
+		segs[i] = url.PathEscape(seg)
+	}
+	return strings.Join(segs, "/")
+}
+
+// objectBlock returns the metadata of key's block read from an object,
+// as Tier.Get does.
+func objectBlock(key BlockKey, h http.Header, metaPrefix string, data []byte) (*BlockMeta, error) {
+	shape, err := parseShape(h.Get(metaPrefix + objectMetaShape))
+	if err != nil {
+		return nil, err
+	}
+	return &BlockMeta{
+		Key:       key,
+		DTypeStr:  h.Get(metaPrefix + objectMetaDType),
+		Shape:     shape,
+		SizeBytes: len(data),
+		Tier:      "remote",
+	}, nil
+}
+
+// newObjectClient returns the HTTP client of an object store tier.
+func newObjectClient(timeout time.Duration) *http.Client {
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &http.Client{Timeout: timeout}
+}
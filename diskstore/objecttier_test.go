@@ -0,0 +1,177 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeObjects is an in-memory object store answering PUT, GET, HEAD and
+// DELETE on any path, keeping the headers starting with metaPrefix.
+type fakeObjects struct {
+	metaPrefix string
+	auth       func(*http.Request) bool
+
+	mu       sync.Mutex
+	objects  map[string][]byte
+	meta     map[string]http.Header
+	puts     int
+	failPuts int // PUTs still to answer 503
+}
+
+func newFakeObjects(t *testing.T, metaPrefix string, auth func(*http.Request) bool) (*fakeObjects, *httptest.Server) {
+	f := &fakeObjects{
+		metaPrefix: metaPrefix,
+		auth:       auth,
+		objects:    make(map[string][]byte),
+		meta:       make(map[string]http.Header),
+	}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeObjects) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !f.auth(r) {
+		http.Error(w, "bad credentials", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := r.URL.Path
+	switch r.Method {
+	case http.MethodPut:
+		if f.failPuts > 0 {
+			f.failPuts--
+			http.Error(w, "slow down", http.StatusServiceUnavailable)
+			return
+		}
+		data, _ := io.ReadAll(r.Body)
+		meta := http.Header{}
+		for k, v := range r.Header {
+			if strings.HasPrefix(k, f.metaPrefix) {
+				meta[k] = v
+			}
+		}
+		f.objects[name], f.meta[name] = data, meta
+		f.puts++
+		w.WriteHeader(http.StatusCreated)
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.objects[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		for k, v := range f.meta[name] {
+			w.Header()[k] = v
+		}
+		w.Write(data)
+	case http.MethodDelete:
+		if _, ok := f.objects[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.objects, name)
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func (f *fakeObjects) count() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.objects)
+}
+
+// testServiceTier runs a remote tier service against its fake server.
+func testServiceTier(t *testing.T, tier Tier) {
+	t.Helper()
+	if p, ok := tier.(Pinger); !ok {
+		t.Error("not a Pinger")
+	} else if err := p.Ping(context.Background()); err != nil {
+		t.Errorf("Ping: %v", err)
+	}
+
+	key := BlockKey{Namespace: "qwen", Seq: 2, Layer: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	data := []byte("some kv bytes")
+	if err := tier.Put(key, "f16", []int{128, 8, 4}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !tier.Has(key) {
+		t.Fatal("Has: false after Put")
+	}
+	got, meta, err := tier.Get(key)
+	if err != nil || string(got) != string(data) {
+		t.Fatalf("Get: got %q, %v", got, err)
+	}
+	if meta.Key != key || meta.DTypeStr != "f16" || len(meta.Shape) != 3 || meta.Shape[0] != 128 {
+		t.Errorf("Get meta: got %+v", meta)
+	}
+	if err := tier.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if _, _, err := tier.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: %v; want ErrNotFound", err)
+	}
+	if tier.Has(key) {
+		t.Error("Has: true after Delete")
+	}
+	if err := tier.Delete(key); err != nil {
+		t.Errorf("Delete of a missing block: %v", err)
+	}
+}
+
+func TestObjectName(t *testing.T) {
+	key := BlockKey{Namespace: "qwen", Seq: 258, Layer: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	if got, want := objectName("kv/a", key), "kv/a/ns/qwen/02/seq258_L1_k_p0-4.kvblk"; got != want {
+		t.Errorf("objectName = %q, want %q", got, want)
+	}
+	key.Namespace = ""
+	if got, want := objectName("", key), "02/seq258_L1_k_p0-4.kvblk"; got != want {
+		t.Errorf("objectName = %q, want %q", got, want)
+	}
+}
+
+func TestOpenTierURL(t *testing.T) {
+	t.Setenv("GOOGLE_APPLICATION_CREDENTIALS", "")
+	t.Setenv("AZURE_STORAGE_KEY", "a2V5")
+	t.Setenv("AZURE_STORAGE_SAS_TOKEN", "")
+
+	tier, err := OpenTierURL("gs://bucket/kv/node1", 0)
+	if err != nil {
+		t.Fatalf("gs: %v", err)
+	}
+	if g := tier.(*GCSTier); g.base != gcsEndpoint+"/bucket" || g.prefix != "kv/node1" {
+		t.Errorf("gs: base %q, prefix %q", g.base, g.prefix)
+	}
+	tier, err = OpenTierURL("azblob://acct/cache/kv", 0)
+	if err != nil {
+		t.Fatalf("azblob: %v", err)
+	}
+	if a := tier.(*AzureTier); a.base != "https://acct.blob.core.windows.net/cache" || a.prefix != "kv" {
+		t.Errorf("azblob: base %q, prefix %q", a.base, a.prefix)
+	}
+	tier, err = OpenTierURL("redis://user:pw@cache:6380/3?prefix=node1:", 0)
+	if err != nil {
+		t.Fatalf("redis: %v", err)
+	}
+	if r := tier.(*RedisTier); r.cfg.Addr != "cache:6380" || r.cfg.Username != "user" || r.cfg.Password != "pw" || r.cfg.DB != 3 || r.cfg.Prefix != "node1:" {
+		t.Errorf("redis: %+v", r.cfg)
+	}
+	tier, err = OpenTierURL("davs://kv:pw@nas:5006/dav/kv", 0)
+	if err != nil {
+		t.Fatalf("dav: %v", err)
+	}
+	if d := tier.(*WebDAVTier); d.base != "https://nas:5006/dav/kv" || d.username != "kv" || d.password != "pw" {
+		t.Errorf("davs: base %q, user %q, password %q", d.base, d.username, d.password)
+	}
+	for _, bad := range []string{"s3://bucket", "gs:///prefix", "azblob://acct", "redis://cache/x"} {
+		if _, err := OpenTierURL(bad, 0); err == nil {
+			t.Errorf("OpenTierURL(%q) succeeded", bad)
+		}
+	}
+}
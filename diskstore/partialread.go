@@ -0,0 +1,168 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Partial reads: a range wanting only some rows of a block on a remote
+// tier service that is a RangeReader (WebDAVTier) fetches just those
+// rows, if the block is stored as is, with no transform, so that its
+// rows lie at fixed offsets of its payload. The block's checksum covers
+// the whole payload and is not verified for such a read. One that fails
+// falls back to reading the whole block as Get does, which repairs a
+// lost block (see repair.go).
+
+// partialRead is the part of a block ReadRanges fetches.
+type partialRead struct {
+	block BlockMeta // the whole block
+	part  BlockMeta // its rows wanted, as a block of their own
+	data  []byte
+	err   error
+}
+
+// partialReads maps the entries of ReadRanges plans narrowed to the
+// rows they use to the part of the block they stand for.
+type partialReads map[*BlockMeta]*partialRead
+
+// readsPartly reports whether meta's block can be read in part.
+func (s *Store) readsPartly(meta *BlockMeta) bool {
+	_, ok := s.remote.(RangeReader)
+	rows := int(meta.Key.EndPos - meta.Key.BeginPos)
+	return ok && s.onService(meta.Tier) && meta.Bundle == nil && !meta.Compressed &&
+		len(meta.Transforms) == 0 && meta.Codec == "" && rows > 0 && meta.SizeBytes%rows == 0
+}
+
+// planPartial narrows the entries of plans, made for keys, for blocks
+// the ranges use only part of and that can be read in part, to the
+// positions they use.
+func (s *Store) planPartial(keys []BlockKey, plans [][]BlockMeta) partialReads {
+	if _, ok := s.remote.(RangeReader); !ok {
+		return nil
+	}
+	// The positions each block is used for, across the ranges.
+	type window struct{ begin, end int32 }
+	windows := make(map[string]window)
+	for i, key := range keys {
+		pos := key.BeginPos
+		for _, meta := range plans[i] {
+			end := min(meta.Key.EndPos, key.EndPos)
+			k := meta.Key.String()
+			if w, ok := windows[k]; ok {
+				windows[k] = window{min(w.begin, pos), max(w.end, end)}
+			} else {
+				windows[k] = window{pos, end}
+			}
+			pos = end
+		}
+	}
+
+	parts := make(partialReads)
+	byBlock := make(map[string]*partialRead)
+	for i := range plans {
+		for j := range plans[i] {
+			meta := &plans[i][j]
+			k := meta.Key.String()
+			w := windows[k]
+			if w.begin == meta.Key.BeginPos && w.end == meta.Key.EndPos || !s.readsPartly(meta) {
+				continue
+			}
+			p, ok := byBlock[k]
+			if !ok {
+				rowSize := meta.SizeBytes / int(meta.Key.EndPos-meta.Key.BeginPos)
+				p = &partialRead{block: *meta, part: *meta}
+				p.part.Key.BeginPos, p.part.Key.EndPos = w.begin, w.end
+				p.part.SizeBytes = rowSize * int(w.end-w.begin)
+				p.part.StoredBytes = p.part.SizeBytes
+				byBlock[k] = p
+			}
+			*meta = p.part
+			parts[meta] = p
+		}
+	}
+	return parts
+}
+
+// fetch reads the parts, on the decode workers if there are any.
+func (parts partialReads) fetch(ctx context.Context, s *Store) {
+	seen := make(map[*partialRead]bool)
+	var jobs []func()
+	for _, p := range parts {
+		if !seen[p] {
+			seen[p] = true
+			jobs = append(jobs, func() { p.data, p.err = s.readPart(ctx, p) })
+		}
+	}
+	if s.decodeJobs == nil {
+		for _, job := range jobs {
+			job()
+		}
+		return
+	}
+	s.decodeAll(jobs)
+}
+
+// wrap returns read, reading the narrowed entries of plans from their
+// fetched parts.
+func (parts partialReads) wrap(read func(meta *BlockMeta) ([]byte, error)) func(meta *BlockMeta) ([]byte, error) {
+	if len(parts) == 0 {
+		return read
+	}
+	return func(meta *BlockMeta) ([]byte, error) {
+		if p, ok := parts[meta]; ok {
+			return p.data, p.err
+		}
+		return read(meta)
+	}
+}
+
+// readPart reads p's rows of its block, or returns nil if the block was
+// removed since the index scan.
+func (s *Store) readPart(ctx context.Context, p *partialRead) ([]byte, error) {
+	block := &p.block
+	if s.pastTTL(block, time.Now()) {
+		return nil, nil
+	}
+	if err := s.fingerprintFor(block.Key.Namespace).checkBlock(block.Key, block.DTypeStr, block.Shape); err != nil {
+		return nil, err
+	}
+	rowSize := block.SizeBytes / int(block.Key.EndPos-block.Key.BeginPos)
+	off := int(p.part.Key.BeginPos-block.Key.BeginPos) * rowSize
+	n := p.part.SizeBytes
+
+	start := time.Now()
+	err := s.throttleContext(ctx, block.Tier, restoreIO, n)
+	var data []byte
+	if err == nil {
+		rr := s.remote.(RangeReader)
+		data, err = remoteCall(ctx, s, func() ([]byte, error) {
+			data, err := rr.GetRange(ctx, block.Key, off, n)
+			if errors.Is(err, ErrNotFound) {
+				err = fmt.Errorf("remote tier: %w", os.ErrNotExist)
+			}
+			return data, err
+		})
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("diskstore: read block %s: %w", block.Key, err)
+		}
+		s.log.Debug("partial read failed, reading the whole block", "key", block.Key, "error", err)
+		whole, _, err := s.get(ctx, block.Key, false, nil)
+		if errors.Is(err, ErrNotFound) {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if off+n > len(whole) {
+			return nil, fmt.Errorf("diskstore: block %s: %d bytes, want %d", block.Key, len(whole), block.SizeBytes)
+		}
+		return whole[off : off+n], nil
+	}
+	s.countRead(block, n, time.Since(start))
+	return data, nil
+}
@@ -0,0 +1,147 @@
+package diskstore
+
+import (
+	"fmt"
+	"time"
+)
+
+// Placement rules: Config.Placement overrides where the budgets alone
+// would put a block. The first rule matching a block names its tier:
+//
+//   - "remote": a Put writes the block straight to the remote tier, if
+//     it has room, instead of the local one; a rule with MinAge moves
+//     local blocks there once they have gone unread that long.
+//   - "local": the block is never evicted to make room, like a pinned
+//     one, and a block of it found on the remote tier is moved back
+//     when the local tier has room.
+//
+// Rules with MinAge match no new block, so they take effect through a
+// sweep every placementInterval, which also runs ApplyPlacement's moves.
+
+// placementInterval is how often placement rules are applied in the
+// background.
+const placementInterval = time.Minute
+
+// LayerRange is a range of layers, First through Last.
+type LayerRange struct {
+	First int `json:"first"`
+	Last  int `json:"last"`
+}
+
+// PlacementRule places the blocks matching all of its set conditions
+// on Tier, "local" or "remote".
+type PlacementRule struct {
+	Layers   *LayerRange   `json:"layers,omitempty"`    // blocks of these layers
+	MinBytes int64         `json:"min_bytes,omitempty"` // stored as at least this many bytes
+	MinAge   time.Duration `json:"min_age,omitempty"`   // not read for at least this long
+
+	Tier string `json:"tier"`
+}
+
+// matches reports whether meta, stored as size bytes and not read for
+// age, meets every condition of r.
+func (r *PlacementRule) matches(meta *BlockMeta, size int64, age time.Duration) bool {
+	if l := r.Layers; l != nil && (meta.Key.Layer < l.First || meta.Key.Layer > l.Last) {
+		return false
+	}
+	return size >= r.MinBytes && age >= r.MinAge
+}
+
+// checkPlacement validates placement rules.
+func checkPlacement(rules []PlacementRule) error {
+	for i, r := range rules {
+		if r.Tier != "local" && r.Tier != "remote" {
+			return fmt.Errorf("diskstore: placement rule %d: unknown tier %q", i+1, r.Tier)
+		}
+		if l := r.Layers; l != nil && l.First > l.Last {
+			return fmt.Errorf("diskstore: placement rule %d: empty layer range %d-%d", i+1, l.First, l.Last)
+		}
+	}
+	return nil
+}
+
+// placement returns the tier the first rule matching meta names, or ""
+// if none does.
+func (s *Store) placement(meta *BlockMeta, now time.Time) string {
+	size := int64(storedSize(meta))
+	age := now.Sub(meta.AccessedAt)
+	for i := range s.rules {
+		if s.rules[i].matches(meta, size, age) {
+			return s.rules[i].Tier
+		}
+	}
+	return ""
+}
+
+// keepsLocal reports whether a rule keeps meta on the local tier.
+func (s *Store) keepsLocal(meta *BlockMeta) bool {
+	return len(s.rules) > 0 && s.placement(meta, time.Now()) == "local"
+}
+
+// fitsRemote reports whether the remote tier can take meta now. Must be
+// called with s.mu held.
+func (s *Store) fitsRemote(meta *BlockMeta) bool {
+	size := int64(storedSize(meta))
+	return s.hasRemote() && !s.Degraded() &&
+		s.remoteUsed+size <= s.remoteBudget && s.nsFitsRemote(meta.Key.Namespace, size)
+}
+
+// ApplyPlacement moves the blocks that placement rules put on the other
+// tier: local blocks a rule sends to the remote tier, as far as it has
+// room, and remote blocks a rule keeps local, as far as the local tier
+// has room without evicting. It returns how many blocks moved each way.
+func (s *Store) ApplyPlacement() (demoted, promoted int) {
+	if s.readOnly || len(s.rules) == 0 || !s.hasRemote() {
+		return 0, 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, meta := range s.index {
+		if meta.Pinned || meta.Bundle != nil {
+			continue
+		}
+		size := int64(storedSize(meta))
+		switch tier := s.placement(meta, now); {
+		case meta.Tier == "local" && tier == "remote":
+			if !s.fitsRemote(meta) {
+				continue
+			}
+			if _, err := s.moveBlock(meta, "remote"); err != nil {
+				s.log.Warn("place block on remote tier", "key", meta.Key, "error", err)
+				continue
+			}
+			s.evictions++
+			demoted++
+		case meta.Tier == "remote" && tier == "local":
+			if s.Degraded() || s.localUsed+size > s.localBudget || s.nsOverLocal(meta.Key.Namespace, size) {
+				continue
+			}
+			if _, err := s.moveBlock(meta, "local"); err != nil {
+				s.log.Warn("place block on local tier", "key", meta.Key, "error", err)
+				continue
+			}
+			promoted++
+		}
+	}
+	if demoted > 0 || promoted > 0 {
+		s.log.Info("applied placement rules", "demoted", demoted, "promoted", promoted)
+	}
+	return demoted, promoted
+}
+
+// placementLoop runs ApplyPlacement until the store is closed.
+func (s *Store) placementLoop() {
+	defer s.wg.Done()
+	t := time.NewTicker(placementInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.ApplyPlacement()
+		}
+	}
+}
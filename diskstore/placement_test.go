@@ -0,0 +1,89 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPlacementRules(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1000,
+		RemoteBudget: 1024 * 1024,
+		Placement: []PlacementRule{
+			{Layers: &LayerRange{First: 0, Last: 1}, Tier: "local"},
+			{MinBytes: 500, Tier: "remote"},
+			{MinAge: time.Hour, Tier: "remote"},
+		},
+		StatsInterval: -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(layer int, i int32) BlockKey {
+		return BlockKey{Seq: 1, Layer: layer, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	tier := func(k BlockKey) string {
+		store.mu.RLock()
+		defer store.mu.RUnlock()
+		return store.index[k.String()].Tier
+	}
+
+	// Big blocks go straight to the remote tier.
+	if err := store.Put(key(5, 0), "f16", []int{300}, make([]byte, 600)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := tier(key(5, 0)); got != "remote" {
+		t.Errorf("big block on the %s tier", got)
+	}
+	if st := store.Stats(); st.LocalUsed != 0 || st.RemoteUsed != 600 {
+		t.Errorf("LocalUsed = %d, RemoteUsed = %d; want 0, 600", st.LocalUsed, st.RemoteUsed)
+	}
+
+	// Blocks of layers kept local are passed over by eviction, however
+	// old.
+	for _, layer := range []int{0, 3} {
+		for i := range int32(5) {
+			if err := store.Put(key(layer, i), "f16", []int{50}, make([]byte, 100)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+	}
+	if err := store.Put(key(3, 5), "f16", []int{50}, make([]byte, 100)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if got := tier(key(3, 0)); got != "remote" {
+		t.Errorf("oldest evictable block on the %s tier", got)
+	}
+	for i := range int32(5) {
+		if got := tier(key(0, i)); got != "local" {
+			t.Errorf("layer 0 block %d evicted to the %s tier", i, got)
+		}
+	}
+
+	// The sweep sends idle blocks down and brings kept blocks back.
+	store.mu.Lock()
+	store.index[key(3, 1).String()].AccessedAt = time.Now().Add(-2 * time.Hour)
+	if _, err := store.moveBlock(store.index[key(0, 0).String()], "remote"); err != nil {
+		t.Fatalf("moveBlock: %v", err)
+	}
+	store.mu.Unlock()
+	if demoted, promoted := store.ApplyPlacement(); demoted != 1 || promoted != 1 {
+		t.Errorf("ApplyPlacement = %d, %d; want 1, 1", demoted, promoted)
+	}
+	if tier(key(3, 1)) != "remote" || tier(key(0, 0)) != "local" {
+		t.Error("ApplyPlacement didn't move the blocks its rules place")
+	}
+
+	bad := cfg
+	bad.Placement = []PlacementRule{{MinBytes: 1, Tier: "hdd"}}
+	if _, err := New(bad); err == nil {
+		t.Error("New accepted a rule for an unknown tier")
+	}
+}
@@ -0,0 +1,71 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNamespaceWindowsNames(t *testing.T) {
+	for _, ns := range []string{"nul", "CON", "com1", "Lpt9", "aux.log", "model."} {
+		if checkNamespace(ns) == nil {
+			t.Errorf("namespace %q accepted", ns)
+		}
+	}
+	for _, ns := range []string{"console", "nullish", "com", "model.v2", "llama3-8b"} {
+		if err := checkNamespace(ns); err != nil {
+			t.Errorf("namespace %q: %v", ns, err)
+		}
+	}
+}
+
+func TestFileNamesPortable(t *testing.T) {
+	portable := regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+	hash := ChainPrefixHash("", []int32{1, 2, 3})
+	for _, key := range []BlockKey{
+		{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 256, IsKey: true},
+		{Seq: 12345, Layer: 79, BeginPos: 1 << 20, EndPos: 1<<20 + 7},
+		{Seq: -1, Layer: -1, EndPos: 1},
+		PrefixKey("ns", hash, 3, 256, 512, false),
+		{Seq: EncoderSeq, Prefix: hash, Layer: 1, EndPos: 16, IsKey: true},
+	} {
+		names := []string{key.fileName() + ".kvblk"}
+		for _, scheme := range []string{ShardSeq, ShardHash, ShardHash2} {
+			names = append(names, strings.Split(filepath.ToSlash(key.shard(scheme)), "/")...)
+		}
+		for _, name := range names {
+			if !portable.MatchString(name) || windowsDevice.MatchString(name) {
+				t.Errorf("%s: file name %q isn't portable", key, name)
+			}
+		}
+	}
+}
+
+func TestRenameFileReplaces(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "new"), filepath.Join(dir, "old")
+	os.WriteFile(from, []byte("new"), 0644)
+	os.WriteFile(to, []byte("old"), 0644)
+
+	// A reader holding the old file briefly doesn't fail the rename.
+	f, err := os.Open(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		f.Close()
+	}()
+	if err := renameFile(from, to); err != nil {
+		t.Fatalf("renameFile: %v", err)
+	}
+	if data, _ := os.ReadFile(to); string(data) != "new" {
+		t.Errorf("renamed file holds %q", data)
+	}
+	if _, err := os.Stat(from); !os.IsNotExist(err) {
+		t.Errorf("source still there: %v", err)
+	}
+}
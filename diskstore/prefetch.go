@@ -0,0 +1,101 @@
+package diskstore
+
+import "time"
+
+// Prefetch: when a restore is likely, for example because a returning
+// session's prompt starts with a stored prefix, PrefetchRange hands the
+// remote blocks covering the range to a background worker that promotes
+// them to the local tier. The restore then finds them on the fast tier,
+// or at least overlaps its remote reads with the worker's.
+
+// prefetchQueue is how many blocks may wait for promotion; further
+// requests are dropped rather than delay the caller.
+const prefetchQueue = 1024
+
+type prefetchReq struct {
+	key BlockKey
+	at  time.Time
+}
+
+// PrefetchRange queues the remote blocks covering key's positions (in its
+// namespace, sequence, layer and half) for promotion to the local tier and
+// returns how many it queued. It does nothing without a remote tier.
+func (s *Store) PrefetchRange(key BlockKey) int {
+	if s.prefetch == nil {
+		return 0
+	}
+	now := time.Now()
+	var n int
+	for _, meta := range s.rangeCover(key) {
+		if meta.Tier != "remote" {
+			continue
+		}
+		k := meta.Key.String()
+		s.mu.Lock()
+		if s.prefetching[k] {
+			s.mu.Unlock()
+			continue
+		}
+		select {
+		case s.prefetch <- prefetchReq{meta.Key, now}:
+			s.prefetching[k] = true
+			n++
+		default:
+			s.mu.Unlock()
+			return n // queue full
+		}
+		s.mu.Unlock()
+	}
+	if n > 0 {
+		s.log.Debug("prefetching blocks", "key", key, "blocks", n)
+	}
+	return n
+}
+
+// prefetchLoop promotes queued blocks until the store is closed.
+func (s *Store) prefetchLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case req := <-s.prefetch:
+			s.promote(req)
+		}
+	}
+}
+
+// promote moves a queued block to the local tier, making room by evicting
+// local blocks not used since the prefetch was requested. A prefetched
+// block counts as accessed, so the next promotion doesn't evict it.
+func (s *Store) promote(req prefetchReq) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := req.key.String()
+	delete(s.prefetching, k)
+	meta, ok := s.index[k]
+	if !ok || meta.Tier != "remote" || meta.Bundle != nil {
+		return
+	}
+	size, ns := int64(storedSize(meta)), meta.Key.Namespace
+	if size > s.localBudget {
+		return
+	}
+	stale := func(m *BlockMeta) bool { return m.AccessedAt.Before(req.at) }
+	for s.localUsed+size > s.localBudget || s.nsOverLocal(ns, size) {
+		match := stale
+		if s.localUsed+size <= s.localBudget {
+			match = func(m *BlockMeta) bool { return m.Key.Namespace == ns && stale(m) }
+		}
+		if !s.evictOldestLocal(match) {
+			return
+		}
+	}
+	if _, err := s.moveBlock(meta, "local"); err != nil {
+		s.log.Warn("prefetch failed", "key", meta.Key, "error", err)
+		return
+	}
+	meta.AccessedAt = time.Now()
+	s.prefetched++
+}
@@ -0,0 +1,64 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPrefetchRange(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   3 * 100,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	put := func(seq int, begin int32) BlockKey {
+		key := BlockKey{Seq: seq, BeginPos: begin, EndPos: begin + 4, IsKey: true}
+		if err := store.Put(key, "f16", []int{25}, make([]byte, 100)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+		return key
+	}
+	a, b := put(1, 0), put(1, 4)
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	other := put(2, 0)
+	put(2, 4)
+
+	// Two blocks to promote, room for one more: the older of seq 2's
+	// blocks makes way.
+	if n := store.PrefetchRange(BlockKey{Seq: 1, BeginPos: 0, EndPos: 8, IsKey: true}); n != 2 {
+		t.Fatalf("PrefetchRange queued %d blocks, want 2", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Stats().Prefetched < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	tierOf := func(k BlockKey) string {
+		_, meta, err := store.Get(k)
+		if err != nil || meta == nil {
+			t.Fatalf("Get %s: %v", k, err)
+		}
+		return meta.Tier
+	}
+	if tierOf(a) != "local" || tierOf(b) != "local" {
+		t.Errorf("prefetched blocks on %s and %s, want local", tierOf(a), tierOf(b))
+	}
+	if tierOf(other) != "remote" {
+		t.Errorf("oldest local block not evicted for the prefetch")
+	}
+
+	// Blocks already local are not queued again.
+	if n := store.PrefetchRange(BlockKey{Seq: 1, BeginPos: 0, EndPos: 8, IsKey: true}); n != 0 {
+		t.Errorf("PrefetchRange of local blocks queued %d", n)
+	}
+}
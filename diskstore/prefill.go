@@ -0,0 +1,144 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Disaggregated prefill: a node with a large GPU computes the KV cache
+// for a prompt and publishes it under the prompt's PrefixHash; a decode
+// node with the same prompt pulls the blocks over the block service
+// (RemoteClient.PullPrefix) instead of recomputing them.
+
+// ErrNotPublished is returned when no sequence is published under a
+// prefix hash.
+var ErrNotPublished = errors.New("diskstore: prefix not published")
+
+// PrefixHash identifies a prompt prefix by its token IDs. Both sides of a
+// transfer compute it independently, so it must only depend on the tokens.
+func PrefixHash(tokens []int32) string {
+	return ChainPrefixHash("", tokens)
+}
+
+// Publication records that a sequence holds the KV cache of a prefix.
+type Publication struct {
+	Hash        string    `json:"hash"`
+	Seq         int       `json:"seq"`
+	Length      int32     `json:"length"` // positions [0, Length) of Seq
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// Publish offers positions [0, length) of seq to peers under hash. A
+// sequence has at most one publication; publishing it again replaces the
+// old one. Publications follow the sequence through RenameSeq and session
+// parking and end when its blocks are removed. They are not persisted.
+func (s *Store) Publish(hash string, seq int, length int32) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.unpublishSeq(seq)
+	s.published[hash] = &Publication{Hash: hash, Seq: seq, Length: length, PublishedAt: time.Now()}
+	close(s.publishedChanged)
+	s.publishedChanged = make(chan struct{})
+	s.log.Debug("published prefix", "hash", hash, "seq", seq, "length", length)
+}
+
+// Unpublish withdraws a publication.
+func (s *Store) Unpublish(hash string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.published, hash)
+}
+
+// Published returns the publication for hash, if any.
+func (s *Store) Published(hash string) (Publication, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	p, ok := s.published[hash]
+	if !ok {
+		return Publication{}, false
+	}
+	return *p, true
+}
+
+// WaitPublished returns the publication for hash, waiting for it to
+// appear until ctx is done. That lets a decode node subscribe before the
+// prefill node has finished.
+func (s *Store) WaitPublished(ctx context.Context, hash string) (Publication, error) {
+	for {
+		s.mu.RLock()
+		p, ok := s.published[hash]
+		changed := s.publishedChanged
+		s.mu.RUnlock()
+		if ok {
+			return *p, nil
+		}
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return Publication{}, fmt.Errorf("%w: %s", ErrNotPublished, hash)
+		}
+	}
+}
+
+// SetExportHook registers fn to run before a published prefix is
+// exported. The runner uses it to snapshot positions that are still only
+// in GPU memory; fn is called without the store lock held.
+func (s *Store) SetExportHook(fn func(seq int, length int32) error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exportHook = fn
+}
+
+// ExportPrefix writes the blocks published under hash in the ExportSeq
+// archive format. Blocks starting at or past the published length are
+// left out.
+func (s *Store) ExportPrefix(hash string, w io.Writer) (int, error) {
+	s.mu.RLock()
+	p, ok := s.published[hash]
+	var pub Publication
+	if ok {
+		pub = *p
+	}
+	hook := s.exportHook
+	s.mu.RUnlock()
+	if !ok {
+		return 0, fmt.Errorf("%w: %s", ErrNotPublished, hash)
+	}
+
+	if hook != nil {
+		if err := hook(pub.Seq, pub.Length); err != nil {
+			return 0, fmt.Errorf("diskstore: export prefix %s: %w", hash, err)
+		}
+	}
+
+	var blocks []BlockMeta
+	for _, meta := range s.Blocks(pub.Seq) {
+		if meta.Key.BeginPos < pub.Length {
+			blocks = append(blocks, meta)
+		}
+	}
+	return s.exportBlocks(pub.Seq, blocks, w)
+}
+
+// unpublishSeq drops seq's publication. Must be called with s.mu held.
+func (s *Store) unpublishSeq(seq int) {
+	for hash, p := range s.published {
+		if p.Seq == seq {
+			delete(s.published, hash)
+		}
+	}
+}
+
+// movePublications follows a renamed sequence. Must be called with s.mu
+// held.
+func (s *Store) movePublications(from, to int) {
+	for _, p := range s.published {
+		if p.Seq == from {
+			p.Seq = to
+		}
+	}
+}
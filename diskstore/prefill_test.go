@@ -0,0 +1,92 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPullPrefix(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	prefill, err := New(Config{LocalPath: filepath.Join(dir, "prefill"), LocalBudget: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer prefill.Close()
+	decode, err := New(Config{LocalPath: filepath.Join(dir, "decode"), LocalBudget: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer decode.Close()
+
+	srv := httptest.NewServer(prefill.BlockServiceHandler())
+	defer srv.Close()
+	c := NewRemoteClient(srv.URL, 5*time.Second)
+
+	tokens := []int32{1, 15043, 3186, 29889, 13, 13, 2}
+	hash := PrefixHash(tokens)
+	if hash == PrefixHash(tokens[:6]) {
+		t.Fatal("PrefixHash ignores the last token")
+	}
+
+	if _, err := c.PullPrefix(context.Background(), hash, 0, decode, 0); !errors.Is(err, ErrNotPublished) {
+		t.Fatalf("PullPrefix before Publish: %v, want ErrNotPublished", err)
+	}
+
+	// The export hook stands in for the runner snapshotting GPU memory:
+	// the blocks only reach the store once a peer asks for them.
+	payload := func(i int32) []byte { return bytes.Repeat([]byte{byte(i)}, 64) }
+	prefill.SetExportHook(func(seq int, length int32) error {
+		for begin := int32(0); begin < length+4; begin += 4 {
+			key := BlockKey{Seq: seq, Layer: 0, BeginPos: begin, EndPos: begin + 4, IsKey: true}
+			if err := prefill.Put(key, "f16", []int{8}, payload(begin)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// Subscribe before the prefill finishes.
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result)
+	go func() {
+		n, err := c.PullPrefix(context.Background(), hash, 2*time.Second, decode, 5)
+		done <- result{n, err}
+	}()
+	time.Sleep(50 * time.Millisecond)
+	prefill.Publish(hash, 2, 8)
+
+	res := <-done
+	if res.err != nil || res.n != 2 {
+		t.Fatalf("PullPrefix = %d, %v; want 2 blocks", res.n, res.err)
+	}
+	for _, begin := range []int32{0, 4} {
+		got, _, err := decode.Get(BlockKey{Seq: 5, Layer: 0, BeginPos: begin, EndPos: begin + 4, IsKey: true})
+		if err != nil || !bytes.Equal(got, payload(begin)) {
+			t.Errorf("block %d on decode node: %v", begin, err)
+		}
+	}
+	if decode.Has(BlockKey{Seq: 5, Layer: 0, BeginPos: 8, EndPos: 12, IsKey: true}) {
+		t.Error("block past the published length was transferred")
+	}
+
+	// Publications follow renames and end with the sequence.
+	if _, err := prefill.RenameSeq(2, 3); err != nil {
+		t.Fatalf("RenameSeq: %v", err)
+	}
+	if p, ok := prefill.Published(hash); !ok || p.Seq != 3 {
+		t.Errorf("after rename: %+v, %v", p, ok)
+	}
+	prefill.RemoveSeq(3)
+	if _, ok := prefill.Published(hash); ok {
+		t.Error("publication survived RemoveSeq")
+	}
+}
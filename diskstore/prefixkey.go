@@ -0,0 +1,110 @@
+package diskstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// Prefix-hash addressing: instead of (seq, positions), a block can be
+// keyed by the hash of every token up to its end, so any sequence whose
+// prompt starts with the same tokens (system prompt, RAG context) finds
+// it, whatever slot it runs in. Such keys carry the hash in
+// BlockKey.Prefix and PrefixSeq as their sequence, so per-sequence
+// operations (RemoveSeq, sessions, expiry events) leave the shared blocks
+// alone; budgets evict them like any other.
+
+// PrefixSeq is the sequence ID of prefix-addressed blocks.
+const PrefixSeq = -1
+
+// ChainPrefixHash extends the prefix hash prev (empty for the start of
+// the prompt) with tokens. Hashing a prompt block by block gives each
+// block a hash that depends on all tokens before it:
+// ChainPrefixHash("", t) equals PrefixHash(t).
+func ChainPrefixHash(prev string, tokens []int32) string {
+	h := sha256.New()
+	h.Write([]byte(prev))
+	var buf [4]byte
+	for _, t := range tokens {
+		binary.LittleEndian.PutUint32(buf[:], uint32(t))
+		h.Write(buf[:])
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// PrefixHashes returns the chained hash of every complete blockSize-token
+// block of tokens: hashes[i] identifies tokens[:(i+1)*blockSize]. A
+// trailing partial block gets no hash.
+func PrefixHashes(tokens []int32, blockSize int) []string {
+	if blockSize <= 0 {
+		return nil
+	}
+	hashes := make([]string, 0, len(tokens)/blockSize)
+	var prev string
+	for end := blockSize; end <= len(tokens); end += blockSize {
+		prev = ChainPrefixHash(prev, tokens[end-blockSize:end])
+		hashes = append(hashes, prev)
+	}
+	return hashes
+}
+
+// PrefixKey returns the key of the block covering positions [begin, end)
+// of layer whose prefix (every token before end) hashes to hash.
+func PrefixKey(ns, hash string, layer int, begin, end int32, isKey bool) BlockKey {
+	return BlockKey{
+		Namespace: ns,
+		Seq:       PrefixSeq,
+		Prefix:    hash,
+		Layer:     layer,
+		BeginPos:  begin,
+		EndPos:    end,
+		IsKey:     isKey,
+	}
+}
+
+// MatchPrefix returns how many leading hashes have a stored block for
+// layer 0 of ns (keys or values), i.e. how many blockSize-position blocks
+// of the prompt can be restored from prefix-addressed blocks.
+func (s *Store) MatchPrefix(ns string, hashes []string, blockSize int32) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for i, hash := range hashes {
+		begin := int32(i) * blockSize
+		k := PrefixKey(ns, hash, 0, begin, begin+blockSize, true)
+		if _, ok := s.index[k.String()]; ok {
+			continue
+		}
+		k.IsKey = false
+		if _, ok := s.index[k.String()]; !ok {
+			return i
+		}
+	}
+	return len(hashes)
+}
+
+// checkPrefix rejects prefix hashes that are not lowercase hex, which
+// also keeps them safe to use in file names.
+func checkPrefix(prefix string) error {
+	for _, c := range prefix {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return fmt.Errorf("diskstore: invalid prefix hash %q", prefix)
+		}
+	}
+	return nil
+}
+
+// prefixFileName is fileName for prefix-addressed keys and encoder
+// outputs.
+func (k BlockKey) prefixFileName() string {
+	kv := "v"
+	if k.IsKey {
+		kv = "k"
+	}
+	kind := "pfx"
+	if k.Seq == EncoderSeq {
+		kind = "enc"
+	}
+	return fmt.Sprintf("%s%s_L%d_%s_p%d-%d", kind, k.Prefix, k.Layer, kv, k.BeginPos, k.EndPos)
+}
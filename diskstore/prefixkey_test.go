@@ -0,0 +1,72 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+func TestPrefixAddressing(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{LocalPath: filepath.Join(dir, "local"), LocalBudget: 1024 * 1024}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	const blockSize = 4
+	system := []int32{1, 887, 526, 263, 8444, 20255, 29889, 13} // two blocks
+	a := append(append([]int32(nil), system...), 1724, 338, 278, 7483)
+	b := append(append([]int32(nil), system...), 3750, 338, 278, 14744)
+
+	ha, hb := PrefixHashes(a, blockSize), PrefixHashes(b, blockSize)
+	if len(ha) != 3 || ha[0] != hb[0] || ha[1] != hb[1] || ha[2] == hb[2] {
+		t.Fatalf("PrefixHashes: %v vs %v", ha, hb)
+	}
+	if PrefixHashes(a[:10], blockSize)[1] != ha[1] {
+		t.Error("a partial last block changed earlier hashes")
+	}
+	if ChainPrefixHash("", system[:4]) != PrefixHash(system[:4]) {
+		t.Error("ChainPrefixHash from empty differs from PrefixHash")
+	}
+
+	// Sequence 3 computes prompt a and stores its blocks by prefix.
+	payload := func(i int) []byte { return bytes.Repeat([]byte{byte(i + 1)}, 32) }
+	for i, h := range ha {
+		begin := int32(i * blockSize)
+		for _, isKey := range []bool{true, false} {
+			key := PrefixKey("", h, 0, begin, begin+blockSize, isKey)
+			if err := store.Put(key, "f16", []int{8}, payload(i)); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+		}
+	}
+	// Removing the slot that computed them does not touch shared blocks.
+	store.RemoveSeq(3)
+	store.Close()
+
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+
+	// Prompt b, in any slot, reuses the shared system prompt blocks.
+	if n := store.MatchPrefix("", hb, blockSize); n != 2 {
+		t.Errorf("MatchPrefix(b) = %d, want 2", n)
+	}
+	if n := store.MatchPrefix("", ha, blockSize); n != 3 {
+		t.Errorf("MatchPrefix(a) = %d, want 3", n)
+	}
+	got, _, err := store.Get(PrefixKey("", hb[1], 0, 4, 8, true))
+	if err != nil || !bytes.Equal(got, payload(1)) {
+		t.Errorf("Get shared block: %v", err)
+	}
+	if _, ok := store.expired[PrefixSeq]; ok {
+		t.Error("prefix blocks raised an expiry event")
+	}
+
+	if err := store.Put(PrefixKey("", "../../etc", 0, 0, 4, true), "f16", []int{8}, payload(0)); err == nil {
+		t.Error("Put accepted a non-hex prefix")
+	}
+}
@@ -0,0 +1,97 @@
+package diskstore
+
+import (
+	"math"
+	"time"
+)
+
+// Backpressure: a runner snapshots blocks as it evicts them from VRAM,
+// and a PutAsync into a full write queue waits for room, so a disk that
+// can't keep up stalls the context shift taking the snapshot. Pressure
+// tells a caller how far behind the store is, so it can skip snapshots
+// for a while (see kvcache.TieredConfig.MaxPressure) instead. Its Level
+// is the highest of
+//
+//   - the write queue's fill,
+//   - the recent latency of local block writes, making room included,
+//     against Config.PressureLatency, and
+//   - 1 while the local disk is full, or the local tier is over its
+//     budget with no remote tier to evict to.
+//
+// The latency's part in Level halves every second without a write, so a
+// store left alone while callers skip snapshots soon reads as idle
+// again.
+
+const (
+	defaultPressureLatency = 50 * time.Millisecond
+
+	// latencySmoothing weighs the newest write in the latency average.
+	latencySmoothing = 0.2
+)
+
+// Pressure is how hard the store is pressed to keep up with writes.
+type Pressure struct {
+	// QueueDepth is the blocks waiting in the write queue, of QueueCap
+	// (Config.WriteQueue; 0 without a queue).
+	QueueDepth int `json:"queue_depth"`
+	QueueCap   int `json:"queue_cap"`
+
+	// WriteLatency is the moving average time of local block writes.
+	WriteLatency time.Duration `json:"write_latency_ns"`
+
+	// Headroom is the local budget left, negative when over it.
+	Headroom int64 `json:"headroom"`
+
+	// DiskFull is set from a write that found the local disk full until
+	// one succeeds (see diskfull.go).
+	DiskFull bool `json:"disk_full"`
+
+	// Level is the highest of the signals in the file comment, from 0,
+	// idle, to 1, unable to keep up; they aren't added up, so one
+	// signal alone can reach 1. kvcache.TieredConfig.MaxPressure
+	// (OLLAMA_KV_TIER_MAX_PRESSURE) is compared against it.
+	Level float64 `json:"level"`
+}
+
+// Pressure returns the store's current write pressure.
+func (s *Store) Pressure() Pressure {
+	s.wqMu.Lock()
+	depth := len(s.wqOrder)
+	s.wqMu.Unlock()
+
+	s.mu.RLock()
+	p := Pressure{
+		QueueDepth:   depth,
+		QueueCap:     s.writeQueue,
+		WriteLatency: s.writeLatency,
+		Headroom:     s.localBudget - s.localUsed,
+		DiskFull:     s.diskFull,
+	}
+	idle := time.Since(s.lastWrite)
+	stuck := p.DiskFull || p.Headroom < 0 && (!s.hasRemote() || s.Degraded())
+	s.mu.RUnlock()
+
+	if p.QueueCap > 0 {
+		p.Level = float64(p.QueueDepth) / float64(p.QueueCap)
+	}
+	if s.pressureLatency > 0 {
+		recent := float64(p.WriteLatency) * math.Exp2(-idle.Seconds())
+		p.Level = max(p.Level, recent/float64(s.pressureLatency))
+	}
+	if stuck {
+		p.Level = 1
+	}
+	p.Level = min(p.Level, 1)
+	return p
+}
+
+// observeWrite adds a local write that took d to the latency average.
+// Must be called with s.mu held.
+func (s *Store) observeWrite(d time.Duration) {
+	if s.writeLatency == 0 {
+		s.writeLatency = d
+	} else {
+		s.writeLatency = time.Duration(latencySmoothing*float64(d) + (1-latencySmoothing)*float64(s.writeLatency))
+	}
+	s.lastWrite = time.Now()
+}
@@ -0,0 +1,73 @@
+package diskstore
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestPressure(t *testing.T) {
+	store, err := New(Config{
+		LocalPath:       t.TempDir(),
+		LocalBudget:     1 << 20,
+		StatsInterval:   -1,
+		PressureLatency: 100 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	if p := store.Pressure(); p.Level != 0 || p.Headroom != 1<<20 {
+		t.Errorf("idle store: %+v, want level 0 and the whole budget left", p)
+	}
+	if err := store.Put(BlockKey{Seq: 1, EndPos: 16, IsKey: true}, "f16", []int{8}, make([]byte, 256)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if p := store.Pressure(); p.WriteLatency <= 0 || p.Headroom != 1<<20-256 {
+		t.Errorf("after a Put: %+v, want its latency and 256 bytes used", p)
+	}
+
+	level := func() float64 {
+		return math.Round(store.Pressure().Level*100) / 100
+	}
+
+	// Slow writes press the store, less the longer ago they were.
+	store.mu.Lock()
+	store.writeLatency, store.lastWrite = 80*time.Millisecond, time.Now()
+	store.mu.Unlock()
+	if l := level(); l != 0.8 {
+		t.Errorf("level after 80ms writes = %v, want 0.8", l)
+	}
+	store.mu.Lock()
+	store.lastWrite = time.Now().Add(-2 * time.Second)
+	store.mu.Unlock()
+	if l := level(); l != 0.2 {
+		t.Errorf("level 2s after 80ms writes = %v, want 0.2", l)
+	}
+
+	// A write queue's fill, when higher.
+	store.wqMu.Lock()
+	store.writeQueue, store.wqOrder = 4, make([]string, 3)
+	store.wqMu.Unlock()
+	if p := store.Pressure(); p.QueueDepth != 3 || p.QueueCap != 4 || p.Level != 0.75 {
+		t.Errorf("3 blocks queued of 4: %+v, want level 0.75", p)
+	}
+	store.wqMu.Lock()
+	store.writeQueue, store.wqOrder = 0, nil
+	store.wqMu.Unlock()
+
+	// Over budget with nowhere to evict to, the store can't keep up.
+	store.mu.Lock()
+	store.localBudget = 128
+	store.mu.Unlock()
+	if p := store.Pressure(); p.Headroom != -128 || p.Level != 1 {
+		t.Errorf("over budget without a remote tier: %+v, want level 1", p)
+	}
+	store.mu.Lock()
+	store.localBudget = 1 << 20
+	store.diskFull = true
+	store.mu.Unlock()
+	if p := store.Pressure(); !p.DiskFull || p.Level != 1 {
+		t.Errorf("disk full: %+v, want level 1", p)
+	}
+}
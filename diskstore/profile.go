@@ -0,0 +1,251 @@
+package diskstore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Eviction policies for Profile.Eviction.
+const (
+	EvictLRU = "lru" // least recently read first
+	EvictLFU = "lfu" // fewest reads first, least recently read among equals
+)
+
+// ttlSweepInterval is how often blocks past their profile's TTL are
+// dropped in the background. Get also drops them on access.
+const ttlSweepInterval = time.Minute
+
+// Profile bundles the cache policy of a namespace so a workload can be
+// configured by name instead of knob by knob.
+type Profile struct {
+	Name string `json:"name"`
+
+	// Admission: blocks spanning fewer positions are not stored.
+	MinPositions int32 `json:"min_positions,omitempty"`
+
+	// Eviction orders the namespace's local blocks for moving to the
+	// remote tier (EvictLRU or EvictLFU).
+	Eviction string `json:"eviction"`
+
+	// Compress zstd-compresses the namespace's blocks, overriding
+	// Config.Compress either way; with tier codecs (see codec.go) it
+	// compresses them with the local tier's codec or not at all.
+	Compress bool `json:"compress"`
+
+	// TTL drops blocks not read for this long; zero keeps them until
+	// budgets push them out.
+	TTL time.Duration `json:"ttl,omitempty"`
+
+	// Priority orders namespaces under store-wide budget pressure: blocks
+	// of lower-priority namespaces are evicted first. Namespaces without
+	// a profile have priority 0.
+	Priority int `json:"priority"`
+}
+
+// builtinProfiles are the profiles selectable by name.
+var builtinProfiles = []Profile{
+	{
+		// Latency first: no compression on the restore path, idle chats
+		// age out after a day.
+		Name:     "interactive-chat",
+		Eviction: EvictLRU,
+		TTL:      24 * time.Hour,
+		Priority: 10,
+	},
+	{
+		// Long-lived agent context that is re-read over days: keep what
+		// is used often, never expire, compress for capacity.
+		Name:     "agent-memory",
+		Eviction: EvictLFU,
+		Compress: true,
+		Priority: 20,
+	},
+	{
+		// Shared documents reused across many requests. Short tails are
+		// not worth a file, and the namespace yields to interactive work.
+		Name:         "batch-rag",
+		MinPositions: 64,
+		Eviction:     EvictLFU,
+		Compress:     true,
+		TTL:          7 * 24 * time.Hour,
+		Priority:     -10,
+	},
+}
+
+// Profiles returns the built-in profiles, sorted by name.
+func Profiles() []Profile {
+	out := append([]Profile(nil), builtinProfiles...)
+	sort.Slice(out, func(i, j int) bool { return out[i].Name < out[j].Name })
+	return out
+}
+
+// LookupProfile returns the built-in profile called name.
+func LookupProfile(name string) (Profile, error) {
+	for _, p := range builtinProfiles {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return Profile{}, fmt.Errorf("diskstore: unknown profile %q", name)
+}
+
+// profileFor returns the profile of ns, or nil if it has none.
+func (s *Store) profileFor(ns string) *Profile {
+	return s.profiles[ns]
+}
+
+// admits reports whether ns's profile lets key be stored.
+func (s *Store) admits(key BlockKey) bool {
+	p := s.profileFor(key.Namespace)
+	return p == nil || key.EndPos-key.BeginPos >= p.MinPositions
+}
+
+// encodeFor is encode for a block of ns, adding or skipping the zstd
+// stage, or the local tier's codec, as its profile asks.
+func (s *Store) encodeFor(ns, dtype string, data []byte) ([]byte, []string, bool, error) {
+	p := s.profileFor(ns)
+	if p == nil {
+		return s.encode(dtype, data)
+	}
+	payload := data
+	var names []string
+	skipped := false
+	apply := func(t Transform) error {
+		if isCodec(t.Name()) && s.incompressible(dtype, payload) {
+			skipped = true
+			return nil
+		}
+		out, err := t.Encode(payload)
+		if err != nil {
+			return fmt.Errorf("diskstore: transform %s: %w", t.Name(), err)
+		}
+		payload = out
+		names = append(names, t.Name())
+		return nil
+	}
+	compressed := false
+	for _, t := range s.chain {
+		if t.Name() == zstdTransformName {
+			if !p.Compress {
+				continue
+			}
+			compressed = true
+		}
+		if err := apply(t); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	if s.codecs != nil {
+		if t := s.codecs["local"].stage; p.Compress && t != nil {
+			if err := apply(t); err != nil {
+				return nil, nil, false, err
+			}
+		}
+	} else if t, ok := s.transforms[zstdTransformName]; p.Compress && !compressed && ok {
+		if err := apply(t); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	return payload, names, skipped, nil
+}
+
+// evictsBefore reports whether local block a should move to the remote
+// tier before b: lower namespace priority first, then by the eviction
+// policy when both namespaces use LFU, then least recently read.
+// Namespaces without a profile use Config.Eviction.
+func (s *Store) evictsBefore(a, b *BlockMeta) bool {
+	pa, pb := s.profileFor(a.Key.Namespace), s.profileFor(b.Key.Namespace)
+	var prioA, prioB int
+	if pa != nil {
+		prioA = pa.Priority
+	}
+	if pb != nil {
+		prioB = pb.Priority
+	}
+	if prioA != prioB {
+		return prioA < prioB
+	}
+	lfu := s.evictionFor(pa) == EvictLFU && s.evictionFor(pb) == EvictLFU
+	if lfu && a.Hits != b.Hits {
+		return a.Hits < b.Hits
+	}
+	return a.AccessedAt.Before(b.AccessedAt)
+}
+
+// evictionFor returns the eviction policy of a namespace with profile p.
+func (s *Store) evictionFor(p *Profile) string {
+	if p != nil {
+		return p.Eviction
+	}
+	return s.eviction
+}
+
+// pastTTL reports whether meta is past its profile's TTL.
+func (s *Store) pastTTL(meta *BlockMeta, now time.Time) bool {
+	p := s.profileFor(meta.Key.Namespace)
+	return p != nil && p.TTL > 0 && now.Sub(meta.AccessedAt) > p.TTL
+}
+
+// ttlFor returns how long meta's block may go unread under its
+// profile's TTL, or zero if it is kept until budgets push it out.
+func (s *Store) ttlFor(meta *BlockMeta) time.Duration {
+	if p := s.profileFor(meta.Key.Namespace); p != nil && !meta.Pinned {
+		return p.TTL
+	}
+	return 0
+}
+
+// ExpireTTL drops every block that has not been read within its
+// profile's TTL and returns how many were dropped. Pinned blocks are
+// kept.
+func (s *Store) ExpireTTL() int {
+	if s.readOnly {
+		return 0
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var n int
+	seqs := make(map[int]bool)
+	for k, meta := range s.index {
+		if meta.Pinned || !s.pastTTL(meta, now) {
+			continue
+		}
+		if err := s.removePayload(meta); err != nil {
+			s.log.Warn("expire block", "key", meta.Key, "error", err)
+			continue
+		}
+		s.releaseUsage(meta, int64(storedSize(meta)))
+		delete(s.index, k)
+		s.indexChanged(meta.Key)
+		s.emit(EventRemove, meta)
+		seqs[meta.Key.Seq] = true
+		n++
+	}
+	for seq := range seqs {
+		if !s.hasSeq(seq) {
+			s.markExpired(seq)
+		}
+	}
+	if n > 0 {
+		s.log.Info("expired idle blocks", "blocks", n)
+	}
+	return n
+}
+
+// ttlLoop runs ExpireTTL until the store is closed.
+func (s *Store) ttlLoop() {
+	defer s.wg.Done()
+	t := time.NewTicker(ttlSweepInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.ExpireTTL()
+		}
+	}
+}
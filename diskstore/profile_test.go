@@ -0,0 +1,90 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestProfiles(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	if _, err := New(Config{LocalPath: filepath.Join(dir, "bad"), Profile: "no-such-profile"}); err == nil {
+		t.Fatal("New accepted an unknown profile")
+	}
+
+	store, err := New(Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  3*4096 + 64, // zstd frames random rag blocks a little larger
+		RemoteBudget: 1024 * 1024,
+		Namespaces: map[string]NamespaceConfig{
+			"chat": {Profile: "interactive-chat"},
+			"rag":  {Profile: "batch-rag"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	rng := rand.New(rand.NewSource(1))
+	random := func() []byte {
+		b := make([]byte, 4096)
+		rng.Read(b)
+		return b
+	}
+	key := func(ns string, begin, end int32) BlockKey {
+		return BlockKey{Namespace: ns, Seq: 1, Layer: 0, BeginPos: begin, EndPos: end, IsKey: true}
+	}
+
+	// batch-rag does not admit short blocks and compresses the rest even
+	// though the store doesn't.
+	store.Put(key("rag", 0, 16), "f16", []int{8}, bytes.Repeat([]byte{1}, 4096))
+	if store.Has(key("rag", 0, 16)) {
+		t.Error("batch-rag admitted a 16-position block")
+	}
+	store.Put(key("rag", 0, 64), "f16", []int{8}, bytes.Repeat([]byte{1}, 4096))
+	if _, meta, _ := store.Get(key("rag", 0, 64)); meta == nil || !meta.Compressed {
+		t.Errorf("batch-rag block not stored compressed: %+v", meta)
+	}
+	store.Delete(key("rag", 0, 64))
+
+	// Under pressure the low-priority namespace goes first, even though
+	// its block is the most recently used.
+	store.Put(key("chat", 0, 64), "f16", []int{8}, random())
+	store.Put(key("chat", 64, 128), "f16", []int{8}, random())
+	store.Put(key("rag", 0, 64), "f16", []int{8}, random())
+	store.Put(key("chat", 128, 192), "f16", []int{8}, random())
+	for _, k := range []BlockKey{key("chat", 0, 64), key("chat", 64, 128), key("rag", 0, 64)} {
+		_, meta, _ := store.Get(k)
+		want := "local"
+		if k.Namespace == "rag" {
+			want = "remote"
+		}
+		if meta == nil || meta.Tier != want {
+			t.Errorf("%s: tier %v, want %s", k, meta, want)
+		}
+	}
+
+	if got := store.Stats().Namespaces["rag"].Profile; got != "batch-rag" {
+		t.Errorf("Stats profile for rag = %q", got)
+	}
+
+	// interactive-chat drops blocks idle for more than a day.
+	store.mu.Lock()
+	store.index[key("chat", 0, 64).String()].AccessedAt = time.Now().Add(-25 * time.Hour)
+	store.mu.Unlock()
+	if _, _, err := store.Get(key("chat", 0, 64)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get of a block past its TTL: %v, want ErrNotFound", err)
+	}
+	if n := store.ExpireTTL(); n != 1 {
+		t.Errorf("ExpireTTL dropped %d blocks, want 1", n)
+	}
+	if store.Has(key("chat", 0, 64)) {
+		t.Error("block past its TTL still indexed")
+	}
+}
@@ -0,0 +1,91 @@
+package diskstore
+
+import (
+	"context"
+	"sync"
+)
+
+// Read memory: every Get holds the block's stored payload and decoded
+// bytes until it returns, and ReadRange also the rows it assembles. With
+// Config.ReadMemory set, reads wait while the bytes held by reads in
+// flight would exceed it, so restoring a long context from several slots
+// at once cannot exhaust the host's RAM. A read larger than the whole
+// budget still runs, alone.
+
+// readBudget bounds the bytes held by reads in flight.
+type readBudget struct {
+	mu    sync.Mutex
+	cond  *sync.Cond
+	limit int64 // 0 is unbounded
+	used  int64
+	waits int64
+}
+
+func (b *readBudget) init(limit int64) {
+	b.cond = sync.NewCond(&b.mu)
+	b.limit = max(limit, 0)
+}
+
+// acquire waits until n more bytes fit in the budget and takes them. It
+// takes nothing if ctx ends first.
+func (b *readBudget) acquire(ctx context.Context, n int64) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.limit > 0 && b.used > 0 && b.used+n > b.limit {
+		b.waits++
+		stop := context.AfterFunc(ctx, func() {
+			b.mu.Lock()
+			b.cond.Broadcast()
+			b.mu.Unlock()
+		})
+		defer stop()
+		for b.used > 0 && b.used+n > b.limit {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			b.cond.Wait()
+		}
+	}
+	b.used += n
+	return nil
+}
+
+// release returns n bytes taken by acquire.
+func (b *readBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
+
+func (b *readBudget) stats() (used, waits int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used, b.waits
+}
+
+// readCost is the memory Get holds while reading meta's block: the stored
+// payload and, when it is encoded, the decoded copy.
+func readCost(meta *BlockMeta) int64 {
+	n := int64(meta.SizeBytes)
+	if meta.Codec != "" {
+		n += int64(meta.StoredBytes)
+	}
+	return n
+}
+
+// rangeCost is the memory ReadRange(key) holds: the rows it assembles from
+// cover and the largest block it decodes on the way.
+func rangeCost(cover []BlockMeta, key BlockKey) int64 {
+	var rows, block int64
+	for i := range cover {
+		meta := &cover[i]
+		span := int64(meta.Key.EndPos - meta.Key.BeginPos)
+		overlap := int64(min(meta.Key.EndPos, key.EndPos) - max(meta.Key.BeginPos, key.BeginPos))
+		if span > 0 && overlap > 0 {
+			rows += int64(meta.SizeBytes) * overlap / span
+		}
+		block = max(block, readCost(meta))
+	}
+	return rows + block
+}
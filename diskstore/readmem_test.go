@@ -0,0 +1,61 @@
+package diskstore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadMemoryBudget(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		ReadMemory:    1500,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for _, begin := range []int32{0, 10} {
+		key := BlockKey{Seq: 1, BeginPos: begin, EndPos: begin + 10, IsKey: true}
+		if err := store.Put(key, "f16", []int{50}, make([]byte, 1000)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Reading both blocks at once needs 2000 + 1000 bytes, twice the
+	// budget, but a read alone always runs.
+	data, end, err := store.ReadRange(BlockKey{Seq: 1, BeginPos: 0, EndPos: 20, IsKey: true})
+	if err != nil || end != 20 || len(data) != 2000 {
+		t.Fatalf("ReadRange = %d bytes to %d, %v", len(data), end, err)
+	}
+	if st := store.Stats(); st.ReadMemory != 0 || st.ReadWaits != 0 {
+		t.Fatalf("after a lone read: ReadMemory %d, ReadWaits %d", st.ReadMemory, st.ReadWaits)
+	}
+
+	// With 1000 bytes held by another read, a second one waits.
+	store.reads.acquire(context.Background(), 1000)
+	done := make(chan error)
+	go func() {
+		_, _, err := store.Get(BlockKey{Seq: 1, BeginPos: 10, EndPos: 20, IsKey: true})
+		done <- err
+	}()
+	for store.Stats().ReadWaits == 0 {
+		select {
+		case err := <-done:
+			t.Fatalf("Get ran past the budget (err %v)", err)
+		case <-time.After(time.Millisecond):
+		}
+	}
+	store.reads.release(1000)
+	if err := <-done; err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if st := store.Stats(); st.ReadMemory != 0 || st.ReadWaits != 1 {
+		t.Errorf("ReadMemory %d, ReadWaits %d; want 0, 1", st.ReadMemory, st.ReadWaits)
+	}
+}
@@ -0,0 +1,60 @@
+package diskstore
+
+import "time"
+
+// Read throughput: Get times every block it reads, including checksum and
+// decode, and keeps a smoothed rate per tier in decoded bytes per second.
+// EstimateRead turns the rates into a read time for a range, so a caller
+// can tell whether restoring from a slow remote tier (NFS, a busy
+// kvblockd) still beats recomputing.
+
+// readRateSmoothing weighs the newest read in a tier's rate.
+const readRateSmoothing = 0.2
+
+// recordRead folds a read of n bytes from tier that took d into the tier's
+// rate. Must be called with s.mu held.
+func (s *Store) recordRead(tier string, n int, d time.Duration) {
+	if n <= 0 || d <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+	if old, ok := s.readRates[tier]; ok {
+		rate = readRateSmoothing*rate + (1-readRateSmoothing)*old
+	}
+	s.readRates[tier] = rate
+}
+
+// ReadRate returns the measured read throughput of tier ("local" or
+// "remote") in bytes per second, or 0 if nothing was read from it yet.
+func (s *Store) ReadRate(tier string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.readRates[tier]
+}
+
+// EstimateRead estimates how long ReadRange(key) takes from the measured
+// rate of the tier each covering block is on. It returns the estimate and
+// where coverage stops, as ReadRange would; ok is false when a block is
+// on a tier that hasn't been read from yet.
+func (s *Store) EstimateRead(key BlockKey) (d time.Duration, end int32, ok bool) {
+	cover := s.rangeCover(key)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ok = true
+	pos := key.BeginPos
+	for pos < key.EndPos {
+		meta := nextCover(cover, pos)
+		if meta == nil {
+			break
+		}
+		if rate := s.readRates[meta.Tier]; rate > 0 {
+			// Get decodes the whole block even when a part is used.
+			d += time.Duration(float64(meta.SizeBytes) / rate * float64(time.Second))
+		} else {
+			ok = false
+		}
+		pos = min(meta.Key.EndPos, key.EndPos)
+	}
+	return d, pos, ok
+}
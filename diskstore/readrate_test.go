@@ -0,0 +1,51 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadRateAndEstimate(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for _, begin := range []int32{0, 10} {
+		key := BlockKey{Seq: 1, BeginPos: begin, EndPos: begin + 10, IsKey: true}
+		if err := store.Put(key, "f16", []int{50}, make([]byte, 1000)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	want := BlockKey{Seq: 1, BeginPos: 5, EndPos: 30, IsKey: true}
+
+	if _, end, ok := store.EstimateRead(want); ok || end != 20 {
+		t.Errorf("EstimateRead before any read = end %d, ok %v; want 20, false", end, ok)
+	}
+
+	if _, _, err := store.Get(BlockKey{Seq: 1, BeginPos: 0, EndPos: 10, IsKey: true}); err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if store.ReadRate("local") <= 0 {
+		t.Fatal("ReadRate(local) not measured by Get")
+	}
+	if got := store.Stats().ReadRates["local"]; got <= 0 {
+		t.Errorf("Stats.ReadRates[local] = %v", got)
+	}
+
+	// Two whole 1000-byte blocks at 1000 bytes/s.
+	store.mu.Lock()
+	store.readRates["local"] = 1000
+	store.mu.Unlock()
+	d, end, ok := store.EstimateRead(want)
+	if !ok || end != 20 || d != 2*time.Second {
+		t.Errorf("EstimateRead = %v, %d, %v; want 2s, 20, true", d, end, ok)
+	}
+}
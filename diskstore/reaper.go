@@ -0,0 +1,78 @@
+package diskstore
+
+// Reaper: removing a sequence (RemoveSeq, RemoveSession) takes its blocks
+// out of the index at once, but the payloads of its remote blocks, which
+// can be thousands of files on NFS, are left to a background reaper
+// instead of being unlinked on the caller's goroutine. The reaper deletes
+// them one at a time, so no other call waits for more than one unlink,
+// skipping any key stored on the same tier again since. Stats.Reaping
+// shows the blocks still waiting and Stats.Reaped those deleted.
+//
+// Like removals queued while degraded, waiting payloads are kept in
+// memory only: Close deletes them, and after a crash they are orphans,
+// removed by the next GC.
+
+// tombstone queues the removal of meta's remote payload for the reaper.
+// Must be called with s.mu held.
+func (s *Store) tombstone(meta *BlockMeta) {
+	s.tombstones = append(s.tombstones, *meta)
+	s.reapingBytes += int64(storedSize(meta))
+	select {
+	case s.reap <- struct{}{}:
+	default:
+	}
+}
+
+// reaps reports whether removing meta's payload is left to the reaper.
+func (s *Store) reaps(meta *BlockMeta) bool {
+	return s.reap != nil && meta.Tier == "remote" && meta.Bundle == nil
+}
+
+// reapLoop deletes tombstoned payloads until the store is closed.
+func (s *Store) reapLoop() {
+	defer s.wg.Done()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.reap:
+			for s.reapOne() {
+				select {
+				case <-s.done:
+					return
+				default:
+				}
+			}
+		}
+	}
+}
+
+// reapOne deletes the oldest tombstoned payload and reports whether
+// there was one.
+func (s *Store) reapOne() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.tombstones) == 0 {
+		return false
+	}
+	meta := s.tombstones[0]
+	s.tombstones[0] = BlockMeta{}
+	s.tombstones = s.tombstones[1:]
+	s.reapingBytes -= int64(storedSize(&meta))
+
+	if m, ok := s.index[meta.Key.String()]; ok && m.Tier == meta.Tier {
+		return true // stored on the remote tier again since
+	}
+	if err := s.removePayload(&meta); err != nil {
+		s.log.Warn("reap block", "key", meta.Key, "error", err)
+		return true
+	}
+	s.reaped++
+	return true
+}
+
+// reapAll deletes every tombstoned payload, on Close.
+func (s *Store) reapAll() {
+	for s.reapOne() {
+	}
+}
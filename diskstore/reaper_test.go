@@ -0,0 +1,95 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// waitReaped waits for the reaper to delete the payloads queued for it.
+func waitReaped(t *testing.T, store *Store) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Stats().Reaping > 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("reaper left %d payloads", store.Stats().Reaping)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestReaper(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	closed := false
+	defer func() {
+		if !closed {
+			store.Close()
+		}
+	}()
+
+	key := func(seq int, i int32) BlockKey {
+		return BlockKey{Seq: seq, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	const n = 20
+	for i := range int32(n) {
+		if err := store.Put(key(1, i), "f16", []int{8}, make([]byte, 16)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if err := store.Put(key(2, 0), "f16", []int{8}, make([]byte, 16)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	for _, seq := range []int{1, 2} {
+		if _, err := store.Migrate(seq, "remote"); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+	}
+
+	if got := store.RemoveSeq(1); got != n {
+		t.Fatalf("RemoveSeq = %d, want %d", got, n)
+	}
+	if store.Has(key(1, 0)) || len(store.Blocks(1)) != 0 {
+		t.Error("removed blocks still indexed")
+	}
+	if st := store.Stats(); st.RemoteUsed != 16 {
+		t.Errorf("RemoteUsed = %d after RemoveSeq, want 16", st.RemoteUsed)
+	}
+	// A payload queued for the reaper whose key is stored on the same
+	// tier again is kept.
+	store.mu.Lock()
+	store.tombstone(store.index[key(2, 0).String()])
+	store.mu.Unlock()
+
+	waitReaped(t, store)
+	st := store.Stats()
+	if st.Reaped != n || st.ReapingBytes != 0 {
+		t.Errorf("Reaped = %d, ReapingBytes = %d, want %d, 0", st.Reaped, st.ReapingBytes, n)
+	}
+	for i := range int32(n) {
+		if _, err := os.Stat(store.blockPath(key(1, i), "remote", 0)); !os.IsNotExist(err) {
+			t.Errorf("payload of %s not reaped: %v", key(1, i), err)
+		}
+	}
+	if _, _, err := store.Get(key(2, 0)); err != nil {
+		t.Errorf("Get of a block stored again: %v", err)
+	}
+
+	// Close deletes what the reaper hasn't.
+	store.RemoveSeq(2)
+	store.Close()
+	closed = true
+	if _, err := os.Stat(store.blockPath(key(2, 0), "remote", 0)); !os.IsNotExist(err) {
+		t.Errorf("payload left after Close: %v", err)
+	}
+}
@@ -0,0 +1,328 @@
+package diskstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Redis tier: RedisTier keeps blocks in Redis, or anything speaking its
+// protocol such as KeyDB or Valkey, as one string value each. Held in
+// the server's memory, it suits small blocks read and promoted often,
+// such as those of system prompts, and a cache several nodes reach over
+// the network; set Config.RemoteBudget to what the server's maxmemory
+// leaves for them. Nodes sharing a server each need a prefix of their
+// own, as each indexes only the blocks it wrote.
+//
+// RedisTier is an ExpiringTier: blocks of a namespace whose profile has a
+// TTL are written with that TTL, less the time they have gone unread,
+// and each read restarts it, so Redis drops them when the store's sweep
+// would, and frees their memory even if the store never comes back.
+
+// redisDefaultPrefix is prepended to block keys without RedisConfig.Prefix.
+const redisDefaultPrefix = "kv:"
+
+// RedisConfig configures a RedisTier.
+type RedisConfig struct {
+	Addr     string // host:port
+	Username string // ACL user (Redis 6+); empty for the default user
+	Password string
+	DB       int
+	Prefix   string // prepended to keys (default "kv:")
+
+	// TTL, if set, expires blocks written with Put after this long
+	// unread, whatever their namespace.
+	TTL time.Duration
+
+	PoolSize int           // idle connections kept (default 4)
+	Timeout  time.Duration // per command (default 30s)
+}
+
+// RedisTier keeps blocks in a Redis server. It implements ExpiringTier,
+// so it can serve as Config.RemoteTier, and Pinger.
+type RedisTier struct {
+	cfg  RedisConfig
+	idle chan *redisConn
+}
+
+var _ ExpiringTier = (*RedisTier)(nil)
+
+// NewRedisTier returns a tier storing blocks in the Redis server at
+// cfg.Addr. Connections are made as needed.
+func NewRedisTier(cfg RedisConfig) (*RedisTier, error) {
+	if cfg.Addr == "" {
+		return nil, fmt.Errorf("diskstore: redis tier: no address")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = redisDefaultPrefix
+	}
+	if cfg.PoolSize <= 0 {
+		cfg.PoolSize = 4
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 30 * time.Second
+	}
+	return &RedisTier{cfg: cfg, idle: make(chan *redisConn, cfg.PoolSize)}, nil
+}
+
+// Put stores a block, expiring after RedisConfig.TTL if set.
+func (t *RedisTier) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	return t.PutTTL(key, dtype, shape, data, t.cfg.TTL)
+}
+
+// PutTTL stores a block to be dropped after ttl (zero keeps it).
+func (t *RedisTier) PutTTL(key BlockKey, dtype string, shape []int, data []byte, ttl time.Duration) error {
+	// The value is the dtype and shape, a line each, then the payload.
+	var v bytes.Buffer
+	v.Grow(len(dtype) + 32 + len(data))
+	v.WriteString(dtype + "\n" + formatShape(shape) + "\n")
+	v.Write(data)
+	args := []string{"SET", t.key(key), v.String()}
+	if ttl > 0 {
+		args = append(args, "PX", strconv.FormatInt(max(ttl.Milliseconds(), 1), 10))
+	}
+	if _, err := t.do(context.Background(), args); err != nil {
+		return fmt.Errorf("diskstore: redis put %s: %w", key, err)
+	}
+	return nil
+}
+
+// Get fetches a block. A missing block returns an error wrapping
+// ErrNotFound, like Store.Get.
+func (t *RedisTier) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return t.GetTTL(context.Background(), key, t.cfg.TTL)
+}
+
+// GetContext is Get, giving up when ctx is done.
+func (t *RedisTier) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	return t.GetTTL(ctx, key, t.cfg.TTL)
+}
+
+// GetTTL fetches a block, restarting its ttl if that is set.
+func (t *RedisTier) GetTTL(ctx context.Context, key BlockKey, ttl time.Duration) ([]byte, *BlockMeta, error) {
+	k := t.key(key)
+	cmds := [][]string{{"GET", k}}
+	if ttl > 0 {
+		cmds = append(cmds, []string{"PEXPIRE", k, strconv.FormatInt(max(ttl.Milliseconds(), 1), 10)})
+	}
+	replies, err := t.pipeline(ctx, cmds)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: redis get %s: %w", key, err)
+	}
+	v, _ := replies[0].([]byte)
+	if v == nil {
+		return nil, nil, fmt.Errorf("diskstore: redis get %s: %w", key, ErrNotFound)
+	}
+	dtype, rest, ok1 := bytes.Cut(v, []byte("\n"))
+	shapeStr, data, ok2 := bytes.Cut(rest, []byte("\n"))
+	shape, err := parseShape(string(shapeStr))
+	if !ok1 || !ok2 || err != nil {
+		return nil, nil, fmt.Errorf("diskstore: redis get %s: malformed value", key)
+	}
+	return data, &BlockMeta{
+		Key:       key,
+		DTypeStr:  string(dtype),
+		Shape:     shape,
+		SizeBytes: len(data),
+		Tier:      "remote",
+	}, nil
+}
+
+// Has reports whether the server holds key. Transport errors report
+// false.
+func (t *RedisTier) Has(key BlockKey) bool {
+	n, err := t.do(context.Background(), []string{"EXISTS", t.key(key)})
+	return err == nil && n == int64(1)
+}
+
+// Delete removes a block. Deleting a missing block is not an error.
+func (t *RedisTier) Delete(key BlockKey) error {
+	if _, err := t.do(context.Background(), []string{"DEL", t.key(key)}); err != nil {
+		return fmt.Errorf("diskstore: redis delete %s: %w", key, err)
+	}
+	return nil
+}
+
+// Ping checks that the server is reachable.
+func (t *RedisTier) Ping(ctx context.Context) error {
+	_, err := t.do(ctx, []string{"PING"})
+	return err
+}
+
+// Close closes the idle connections.
+func (t *RedisTier) Close() error {
+	for {
+		select {
+		case c := <-t.idle:
+			c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+func (t *RedisTier) key(key BlockKey) string {
+	return t.cfg.Prefix + key.String()
+}
+
+func (t *RedisTier) do(ctx context.Context, cmd []string) (any, error) {
+	replies, err := t.pipeline(ctx, [][]string{cmd})
+	if err != nil {
+		return nil, err
+	}
+	return replies[0], nil
+}
+
+// pipeline sends cmds on one connection and returns their replies. A
+// reply that is an error fails the call; the connection stays usable.
+func (t *RedisTier) pipeline(ctx context.Context, cmds [][]string) ([]any, error) {
+	c, err := t.conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	replies, err := c.pipeline(ctx, t.cfg.Timeout, cmds)
+	var rerr redisError
+	if err != nil && !errors.As(err, &rerr) {
+		c.Close()
+		return nil, err
+	}
+	select {
+	case t.idle <- c:
+	default:
+		c.Close()
+	}
+	return replies, err
+}
+
+// conn returns an idle connection or dials a new one.
+func (t *RedisTier) conn(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-t.idle:
+		return c, nil
+	default:
+	}
+	d := net.Dialer{Timeout: t.cfg.Timeout}
+	nc, err := d.DialContext(ctx, "tcp", t.cfg.Addr)
+	if err != nil {
+		return nil, err
+	}
+	c := &redisConn{Conn: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	var setup [][]string
+	switch {
+	case t.cfg.Username != "":
+		setup = append(setup, []string{"AUTH", t.cfg.Username, t.cfg.Password})
+	case t.cfg.Password != "":
+		setup = append(setup, []string{"AUTH", t.cfg.Password})
+	}
+	if t.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(t.cfg.DB)})
+	}
+	if len(setup) > 0 {
+		if _, err := c.pipeline(ctx, t.cfg.Timeout, setup); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// redisConn is one connection speaking RESP2.
+type redisConn struct {
+	net.Conn
+	r *bufio.Reader
+	w *bufio.Writer
+}
+
+// redisError is an error reply.
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+func (c *redisConn) pipeline(ctx context.Context, timeout time.Duration, cmds [][]string) ([]any, error) {
+	deadline := time.Now().Add(timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	c.SetDeadline(deadline)
+	if ctx.Done() != nil {
+		stop := context.AfterFunc(ctx, func() { c.SetDeadline(time.Now()) })
+		defer stop()
+	}
+
+	for _, cmd := range cmds {
+		fmt.Fprintf(c.w, "*%d\r\n", len(cmd))
+		for _, arg := range cmd {
+			fmt.Fprintf(c.w, "$%d\r\n%s\r\n", len(arg), arg)
+		}
+	}
+	if err := c.w.Flush(); err != nil {
+		return nil, err
+	}
+	replies := make([]any, len(cmds))
+	var first error
+	for i := range cmds {
+		v, err := c.read()
+		if err != nil {
+			var rerr redisError
+			if !errors.As(err, &rerr) {
+				return nil, err
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		replies[i] = v
+	}
+	return replies, first
+}
+
+// read reads one reply: a string, []byte (nil for a null bulk string),
+// int64 or []any, or a redisError.
+func (c *redisConn) read() (any, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return []byte(nil), err
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return buf[:n], nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		arr := make([]any, n)
+		for i := range arr {
+			if arr[i], err = c.read(); err != nil {
+				return nil, err
+			}
+		}
+		return arr, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
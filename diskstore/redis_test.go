@@ -0,0 +1,227 @@
+package diskstore
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRedis is an in-memory server for the commands RedisTier sends. It
+// records the TTL each key was last given instead of expiring it.
+type fakeRedis struct {
+	password string
+
+	mu   sync.Mutex
+	dbs  map[string]map[string][]byte // by database
+	ttls map[string]time.Duration     // by key, zero for none
+}
+
+func newFakeRedis(t *testing.T, password string) (*fakeRedis, string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	f := &fakeRedis{password: password, dbs: make(map[string]map[string][]byte), ttls: make(map[string]time.Duration)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(c)
+		}
+	}()
+	return f, ln.Addr().String()
+}
+
+func (f *fakeRedis) serve(c net.Conn) {
+	defer c.Close()
+	r, w := bufio.NewReader(c), bufio.NewWriter(c)
+	db, authed := "0", f.password == ""
+	for {
+		var n int
+		if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+			return
+		}
+		args := make([]string, n)
+		for i := range args {
+			var l int
+			if _, err := fmt.Fscanf(r, "$%d\r\n", &l); err != nil {
+				return
+			}
+			buf := make([]byte, l+2)
+			if _, err := io.ReadFull(r, buf); err != nil {
+				return
+			}
+			args[i] = string(buf[:l])
+		}
+		f.mu.Lock()
+		reply := f.exec(&db, &authed, args)
+		f.mu.Unlock()
+		w.WriteString(reply)
+		w.Flush()
+	}
+}
+
+func (f *fakeRedis) exec(db *string, authed *bool, args []string) string {
+	cmd := strings.ToUpper(args[0])
+	if cmd == "AUTH" {
+		if args[len(args)-1] != f.password {
+			return "-WRONGPASS invalid password\r\n"
+		}
+		*authed = true
+		return "+OK\r\n"
+	}
+	if !*authed {
+		return "-NOAUTH Authentication required.\r\n"
+	}
+	data := f.dbs[*db]
+	if data == nil {
+		data = make(map[string][]byte)
+		f.dbs[*db] = data
+	}
+	switch cmd {
+	case "PING":
+		return "+PONG\r\n"
+	case "SELECT":
+		*db = args[1]
+		return "+OK\r\n"
+	case "SET":
+		data[args[1]] = []byte(args[2])
+		f.ttls[args[1]] = 0
+		if len(args) == 5 && strings.ToUpper(args[3]) == "PX" {
+			ms, _ := strconv.Atoi(args[4])
+			f.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+		}
+		return "+OK\r\n"
+	case "GET":
+		v, ok := data[args[1]]
+		if !ok {
+			return "$-1\r\n"
+		}
+		return fmt.Sprintf("$%d\r\n%s\r\n", len(v), v)
+	case "PEXPIRE":
+		if _, ok := data[args[1]]; !ok {
+			return ":0\r\n"
+		}
+		ms, _ := strconv.Atoi(args[2])
+		f.ttls[args[1]] = time.Duration(ms) * time.Millisecond
+		return ":1\r\n"
+	case "EXISTS":
+		if _, ok := data[args[1]]; ok {
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	case "DEL":
+		if _, ok := data[args[1]]; ok {
+			delete(data, args[1])
+			return ":1\r\n"
+		}
+		return ":0\r\n"
+	}
+	return "-ERR unknown command\r\n"
+}
+
+func (f *fakeRedis) ttl(key string) time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.ttls[key]
+}
+
+func TestRedisTier(t *testing.T) {
+	f, addr := newFakeRedis(t, "secret")
+	tier, err := NewRedisTier(RedisConfig{Addr: addr, Password: "secret", DB: 2})
+	if err != nil {
+		t.Fatalf("NewRedisTier: %v", err)
+	}
+	defer tier.Close()
+	testServiceTier(t, tier)
+
+	key := BlockKey{Seq: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	if err := tier.PutTTL(key, "f16", []int{2}, []byte("kv"), time.Minute); err != nil {
+		t.Fatalf("PutTTL: %v", err)
+	}
+	if got := f.ttl("kv:" + key.String()); got != time.Minute {
+		t.Errorf("TTL after PutTTL = %v, want 1m", got)
+	}
+	f.mu.Lock()
+	_, inDB2 := f.dbs["2"]["kv:"+key.String()]
+	f.mu.Unlock()
+	if !inDB2 {
+		t.Error("block not in database 2")
+	}
+
+	bad, _ := NewRedisTier(RedisConfig{Addr: addr, Password: "wrong"})
+	if err := bad.Ping(context.Background()); err == nil {
+		t.Error("Ping with the wrong password succeeded")
+	}
+}
+
+func TestStoreWithRedisTier(t *testing.T) {
+	f, addr := newFakeRedis(t, "")
+	tier, err := OpenTierURL("redis://"+addr+"?prefix=node1:", 0)
+	if err != nil {
+		t.Fatalf("OpenTierURL: %v", err)
+	}
+	store, err := New(Config{
+		LocalPath:     t.TempDir(),
+		LocalBudget:   200,
+		RemoteBudget:  1024 * 1024,
+		RemoteTier:    tier,
+		Profile:       "interactive-chat",
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(seq int) BlockKey {
+		return BlockKey{Seq: seq, BeginPos: 0, EndPos: 4, IsKey: true}
+	}
+	data := bytes.Repeat([]byte{7}, 100)
+	store.Pin(2)
+	for _, seq := range []int{1, 2, 3} {
+		if err := store.Put(key(seq), "f16", []int{50}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// The evicted block expires in Redis with what is left of its
+	// profile's TTL.
+	if store.index[key(1).String()].Tier != "remote" {
+		t.Fatal("block 1 not evicted")
+	}
+	ttl := f.ttl("node1:" + key(1).String())
+	if ttl <= 23*time.Hour || ttl > 24*time.Hour {
+		t.Errorf("TTL of evicted block = %v, want just under 24h", ttl)
+	}
+
+	// A read restarts it.
+	f.mu.Lock()
+	f.ttls["node1:"+key(1).String()] = time.Hour
+	f.mu.Unlock()
+	if got, _, err := store.Get(key(1)); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get = %d bytes, %v", len(got), err)
+	}
+	if ttl := f.ttl("node1:" + key(1).String()); ttl != 24*time.Hour {
+		t.Errorf("TTL after a read = %v, want 24h", ttl)
+	}
+
+	// Pinned blocks never expire.
+	if _, err := store.Migrate(2, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if ttl := f.ttl("node1:" + key(2).String()); ttl != 0 {
+		t.Errorf("TTL of pinned block = %v, want none", ttl)
+	}
+}
@@ -0,0 +1,239 @@
+package diskstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+)
+
+// Reloading settings: a store lives as long as the runner or daemon that
+// opened it, and restarting that to retune it drops the KV cache in
+// VRAM. Settings are the parts of Config that can change while the store
+// runs: the budgets, the tier codecs, the remote bandwidth limits and the
+// default eviction policy. Reload checks a whole Settings before applying
+// any of it, so a typo leaves the store as it was, then applies the
+// changes under the store's lock: a smaller budget rebalances as
+// SetBudgets does, new blocks are compressed with a new codec while
+// stored ones keep theirs until they move (recompressed on the way with
+// RecompressOnMigrate), and the new eviction order and limits apply to
+// the next eviction and transfer. Each change is logged.
+//
+// WatchSettings reads Settings from a JSON file and reloads it on a
+// signal, for daemons and the runner reloading on SIGHUP; the admin API
+// serves and replaces them at /settings.
+
+// Settings are a store's settings that Reload can change at runtime. The
+// JSON form is that of the admin API and settings files; fields left out
+// of a file keep their value.
+type Settings struct {
+	// LocalBudget and RemoteBudget are Config's, in bytes. With a
+	// free-space budget they cap the derived one.
+	LocalBudget  int64 `json:"local"`
+	RemoteBudget int64 `json:"remote"`
+
+	// LocalCodec and RemoteCodec are Config's, normalized ("zstd:3"),
+	// and only for a store opened with tier codecs: one opened without
+	// them can't start using them at runtime.
+	LocalCodec  string `json:"local_codec,omitempty"`
+	RemoteCodec string `json:"remote_codec,omitempty"`
+
+	// RemoteBandwidth, MigrationBandwidth and RestoreBandwidth are
+	// Config's, in bytes per second; zero is unlimited.
+	RemoteBandwidth    int64 `json:"remote_bandwidth"`
+	MigrationBandwidth int64 `json:"migration_bandwidth"`
+	RestoreBandwidth   int64 `json:"restore_bandwidth"`
+
+	// Eviction is Config's, EvictLRU or EvictLFU.
+	Eviction string `json:"eviction"`
+}
+
+// ReloadResult reports what Reload changed.
+type ReloadResult struct {
+	// Changed are the JSON names of the settings that changed.
+	Changed []string `json:"changed"`
+	// BudgetResult is the rebalancing of changed budgets.
+	BudgetResult
+}
+
+// Settings returns the settings cfg would open a store with.
+func (c Config) Settings() Settings {
+	return Settings{
+		LocalBudget:        c.LocalBudget,
+		RemoteBudget:       c.RemoteBudget,
+		LocalCodec:         c.LocalCodec,
+		RemoteCodec:        c.RemoteCodec,
+		RemoteBandwidth:    c.RemoteBandwidth,
+		MigrationBandwidth: c.MigrationBandwidth,
+		RestoreBandwidth:   c.RestoreBandwidth,
+		Eviction:           c.Eviction,
+	}
+}
+
+// ApplySettings sets cfg's fields from st, to open a store with a
+// settings file's settings, including the codecs Reload can't add.
+func (c *Config) ApplySettings(st Settings) {
+	c.LocalBudget, c.RemoteBudget = st.LocalBudget, st.RemoteBudget
+	c.LocalCodec, c.RemoteCodec = st.LocalCodec, st.RemoteCodec
+	c.RemoteBandwidth = st.RemoteBandwidth
+	c.MigrationBandwidth = st.MigrationBandwidth
+	c.RestoreBandwidth = st.RestoreBandwidth
+	c.Eviction = st.Eviction
+}
+
+// Settings returns the store's current settings.
+func (s *Store) Settings() Settings {
+	s.mu.RLock()
+	st := Settings{
+		LocalBudget:  s.localBudget,
+		RemoteBudget: s.remoteBudget,
+		Eviction:     s.eviction,
+	}
+	if s.codecs != nil {
+		st.LocalCodec, st.RemoteCodec = s.codecs["local"].spec, s.codecs["remote"].spec
+	}
+	s.mu.RUnlock()
+	st.RemoteBandwidth, st.RestoreBandwidth, st.MigrationBandwidth = s.bw.limits()
+	if st.Eviction == "" {
+		st.Eviction = EvictLRU
+	}
+	return st
+}
+
+// Reload changes the store's settings to next, applying nothing if any
+// of them is invalid.
+func (s *Store) Reload(next Settings) (ReloadResult, error) {
+	if s.readOnly {
+		return ReloadResult{}, ErrReadOnly
+	}
+	if next.LocalBudget < 0 || next.RemoteBudget < 0 {
+		return ReloadResult{}, fmt.Errorf("diskstore: reload: budgets must be non-negative")
+	}
+	if next.RemoteBandwidth < 0 || next.MigrationBandwidth < 0 || next.RestoreBandwidth < 0 {
+		return ReloadResult{}, fmt.Errorf("diskstore: reload: bandwidth limits must be non-negative")
+	}
+	if next.Eviction == "" {
+		next.Eviction = EvictLRU
+	}
+	if next.Eviction != EvictLRU && next.Eviction != EvictLFU {
+		return ReloadResult{}, fmt.Errorf("diskstore: reload: unknown eviction policy %q", next.Eviction)
+	}
+	cur := s.Settings()
+
+	// New codecs are built before anything changes, and closed unless
+	// they replace one.
+	codecs := make(map[string]tierCodec)
+	defer func() {
+		for _, c := range codecs {
+			c.close()
+		}
+	}()
+	for tier, spec := range map[string]string{"local": next.LocalCodec, "remote": next.RemoteCodec} {
+		if spec == "" {
+			continue
+		}
+		if s.codecs == nil {
+			return ReloadResult{}, fmt.Errorf("diskstore: reload: %s codec %q: the store was opened without tier codecs", tier, spec)
+		}
+		c, err := newTierCodec(spec)
+		if err != nil {
+			return ReloadResult{}, err
+		}
+		codecs[tier] = c
+	}
+
+	res := ReloadResult{Changed: []string{}}
+	changed := func(name string, old, new any) {
+		if old != new {
+			res.Changed = append(res.Changed, name)
+			s.log.Info("setting changed", "setting", name, "old", old, "new", new)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, tier := range []string{"local", "remote"} {
+		c, ok := codecs[tier]
+		if !ok || c.spec == s.codecs[tier].spec {
+			continue
+		}
+		changed(tier+"_codec", s.codecs[tier].spec, c.spec)
+		// Encoding and moves run under s.mu, so nothing holds the old one.
+		old := s.codecs[tier]
+		s.codecs[tier] = c
+		codecs[tier] = old
+	}
+	changed("eviction", cur.Eviction, next.Eviction)
+	s.eviction = next.Eviction
+
+	changed("remote_bandwidth", cur.RemoteBandwidth, next.RemoteBandwidth)
+	changed("migration_bandwidth", cur.MigrationBandwidth, next.MigrationBandwidth)
+	changed("restore_bandwidth", cur.RestoreBandwidth, next.RestoreBandwidth)
+	s.bw.set(next.RemoteBandwidth, next.RestoreBandwidth, next.MigrationBandwidth)
+
+	// A free-space budget is re-derived under the new cap.
+	s.localDisk.fixed, s.remoteDisk.fixed = next.LocalBudget, next.RemoteBudget
+	if next.LocalBudget != s.localBudget || next.RemoteBudget != s.remoteBudget {
+		changed("local", s.localBudget, next.LocalBudget)
+		changed("remote", s.remoteBudget, next.RemoteBudget)
+		res.BudgetResult = s.applyBudgets(next.LocalBudget, next.RemoteBudget)
+	}
+	s.log.Info("settings reloaded", "changed", res.Changed)
+	return res, nil
+}
+
+// ReadSettings reads the settings file at path over cur, so fields the
+// file leaves out keep cur's values. Unknown fields are an error, to
+// catch a misspelled one.
+func ReadSettings(path string, cur Settings) (Settings, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cur, fmt.Errorf("diskstore: read settings: %w", err)
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&cur); err != nil {
+		return cur, fmt.Errorf("diskstore: parse settings %s: %w", path, err)
+	}
+	return cur, nil
+}
+
+// WatchSettings reloads the settings file at path now, returning its
+// error, and again on each of sigs (e.g. syscall.SIGHUP) until the store
+// is closed. A file that fails to read or apply on a signal is logged and
+// the store keeps its settings.
+func (s *Store) WatchSettings(path string, sigs ...os.Signal) error {
+	reload := func() error {
+		st, err := ReadSettings(path, s.Settings())
+		if err == nil {
+			_, err = s.Reload(st)
+		}
+		return err
+	}
+	if err := reload(); err != nil {
+		return err
+	}
+	if len(sigs) == 0 {
+		return nil
+	}
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, sigs...)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer signal.Stop(c)
+		for {
+			select {
+			case <-s.done:
+				return
+			case sig := <-c:
+				s.log.Info("reloading settings", "file", path, "signal", sig)
+				if err := reload(); err != nil {
+					s.log.Error("reload settings, keeping the current ones", "file", path, "error", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
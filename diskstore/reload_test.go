@@ -0,0 +1,143 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReload(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	for i := int32(0); i < 4; i++ {
+		key := BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+		store.Put(key, "f16", []int{50}, make([]byte, 100))
+	}
+
+	cur := store.Settings()
+	if cur.LocalBudget != 1024*1024 || cur.Eviction != EvictLRU || cur.LocalCodec != "" || cur.RemoteBandwidth != 0 {
+		t.Errorf("Settings = %+v", cur)
+	}
+
+	next := cur
+	next.LocalBudget = 200
+	next.Eviction = EvictLFU
+	next.MigrationBandwidth = 1 << 30
+	res, err := store.Reload(next)
+	if err != nil {
+		t.Fatalf("Reload: %v", err)
+	}
+	if want := []string{"eviction", "migration_bandwidth", "local"}; !slices.Equal(res.Changed, want) || res.Moved != 2 {
+		t.Errorf("Reload = %+v, want %v changed and 2 moved", res, want)
+	}
+	if got := store.Settings(); got != next {
+		t.Errorf("Settings = %+v, want %+v", got, next)
+	}
+	if st := store.Stats(); st.LocalUsed != 200 || st.RemoteBlocks != 2 {
+		t.Errorf("%d bytes local, %d blocks remote; want 200 and 2", st.LocalUsed, st.RemoteBlocks)
+	}
+
+	// Nothing applies when any setting is invalid.
+	for _, bad := range []Settings{
+		{LocalBudget: 100, RemoteBudget: 1024 * 1024, Eviction: "mru"},
+		{LocalBudget: 100, RemoteBudget: 1024 * 1024, RestoreBandwidth: -1},
+		{LocalBudget: 100, RemoteBudget: 1024 * 1024, LocalCodec: CodecS2},
+	} {
+		if _, err := store.Reload(bad); err == nil {
+			t.Errorf("Reload(%+v) succeeded", bad)
+		}
+	}
+	if got := store.Settings(); got != next {
+		t.Errorf("after invalid reloads, Settings = %+v, want %+v", got, next)
+	}
+
+	// Reloading the same settings changes nothing.
+	if res, err := store.Reload(next); err != nil || len(res.Changed) != 0 {
+		t.Errorf("Reload(same) = %+v, %v", res, err)
+	}
+}
+
+func TestReloadCodecs(t *testing.T) {
+	requireZstd(t)
+	store, err := New(Config{
+		LocalPath:     t.TempDir(),
+		LocalBudget:   1024 * 1024,
+		LocalCodec:    CodecS2,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	data := make([]byte, 4096)
+	before := BlockKey{Seq: 1, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(before, "f16", []int{2048}, data)
+
+	next := store.Settings()
+	if next.LocalCodec != CodecS2 || next.RemoteCodec != CodecNone {
+		t.Errorf("codecs %q, %q; want s2, none", next.LocalCodec, next.RemoteCodec)
+	}
+	if _, err := store.Reload(Settings{LocalBudget: next.LocalBudget, LocalCodec: "zstd:23"}); err == nil {
+		t.Error("Reload with zstd:23 succeeded")
+	}
+	next.LocalCodec = "zstd:9"
+	if res, err := store.Reload(next); err != nil || !slices.Equal(res.Changed, []string{"local_codec"}) {
+		t.Fatalf("Reload = %+v, %v", res, err)
+	}
+
+	// New blocks take the new codec; stored ones keep theirs.
+	after := BlockKey{Seq: 2, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(after, "f16", []int{2048}, data)
+	for key, codec := range map[BlockKey]string{before: CodecS2, after: CodecZstd} {
+		got, meta, err := store.Get(key)
+		if err != nil || len(got) != len(data) {
+			t.Fatalf("Get(%v) = %d bytes, %v", key, len(got), err)
+		}
+		if meta.Codec != codec {
+			t.Errorf("block of seq %d in %q, want %q", key.Seq, meta.Codec, codec)
+		}
+	}
+}
+
+func TestWatchSettings(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1024 * 1024,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Fields left out keep their value.
+	path := filepath.Join(dir, "settings.json")
+	os.WriteFile(path, []byte(`{"eviction": "lfu", "remote_bandwidth": 1048576}`), 0644)
+	if err := store.WatchSettings(path); err != nil {
+		t.Fatalf("WatchSettings: %v", err)
+	}
+	if st := store.Settings(); st.Eviction != EvictLFU || st.RemoteBandwidth != 1<<20 || st.LocalBudget != 1024*1024 {
+		t.Errorf("Settings = %+v", st)
+	}
+
+	// A misspelled field is an error, and nothing changes.
+	os.WriteFile(path, []byte(`{"eviction": "lru", "local_budget": 100}`), 0644)
+	if err := store.WatchSettings(path); err == nil {
+		t.Error("WatchSettings with an unknown field succeeded")
+	}
+	if st := store.Settings(); st.Eviction != EvictLFU || st.LocalBudget != 1024*1024 {
+		t.Errorf("Settings = %+v after a bad file", st)
+	}
+}
@@ -0,0 +1,504 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Block service protocol. A storage node runs BlockServiceHandler (see
+// cmd/kvblockd) and GPU nodes reach it with a RemoteClient, typically as
+// Config.RemoteTier. It is plain HTTP/1.1 with query-string keys and JSON
+// metadata, not gRPC: the module has no dependencies beyond zstd, and
+// HTTP needs none. The path prefix is the schema version; /v1 only gains
+// optional parameters, headers and JSON fields, which old peers ignore,
+// and an incompatible change would be served as /v2 beside it.
+//
+//	GET    /v1/ping                   200, empty: liveness
+//	PUT    /v1/block?<key>            body: block bytes; X-Kv-Dtype, X-Kv-Shape
+//	                                  204 stored, 507 no room, 403 read-only store
+//	GET    /v1/block?<key>            200, body: block bytes; X-Kv-Meta: BlockMeta JSON
+//	                                  404 missing, 503 tier unavailable
+//	HEAD   /v1/block?<key>            200 present, 404 missing
+//	DELETE /v1/block?<key>            204, also for a missing block
+//	GET    /v1/range?<key>            200, body: JSON array of BlockMeta
+//	GET    /v1/prefix/{hash}?wait=<d> 200, body: ExportSeq archive; 404 not published
+//
+// <key> is the BlockKey: ns (namespace, omitted for the default), seq,
+// layer, begin and end (decimal int32), kv ("k" or "v") and, for
+// prefix-addressed blocks, prefix (a hex hash). X-Kv-Dtype is the
+// block's dtype, such as "f16", and X-Kv-Shape its shape as decimal
+// dimensions joined by commas. BlockMeta JSON is the index entry, with
+// the fields of BlockMeta's json tags; its key is the BlockKey under the
+// names namespace, seq, layer, begin_pos, end_pos, is_key and prefix.
+// /v1/range lists the blocks of the key's namespace and seq (never
+// prefix-addressed ones) of that layer and kv overlapping [begin, end),
+// sorted by begin_pos, [] if none. A prefix request waits up to wait (at
+// most maxPrefixWait) for the prefix to be published.
+//
+// Any request with a malformed key, shape or wait gets 400, and a store
+// error without a status of its own 500; error bodies are plain text.
+const (
+	headerDType = "X-Kv-Dtype"
+	headerShape = "X-Kv-Shape"
+	headerMeta  = "X-Kv-Meta"
+
+	maxBlockBody  = 1 << 30
+	maxPrefixWait = 5 * time.Minute
+)
+
+// BlockServiceHandler returns an http.Handler serving the store's blocks
+// to RemoteClients. Like AdminHandler it has no authentication, so bind it
+// to a trusted network.
+func (s *Store) BlockServiceHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /v1/ping", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("PUT /v1/block", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keyParams(w, r)
+		if !ok {
+			return
+		}
+		shape, err := parseShape(r.Header.Get(headerShape))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBlockBody))
+		if err != nil {
+			http.Error(w, "read body: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Strict, so a block the disk has no room for is refused with
+		// 507 and the client keeps its copy.
+		if err := s.putShifted(context.Background(), key, r.Header.Get(headerDType), shape, data, 0, true); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /v1/block", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keyParams(w, r)
+		if !ok {
+			return
+		}
+		data, meta, err := s.Get(key)
+		if errors.Is(err, ErrNotFound) {
+			http.NotFound(w, r)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		metaJSON, _ := json.Marshal(meta)
+		w.Header().Set(headerMeta, string(metaJSON))
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Write(data)
+	})
+
+	mux.HandleFunc("HEAD /v1/block", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keyParams(w, r)
+		if !ok {
+			return
+		}
+		if !s.Has(key) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	mux.HandleFunc("DELETE /v1/block", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keyParams(w, r)
+		if !ok {
+			return
+		}
+		if err := s.Delete(key); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	mux.HandleFunc("GET /v1/range", func(w http.ResponseWriter, r *http.Request) {
+		key, ok := keyParams(w, r)
+		if !ok {
+			return
+		}
+		blocks := s.GetNamespaceRange(key.Namespace, key.Seq, key.Layer, key.IsKey, key.BeginPos, key.EndPos)
+		if blocks == nil {
+			blocks = []BlockMeta{}
+		}
+		writeJSON(w, http.StatusOK, blocks)
+	})
+
+	mux.HandleFunc("GET /v1/prefix/{hash}", func(w http.ResponseWriter, r *http.Request) {
+		hash := r.PathValue("hash")
+		var wait time.Duration
+		if v := r.URL.Query().Get("wait"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				http.Error(w, "invalid wait: "+v, http.StatusBadRequest)
+				return
+			}
+			wait = min(d, maxPrefixWait)
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), wait)
+		defer cancel()
+		if _, err := s.WaitPublished(ctx, hash); err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		// Errors after the first write can only cut the stream short;
+		// the importer detects the truncated archive.
+		w.Header().Set("Content-Type", "application/zstd")
+		if _, err := s.ExportPrefix(hash, w); err != nil {
+			s.log.Warn("export prefix", "hash", hash, "error", err)
+		}
+	})
+
+	return mux
+}
+
+// RemoteClient talks to a BlockServiceHandler. It implements Tier, so it
+// can serve as a Store's remote tier, and Pinger, so several of them can
+// be combined in a ReplicaSet, as OpenTierURL does for a kvblock:// URL
+// naming several hosts.
+type RemoteClient struct {
+	base   string
+	client *http.Client
+}
+
+// NewRemoteClient returns a client for the block service at addr, given
+// as host:port or a URL. Requests time out after timeout (default 30s).
+func NewRemoteClient(addr string, timeout time.Duration) *RemoteClient {
+	if !strings.Contains(addr, "://") {
+		addr = "http://" + addr
+	}
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	return &RemoteClient{
+		base:   strings.TrimSuffix(addr, "/"),
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+// openBlockServices opens the block services a kvblock:// URL names (see
+// OpenTierURL), as a ReplicaSet if there are several.
+func openBlockServices(u *url.URL, timeout time.Duration) (Tier, error) {
+	var clients []BlockReader
+	for _, addr := range strings.Split(u.Host, ",") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			clients = append(clients, NewRemoteClient(addr, timeout))
+		}
+	}
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("diskstore: tier %q: no block service address", u.Redacted())
+	}
+	var cfg ReplicaConfig
+	q := u.Query()
+	for name, d := range map[string]*time.Duration{"hedge": &cfg.HedgeAfter, "check": &cfg.CheckInterval} {
+		if v := q.Get(name); v != "" {
+			var err error
+			if *d, err = time.ParseDuration(v); err != nil || *d < 0 {
+				return nil, fmt.Errorf("diskstore: tier %q: invalid %s %q", u.Redacted(), name, v)
+			}
+		}
+	}
+	if len(clients) == 1 {
+		return clients[0].(*RemoteClient), nil
+	}
+	return NewReplicaSet(cfg, clients...), nil
+}
+
+// Put stores a block on the service. A block the service has no room
+// for returns an error wrapping ErrBudgetExceeded.
+func (c *RemoteClient) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	req, err := http.NewRequest(http.MethodPut, c.url("/v1/block", key), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("diskstore: remote put %s: %w", key, err)
+	}
+	req.Header.Set(headerDType, dtype)
+	req.Header.Set(headerShape, formatShape(shape))
+	resp, err := c.do(req)
+	if resp != nil && resp.StatusCode == http.StatusInsufficientStorage {
+		return fmt.Errorf("diskstore: remote put %s: %w: %v", key, ErrBudgetExceeded, err)
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: remote put %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get fetches a block. A missing block returns an error wrapping
+// ErrNotFound, like Store.Get.
+func (c *RemoteClient) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return c.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, giving up when ctx is done.
+func (c *RemoteClient) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.url("/v1/block", key), nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: remote get %s: %w", key, err)
+	}
+	resp, err := c.do(req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil, fmt.Errorf("diskstore: remote get %s: %w", key, ErrNotFound)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: remote get %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: remote get %s: %w", key, err)
+	}
+	meta := &BlockMeta{}
+	if err := json.Unmarshal([]byte(resp.Header.Get(headerMeta)), meta); err != nil {
+		return nil, nil, fmt.Errorf("diskstore: remote get %s: decode metadata: %w", key, err)
+	}
+	return data, meta, nil
+}
+
+// Has reports whether the service holds key. Transport errors report
+// false.
+func (c *RemoteClient) Has(key BlockKey) bool {
+	req, err := http.NewRequest(http.MethodHead, c.url("/v1/block", key), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// Delete removes a block from the service.
+func (c *RemoteClient) Delete(key BlockKey) error {
+	req, err := http.NewRequest(http.MethodDelete, c.url("/v1/block", key), nil)
+	if err != nil {
+		return fmt.Errorf("diskstore: remote delete %s: %w", key, err)
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return fmt.Errorf("diskstore: remote delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// GetRange returns metadata for the service's blocks overlapping
+// [beginPos, endPos) in the default namespace, sorted by BeginPos.
+// Transport errors return nil.
+func (c *RemoteClient) GetRange(seq, layer int, isKey bool, beginPos, endPos int32) []BlockMeta {
+	return c.GetNamespaceRange("", seq, layer, isKey, beginPos, endPos)
+}
+
+// GetNamespaceRange is GetRange for namespace ns, as on Store.
+func (c *RemoteClient) GetNamespaceRange(ns string, seq, layer int, isKey bool, beginPos, endPos int32) []BlockMeta {
+	key := BlockKey{Namespace: ns, Seq: seq, Layer: layer, BeginPos: beginPos, EndPos: endPos, IsKey: isKey}
+	req, err := http.NewRequest(http.MethodGet, c.url("/v1/range", key), nil)
+	if err != nil {
+		return nil
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	var blocks []BlockMeta
+	if err := json.NewDecoder(resp.Body).Decode(&blocks); err != nil {
+		return nil
+	}
+	return blocks
+}
+
+// PullPrefix fetches the blocks a peer published under hash (see
+// Store.Publish) and imports them into dst as seq, returning how many
+// blocks arrived. If the prefix is not published yet, the peer waits up
+// to wait for it; ErrNotPublished means it never was. The client's
+// timeout covers the wait and the transfer.
+func (c *RemoteClient) PullPrefix(ctx context.Context, hash string, wait time.Duration, dst *Store, seq int) (int, error) {
+	u := c.base + "/v1/prefix/" + url.PathEscape(hash)
+	if wait > 0 {
+		u += "?wait=" + url.QueryEscape(wait.String())
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: pull prefix %s: %w", hash, err)
+	}
+	resp, err := c.do(req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return 0, fmt.Errorf("%w: %s", ErrNotPublished, hash)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("diskstore: pull prefix %s: %w", hash, err)
+	}
+	defer resp.Body.Close()
+
+	n, err := dst.ImportSeqAs(resp.Body, seq)
+	if err != nil {
+		return n, fmt.Errorf("diskstore: pull prefix %s: %w", hash, err)
+	}
+	return n, nil
+}
+
+// Ping checks that the service is reachable.
+func (c *RemoteClient) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+"/v1/ping", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// do sends req and turns non-2xx responses into errors. The response is
+// returned alongside such errors so callers can inspect the status.
+func (c *RemoteClient) do(req *http.Request) (*http.Response, error) {
+	return doHTTP(c.client, req)
+}
+
+// doHTTP is RemoteClient.do for any client.
+func doHTTP(client *http.Client, req *http.Request) (*http.Response, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		return resp, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+func (c *RemoteClient) url(path string, key BlockKey) string {
+	kv := "v"
+	if key.IsKey {
+		kv = "k"
+	}
+	q := url.Values{
+		"seq":   {strconv.Itoa(key.Seq)},
+		"layer": {strconv.Itoa(key.Layer)},
+		"begin": {strconv.FormatInt(int64(key.BeginPos), 10)},
+		"end":   {strconv.FormatInt(int64(key.EndPos), 10)},
+		"kv":    {kv},
+	}
+	if key.Namespace != "" {
+		q.Set("ns", key.Namespace)
+	}
+	if key.Prefix != "" {
+		q.Set("prefix", key.Prefix)
+	}
+	return c.base + path + "?" + q.Encode()
+}
+
+// keyParams parses a block key from the query string, writing a 400
+// response if it is malformed.
+func keyParams(w http.ResponseWriter, r *http.Request) (BlockKey, bool) {
+	q := r.URL.Query()
+	var key BlockKey
+	var err error
+	parse := func(name string) int64 {
+		if err != nil {
+			return 0
+		}
+		var n int64
+		n, err = strconv.ParseInt(q.Get(name), 10, 32)
+		if err != nil {
+			err = fmt.Errorf("invalid %s: %q", name, q.Get(name))
+		}
+		return n
+	}
+	key.Seq = int(parse("seq"))
+	key.Layer = int(parse("layer"))
+	key.BeginPos = int32(parse("begin"))
+	key.EndPos = int32(parse("end"))
+	switch q.Get("kv") {
+	case "k":
+		key.IsKey = true
+	case "v":
+	default:
+		if err == nil {
+			err = fmt.Errorf("invalid kv: %q", q.Get("kv"))
+		}
+	}
+	key.Namespace = q.Get("ns")
+	key.Prefix = q.Get("prefix")
+	if err == nil {
+		err = checkNamespace(key.Namespace)
+	}
+	if err == nil {
+		err = checkPrefix(key.Prefix)
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return key, false
+	}
+	return key, true
+}
+
+// errorStatus is the HTTP status for a store error.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrTierUnavailable):
+		return http.StatusServiceUnavailable
+	case errors.Is(err, ErrBudgetExceeded):
+		return http.StatusInsufficientStorage
+	case errors.Is(err, ErrReadOnly):
+		return http.StatusForbidden
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+func formatShape(shape []int) string {
+	parts := make([]string, len(shape))
+	for i, d := range shape {
+		parts[i] = strconv.Itoa(d)
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseShape(s string) ([]int, error) {
+	if s == "" {
+		return nil, nil
+	}
+	parts := strings.Split(s, ",")
+	shape := make([]int, len(parts))
+	for i, p := range parts {
+		d, err := strconv.Atoi(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shape %q", s)
+		}
+		shape[i] = d
+	}
+	return shape, nil
+}
@@ -0,0 +1,115 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/rand"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRemoteClient(t *testing.T) {
+	dir := t.TempDir()
+	backing, err := New(Config{LocalPath: filepath.Join(dir, "node"), LocalBudget: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer backing.Close()
+	srv := httptest.NewServer(backing.BlockServiceHandler())
+	defer srv.Close()
+
+	c := NewRemoteClient(srv.URL, time.Second)
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+
+	key := BlockKey{Namespace: "qwen", Seq: 2, Layer: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	data := []byte("some kv bytes")
+	if err := c.Put(key, "f16", []int{128, 8, 4}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if !c.Has(key) {
+		t.Fatal("Has: false after Put")
+	}
+	got, meta, err := c.Get(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get: got %q, %v", got, err)
+	}
+	if meta.Key != key || meta.DTypeStr != "f16" || len(meta.Shape) != 3 {
+		t.Errorf("Get meta: got %+v", meta)
+	}
+	if r := c.GetRange(2, 1, true, 2, 8); len(r) != 0 {
+		t.Errorf("GetRange ignored namespace: got %d blocks", len(r))
+	}
+
+	if err := c.Delete(key); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if got, _, err := c.Get(key); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get after Delete: got %q, %v; want ErrNotFound", got, err)
+	}
+	if c.Has(key) {
+		t.Error("Has: true after Delete")
+	}
+}
+
+func TestStoreWithRemoteTier(t *testing.T) {
+	dir := t.TempDir()
+	node, err := New(Config{LocalPath: filepath.Join(dir, "node"), LocalBudget: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("New node: %v", err)
+	}
+	defer node.Close()
+	srv := httptest.NewServer(node.BlockServiceHandler())
+	defer srv.Close()
+
+	gpu, err := New(Config{
+		LocalPath:    filepath.Join(dir, "gpu"),
+		LocalBudget:  5000,
+		RemoteBudget: 1024 * 1024,
+		Compress:     true,
+		RemoteTier:   NewRemoteClient(srv.URL, time.Second),
+	})
+	if err != nil {
+		t.Fatalf("New gpu: %v", err)
+	}
+	defer gpu.Close()
+
+	block := func(i int) []byte {
+		b := make([]byte, 3000)
+		rand.New(rand.NewSource(int64(i))).Read(b) // incompressible
+		return b
+	}
+	for i := 0; i < 8; i++ {
+		key := BlockKey{Seq: 0, Layer: 0, BeginPos: int32(i), EndPos: int32(i + 1), IsKey: true}
+		if err := gpu.Put(key, "f16", []int{128}, block(i)); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+	if st := gpu.Stats(); st.RemoteBlocks == 0 {
+		t.Fatal("nothing spilled to the block service")
+	}
+	if st := node.Stats(); st.LocalBlocks != gpu.Stats().RemoteBlocks {
+		t.Errorf("node holds %d blocks, gpu thinks %d are remote", st.LocalBlocks, gpu.Stats().RemoteBlocks)
+	}
+
+	for i := 0; i < 8; i++ {
+		key := BlockKey{Seq: 0, Layer: 0, BeginPos: int32(i), EndPos: int32(i + 1), IsKey: true}
+		got, _, err := gpu.Get(key)
+		if err != nil || !bytes.Equal(got, block(i)) {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+	}
+	if res := gpu.Verify(); len(res.Missing)+len(res.Corrupt) != 0 {
+		t.Errorf("Verify: %+v", res)
+	}
+
+	gpu.RemoveSeq(0)
+	waitReaped(t, gpu)
+	if n := node.Stats().LocalBlocks; n != 0 {
+		t.Errorf("node still holds %d blocks after RemoveSeq", n)
+	}
+}
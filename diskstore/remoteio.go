@@ -0,0 +1,208 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Remote tier I/O: an NFS mount or block service that hiccups should cost
+// a retry, not a failed eviction, and one that hangs should not block a
+// Get forever. Every read, write and removal on the remote tier times out
+// after Config.RemoteTimeout and is retried with exponential backoff.
+// After RemoteFailureThreshold operations in a row have failed, a circuit
+// breaker treats the remote tier as unavailable for RemoteCooldown:
+// operations fail at once with ErrRemoteUnavailable and evictions stop,
+// until the cooldown ends and the next operation tries the tier again;
+// if that fails too, the breaker trips again.
+//
+// A timed-out operation cannot be cancelled (a hung NFS call doesn't
+// return), so it is abandoned: it finishes in the background and its
+// result is dropped. So is one whose caller's context ends first, which
+// doesn't count against the remote tier. A write that lands after its
+// timeout leaves a stray file on the remote tier, which GC removes as an
+// orphan.
+//
+// An operation that fails on every try returns an error wrapping both
+// ErrTierUnavailable and the last cause.
+
+// ErrRemoteUnavailable is returned for remote-tier operations while the
+// remote tier is treated as unavailable after repeated failures. It
+// matches ErrTierUnavailable.
+var ErrRemoteUnavailable error = &kindError{"diskstore: remote tier unavailable", ErrTierUnavailable}
+
+// errRemoteTimeout is a remote-tier operation that didn't finish in time.
+var errRemoteTimeout = errors.New("remote tier operation timed out")
+
+// Defaults for the remote tier I/O settings in Config.
+const (
+	defaultRemoteTimeout          = 30 * time.Second
+	defaultRemoteRetries          = 2
+	defaultRemoteBackoff          = 100 * time.Millisecond
+	defaultRemoteFailureThreshold = 5
+	defaultRemoteCooldown         = 30 * time.Second
+)
+
+// remoteIO holds the remote tier's retry settings and circuit breaker.
+type remoteIO struct {
+	timeout   time.Duration // 0 is none
+	retries   int
+	backoff   time.Duration
+	threshold int
+	cooldown  time.Duration
+
+	mu        sync.Mutex
+	failures  int // operations failed in a row
+	downUntil time.Time
+	retried   int64
+	timeouts  int64
+}
+
+func (r *remoteIO) init(cfg Config) {
+	r.timeout = orDefault(cfg.RemoteTimeout, defaultRemoteTimeout)
+	r.retries = orDefault(cfg.RemoteRetries, defaultRemoteRetries)
+	r.backoff = orDefault(cfg.RemoteBackoff, defaultRemoteBackoff)
+	r.threshold = orDefault(cfg.RemoteFailureThreshold, defaultRemoteFailureThreshold)
+	r.cooldown = orDefault(cfg.RemoteCooldown, defaultRemoteCooldown)
+}
+
+// orDefault returns v, def if v is zero, or zero if v is negative.
+func orDefault[T int | time.Duration](v, def T) T {
+	switch {
+	case v == 0:
+		return def
+	case v < 0:
+		return 0
+	}
+	return v
+}
+
+// available reports whether the breaker lets operations through.
+func (r *remoteIO) available() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !time.Now().Before(r.downUntil)
+}
+
+// observe records the outcome of an operation and reports whether it
+// tripped the breaker.
+func (r *remoteIO) observe(err error) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err == nil {
+		r.failures = 0
+		r.downUntil = time.Time{}
+		return false
+	}
+	// After a cooldown, one more failure trips the breaker again.
+	r.failures++
+	if r.threshold == 0 || (r.failures < r.threshold && r.downUntil.IsZero()) {
+		return false
+	}
+	r.failures = 0
+	r.downUntil = time.Now().Add(r.cooldown)
+	return true
+}
+
+func (r *remoteIO) stats() (retried, timeouts int64, downUntil time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Now().Before(r.downUntil) {
+		downUntil = r.downUntil
+	}
+	return r.retried, r.timeouts, downUntil
+}
+
+// remoteCall runs op against the remote tier with the timeout, retries
+// and circuit breaker of s, giving up when ctx is done. A missing block,
+// or one a full tier refuses, is an answer, not a failure: it is
+// returned at once and counts as the tier working. op must not share memory with the caller that it writes
+// to, as it may outlive the call.
+func remoteCall[T any](ctx context.Context, s *Store, op func() (T, error)) (T, error) {
+	r := &s.rio
+	var zero T
+	if s.degraded.Load() || !r.available() {
+		return zero, ErrRemoteUnavailable
+	}
+	wait := r.backoff
+	for try := 0; ; try++ {
+		v, err := runTimeout(ctx, r, op)
+		if err == nil || errors.Is(err, os.ErrNotExist) || errors.Is(err, ErrBudgetExceeded) {
+			r.observe(nil)
+			return v, err
+		}
+		if ctx.Err() != nil {
+			return zero, err // the caller gave up, not the tier
+		}
+		if try >= r.retries {
+			if r.observe(err) {
+				s.log.Warn("remote tier unavailable", "cooldown", r.cooldown, "error", err)
+			}
+			return zero, fmt.Errorf("%w: %w", ErrTierUnavailable, err)
+		}
+		r.mu.Lock()
+		r.retried++
+		r.mu.Unlock()
+		s.log.Debug("retrying remote tier operation", "try", try+1, "backoff", wait, "error", err)
+		if err := sleepContext(ctx, wait); err != nil {
+			return zero, err
+		}
+		wait *= 2
+	}
+}
+
+// remoteDo is remoteCall for writes and removals, which run to the end
+// once started.
+func remoteDo(s *Store, op func() error) error {
+	_, err := remoteCall(context.Background(), s, func() (struct{}, error) { return struct{}{}, op() })
+	return err
+}
+
+// runTimeout runs op, abandoning it after r's timeout or when ctx is done.
+func runTimeout[T any](ctx context.Context, r *remoteIO, op func() (T, error)) (T, error) {
+	if r.timeout == 0 && ctx.Done() == nil {
+		return op()
+	}
+	type result struct {
+		v   T
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		v, err := op()
+		done <- result{v, err}
+	}()
+	var timeout <-chan time.Time
+	if r.timeout > 0 {
+		t := time.NewTimer(r.timeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	var zero T
+	select {
+	case res := <-done:
+		return res.v, res.err
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case <-timeout:
+		r.mu.Lock()
+		r.timeouts++
+		r.mu.Unlock()
+		return zero, fmt.Errorf("%w after %v", errRemoteTimeout, r.timeout)
+	}
+}
+
+// sleepContext sleeps for d, or until ctx is done.
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,157 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyTier is an in-memory Tier whose operations fail while fail is
+// positive, counting down, and hang while hang is set.
+type flakyTier struct {
+	mu     sync.Mutex
+	blocks map[string][]byte
+	fail   int
+	hang   chan struct{}
+	calls  int
+}
+
+var errFlaky = errors.New("flaky tier")
+
+func (f *flakyTier) op() error {
+	f.mu.Lock()
+	f.calls++
+	hang := f.hang
+	if f.fail > 0 {
+		f.fail--
+		f.mu.Unlock()
+		return errFlaky
+	}
+	f.mu.Unlock()
+	if hang != nil {
+		<-hang
+	}
+	return nil
+}
+
+func (f *flakyTier) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	if err := f.op(); err != nil {
+		return nil, nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.blocks[key.String()], nil, nil
+}
+
+func (f *flakyTier) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	if err := f.op(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[key.String()] = bytes.Clone(data)
+	return nil
+}
+
+func (f *flakyTier) Has(key BlockKey) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, ok := f.blocks[key.String()]
+	return ok
+}
+
+func (f *flakyTier) Delete(key BlockKey) error {
+	if err := f.op(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.blocks, key.String())
+	return nil
+}
+
+func (f *flakyTier) set(fail int, hang chan struct{}) {
+	f.mu.Lock()
+	f.fail, f.hang, f.calls = fail, hang, 0
+	f.mu.Unlock()
+}
+
+func TestRemoteRetriesAndBreaker(t *testing.T) {
+	tier := &flakyTier{blocks: make(map[string][]byte)}
+	store, err := New(Config{
+		LocalPath:              filepath.Join(t.TempDir(), "local"),
+		LocalBudget:            1024 * 1024,
+		RemoteBudget:           1024 * 1024,
+		RemoteTier:             tier,
+		RemoteTimeout:          50 * time.Millisecond,
+		RemoteRetries:          2,
+		RemoteBackoff:          time.Millisecond,
+		RemoteFailureThreshold: 2,
+		RemoteCooldown:         100 * time.Millisecond,
+		StatsInterval:          -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(layer int) BlockKey {
+		return BlockKey{Seq: 1, Layer: layer, BeginPos: 0, EndPos: 4, IsKey: true}
+	}
+	data := []byte("sixteen bytes...")
+	for layer := range 4 {
+		if err := store.Put(key(layer), "f16", []int{8}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+
+	// Two failures are retried away.
+	tier.set(2, nil)
+	if n, err := store.Migrate(1, "remote"); err != nil || n != 4 {
+		t.Fatalf("Migrate with a flaky tier: %d, %v", n, err)
+	}
+	if got := store.Stats().RemoteRetries; got != 2 {
+		t.Errorf("RemoteRetries = %d, want 2", got)
+	}
+
+	// A hung read times out on every try.
+	hang := make(chan struct{})
+	defer close(hang)
+	tier.set(0, hang)
+	start := time.Now()
+	if _, _, err := store.Get(key(0)); !errors.Is(err, errRemoteTimeout) || !errors.Is(err, ErrTierUnavailable) {
+		t.Errorf("Get from a hung tier: %v, want a timeout", err)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("Get from a hung tier took %v", d)
+	}
+	if got := store.Stats().RemoteTimeouts; got != 3 {
+		t.Errorf("RemoteTimeouts = %d, want 3", got)
+	}
+
+	// A second failed operation trips the breaker: the tier isn't tried.
+	tier.set(100, nil)
+	store.Get(key(1))
+	if store.Stats().RemoteDownUntil.IsZero() {
+		t.Fatal("remote tier not marked unavailable")
+	}
+	tier.set(0, nil)
+	if _, _, err := store.Get(key(2)); !errors.Is(err, ErrRemoteUnavailable) || !errors.Is(err, ErrTierUnavailable) {
+		t.Errorf("Get while unavailable: %v, want ErrRemoteUnavailable", err)
+	}
+	if tier.calls != 0 {
+		t.Errorf("tier called %d times while unavailable", tier.calls)
+	}
+
+	// After the cooldown the tier is tried again.
+	time.Sleep(150 * time.Millisecond)
+	if got, _, err := store.Get(key(2)); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("Get after the cooldown: %q, %v", got, err)
+	}
+	if !store.Stats().RemoteDownUntil.IsZero() {
+		t.Error("remote tier still unavailable after a successful read")
+	}
+}
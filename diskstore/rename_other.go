@@ -0,0 +1,25 @@
+//go:build !unix && !windows
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+)
+
+// renameFile renames from to to, replacing to if it exists.
+func renameFile(from, to string) error {
+	return os.Rename(from, to)
+}
+
+// crossDevice reports whether a rename may have failed for want of a
+// copy. Without errnos there is no telling why a rename failed, and Plan
+// 9 renames a file only within its directory, so every failed rename is
+// retried as a copy; a copy that can't work fails with its own error.
+func crossDevice(err error) bool {
+	var linkErr *os.LinkError
+	return errors.As(err, &linkErr)
+}
+
+// syncDir does nothing: there is no syncing a directory here.
+func syncDir(dir string) error { return nil }
@@ -0,0 +1,34 @@
+//go:build unix
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// renameFile renames from to to, replacing to if it exists.
+func renameFile(from, to string) error {
+	return os.Rename(from, to)
+}
+
+// crossDevice reports whether a rename failed because from and to are on
+// different filesystems.
+func crossDevice(err error) bool {
+	return errors.Is(err, syscall.EXDEV)
+}
+
+// syncDir syncs dir, making the renames and new files in it durable.
+// Filesystems that can't sync a directory are taken at their word.
+func syncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return err
+	}
+	return nil
+}
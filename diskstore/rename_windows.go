@@ -0,0 +1,53 @@
+//go:build windows
+
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+const (
+	errorNotSameDevice    syscall.Errno = 17
+	errorSharingViolation syscall.Errno = 32
+	errorLockViolation    syscall.Errno = 33
+)
+
+// renameRetries bounds how long renameFile waits for a reader to let go
+// of the file it replaces.
+const renameRetries = 10
+
+// renameFile renames from to to, replacing to if it exists. Windows
+// refuses to replace a file another handle has open without
+// FILE_SHARE_DELETE, which os.Open doesn't ask for, so a block being read
+// while it is rewritten fails the rename: retry for a while, as the read
+// is short.
+func renameFile(from, to string) error {
+	var err error
+	for i := range renameRetries {
+		if err = os.Rename(from, to); err == nil || !inUse(err) {
+			return err
+		}
+		time.Sleep(time.Duration(i+1) * 10 * time.Millisecond)
+	}
+	return err
+}
+
+// inUse reports whether err is Windows refusing a file another handle
+// has open.
+func inUse(err error) bool {
+	return errors.Is(err, syscall.ERROR_ACCESS_DENIED) ||
+		errors.Is(err, errorSharingViolation) || errors.Is(err, errorLockViolation)
+}
+
+// crossDevice reports whether a rename failed because from and to are on
+// different volumes.
+func crossDevice(err error) bool {
+	return errors.Is(err, errorNotSameDevice)
+}
+
+// syncDir does nothing: Windows can't sync a directory, and NTFS
+// journals the renames in it.
+func syncDir(dir string) error { return nil }
@@ -0,0 +1,187 @@
+package diskstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// checksum returns the CRC-32C of a stored payload.
+func checksum(payload []byte) uint32 {
+	return crc32.Checksum(payload, castagnoli)
+}
+
+// otherTier returns the tier a second copy of a block may live on.
+func otherTier(tier string) string {
+	if tier == "remote" {
+		return "local"
+	}
+	return "remote"
+}
+
+// readVerified reads the stored payload for meta and validates it against
+// the recorded checksum. If the primary copy is missing or corrupt and an
+// intact copy exists on the other tier (e.g. left behind by a promotion or
+// a replicated write), the primary is rewritten from the good copy and the
+// repair is counted in Stats instead of surfacing an error; without one
+// the error wraps ErrCorrupted. A read abandoned because ctx is done is
+// neither repaired nor counted.
+func (s *Store) readVerified(ctx context.Context, m *BlockMeta) ([]byte, error) {
+	s.mu.RLock()
+	meta := *m
+	s.mu.RUnlock()
+
+	payload, readErr := s.readPayload(ctx, &meta, restoreIO)
+	if readErr == nil && s.verify(&meta, payload) {
+		return payload, nil
+	}
+	if readErr != nil && ctx.Err() != nil {
+		return nil, fmt.Errorf("diskstore: read block %s: %w", meta.Key, readErr)
+	}
+
+	if alt, ok := s.readAlternate(ctx, &meta); ok {
+		if s.readOnly {
+			return alt, nil
+		}
+		// Best effort: a failed rewrite still leaves a readable copy.
+		if err := s.repairPrimary(&meta, alt); err == nil {
+			s.log.Info("read repair", "key", meta.Key, "tier", meta.Tier, "cause", readErr)
+		}
+		return alt, nil
+	}
+
+	if meta.Tier == "remote" && readErr != nil && !errors.Is(readErr, os.ErrNotExist) {
+		// The remote tier failed (see remoteio.go), not the block.
+		if !errors.Is(readErr, ErrRemoteUnavailable) {
+			s.log.Warn("remote tier read failed", "key", meta.Key, "error", readErr)
+		}
+		return nil, fmt.Errorf("diskstore: read block %s: %w", meta.Key, readErr)
+	}
+	s.mu.Lock()
+	s.corruptReads++
+	s.mu.Unlock()
+	s.log.Error("corrupt block", "key", meta.Key, "tier", meta.Tier, "error", readErr)
+	if readErr != nil {
+		return nil, fmt.Errorf("%w: read block %s: %w", ErrCorrupted, meta.Key, readErr)
+	}
+	return nil, fmt.Errorf("%w: block %s: checksum mismatch", ErrCorrupted, meta.Key)
+}
+
+// repairPrimary rewrites meta's primary copy with alt, an intact copy
+// from readAlternate, and counts the repair in Stats. A bad bundled copy
+// is replaced by a loose file rather than patched in place.
+func (s *Store) repairPrimary(meta *BlockMeta, alt []byte) error {
+	primary := s.metaPath(meta)
+	err := os.MkdirAll(filepath.Dir(primary), 0755)
+	if err == nil {
+		err = replaceFile(primary, alt)
+	}
+	if err != nil {
+		s.log.Warn("read repair failed", "key", meta.Key, "tier", meta.Tier, "error", err)
+		return err
+	}
+	s.mu.Lock()
+	s.readRepairs++
+	if m, ok := s.index[meta.Key.String()]; ok && m.Bundle != nil {
+		s.releaseBundled(m)
+	}
+	s.mu.Unlock()
+	return nil
+}
+
+// replaceFile writes data to path through a temporary file renamed over
+// it, so a reader that has the old file mapped (see mmap.go) never sees it
+// truncated. The file is synced before the rename and its directory
+// after, so a crash leaves the old content or the new, never an empty
+// file.
+func replaceFile(path string, data []byte) error {
+	return replaceFileFunc(path, func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	})
+}
+
+// replaceFileFunc is replaceFile for content streamed by write.
+func replaceFileFunc(path string, write func(io.Writer) error) error {
+	tmp := path + ".tmp"
+	if err := writeSynced(tmp, write); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := renameFile(tmp, path); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// writeSynced writes path with write and syncs it to disk.
+func writeSynced(path string, write func(io.Writer) error) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriterSize(f, 64<<10)
+	err = write(w)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// readAlternate looks for an intact copy of meta on the other tier.
+func (s *Store) readAlternate(ctx context.Context, meta *BlockMeta) ([]byte, bool) {
+	if meta.Checksum == 0 {
+		// Without a checksum there's no way to tell which copy is good.
+		return nil, false
+	}
+	if meta.Replica && s.onService("remote") {
+		r := replicaOf(meta)
+		alt, err := s.readPayload(ctx, &r, restoreIO)
+		if err != nil || !s.verify(meta, alt) {
+			return nil, false
+		}
+		return alt, true
+	}
+	if s.remotePath == "" {
+		return nil, false
+	}
+	tier := otherTier(meta.Tier)
+	if err := s.throttleContext(ctx, tier, restoreIO, storedSize(meta)); err != nil {
+		return nil, false
+	}
+	if tier == "remote" {
+		path := s.blockPath(meta.Key, tier, 0)
+		alt, err := remoteCall(ctx, s, func() ([]byte, error) { return os.ReadFile(path) })
+		if err != nil || !s.verify(meta, alt) {
+			return nil, false
+		}
+		return alt, true
+	}
+	// A local copy may be on any stripe.
+	for i := range max(len(s.stripes), 1) {
+		alt, err := os.ReadFile(s.blockPath(meta.Key, tier, i))
+		if err == nil && s.verify(meta, alt) {
+			return alt, true
+		}
+	}
+	return nil, false
+}
+
+// verify reports whether payload matches the checksum recorded in meta.
+// Blocks written before checksums were recorded always verify.
+func (s *Store) verify(meta *BlockMeta, payload []byte) bool {
+	return meta.Checksum == 0 || checksum(payload) == meta.Checksum
+}
@@ -0,0 +1,78 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadRepairFromOtherTier(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1024 * 1024,
+		RemoteBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 2, Layer: 1, BeginPos: 0, EndPos: 1, IsKey: true}
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 256)
+	if err := store.Put(key, "f16", []int{128}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	// Leave an intact copy on the remote tier, then corrupt the local one.
+	local := store.blockPath(key, "local", 0)
+	remote := store.blockPath(key, "remote", 0)
+	os.MkdirAll(filepath.Dir(remote), 0755)
+	if err := os.WriteFile(remote, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local, bytes.Repeat([]byte{0xff}, len(data)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	got, _, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Get: returned corrupt data")
+	}
+	if n := store.Stats().ReadRepairs; n != 1 {
+		t.Errorf("ReadRepairs = %d, want 1", n)
+	}
+	repaired, _ := os.ReadFile(local)
+	if !bytes.Equal(repaired, data) {
+		t.Error("local copy was not repaired")
+	}
+}
+
+func TestCorruptWithoutReplica(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: false}
+	store.Put(key, "f16", []int{128}, make([]byte, 128))
+	os.WriteFile(store.blockPath(key, "local", 0), []byte("garbage"), 0644)
+
+	if _, _, err := store.Get(key); !errors.Is(err, ErrCorrupted) {
+		t.Fatalf("Get: %v, want ErrCorrupted", err)
+	}
+	if n := store.Stats().CorruptReads; n != 1 {
+		t.Errorf("CorruptReads = %d, want 1", n)
+	}
+}
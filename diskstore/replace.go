@@ -0,0 +1,84 @@
+package diskstore
+
+import (
+	"errors"
+	"os"
+	"strings"
+)
+
+// Replacing a block: a Put of a key that is already stored must not lose
+// the stored block when the new one can't be written, say because the
+// disk is full (see diskfull.go), as the Put then returns no error. The
+// old block is taken out of the index and its file moved aside, out of
+// the new block's way, since both may live at the same path; the file
+// keeps its inode, so blocks sharing it through ForkSeq are untouched.
+// Once the new block is stored the old one is removed, and if it isn't
+// the old one is put back where it was. The old block's replica, only a
+// copy, is dropped up front rather than moved. The old block's bytes
+// count against the budgets until it is removed. A file left aside by a crash is an
+// orphan to GC.
+
+// replaced is a block being replaced by a Put.
+type replaced struct {
+	meta  *BlockMeta
+	aside string // where its file was moved, or "" if it has none
+}
+
+// setAside takes meta out of the index and moves its file aside for a
+// Put replacing it. Must be called with s.mu held.
+func (s *Store) setAside(meta *BlockMeta) (*replaced, error) {
+	if meta.Replica {
+		s.dropReplica(meta)
+	}
+	r := &replaced{meta: meta}
+	if meta.Bundle == nil && !s.onService(meta.Tier) {
+		path := s.metaPath(meta)
+		r.aside = strings.TrimSuffix(path, ".kvblk") + ".replaced.kvblk"
+		err := s.onTierFiles(meta.Tier, func() error { return renameFile(path, r.aside) })
+		if errors.Is(err, os.ErrNotExist) {
+			r.aside = "" // gone already, left to GC
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	delete(s.index, meta.Key.String())
+	s.indexChanged(meta.Key)
+	return r, nil
+}
+
+// restoreReplaced puts r back after the Put replacing it stored nothing.
+// Must be called with s.mu held.
+func (s *Store) restoreReplaced(r *replaced) {
+	if r.aside != "" {
+		path := s.metaPath(r.meta)
+		if err := s.onTierFiles(r.meta.Tier, func() error { return renameFile(r.aside, path) }); err != nil {
+			s.log.Warn("restore replaced block", "key", r.meta.Key, "error", err)
+		}
+	}
+	s.index[r.meta.Key.String()] = r.meta
+	s.indexChanged(r.meta.Key)
+}
+
+// dropReplaced removes r once the block replacing it is stored. Must be
+// called with s.mu held.
+func (s *Store) dropReplaced(r *replaced) {
+	var err error
+	if r.aside != "" {
+		err = s.onTierFiles(r.meta.Tier, func() error { return removeFile(r.aside) })
+	} else {
+		err = s.removePayload(r.meta)
+	}
+	if err != nil {
+		s.log.Warn("remove replaced block", "key", r.meta.Key, "tier", r.meta.Tier, "error", err)
+	}
+	s.releaseUsage(r.meta, int64(storedSize(r.meta)))
+}
+
+// onTierFiles runs op on the files of tier, bounded by the remote
+// timeout off the local tier.
+func (s *Store) onTierFiles(tier string, op func() error) error {
+	if tier == "local" {
+		return op()
+	}
+	return remoteDo(s, op)
+}
@@ -0,0 +1,214 @@
+package diskstore
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"slices"
+	"time"
+)
+
+// Replay re-runs a recorded trace (see trace.go) against a store, so
+// budgets, tier layouts and eviction policies can be compared on a real
+// workload: the replayed store is opened with the configuration under
+// test, and the result tells how many reads it served and from where.
+// Payloads are random bytes of each block's recorded size, so codecs
+// compress them about as badly as real f16 KV and no better; compare
+// layouts with Replay, codecs on real blocks.
+
+// TraceSource yields the operations Replay runs: a TraceReader for a
+// recorded trace, a Workload's Ops for a synthetic one.
+type TraceSource interface {
+	// Next returns the next operation, or io.EOF after the last.
+	Next() (TraceOp, error)
+}
+
+// ReplayOptions configures Replay.
+type ReplayOptions struct {
+	// Speed scales the recorded gaps between operations: 1 replays in
+	// real time, 10 ten times faster. Zero replays as fast as the store
+	// goes.
+	Speed float64
+
+	// SampleEvery, if positive, samples the store's usage each time
+	// this much recorded time has passed, into ReplayResult.Usage.
+	SampleEvery time.Duration
+}
+
+// UsageSample is the store's usage at a point of a replay.
+type UsageSample struct {
+	At          time.Duration `json:"at_ns"` // recorded time since the first operation
+	Blocks      int           `json:"blocks"`
+	LocalBytes  int64         `json:"local_bytes"`
+	RemoteBytes int64         `json:"remote_bytes"`
+}
+
+// ReplayResult is what happened replaying a trace.
+type ReplayResult struct {
+	Ops      int           `json:"ops"`
+	Duration time.Duration `json:"duration_ns"`
+	Recorded time.Duration `json:"recorded_ns"` // from the first to the last operation as recorded
+
+	Puts    int `json:"puts"`
+	Removes int `json:"removes"` // deletes, sequence removals and truncations
+	Errors  int `json:"errors"`  // puts and removals that failed
+
+	// Gets is the number of reads, Hits those served, from the local or
+	// remote tier, and RecordedHits those served when the trace was
+	// recorded.
+	Gets         int `json:"gets"`
+	Hits         int `json:"hits"`
+	LocalHits    int `json:"local_hits"`
+	RemoteHits   int `json:"remote_hits"`
+	RecordedHits int `json:"recorded_hits"`
+
+	// ReadPositions is the positions the reads asked for and
+	// HitPositions those served, counted on layer 0's keys so each
+	// position counts once however many layers are stored.
+	ReadPositions int64 `json:"read_positions"`
+	HitPositions  int64 `json:"hit_positions"`
+
+	// GetP50 and GetP99 are read latencies, hits and misses alike.
+	GetP50 time.Duration `json:"get_p50_ns"`
+	GetP99 time.Duration `json:"get_p99_ns"`
+
+	// Evictions is how many blocks the store moved to the remote tier to
+	// make room during the replay.
+	Evictions int64 `json:"evictions"`
+
+	// Usage is the store's usage every ReplayOptions.SampleEvery and at
+	// the end.
+	Usage []UsageSample `json:"usage,omitempty"`
+}
+
+// HitRatio returns Hits / Gets, or 0 without reads.
+func (r ReplayResult) HitRatio() float64 {
+	if r.Gets == 0 {
+		return 0
+	}
+	return float64(r.Hits) / float64(r.Gets)
+}
+
+// PrefillSaved returns the time prefilling HitPositions would have taken
+// at tps positions per second, the GPU time the served reads saved.
+func (r ReplayResult) PrefillSaved(tps float64) time.Duration {
+	if tps <= 0 {
+		return 0
+	}
+	return time.Duration(float64(r.HitPositions) / tps * float64(time.Second))
+}
+
+// Replay runs the operations of src against s until they end or ctx is
+// done, and returns what happened. It returns an error only for a trace
+// it can't read or a done ctx, with the result so far.
+func Replay(ctx context.Context, s *Store, src TraceSource, opts ReplayOptions) (ReplayResult, error) {
+	var res ReplayResult
+	var latencies []time.Duration
+	var payload []byte
+	var first time.Time
+	start := time.Now()
+	evictions := s.Stats().Evictions
+
+	var nextSample time.Duration
+	sample := func() {
+		s.mu.RLock()
+		res.Usage = append(res.Usage, UsageSample{
+			At:          res.Recorded,
+			Blocks:      len(s.index),
+			LocalBytes:  s.localUsed,
+			RemoteBytes: s.remoteUsed,
+		})
+		s.mu.RUnlock()
+	}
+	finish := func(err error) (ReplayResult, error) {
+		if opts.SampleEvery > 0 {
+			sample()
+		}
+		res.Duration = time.Since(start)
+		res.Evictions = s.Stats().Evictions - evictions
+		if len(latencies) > 0 {
+			slices.Sort(latencies)
+			res.GetP50 = latencies[len(latencies)/2]
+			res.GetP99 = latencies[len(latencies)*99/100]
+		}
+		return res, err
+	}
+	for {
+		op, err := src.Next()
+		if errors.Is(err, io.EOF) {
+			return finish(nil)
+		}
+		if err != nil {
+			return finish(err)
+		}
+		if first.IsZero() {
+			first = op.At
+		}
+		res.Recorded = op.At.Sub(first)
+		if opts.SampleEvery > 0 && res.Recorded >= nextSample {
+			if res.Ops > 0 {
+				sample()
+			}
+			nextSample = res.Recorded.Truncate(opts.SampleEvery) + opts.SampleEvery
+		}
+		if opts.Speed > 0 {
+			due := start.Add(time.Duration(float64(res.Recorded) / opts.Speed))
+			select {
+			case <-ctx.Done():
+				return finish(ctx.Err())
+			case <-time.After(time.Until(due)):
+			}
+		} else if err := ctx.Err(); err != nil {
+			return finish(err)
+		}
+		res.Ops++
+
+		switch op.Op {
+		case TracePut:
+			if len(payload) < op.Size {
+				payload = make([]byte, op.Size)
+				rand.Read(payload)
+			}
+			res.Puts++
+			if s.Put(op.Key, op.DType, nil, payload[:op.Size]) != nil {
+				res.Errors++
+			}
+		case TraceGet:
+			res.Gets++
+			if op.Hit {
+				res.RecordedHits++
+			}
+			positions := int64(0)
+			if op.Key.Layer == 0 && op.Key.IsKey {
+				positions = int64(op.Key.EndPos - op.Key.BeginPos)
+			}
+			res.ReadPositions += positions
+			t := time.Now()
+			_, meta, err := s.Get(op.Key)
+			latencies = append(latencies, time.Since(t))
+			if err == nil {
+				res.Hits++
+				res.HitPositions += positions
+				if meta.Tier == "local" {
+					res.LocalHits++
+				} else {
+					res.RemoteHits++
+				}
+			}
+		case TraceDelete:
+			res.Removes++
+			if s.Delete(op.Key) != nil {
+				res.Errors++
+			}
+		case TraceRemoveSeq:
+			res.Removes++
+			s.RemoveSeq(op.Key.Seq)
+		case TraceTruncate:
+			res.Removes++
+			if _, err := s.TruncateSeq(op.Key.Seq, op.Key.EndPos); err != nil {
+				res.Errors++
+			}
+		}
+	}
+}
@@ -0,0 +1,411 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// BlockReader is the read side of a block store. *Store implements it, as
+// do network clients for remote stores. A missing block yields an error
+// wrapping ErrNotFound.
+type BlockReader interface {
+	Get(key BlockKey) ([]byte, *BlockMeta, error)
+}
+
+// ContextReader is implemented by readers whose reads can be cancelled.
+// The store uses it for a remote tier service, so an abandoned read
+// doesn't keep its connection busy.
+type ContextReader interface {
+	GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error)
+}
+
+// Pinger is implemented by readers that support an active health check.
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+// ReplicaConfig tunes a ReplicaSet.
+type ReplicaConfig struct {
+	// HedgeAfter is how long to wait on a replica before sending the same
+	// read to the next one. Zero disables hedging (pure failover).
+	HedgeAfter time.Duration
+
+	// FailureThreshold consecutive errors mark a replica unhealthy
+	// (default 3). Unhealthy replicas are only tried when no healthy
+	// replica is left, and are re-admitted after Cooldown (default 30s)
+	// or a successful health check.
+	FailureThreshold int
+	Cooldown         time.Duration
+
+	// CheckInterval runs Ping on replicas implementing Pinger. Zero
+	// disables active checks.
+	CheckInterval time.Duration
+}
+
+// ReplicaSet reads blocks from a set of replicated stores, failing over
+// between them and optionally hedging slow reads to bound tail latency.
+// It is a Tier, so a set of block services (see OpenTierURL) can serve as
+// Config.RemoteTier: Put, Has and Delete go to the replicas that are
+// Tiers themselves. A Put is stored on every healthy replica and fails
+// only if none stores the block, so a replica that was down may miss
+// blocks: a replica reporting a block missing (an error wrapping
+// ErrNotFound) sends the read on to the next one, and the read misses
+// only if every replica does.
+type ReplicaSet struct {
+	cfg      ReplicaConfig
+	replicas []*replica
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+type replica struct {
+	r BlockReader
+
+	mu          sync.Mutex
+	failures    int
+	downUntil   time.Time
+	latencyEWMA time.Duration
+	hedgedWins  int64
+	totalReads  int64
+	failedReads int64
+}
+
+// ReplicaStatus is a point-in-time view of one replica's health.
+type ReplicaStatus struct {
+	Healthy    bool          `json:"healthy"`
+	Latency    time.Duration `json:"latency"`
+	Reads      int64         `json:"reads"`
+	Failures   int64         `json:"failures"`
+	HedgedWins int64         `json:"hedged_wins"`
+	DownUntil  time.Time     `json:"down_until,omitempty"`
+}
+
+// NewReplicaSet builds a ReplicaSet over readers, preferred in order.
+func NewReplicaSet(cfg ReplicaConfig, readers ...BlockReader) *ReplicaSet {
+	if cfg.FailureThreshold <= 0 {
+		cfg.FailureThreshold = 3
+	}
+	if cfg.Cooldown <= 0 {
+		cfg.Cooldown = 30 * time.Second
+	}
+	rs := &ReplicaSet{cfg: cfg, done: make(chan struct{})}
+	for _, r := range readers {
+		rs.replicas = append(rs.replicas, &replica{r: r})
+	}
+	if cfg.CheckInterval > 0 {
+		rs.wg.Add(1)
+		go rs.checkLoop()
+	}
+	return rs
+}
+
+// Close stops background health checks.
+func (rs *ReplicaSet) Close() error {
+	close(rs.done)
+	rs.wg.Wait()
+	return nil
+}
+
+type replicaResult struct {
+	rep  *replica
+	data []byte
+	meta *BlockMeta
+	err  error
+}
+
+var (
+	_ Tier          = (*ReplicaSet)(nil)
+	_ ContextReader = (*ReplicaSet)(nil)
+	_ Pinger        = (*ReplicaSet)(nil)
+)
+
+// Get reads key from the healthiest replica; see GetContext.
+func (rs *ReplicaSet) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return rs.GetContext(context.Background(), key)
+}
+
+// GetContext reads key from the healthiest replica, giving up when ctx
+// is done. If HedgeAfter elapses without an answer the read is also sent
+// to the next replica, and the first successful response wins; the reads
+// still running are then cancelled on replicas that are ContextReaders.
+// Errors and misses fail over to the remaining replicas. The read misses
+// with ErrNotFound if every replica misses the block, and otherwise
+// returns the last error if no replica has it.
+func (rs *ReplicaSet) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	order := rs.order()
+	if len(order) == 0 {
+		return nil, nil, fmt.Errorf("diskstore: replica set is empty")
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel() // the losers
+
+	results := make(chan replicaResult, len(order))
+	launch := func(rep *replica) {
+		go func() {
+			start := time.Now()
+			var data []byte
+			var meta *BlockMeta
+			var err error
+			if r, ok := rep.r.(ContextReader); ok {
+				data, meta, err = r.GetContext(ctx, key)
+			} else {
+				data, meta, err = rep.r.Get(key)
+			}
+			if ctx.Err() == nil {
+				// A cancelled read says nothing of the replica.
+				rep.observe(time.Since(start), err, rs.cfg)
+			}
+			results <- replicaResult{rep: rep, data: data, meta: meta, err: err}
+		}()
+	}
+
+	next, inflight := 0, 0
+	launch(order[next])
+	next++
+	inflight++
+
+	var hedge <-chan time.Time
+	if rs.cfg.HedgeAfter > 0 && next < len(order) {
+		t := time.NewTimer(rs.cfg.HedgeAfter)
+		defer t.Stop()
+		hedge = t.C
+	}
+
+	var lastErr error
+	for inflight > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		case <-hedge:
+			hedge = nil
+			if next < len(order) {
+				launch(order[next])
+				next++
+				inflight++
+			}
+		case res := <-results:
+			inflight--
+			if res.err == nil {
+				if res.rep != order[0] {
+					res.rep.mu.Lock()
+					res.rep.hedgedWins++
+					res.rep.mu.Unlock()
+				}
+				return res.data, res.meta, nil
+			}
+			if !errors.Is(res.err, ErrNotFound) {
+				lastErr = res.err
+			}
+			// Fail over immediately rather than waiting for the hedge.
+			if inflight == 0 && next < len(order) {
+				launch(order[next])
+				next++
+				inflight++
+			}
+		}
+	}
+	if lastErr == nil {
+		return nil, nil, fmt.Errorf("diskstore: replica get %s: %w", key, ErrNotFound)
+	}
+	return nil, nil, fmt.Errorf("diskstore: no replica of %d has %s: %w", len(order), key, lastErr)
+}
+
+// Put stores the block on every healthy replica that is a Tier, or on
+// every one if none is healthy, at once. It fails only if no replica
+// stores it, with every replica's error; a block no replica has room for
+// wraps ErrBudgetExceeded.
+func (rs *ReplicaSet) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	var tiers []*replica
+	for _, rep := range rs.order() {
+		if _, ok := rep.r.(Tier); ok && (rep.up() || len(tiers) == 0) {
+			tiers = append(tiers, rep)
+		}
+	}
+	if len(tiers) == 0 {
+		return fmt.Errorf("diskstore: replica put %s: no replica is writable", key)
+	}
+	errs := make([]error, len(tiers))
+	var wg sync.WaitGroup
+	for i, rep := range tiers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if errs[i] = rep.r.(Tier).Put(key, dtype, shape, data); errs[i] != nil && !errors.Is(errs[i], ErrBudgetExceeded) {
+				rep.fail(rs.cfg)
+			}
+		}()
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err == nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("diskstore: replica put %s: %w", key, errors.Join(errs...))
+}
+
+// Has reports whether any replica that is a Tier has the block.
+func (rs *ReplicaSet) Has(key BlockKey) bool {
+	for _, rep := range rs.order() {
+		if t, ok := rep.r.(Tier); ok && t.Has(key) {
+			return true
+		}
+	}
+	return false
+}
+
+// Delete removes the block from every replica that is a Tier.
+func (rs *ReplicaSet) Delete(key BlockKey) error {
+	var errs []error
+	for _, rep := range rs.replicas {
+		if t, ok := rep.r.(Tier); ok {
+			errs = append(errs, t.Delete(key))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Ping checks the replicas that are Pingers, as the health checks do,
+// and succeeds if any of them answers, or if none is a Pinger.
+func (rs *ReplicaSet) Ping(ctx context.Context) error {
+	var errs []error
+	for _, rep := range rs.replicas {
+		if err := rs.check(ctx, rep); err == nil {
+			return nil
+		} else if err != errNoPing {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("diskstore: no replica answers: %w", errors.Join(errs...))
+}
+
+// Status reports per-replica health, in configuration order.
+func (rs *ReplicaSet) Status() []ReplicaStatus {
+	now := time.Now()
+	out := make([]ReplicaStatus, len(rs.replicas))
+	for i, rep := range rs.replicas {
+		rep.mu.Lock()
+		out[i] = ReplicaStatus{
+			Healthy:    !now.Before(rep.downUntil),
+			Latency:    rep.latencyEWMA,
+			Reads:      rep.totalReads,
+			Failures:   rep.failedReads,
+			HedgedWins: rep.hedgedWins,
+		}
+		if !out[i].Healthy {
+			out[i].DownUntil = rep.downUntil
+		}
+		rep.mu.Unlock()
+	}
+	return out
+}
+
+// order returns healthy replicas first, each group in configuration order.
+func (rs *ReplicaSet) order() []*replica {
+	now := time.Now()
+	healthy := make([]*replica, 0, len(rs.replicas))
+	var down []*replica
+	for _, rep := range rs.replicas {
+		rep.mu.Lock()
+		up := !now.Before(rep.downUntil)
+		rep.mu.Unlock()
+		if up {
+			healthy = append(healthy, rep)
+		} else {
+			down = append(down, rep)
+		}
+	}
+	return append(healthy, down...)
+}
+
+// observe updates a replica's health after a read.
+func (rep *replica) observe(d time.Duration, err error, cfg ReplicaConfig) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+
+	rep.totalReads++
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		rep.failedReads++
+		rep.failLocked(cfg)
+		return
+	}
+	rep.failures = 0
+	rep.downUntil = time.Time{}
+	if rep.latencyEWMA == 0 {
+		rep.latencyEWMA = d
+	} else {
+		rep.latencyEWMA = (rep.latencyEWMA*7 + d) / 8
+	}
+}
+
+// up reports whether the replica is healthy.
+func (rep *replica) up() bool {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	return !time.Now().Before(rep.downUntil)
+}
+
+// fail counts a failed write against the replica's health.
+func (rep *replica) fail(cfg ReplicaConfig) {
+	rep.mu.Lock()
+	defer rep.mu.Unlock()
+	rep.failLocked(cfg)
+}
+
+func (rep *replica) failLocked(cfg ReplicaConfig) {
+	rep.failures++
+	if rep.failures >= cfg.FailureThreshold {
+		rep.downUntil = time.Now().Add(cfg.Cooldown)
+	}
+}
+
+func (rs *ReplicaSet) checkLoop() {
+	defer rs.wg.Done()
+	t := time.NewTicker(rs.cfg.CheckInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-rs.done:
+			return
+		case <-t.C:
+			rs.checkAll()
+		}
+	}
+}
+
+func (rs *ReplicaSet) checkAll() {
+	for _, rep := range rs.replicas {
+		ctx, cancel := context.WithTimeout(context.Background(), rs.cfg.CheckInterval)
+		rs.check(ctx, rep)
+		cancel()
+	}
+}
+
+// errNoPing is check's answer for a replica that isn't a Pinger.
+var errNoPing = errors.New("diskstore: replica has no health check")
+
+// check pings rep, if it is a Pinger, and records its health.
+func (rs *ReplicaSet) check(ctx context.Context, rep *replica) error {
+	p, ok := rep.r.(Pinger)
+	if !ok {
+		return errNoPing
+	}
+	err := p.Ping(ctx)
+	rep.mu.Lock()
+	if err == nil {
+		rep.failures = 0
+		rep.downUntil = time.Time{}
+	} else if !errors.Is(err, context.Canceled) {
+		rep.failures = rs.cfg.FailureThreshold
+		rep.downUntil = time.Now().Add(rs.cfg.Cooldown)
+	}
+	rep.mu.Unlock()
+	return err
+}
@@ -0,0 +1,186 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+type fakeReader struct {
+	delay time.Duration
+	err   error
+	data  []byte
+}
+
+func (f *fakeReader) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	time.Sleep(f.delay)
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+	return f.data, &BlockMeta{Key: key}, nil
+}
+
+func TestReplicaSetFailover(t *testing.T) {
+	bad := &fakeReader{err: errors.New("nfs gone")}
+	good := &fakeReader{data: []byte("ok")}
+	rs := NewReplicaSet(ReplicaConfig{FailureThreshold: 2, Cooldown: time.Hour}, bad, good)
+	defer rs.Close()
+
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	for i := 0; i < 3; i++ {
+		data, _, err := rs.Get(key)
+		if err != nil || string(data) != "ok" {
+			t.Fatalf("Get %d: %q, %v", i, data, err)
+		}
+	}
+
+	st := rs.Status()
+	if st[0].Healthy {
+		t.Error("failing replica still marked healthy")
+	}
+	// Once marked down, the bad replica is skipped entirely.
+	if st[0].Reads != 2 {
+		t.Errorf("failing replica saw %d reads, want 2", st[0].Reads)
+	}
+}
+
+func TestReplicaSetHedge(t *testing.T) {
+	slow := &fakeReader{delay: 500 * time.Millisecond, data: []byte("slow")}
+	fast := &fakeReader{data: []byte("fast")}
+	rs := NewReplicaSet(ReplicaConfig{HedgeAfter: 10 * time.Millisecond}, slow, fast)
+	defer rs.Close()
+
+	start := time.Now()
+	data, _, err := rs.Get(BlockKey{})
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if string(data) != "fast" {
+		t.Errorf("Get = %q, want hedged response", data)
+	}
+	if d := time.Since(start); d > 250*time.Millisecond {
+		t.Errorf("hedged Get took %v", d)
+	}
+	if rs.Status()[1].HedgedWins != 1 {
+		t.Error("hedged win not recorded")
+	}
+}
+
+func TestReplicaSetAllFail(t *testing.T) {
+	rs := NewReplicaSet(ReplicaConfig{},
+		&fakeReader{err: errors.New("a")}, &fakeReader{err: errors.New("b")})
+	defer rs.Close()
+	if _, _, err := rs.Get(BlockKey{}); err == nil {
+		t.Fatal("Get: expected error when every replica fails")
+	}
+}
+
+// blockingReader answers only when its read is cancelled.
+type blockingReader struct{ cancelled chan struct{} }
+
+func (b *blockingReader) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	select {}
+}
+
+func (b *blockingReader) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	<-ctx.Done()
+	close(b.cancelled)
+	return nil, nil, ctx.Err()
+}
+
+func TestReplicaSetCancelsLosers(t *testing.T) {
+	slow := &blockingReader{cancelled: make(chan struct{})}
+	rs := NewReplicaSet(ReplicaConfig{HedgeAfter: time.Millisecond}, slow, &fakeReader{data: []byte("fast")})
+	defer rs.Close()
+	if data, _, err := rs.Get(BlockKey{}); err != nil || string(data) != "fast" {
+		t.Fatalf("Get: %q, %v", data, err)
+	}
+	select {
+	case <-slow.cancelled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("losing read not cancelled")
+	}
+	if st := rs.Status()[0]; st.Failures != 0 || !st.Healthy {
+		t.Errorf("cancelled read counted against its replica: %+v", st)
+	}
+}
+
+func TestReplicaSetMiss(t *testing.T) {
+	missing := &fakeReader{err: ErrNotFound}
+	rs := NewReplicaSet(ReplicaConfig{}, missing, &fakeReader{data: []byte("ok")})
+	defer rs.Close()
+	if data, _, err := rs.Get(BlockKey{}); err != nil || string(data) != "ok" {
+		t.Errorf("Get past a replica missing the block: %q, %v", data, err)
+	}
+
+	rs2 := NewReplicaSet(ReplicaConfig{}, missing, missing)
+	defer rs2.Close()
+	if _, _, err := rs2.Get(BlockKey{}); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get missing on every replica: %v, want ErrNotFound", err)
+	}
+}
+
+func TestReplicatedBlockServices(t *testing.T) {
+	dir := t.TempDir()
+	var nodes []*Store
+	var srvs []*httptest.Server
+	for _, name := range []string{"a", "b"} {
+		node, err := New(Config{LocalPath: filepath.Join(dir, name), LocalBudget: 1 << 20, StatsInterval: -1})
+		if err != nil {
+			t.Fatalf("New node: %v", err)
+		}
+		defer node.Close()
+		srv := httptest.NewServer(node.BlockServiceHandler())
+		defer srv.Close()
+		nodes, srvs = append(nodes, node), append(srvs, srv)
+	}
+	tier, err := OpenTierURL("kvblock://"+srvs[0].Listener.Addr().String()+","+srvs[1].Listener.Addr().String()+"?hedge=50ms", time.Second)
+	if err != nil {
+		t.Fatalf("OpenTierURL: %v", err)
+	}
+	rs, ok := tier.(*ReplicaSet)
+	if !ok {
+		t.Fatalf("OpenTierURL with two hosts = %T, want a ReplicaSet", tier)
+	}
+	defer rs.Close()
+	gpu, err := New(Config{
+		LocalPath:     filepath.Join(dir, "gpu"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+		RemoteTier:    rs,
+	})
+	if err != nil {
+		t.Fatalf("New gpu: %v", err)
+	}
+	defer gpu.Close()
+
+	key := BlockKey{Seq: 1, EndPos: 1, IsKey: true}
+	data := bytes.Repeat([]byte{3}, 100)
+	if err := gpu.Put(key, "f16", []int{50}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	if n, err := gpu.Migrate(1, "remote"); err != nil || n != 1 {
+		t.Fatalf("Migrate: %d, %v", n, err)
+	}
+	for i, node := range nodes {
+		if !node.Has(key) {
+			t.Errorf("replica %d doesn't hold the evicted block", i)
+		}
+	}
+
+	// With the first replica gone, reads fail over to the second.
+	srvs[0].Close()
+	if got, _, err := gpu.Get(key); err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get with a replica down: %v", err)
+	}
+	gpu.RemoveSeq(1)
+	waitReaped(t, gpu)
+	if nodes[1].Has(key) {
+		t.Error("block left on the live replica after RemoveSeq")
+	}
+}
@@ -0,0 +1,220 @@
+package diskstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Replication: with Config.Replicate the blocks most expensive to lose,
+// pinned ones and prefix-addressed ones (a system prompt every session
+// starts with), are kept on the remote tier as well as the local one, so
+// a failed SSD or a wiped /tmp doesn't take them. A Put writes such a
+// block to both tiers, Pin copies the sequence's local blocks, and
+// promoting one copies it, keeping the remote copy. BlockMeta.Replica
+// marks local blocks with a remote copy, which counts towards the remote
+// budget; evicting one just deletes the local file.
+//
+// Where a local copy goes bad, read repair (see repair.go) reads the
+// remote one, and where it is gone, GC falls back to it. The index lives
+// on the local tier too, so the replicated blocks' entries are also kept
+// in replicas.json in the remote directory, along with those of pinned
+// and prefix-addressed blocks left only on the remote tier, rewritten on
+// Close and every replicaSyncInterval while they change; a store that
+// opens with an empty index takes them from there as remote blocks.
+
+const (
+	replicaManifest     = "replicas.json"
+	replicaSyncInterval = time.Minute
+)
+
+// replicates reports whether meta is a block replication keeps on both
+// tiers.
+func (s *Store) replicates(meta *BlockMeta) bool {
+	return s.replicate && s.hasRemote() && meta.Bundle == nil && (meta.Pinned || meta.Key.Prefix != "")
+}
+
+// addReplica writes payload, meta's local payload, to the remote tier as
+// its replica, if the remote tier has room. Must be called with s.mu
+// held.
+func (s *Store) addReplica(meta *BlockMeta, payload []byte) {
+	if meta.Replica || meta.Tier != "local" || !s.fitsRemote(meta) {
+		return
+	}
+	if err := s.writePayload(meta, "remote", 0, payload); err != nil {
+		s.log.Warn("replicate block", "key", meta.Key, "error", err)
+		return
+	}
+	meta.Replica = true
+	s.addUsage(meta, "remote", int64(storedSize(meta)))
+	s.indexChanged(meta.Key)
+}
+
+// replicateSeq replicates seq's local blocks. Must be called with s.mu
+// held.
+func (s *Store) replicateSeq(seq int) {
+	for _, meta := range s.index {
+		if meta.Key.Seq != seq || meta.Tier != "local" || meta.Replica || !s.replicates(meta) {
+			continue
+		}
+		data, err := s.readPayload(context.Background(), meta, migrationIO)
+		if err != nil {
+			s.log.Warn("replicate block", "key", meta.Key, "error", err)
+			continue
+		}
+		s.addReplica(meta, data)
+		putBuf(data)
+	}
+}
+
+// replicaOf returns the entry of meta's remote replica.
+func replicaOf(meta *BlockMeta) BlockMeta {
+	r := *meta
+	r.Tier, r.Replica, r.Stripe, r.Share = "remote", false, 0, ""
+	return r
+}
+
+// dropLocalCopy turns replicated local block meta into a remote block,
+// deleting its local payload if remove is set. Must be called with s.mu
+// held.
+func (s *Store) dropLocalCopy(meta *BlockMeta, remove bool) {
+	if remove {
+		if err := removeFile(s.metaPath(meta)); err != nil {
+			s.log.Warn("remove replicated block", "key", meta.Key, "error", err)
+		}
+	}
+	meta.Replica = false
+	s.releaseUsage(meta, int64(storedSize(meta)))
+	meta.Tier, meta.Stripe = "remote", 0
+	s.indexChanged(meta.Key)
+	s.replicasChanged = true
+}
+
+// dropReplica deletes meta's remote replica. Must be called with s.mu
+// held.
+func (s *Store) dropReplica(meta *BlockMeta) {
+	r := replicaOf(meta)
+	if err := s.removePayload(&r); err != nil {
+		s.log.Warn("remove replica", "key", meta.Key, "error", err)
+	}
+	s.addUsage(meta, "remote", -int64(storedSize(meta)))
+	meta.Replica = false
+	s.indexChanged(meta.Key)
+	s.replicasChanged = true
+}
+
+// renameReplica moves meta's remote replica to newKey, or drops it if
+// that fails. Must be called with s.mu held.
+func (s *Store) renameReplica(meta *BlockMeta, newKey BlockKey) {
+	var err error
+	if s.onService("remote") {
+		r := replicaOf(meta)
+		err = s.renameOnService(&r, newKey)
+	} else {
+		from, to := s.blockPath(meta.Key, "remote", 0), s.blockPath(newKey, "remote", 0)
+		err = remoteDo(s, func() error {
+			if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+				return err
+			}
+			return renameFile(from, to)
+		})
+	}
+	if err != nil {
+		s.log.Warn("rename replica", "key", meta.Key, "error", err)
+		s.dropReplica(meta)
+		return
+	}
+	s.replicasChanged = true
+}
+
+// hasReplica reports whether meta's remote replica is there.
+func (s *Store) hasReplica(meta *BlockMeta) bool {
+	if s.onService("remote") {
+		return s.remote.Has(meta.Key)
+	}
+	_, err := os.Stat(s.blockPath(meta.Key, "remote", 0))
+	return err == nil
+}
+
+func (s *Store) replicaManifestPath() string {
+	return filepath.Join(s.remotePath, replicaManifest)
+}
+
+// syncReplicas rewrites replicas.json if the blocks it lists changed.
+func (s *Store) syncReplicas() error {
+	s.mu.Lock()
+	if !s.replicasChanged {
+		s.mu.Unlock()
+		return nil
+	}
+	var entries []BlockMeta
+	for _, meta := range s.index {
+		if meta.Replica || meta.Tier == "remote" && s.replicates(meta) {
+			entries = append(entries, replicaOf(meta))
+		}
+	}
+	s.replicasChanged = false
+	s.mu.Unlock()
+
+	sortBlocks(entries)
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err == nil {
+		path := s.replicaManifestPath()
+		err = remoteDo(s, func() error { return replaceFile(path, data) })
+	}
+	if err != nil {
+		s.mu.Lock()
+		s.replicasChanged = true
+		s.mu.Unlock()
+		s.log.Warn("write replica manifest", "error", err)
+	}
+	return err
+}
+
+// replicaLoop runs syncReplicas until the store is closed.
+func (s *Store) replicaLoop() {
+	defer s.wg.Done()
+	t := time.NewTicker(replicaSyncInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-t.C:
+			s.syncReplicas()
+		}
+	}
+}
+
+// recoverReplicas indexes the blocks of replicas.json as remote blocks.
+// It is called while loading an empty index, before the store is shared.
+func (s *Store) recoverReplicas() {
+	data, err := os.ReadFile(s.replicaManifestPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.log.Warn("read replica manifest", "error", err)
+		}
+		return
+	}
+	var entries []BlockMeta
+	if err := json.Unmarshal(data, &entries); err != nil {
+		s.log.Warn("decode replica manifest", "error", err)
+		return
+	}
+	for i := range entries {
+		meta := &entries[i]
+		s.index[meta.Key.String()] = meta
+	}
+	if len(entries) > 0 {
+		s.log.Warn("local index lost, recovered replicated blocks from the remote tier", "blocks", len(entries))
+	}
+}
+
+// keepsReplica reports whether moving remote block meta to dst leaves
+// its remote payload as the replica.
+func (s *Store) keepsReplica(meta *BlockMeta, dst string) bool {
+	return dst == "local" && meta.Tier == "remote" && meta.Share == "" && s.replicates(meta)
+}
@@ -0,0 +1,107 @@
+package diskstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplicate(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		Replicate:     true,
+		StatsInterval: -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	data := func(i int) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 100)
+	}
+	prefix := PrefixKey("", PrefixHash([]int32{1, 2, 3}), 0, 0, 4, true)
+	pinned := BlockKey{Seq: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	plain := BlockKey{Seq: 2, BeginPos: 0, EndPos: 4, IsKey: true}
+	for i, key := range []BlockKey{prefix, pinned, plain} {
+		if err := store.Put(key, "f16", []int{50}, data(i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	store.Pin(1)
+
+	// The prefix block and the pinned one are on both tiers.
+	st := store.Stats()
+	if st.Replicas != 2 || st.LocalBlocks != 3 || st.LocalUsed != 300 || st.RemoteUsed != 200 {
+		t.Errorf("Stats = %d replicas, %d local blocks, %d/%d bytes used; want 2, 3, 300/200",
+			st.Replicas, st.LocalBlocks, st.LocalUsed, st.RemoteUsed)
+	}
+	for _, key := range []BlockKey{prefix, pinned} {
+		if _, err := os.Stat(store.blockPath(key, "remote", 0)); err != nil {
+			t.Errorf("no replica of %s: %v", key, err)
+		}
+	}
+
+	// Evicting a replicated block just deletes its local file.
+	store.Unpin(1)
+	if res := store.SetBudgets(100, 1024*1024); res.Moved != 2 {
+		t.Errorf("SetBudgets moved %d blocks, want 2", res.Moved)
+	}
+	st = store.Stats()
+	if st.Replicas != 0 || st.LocalUsed != 100 || st.RemoteUsed != 200 {
+		t.Errorf("after eviction: %d replicas, %d/%d bytes used; want 0, 100/200",
+			st.Replicas, st.LocalUsed, st.RemoteUsed)
+	}
+
+	// Promoting the prefix block back keeps its remote copy; the no
+	// longer pinned block leaves the remote tier.
+	store.SetBudgets(1024*1024, 1024*1024)
+	if _, err := store.Migrate(-1, "local"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if meta := store.index[prefix.String()]; meta.Tier != "local" || !meta.Replica {
+		t.Errorf("promoted prefix block on %s, replica %v", meta.Tier, meta.Replica)
+	}
+	if st := store.Stats(); st.Replicas != 1 || st.RemoteUsed != 100 {
+		t.Errorf("after promotion: %d replicas, %d remote bytes used; want 1, 100", st.Replicas, st.RemoteUsed)
+	}
+
+	// GC falls back to the replica of a lost local file.
+	if err := os.Remove(store.blockPath(prefix, "local", 0)); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := store.GC(); err != nil || res.FromReplicas != 1 || res.MissingBlocks != 0 || res.OrphanFiles != 0 {
+		t.Errorf("GC = %+v, %v; want 1 from replicas", res, err)
+	}
+	if got, meta, err := store.Get(prefix); err != nil || meta.Tier != "remote" || !bytes.Equal(got, data(0)) {
+		t.Errorf("Get after GC = %d bytes, %v", len(got), err)
+	}
+
+	// Losing the local tier loses only what wasn't replicated.
+	store.Pin(1)
+	store.Close()
+	if err := os.RemoveAll(cfg.LocalPath); err != nil {
+		t.Fatal(err)
+	}
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	for i, key := range []BlockKey{prefix, pinned} {
+		if got, meta, err := store.Get(key); err != nil || meta.Tier != "remote" || !bytes.Equal(got, data(i)) {
+			t.Errorf("Get(%s) after losing the local tier = %d bytes, %v", key, len(got), err)
+		}
+	}
+	if store.Has(plain) {
+		t.Errorf("unreplicated block survived losing the local tier")
+	}
+	if st := store.Stats(); st.RemoteUsed != 200 {
+		t.Errorf("RemoteUsed = %d after recovery, want 200", st.RemoteUsed)
+	}
+}
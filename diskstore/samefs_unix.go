@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd || dragonfly
+
+package diskstore
+
+import "syscall"
+
+// sameFilesystem reports whether the directories a and b are on the same
+// filesystem, so a file can be renamed from one to the other.
+func sameFilesystem(a, b string) bool {
+	var sa, sb syscall.Stat_t
+	if syscall.Stat(a, &sa) != nil || syscall.Stat(b, &sb) != nil {
+		return false
+	}
+	return sa.Dev == sb.Dev
+}
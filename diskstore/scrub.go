@@ -0,0 +1,133 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Scrubbing: Scrub reads every block the index lists, on whichever tier
+// holds it, and checks the payload against the block's metadata: the
+// recorded checksum, the recorded stored size and, optionally, that it
+// decodes to the recorded logical size. Verify is Scrub without repair
+// or decoding. With repair, a block whose copy is missing or damaged is
+// rewritten from an intact copy on the other tier or its replica, as a
+// read does (see repair.go); a block whose metadata is wrong, or that
+// has no intact copy anywhere, is reported and left alone.
+
+// Scrub problem kinds.
+const (
+	ScrubMissing    = "missing"    // the payload is gone
+	ScrubUnreadable = "unreadable" // reading it failed, e.g. the remote tier is down
+	ScrubChecksum   = "checksum"   // it doesn't match its checksum
+	ScrubSize       = "size"       // its size differs from the metadata's
+	ScrubDecode     = "decode"     // it doesn't decode to its logical size
+)
+
+// ScrubOptions configures Scrub.
+type ScrubOptions struct {
+	// Repair rewrites missing and damaged blocks from an intact copy on
+	// the other tier or the block's replica.
+	Repair bool
+
+	// Decode also decodes every payload and checks its logical size,
+	// which costs a decompression per block.
+	Decode bool
+}
+
+// ScrubProblem is one block Scrub found at fault.
+type ScrubProblem struct {
+	Key      BlockKey `json:"key"`
+	Tier     string   `json:"tier"`
+	Kind     string   `json:"kind"`
+	Detail   string   `json:"detail,omitempty"`
+	Repaired bool     `json:"repaired"`
+}
+
+// ScrubReport is the outcome of Scrub, meant to be read by tooling as
+// JSON.
+type ScrubReport struct {
+	Started  time.Time      `json:"started"`
+	Duration time.Duration  `json:"duration_ns"`
+	Checked  int            `json:"checked"`
+	Bytes    int64          `json:"bytes"` // stored bytes read
+	Repaired int            `json:"repaired"`
+	Problems []ScrubProblem `json:"problems,omitempty"`
+}
+
+// Unrepaired returns how many problems Scrub left in place.
+func (r *ScrubReport) Unrepaired() int {
+	return len(r.Problems) - r.Repaired
+}
+
+// Scrub checks every block against its metadata, repairing what it can
+// if opts.Repair is set, and returns what it found. Blocks are read at
+// migration priority, so a scrub yields to restores.
+func (s *Store) Scrub(opts ScrubOptions) ScrubReport {
+	if opts.Repair && s.writable() != nil {
+		opts.Repair = false
+	}
+	s.mu.RLock()
+	metas := make([]BlockMeta, 0, len(s.index))
+	for _, meta := range s.index {
+		metas = append(metas, *meta)
+	}
+	s.mu.RUnlock()
+	sortBlocks(metas)
+
+	rep := ScrubReport{Started: time.Now()}
+	for i := range metas {
+		meta := &metas[i]
+		rep.Checked++
+		p := s.scrubBlock(meta, opts, &rep)
+		if p == nil {
+			continue
+		}
+		if opts.Repair && p.Kind != ScrubSize && p.Kind != ScrubDecode {
+			if alt, ok := s.readAlternate(context.Background(), meta); ok && s.repairPrimary(meta, alt) == nil {
+				p.Repaired = true
+				rep.Repaired++
+			}
+		}
+		rep.Problems = append(rep.Problems, *p)
+	}
+	rep.Duration = time.Since(rep.Started)
+	s.log.Info("scrub complete", "checked", rep.Checked, "problems", len(rep.Problems), "repaired", rep.Repaired, "took", rep.Duration)
+	return rep
+}
+
+// scrubBlock reads meta's payload and returns what is wrong with it, if
+// anything.
+func (s *Store) scrubBlock(meta *BlockMeta, opts ScrubOptions, rep *ScrubReport) *ScrubProblem {
+	problem := func(kind, detail string) *ScrubProblem {
+		return &ScrubProblem{Key: meta.Key, Tier: meta.Tier, Kind: kind, Detail: detail}
+	}
+	payload, err := s.readPayload(context.Background(), meta, migrationIO)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		return problem(ScrubMissing, "")
+	case err != nil:
+		return problem(ScrubUnreadable, err.Error())
+	}
+	rep.Bytes += int64(len(payload))
+	if !s.verify(meta, payload) {
+		return problem(ScrubChecksum, fmt.Sprintf("crc32c %08x, want %08x", checksum(payload), meta.Checksum))
+	}
+	// Blocks from before stored sizes were recorded have none.
+	if meta.StoredBytes > 0 && len(payload) != meta.StoredBytes {
+		return problem(ScrubSize, fmt.Sprintf("%d bytes stored, metadata says %d", len(payload), meta.StoredBytes))
+	}
+	if !opts.Decode {
+		return nil
+	}
+	data, err := s.decode(meta, payload, nil)
+	switch {
+	case err != nil:
+		return problem(ScrubDecode, err.Error())
+	case len(data) != meta.SizeBytes:
+		return problem(ScrubDecode, fmt.Sprintf("decodes to %d bytes, metadata says %d", len(data), meta.SizeBytes))
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package diskstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScrub(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1024 * 1024,
+		RemoteBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	data := bytes.Repeat([]byte{1, 2, 3, 4}, 256)
+	keys := make([]BlockKey, 4)
+	for i := range keys {
+		keys[i] = BlockKey{Seq: 1, Layer: i, EndPos: 1, IsKey: true}
+		if err := store.Put(keys[i], "f16", []int{512}, data); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	// keys[0] is corrupt with an intact remote copy, keys[1] corrupt
+	// without one, keys[2] missing; keys[3] is fine.
+	remote := store.blockPath(keys[0], "remote", 0)
+	os.MkdirAll(filepath.Dir(remote), 0755)
+	os.WriteFile(remote, data, 0644)
+	garbage := bytes.Repeat([]byte{0xff}, len(data))
+	os.WriteFile(store.blockPath(keys[0], "local", 0), garbage, 0644)
+	os.WriteFile(store.blockPath(keys[1], "local", 0), garbage, 0644)
+	os.Remove(store.blockPath(keys[2], "local", 0))
+
+	rep := store.Scrub(ScrubOptions{Decode: true})
+	want := map[BlockKey]string{keys[0]: ScrubChecksum, keys[1]: ScrubChecksum, keys[2]: ScrubMissing}
+	if rep.Checked != 4 || len(rep.Problems) != 3 || rep.Repaired != 0 {
+		t.Fatalf("Scrub = %+v, want 3 problems of 4 blocks", rep)
+	}
+	for _, p := range rep.Problems {
+		if want[p.Key] != p.Kind || p.Repaired {
+			t.Errorf("problem %+v, want kind %q", p, want[p.Key])
+		}
+	}
+	if res := store.Verify(); len(res.Corrupt) != 2 || len(res.Missing) != 1 {
+		t.Errorf("Verify = %+v", res)
+	}
+
+	rep = store.Scrub(ScrubOptions{Repair: true})
+	if rep.Repaired != 1 || rep.Unrepaired() != 2 {
+		t.Errorf("repairing scrub repaired %d, left %d; want 1, 2", rep.Repaired, rep.Unrepaired())
+	}
+	if got, _ := os.ReadFile(store.blockPath(keys[0], "local", 0)); !bytes.Equal(got, data) {
+		t.Error("corrupt local copy was not repaired")
+	}
+	out, err := json.Marshal(rep)
+	if err != nil || !bytes.Contains(out, []byte(`"kind":"missing"`)) {
+		t.Errorf("report JSON %s, %v", out, err)
+	}
+
+	// Metadata that disagrees with an intact payload is reported, not
+	// repaired.
+	store.mu.Lock()
+	store.index[keys[3].String()].StoredBytes++
+	store.mu.Unlock()
+	rep = store.Scrub(ScrubOptions{Repair: true})
+	if p := rep.Problems[len(rep.Problems)-1]; p.Key != keys[3] || p.Kind != ScrubSize || p.Repaired {
+		t.Errorf("last problem %+v, want an unrepaired size mismatch of %s", p, keys[3])
+	}
+}
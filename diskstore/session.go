@@ -0,0 +1,312 @@
+package diskstore
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// parkedSeqBase is the first sequence ID used to hold blocks of sessions
+// that are not currently bound to a runner slot. It sits far above any
+// real slot count so parked data can't collide with live slots.
+const parkedSeqBase = 1 << 30
+
+// sessionFile is the persisted session registry.
+type sessionFile struct {
+	Sessions   map[string]int `json:"sessions"`
+	NextParked int            `json:"next_parked"`
+}
+
+// RenameSeq re-keys every block of from under to. It fails if to already
+// has blocks, so one conversation can never be merged into another.
+// It returns the number of blocks moved. Blocks still queued by PutAsync
+// are written first, so they move with the rest.
+func (s *Store) RenameSeq(from, to int) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.renameSeq(from, to)
+}
+
+// renameSeq is RenameSeq with s.mu held.
+func (s *Store) renameSeq(from, to int) (int, error) {
+	if from == to {
+		return 0, nil
+	}
+	if s.hasSeq(to) {
+		return 0, fmt.Errorf("diskstore: rename seq %d: seq %d already has blocks", from, to)
+	}
+
+	var moved int
+	for k, meta := range s.index {
+		if meta.Key.Seq != from {
+			continue
+		}
+		newKey := meta.Key
+		newKey.Seq = to
+		if s.onService(meta.Tier) {
+			if err := s.renameOnService(meta, newKey); err != nil {
+				return moved, fmt.Errorf("diskstore: rename %s: %w", meta.Key, err)
+			}
+		} else if meta.Bundle == nil {
+			oldPath := s.metaPath(meta)
+			newPath := s.blockPath(newKey, meta.Tier, meta.Stripe)
+			if err := os.MkdirAll(filepath.Dir(newPath), 0755); err != nil {
+				return moved, fmt.Errorf("diskstore: rename %s: %w", meta.Key, err)
+			}
+			if err := renameFile(oldPath, newPath); err != nil {
+				return moved, fmt.Errorf("diskstore: rename %s: %w", meta.Key, err)
+			}
+		}
+		if meta.Replica {
+			s.renameReplica(meta, newKey)
+		}
+		delete(s.index, k)
+		s.indexChanged(meta.Key)
+		meta.Key = newKey
+		s.index[newKey.String()] = meta
+		s.indexChanged(newKey)
+		moved++
+	}
+
+	if s.pinned[from] {
+		delete(s.pinned, from)
+		s.pinned[to] = true
+	}
+	for id, seq := range s.sessions {
+		if seq == from {
+			s.sessions[id] = to
+		}
+	}
+	s.movePublications(from, to)
+	s.log.Debug("renamed sequence", "from", from, "to", to, "blocks", moved)
+	return moved, nil
+}
+
+// renameOnService re-keys a block held by the remote block service,
+// which has no rename operation.
+func (s *Store) renameOnService(meta *BlockMeta, newKey BlockKey) error {
+	data, err := s.readPayload(context.Background(), meta, migrationIO)
+	if err != nil {
+		return err
+	}
+	s.throttle(meta.Tier, migrationIO, len(data))
+	dtype, shape, oldKey := meta.DTypeStr, meta.Shape, meta.Key
+	if err := remoteDo(s, func() error { return s.remote.Put(newKey, dtype, shape, data) }); err != nil {
+		return err
+	}
+	return remoteDo(s, func() error { return s.remote.Delete(oldKey) })
+}
+
+// BindSession attaches a stable session (conversation) ID to a runner
+// slot. Blocks the session cached earlier — under whatever slot it used
+// then — are moved to seq, and blocks of a different session currently
+// occupying seq are parked under a reserved ID until that session is
+// bound again. The registry is persisted, so sessions survive restarts
+// even though Ollama reuses slot IDs across unrelated requests. Blocks
+// still queued by PutAsync are written first, so each lands with the
+// session that put it.
+func (s *Store) BindSession(id string, seq int) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if id == "" {
+		return fmt.Errorf("diskstore: empty session id")
+	}
+	if seq >= parkedSeqBase {
+		return fmt.Errorf("diskstore: seq %d is in the reserved range", seq)
+	}
+
+	s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	prev, bound := s.sessions[id]
+	if bound && prev == seq {
+		return nil
+	}
+
+	switch {
+	case s.sessionAt(seq) != "":
+		// Another session owns the slot: park its blocks.
+		parked := s.nextParked
+		s.nextParked++
+		if _, err := s.renameSeq(seq, parked); err != nil {
+			return err
+		}
+	case bound && s.hasSeq(seq):
+		// Anonymous leftovers from an unrelated request.
+		s.dropSeq(seq)
+	}
+	// A session bound for the first time adopts any anonymous blocks in
+	// the slot: they are from the conversation being named.
+
+	if bound {
+		if _, err := s.renameSeq(prev, seq); err != nil {
+			return err
+		}
+	}
+	s.sessions[id] = seq
+	return s.saveSessions()
+}
+
+// UnbindSession detaches a session from its slot, parking its blocks so
+// the slot can be reused. The session can be bound again later. Blocks
+// still queued by PutAsync are written, and parked, first.
+func (s *Store) UnbindSession(id string) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sessions[id]
+	if !ok || seq >= parkedSeqBase {
+		return nil
+	}
+	parked := s.nextParked
+	s.nextParked++
+	if _, err := s.renameSeq(seq, parked); err != nil {
+		return err
+	}
+	return s.saveSessions()
+}
+
+// RemoveSession deletes a session and all of its blocks, those still
+// queued by PutAsync included.
+func (s *Store) RemoveSession(id string) (int, error) {
+	if err := s.writable(); err != nil {
+		return 0, err
+	}
+	s.Flush()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq, ok := s.sessions[id]
+	if !ok {
+		return 0, nil
+	}
+	delete(s.sessions, id)
+	delete(s.pinned, seq)
+	n := s.dropSeq(seq)
+	return n, s.saveSessions()
+}
+
+// SessionSeq returns the sequence ID holding a session's blocks. IDs at or
+// above the parked range mean the session is not bound to a slot.
+func (s *Store) SessionSeq(id string) (int, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	seq, ok := s.sessions[id]
+	return seq, ok
+}
+
+// SessionInfo describes one registered session.
+type SessionInfo struct {
+	ID     string `json:"id"`
+	Seq    int    `json:"seq"`
+	Parked bool   `json:"parked"`
+}
+
+// Sessions lists the registered sessions, sorted by ID.
+func (s *Store) Sessions() []SessionInfo {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	out := make([]SessionInfo, 0, len(s.sessions))
+	for id, seq := range s.sessions {
+		out = append(out, SessionInfo{ID: id, Seq: seq, Parked: seq >= parkedSeqBase})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].ID < out[j].ID })
+	return out
+}
+
+// sessionAt returns the session bound to seq, if any.
+// Must be called with s.mu held.
+func (s *Store) sessionAt(seq int) string {
+	for id, sq := range s.sessions {
+		if sq == seq {
+			return id
+		}
+	}
+	return ""
+}
+
+// dropSeq deletes every block of seq without raising an expiry event,
+// leaving the payloads of remote blocks to the reaper (see reaper.go).
+// Must be called with s.mu held.
+func (s *Store) dropSeq(seq int) int {
+	s.unpublishSeq(seq)
+	return s.dropBlocks(func(meta *BlockMeta) bool { return meta.Key.Seq == seq })
+}
+
+// dropBlocks removes the blocks match selects and returns how many. Must
+// be called with s.mu held.
+func (s *Store) dropBlocks(match func(*BlockMeta) bool) int {
+	var n int
+	for k, meta := range s.index {
+		if !match(meta) {
+			continue
+		}
+		if s.reaps(meta) {
+			s.tombstone(meta)
+		} else if err := s.removePayload(meta); err != nil {
+			s.log.Warn("remove block", "key", meta.Key, "tier", meta.Tier, "error", err)
+		}
+		s.releaseUsage(meta, int64(storedSize(meta)))
+		delete(s.index, k)
+		s.indexChanged(meta.Key)
+		s.emit(EventRemove, meta)
+		n++
+	}
+	return n
+}
+
+func (s *Store) sessionsPath() string {
+	return filepath.Join(s.localPath, "sessions.json")
+}
+
+// saveSessions persists the registry. Must be called with s.mu held.
+func (s *Store) saveSessions() error {
+	data, err := json.MarshalIndent(sessionFile{Sessions: s.sessions, NextParked: s.nextParked}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("diskstore: encode sessions: %w", err)
+	}
+	if err := os.WriteFile(s.sessionsPath(), data, 0644); err != nil {
+		s.log.Error("write sessions", "path", s.sessionsPath(), "error", err)
+		return fmt.Errorf("diskstore: write sessions: %w", err)
+	}
+	return nil
+}
+
+func (s *Store) loadSessions() {
+	s.sessions = make(map[string]int)
+	s.nextParked = parkedSeqBase
+
+	data, err := os.ReadFile(s.sessionsPath())
+	if err != nil {
+		if !errors.Is(err, os.ErrNotExist) {
+			s.log.Warn("read sessions, starting empty", "path", s.sessionsPath(), "error", err)
+		}
+		return
+	}
+	var f sessionFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		s.log.Warn("decode sessions, starting empty", "path", s.sessionsPath(), "error", err)
+		return
+	}
+	if f.Sessions != nil {
+		s.sessions = f.Sessions
+	}
+	if f.NextParked > s.nextParked {
+		s.nextParked = f.NextParked
+	}
+}
@@ -0,0 +1,106 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"path/filepath"
+	"testing"
+)
+
+func TestSessionBindAndPark(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	alice := []byte("alice's conversation")
+	bob := []byte("bob's conversation")
+	key := func(seq int) BlockKey {
+		return BlockKey{Seq: seq, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	}
+
+	// Alice's blocks are written to slot 0 before the session is named.
+	store.Put(key(0), "f16", []int{4}, alice)
+	if err := store.BindSession("alice", 0); err != nil {
+		t.Fatalf("BindSession alice: %v", err)
+	}
+
+	// Bob takes over slot 0: alice's blocks must be parked, not lost.
+	if err := store.BindSession("bob", 0); err != nil {
+		t.Fatalf("BindSession bob: %v", err)
+	}
+	if _, _, err := store.Get(key(0)); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("slot 0 still holds alice's data after rebind (err %v)", err)
+	}
+	seq, ok := store.SessionSeq("alice")
+	if !ok || seq < parkedSeqBase {
+		t.Fatalf("alice: got seq %d (bound=%v), want parked", seq, ok)
+	}
+	store.Put(key(0), "f16", []int{4}, bob)
+
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// After a restart alice comes back on slot 2 and finds her blocks.
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+
+	if err := store.BindSession("alice", 2); err != nil {
+		t.Fatalf("BindSession alice again: %v", err)
+	}
+	data, _, err := store.Get(key(2))
+	if err != nil || !bytes.Equal(data, alice) {
+		t.Fatalf("alice on slot 2: got %q, %v", data, err)
+	}
+	data, _, err = store.Get(key(0))
+	if err != nil || !bytes.Equal(data, bob) {
+		t.Fatalf("bob on slot 0: got %q, %v", data, err)
+	}
+
+	sessions := store.Sessions()
+	if len(sessions) != 2 || sessions[0].ID != "alice" || sessions[0].Seq != 2 || sessions[1].Parked {
+		t.Errorf("Sessions: got %+v", sessions)
+	}
+
+	if n, err := store.RemoveSession("bob"); err != nil || n != 1 {
+		t.Errorf("RemoveSession: got %d, %v", n, err)
+	}
+	if _, ok := store.SessionSeq("bob"); ok {
+		t.Errorf("bob still registered after RemoveSession")
+	}
+}
+
+func TestRenameSeqRefusesMerge(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for _, seq := range []int{1, 2} {
+		key := BlockKey{Seq: seq, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+		store.Put(key, "f16", []int{4}, make([]byte, 8))
+	}
+	if _, err := store.RenameSeq(1, 2); err == nil {
+		t.Fatal("RenameSeq into a non-empty seq succeeded")
+	}
+	if n, err := store.RenameSeq(1, 3); err != nil || n != 1 {
+		t.Fatalf("RenameSeq(1, 3): got %d, %v", n, err)
+	}
+	if len(store.Blocks(1)) != 0 || len(store.Blocks(3)) != 1 {
+		t.Errorf("blocks not moved: seq1=%d seq3=%d", len(store.Blocks(1)), len(store.Blocks(3)))
+	}
+}
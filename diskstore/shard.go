@@ -0,0 +1,216 @@
+package diskstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+)
+
+// Directory sharding: the blocks of a namespace are spread over shard
+// subdirectories of its directory on each tier, so no one directory
+// holds them all. ShardSeq, the default and the layout of every store
+// from before the scheme could be chosen, puts a sequence's blocks in
+// one of 256 directories by Seq%256 (prefix-addressed blocks by the
+// first two characters of their prefix), which leaves a long
+// conversation, hundreds of thousands of blocks, in a single directory.
+// ShardHash spreads blocks over 256 directories by a hash of their
+// sequence, layer and positions (their file name), and ShardHash2 over
+// 256×256 in two levels, for stores of tens of millions of blocks.
+//
+// layout.json in the local tier records the scheme; a store without one
+// is in ShardSeq. New with another Config.Sharding than the recorded
+// one lays the store out again: it records the move in layout.json
+// first, then renames every block file to its new path on its tier, so
+// a move cut short by a crash is finished by the next writable New.
+// Blocks in archive bundles or on a RemoteTier service aren't files of
+// the store's own and stay where they are; object tiers always name
+// blocks by ShardSeq. A read-only store reads in the recorded scheme.
+
+// Shard schemes for Config.Sharding.
+const (
+	ShardSeq   = "seq"   // Seq%256, or the prefix's first two characters
+	ShardHash  = "hash"  // 256 directories by a hash of the file name
+	ShardHash2 = "hash2" // 256×256 directories in two levels
+)
+
+const layoutFile = "layout.json"
+
+// layout is the content of layout.json. From is set while the store is
+// being moved from that scheme to Sharding.
+type layout struct {
+	Sharding string `json:"sharding"`
+	From     string `json:"from,omitempty"`
+}
+
+func validShardScheme(scheme string) bool {
+	switch scheme {
+	case ShardSeq, ShardHash, ShardHash2:
+		return true
+	}
+	return false
+}
+
+// shard is the subdirectory of a tier's namespace directory holding k's
+// file under scheme.
+func (k BlockKey) shard(scheme string) string {
+	switch scheme {
+	case ShardHash, ShardHash2:
+		h := fnv.New32a()
+		h.Write([]byte(k.fileName()))
+		sum := h.Sum32()
+		if scheme == ShardHash {
+			return fmt.Sprintf("%02x", sum&0xff)
+		}
+		return filepath.Join(fmt.Sprintf("%02x", sum&0xff), fmt.Sprintf("%02x", sum>>8&0xff))
+	}
+	if len(k.Prefix) >= 2 {
+		return k.Prefix[:2]
+	}
+	return fmt.Sprintf("%02x", k.Seq%256)
+}
+
+func (s *Store) layoutPath() string {
+	return filepath.Join(s.localPath, layoutFile)
+}
+
+// readLayout returns the store's recorded layout, ShardSeq if it has
+// none.
+func (s *Store) readLayout() (layout, error) {
+	l := layout{Sharding: ShardSeq}
+	data, err := os.ReadFile(s.layoutPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return l, nil
+	}
+	if err == nil {
+		err = json.Unmarshal(data, &l)
+	}
+	if err == nil && (!validShardScheme(l.Sharding) || l.From != "" && !validShardScheme(l.From)) {
+		err = fmt.Errorf("unknown shard scheme %q", l.Sharding)
+	}
+	if err != nil {
+		return l, fmt.Errorf("diskstore: read %s: %w", s.layoutPath(), err)
+	}
+	return l, nil
+}
+
+func (s *Store) writeLayout(l layout) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err == nil {
+		err = replaceFile(s.layoutPath(), data)
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: write %s: %w", s.layoutPath(), err)
+	}
+	return nil
+}
+
+// openLayout sets the store's shard scheme from layout.json, before the
+// index is loaded.
+func (s *Store) openLayout() (layout, error) {
+	l, err := s.readLayout()
+	if err != nil {
+		return l, err
+	}
+	s.sharding, s.shardingFrom = l.Sharding, l.Sharding
+	if l.From != "" {
+		s.shardingFrom = l.From
+	}
+	return l, nil
+}
+
+// reshard finishes a move the layout records and then moves the store
+// to scheme, if set and not the store's. Called by New once the index
+// is loaded, before anything else uses the store.
+func (s *Store) reshard(l layout, scheme string) error {
+	if s.readOnly {
+		if scheme != "" && scheme != l.Sharding {
+			s.log.Warn("read-only store keeps its shard scheme", "sharding", l.Sharding, "want", scheme)
+		}
+		return nil
+	}
+	if l.From != "" {
+		if err := s.moveShards(l.From, l.Sharding); err != nil {
+			return err
+		}
+	}
+	if scheme == "" || scheme == l.Sharding {
+		return nil
+	}
+	s.shardingFrom = l.Sharding
+	return s.moveShards(l.Sharding, scheme)
+}
+
+// moveShards renames every block file from its path under scheme from to
+// its path under scheme to, then records to as the store's scheme.
+// Files already moved, or missing (left to GC), are passed over.
+func (s *Store) moveShards(from, to string) error {
+	if err := s.writeLayout(layout{Sharding: to, From: from}); err != nil {
+		return err
+	}
+	s.log.Info("moving blocks to new shard directories", "from", from, "to", to)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.sharding = to
+	type place struct {
+		tier   string
+		stripe int
+	}
+	var moved, failed int
+	var firstErr error
+	oldDirs := make(map[string]bool)
+	move := func(key BlockKey, p place) {
+		old, cur := s.shardPath(key, p.tier, p.stripe, from), s.shardPath(key, p.tier, p.stripe, to)
+		if old == cur {
+			return
+		}
+		if _, err := os.Lstat(cur); err == nil {
+			return
+		}
+		if _, err := os.Lstat(old); errors.Is(err, os.ErrNotExist) {
+			return
+		}
+		err := os.MkdirAll(filepath.Dir(cur), 0755)
+		if err == nil {
+			err = renameFile(old, cur)
+		}
+		if err != nil {
+			if failed++; firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		moved++
+		oldDirs[filepath.Dir(old)] = true
+	}
+	for _, meta := range s.index {
+		if meta.Bundle == nil && !s.onService(meta.Tier) {
+			move(meta.Key, place{meta.Tier, meta.Stripe})
+		}
+		if meta.Replica && s.remotePath != "" && meta.Tier != "remote" {
+			move(meta.Key, place{"remote", 0})
+		}
+	}
+	// Shard directories left empty go, the upper level of two too.
+	for dir := range oldDirs {
+		if os.Remove(dir) == nil && from == ShardHash2 {
+			os.Remove(filepath.Dir(dir))
+		}
+	}
+	if failed > 0 {
+		s.log.Error("move blocks to new shard directories", "moved", moved, "failed", failed, "error", firstErr)
+		return fmt.Errorf("diskstore: move %d blocks to %s shard directories: %w", failed, to, firstErr)
+	}
+	s.log.Info("moved blocks to new shard directories", "sharding", to, "moved", moved)
+	return s.writeLayout(layout{Sharding: to})
+}
+
+// Sharding returns the shard scheme the store was laid out in when it
+// was opened and the one it is laid out in now, which differ if New
+// moved it to Config.Sharding.
+func (s *Store) Sharding() (from, to string) {
+	return s.shardingFrom, s.sharding
+}
@@ -0,0 +1,134 @@
+package diskstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSharding(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	if from, to := store.Sharding(); from != ShardSeq || to != ShardSeq {
+		t.Errorf("new store sharded %s -> %s, want seq", from, to)
+	}
+	var keys []BlockKey
+	for layer := range 32 {
+		keys = append(keys, BlockKey{Seq: 7, Layer: layer, EndPos: 16, IsKey: true})
+	}
+	keys = append(keys,
+		BlockKey{Namespace: "other", Seq: 1, EndPos: 16},
+		PrefixKey("", ChainPrefixHash("", []int32{1, 2}), 0, 0, 16, true))
+	for _, key := range keys {
+		if err := store.Put(key, "f16", []int{8}, make([]byte, 64)); err != nil {
+			t.Fatalf("Put %s: %v", key, err)
+		}
+	}
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	store.Close()
+
+	// Every file is moved to its hash2 path, one sequence's blocks spread
+	// over several directories.
+	cfg.Sharding = ShardHash2
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New with hash2: %v", err)
+	}
+	if from, to := store.Sharding(); from != ShardSeq || to != ShardHash2 {
+		t.Errorf("Sharding = %s -> %s, want seq -> hash2", from, to)
+	}
+	dirs := make(map[string]bool)
+	for _, key := range keys {
+		meta, ok := store.index[key.String()]
+		if !ok {
+			t.Fatalf("%s not indexed", key)
+		}
+		path := store.metaPath(meta)
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+		if key.Seq == 7 {
+			dirs[filepath.Dir(path)] = true
+		}
+		if _, _, err := store.Get(key); err != nil {
+			t.Errorf("Get %s: %v", key, err)
+		}
+	}
+	if len(dirs) < 8 {
+		t.Errorf("32 blocks of one sequence in %d directories", len(dirs))
+	}
+	if _, err := os.Stat(filepath.Join(cfg.LocalPath, "07")); !os.IsNotExist(err) {
+		t.Errorf("old shard directory left behind: %v", err)
+	}
+	res, err := store.GC()
+	if err != nil || res.MissingBlocks != 0 || res.OrphanFiles != 0 {
+		t.Errorf("GC = %+v, %v; want nothing missing or orphaned", res, err)
+	}
+	store.Close()
+
+	// The recorded scheme is kept without one configured, and by a
+	// read-only store configured with another.
+	cfg.Sharding = ""
+	ro := cfg
+	ro.ReadOnly, ro.Sharding = true, ShardSeq
+	for _, c := range []Config{cfg, ro} {
+		store, err = New(c)
+		if err != nil {
+			t.Fatalf("New: %v", err)
+		}
+		if from, to := store.Sharding(); from != ShardHash2 || to != ShardHash2 {
+			t.Errorf("reopened (read-only %v) as %s -> %s, want hash2", c.ReadOnly, from, to)
+		}
+		if _, _, err := store.Get(keys[0]); err != nil {
+			t.Errorf("Get after reopening: %v", err)
+		}
+		store.Close()
+	}
+
+	// A move cut short is finished by the next New: half the files are
+	// moved to their hash paths before it stopped.
+	store, _ = New(cfg)
+	for _, key := range keys[:16] {
+		meta := store.index[key.String()]
+		from, to := store.shardPath(key, meta.Tier, 0, ShardHash2), store.shardPath(key, meta.Tier, 0, ShardHash)
+		os.MkdirAll(filepath.Dir(to), 0755)
+		if err := os.Rename(from, to); err != nil {
+			t.Fatal(err)
+		}
+	}
+	store.Close()
+	os.WriteFile(filepath.Join(cfg.LocalPath, layoutFile), []byte(`{"sharding": "hash", "from": "hash2"}`), 0644)
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("New after an interrupted move: %v", err)
+	}
+	defer store.Close()
+	if from, to := store.Sharding(); from != ShardHash2 || to != ShardHash {
+		t.Errorf("Sharding = %s -> %s, want hash2 -> hash", from, to)
+	}
+	for _, key := range keys {
+		if _, _, err := store.Get(key); err != nil {
+			t.Errorf("Get %s: %v", key, err)
+		}
+	}
+	if l, err := store.readLayout(); err != nil || l != (layout{Sharding: ShardHash}) {
+		t.Errorf("layout = %+v, %v; want the move recorded as done", l, err)
+	}
+
+	cfg.Sharding = "bogus"
+	if _, err := New(cfg); err == nil {
+		t.Error("New with an unknown shard scheme succeeded")
+	}
+}
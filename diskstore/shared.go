@@ -0,0 +1,90 @@
+package diskstore
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+)
+
+// Shared stores: OpenShared hands out one Store per local tier directory
+// in the process, counting its users, so several caches (say, the
+// TieredCausal of each model a process serves) don't each open, index and
+// write back the same directory. Two Stores over one directory would keep
+// separate indexes and budgets and overwrite each other's index file.
+
+var sharedStores = struct {
+	sync.Mutex
+	m map[string]*sharedStore
+}{m: make(map[string]*sharedStore)}
+
+// sharedStore is a registry entry and what later opens must agree with.
+type sharedStore struct {
+	store       *Store
+	refs        int
+	remote      string
+	remoteTier  bool
+	fingerprint string
+}
+
+// OpenShared returns the Store whose local tier is path (with
+// NamespaceByModel, the model's subdirectory of it), opening it with cfg
+// the first time. cfg.LocalPath is ignored. Later callers get the same
+// Store and their other settings are ignored, except that they must agree
+// on the remote tier and the fingerprint. Every caller closes the Store
+// once; the last Close closes it.
+func OpenShared(path string, cfg Config) (*Store, error) {
+	cfg.LocalPath = path
+	local, remote := cfg.dirs()
+	key, err := filepath.Abs(local)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: open shared %s: %w", local, err)
+	}
+	var fp string
+	if cfg.Fingerprint != nil {
+		fp = cfg.Fingerprint.ID()
+	}
+
+	sharedStores.Lock()
+	defer sharedStores.Unlock()
+
+	if e, ok := sharedStores.m[key]; ok {
+		switch {
+		case e.remote != remote || e.remoteTier != (cfg.RemoteTier != nil):
+			return nil, fmt.Errorf("diskstore: %s is already open with another remote tier", local)
+		case e.fingerprint != fp:
+			return nil, fmt.Errorf("diskstore: %s is already open for model %q, not %q", local, e.fingerprint, fp)
+		}
+		e.refs++
+		return e.store, nil
+	}
+
+	s, err := New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s.shared = key
+	sharedStores.m[key] = &sharedStore{
+		store:       s,
+		refs:        1,
+		remote:      remote,
+		remoteTier:  cfg.RemoteTier != nil,
+		fingerprint: fp,
+	}
+	return s, nil
+}
+
+// releaseShared drops a user of the shared store at key and reports
+// whether it was the last one.
+func releaseShared(key string) bool {
+	sharedStores.Lock()
+	defer sharedStores.Unlock()
+	e, ok := sharedStores.m[key]
+	if !ok {
+		return false // closed already
+	}
+	if e.refs--; e.refs > 0 {
+		return false
+	}
+	delete(sharedStores.m, key)
+	return true
+}
@@ -0,0 +1,67 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestOpenShared(t *testing.T) {
+	dir := t.TempDir()
+	fp := &Fingerprint{ModelDigest: "m1", NLayers: 2, NKVHeads: 2, HeadDim: 4, DType: "f16"}
+	cfg := Config{LocalBudget: 1 << 20, StatsInterval: -1, Fingerprint: fp}
+
+	a, err := OpenShared(dir, cfg)
+	if err != nil {
+		t.Fatalf("OpenShared: %v", err)
+	}
+	b, err := OpenShared(filepath.Join(dir, "."), cfg)
+	if err != nil {
+		t.Fatalf("OpenShared again: %v", err)
+	}
+	if a != b {
+		t.Fatal("second OpenShared of a directory returned another Store")
+	}
+
+	other := *fp
+	other.ModelDigest = "m2"
+	if _, err := OpenShared(dir, Config{LocalBudget: 1 << 20, Fingerprint: &other}); err == nil {
+		t.Error("OpenShared accepted another model's fingerprint")
+	}
+	if _, err := OpenShared(dir, Config{LocalBudget: 1 << 20, RemotePath: t.TempDir(), Fingerprint: fp}); err == nil {
+		t.Error("OpenShared accepted another remote tier")
+	}
+
+	// With NamespaceByModel each model gets its own directory, so its own
+	// Store.
+	byModel := cfg
+	byModel.NamespaceByModel = true
+	m1, err := OpenShared(dir, byModel)
+	if err != nil {
+		t.Fatalf("OpenShared by model: %v", err)
+	}
+	defer m1.Close()
+	if m1 == a {
+		t.Error("model subdirectory shares the top-level Store")
+	}
+
+	// The first Close leaves the store open for the other user.
+	a.Close()
+	key := BlockKey{Seq: 1, EndPos: 2, IsKey: true}
+	if err := b.Put(key, "f16", []int{4}, make([]byte, 16)); err != nil {
+		t.Fatalf("Put after the first Close: %v", err)
+	}
+	b.Close()
+
+	// Reopened after the last Close, from its saved index.
+	c, err := OpenShared(dir, cfg)
+	if err != nil {
+		t.Fatalf("OpenShared after Close: %v", err)
+	}
+	defer c.Close()
+	if c == a {
+		t.Error("OpenShared returned a closed Store")
+	}
+	if !c.Has(key) {
+		t.Error("block put before the last Close is missing")
+	}
+}
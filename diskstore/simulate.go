@@ -0,0 +1,200 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	mrand "math/rand/v2"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Simulation: before patching a production runner, Simulate estimates
+// what tiering would save on a workload by replaying it (see Replay)
+// against a store with the budgets under consideration in a temporary
+// directory. The workload is a recorded trace or a synthetic one that
+// Workload generates the way the patched runner would: conversations
+// that each store their turns' blocks per layer and, when a turn finds
+// the conversation's cache gone from VRAM, read the stored context back.
+// The store moves blocks between tiers but never drops one to fit a
+// budget, so reads hit as long as nothing removes their blocks: budgets
+// show in the split of LocalHits and RemoteHits and in the usage over
+// time. Every block is written for real, so a simulation takes as much
+// disk I/O as its writes; scale the model and budgets down together to
+// run it faster.
+
+// Workload describes a synthetic workload. Zero fields take the
+// defaults noted.
+type Workload struct {
+	// Conversations is how many conversations run (default 100), at
+	// most Concurrency at a time (default 4), with Turns turns on
+	// average (default 5).
+	Conversations int
+	Concurrency   int
+	Turns         int
+
+	// TurnTokens is the average positions a turn adds, prompt and reply
+	// (default 500). A conversation ends at ContextSize positions
+	// (default 8192).
+	TurnTokens  int
+	ContextSize int
+
+	// Resume is the share of turns after the first whose conversation's
+	// cache has left VRAM, so its context is read from the store
+	// (default 0.5).
+	Resume float64
+
+	// Gap is the time between turns of one conversation (default 30s).
+	Gap time.Duration
+
+	// Layers (default 32), BlockSize positions per block (default 256)
+	// and PositionBytes, the bytes of one position of one layer's keys
+	// or values (default 2048: 8 KV heads of 128 f16), shape the blocks.
+	Layers        int
+	BlockSize     int
+	PositionBytes int
+
+	// Seed seeds the generator, so a workload can be run again against
+	// other settings.
+	Seed uint64
+}
+
+func (w Workload) withDefaults() Workload {
+	def := func(v *int, d int) {
+		if *v <= 0 {
+			*v = d
+		}
+	}
+	def(&w.Conversations, 100)
+	def(&w.Concurrency, 4)
+	def(&w.Turns, 5)
+	def(&w.TurnTokens, 500)
+	def(&w.ContextSize, 8192)
+	def(&w.Layers, 32)
+	def(&w.BlockSize, 256)
+	def(&w.PositionBytes, 2048)
+	if w.Resume <= 0 {
+		w.Resume = 0.5
+	}
+	if w.Gap <= 0 {
+		w.Gap = 30 * time.Second
+	}
+	return w
+}
+
+// simConv is a conversation of a Workload.
+type simConv struct {
+	seq       int
+	pos       int32
+	turnsLeft int
+	blocks    [][2]int32 // position ranges stored
+}
+
+// WorkloadOps generates the operations of a Workload.
+type WorkloadOps struct {
+	w       Workload
+	rng     *mrand.Rand
+	clock   time.Time
+	started int
+	active  []*simConv
+	buf     []TraceOp
+}
+
+// Ops returns a TraceSource of w's operations.
+func (w Workload) Ops() *WorkloadOps {
+	return &WorkloadOps{
+		w:     w.withDefaults(),
+		rng:   mrand.New(mrand.NewPCG(w.Seed, w.Seed^0x9e3779b97f4a7c15)),
+		clock: time.Unix(0, 0),
+	}
+}
+
+// Next returns the next operation, or io.EOF after the last.
+func (g *WorkloadOps) Next() (TraceOp, error) {
+	for len(g.buf) == 0 {
+		for len(g.active) < g.w.Concurrency && g.started < g.w.Conversations {
+			turns := 1
+			for g.rng.Float64() > 1/float64(g.w.Turns) {
+				turns++
+			}
+			g.active = append(g.active, &simConv{seq: g.started, turnsLeft: turns})
+			g.started++
+		}
+		if len(g.active) == 0 {
+			return TraceOp{}, io.EOF
+		}
+		i := g.rng.IntN(len(g.active))
+		if g.turn(g.active[i]) {
+			g.active = append(g.active[:i], g.active[i+1:]...)
+		}
+	}
+	op := g.buf[0]
+	g.buf = g.buf[1:]
+	return op, nil
+}
+
+// turn queues the operations of c's next turn and reports whether c has
+// ended.
+func (g *WorkloadOps) turn(c *simConv) bool {
+	w := g.w
+	g.clock = g.clock.Add(w.Gap / time.Duration(w.Concurrency))
+	op := func(kind int, layer int, isKey bool, r [2]int32) TraceOp {
+		return TraceOp{
+			Op:    kind,
+			At:    g.clock,
+			Key:   BlockKey{Seq: c.seq, Layer: layer, BeginPos: r[0], EndPos: r[1], IsKey: isKey},
+			DType: "f16",
+			Size:  int(r[1]-r[0]) * w.PositionBytes,
+		}
+	}
+	each := func(kind int, blocks [][2]int32) {
+		for _, r := range blocks {
+			for layer := range w.Layers {
+				g.buf = append(g.buf, op(kind, layer, true, r), op(kind, layer, false, r))
+			}
+		}
+	}
+
+	if c.pos > 0 && g.rng.Float64() < w.Resume {
+		each(TraceGet, c.blocks)
+	}
+	n := int32(w.TurnTokens/2 + g.rng.IntN(w.TurnTokens+1))
+	end := min(c.pos+n, int32(w.ContextSize))
+	var added [][2]int32
+	bs := int32(w.BlockSize)
+	for begin := c.pos; begin < end; {
+		stop := min((begin/bs+1)*bs, end)
+		added = append(added, [2]int32{begin, stop})
+		begin = stop
+	}
+	each(TracePut, added)
+	c.blocks = append(c.blocks, added...)
+	c.pos = end
+	c.turnsLeft--
+	return c.turnsLeft == 0 || c.pos >= int32(w.ContextSize)
+}
+
+// Simulate replays src against a new store with cfg's settings in
+// temporary directories, which it removes afterwards: cfg's LocalPath is
+// ignored, and the store has a remote tier if cfg.RemoteBudget is set.
+func Simulate(ctx context.Context, cfg Config, src TraceSource, opts ReplayOptions) (ReplayResult, error) {
+	dir, err := os.MkdirTemp("", "kvtier-sim-")
+	if err != nil {
+		return ReplayResult{}, fmt.Errorf("diskstore: simulate: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	cfg.LocalPath, cfg.RemotePath = filepath.Join(dir, "local"), ""
+	if cfg.RemoteBudget > 0 {
+		cfg.RemotePath = filepath.Join(dir, "remote")
+	}
+	cfg.StatsInterval, cfg.TracePath = -1, ""
+
+	s, err := New(cfg)
+	if err != nil {
+		return ReplayResult{}, err
+	}
+	res, err := Replay(ctx, s, src, opts)
+	return res, errors.Join(err, s.Close())
+}
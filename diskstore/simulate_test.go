@@ -0,0 +1,89 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+)
+
+func TestWorkloadOps(t *testing.T) {
+	w := Workload{Conversations: 3, Concurrency: 2, TurnTokens: 300, ContextSize: 1000, Resume: 1, Layers: 2, PositionBytes: 4, Seed: 7}
+	collect := func() []TraceOp {
+		var ops []TraceOp
+		src := w.Ops()
+		for {
+			op, err := src.Next()
+			if errors.Is(err, io.EOF) {
+				return ops
+			}
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			ops = append(ops, op)
+		}
+	}
+	ops := collect()
+	if len(ops) == 0 {
+		t.Fatal("no operations")
+	}
+	stored := make(map[BlockKey]bool)
+	seqs := make(map[int]bool)
+	for _, op := range ops {
+		seqs[op.Key.Seq] = true
+		switch op.Op {
+		case TracePut:
+			if op.Key.EndPos > 1000 || op.Key.BeginPos/256 != (op.Key.EndPos-1)/256 {
+				t.Errorf("put %s crosses a block or the context", op.Key)
+			}
+			if op.Size != int(op.Key.EndPos-op.Key.BeginPos)*4 {
+				t.Errorf("put %s of %d bytes", op.Key, op.Size)
+			}
+			stored[op.Key] = true
+		case TraceGet:
+			if !stored[op.Key] {
+				t.Errorf("get %s before its put", op.Key)
+			}
+		default:
+			t.Errorf("unexpected op %d", op.Op)
+		}
+	}
+	if len(seqs) != 3 {
+		t.Errorf("%d conversations, want 3", len(seqs))
+	}
+
+	again := collect()
+	if len(again) != len(ops) || again[len(again)-1] != ops[len(ops)-1] {
+		t.Error("the same seed generated another workload")
+	}
+}
+
+func TestSimulate(t *testing.T) {
+	w := Workload{Conversations: 8, Concurrency: 2, Turns: 3, TurnTokens: 200, Resume: 1, Layers: 2, PositionBytes: 16, Seed: 1}
+
+	res, err := Simulate(context.Background(), Config{LocalBudget: 1 << 30}, w.Ops(), ReplayOptions{SampleEvery: time.Minute})
+	if err != nil {
+		t.Fatalf("Simulate: %v", err)
+	}
+	if res.Gets == 0 || res.Hits != res.Gets || res.ReadPositions == 0 || res.HitPositions != res.ReadPositions {
+		t.Errorf("ample budget: %d/%d reads, %d/%d positions served", res.Hits, res.Gets, res.HitPositions, res.ReadPositions)
+	}
+	if len(res.Usage) < 2 || res.Usage[len(res.Usage)-1].LocalBytes == 0 {
+		t.Errorf("usage samples = %+v", res.Usage)
+	}
+	if want := time.Duration(res.HitPositions) * time.Millisecond; res.PrefillSaved(1000) != want {
+		t.Errorf("PrefillSaved = %v, want %v", res.PrefillSaved(1000), want)
+	}
+
+	// A local budget for a fraction of the workload leaves the reads of
+	// older turns to the remote tier.
+	tight, err := Simulate(context.Background(), Config{LocalBudget: 8 << 10, RemoteBudget: 1 << 30}, w.Ops(), ReplayOptions{})
+	if err != nil {
+		t.Fatalf("Simulate: %v", err)
+	}
+	if tight.Gets != res.Gets || tight.Hits != res.Hits || tight.Evictions == 0 || tight.RemoteHits == 0 || res.RemoteHits != 0 {
+		t.Errorf("tight local budget: %d/%d reads, %d remote, %d evictions; want the evicted blocks read remotely",
+			tight.Hits, tight.Gets, tight.RemoteHits, tight.Evictions)
+	}
+}
@@ -0,0 +1,33 @@
+package diskstore
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Sidecar store: cmd/kvcached runs a store of its own and serves it to
+// the runner on the same machine over a Unix domain socket, with the
+// block service protocol (see remote.go), so the blocks outlive the
+// runner and the daemon can be restarted or upgraded while the runner
+// keeps going, as a remote tier that is away for a while (see
+// degraded.go). The runner reaches it with a RemoteClient whose requests
+// go to the socket rather than a TCP address: Put, Get and GetRange, and
+// the rest of Tier, are then local RPCs.
+
+// NewSocketClient returns a client for a block service listening on the
+// Unix domain socket at path, such as cmd/kvcached. Requests time out
+// after timeout (default 30s).
+func NewSocketClient(path string, timeout time.Duration) *RemoteClient {
+	// The host is only a placeholder: every connection goes to path.
+	c := NewRemoteClient("http://kvcached", timeout)
+	var d net.Dialer
+	c.client.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return d.DialContext(ctx, "unix", path)
+		},
+		MaxIdleConnsPerHost: 16,
+	}
+	return c
+}
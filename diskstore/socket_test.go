@@ -0,0 +1,74 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestSocketClient(t *testing.T) {
+	dir := t.TempDir()
+	daemon, err := New(Config{LocalPath: filepath.Join(dir, "daemon"), LocalBudget: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("New daemon: %v", err)
+	}
+	defer daemon.Close()
+	sock := filepath.Join(dir, "kv.sock")
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("no Unix domain sockets: %v", err)
+	}
+	srv := &http.Server{Handler: daemon.BlockServiceHandler()}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	c := NewSocketClient(sock, time.Second)
+	if err := c.Ping(context.Background()); err != nil {
+		t.Fatalf("Ping: %v", err)
+	}
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 4, IsKey: true}
+	data := []byte("kv over a socket")
+	if err := c.Put(key, "f16", []int{4, 4}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, _, err := c.Get(key)
+	if err != nil || !bytes.Equal(got, data) {
+		t.Fatalf("Get: got %q, %v", got, err)
+	}
+	if r := c.GetRange(1, 0, true, 2, 8); len(r) != 1 || r[0].Key != key {
+		t.Errorf("GetRange: got %+v", r)
+	}
+
+	// A runner's store keeps its blocks in the daemon once they leave
+	// its own small local tier.
+	runner, err := New(Config{
+		LocalPath:    filepath.Join(dir, "runner"),
+		LocalBudget:  40,
+		RemoteBudget: 1024 * 1024,
+		RemoteTier:   c,
+	})
+	if err != nil {
+		t.Fatalf("New runner: %v", err)
+	}
+	defer runner.Close()
+	for i := range 4 {
+		k := BlockKey{Seq: 2, Layer: i, BeginPos: 0, EndPos: 4, IsKey: true}
+		if err := runner.Put(k, "f16", []int{4, 4}, bytes.Repeat([]byte{byte(i)}, 32)); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+	if st := daemon.Stats(); st.LocalBlocks == 0 {
+		t.Fatal("no blocks reached the daemon")
+	}
+	for i := range 4 {
+		k := BlockKey{Seq: 2, Layer: i, BeginPos: 0, EndPos: 4, IsKey: true}
+		got, _, err := runner.Get(k)
+		if err != nil || !bytes.Equal(got, bytes.Repeat([]byte{byte(i)}, 32)) {
+			t.Errorf("Get %d through the daemon: got %v, %v", i, got, err)
+		}
+	}
+}
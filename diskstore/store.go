@@ -3,32 +3,57 @@
 // Blocks are written to a fast local tier (SSD) first and can be promoted
 // to a slow remote tier (NFS/HDD) when the local tier fills up.
 // Data is optionally compressed with zstd before writing.
+//
+// The remote tier may be a kvblockd on another machine, served by
+// Store.BlockServiceHandler and reached with a RemoteClient. Its protocol
+// is versioned HTTP with JSON metadata rather than gRPC; the wire format
+// is described in remote.go and the README.
 package diskstore
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"maps"
 	"os"
 	"path/filepath"
 	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/klauspost/compress/zstd"
 )
 
 // BlockKey uniquely identifies an evicted KV block.
 type BlockKey struct {
-	Seq       int   `json:"seq"`        // Sequence (slot) ID
-	Layer     int   `json:"layer"`      // Transformer layer index
-	BeginPos  int32 `json:"begin_pos"`  // First token position in block
-	EndPos    int32 `json:"end_pos"`    // One-past-last token position
-	IsKey     bool  `json:"is_key"`     // true = key tensor, false = value tensor
+	Namespace string `json:"namespace,omitempty"` // Model namespace ("" = default)
+	Seq       int    `json:"seq"`                 // Sequence (slot) ID
+	Layer     int    `json:"layer"`               // Transformer layer index
+	BeginPos  int32  `json:"begin_pos"`           // First token position in block
+	EndPos    int32  `json:"end_pos"`             // One-past-last token position
+	IsKey     bool   `json:"is_key"`              // true = key tensor, false = value tensor
+
+	// Prefix, if set, addresses the block by the hash of its prompt
+	// prefix instead of by Seq (see PrefixKey).
+	Prefix string `json:"prefix,omitempty"`
 }
 
-// String returns a human-readable key for logging.
+// String returns a human-readable key for logging. Keys in a named
+// namespace are prefixed with "<namespace>/".
 func (k BlockKey) String() string {
+	if k.Namespace != "" {
+		return k.Namespace + "/" + k.fileName()
+	}
+	return k.fileName()
+}
+
+// fileName is the key without its namespace, used for block file names.
+func (k BlockKey) fileName() string {
+	if k.Prefix != "" {
+		return k.prefixFileName()
+	}
 	kv := "v"
 	if k.IsKey {
 		kv = "k"
@@ -38,14 +63,66 @@ func (k BlockKey) String() string {
 
 // BlockMeta holds metadata about a stored block, persisted alongside the data.
 type BlockMeta struct {
-	Key        BlockKey  `json:"key"`
-	DTypeStr   string    `json:"dtype"`        // e.g. "f16", "q8_0"
-	Shape      []int     `json:"shape"`        // original tensor shape
-	SizeBytes  int       `json:"size_bytes"`   // uncompressed size
-	Compressed bool      `json:"compressed"`
-	Tier       string    `json:"tier"`         // "local" or "remote"
-	StoredAt   time.Time `json:"stored_at"`
-	AccessedAt time.Time `json:"accessed_at"`
+	Key        BlockKey   `json:"key"`
+	DTypeStr   string     `json:"dtype"`      // e.g. "f16", "q8_0"
+	Shape      []int      `json:"shape"`      // original tensor shape
+	SizeBytes  int        `json:"size_bytes"` // uncompressed size
+	Compressed bool       `json:"compressed"`
+	Tier       string     `json:"tier"`                 // "local" or "remote"
+	Transforms []string   `json:"transforms,omitempty"` // Put pipeline stages, in order
+	Checksum   uint32     `json:"checksum,omitempty"`   // CRC-32C of the stored payload
+	Pinned     bool       `json:"pinned,omitempty"`     // never evicted from the local tier
+	Bundle     *BundleRef `json:"bundle,omitempty"`     // set when archived into a remote bundle
+	Stripe     int        `json:"stripe,omitempty"`     // local stripe holding the block (see stripe.go)
+	Replica    bool       `json:"replica,omitempty"`    // a local block also on the remote tier (see replicate.go)
+	StoredAt   time.Time  `json:"stored_at"`
+	AccessedAt time.Time  `json:"accessed_at"`
+	Hits       uint32     `json:"hits,omitempty"`   // reads since the block was stored
+	Recent     []uint32   `json:"recent,omitempty"` // recent reads, seconds after StoredAt, newest first
+
+	// Put pipeline statistics: the payload size after the transforms, the
+	// compression stage ("" if none) and how long the pipeline took. Zero
+	// for blocks stored before they were recorded.
+	StoredBytes int           `json:"stored_bytes,omitempty"`
+	Codec       string        `json:"codec,omitempty"`
+	EncodeTime  time.Duration `json:"encode_time,omitempty"`
+
+	// Incompressible is set when the compression stage was skipped for
+	// the block, as its dtype or a sample of its bytes showed it would
+	// barely shrink (see entropy.go).
+	Incompressible bool `json:"incompressible,omitempty"`
+
+	// Shift is how far the sequence's positions had been shifted down by
+	// context shifts when the block was stored (see PutShifted): Key
+	// holds the tokens' absolute positions, but the rows were computed,
+	// and keys rotated, for positions Shift lower.
+	Shift int32 `json:"shift,omitempty"`
+
+	// Share names the group of entries whose payload files are hard
+	// links to the same data (see ForkSeq); empty if the block has its
+	// own. A group's data counts once towards usage.
+	Share string `json:"share,omitempty"`
+}
+
+// CompressionRatio returns SizeBytes / StoredBytes, or 0 if the stored
+// size was not recorded. Values near 1 with a Codec mark incompressible
+// blocks.
+func (m BlockMeta) CompressionRatio() float64 {
+	if m.StoredBytes == 0 {
+		return 0
+	}
+	return float64(m.SizeBytes) / float64(m.StoredBytes)
+}
+
+// storedSize is the size of meta's stored payload, which is what it
+// counts towards its tier's usage and budget. Blocks indexed before
+// StoredBytes was recorded count their uncompressed size until a move
+// measures them.
+func storedSize(meta *BlockMeta) int {
+	if meta.StoredBytes > 0 {
+		return meta.StoredBytes
+	}
+	return meta.SizeBytes
 }
 
 // Store is the tiered disk-backed storage engine.
@@ -56,20 +133,220 @@ type Store struct {
 	localPath string
 	// remote is the slow tier (NFS/HDD), optional.
 	remotePath string
+	// remote, if set, replaces remotePath with a block service.
+	remote Tier
+
+	// Model the cached blocks belong to (nil = unchecked).
+	fingerprint *Fingerprint
+
+	// Per-namespace limits, fingerprints and usage.
+	namespaces     map[string]NamespaceConfig
+	nsFingerprints map[string]*Fingerprint
+	profiles       map[string]*Profile
+	nsUsed         map[string]*nsUsage
 
 	// In-memory index of all stored blocks.
 	index map[string]*BlockMeta // keyed by BlockKey.String()
 
+	// Journal of index changes, nil unless IndexBackend is "journal"
+	// (see indexlog.go).
+	journal *indexJournal
+
+	// Filter answering lookups of blocks that aren't stored without
+	// the index (see bloom.go).
+	bloom      atomic.Pointer[bloomFilter]
+	bloomSkips atomic.Int64
+
+	// Live index entries per share group (see fork.go).
+	shares map[string]int
+
+	// Sequences whose blocks stay on the local tier.
+	pinned map[int]bool
+
+	// Session registry: stable conversation IDs to sequence IDs.
+	sessions   map[string]int
+	nextParked int
+
+	// Sequences whose cached blocks were removed, and who to tell.
+	expired        map[int]time.Time
+	expiryWatchers map[chan ExpiryEvent]struct{}
+	onExpire       func(ExpiryEvent)
+
+	// Block event watchers (see events.go).
+	eventWatchers map[chan Event]struct{}
+	droppedEvents int64
+
+	// Prefixes offered to decode nodes, by PrefixHash. publishedChanged
+	// is closed and replaced on every Publish to wake waiters.
+	published        map[string]*Publication
+	publishedChanged chan struct{}
+	exportHook       func(seq int, length int32) error
+
 	// Budget limits.
-	localBudget int64
+	localBudget  int64
 	remoteBudget int64
-	localUsed   int64
-	remoteUsed  int64
+	localUsed    int64
+	remoteUsed   int64
+
+	// Put pipeline. chain is applied in order on Put; transforms holds
+	// every registered stage by name so Get can reverse older blocks.
+	chain      []Transform
+	transforms map[string]Transform
+	zstd       *zstdTransform
+
+	// Remote archive bundles, by file name.
+	bundles          map[string]*bundleInfo
+	archiveMinBlocks int
+	archiveMaxBytes  int64
+	compactDeadRatio float64
+
+	log *slog.Logger
+
+	// Background work, stopped by Close.
+	done chan struct{}
+	wg   sync.WaitGroup
+
+	// Integrity counters.
+	readRepairs  int64
+	corruptReads int64
+
+	// Operation counters since open, for rate monitoring.
+	puts, hits, misses, evictions int64
+
+	// Puts dropped on a full local disk (see diskfull.go); diskFull is
+	// set from the first until a local write succeeds again.
+	diskFullDrops int64
+	diskFull      bool
+	shrinkOnFull  bool
+
+	// Local write latency for Pressure (see pressure.go).
+	writeLatency    time.Duration
+	lastWrite       time.Time
+	pressureLatency time.Duration
+
+	// Time spent running the Put pipeline and reversing it (see
+	// compstats.go); decodes run without s.mu.
+	encodeTotal time.Duration
+	decodeNanos atomic.Int64
+
+	// PutAsync queue (see writequeue.go), guarded by wqMu. wqOrder lists
+	// the keys to write next; wq also holds the block being written.
+	writeQueue int
+	wqMu       sync.Mutex
+	wqCond     *sync.Cond
+	wq         map[string]*pendingPut
+	wqOrder    []string
+	wqClosed   bool
+	coalesced  int64
+
+	// Per-namespace counters of the current stats history interval.
+	sample      map[string]*SampleCounters
+	sampleStart time.Time
+	statsOn     bool
+
+	// Smoothed read throughput per tier (see readrate.go).
+	readRates map[string]float64
+
+	// Registry key of a store opened with OpenShared.
+	shared string
+
+	// The store of the next tier down, opened and closed with this one
+	// (see chain.go).
+	below *Store
+
+	// Default eviction policy of namespaces without a profile.
+	eviction string
+
+	// Placement rules (see placement.go).
+	rules []PlacementRule
+
+	// Local stripes, nil if the local tier is LocalPath alone, and the
+	// next one round-robin picks, guarded by mu (see stripe.go).
+	stripes    []*stripe
+	stripeMode string
+	stripeNext int
+
+	// Blocks queued for promotion to the local tier (see prefetch.go);
+	// prefetching is guarded by mu.
+	prefetch    chan prefetchReq
+	prefetching map[string]bool
+	prefetched  int64
+
+	// Memory held by reads in flight (see readmem.go).
+	reads readBudget
+
+	// Remote tier bandwidth limits (see bandwidth.go), retries and
+	// circuit breaker (see remoteio.go).
+	bw  bandwidth
+	rio remoteIO
+
+	// Degraded mode (see degraded.go); pendingRemovals is guarded by mu.
+	degraded        atomic.Bool
+	pendingRemovals []BlockMeta
+
+	// Remote payloads of removed sequences waiting for the reaper (see
+	// reaper.go), guarded by mu; reap is nil without a remote tier.
+	reap         chan struct{}
+	tombstones   []BlockMeta
+	reapingBytes int64
+	reaped       int64
+
+	// Free-space budgets (see diskbudget.go).
+	localDisk, remoteDisk diskBudget
 
-	// Compression.
-	compress    bool
-	encoder     *zstd.Encoder
-	decoder     *zstd.Decoder
+	// lock is the writer's hold on the local tier, nil when readOnly
+	// (see lock.go).
+	lock     *dirLock
+	readOnly bool
+
+	// indexGen counts index changes and savedGen is the count the last
+	// periodic flush wrote (see flush.go); indexMu serializes writes of
+	// the index and its journal.
+	indexGen, savedGen uint64
+	indexMu            sync.Mutex
+
+	// trace records operations for Replay, nil unless Config.TracePath
+	// is set (see trace.go).
+	trace *tracer
+
+	// formatFrom is the on-disk format the store was opened in (see
+	// format.go).
+	formatFrom int
+
+	// sharding is the shard scheme of block directories, and
+	// shardingFrom the one the store was opened in (see shard.go).
+	sharding, shardingFrom string
+
+	// How blocks move between directory tiers (see movefile.go);
+	// renameMoves is set while they share a filesystem.
+	streamMoves bool
+	renameMoves atomic.Bool
+
+	// Mapped reads and direct I/O of local blocks (see mmap.go and
+	// directio.go).
+	mmap     mmapState
+	directIO atomic.Bool
+
+	// Batched block file reads (see batchread.go) and the decode workers
+	// they hand blocks to (see decodepool.go; nil without).
+	ring          uring
+	decodeWorkers int
+	decodeJobs    chan func()
+
+	migrationWorkers int
+
+	// Replication (see replicate.go); replicasChanged is guarded by mu.
+	replicate       bool
+	replicasChanged bool
+
+	// Compression stage per tier, nil without Config.LocalCodec and
+	// RemoteCodec (see codec.go).
+	codecs     map[string]tierCodec
+	recompress bool
+
+	// Incompressible blocks (see entropy.go).
+	entropyLimit float64
+	rawDTypes    map[string]bool
 }
 
 // Config for creating a new Store.
@@ -79,75 +356,703 @@ type Config struct {
 	LocalBudget  int64  // Max bytes on local tier.
 	RemoteBudget int64  // Max bytes on remote tier.
 	Compress     bool   // Apply zstd compression.
+
+	// LocalCodec and RemoteCodec, if either is set, replace Compress
+	// with a compression stage per tier: CodecNone, CodecS2 (fast,
+	// LZ4-class) or CodecZstd, optionally with a level ("zstd:19").
+	// RecompressOnMigrate recompresses blocks moving between the tiers
+	// with their destination's codec (see codec.go).
+	LocalCodec          string
+	RemoteCodec         string
+	RecompressOnMigrate bool
+
+	// IncompressibleEntropy, if above zero, stores blocks without the
+	// compression stage when a sample of their bytes has at least that
+	// entropy, in bits per byte (8 at most, for random data).
+	// RawDTypes lists dtypes whose blocks are never compressed, e.g.
+	// "q8_0". See entropy.go.
+	IncompressibleEntropy float64
+	RawDTypes             []string
+
+	// Transforms is a user-defined Put pipeline (quantize, delta, encrypt,
+	// ...). If Compress is set and the chain has no zstd stage, zstd is
+	// appended to the end.
+	Transforms []Transform
+
+	// Logger receives structured diagnostics (nil = slog.Default()).
+	// LogLevel, if set, is the minimum level diskstore emits.
+	Logger   *slog.Logger
+	LogLevel slog.Leveler
+
+	// Remote archiving: every ArchiveInterval, remote blocks not accessed
+	// for ArchiveAge are packed into bundles of at least ArchiveMinBlocks
+	// blocks (default 16) and at most ArchiveMaxBytes (default 1 GiB).
+	// Zero ArchiveInterval disables the background pass.
+	ArchiveAge       time.Duration
+	ArchiveInterval  time.Duration
+	ArchiveMinBlocks int
+	ArchiveMaxBytes  int64
+
+	// CompactDeadRatio is the share of dead bytes at which the archive
+	// pass rewrites a bundle (default 0.5; negative disables).
+	CompactDeadRatio float64
+
+	// OnExpire, if set, is called asynchronously when a sequence loses
+	// its last cached block to GC or removal.
+	OnExpire func(ExpiryEvent)
+
+	// Fingerprint identifies the model the cache belongs to. It is
+	// recorded when the store is created, must match on every later open,
+	// and every block is checked against it on Put and Get. With
+	// NamespaceByModel each fingerprint gets its own subdirectory
+	// (models/<id>) under both tiers instead, so switching models keeps
+	// both caches.
+	Fingerprint      *Fingerprint
+	NamespaceByModel bool
+
+	// Namespaces configures per-namespace budgets and fingerprints for
+	// stores holding several models' blocks (see BlockKey.Namespace).
+	// Namespaces that aren't listed are still accepted, bounded only by
+	// the store-wide budgets.
+	Namespaces map[string]NamespaceConfig
+
+	// Profile names the built-in policy profile (see Profiles) of the
+	// default namespace; NamespaceConfig.Profile sets it for others.
+	Profile string
+
+	// WriteQueue, if positive, is how many blocks PutAsync may queue for a
+	// background writer. Zero makes PutAsync write synchronously.
+	WriteQueue int
+
+	// ReadMemory, if positive, bounds the bytes that reads in flight
+	// hold for stored and decoded block data; further reads wait.
+	ReadMemory int64
+
+	// StreamMoves lets the kernel copy blocks moved between the local
+	// and remote directories, as reflinks where the filesystem can, and
+	// verifies each copy's checksum by reading it back. Otherwise they
+	// are copied in chunks checked as they are read. Directories on one
+	// filesystem rename blocks either way (see movefile.go).
+	StreamMoves bool
+
+	// RemoteBandwidth, if positive, caps the bytes per second read from
+	// and written to the remote tier. MigrationBandwidth caps evictions,
+	// promotions, Migrate, archiving and Verify, RestoreBandwidth the
+	// reads Get and ReadRange wait for; each counts against
+	// RemoteBandwidth too (see bandwidth.go).
+	RemoteBandwidth    int64
+	MigrationBandwidth int64
+	RestoreBandwidth   int64
+
+	// RemoteTimeout bounds each remote-tier operation (default 30s),
+	// which is tried RemoteRetries more times (default 2) after an error,
+	// RemoteBackoff apart (default 100ms, doubling). After
+	// RemoteFailureThreshold failed operations in a row (default 5) the
+	// remote tier is treated as unavailable for RemoteCooldown (default
+	// 30s). Negative values disable each (see remoteio.go).
+	RemoteTimeout          time.Duration
+	RemoteRetries          int
+	RemoteBackoff          time.Duration
+	RemoteFailureThreshold int
+	RemoteCooldown         time.Duration
+
+	// RemoteCheckInterval is how often the remote tier's health is
+	// checked (default 10s; negative disables). While the check fails the
+	// store runs degraded on the local tier (see degraded.go).
+	RemoteCheckInterval time.Duration
+
+	// LocalBudgetPercent and RemoteBudgetPercent, if set, re-derive the
+	// tier's budget every BudgetInterval (default 1 minute) as that
+	// percentage of the space it could use on its filesystem: the free
+	// space plus its own blocks. LocalMinFree and RemoteMinFree are free
+	// bytes always left on the filesystem. LocalBudget and RemoteBudget,
+	// if also set, cap the derived budgets (see diskbudget.go).
+	LocalBudgetPercent  float64
+	RemoteBudgetPercent float64
+	LocalMinFree        int64
+	RemoteMinFree       int64
+	BudgetInterval      time.Duration
+
+	// ShrinkOnDiskFull lowers the local budget to the local usage when a
+	// Put finds the local disk full, so later ones make room by evicting
+	// (see diskfull.go).
+	ShrinkOnDiskFull bool
+
+	// PressureLatency is the local write latency at which Pressure
+	// reports the store unable to keep up (default 50ms; negative leaves
+	// latency out).
+	PressureLatency time.Duration
+
+	// MmapReads reads local-tier blocks decoded into a caller's buffer
+	// (GetInto, ReadRange) through a memory mapping instead of read
+	// calls, where the platform and filesystem allow (see mmap.go).
+	MmapReads bool
+
+	// DirectIO writes and reads local-tier block files with O_DIRECT,
+	// keeping KV traffic out of the page cache (Linux; see directio.go).
+	// Mapped reads go through the page cache and are off with it.
+	DirectIO bool
+
+	// DecodeWorkers, if above one, is how many blocks a ReadRanges (and
+	// so a restore) decodes at once, on a pool of as many goroutines
+	// (see decodepool.go). Otherwise blocks are decoded one by one.
+	DecodeWorkers int
+
+	// MigrationWorkers, if above one, is how many blocks an eviction
+	// making room on the local tier moves to the remote tier at once
+	// (see evictpool.go). Otherwise blocks move one by one.
+	MigrationWorkers int
+
+	// Replicate keeps pinned and prefix-addressed blocks on the remote
+	// tier as well as the local one, so they outlive the local tier
+	// (see replicate.go).
+	Replicate bool
+
+	// ReadOnly opens the store to read alongside a writer that may have
+	// it open, on the index as last saved: it takes no lock, runs nothing
+	// in the background and writes nothing. Changes fail with ErrReadOnly
+	// or, where they return no error, do nothing. Otherwise New
+	// fails with ErrLocked while another store has the local tier open
+	// (see lock.go).
+	ReadOnly bool
+
+	// TracePath, if set, is a file the store appends a record of every
+	// Put, read and removal to, for Replay (see trace.go). Read-only
+	// stores record nothing.
+	TracePath string
+
+	// UpgradeFormat lets New upgrade a store in an older on-disk format
+	// in place; otherwise it fails with ErrStoreFormat on one (see
+	// format.go).
+	UpgradeFormat bool
+
+	// IndexBackend is how the index is persisted in the local tier:
+	// "json" (the default) rewrites index.json whole on Close and, if it
+	// changed, every FlushInterval; "journal" appends the changed
+	// entries to a journal every IndexSyncInterval (default 5s), folding
+	// it into index.json as it grows, to save only what changed and lose
+	// at most a sync's worth of changes to a crash. Either way the index
+	// is loaded whole and held in memory (see indexlog.go).
+	IndexBackend      string
+	IndexSyncInterval time.Duration
+
+	// FlushInterval is how often the store syncs queued blocks, the
+	// index and the trace to disk while open (default 1 minute; negative
+	// disables), and Context, if set, makes it sync once the context is
+	// done (see flush.go).
+	FlushInterval time.Duration
+	Context       context.Context
+
+	// StatsInterval is how often a stats sample is appended to the
+	// history in the local tier (default 10 minutes; negative disables).
+	StatsInterval time.Duration
+
+	// RemoteTier, if set, is used as the remote tier instead of
+	// RemotePath, e.g. a RemoteClient for a kvblockd on a storage node.
+	// Archive bundles and read repair need a RemotePath and are disabled.
+	RemoteTier Tier
+
+	// Tiers, if set, are tiers below the local one, fastest first, used
+	// as a chain in place of RemotePath or RemoteTier (see chain.go).
+	// RemoteBudget, if zero, is their budgets' sum.
+	Tiers []TierConfig
+
+	// Eviction orders local blocks for moving to the remote tier in
+	// namespaces without a profile: EvictLRU (the default) or EvictLFU.
+	Eviction string
+
+	// Placement, if set, are rules placing blocks by layer, size or age
+	// ahead of the budgets, e.g. keeping layers 0-3 local or sending
+	// blocks unread for an hour to the remote tier (see placement.go).
+	Placement []PlacementRule
+
+	// LocalStripes, if set, spreads the local tier's blocks over these
+	// directories, one per device, picking each block's by StripeMode:
+	// StripeRoundRobin (the default) or StripeHash. LocalBudget, if
+	// zero, is their budgets' sum (see stripe.go).
+	LocalStripes []LocalStripe
+	StripeMode   string
+
+	// Sharding is how blocks are spread over the subdirectories of each
+	// tier: ShardSeq, ShardHash or ShardHash2. Empty keeps the scheme the
+	// store was laid out in (ShardSeq for a new one); another moves its
+	// blocks to the new scheme in New (see shard.go).
+	Sharding string
+}
+
+// dirs returns the directories of the local and remote tier, which with
+// NamespaceByModel are the model's subdirectories.
+func (c Config) dirs() (local, remote string) {
+	local, remote = c.LocalPath, c.RemotePath
+	if c.NamespaceByModel && c.Fingerprint != nil {
+		id := c.Fingerprint.ID()
+		local = filepath.Join(local, modelsDir, id)
+		if remote != "" {
+			remote = filepath.Join(remote, modelsDir, id)
+		}
+	}
+	return local, remote
+}
+
+// stripes returns the local stripes, which with NamespaceByModel are the
+// model's subdirectories.
+func (c Config) stripes() []*stripe {
+	var stripes []*stripe
+	for _, st := range c.LocalStripes {
+		path := st.Path
+		if c.NamespaceByModel && c.Fingerprint != nil {
+			path = filepath.Join(path, modelsDir, c.Fingerprint.ID())
+		}
+		stripes = append(stripes, &stripe{path: path, budget: st.Budget})
+	}
+	return stripes
 }
 
 // New creates a new tiered disk store.
 func New(cfg Config) (*Store, error) {
-	if err := os.MkdirAll(cfg.LocalPath, 0755); err != nil {
+	if cfg.RemotePath != "" && cfg.RemoteTier != nil {
+		return nil, fmt.Errorf("diskstore: RemotePath and RemoteTier are mutually exclusive")
+	}
+	switch cfg.IndexBackend {
+	case "", IndexJSON, IndexJournal:
+	default:
+		return nil, fmt.Errorf("diskstore: unknown index backend %q", cfg.IndexBackend)
+	}
+	if cfg.Sharding != "" && !validShardScheme(cfg.Sharding) {
+		return nil, fmt.Errorf("diskstore: unknown shard scheme %q", cfg.Sharding)
+	}
+	switch cfg.Eviction {
+	case "", EvictLRU, EvictLFU:
+	default:
+		return nil, fmt.Errorf("diskstore: unknown eviction policy %q", cfg.Eviction)
+	}
+	if cfg.IncompressibleEntropy < 0 || cfg.IncompressibleEntropy > 8 {
+		return nil, fmt.Errorf("diskstore: IncompressibleEntropy %g out of range (0 to 8 bits per byte)", cfg.IncompressibleEntropy)
+	}
+	if err := checkPlacement(cfg.Placement); err != nil {
+		return nil, err
+	}
+	if err := checkStripes(cfg); err != nil {
+		return nil, err
+	}
+	if len(cfg.Tiers) > 0 {
+		return openChain(cfg)
+	}
+	cfg.LocalPath, cfg.RemotePath = cfg.dirs()
+	stripes := cfg.stripes()
+	if cfg.LocalBudget == 0 {
+		for _, st := range stripes {
+			cfg.LocalBudget += st.budget
+		}
+	}
+
+	if cfg.ReadOnly {
+		if _, err := os.Stat(cfg.LocalPath); err != nil {
+			return nil, fmt.Errorf("diskstore: open read-only: %w", err)
+		}
+		// Nothing runs in the background that would write.
+		cfg.ArchiveInterval, cfg.WriteQueue, cfg.StatsInterval = 0, 0, -1
+		cfg.RemoteCheckInterval, cfg.BudgetInterval = -1, -1
+	} else if err := os.MkdirAll(cfg.LocalPath, 0755); err != nil {
 		return nil, fmt.Errorf("diskstore: create local dir: %w", err)
 	}
-	if cfg.RemotePath != "" {
+	for _, st := range stripes {
+		if cfg.ReadOnly {
+			break
+		}
+		if err := os.MkdirAll(st.path, 0755); err != nil {
+			return nil, fmt.Errorf("diskstore: create local stripe: %w", err)
+		}
+	}
+	if cfg.RemotePath != "" && !cfg.ReadOnly {
 		if err := os.MkdirAll(cfg.RemotePath, 0755); err != nil {
 			return nil, fmt.Errorf("diskstore: create remote dir: %w", err)
 		}
+		if err := markRemote(cfg.RemotePath); err != nil {
+			return nil, fmt.Errorf("diskstore: mark remote dir: %w", err)
+		}
 	}
 
-	var enc *zstd.Encoder
-	var dec *zstd.Decoder
-	if cfg.Compress {
-		var err error
-		enc, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	fp, err := loadTierFingerprints(cfg.LocalPath, cfg.RemotePath, cfg.Fingerprint)
+	if err != nil {
+		return nil, err
+	}
+	nsFingerprints := make(map[string]*Fingerprint)
+	profiles := make(map[string]*Profile)
+	if cfg.Profile != "" {
+		p, err := LookupProfile(cfg.Profile)
 		if err != nil {
-			return nil, fmt.Errorf("diskstore: create zstd encoder: %w", err)
+			return nil, err
+		}
+		profiles[""] = &p
+	}
+	for ns, nc := range cfg.Namespaces {
+		if ns == "" {
+			return nil, fmt.Errorf("diskstore: namespace config needs a name")
+		}
+		if err := checkNamespace(ns); err != nil {
+			return nil, err
+		}
+		if nc.Profile != "" {
+			p, err := LookupProfile(nc.Profile)
+			if err != nil {
+				return nil, fmt.Errorf("namespace %s: %w", ns, err)
+			}
+			profiles[ns] = &p
 		}
-		dec, err = zstd.NewReader(nil)
+		if nc.Fingerprint == nil {
+			continue
+		}
+		local, remote := nsPath(cfg.LocalPath, ns), ""
+		if cfg.RemotePath != "" {
+			remote = nsPath(cfg.RemotePath, ns)
+		}
+		f, err := loadTierFingerprints(local, remote, nc.Fingerprint)
 		if err != nil {
-			return nil, fmt.Errorf("diskstore: create zstd decoder: %w", err)
+			return nil, fmt.Errorf("namespace %s: %w", ns, err)
 		}
+		nsFingerprints[ns] = f
 	}
 
 	s := &Store{
-		localPath:    cfg.LocalPath,
-		remotePath:   cfg.RemotePath,
-		index:        make(map[string]*BlockMeta),
-		localBudget:  cfg.LocalBudget,
-		remoteBudget: cfg.RemoteBudget,
-		compress:     cfg.Compress,
-		encoder:      enc,
-		decoder:      dec,
+		localPath:   cfg.LocalPath,
+		remotePath:  cfg.RemotePath,
+		remote:      cfg.RemoteTier,
+		fingerprint: fp,
+
+		namespaces:     cfg.Namespaces,
+		nsFingerprints: nsFingerprints,
+		profiles:       profiles,
+		nsUsed:         make(map[string]*nsUsage),
+
+		index:    make(map[string]*BlockMeta),
+		shares:   make(map[string]int),
+		pinned:   make(map[int]bool),
+		expired:  make(map[int]time.Time),
+		onExpire: cfg.OnExpire,
+
+		expiryWatchers:   make(map[chan ExpiryEvent]struct{}),
+		eventWatchers:    make(map[chan Event]struct{}),
+		published:        make(map[string]*Publication),
+		publishedChanged: make(chan struct{}),
+		localBudget:      cfg.LocalBudget,
+		remoteBudget:     cfg.RemoteBudget,
+		transforms:       make(map[string]Transform),
+		log:              newLogger(cfg.Logger, cfg.LogLevel),
+		bundles:          make(map[string]*bundleInfo),
+		done:             make(chan struct{}),
+		sample:           make(map[string]*SampleCounters),
+		sampleStart:      time.Now(),
+		statsOn:          cfg.StatsInterval >= 0,
+		writeQueue:       max(cfg.WriteQueue, 0),
+		streamMoves:      cfg.StreamMoves,
+		wq:               make(map[string]*pendingPut),
+		readRates:        make(map[string]float64),
+		prefetching:      make(map[string]bool),
+
+		archiveMinBlocks: cfg.ArchiveMinBlocks,
+		archiveMaxBytes:  cfg.ArchiveMaxBytes,
+		compactDeadRatio: cfg.CompactDeadRatio,
+
+		localDisk: diskBudget{percent: cfg.LocalBudgetPercent, minFree: cfg.LocalMinFree, fixed: cfg.LocalBudget},
+		readOnly:  cfg.ReadOnly,
+		eviction:  cfg.Eviction,
+		rules:     cfg.Placement,
+
+		stripes:    stripes,
+		stripeMode: cfg.StripeMode,
+		replicate:  cfg.Replicate,
+
+		shrinkOnFull:    cfg.ShrinkOnDiskFull,
+		pressureLatency: orDefault(cfg.PressureLatency, defaultPressureLatency),
+	}
+	if cfg.RemoteTier == nil && cfg.RemotePath != "" {
+		same := true
+		for _, base := range s.localBases() {
+			same = same && sameFilesystem(base, cfg.RemotePath)
+		}
+		s.renameMoves.Store(same)
+		s.remoteDisk = diskBudget{percent: cfg.RemoteBudgetPercent, minFree: cfg.RemoteMinFree, fixed: cfg.RemoteBudget}
+	}
+	s.wqCond = sync.NewCond(&s.wqMu)
+	s.reads.init(cfg.ReadMemory)
+	s.bw.init(cfg)
+	s.rio.init(cfg)
+	if s.archiveMinBlocks <= 0 {
+		s.archiveMinBlocks = defaultArchiveMinBlocks
+	}
+	if s.archiveMaxBytes <= 0 {
+		s.archiveMaxBytes = defaultArchiveMaxBytes
+	}
+	if s.compactDeadRatio == 0 {
+		s.compactDeadRatio = defaultCompactDeadRatio
+	}
+
+	if cfg.MmapReads && !mmapAvailable {
+		s.log.Warn("mmap not supported on this platform, mapped reads disabled")
+	}
+	if cfg.DirectIO && !directIOAvailable {
+		s.log.Warn("direct I/O not supported on this platform, using buffered I/O")
+	}
+	s.directIO.Store(cfg.DirectIO && directIOAvailable)
+	s.mmap.on = cfg.MmapReads && mmapAvailable && !s.directIO.Load()
+	if cfg.DecodeWorkers > 1 {
+		s.decodeWorkers = cfg.DecodeWorkers
+	}
+	if cfg.MigrationWorkers > 1 {
+		s.migrationWorkers = cfg.MigrationWorkers
+	}
+	s.entropyLimit = cfg.IncompressibleEntropy
+	if len(cfg.RawDTypes) > 0 {
+		s.rawDTypes = make(map[string]bool)
+		for _, dtype := range cfg.RawDTypes {
+			s.rawDTypes[dtype] = true
+		}
+	}
+
+	for _, t := range cfg.Transforms {
+		if _, dup := s.transforms[t.Name()]; dup {
+			return nil, fmt.Errorf("diskstore: duplicate transform %q", t.Name())
+		}
+		s.chain = append(s.chain, t)
+		s.transforms[t.Name()] = t
+	}
+	tierCodecs := cfg.LocalCodec != "" || cfg.RemoteCodec != ""
+	if tierCodecs && !zstdAvailable {
+		s.log.Warn("built without zstd, tier codecs disabled")
+	} else if tierCodecs {
+		if err := s.openCodecs(cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Compress && !zstdAvailable {
+		s.log.Warn("built without zstd, compression disabled")
+	} else if cfg.Compress && !tierCodecs {
+		if _, ok := s.transforms[zstdTransformName]; !ok {
+			t, err := newZstdTransform(s.decodeWorkers)
+			if err != nil {
+				return nil, err
+			}
+			s.zstd = t
+			s.chain = append(s.chain, t)
+			s.transforms[zstdTransformName] = t
+		}
+	}
+	// Profiles may compress a namespace even when the store doesn't.
+	var ttl bool
+	for _, p := range profiles {
+		ttl = ttl || p.TTL > 0
+		if p.Compress && !zstdAvailable {
+			s.log.Warn("built without zstd, profile compression disabled", "profile", p.Name)
+		}
+		if _, ok := s.transforms[zstdTransformName]; p.Compress && !ok && zstdAvailable {
+			t, err := newZstdTransform(s.decodeWorkers)
+			if err != nil {
+				return nil, err
+			}
+			s.zstd = t
+			s.transforms[zstdTransformName] = t
+		}
+	}
+
+	if !s.readOnly {
+		if s.lock, err = lockDir(s.localPath); err != nil {
+			return nil, err
+		}
+		s.wg.Add(1)
+		go s.leaseLoop()
+	}
+	if err := s.checkFormat(cfg.UpgradeFormat); err != nil {
+		close(s.done)
+		s.wg.Wait()
+		if s.lock != nil {
+			s.lock.release()
+		}
+		return nil, err
+	}
+	if cfg.TracePath != "" && !s.readOnly {
+		t, err := openTracer(cfg.TracePath)
+		if err != nil {
+			close(s.done)
+			s.wg.Wait()
+			s.lock.release()
+			return nil, err
+		}
+		s.trace = t
+	}
+
+	l, err := s.openLayout()
+	if err != nil {
+		close(s.done)
+		s.wg.Wait()
+		s.trace.close()
+		if s.lock != nil {
+			s.lock.release()
+		}
+		return nil, err
 	}
 
 	// Load existing index if present.
-	s.loadIndex()
+	journaled := s.loadIndex()
+	s.loadSessions()
+
+	if err := s.reshard(l, cfg.Sharding); err != nil {
+		close(s.done)
+		s.wg.Wait()
+		s.trace.close()
+		s.lock.release()
+		return nil, err
+	}
+
+	if cfg.IndexBackend == IndexJournal && !s.readOnly {
+		if err := s.openJournal(journaled); err != nil {
+			close(s.done)
+			s.wg.Wait()
+			s.trace.close()
+			s.lock.release()
+			return nil, err
+		}
+		if interval := orDefault(cfg.IndexSyncInterval, defaultIndexSyncInterval); interval > 0 {
+			s.wg.Add(1)
+			go s.indexSyncLoop(interval)
+		}
+	}
+
+	if cfg.RemotePath != "" && cfg.ArchiveInterval > 0 {
+		s.wg.Add(1)
+		go s.archiveLoop(cfg.ArchiveAge, cfg.ArchiveInterval)
+	}
+	if ttl && !s.readOnly {
+		s.wg.Add(1)
+		go s.ttlLoop()
+	}
+	if len(s.rules) > 0 && s.hasRemote() && !s.readOnly {
+		s.wg.Add(1)
+		go s.placementLoop()
+	}
+	if s.replicate && s.remotePath != "" && !s.readOnly {
+		s.wg.Add(1)
+		go s.replicaLoop()
+	}
+	if s.writeQueue > 0 {
+		s.wg.Add(1)
+		go s.writeLoop()
+	}
+	if s.decodeWorkers > 0 {
+		s.decodeJobs = make(chan func())
+		for range s.decodeWorkers {
+			s.wg.Add(1)
+			go s.decodeLoop()
+		}
+	}
+	if s.hasRemote() && !s.readOnly {
+		s.prefetch = make(chan prefetchReq, prefetchQueue)
+		s.wg.Add(1)
+		go s.prefetchLoop()
+
+		s.reap = make(chan struct{}, 1)
+		s.wg.Add(1)
+		go s.reapLoop()
+
+		if interval := orDefault(cfg.RemoteCheckInterval, defaultRemoteCheckInterval); interval > 0 {
+			s.wg.Add(1)
+			go s.remoteCheckLoop(interval)
+		}
+	}
+	if (s.localDisk.enabled() || s.remoteDisk.enabled()) && !s.readOnly {
+		s.refreshBudgets()
+		if interval := orDefault(cfg.BudgetInterval, defaultBudgetInterval); interval > 0 {
+			s.wg.Add(1)
+			go s.budgetLoop(interval)
+		}
+	}
+	if !s.readOnly {
+		if interval := orDefault(cfg.FlushInterval, defaultFlushInterval); interval > 0 || cfg.Context != nil {
+			s.wg.Add(1)
+			go s.flushLoop(cfg.Context, interval)
+		}
+	}
+	if s.statsOn {
+		interval := cfg.StatsInterval
+		if interval == 0 {
+			interval = defaultStatsInterval
+		}
+		s.pruneStatsHistory()
+		s.wg.Add(1)
+		go s.statsLoop(interval)
+	}
 
 	return s, nil
 }
 
 // Put stores a KV tensor block to the local tier.
 func (s *Store) Put(key BlockKey, dtype string, shape []int, data []byte) error {
-	s.mu.Lock()
-	defer s.mu.Unlock()
+	return s.PutShifted(key, dtype, shape, data, 0)
+}
+
+// PutShifted is Put for rows computed at positions shift lower than
+// key's, recorded as BlockMeta.Shift.
+func (s *Store) PutShifted(key BlockKey, dtype string, shape []int, data []byte, shift int32) error {
+	return s.putShifted(context.Background(), key, dtype, shape, data, shift, false)
+}
 
-	payload := data
-	compressed := false
-	if s.compress && s.encoder != nil {
-		payload = s.encoder.EncodeAll(data, nil)
-		compressed = true
+// PutContext is Put giving up when ctx is done before the block is
+// written, including while it waits to make room on the local tier. A
+// block is either stored whole or not at all.
+func (s *Store) PutContext(ctx context.Context, key BlockKey, dtype string, shape []int, data []byte) error {
+	return s.putShifted(ctx, key, dtype, shape, data, 0, false)
+}
+
+// putShifted is PutShifted giving up when ctx is done. A strict put
+// fails with ErrBudgetExceeded where a full local disk would drop the
+// block: the block service's (see remote.go), whose client frees its
+// own copy once the block is stored.
+func (s *Store) putShifted(ctx context.Context, key BlockKey, dtype string, shape []int, data []byte, shift int32, strict bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := s.writable(); err != nil {
+		return err
+	}
+	if err := checkNamespace(key.Namespace); err != nil {
+		return err
+	}
+	if err := checkPrefix(key.Prefix); err != nil {
+		return err
+	}
+	if err := s.fingerprintFor(key.Namespace).checkBlock(key, dtype, shape); err != nil {
+		return err
 	}
 
-	// Check local budget; if full, evict oldest local blocks to remote.
-	for s.localUsed+int64(len(payload)) > s.localBudget {
-		if !s.evictLocalToRemote() {
-			break // no remote tier or remote is full
-		}
+	if !s.admits(key) {
+		s.log.Debug("block not admitted by profile", "key", key)
+		return nil
 	}
 
-	path := s.blockPath(key, "local")
-	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	encodeStart := time.Now()
+	payload, stages, skipped, err := s.encodeFor(key.Namespace, dtype, data)
+	if err != nil {
 		return err
 	}
-	if err := os.WriteFile(path, payload, 0644); err != nil {
-		return err
+	encodeTime := time.Since(encodeStart)
+
+	// A second Put of a key (a retry, or the same positions evicted
+	// twice) replaces the block instead of counting it twice. The old
+	// block stays stored until the new one is, and is put back if the
+	// new one isn't (see replace.go).
+	var old *replaced
+	if meta, ok := s.index[key.String()]; ok {
+		if old, err = s.setAside(meta); err != nil {
+			return err
+		}
+		defer func() {
+			if old != nil {
+				s.restoreReplaced(old)
+			}
+		}()
 	}
 
 	meta := &BlockMeta{
@@ -155,51 +1060,233 @@ func (s *Store) Put(key BlockKey, dtype string, shape []int, data []byte) error
 		DTypeStr:   dtype,
 		Shape:      shape,
 		SizeBytes:  len(data),
-		Compressed: compressed,
+		Compressed: hasTransform(stages, zstdTransformName),
 		Tier:       "local",
+
+		Incompressible: skipped,
+
+		StoredBytes: len(payload),
+		EncodeTime:  encodeTime,
+		Shift:       shift,
+
+		Transforms: stages,
+		Checksum:   checksum(payload),
+		Pinned:     s.pinned[key.Seq],
 		StoredAt:   time.Now(),
 		AccessedAt: time.Now(),
 	}
+	meta.Codec = codecOf(stages)
+
+	// A placement rule may send the block straight to the remote tier,
+	// recompressing it for there like a move.
+	if len(s.rules) > 0 && s.placement(meta, meta.AccessedAt) == "remote" && s.fitsRemote(meta) {
+		if s.recodes(meta, "remote") {
+			out, r, err := s.recode(meta, payload, "remote")
+			if err != nil {
+				return err
+			}
+			r.apply(meta)
+			payload, stages = out, meta.Transforms
+			meta.StoredBytes = len(payload)
+		}
+		if err := s.writePayload(meta, "remote", 0, payload); err != nil {
+			s.log.Warn("place block on remote tier", "key", key, "error", err)
+		} else {
+			meta.Tier = "remote"
+		}
+	}
+	if meta.Tier == "local" {
+		err := s.writeNewLocal(ctx, meta, payload)
+		if diskFull(err) {
+			s.dropOnDiskFull(meta, err)
+			if strict {
+				return fmt.Errorf("%w: local disk full storing %s: %w", ErrBudgetExceeded, key, err)
+			}
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s.diskFull = false
+	}
+	if n := len(stages); n > 0 {
+		if _, ok := s.transforms[stages[n-1]].(*zstdTransform); ok {
+			defer putBuf(payload) // compressed into a pooled buffer
+		}
+	}
+
+	if old != nil {
+		s.dropReplaced(old)
+		old = nil
+	}
 	s.index[key.String()] = meta
-	s.localUsed += int64(len(payload))
+	s.indexChanged(key)
+	s.addUsage(meta, meta.Tier, int64(len(payload)))
+	if s.replicates(meta) {
+		s.addReplica(meta, payload)
+	}
+	s.emit(EventPut, meta)
+	s.trace.record(TraceOp{Op: TracePut, Key: key, DType: dtype, Size: len(data)})
+	s.puts++
+	s.encodeTotal += meta.EncodeTime
+	s.sampleFor(key.Namespace).Puts++
+	delete(s.expired, key.Seq)
+	s.log.Debug("put block", "key", key, "size", len(data), "stored", len(payload))
 
 	return nil
 }
 
+// writeNewLocal writes the payload of a new block to the local tier,
+// first making room for it within the local and its namespace's budget.
+// Must be called with s.mu held.
+func (s *Store) writeNewLocal(ctx context.Context, meta *BlockMeta, payload []byte) error {
+	key := meta.Key
+	start := time.Now()
+
+	// Keep the namespace within its own budget first, moving its own
+	// oldest blocks so one model can't push out another's.
+	for s.nsOverLocal(key.Namespace, int64(len(payload))) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.evictLocal(func(m *BlockMeta) bool { return m.Key.Namespace == key.Namespace }, int64(len(payload))) == 0 {
+			s.log.Warn("namespace local budget exceeded", "key", key, "namespace", key.Namespace)
+			break
+		}
+	}
+
+	// Check local budget; if full, evict oldest local blocks to remote.
+	for s.localUsed+int64(len(payload)) > s.localBudget {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if s.evictLocal(nil, s.localUsed+int64(len(payload))-s.localBudget) == 0 {
+			if s.Degraded() {
+				break // caught up on when the remote tier is back
+			}
+			s.log.Warn("local budget exceeded",
+				"key", key, "used", s.localUsed, "size", len(payload), "budget", s.localBudget)
+			break // no remote tier or remote is full
+		}
+	}
+
+	meta.Stripe = s.pickStripe(key, int64(len(payload)))
+	path := s.blockPath(key, "local", meta.Stripe)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		if !diskFull(err) {
+			s.log.Error("create block dir", "key", key, "error", err)
+		}
+		return err
+	}
+	if err := s.writeLocal(path, payload); err != nil {
+		if diskFull(err) {
+			os.Remove(path) // written in part
+			return err
+		}
+		s.log.Error("write block", "key", key, "path", path, "error", err)
+		return err
+	}
+	s.observeWrite(time.Since(start))
+	return nil
+}
+
 // Get retrieves a KV tensor block. Returns the raw (decompressed) bytes and metadata.
-// Returns nil, nil if not found.
+// A block that isn't stored yields an error wrapping ErrNotFound, one
+// that can't be read back intact ErrCorrupted, and one on a remote tier
+// that can't be reached ErrTierUnavailable.
 func (s *Store) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return s.get(context.Background(), key, true, nil)
+}
+
+// GetContext is Get giving up when ctx is done: while it waits for read
+// memory or bandwidth, or on a slow remote tier read, which is then
+// abandoned without counting against the tier (see remoteio.go). The
+// error wraps ctx.Err().
+func (s *Store) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	return s.get(ctx, key, true, nil)
+}
+
+// GetInto is Get decoding into dst[:0], so a caller reading many blocks
+// can reuse one buffer. The returned data shares dst's memory unless dst
+// was too small, in which case it is grown as by append.
+func (s *Store) GetInto(key BlockKey, dst []byte) ([]byte, *BlockMeta, error) {
+	return s.GetIntoContext(context.Background(), key, dst)
+}
+
+// GetIntoContext is GetInto giving up when ctx is done, as GetContext.
+func (s *Store) GetIntoContext(ctx context.Context, key BlockKey, dst []byte) ([]byte, *BlockMeta, error) {
+	if dst == nil {
+		dst = []byte{}
+	}
+	return s.get(ctx, key, true, dst)
+}
+
+// get is GetInto, or Get for a nil dst; charge makes it hold its read
+// memory (Config.ReadMemory) itself, which ReadRange does for it.
+func (s *Store) get(ctx context.Context, key BlockKey, charge bool, dst []byte) ([]byte, *BlockMeta, error) {
 	s.mu.RLock()
 	meta, ok := s.index[key.String()]
 	s.mu.RUnlock()
 
-	if !ok {
-		return nil, nil, nil
+	if !ok || s.pastTTL(meta, time.Now()) {
+		// Blocks past their TTL are dropped by the next sweep.
+		s.mu.Lock()
+		s.misses++
+		s.sampleFor(key.Namespace).Misses++
+		s.mu.Unlock()
+		s.trace.record(TraceOp{Op: TraceGet, Key: key})
+		return nil, nil, fmt.Errorf("diskstore: get %s: %w", key, ErrNotFound)
+	}
+	if err := s.fingerprintFor(key.Namespace).checkBlock(meta.Key, meta.DTypeStr, meta.Shape); err != nil {
+		return nil, nil, err
 	}
 
-	path := s.blockPath(key, meta.Tier)
-	payload, err := os.ReadFile(path)
-	if err != nil {
+	if err := ctx.Err(); err != nil {
 		return nil, nil, fmt.Errorf("diskstore: read block %s: %w", key, err)
 	}
+	if charge {
+		cost := readCost(meta)
+		if err := s.reads.acquire(ctx, cost); err != nil {
+			return nil, nil, fmt.Errorf("diskstore: read block %s: %w", key, err)
+		}
+		defer s.reads.release(cost)
+	}
 
-	data := payload
-	if meta.Compressed && s.decoder != nil {
-		data, err = s.decoder.DecodeAll(payload, nil)
+	start := time.Now()
+	data, mapped := s.readMapped(meta, dst)
+	if !mapped {
+		payload, err := s.readVerified(ctx, meta)
 		if err != nil {
-			return nil, nil, fmt.Errorf("diskstore: decompress block %s: %w", key, err)
+			return nil, nil, err
+		}
+		if data, err = s.decode(meta, payload, dst); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	s.mu.Lock()
-	meta.AccessedAt = time.Now()
-	s.mu.Unlock()
-
+	s.countRead(meta, len(data), time.Since(start))
 	return data, meta, nil
 }
 
+// countRead records a read of n bytes of meta's block that took d.
+func (s *Store) countRead(meta *BlockMeta, n int, d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recordRead(meta.Tier, n, d)
+	s.hits++
+	s.sampleFor(meta.Key.Namespace).Hits++
+	s.trace.record(TraceOp{Op: TraceGet, Key: meta.Key, Hit: true})
+	if m, ok := s.index[meta.Key.String()]; ok {
+		recordAccess(m, time.Now())
+		s.indexChanged(m.Key)
+	}
+}
+
 // Has checks whether a block exists in the store.
 func (s *Store) Has(key BlockKey) bool {
+	if !s.mayHave(key) {
+		return false
+	}
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 	_, ok := s.index[key.String()]
@@ -207,14 +1294,23 @@ func (s *Store) Has(key BlockKey) bool {
 }
 
 // GetRange returns all stored blocks for a given sequence, layer, and key/value type
-// that overlap with the position range [beginPos, endPos).
+// that overlap with the position range [beginPos, endPos), in the default
+// namespace.
 func (s *Store) GetRange(seq, layer int, isKey bool, beginPos, endPos int32) []BlockMeta {
+	return s.GetNamespaceRange("", seq, layer, isKey, beginPos, endPos)
+}
+
+// GetNamespaceRange is GetRange for namespace ns. Prefix-addressed
+// blocks (see prefixkey.go) belong to no sequence and are left out.
+func (s *Store) GetNamespaceRange(ns string, seq, layer int, isKey bool, beginPos, endPos int32) []BlockMeta {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	var results []BlockMeta
 	for _, meta := range s.index {
-		if meta.Key.Seq == seq &&
+		if meta.Key.Namespace == ns &&
+			meta.Key.Prefix == "" &&
+			meta.Key.Seq == seq &&
 			meta.Key.Layer == layer &&
 			meta.Key.IsKey == isKey &&
 			meta.Key.BeginPos < endPos &&
@@ -229,25 +1325,24 @@ func (s *Store) GetRange(seq, layer int, isKey bool, beginPos, endPos int32) []B
 	return results
 }
 
-// RemoveSeq removes all blocks for a given sequence.
+// RemoveSeq removes all blocks for a given sequence. They are gone from
+// the index when it returns; the payloads of remote blocks are deleted by
+// a background reaper (see reaper.go). Blocks still queued by PutAsync
+// are written first, so none outlive the removal.
 func (s *Store) RemoveSeq(seq int) int {
+	if s.readOnly {
+		return 0
+	}
+	s.Flush()
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	var removed int
-	for k, meta := range s.index {
-		if meta.Key.Seq == seq {
-			path := s.blockPath(meta.Key, meta.Tier)
-			os.Remove(path)
-			if meta.Tier == "local" {
-				s.localUsed -= int64(meta.SizeBytes)
-			} else {
-				s.remoteUsed -= int64(meta.SizeBytes)
-			}
-			delete(s.index, k)
-			removed++
-		}
+	s.trace.record(TraceOp{Op: TraceRemoveSeq, Key: BlockKey{Seq: seq}})
+	removed := s.dropSeq(seq)
+	if removed > 0 {
+		s.markExpired(seq)
 	}
+	s.log.Debug("removed sequence", "seq", seq, "blocks", removed)
 	return removed
 }
 
@@ -259,21 +1354,148 @@ type Stats struct {
 	RemoteUsed   int64 `json:"remote_used"`
 	LocalBudget  int64 `json:"local_budget"`
 	RemoteBudget int64 `json:"remote_budget"`
+
+	// LocalUsed and RemoteUsed are the bytes stored on disk, which the
+	// budgets apply to. LocalLogical and RemoteLogical are the same
+	// blocks' uncompressed size; blocks sharing data through ForkSeq
+	// count once on disk but each count here.
+	LocalLogical  int64 `json:"local_logical"`
+	RemoteLogical int64 `json:"remote_logical"`
+
+	// Stripes is the usage of each device of a striped local tier.
+	Stripes []StripeStats `json:"stripes,omitempty"`
+
+	// Replicas counts local blocks also kept on the remote tier (see
+	// replicate.go); they count towards RemoteUsed but not RemoteBlocks.
+	Replicas int `json:"replicas,omitempty"`
+
+	// ReadRepairs counts corrupt or missing copies rewritten from an
+	// intact copy on the other tier; CorruptReads counts reads that
+	// found no intact copy.
+	ReadRepairs  int64 `json:"read_repairs"`
+	CorruptReads int64 `json:"corrupt_reads"`
+
+	// Cumulative counters since the store was opened. Evictions counts
+	// blocks moved from the local to the remote tier to make room.
+	Puts      int64 `json:"puts"`
+	Hits      int64 `json:"hits"`
+	Misses    int64 `json:"misses"`
+	Evictions int64 `json:"evictions"`
+
+	// Coalesced counts PutAsync calls merged into a queued or in-flight
+	// write of the same key; Queued is the number of blocks waiting.
+	Coalesced int64 `json:"coalesced"`
+	Queued    int   `json:"queued"`
+
+	// Prefetched counts remote blocks promoted to the local tier by
+	// PrefetchRange.
+	Prefetched int64 `json:"prefetched"`
+
+	// ReadMemory is the block data held by reads in flight in bytes;
+	// ReadWaits counts reads that waited for Config.ReadMemory.
+	ReadMemory int64 `json:"read_memory"`
+	ReadWaits  int64 `json:"read_waits"`
+
+	// DroppedEvents counts block events a watcher missed because its
+	// channel was full (see WatchEvents).
+	DroppedEvents int64 `json:"dropped_events,omitempty"`
+
+	// DiskFullDrops counts blocks not stored because the local disk was
+	// full (see diskfull.go).
+	DiskFullDrops int64 `json:"disk_full_drops"`
+
+	// BloomSkips counts lookups of blocks that aren't stored answered
+	// without the index (see bloom.go).
+	BloomSkips int64 `json:"bloom_skips"`
+
+	// Reaping is how many removed blocks' remote payloads wait for the
+	// background reaper, ReapingBytes their stored size; Reaped counts
+	// the payloads it deleted (see reaper.go).
+	Reaping      int   `json:"reaping"`
+	ReapingBytes int64 `json:"reaping_bytes"`
+	Reaped       int64 `json:"reaped"`
+
+	// ReadRates is the measured read throughput per tier in decoded bytes
+	// per second, for tiers read since the store was opened.
+	ReadRates map[string]float64 `json:"read_rates,omitempty"`
+
+	// RemoteThrottled is the time remote-tier transfers waited for the
+	// bandwidth limits.
+	RemoteThrottled time.Duration `json:"remote_throttled"`
+
+	// RemoteRetries counts remote-tier operations tried again after an
+	// error and RemoteTimeouts tries that timed out. RemoteDownUntil is
+	// set while the remote tier is treated as unavailable.
+	RemoteRetries   int64     `json:"remote_retries"`
+	RemoteTimeouts  int64     `json:"remote_timeouts"`
+	RemoteDownUntil time.Time `json:"remote_down_until,omitempty"`
+
+	// Degraded is set while the remote tier is unavailable and the store
+	// runs on the local tier alone (see Store.Degraded).
+	Degraded bool `json:"degraded"`
+
+	// BundleDeadBytes is archived space held by removed blocks, reclaimed
+	// by bundle compaction or, on filesystems with holes, already freed.
+	// BundlePhysical is the disk space bundle files take, which
+	// RemoteUsed counts in place of their live blocks' payload.
+	BundleDeadBytes int64 `json:"bundle_dead_bytes"`
+	BundlePhysical  int64 `json:"bundle_physical"`
+
+	// Namespaces breaks usage down by model namespace ("" is the default
+	// namespace). It is omitted while only the default namespace is used.
+	Namespaces map[string]NamespaceStats `json:"namespaces,omitempty"`
+
+	// Compression is the size of all blocks before and after the Put
+	// pipeline, CompressionByLayer and CompressionByDType the same per
+	// layer and per dtype (see compstats.go). EncodeTime and DecodeTime
+	// are the time spent running the pipeline and reversing it since
+	// the store was opened.
+	Compression        CompressionStats            `json:"compression"`
+	CompressionByLayer map[int]CompressionStats    `json:"compression_by_layer,omitempty"`
+	CompressionByDType map[string]CompressionStats `json:"compression_by_dtype,omitempty"`
+	EncodeTime         time.Duration               `json:"encode_time"`
+	DecodeTime         time.Duration               `json:"decode_time"`
+
+	// BySeq, ByLayer and ByAge break the blocks held down by sequence
+	// (PrefixSeq for prefix-addressed blocks), by layer and by age since
+	// stored, youngest first (see usage.go).
+	BySeq   map[int]UsageStats `json:"by_seq,omitempty"`
+	ByLayer map[int]UsageStats `json:"by_layer,omitempty"`
+	ByAge   []AgeUsage         `json:"by_age,omitempty"`
 }
 
 func (s *Store) Stats() Stats {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	var local, remote int
+	var local, remote, replicas int
+	var localLogical, remoteLogical int64
 	for _, meta := range s.index {
+		if meta.Replica {
+			replicas++
+		}
 		if meta.Tier == "local" {
 			local++
+			localLogical += int64(meta.SizeBytes)
 		} else {
 			remote++
+			remoteLogical += int64(meta.SizeBytes)
 		}
 	}
 
+	namespaces := s.namespaceStats()
+	if _, ok := namespaces[""]; len(namespaces) == 0 || (len(namespaces) == 1 && ok) {
+		namespaces = nil
+	}
+
+	s.wqMu.Lock()
+	coalesced, queued := s.coalesced, len(s.wqOrder)
+	s.wqMu.Unlock()
+	readMemory, readWaits := s.reads.stats()
+	retried, timeouts, downUntil := s.rio.stats()
+	compression, byLayer, byDType := s.compressionStats()
+	usageBySeq, usageByLayer, usageByAge := s.usageStats(time.Now())
+
 	return Stats{
 		LocalBlocks:  local,
 		RemoteBlocks: remote,
@@ -281,44 +1503,143 @@ func (s *Store) Stats() Stats {
 		RemoteUsed:   s.remoteUsed,
 		LocalBudget:  s.localBudget,
 		RemoteBudget: s.remoteBudget,
+
+		LocalLogical:  localLogical,
+		RemoteLogical: remoteLogical,
+
+		ReadRepairs:  s.readRepairs,
+		CorruptReads: s.corruptReads,
+		Puts:         s.puts,
+		Hits:         s.hits,
+		Misses:       s.misses,
+		Evictions:    s.evictions,
+		Prefetched:   s.prefetched,
+		Coalesced:    coalesced,
+		Queued:       queued,
+		ReadMemory:   readMemory,
+		ReadWaits:    readWaits,
+		ReadRates:    maps.Clone(s.readRates),
+
+		DroppedEvents: s.droppedEvents,
+		DiskFullDrops: s.diskFullDrops,
+		BloomSkips:    s.bloomSkips.Load(),
+
+		Reaping:      len(s.tombstones),
+		ReapingBytes: s.reapingBytes,
+		Reaped:       s.reaped,
+
+		BundleDeadBytes: s.deadBundleBytes(),
+		BundlePhysical:  s.bundlePhysical(),
+		RemoteThrottled: s.bw.stats(),
+		RemoteRetries:   retried,
+		RemoteTimeouts:  timeouts,
+		RemoteDownUntil: downUntil,
+		Degraded:        s.Degraded(),
+		Namespaces:      namespaces,
+		Stripes:         s.stripeStats(),
+		Replicas:        replicas,
+
+		Compression:        compression,
+		CompressionByLayer: byLayer,
+		CompressionByDType: byDType,
+		EncodeTime:         s.encodeTotal,
+		DecodeTime:         time.Duration(s.decodeNanos.Load()),
+
+		BySeq:   usageBySeq,
+		ByLayer: usageByLayer,
+		ByAge:   usageByAge,
 	}
 }
 
-// Close flushes the index and releases resources.
+// Close flushes the index and releases resources. A store from
+// OpenShared is only closed when its last user closes it.
 func (s *Store) Close() error {
-	s.saveIndex()
-	if s.encoder != nil {
-		s.encoder.Close()
+	if s.shared != "" && !releaseShared(s.shared) {
+		return nil
 	}
-	if s.decoder != nil {
-		s.decoder.Close()
+	s.closeWriteQueue()
+	close(s.done)
+	s.wg.Wait()
+	s.reapAll()
+
+	if s.statsOn {
+		s.flushStats(true)
 	}
-	return nil
+	var err error
+	if s.replicate && s.remotePath != "" && !s.readOnly {
+		err = s.syncReplicas()
+	}
+	if !s.readOnly {
+		err = errors.Join(err, s.syncBlocks())
+	}
+	switch {
+	case s.journal != nil:
+		err = errors.Join(err, s.closeJournal())
+		s.lock.release()
+	case !s.readOnly:
+		err = errors.Join(err, s.saveIndex())
+		s.lock.release()
+	}
+	if s.zstd != nil {
+		s.zstd.close()
+	}
+	for _, c := range s.codecs {
+		c.close()
+	}
+	s.ring.close()
+	err = errors.Join(err, s.trace.close())
+	if s.below != nil {
+		err = errors.Join(err, s.below.Close())
+	}
+	return err
 }
 
 // ── internal ────────────────────────────────────────────────────────────────
 
-func (s *Store) blockPath(key BlockKey, tier string) string {
-	base := s.localPath
+func (s *Store) blockPath(key BlockKey, tier string, stripe int) string {
+	return s.shardPath(key, tier, stripe, s.sharding)
+}
+
+// shardPath is blockPath under a shard scheme (see shard.go).
+func (s *Store) shardPath(key BlockKey, tier string, stripe int, scheme string) string {
+	base := s.localBase(stripe)
 	if tier == "remote" {
 		base = s.remotePath
 	}
-	shard := key.Seq % 256
-	return filepath.Join(base, fmt.Sprintf("%02x", shard), key.String()+".kvblk")
+	return filepath.Join(nsPath(base, key.Namespace), key.shard(scheme), key.fileName()+".kvblk")
+}
+
+// metaPath returns the path of meta's payload file.
+func (s *Store) metaPath(meta *BlockMeta) string {
+	return s.blockPath(meta.Key, meta.Tier, meta.Stripe)
 }
 
-// evictLocalToRemote moves the oldest local block to remote tier.
+// indexChanged notes that key's index entry was added, changed or
+// removed, for the journal, the Bloom filter and the replica manifest.
 // Must be called with s.mu held.
-func (s *Store) evictLocalToRemote() bool {
-	if s.remotePath == "" {
+func (s *Store) indexChanged(key BlockKey) {
+	s.indexGen++
+	if s.journal != nil {
+		s.journal.dirty[key.String()] = struct{}{}
+	}
+	if s.replicate && (key.Prefix != "" || s.pinned[key.Seq]) {
+		s.replicasChanged = true
+	}
+	s.bloomChanged(key)
+}
+
+// evictOldestLocal moves the oldest unpinned local block accepted by match
+// (nil = any) to the remote tier. Must be called with s.mu held.
+func (s *Store) evictOldestLocal(match func(*BlockMeta) bool) bool {
+	if !s.hasRemote() || s.Degraded() {
 		return false
 	}
 
-	// Find oldest local block.
+	// Find the unpinned local block to go first, usually the oldest.
 	var oldest *BlockMeta
 	for _, meta := range s.index {
-		if meta.Tier == "local" {
-			if oldest == nil || meta.AccessedAt.Before(oldest.AccessedAt) {
+		if meta.Tier == "local" && !meta.Pinned && !s.keepsLocal(meta) && (match == nil || match(meta)) {
+			if oldest == nil || s.evictsBefore(meta, oldest) {
 				oldest = meta
 			}
 		}
@@ -327,61 +1648,218 @@ func (s *Store) evictLocalToRemote() bool {
 		return false
 	}
 
-	// Check remote budget.
-	if s.remoteUsed+int64(oldest.SizeBytes) > s.remoteBudget {
+	// Check remote budget; a replicated block is already counted there.
+	size := int64(storedSize(oldest))
+	if oldest.Replica {
+		size = 0
+	}
+	if s.remoteUsed+size > s.remoteBudget {
+		s.log.Warn("remote budget exceeded, cannot evict",
+			"key", oldest.Key, "used", s.remoteUsed, "size", size, "budget", s.remoteBudget)
+		return false
+	}
+	if !s.nsFitsRemote(oldest.Key.Namespace, size) {
+		s.log.Warn("namespace remote budget exceeded, cannot evict",
+			"key", oldest.Key, "namespace", oldest.Key.Namespace)
+		return false
+	}
+
+	n, err := s.moveBlock(oldest, "remote")
+	if err != nil {
+		s.log.Warn("evict to remote failed", "key", oldest.Key, "error", err)
 		return false
 	}
+	s.evictions++
+	s.log.Debug("evicted block to remote", "key", oldest.Key, "size", n)
 
-	srcPath := s.blockPath(oldest.Key, "local")
-	dstPath := s.blockPath(oldest.Key, "remote")
+	return true
+}
 
-	if err := os.MkdirAll(filepath.Dir(dstPath), 0755); err != nil {
-		return false
+// moveBlock relocates a block's payload to the dst tier, updating usage
+// and the block's Tier. It returns the number of bytes moved.
+// Must be called with s.mu held.
+func (s *Store) moveBlock(meta *BlockMeta, dst string) (int64, error) {
+	if meta.Replica && dst == "remote" {
+		n := int64(storedSize(meta))
+		s.dropLocalCopy(meta, true)
+		s.emit(EventEvict, meta)
+		return n, nil
+	}
+	var stripe int
+	if dst == "local" {
+		stripe = s.pickStripe(meta.Key, int64(storedSize(meta)))
+	}
+	t, err := s.transferPayload(meta, dst, stripe)
+	if err != nil {
+		return 0, err
 	}
+	s.finishMove(meta, dst, stripe, t)
+	return t.n, nil
+}
+
+// transfer is what transferPayload did: the payload's size on the
+// destination, whether it was renamed there, and its new encoding if it
+// was recompressed (see codec.go).
+type transfer struct {
+	n       int64
+	renamed bool
+	recoded *recoding
+}
 
-	data, err := os.ReadFile(srcPath)
+// transferPayload puts meta's payload on the dst tier, in stripe if it
+// is the local one, leaving the source to finishMove unless it was
+// renamed. It changes nothing in the store, so file moves (see
+// fileMove), recompressing moves and writes to a service may run side
+// by side (see evictpool.go).
+// Must be called with s.mu held.
+func (s *Store) transferPayload(meta *BlockMeta, dst string, stripe int) (transfer, error) {
+	if s.recodes(meta, dst) {
+		return s.recodePayload(meta, dst, stripe)
+	}
+	fileMove := s.fileMove(meta, dst)
+	if fileMove && s.renameMoves.Load() && !s.keepsReplica(meta, dst) {
+		n, err := s.renamePayload(meta, dst, stripe)
+		if err != nil || n >= 0 {
+			return transfer{n: n, renamed: err == nil}, err
+		}
+	}
+	if fileMove {
+		n, err := s.copyPayload(meta, dst, stripe)
+		return transfer{n: n}, err
+	}
+	data, err := s.readPayload(context.Background(), meta, migrationIO)
 	if err != nil {
-		return false
+		return transfer{}, err
 	}
-	if err := os.WriteFile(dstPath, data, 0644); err != nil {
-		return false
+	if err := s.writePayload(meta, dst, stripe, data); err != nil {
+		return transfer{}, err
 	}
-	os.Remove(srcPath)
+	putBuf(data)
+	return transfer{n: int64(len(data))}, nil
+}
 
-	s.localUsed -= int64(len(data))
-	s.remoteUsed += int64(len(data))
-	oldest.Tier = "remote"
+// finishMove records meta as moved to dst, in stripe, as t left it,
+// removing its source unless it was renamed or stays as its replica.
+// Must be called with s.mu held.
+func (s *Store) finishMove(meta *BlockMeta, dst string, stripe int, t transfer) {
+	n := t.n
+	if s.keepsReplica(meta, dst) {
+		meta.Stripe = stripe
+		s.addUsage(meta, dst, n)
+		meta.Tier, meta.Replica = dst, true
+		s.indexChanged(meta.Key)
+		s.emit(EventPromote, meta)
+		return
+	}
+	if !t.renamed {
+		if err := s.removePayload(meta); err != nil {
+			s.log.Warn("remove moved block source", "key", meta.Key, "tier", meta.Tier, "error", err)
+		}
+	}
 
-	return true
+	// Usage moves by what the block was counted as; a block indexed
+	// without its stored size is measured now.
+	s.releaseUsage(meta, int64(storedSize(meta)))
+	meta.StoredBytes = int(n)
+	meta.Stripe = stripe
+	if t.recoded != nil {
+		t.recoded.apply(meta)
+		s.encodeTotal += t.recoded.took
+	}
+	s.addUsage(meta, dst, n)
+	meta.Tier = dst
+	s.indexChanged(meta.Key)
+	if dst == "remote" {
+		s.emit(EventEvict, meta)
+	} else {
+		s.emit(EventPromote, meta)
+	}
 }
 
 func (s *Store) indexPath() string {
 	return filepath.Join(s.localPath, "index.json")
 }
 
-func (s *Store) saveIndex() {
-	data, err := json.MarshalIndent(s.index, "", "  ")
+func (s *Store) saveIndex() error {
+	data, err := json.MarshalIndent(indexFile{Format: storeFormat, Blocks: s.index}, "", "  ")
 	if err != nil {
-		return
+		s.log.Error("encode index", "error", err)
+		return fmt.Errorf("diskstore: encode index: %w", err)
+	}
+	// Renamed into place, so a read-only store never reads it half
+	// written.
+	if err := replaceFile(s.indexPath(), data); err != nil {
+		s.log.Error("write index", "path", s.indexPath(), "error", err)
+		return fmt.Errorf("diskstore: write index: %w", err)
 	}
-	os.WriteFile(s.indexPath(), data, 0644)
+	return s.removeJournal()
 }
 
-func (s *Store) loadIndex() {
+// loadIndex loads index.json and replays the journal of a "journal"
+// store on top, returning how many bytes of the journal it replayed.
+func (s *Store) loadIndex() int64 {
+	// The journal is read first, in case a writer compacts it meanwhile
+	// (see indexlog.go).
+	journal, err := os.ReadFile(s.journalPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		s.log.Warn("read index journal, ignoring it", "path", s.journalPath(), "error", err)
+	}
 	data, err := os.ReadFile(s.indexPath())
 	if err != nil {
-		return
+		if !errors.Is(err, os.ErrNotExist) {
+			s.log.Warn("read index, starting empty", "path", s.indexPath(), "error", err)
+		}
+	} else {
+		f := indexFile{Blocks: s.index}
+		if err := json.Unmarshal(data, &f); err != nil {
+			s.log.Warn("decode index, starting empty", "path", s.indexPath(), "error", err)
+			clear(s.index)
+		}
+	}
+	journaled := s.replayJournal(journal)
+	if len(s.index) == 0 && s.replicate && s.remotePath != "" {
+		s.recoverReplicas()
 	}
-	json.Unmarshal(data, &s.index)
 
-	// Recalculate usage.
+	// Recalculate usage and pins.
 	for _, meta := range s.index {
-		if meta.Tier == "local" {
-			s.localUsed += int64(meta.SizeBytes)
-		} else {
-			s.remoteUsed += int64(meta.SizeBytes)
+		if meta.Pinned {
+			s.pinned[meta.Key.Seq] = true
+		}
+		if meta.Replica {
+			s.addUsage(meta, "remote", int64(storedSize(meta)))
+		}
+		if meta.Bundle != nil {
+			info, ok := s.bundles[meta.Bundle.File]
+			if !ok {
+				info = &bundleInfo{}
+				s.bundles[meta.Bundle.File] = info
+			}
+			info.live++
+			info.dead -= meta.Bundle.Length
+		}
+		if meta.Share != "" {
+			if s.shares[meta.Share]++; s.shares[meta.Share] > 1 {
+				continue // counted with the group's first entry
+			}
+		}
+		s.addUsage(meta, meta.Tier, int64(storedSize(meta)))
+	}
+	// Whatever a bundle holds beyond its live blocks is dead space.
+	for name, info := range s.bundles {
+		size, err := bundlePayloadSize(filepath.Join(s.remotePath, bundleDir, name))
+		if err != nil {
+			s.log.Warn("read bundle size", "bundle", name, "error", err)
+			info.dead = 0
+			continue
 		}
+		info.size = size
+		info.dead += size
+		s.chargeBundle(info, filepath.Join(s.remotePath, bundleDir, name))
 	}
+	s.rebuildBloom()
+	s.log.Debug("loaded index", "blocks", len(s.index))
+	return journaled
 }
 
 // Uint32Bytes is a helper for encoding position as bytes.
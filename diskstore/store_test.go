@@ -1,6 +1,7 @@
 package diskstore
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -46,6 +47,7 @@ func TestPutAndGet(t *testing.T) {
 }
 
 func TestPutAndGetCompressed(t *testing.T) {
+	requireZstd(t)
 	dir := t.TempDir()
 	store, err := New(Config{
 		LocalPath:   filepath.Join(dir, "local"),
@@ -80,7 +82,7 @@ func TestPutAndGetCompressed(t *testing.T) {
 	}
 
 	// Verify on-disk size is smaller than original.
-	path := store.blockPath(key, "local")
+	path := store.blockPath(key, "local", 0)
 	fi, _ := os.Stat(path)
 	if fi.Size() >= int64(len(data)) {
 		t.Errorf("compressed file (%d) should be smaller than original (%d)", fi.Size(), len(data))
@@ -128,6 +130,44 @@ func TestEvictLocalToRemote(t *testing.T) {
 	}
 }
 
+func TestEvictCounters(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  5000,
+		RemoteBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := func(i int) BlockKey {
+		return BlockKey{Seq: 0, Layer: 0, BeginPos: int32(i), EndPos: int32(i + 1), IsKey: true}
+	}
+	for i := 0; i < 5; i++ {
+		if err := store.Put(key(i), "f16", []int{128, 1}, make([]byte, 2000)); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+	for i := 0; i < 5; i++ {
+		if _, _, err := store.Get(key(i)); err != nil {
+			t.Fatalf("Get %d: %v", i, err)
+		}
+	}
+	store.Get(BlockKey{Seq: 9, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true})
+
+	stats := store.Stats()
+	if stats.RemoteBlocks == 0 {
+		t.Fatal("nothing evicted to the remote tier")
+	}
+	if stats.Puts != 5 || stats.Hits != 5 || stats.Misses != 1 || stats.Evictions != int64(stats.RemoteBlocks) {
+		t.Errorf("counters: got puts=%d hits=%d misses=%d evictions=%d (remote blocks %d)",
+			stats.Puts, stats.Hits, stats.Misses, stats.Evictions, stats.RemoteBlocks)
+	}
+}
+
 func TestRemoveSeq(t *testing.T) {
 	dir := t.TempDir()
 	store, err := New(Config{
@@ -233,3 +273,159 @@ func TestIndexPersistence(t *testing.T) {
 		t.Error("index not persisted across close/reopen")
 	}
 }
+
+func TestGC(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	gone := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(gone, "f16", []int{128}, make([]byte, 64))
+	os.Remove(store.blockPath(gone, "local", 0))
+
+	orphan := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	path := store.blockPath(orphan, "local", 0)
+	os.MkdirAll(filepath.Dir(path), 0755)
+	os.WriteFile(path, make([]byte, 32), 0644)
+
+	res, err := store.GC()
+	if err != nil {
+		t.Fatalf("GC: %v", err)
+	}
+	if res.MissingBlocks != 1 || res.OrphanFiles != 1 || res.FreedBytes != 32 {
+		t.Errorf("GC = %+v, want 1 missing, 1 orphan, 32 bytes", res)
+	}
+	if store.Has(gone) {
+		t.Error("missing block still indexed after GC")
+	}
+}
+
+func TestMigrateAndVerify(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1024 * 1024,
+		RemoteBudget: 1024 * 1024,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	for seq := 0; seq < 2; seq++ {
+		for i := int32(0); i < 3; i++ {
+			key := BlockKey{Seq: seq, Layer: 0, BeginPos: i, EndPos: i + 1, IsKey: true}
+			store.Put(key, "f16", []int{128}, make([]byte, 100))
+		}
+	}
+
+	n, err := store.Migrate(1, "remote")
+	if err != nil || n != 3 {
+		t.Fatalf("Migrate to remote: moved %d, err %v", n, err)
+	}
+	if st := store.Stats(); st.RemoteBlocks != 3 || st.LocalBlocks != 3 {
+		t.Errorf("after migrate: %d local, %d remote, want 3/3", st.LocalBlocks, st.RemoteBlocks)
+	}
+	if n, err := store.Migrate(-1, "local"); err != nil || n != 3 {
+		t.Fatalf("Migrate to local: moved %d, err %v", n, err)
+	}
+
+	if res := store.Verify(); res.Checked != 6 || len(res.Corrupt)+len(res.Missing) != 0 {
+		t.Errorf("Verify = %+v, want 6 clean blocks", res)
+	}
+
+	// A full destination stops the move.
+	store.mu.Lock()
+	store.remoteBudget = 150
+	store.mu.Unlock()
+	if n, err := store.Migrate(1, "remote"); !errors.Is(err, ErrBudgetExceeded) || n != 1 {
+		t.Errorf("Migrate past the remote budget: moved %d, err %v; want 1, ErrBudgetExceeded", n, err)
+	}
+	bad := BlockKey{Seq: 0, Layer: 0, BeginPos: 1, EndPos: 2, IsKey: true}
+	os.WriteFile(store.blockPath(bad, "local", 0), []byte("x"), 0644)
+	if res := store.Verify(); len(res.Corrupt) != 1 || res.Corrupt[0] != bad {
+		t.Errorf("Verify corrupt = %v, want [%s]", res.Corrupt, bad)
+	}
+}
+
+func TestStoredBytesAccounting(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		LocalBudget:  1024 * 1024,
+		RemoteBudget: 1024 * 1024,
+		Compress:     true,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(key, "f16", []int{128}, make([]byte, 8192))
+	stored := int64(store.Blocks(1)[0].StoredBytes)
+	if stored == 0 || stored >= 8192 {
+		t.Fatalf("StoredBytes = %d, want a compressed size", stored)
+	}
+	if st := store.Stats(); st.LocalUsed != stored || st.LocalLogical != 8192 {
+		t.Errorf("local used %d, logical %d; want %d, 8192", st.LocalUsed, st.LocalLogical, stored)
+	}
+
+	// Usage follows the block across tiers and a reopen, and is all
+	// released when it is removed.
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	store.Close()
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if st := store.Stats(); st.LocalUsed != 0 || st.RemoteUsed != stored || st.RemoteLogical != 8192 {
+		t.Errorf("after migrate: local used %d, remote used %d, logical %d; want 0, %d, 8192",
+			st.LocalUsed, st.RemoteUsed, st.RemoteLogical, stored)
+	}
+	store.Delete(key)
+	if st := store.Stats(); st.LocalUsed != 0 || st.RemoteUsed != 0 || st.RemoteLogical != 0 {
+		t.Errorf("after delete: used %d/%d, logical %d; want 0", st.LocalUsed, st.RemoteUsed, st.RemoteLogical)
+	}
+}
+
+func TestAccessHistoryPersistence(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+	}
+
+	store, _ := New(cfg)
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+	for i := 0; i < 6; i++ {
+		store.Get(key)
+	}
+	store.Close()
+
+	store2, _ := New(cfg)
+	defer store2.Close()
+	blocks := store2.Blocks(0)
+	if len(blocks) != 1 {
+		t.Fatalf("Blocks: got %d, want 1", len(blocks))
+	}
+	if blocks[0].Hits != 6 {
+		t.Errorf("Hits = %d after reopen, want 6", blocks[0].Hits)
+	}
+	if n := len(blocks[0].RecentAccesses()); n != recentAccesses {
+		t.Errorf("RecentAccesses: got %d, want %d", n, recentAccesses)
+	}
+}
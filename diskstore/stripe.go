@@ -0,0 +1,165 @@
+package diskstore
+
+import (
+	"fmt"
+	"hash/fnv"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Striping: a host with several local SSDs spreads the local tier over
+// all of them with Config.LocalStripes, one directory per device, so
+// snapshot writes, whose writeback the kernel runs per device, and
+// parallel evictions use their combined bandwidth. LocalPath keeps the
+// index and the store's other files and may be listed as a stripe too.
+//
+// Each new or promoted block goes to one stripe, picked round-robin or
+// by a hash of its key (StripeMode), passing over stripes without room
+// for it in their own budget; BlockMeta.Stripe records which. The
+// local budget, evictions included, still applies to the stripes
+// together.
+
+// Stripe modes for Config.StripeMode.
+const (
+	StripeRoundRobin = "round-robin" // each block on the next stripe
+	StripeHash       = "hash"        // each block where its key hashes to
+)
+
+// LocalStripe is one device of a striped local tier.
+type LocalStripe struct {
+	Path string
+
+	// Budget is the most bytes of blocks the stripe holds; zero leaves
+	// it bounded by the local budget alone.
+	Budget int64
+}
+
+// StripeStats is the usage of one local stripe.
+type StripeStats struct {
+	Path   string `json:"path"`
+	Blocks int    `json:"blocks"`
+	Used   int64  `json:"used"`
+	Budget int64  `json:"budget,omitempty"`
+}
+
+// stripe is a local stripe in use. used is guarded by s.mu.
+type stripe struct {
+	path   string
+	budget int64
+	used   int64
+}
+
+// checkStripes validates the striping settings.
+func checkStripes(cfg Config) error {
+	switch cfg.StripeMode {
+	case "", StripeRoundRobin, StripeHash:
+	default:
+		return fmt.Errorf("diskstore: unknown stripe mode %q", cfg.StripeMode)
+	}
+	seen := make(map[string]bool)
+	for _, st := range cfg.LocalStripes {
+		p := filepath.Clean(st.Path)
+		if st.Path == "" || seen[p] {
+			return fmt.Errorf("diskstore: local stripes need distinct paths")
+		}
+		seen[p] = true
+	}
+	return nil
+}
+
+// ParseStripes parses local stripes from a comma-separated list of
+// path[:budgetGB]; e.g. "/mnt/nvme0:400,/mnt/nvme1:400". An empty spec
+// is no striping.
+func ParseStripes(spec string) ([]LocalStripe, error) {
+	var stripes []LocalStripe
+	for _, s := range strings.Split(spec, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		path, gb, ok := strings.Cut(s, ":")
+		st := LocalStripe{Path: path}
+		if ok {
+			n, err := strconv.ParseFloat(gb, 64)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("diskstore: stripe %q: invalid budget %q", s, gb)
+			}
+			st.Budget = int64(n * 1024 * 1024 * 1024)
+		}
+		if path == "" {
+			return nil, fmt.Errorf("diskstore: stripe %q: want path[:budgetGB]", s)
+		}
+		stripes = append(stripes, st)
+	}
+	return stripes, nil
+}
+
+// pickStripe returns the stripe a local block of key, size bytes, goes
+// to: the first with room from the one round-robin or its hash picks,
+// or failing that the one with the most room. Must be called with s.mu
+// held.
+func (s *Store) pickStripe(key BlockKey, size int64) int {
+	n := len(s.stripes)
+	if n <= 1 {
+		return 0
+	}
+	var start int
+	if s.stripeMode == StripeHash {
+		h := fnv.New32a()
+		h.Write([]byte(key.String()))
+		start = int(h.Sum32() % uint32(n))
+	} else {
+		start = s.stripeNext
+		s.stripeNext = (start + 1) % n
+	}
+	best := start
+	for i := range n {
+		j := (start + i) % n
+		st := s.stripes[j]
+		if st.budget <= 0 || st.used+size <= st.budget {
+			return j
+		}
+		if st.budget-st.used > s.stripes[best].budget-s.stripes[best].used {
+			best = j
+		}
+	}
+	return best
+}
+
+// localBase returns the directory holding local blocks of stripe.
+func (s *Store) localBase(stripe int) string {
+	if stripe < len(s.stripes) {
+		return s.stripes[stripe].path
+	}
+	return s.localPath
+}
+
+// localBases returns every directory that may hold local blocks.
+func (s *Store) localBases() []string {
+	if len(s.stripes) == 0 {
+		return []string{s.localPath}
+	}
+	bases := make([]string, len(s.stripes))
+	for i, st := range s.stripes {
+		bases[i] = st.path
+	}
+	return bases
+}
+
+// stripeStats returns the usage of each stripe. Must be called with s.mu
+// held.
+func (s *Store) stripeStats() []StripeStats {
+	if len(s.stripes) == 0 {
+		return nil
+	}
+	out := make([]StripeStats, len(s.stripes))
+	for i, st := range s.stripes {
+		out[i] = StripeStats{Path: st.path, Used: st.used, Budget: st.budget}
+	}
+	for _, meta := range s.index {
+		if meta.Tier == "local" && meta.Stripe < len(out) {
+			out[meta.Stripe].Blocks++
+		}
+	}
+	return out
+}
@@ -0,0 +1,132 @@
+package diskstore
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalStripes(t *testing.T) {
+	dir := t.TempDir()
+	ssd0, ssd1 := filepath.Join(dir, "ssd0"), filepath.Join(dir, "ssd1")
+	cfg := Config{
+		LocalPath:    filepath.Join(dir, "local"),
+		RemotePath:   filepath.Join(dir, "remote"),
+		RemoteBudget: 1024 * 1024,
+		LocalStripes: []LocalStripe{
+			{Path: ssd0, Budget: 500},
+			{Path: ssd1, Budget: 300},
+		},
+		StatsInterval: -1,
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	key := func(i int32) BlockKey {
+		return BlockKey{Seq: 1, BeginPos: i, EndPos: i + 1, IsKey: true}
+	}
+	data := func(i int32) []byte {
+		return bytes.Repeat([]byte{byte(i)}, 100)
+	}
+	used := func(store *Store) (int64, int64) {
+		st := store.Stats().Stripes
+		if len(st) != 2 {
+			t.Fatalf("Stats has %d stripes, want 2", len(st))
+		}
+		return st[0].Used, st[1].Used
+	}
+
+	// Blocks alternate between the stripes until one is full.
+	const n = 8
+	for i := range int32(n) {
+		if err := store.Put(key(i), "f16", []int{50}, data(i)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if a, b := used(store); a != 500 || b != 300 {
+		t.Errorf("stripes hold %d and %d bytes, want 500 and 300", a, b)
+	}
+	if st := store.Stats(); st.LocalBudget != 800 || st.Evictions != 0 {
+		t.Errorf("LocalBudget = %d, Evictions = %d; want 800, 0", st.LocalBudget, st.Evictions)
+	}
+	for i := range int32(n) {
+		meta := store.index[key(i).String()]
+		if _, err := os.Stat(filepath.Join([]string{ssd0, ssd1}[meta.Stripe], "01", key(i).fileName()+".kvblk")); err != nil {
+			t.Errorf("block %d not on stripe %d: %v", i, meta.Stripe, err)
+		}
+	}
+
+	// Blocks moved back from the remote tier are striped too.
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if a, b := used(store); a != 0 || b != 0 {
+		t.Errorf("stripes hold %d and %d bytes after migrating away", a, b)
+	}
+	if _, err := store.Migrate(1, "local"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	if a, b := used(store); a+b != 800 || b > 300 {
+		t.Errorf("stripes hold %d and %d bytes after migrating back", a, b)
+	}
+
+	// GC finds orphans on every stripe.
+	orphan := filepath.Join(ssd1, "01", "orphan.kvblk")
+	if err := os.WriteFile(orphan, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if res, err := store.GC(); err != nil || res.OrphanFiles != 1 || res.MissingBlocks != 0 {
+		t.Errorf("GC = %+v, %v; want 1 orphan", res, err)
+	}
+	store.Close()
+
+	store, err = New(cfg)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer store.Close()
+	if a, b := used(store); a+b != 800 {
+		t.Errorf("reopened stripes hold %d and %d bytes, want 800 together", a, b)
+	}
+	for i := range int32(n) {
+		if got, _, err := store.Get(key(i)); err != nil || !bytes.Equal(got, data(i)) {
+			t.Errorf("Get(%d) = %d bytes, %v", i, len(got), err)
+		}
+	}
+
+	// Hash striping puts a key where it hashes to.
+	store.mu.Lock()
+	store.stripeMode = StripeHash
+	a, b := store.pickStripe(key(1), 0), store.pickStripe(key(1), 0)
+	store.mu.Unlock()
+	if a != b {
+		t.Errorf("hash striping picked stripes %d and %d for one key", a, b)
+	}
+
+	for _, bad := range []Config{
+		{LocalPath: cfg.LocalPath, LocalStripes: []LocalStripe{{Path: ssd0}, {Path: ssd0 + "/"}}},
+		{LocalPath: cfg.LocalPath, LocalStripes: cfg.LocalStripes, StripeMode: "random"},
+	} {
+		if _, err := New(bad); err == nil {
+			t.Errorf("New accepted stripes %+v, mode %q", bad.LocalStripes, bad.StripeMode)
+		}
+	}
+}
+
+func TestParseStripes(t *testing.T) {
+	stripes, err := ParseStripes("/mnt/nvme0:400, /mnt/nvme1")
+	if err != nil {
+		t.Fatalf("ParseStripes: %v", err)
+	}
+	if len(stripes) != 2 || stripes[0] != (LocalStripe{"/mnt/nvme0", 400 << 30}) || stripes[1] != (LocalStripe{Path: "/mnt/nvme1"}) {
+		t.Errorf("ParseStripes = %+v", stripes)
+	}
+	for _, spec := range []string{":1", "/mnt/nvme0:x"} {
+		if _, err := ParseStripes(spec); err == nil {
+			t.Errorf("ParseStripes(%q) accepted", spec)
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package diskstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// syncFS writes everything cached for the filesystem holding dir to
+// disk: every block file written there and the directories naming them.
+func syncFS(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, _, errno := syscall.Syscall(sysSyncfs, f.Fd(), 0, 0); errno != 0 {
+		return &os.PathError{Op: "syncfs", Path: dir, Err: errno}
+	}
+	return nil
+}
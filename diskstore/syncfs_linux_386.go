@@ -0,0 +1,4 @@
+package diskstore
+
+// sysSyncfs is syncfs, which the syscall package leaves out on 386.
+const sysSyncfs = 344
@@ -0,0 +1,9 @@
+//go:build !unix || aix
+
+package diskstore
+
+// syncFS can't sync a filesystem here (Windows needs an administrator's
+// volume handle for that, and AIX's syscall package has no sync): block
+// files are left to the system to write back, and only the files
+// replaceFile writes are synced.
+func syncFS(dir string) error { return nil }
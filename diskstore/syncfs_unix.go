@@ -0,0 +1,12 @@
+//go:build unix && !linux && !aix
+
+package diskstore
+
+import "syscall"
+
+// syncFS writes everything cached for the filesystem holding dir to
+// disk. Without syncfs that takes syncing every filesystem.
+func syncFS(dir string) error {
+	syscall.Sync()
+	return nil
+}
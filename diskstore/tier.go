@@ -0,0 +1,291 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Tier is a block store that can back the remote tier: another machine's
+// store reached over the network (see RemoteClient), or any other
+// implementation. *Store implements it too.
+//
+// A Store using a Tier hands it already-encoded payloads as opaque data,
+// so the Tier may apply its own compression but must return exactly the
+// bytes it was given.
+type Tier interface {
+	BlockReader
+	Put(key BlockKey, dtype string, shape []int, data []byte) error
+	Has(key BlockKey) bool
+	Delete(key BlockKey) error
+}
+
+var (
+	_ Tier = (*Store)(nil)
+	_ Tier = (*RemoteClient)(nil)
+)
+
+// ExpiringTier is a Tier that drops blocks by itself once they have gone
+// unread for a while, such as RedisTier. The store writes blocks of a
+// namespace whose profile has a TTL with PutTTL, giving the time they
+// have left, and reads them with GetTTL, so the tier drops a block when
+// the store's TTL sweep would.
+type ExpiringTier interface {
+	Tier
+	// PutTTL is Put for a block to drop after ttl.
+	PutTTL(key BlockKey, dtype string, shape []int, data []byte, ttl time.Duration) error
+	// GetTTL is Get, giving up when ctx is done, restarting the block's
+	// ttl.
+	GetTTL(ctx context.Context, key BlockKey, ttl time.Duration) ([]byte, *BlockMeta, error)
+}
+
+// RangeReader is implemented by tiers that can read part of a stored
+// payload, such as WebDAVTier. ReadRange uses it to fetch only the rows
+// it needs of blocks on the remote tier service (see partialread.go).
+type RangeReader interface {
+	// GetRange returns n bytes of the payload stored for key from byte
+	// off on. A missing block yields an error wrapping ErrNotFound.
+	GetRange(ctx context.Context, key BlockKey, off, n int) ([]byte, error)
+}
+
+// OpenTierURL returns the remote tier service rawURL names:
+//
+//	gs://bucket[/prefix]                 Google Cloud Storage (see GCSTier)
+//	azblob://account/container[/prefix]  Azure Blob Storage (see AzureTier)
+//	redis://[[user]:password@]host:port[/db][?prefix=p]  Redis (see RedisTier)
+//	dav[s]://[user:password@]host[:port]/path  WebDAV over http[s] (see WebDAVTier)
+//	kvblock://host:port[,host:port...][?hedge=d&check=d]  kvblockd block services (see RemoteClient)
+//
+// Several kvblock hosts are replicas of each other, read and written
+// through a ReplicaSet: hedge is its ReplicaConfig.HedgeAfter and check
+// its CheckInterval, both off by default.
+//
+// Cloud credentials are taken from the environment as the cloud SDKs
+// take them: for GCS, the service account key file
+// GOOGLE_APPLICATION_CREDENTIALS names, or else the metadata server of
+// the VM; for Azure, the account key in AZURE_STORAGE_KEY or else the SAS
+// token in AZURE_STORAGE_SAS_TOKEN. Requests time out after timeout
+// (default 30s).
+func OpenTierURL(rawURL string, timeout time.Duration) (Tier, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: tier %q: %w", rawURL, err)
+	}
+	prefix := strings.Trim(u.Path, "/")
+	var t Tier
+	switch u.Scheme {
+	case "gs":
+		t, err = NewGCSTier(GCSConfig{
+			Bucket:          u.Host,
+			Prefix:          prefix,
+			CredentialsFile: os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"),
+			Timeout:         timeout,
+		})
+	case "azblob":
+		container, prefix, _ := strings.Cut(prefix, "/")
+		t, err = NewAzureTier(AzureConfig{
+			Account:   u.Host,
+			Container: container,
+			Prefix:    prefix,
+			Key:       os.Getenv("AZURE_STORAGE_KEY"),
+			SAS:       os.Getenv("AZURE_STORAGE_SAS_TOKEN"),
+			Timeout:   timeout,
+		})
+	case "redis":
+		cfg := RedisConfig{Addr: u.Host, Prefix: u.Query().Get("prefix"), Timeout: timeout}
+		if prefix != "" {
+			if cfg.DB, err = strconv.Atoi(prefix); err != nil {
+				return nil, fmt.Errorf("diskstore: tier %q: invalid database %q", rawURL, prefix)
+			}
+		}
+		if u.User != nil {
+			cfg.Username = u.User.Username()
+			cfg.Password, _ = u.User.Password()
+		}
+		t, err = NewRedisTier(cfg)
+	case "dav", "davs":
+		cfg := WebDAVConfig{Timeout: timeout}
+		if u.User != nil {
+			cfg.Username = u.User.Username()
+			cfg.Password, _ = u.User.Password()
+		}
+		dav := *u
+		dav.Scheme, dav.User = "http", nil
+		if u.Scheme == "davs" {
+			dav.Scheme = "https"
+		}
+		cfg.URL = dav.String()
+		t, err = NewWebDAVTier(cfg)
+	case "kvblock":
+		t, err = openBlockServices(u, timeout)
+	default:
+		return nil, fmt.Errorf("diskstore: tier %q: unknown scheme %q (want gs, azblob, redis, dav, davs or kvblock)", rawURL, u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Delete removes a single block. Deleting a missing block is not an
+// error.
+func (s *Store) Delete(key BlockKey) error {
+	if err := s.writable(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.trace.record(TraceOp{Op: TraceDelete, Key: key})
+	meta, ok := s.index[key.String()]
+	if !ok {
+		return nil
+	}
+	if err := s.removePayload(meta); err != nil {
+		return fmt.Errorf("diskstore: delete %s: %w", key, err)
+	}
+	s.releaseUsage(meta, int64(storedSize(meta)))
+	delete(s.index, key.String())
+	s.indexChanged(key)
+	s.emit(EventRemove, meta)
+	if !s.hasSeq(key.Seq) {
+		s.markExpired(key.Seq)
+	}
+	return nil
+}
+
+// onService reports whether blocks on tier live on Config.RemoteTier
+// rather than in a directory.
+func (s *Store) onService(tier string) bool {
+	return tier == "remote" && s.remote != nil
+}
+
+// hasRemote reports whether the store has a remote tier of either kind.
+func (s *Store) hasRemote() bool {
+	return s.remotePath != "" || s.remote != nil
+}
+
+// readPayload reads the stored (encoded) payload of meta from wherever it
+// lives, as a transfer of class (see bandwidth.go), with the retries of
+// remote tier I/O (see remoteio.go), giving up when ctx is done. A
+// missing block yields an error wrapping os.ErrNotExist.
+func (s *Store) readPayload(ctx context.Context, meta *BlockMeta, class ioClass) ([]byte, error) {
+	if err := s.throttleContext(ctx, meta.Tier, class, storedSize(meta)); err != nil {
+		return nil, err
+	}
+	if meta.Tier == "remote" {
+		m := *meta
+		return remoteCall(ctx, s, func() ([]byte, error) { return s.readStored(ctx, &m) })
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.readStored(ctx, meta)
+}
+
+// readStored is readPayload without throttling or retries. Only a
+// remote tier service that is a ContextReader sees ctx; file reads run
+// to the end once started.
+func (s *Store) readStored(ctx context.Context, meta *BlockMeta) ([]byte, error) {
+	switch {
+	case meta.Bundle != nil:
+		return s.readBundled(meta.Bundle)
+	case s.onService(meta.Tier):
+		var data []byte
+		var err error
+		if et, ok := s.remote.(ExpiringTier); ok && s.ttlFor(meta) > 0 {
+			data, _, err = et.GetTTL(ctx, meta.Key, s.ttlFor(meta))
+		} else if r, ok := s.remote.(ContextReader); ok {
+			data, _, err = r.GetContext(ctx, meta.Key)
+		} else {
+			data, _, err = s.remote.Get(meta.Key)
+		}
+		if errors.Is(err, ErrNotFound) || err == nil && data == nil {
+			err = fmt.Errorf("remote tier: %w", os.ErrNotExist)
+		}
+		return data, err
+	case meta.Tier == "local":
+		return s.readLocal(s.metaPath(meta))
+	default:
+		return readFile(s.metaPath(meta))
+	}
+}
+
+// writePayload stores an encoded payload for key on tier, in stripe if
+// it is the local one. Writes to the
+// remote tier are migrations (see bandwidth.go), and retried (see
+// remoteio.go).
+func (s *Store) writePayload(meta *BlockMeta, tier string, stripe int, payload []byte) error {
+	s.throttle(tier, migrationIO, len(payload))
+	path := s.blockPath(meta.Key, tier, stripe)
+	switch {
+	case s.onService(tier):
+		key, dtype, shape := meta.Key, meta.DTypeStr, meta.Shape
+		if et, ok := s.remote.(ExpiringTier); ok && s.ttlFor(meta) > 0 {
+			// What the block has left, at least a second.
+			ttl := max(s.ttlFor(meta)-time.Since(meta.AccessedAt), time.Second)
+			return remoteDo(s, func() error { return et.PutTTL(key, dtype, shape, payload, ttl) })
+		}
+		return remoteDo(s, func() error { return s.remote.Put(key, dtype, shape, payload) })
+	case tier == "local":
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return err
+		}
+		return s.writeLocal(path, payload)
+	default:
+		return remoteDo(s, func() error {
+			if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+				return err
+			}
+			return os.WriteFile(path, payload, 0644)
+		})
+	}
+}
+
+// removePayload deletes meta's stored payload, and its remote replica
+// if it has one, releasing its bundle if it is archived. A remote
+// payload that can't be removed while the remote tier is unavailable is
+// removed on re-sync (see degraded.go). Must be called with s.mu held.
+func (s *Store) removePayload(meta *BlockMeta) error {
+	if meta.Replica {
+		r := replicaOf(meta)
+		if err := s.removePayload(&r); err != nil {
+			s.log.Warn("remove replica", "key", meta.Key, "error", err)
+		}
+		s.replicasChanged = true
+	}
+	var err error
+	switch {
+	case meta.Bundle != nil:
+		s.releaseBundled(meta)
+		return nil
+	case s.onService(meta.Tier):
+		key := meta.Key
+		err = remoteDo(s, func() error { return s.remote.Delete(key) })
+	case meta.Tier == "remote":
+		path := s.metaPath(meta)
+		err = remoteDo(s, func() error { return removeFile(path) })
+	default:
+		return removeFile(s.metaPath(meta))
+	}
+	if errors.Is(err, ErrRemoteUnavailable) {
+		s.deferRemoval(meta)
+		return nil
+	}
+	return err
+}
+
+// removeFile removes path; a missing file is not an error.
+func removeFile(path string) error {
+	err := os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,291 @@
+package diskstore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// Operation traces: with Config.TracePath set the store appends a record
+// of every Put, read and removal it is asked for to a trace file, so a
+// real workload can be replayed later against other budgets, tier
+// layouts or eviction policies (see Replay). Evictions, promotions and
+// drops are the store's own decisions and aren't recorded: replaying
+// the trace makes them again under the new settings.
+//
+// The file starts with traceMagic and a version byte, then holds
+// records of an op byte followed by unsigned varints, signed varints
+// (zigzag) and length-prefixed strings:
+//
+//	start      unix nanoseconds                 (each time a store opens it)
+//	put        Δt, key, dtype, logical size
+//	get        Δt, key                          (op's hit bit set on a hit)
+//	delete     Δt, key
+//	removeSeq  Δt, seq
+//	truncate   Δt, seq, end position
+//
+// Δt is the microseconds since the previous record. A key is its
+// namespace, prefix, seq, layer, begin and end positions, the op's key
+// bit telling keys from values. Records are buffered and written when
+// the buffer fills and on Close, so a crash loses the last few.
+
+// traceMagic starts a trace file.
+const traceMagic = "KVTR"
+
+const traceVersion = 1
+
+// Trace operations.
+const (
+	TraceStart     = 1
+	TracePut       = 2
+	TraceGet       = 3
+	TraceDelete    = 4
+	TraceRemoveSeq = 5
+	TraceTruncate  = 6
+)
+
+// Flag bits of the op byte.
+const (
+	traceIsKey = 0x40
+	traceHit   = 0x80
+	traceOp    = 0x3f
+)
+
+// TraceOp is one record of a trace.
+type TraceOp struct {
+	Op    int
+	At    time.Time
+	Key   BlockKey // only Seq for TraceRemoveSeq, Seq and EndPos for TraceTruncate
+	DType string   // TracePut
+	Size  int      // TracePut: the logical size
+	Hit   bool     // TraceGet
+}
+
+// tracer appends records to a trace file.
+type tracer struct {
+	mu   sync.Mutex
+	f    *os.File
+	w    *bufio.Writer
+	last time.Time
+	buf  []byte
+	err  error
+}
+
+// openTracer opens path for appending records, writing the header if
+// the file is new, and records the start of a session.
+func openTracer(path string) (*tracer, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: open trace: %w", err)
+	}
+	t := &tracer{f: f, w: bufio.NewWriterSize(f, 64<<10), last: time.Now()}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		t.w.WriteString(traceMagic)
+		t.w.WriteByte(traceVersion)
+	}
+	t.buf = append(t.buf[:0], TraceStart)
+	t.buf = binary.AppendUvarint(t.buf, uint64(t.last.UnixNano()))
+	t.w.Write(t.buf)
+	return t, nil
+}
+
+// record appends one operation. Errors are kept for close; tracing never
+// fails the operation it records.
+func (t *tracer) record(op TraceOp) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err != nil {
+		return
+	}
+	now := time.Now()
+	b := t.buf[:0]
+	code := byte(op.Op)
+	if op.Key.IsKey {
+		code |= traceIsKey
+	}
+	if op.Hit {
+		code |= traceHit
+	}
+	b = append(b, code)
+	b = binary.AppendUvarint(b, uint64(max(now.Sub(t.last)/time.Microsecond, 0)))
+	t.last = now
+	switch op.Op {
+	case TraceRemoveSeq:
+		b = binary.AppendVarint(b, int64(op.Key.Seq))
+	case TraceTruncate:
+		b = binary.AppendVarint(b, int64(op.Key.Seq))
+		b = binary.AppendVarint(b, int64(op.Key.EndPos))
+	default:
+		b = appendTraceString(b, op.Key.Namespace)
+		b = appendTraceString(b, op.Key.Prefix)
+		b = binary.AppendVarint(b, int64(op.Key.Seq))
+		b = binary.AppendVarint(b, int64(op.Key.Layer))
+		b = binary.AppendVarint(b, int64(op.Key.BeginPos))
+		b = binary.AppendVarint(b, int64(op.Key.EndPos))
+		if op.Op == TracePut {
+			b = appendTraceString(b, op.DType)
+			b = binary.AppendUvarint(b, uint64(op.Size))
+		}
+	}
+	t.buf = b
+	_, t.err = t.w.Write(b)
+}
+
+// flush writes the buffered records.
+func (t *tracer) flush() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.err == nil {
+		t.err = t.w.Flush()
+	}
+	if t.err != nil {
+		return fmt.Errorf("diskstore: write trace: %w", t.err)
+	}
+	return nil
+}
+
+func (t *tracer) close() error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	err := t.err
+	if ferr := t.w.Flush(); err == nil {
+		err = ferr
+	}
+	if cerr := t.f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: write trace: %w", err)
+	}
+	return nil
+}
+
+func appendTraceString(b []byte, s string) []byte {
+	b = binary.AppendUvarint(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// TraceReader reads the records of a trace file.
+type TraceReader struct {
+	r    *bufio.Reader
+	last time.Time
+}
+
+// NewTraceReader checks the header of a trace file and returns a reader
+// of its records.
+func NewTraceReader(r io.Reader) (*TraceReader, error) {
+	br := bufio.NewReader(r)
+	head := make([]byte, len(traceMagic)+1)
+	if _, err := io.ReadFull(br, head); err != nil || string(head[:len(traceMagic)]) != traceMagic {
+		return nil, errors.New("diskstore: not a trace file")
+	}
+	if head[len(traceMagic)] != traceVersion {
+		return nil, fmt.Errorf("diskstore: trace version %d, want %d", head[len(traceMagic)], traceVersion)
+	}
+	return &TraceReader{r: br}, nil
+}
+
+// Next returns the next operation, or io.EOF after the last. Session
+// starts are folded into the times of the operations that follow them.
+func (tr *TraceReader) Next() (TraceOp, error) {
+	for {
+		code, err := tr.r.ReadByte()
+		if err != nil {
+			return TraceOp{}, err
+		}
+		op, err := tr.read(code)
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				err = io.ErrUnexpectedEOF
+			}
+			return TraceOp{}, fmt.Errorf("diskstore: read trace: %w", err)
+		}
+		if op.Op != TraceStart {
+			return op, nil
+		}
+	}
+}
+
+func (tr *TraceReader) read(code byte) (TraceOp, error) {
+	op := TraceOp{Op: int(code & traceOp), Hit: code&traceHit != 0}
+	op.Key.IsKey = code&traceIsKey != 0
+	if op.Op == TraceStart {
+		ns, err := binary.ReadUvarint(tr.r)
+		tr.last = time.Unix(0, int64(ns))
+		return op, err
+	}
+	if op.Op < TracePut || op.Op > TraceTruncate {
+		return op, fmt.Errorf("unknown op %d", op.Op)
+	}
+	us, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return op, err
+	}
+	tr.last = tr.last.Add(time.Duration(us) * time.Microsecond)
+	op.At = tr.last
+
+	var ints []*int64
+	var seq, layer, begin, end int64
+	switch op.Op {
+	case TraceRemoveSeq:
+		ints = []*int64{&seq}
+	case TraceTruncate:
+		ints = []*int64{&seq, &end}
+	default:
+		if op.Key.Namespace, err = tr.string(); err != nil {
+			return op, err
+		}
+		if op.Key.Prefix, err = tr.string(); err != nil {
+			return op, err
+		}
+		ints = []*int64{&seq, &layer, &begin, &end}
+	}
+	for _, p := range ints {
+		if *p, err = binary.ReadVarint(tr.r); err != nil {
+			return op, err
+		}
+	}
+	op.Key.Seq, op.Key.Layer = int(seq), int(layer)
+	op.Key.BeginPos, op.Key.EndPos = int32(begin), int32(end)
+	if op.Op == TracePut {
+		if op.DType, err = tr.string(); err != nil {
+			return op, err
+		}
+		size, err := binary.ReadUvarint(tr.r)
+		if err != nil {
+			return op, err
+		}
+		if size > 1<<34 {
+			return op, fmt.Errorf("block of %d bytes", size)
+		}
+		op.Size = int(size)
+	}
+	return op, nil
+}
+
+func (tr *TraceReader) string() (string, error) {
+	n, err := binary.ReadUvarint(tr.r)
+	if err != nil {
+		return "", err
+	}
+	if n > 4096 {
+		return "", fmt.Errorf("string of %d bytes", n)
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(tr.r, b)
+	return string(b), err
+}
@@ -0,0 +1,143 @@
+package diskstore
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTraceRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trace")
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		LocalBudget:   1 << 20,
+		StatsInterval: -1,
+		TracePath:     path,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	key := BlockKey{Namespace: "ns", Seq: 3, Layer: 2, BeginPos: 16, EndPos: 32, IsKey: true}
+	store.Put(key, "f16", []int{8}, make([]byte, 100))
+	store.Get(key)
+	store.Get(BlockKey{Seq: 9, EndPos: 1})
+	store.Delete(key)
+	store.TruncateSeq(3, 8)
+	store.RemoveSeq(3)
+	if err := store.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr, err := NewTraceReader(f)
+	if err != nil {
+		t.Fatalf("NewTraceReader: %v", err)
+	}
+	var ops []TraceOp
+	for {
+		op, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		ops = append(ops, op)
+	}
+	want := []int{TracePut, TraceGet, TraceGet, TraceDelete, TraceTruncate, TraceRemoveSeq}
+	if len(ops) != len(want) {
+		t.Fatalf("%d ops, want %d: %+v", len(ops), len(want), ops)
+	}
+	for i, op := range ops {
+		if op.Op != want[i] {
+			t.Errorf("op %d = %d, want %d", i, op.Op, want[i])
+		}
+	}
+	if put := ops[0]; put.Key != key || put.DType != "f16" || put.Size != 100 || put.At.IsZero() {
+		t.Errorf("put = %+v", put)
+	}
+	if !ops[1].Hit || ops[1].Key != key {
+		t.Errorf("hit = %+v", ops[1])
+	}
+	if ops[2].Hit || ops[2].Key.Seq != 9 {
+		t.Errorf("miss = %+v", ops[2])
+	}
+	if tr := ops[4]; tr.Key.Seq != 3 || tr.Key.EndPos != 8 {
+		t.Errorf("truncate = %+v", tr)
+	}
+	if ops[5].Key.Seq != 3 {
+		t.Errorf("removeSeq = %+v", ops[5])
+	}
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "trace")
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "recorded"),
+		LocalBudget:   1 << 20,
+		StatsInterval: -1,
+		TracePath:     path,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	// Four blocks of 1000 bytes, each read twice.
+	for seq := range 4 {
+		store.Put(BlockKey{Seq: seq, EndPos: 1, IsKey: true}, "f16", []int{500}, make([]byte, 1000))
+	}
+	for range 2 {
+		for seq := range 4 {
+			store.Get(BlockKey{Seq: seq, EndPos: 1, IsKey: true})
+		}
+	}
+	store.Close()
+
+	// Replayed with local room for two blocks, the first two written
+	// are evicted and read from the remote tier.
+	replayed, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   2500,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer replayed.Close()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	tr, err := NewTraceReader(f)
+	if err != nil {
+		t.Fatalf("NewTraceReader: %v", err)
+	}
+	res, err := Replay(context.Background(), replayed, tr, ReplayOptions{})
+	if err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if res.Ops != 12 || res.Puts != 4 || res.Gets != 8 || res.Errors != 0 {
+		t.Errorf("result = %+v", res)
+	}
+	if res.RecordedHits != 8 {
+		t.Errorf("RecordedHits = %d, want 8", res.RecordedHits)
+	}
+	if res.Hits != 8 || res.HitRatio() != 1 {
+		t.Errorf("Hits = %d, ratio %v; want 8", res.Hits, res.HitRatio())
+	}
+	if res.Evictions < 2 || res.RemoteHits == 0 || res.LocalHits+res.RemoteHits != res.Hits {
+		t.Errorf("Evictions = %d, hits %d local %d remote; want evicted blocks read remotely",
+			res.Evictions, res.LocalHits, res.RemoteHits)
+	}
+}
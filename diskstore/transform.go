@@ -0,0 +1,133 @@
+package diskstore
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Transform is a reversible stage in the Put pipeline.
+//
+// On Put the configured transforms run in order (e.g. quantize → delta →
+// compress → encrypt); on Get they are reversed. The name of every stage
+// applied to a block is recorded in its BlockMeta, so a block written with
+// one chain can still be decoded after the chain is reconfigured, as long
+// as every transform it used is still registered.
+type Transform interface {
+	// Name identifies the transform in BlockMeta. It must be stable
+	// across runs and unique within a Store.
+	Name() string
+
+	// Encode transforms data on the way to disk.
+	Encode(data []byte) ([]byte, error)
+
+	// Decode reverses Encode.
+	Decode(data []byte) ([]byte, error)
+}
+
+// zstdTransformName is the name recorded for the built-in compression stage.
+const zstdTransformName = "zstd"
+
+// ErrNoZstd is returned for zstd-compressed data by builds with the
+// nozstd tag.
+var ErrNoZstd = errors.New("diskstore: built without zstd support (nozstd)")
+
+// encode runs the Put pipeline for a block of dtype, ending with the
+// local tier's codec if tiers have codecs (see codec.go), and returns the
+// payload together with the names of the stages applied and whether a
+// compression stage was skipped as incompressible (see entropy.go).
+func (s *Store) encode(dtype string, data []byte) ([]byte, []string, bool, error) {
+	payload := data
+	names := make([]string, 0, len(s.chain)+1)
+	chain := s.chain
+	if t := s.codecs["local"].stage; t != nil {
+		chain = append(chain[:len(chain):len(chain)], t)
+	}
+	skipped := false
+	for _, t := range chain {
+		if isCodec(t.Name()) && s.incompressible(dtype, payload) {
+			skipped = true
+			continue
+		}
+		out, err := t.Encode(payload)
+		if err != nil {
+			return nil, nil, false, fmt.Errorf("diskstore: transform %s: %w", t.Name(), err)
+		}
+		payload = out
+		names = append(names, t.Name())
+	}
+	return payload, names, skipped, nil
+}
+
+// decode reverses the stages recorded in meta. It takes over payload,
+// which it may return or recycle (see putBuf). With dst non-nil the data
+// is decoded or copied into dst[:0], grown if too small.
+func (s *Store) decode(meta *BlockMeta, payload, dst []byte) ([]byte, error) {
+	names := meta.Transforms
+	if len(names) == 0 && meta.Compressed {
+		// Blocks written before transforms were recorded.
+		names = []string{zstdTransformName}
+	}
+	if len(names) > 0 {
+		defer func(start time.Time) { s.countDecode(time.Since(start)) }(time.Now())
+	}
+
+	data := payload
+	owned := true // nothing else refers to data's memory
+	inDst := false
+	for i := len(names) - 1; i >= 0; i-- {
+		t, ok := s.transforms[names[i]]
+		if !ok && isCodec(names[i]) && !zstdAvailable {
+			return nil, fmt.Errorf("diskstore: block %s: %w", meta.Key, ErrNoZstd)
+		}
+		if !ok {
+			return nil, fmt.Errorf("diskstore: block %s: transform %q not configured", meta.Key, names[i])
+		}
+		var out []byte
+		var err error
+		if z, ok := t.(*zstdTransform); ok {
+			// The built-in stage decodes into a buffer of ours, and
+			// its input can be recycled.
+			var buf []byte
+			switch {
+			case i > 0:
+				buf = getBuf(0)
+			case dst != nil:
+				buf, inDst = dst[:0], true
+			default:
+				buf = getBuf(meta.SizeBytes)[:0]
+			}
+			out, err = z.decodeTo(buf, data)
+			if err == nil && owned {
+				putBuf(data)
+			}
+			owned = true
+		} else {
+			// Other stages may return their input or memory of their
+			// own.
+			out, err = t.Decode(data)
+			owned = false
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: reverse transform %s on block %s: %w", ErrCorrupted, names[i], meta.Key, err)
+		}
+		data = out
+	}
+	if dst != nil && !inDst {
+		out := append(dst[:0], data...)
+		if owned {
+			putBuf(data)
+		}
+		data = out
+	}
+	return data, nil
+}
+
+func hasTransform(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package diskstore
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+)
+
+// xorTransform is a toy "encryption" stage used to exercise the pipeline.
+type xorTransform struct{ k byte }
+
+func (x xorTransform) Name() string { return "xor" }
+
+func (x xorTransform) Encode(data []byte) ([]byte, error) {
+	out := make([]byte, len(data))
+	for i, b := range data {
+		out[i] = b ^ x.k
+	}
+	return out, nil
+}
+
+func (x xorTransform) Decode(data []byte) ([]byte, error) { return x.Encode(data) }
+
+// requireZstd skips tests that compress in builds with the nozstd tag.
+func requireZstd(t *testing.T) {
+	t.Helper()
+	if !zstdAvailable {
+		t.Skip("built without zstd")
+	}
+}
+
+func TestTransformChain(t *testing.T) {
+	requireZstd(t)
+	dir := t.TempDir()
+	zt, err := NewZstdTransform()
+	if err != nil {
+		t.Fatalf("NewZstdTransform: %v", err)
+	}
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+		// Compress, then "encrypt".
+		Transforms: []Transform{zt, xorTransform{k: 0x5a}},
+	}
+	store, err := New(cfg)
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	data := bytes.Repeat([]byte{7}, 4096)
+	if err := store.Put(key, "f16", []int{128}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+
+	got, meta, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatal("Get: round trip mismatch")
+	}
+	if len(meta.Transforms) != 2 || meta.Transforms[0] != "zstd" || meta.Transforms[1] != "xor" {
+		t.Errorf("Transforms = %v, want [zstd xor]", meta.Transforms)
+	}
+	if !meta.Compressed {
+		t.Error("expected compressed=true")
+	}
+	if meta.Codec != "zstd" || meta.StoredBytes == 0 || meta.CompressionRatio() < 10 || meta.EncodeTime <= 0 {
+		t.Errorf("compression stats: codec %q, stored %d, ratio %.1f, encode %v",
+			meta.Codec, meta.StoredBytes, meta.CompressionRatio(), meta.EncodeTime)
+	}
+}
+
+func TestCompressionStatsUncompressed(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{LocalPath: filepath.Join(dir, "local"), LocalBudget: 1024 * 1024})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	if err := store.Put(key, "f16", []int{128}, make([]byte, 256)); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	_, meta, err := store.Get(key)
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if meta.Codec != "" || meta.StoredBytes != 256 || meta.CompressionRatio() != 1 {
+		t.Errorf("codec %q, stored %d, ratio %v; want none, 256, 1", meta.Codec, meta.StoredBytes, meta.CompressionRatio())
+	}
+}
+
+func TestTransformMissingOnGet(t *testing.T) {
+	dir := t.TempDir()
+	cfg := Config{
+		LocalPath:   filepath.Join(dir, "local"),
+		LocalBudget: 1024 * 1024,
+		Transforms:  []Transform{xorTransform{k: 1}},
+	}
+	store, _ := New(cfg)
+	key := BlockKey{Seq: 0, Layer: 0, BeginPos: 0, EndPos: 1, IsKey: true}
+	store.Put(key, "f16", []int{128}, make([]byte, 64))
+	store.Close()
+
+	// Reopen without the transform: the block must not be returned as-is.
+	cfg.Transforms = nil
+	store2, _ := New(cfg)
+	defer store2.Close()
+	if _, _, err := store2.Get(key); err == nil {
+		t.Error("Get: expected error for unregistered transform")
+	}
+}
@@ -0,0 +1,254 @@
+//go:build linux && iouring
+
+package diskstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"unsafe"
+)
+
+// uring reads batches of block files through an io_uring: each wave opens
+// up to uringEntries files, queues a read for every one and waits for all
+// of them with a single io_uring_enter. The ring is set up on first use;
+// if the kernel refuses it (too old, or io_uring blocked by seccomp) the
+// store warns once and reads the files one after another.
+type uring struct {
+	mu     sync.Mutex
+	tried  bool
+	failed bool
+
+	fd   int
+	sq   []byte // submission ring
+	cq   []byte // completion ring
+	sqes []byte // submission queue entries
+
+	sqTail, sqMask *uint32
+	sqArray        []uint32
+	cqHead, cqTail *uint32
+	cqMask         uint32
+	cqes           uintptr // offset of the CQEs in cq
+
+	// iov holds the buffers a wave reads into. The kernel reads it by
+	// address, so it lives on the heap rather than a goroutine's stack.
+	iov []syscall.Iovec
+
+	// lost keeps the buffers of reads abandoned by a failed
+	// io_uring_enter reachable: the kernel may still write to them.
+	lost []any
+}
+
+// uringEntries is the number of reads per wave, and so the number of block
+// files open at once.
+const uringEntries = 256
+
+const (
+	sysIOUringSetup = 425
+	sysIOUringEnter = 426
+
+	ioringOpReadv        = 1
+	ioringEnterGetEvents = 1
+	ioringOffSQRing      = 0
+	ioringOffCQRing      = 0x8000000
+	ioringOffSQEs        = 0x10000000
+	uringSQESize         = 64
+	uringCQESize         = 16
+	uringSQEOffFd        = 4
+	uringSQEOffAddr      = 16
+	uringSQEOffLen       = 24
+	uringSQEOffUserData  = 32
+	uringCQEOffRes       = 8
+)
+
+// uringParams is struct io_uring_params.
+type uringParams struct {
+	sqEntries, cqEntries, flags, sqThreadCPU, sqThreadIdle, features, wqFd uint32
+	resv                                                                   [3]uint32
+	sqOff                                                                  struct {
+		head, tail, ringMask, ringEntries, flags, dropped, array, resv1 uint32
+		userAddr                                                        uint64
+	}
+	cqOff struct {
+		head, tail, ringMask, ringEntries, overflow, cqes, flags, resv1 uint32
+		userAddr                                                        uint64
+	}
+}
+
+// setup creates the ring and maps its queues.
+func (u *uring) setup() error {
+	var p uringParams
+	fd, _, errno := syscall.Syscall(sysIOUringSetup, uringEntries, uintptr(unsafe.Pointer(&p)), 0)
+	if errno != 0 {
+		return fmt.Errorf("io_uring_setup: %w", errno)
+	}
+	u.fd = int(fd)
+	var err error
+	mmap := func(off int64, size uint32) []byte {
+		if err != nil {
+			return nil
+		}
+		var b []byte
+		b, err = syscall.Mmap(u.fd, off, int(size), syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED|syscall.MAP_POPULATE)
+		return b
+	}
+	u.sq = mmap(ioringOffSQRing, p.sqOff.array+p.sqEntries*4)
+	u.cq = mmap(ioringOffCQRing, p.cqOff.cqes+p.cqEntries*uringCQESize)
+	u.sqes = mmap(ioringOffSQEs, p.sqEntries*uringSQESize)
+	if err != nil {
+		u.unmap()
+		return fmt.Errorf("mapping io_uring: %w", err)
+	}
+	u.sqTail = (*uint32)(unsafe.Pointer(&u.sq[p.sqOff.tail]))
+	u.sqMask = (*uint32)(unsafe.Pointer(&u.sq[p.sqOff.ringMask]))
+	u.sqArray = unsafe.Slice((*uint32)(unsafe.Pointer(&u.sq[p.sqOff.array])), p.sqEntries)
+	u.cqHead = (*uint32)(unsafe.Pointer(&u.cq[p.cqOff.head]))
+	u.cqTail = (*uint32)(unsafe.Pointer(&u.cq[p.cqOff.tail]))
+	u.cqMask = *(*uint32)(unsafe.Pointer(&u.cq[p.cqOff.ringMask]))
+	u.cqes = uintptr(p.cqOff.cqes)
+	u.iov = make([]syscall.Iovec, uringEntries)
+	return nil
+}
+
+func (u *uring) read(s *Store, metas []*BlockMeta) ([][]byte, []error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !u.tried {
+		u.tried = true
+		if err := u.setup(); err != nil {
+			u.failed = true
+			s.log.Warn("io_uring unavailable, reading block files one by one", "error", err)
+		}
+	}
+	if u.failed {
+		return s.readEach(metas)
+	}
+
+	data := make([][]byte, len(metas))
+	errs := make([]error, len(metas))
+	for i := 0; i < len(metas); i += uringEntries {
+		wave := metas[i:min(i+uringEntries, len(metas))]
+		u.readWave(s, wave, data[i:], errs[i:])
+	}
+	return data, errs
+}
+
+// readWave reads the files of metas, at most uringEntries, into data.
+func (u *uring) readWave(s *Store, metas []*BlockMeta, data [][]byte, errs []error) {
+	iov := u.iov[:len(metas)]
+	sizes := make([]int, len(metas))
+	var queued int
+	for i, meta := range metas {
+		f, direct, err := s.openBlock(meta)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		sizes[i] = int(fi.Size())
+		if sizes[i] == 0 {
+			data[i] = getBuf(0)
+			continue
+		}
+		if direct {
+			data[i] = alignedBuf(alignUp(sizes[i]))
+		} else {
+			data[i] = getBuf(sizes[i])
+		}
+		iov[i].Base = &data[i][0]
+		iov[i].SetLen(len(data[i]))
+
+		tail := atomic.LoadUint32(u.sqTail)
+		slot := tail & *u.sqMask
+		sqe := u.sqes[slot*uringSQESize : (slot+1)*uringSQESize]
+		clear(sqe)
+		sqe[0] = ioringOpReadv
+		*(*int32)(unsafe.Pointer(&sqe[uringSQEOffFd])) = int32(f.Fd())
+		*(*uint64)(unsafe.Pointer(&sqe[uringSQEOffAddr])) = uint64(uintptr(unsafe.Pointer(&iov[i])))
+		*(*uint32)(unsafe.Pointer(&sqe[uringSQEOffLen])) = 1
+		*(*uint64)(unsafe.Pointer(&sqe[uringSQEOffUserData])) = uint64(i)
+		u.sqArray[slot] = slot
+		atomic.StoreUint32(u.sqTail, tail+1)
+		queued++
+	}
+
+	for submit, done := queued, 0; done < queued; {
+		n, _, errno := syscall.Syscall6(sysIOUringEnter, uintptr(u.fd), uintptr(submit), uintptr(queued-done), ioringEnterGetEvents, 0, 0)
+		if errno == syscall.EINTR {
+			continue
+		}
+		if errno != 0 {
+			// Not expected once the ring is set up. The reads still
+			// queued can't be told apart from finished ones, so give up
+			// on the ring.
+			u.failed = true
+			s.log.Warn("io_uring_enter failed, reading block files one by one", "error", errno)
+			u.lost = append(u.lost, u.iov, slices.Clone(data))
+			u.iov = nil
+			d, e := s.readEach(metas)
+			copy(data, d)
+			copy(errs, e)
+			return
+		}
+		submit -= int(n)
+		for head := atomic.LoadUint32(u.cqHead); head != atomic.LoadUint32(u.cqTail); head++ {
+			cqe := u.cq[u.cqes+uintptr(head&u.cqMask)*uringCQESize:]
+			i := *(*uint64)(unsafe.Pointer(&cqe[0]))
+			res := *(*int32)(unsafe.Pointer(&cqe[uringCQEOffRes]))
+			atomic.StoreUint32(u.cqHead, head+1)
+			done++
+			switch {
+			case res < 0:
+				errs[i] = syscall.Errno(-res)
+			case int(res) < sizes[i]:
+				errs[i] = io.ErrUnexpectedEOF
+			default:
+				data[i] = data[i][:sizes[i]]
+				continue
+			}
+			putBuf(data[i])
+			data[i] = nil
+		}
+	}
+	runtime.KeepAlive(data)
+
+	// A short read, e.g. a file rewritten since the stat, is retried the
+	// plain way.
+	for i, err := range errs {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			d, e := s.readEach(metas[i : i+1])
+			data[i], errs[i] = d[0], e[0]
+		}
+	}
+}
+
+func (u *uring) close() {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.unmap()
+	u.tried, u.failed = true, true
+}
+
+// unmap releases the ring.
+func (u *uring) unmap() {
+	for _, b := range [][]byte{u.sqes, u.cq, u.sq} {
+		if b != nil {
+			syscall.Munmap(b)
+		}
+	}
+	u.sq, u.cq, u.sqes = nil, nil, nil
+	if u.fd > 0 {
+		syscall.Close(u.fd)
+		u.fd = 0
+	}
+}
@@ -0,0 +1,13 @@
+//go:build !linux || !iouring
+
+package diskstore
+
+// uring reads a batch of block files one after another in builds without
+// io_uring (see uring_linux.go).
+type uring struct{}
+
+func (u *uring) read(s *Store, metas []*BlockMeta) ([][]byte, []error) {
+	return s.readEach(metas)
+}
+
+func (u *uring) close() {}
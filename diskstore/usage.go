@@ -0,0 +1,75 @@
+package diskstore
+
+import "time"
+
+// Usage breakdown: Stats splits the blocks held by sequence, by layer and
+// by age since they were stored, so operators can see which
+// conversations take up the budgets. A block's bytes are its stored size
+// on the tier holding it, plus the same on the remote tier for a
+// replicated one; blocks sharing data through ForkSeq each count.
+
+// UsageStats is the size of a set of blocks on each tier.
+type UsageStats struct {
+	Blocks      int   `json:"blocks"`
+	LocalBytes  int64 `json:"local_bytes"`
+	RemoteBytes int64 `json:"remote_bytes"`
+	Logical     int64 `json:"logical"`
+}
+
+// Bytes returns the stored bytes on both tiers.
+func (u UsageStats) Bytes() int64 {
+	return u.LocalBytes + u.RemoteBytes
+}
+
+func (u *UsageStats) add(meta *BlockMeta) {
+	u.Blocks++
+	u.Logical += int64(meta.SizeBytes)
+	size := int64(storedSize(meta))
+	if meta.Tier == "local" {
+		u.LocalBytes += size
+	} else {
+		u.RemoteBytes += size
+	}
+	if meta.Replica {
+		u.RemoteBytes += size
+	}
+}
+
+// AgeUsage is the usage of blocks stored within MaxAge but not within the
+// previous bucket's; the last bucket, with no MaxAge, holds the rest.
+type AgeUsage struct {
+	MaxAge time.Duration `json:"max_age,omitempty"`
+	UsageStats
+}
+
+// usageAges are the upper bounds of the age buckets.
+var usageAges = []time.Duration{time.Hour, 24 * time.Hour, 7 * 24 * time.Hour, 30 * 24 * time.Hour}
+
+// usageStats returns the usage of all blocks by sequence, by layer and by
+// age at now. Must be called with s.mu held.
+func (s *Store) usageStats(now time.Time) (bySeq, byLayer map[int]UsageStats, byAge []AgeUsage) {
+	if len(s.index) == 0 {
+		return nil, nil, nil
+	}
+	bySeq = make(map[int]UsageStats)
+	byLayer = make(map[int]UsageStats)
+	byAge = make([]AgeUsage, len(usageAges)+1)
+	for i, age := range usageAges {
+		byAge[i].MaxAge = age
+	}
+	for _, meta := range s.index {
+		u := bySeq[meta.Key.Seq]
+		u.add(meta)
+		bySeq[meta.Key.Seq] = u
+		u = byLayer[meta.Key.Layer]
+		u.add(meta)
+		byLayer[meta.Key.Layer] = u
+
+		i := 0
+		for i < len(usageAges) && now.Sub(meta.StoredAt) >= usageAges[i] {
+			i++
+		}
+		byAge[i].add(meta)
+	}
+	return bySeq, byLayer, byAge
+}
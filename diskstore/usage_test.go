@@ -0,0 +1,60 @@
+package diskstore
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUsageStats(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1 << 20,
+		RemoteBudget:  1 << 20,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	if st := store.Stats(); st.BySeq != nil || st.ByAge != nil {
+		t.Errorf("empty store: BySeq %v, ByAge %v", st.BySeq, st.ByAge)
+	}
+
+	// Seq 1: two layers of 100 bytes; seq 2: one layer of 300 bytes,
+	// moved to the remote tier.
+	for layer := 0; layer < 2; layer++ {
+		store.Put(BlockKey{Seq: 1, Layer: layer, EndPos: 1, IsKey: true}, "f16", []int{50}, make([]byte, 100))
+	}
+	store.Put(BlockKey{Seq: 2, EndPos: 1, IsKey: true}, "f16", []int{150}, make([]byte, 300))
+	if _, err := store.Migrate(2, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	// Age one block of seq 1 past a day.
+	store.mu.Lock()
+	store.index[BlockKey{Seq: 1, Layer: 1, EndPos: 1, IsKey: true}.String()].StoredAt = time.Now().Add(-36 * time.Hour)
+	store.mu.Unlock()
+
+	st := store.Stats()
+	if u := st.BySeq[1]; u.Blocks != 2 || u.LocalBytes != 200 || u.RemoteBytes != 0 || u.Logical != 200 {
+		t.Errorf("BySeq[1] = %+v", u)
+	}
+	if u := st.BySeq[2]; u.Blocks != 1 || u.LocalBytes != 0 || u.RemoteBytes != 300 || u.Bytes() != 300 {
+		t.Errorf("BySeq[2] = %+v", u)
+	}
+	if u := st.ByLayer[0]; u.Blocks != 2 || u.Bytes() != 400 {
+		t.Errorf("ByLayer[0] = %+v", u)
+	}
+	if len(st.ByAge) != len(usageAges)+1 {
+		t.Fatalf("%d age buckets, want %d", len(st.ByAge), len(usageAges)+1)
+	}
+	if a := st.ByAge[0]; a.MaxAge != time.Hour || a.Blocks != 2 || a.Bytes() != 400 {
+		t.Errorf("ByAge[0] = %+v", a)
+	}
+	if a := st.ByAge[2]; a.MaxAge != 7*24*time.Hour || a.Blocks != 1 {
+		t.Errorf("ByAge[2] = %+v, want the block stored 36h ago", a)
+	}
+}
@@ -0,0 +1,290 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WebDAV tier: WebDAVTier keeps blocks as files on a WebDAV server, as
+// many NAS devices serve one more dependably than NFS across a WAN. Files
+// are laid out as on a directory tier under the server URL; collections
+// are created with MKCOL the first time a write finds them missing. As
+// WebDAV servers differ in their support for properties, a block's dtype
+// and shape follow its payload in the file, so that byte off of the
+// payload is byte off of the file and GetRange can fetch part of a block
+// with an HTTP range request (see partialread.go).
+
+// webdavTrailer is the size of the length closing a block file: the file
+// is the payload, then "dtype\nshape", then that text's length as a
+// big-endian uint32.
+const webdavTrailer = 4
+
+// WebDAVConfig configures a WebDAVTier.
+type WebDAVConfig struct {
+	URL      string // collection holding the blocks, e.g. https://nas/dav/kv
+	Username string // for basic authentication; empty for none
+	Password string
+	Timeout  time.Duration // per request (default 30s)
+}
+
+// WebDAVTier keeps blocks on a WebDAV server. It implements Tier, so it
+// can serve as Config.RemoteTier, RangeReader and Pinger.
+type WebDAVTier struct {
+	base     string
+	username string
+	password string
+	client   *http.Client
+
+	mu   sync.Mutex
+	dirs map[string]bool // collections known to exist
+}
+
+var _ RangeReader = (*WebDAVTier)(nil)
+
+// NewWebDAVTier returns a tier storing blocks under cfg.URL.
+func NewWebDAVTier(cfg WebDAVConfig) (*WebDAVTier, error) {
+	if !strings.HasPrefix(cfg.URL, "http://") && !strings.HasPrefix(cfg.URL, "https://") {
+		return nil, fmt.Errorf("diskstore: webdav tier: need an http or https URL, got %q", cfg.URL)
+	}
+	return &WebDAVTier{
+		base:     strings.TrimSuffix(cfg.URL, "/"),
+		username: cfg.Username,
+		password: cfg.Password,
+		client:   newObjectClient(cfg.Timeout),
+		dirs:     make(map[string]bool),
+	}, nil
+}
+
+// Put stores a block, creating the collections it goes in if missing.
+func (t *WebDAVTier) Put(key BlockKey, dtype string, shape []int, data []byte) error {
+	meta := dtype + "\n" + formatShape(shape)
+	body := make([]byte, 0, len(data)+len(meta)+webdavTrailer)
+	body = append(body, data...)
+	body = append(body, meta...)
+	body = binary.BigEndian.AppendUint32(body, uint32(len(meta)))
+
+	name := objectName("", key)
+	err := t.put(name, body)
+	if errors.Is(err, errNoCollection) {
+		if err = t.mkcols(path.Dir(name)); err == nil {
+			err = t.put(name, body)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: webdav put %s: %w", key, err)
+	}
+	return nil
+}
+
+// errNoCollection is a PUT refused because the file's collection is
+// missing: 409 Conflict by RFC 4918, 404 on some servers.
+var errNoCollection = errors.New("collection missing")
+
+func (t *WebDAVTier) put(name string, body []byte) error {
+	req, err := t.request(context.Background(), http.MethodPut, name, body)
+	if err != nil {
+		return err
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && (resp.StatusCode == http.StatusConflict || resp.StatusCode == http.StatusNotFound) {
+		return errNoCollection
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// mkcols creates the collection dir and those above it, from the top,
+// skipping those known to exist.
+func (t *WebDAVTier) mkcols(dir string) error {
+	var missing []string
+	t.mu.Lock()
+	for d := dir; d != "." && d != "/" && !t.dirs[d]; d = path.Dir(d) {
+		missing = append(missing, d)
+	}
+	t.mu.Unlock()
+	for i := len(missing) - 1; i >= 0; i-- {
+		req, err := t.request(context.Background(), "MKCOL", missing[i]+"/", nil)
+		if err != nil {
+			return err
+		}
+		resp, err := doHTTP(t.client, req)
+		// 405 Method Not Allowed: it exists already, perhaps made by a
+		// concurrent Put.
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusMethodNotAllowed) {
+			return fmt.Errorf("create collection %s: %w", missing[i], err)
+		}
+		if err == nil {
+			resp.Body.Close()
+		}
+		t.mu.Lock()
+		t.dirs[missing[i]] = true
+		t.mu.Unlock()
+	}
+	return nil
+}
+
+// Get fetches a block. A missing block returns an error wrapping
+// ErrNotFound, like Store.Get.
+func (t *WebDAVTier) Get(key BlockKey) ([]byte, *BlockMeta, error) {
+	return t.GetContext(context.Background(), key)
+}
+
+// GetContext is Get, giving up when ctx is done.
+func (t *WebDAVTier) GetContext(ctx context.Context, key BlockKey) ([]byte, *BlockMeta, error) {
+	body, err := t.get(ctx, key, 0, -1)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: webdav get %s: %w", key, err)
+	}
+	n := len(body) - webdavTrailer
+	if n < 0 {
+		return nil, nil, fmt.Errorf("diskstore: webdav get %s: truncated file", key)
+	}
+	metaLen := int(binary.BigEndian.Uint32(body[n:]))
+	if metaLen > n {
+		return nil, nil, fmt.Errorf("diskstore: webdav get %s: truncated file", key)
+	}
+	data, meta := body[:n-metaLen], body[n-metaLen:n]
+	dtype, shapeStr, _ := strings.Cut(string(meta), "\n")
+	shape, err := parseShape(shapeStr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("diskstore: webdav get %s: %w", key, err)
+	}
+	return data, &BlockMeta{
+		Key:       key,
+		DTypeStr:  dtype,
+		Shape:     shape,
+		SizeBytes: len(data),
+		Tier:      "remote",
+	}, nil
+}
+
+// GetRange fetches n bytes of key's payload from byte off on.
+func (t *WebDAVTier) GetRange(ctx context.Context, key BlockKey, off, n int) ([]byte, error) {
+	data, err := t.get(ctx, key, off, n)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: webdav get %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// get reads n bytes of key's file from byte off on, or all of it if n is
+// negative.
+func (t *WebDAVTier) get(ctx context.Context, key BlockKey, off, n int) ([]byte, error) {
+	req, err := t.request(ctx, http.MethodGet, objectName("", key), nil)
+	if err != nil {
+		return nil, err
+	}
+	if n >= 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 {
+		return data, nil
+	}
+	if resp.StatusCode != http.StatusPartialContent {
+		// The server ignored the range and sent the whole file.
+		if off+n > len(data) {
+			return nil, fmt.Errorf("%d bytes at %d of a %d-byte file", n, off, len(data))
+		}
+		data = data[off : off+n]
+	}
+	if len(data) != n {
+		return nil, fmt.Errorf("got %d bytes at %d, want %d", len(data), off, n)
+	}
+	return data, nil
+}
+
+// Has reports whether the server holds key. Transport errors report
+// false.
+func (t *WebDAVTier) Has(key BlockKey) bool {
+	req, err := t.request(context.Background(), http.MethodHead, objectName("", key), nil)
+	if err != nil {
+		return false
+	}
+	resp, err := doHTTP(t.client, req)
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return true
+}
+
+// Delete removes a block's file. Deleting a missing block is not an
+// error.
+func (t *WebDAVTier) Delete(key BlockKey) error {
+	req, err := t.request(context.Background(), http.MethodDelete, objectName("", key), nil)
+	if err != nil {
+		return fmt.Errorf("diskstore: webdav delete %s: %w", key, err)
+	}
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("diskstore: webdav delete %s: %w", key, err)
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Ping checks that the server answers for the tier's collection with
+// its credentials. A collection not created yet passes.
+func (t *WebDAVTier) Ping(ctx context.Context) error {
+	req, err := t.request(ctx, "PROPFIND", "", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Depth", "0")
+	resp, err := doHTTP(t.client, req)
+	if resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// request returns an authenticated request for name under the tier's
+// collection.
+func (t *WebDAVTier) request(ctx context.Context, method, name string, body []byte) (*http.Request, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, t.base+"/"+escapeObjectPath(name), r)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if t.username != "" {
+		req.SetBasicAuth(t.username, t.password)
+	}
+	return req, nil
+}
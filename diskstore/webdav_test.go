@@ -0,0 +1,202 @@
+package diskstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeDAV is an in-memory WebDAV server for the methods WebDAVTier uses.
+// Like a real one it refuses a PUT into a missing collection.
+type fakeDAV struct {
+	ignoreRange bool // answer range requests with the whole file
+
+	mu     sync.Mutex
+	files  map[string][]byte
+	dirs   map[string]bool
+	ranges []string // Range headers of GETs
+}
+
+func newFakeDAV(t *testing.T, user, password string) (*fakeDAV, *httptest.Server) {
+	f := &fakeDAV{files: make(map[string][]byte), dirs: map[string]bool{"/dav": true}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, _ := r.BasicAuth(); u != user || p != password {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		f.serve(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+func (f *fakeDAV) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name := strings.TrimSuffix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		if !f.dirs[path.Dir(name)] {
+			http.Error(w, "no parent", http.StatusConflict)
+			return
+		}
+		var b bytes.Buffer
+		b.ReadFrom(r.Body)
+		f.files[name] = b.Bytes()
+		w.WriteHeader(http.StatusCreated)
+	case "MKCOL":
+		switch {
+		case f.dirs[name]:
+			http.Error(w, "exists", http.StatusMethodNotAllowed)
+		case !f.dirs[path.Dir(name)]:
+			http.Error(w, "no parent", http.StatusConflict)
+		default:
+			f.dirs[name] = true
+			w.WriteHeader(http.StatusCreated)
+		}
+	case http.MethodGet, http.MethodHead:
+		data, ok := f.files[name]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if rng := r.Header.Get("Range"); rng != "" {
+			f.ranges = append(f.ranges, rng)
+			if f.ignoreRange {
+				r.Header.Del("Range")
+			}
+		}
+		http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+	case http.MethodDelete:
+		if _, ok := f.files[name]; !ok {
+			http.NotFound(w, r)
+			return
+		}
+		delete(f.files, name)
+		w.WriteHeader(http.StatusNoContent)
+	case "PROPFIND":
+		if !f.dirs[name] {
+			http.NotFound(w, r)
+			return
+		}
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+}
+
+// takeRanges returns the Range headers received since the last call.
+func (f *fakeDAV) takeRanges() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	r := f.ranges
+	f.ranges = nil
+	return r
+}
+
+func TestWebDAVTier(t *testing.T) {
+	f, srv := newFakeDAV(t, "kv", "secret")
+	tier, err := NewWebDAVTier(WebDAVConfig{URL: srv.URL + "/dav/", Username: "kv", Password: "secret"})
+	if err != nil {
+		t.Fatalf("NewWebDAVTier: %v", err)
+	}
+	testServiceTier(t, tier)
+	if !f.dirs["/dav/ns/qwen/02"] {
+		t.Error("collections not created")
+	}
+
+	key := BlockKey{Seq: 1, BeginPos: 0, EndPos: 4, IsKey: true}
+	if err := tier.Put(key, "f16", []int{4, 2}, []byte("0123456789abcdef")); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	for _, ignore := range []bool{false, true} {
+		f.mu.Lock()
+		f.ignoreRange = ignore
+		f.mu.Unlock()
+		got, err := tier.GetRange(context.Background(), key, 4, 8)
+		if err != nil || string(got) != "456789ab" {
+			t.Errorf("GetRange (server ignoring ranges %v) = %q, %v", ignore, got, err)
+		}
+	}
+	if _, err := tier.GetRange(context.Background(), key, 100, 8); err == nil {
+		t.Error("GetRange past the file succeeded")
+	}
+
+	wrong, _ := NewWebDAVTier(WebDAVConfig{URL: srv.URL + "/dav", Username: "kv"})
+	if err := wrong.Ping(context.Background()); err == nil {
+		t.Error("Ping without the password succeeded")
+	}
+	if _, err := NewWebDAVTier(WebDAVConfig{URL: "nas/dav"}); err == nil {
+		t.Error("NewWebDAVTier without a scheme succeeded")
+	}
+}
+
+func TestStoreWithWebDAVTier(t *testing.T) {
+	f, srv := newFakeDAV(t, "", "")
+	tier, err := OpenTierURL("dav://"+strings.TrimPrefix(srv.URL, "http://")+"/dav", 0)
+	if err != nil {
+		t.Fatalf("OpenTierURL: %v", err)
+	}
+	store, err := New(Config{
+		LocalPath:     t.TempDir(),
+		LocalBudget:   1024 * 1024,
+		RemoteBudget:  1024 * 1024,
+		RemoteTier:    tier,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+
+	// Two layers of eight rows of eight bytes, on the WebDAV server.
+	rows := func(layer int) []byte {
+		var b []byte
+		for i := range 8 {
+			b = append(b, bytes.Repeat([]byte{byte(layer*8 + i)}, 8)...)
+		}
+		return b
+	}
+	for layer := range 2 {
+		key := BlockKey{Seq: 1, Layer: layer, BeginPos: 0, EndPos: 8, IsKey: true}
+		if err := store.Put(key, "f16", []int{8, 4}, rows(layer)); err != nil {
+			t.Fatalf("Put: %v", err)
+		}
+	}
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// Positions 2 to 5 of each layer are fetched alone.
+	keys := []BlockKey{
+		{Seq: 1, Layer: 0, BeginPos: 2, EndPos: 5, IsKey: true},
+		{Seq: 1, Layer: 1, BeginPos: 2, EndPos: 5, IsKey: true},
+	}
+	for i, got := range store.ReadRanges(keys) {
+		if want := rows(i)[16:40]; got.Err != nil || !bytes.Equal(got.Data, want) || got.End != 5 {
+			t.Errorf("layer %d: got %v end %d, %v; want %v", i, got.Data, got.End, got.Err, want)
+		}
+	}
+	if r := f.takeRanges(); len(r) != 2 || r[0] != "bytes=16-39" {
+		t.Errorf("range requests %q, want two of bytes=16-39", r)
+	}
+
+	// A whole block is read as by Get.
+	got, end, err := store.ReadRange(BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 8, IsKey: true})
+	if r := f.takeRanges(); err != nil || !bytes.Equal(got, rows(0)) || end != 8 || len(r) != 0 {
+		t.Errorf("ReadRange = %d bytes end %d, %v, ranges %q", len(got), end, err, r)
+	}
+
+	// A block lost from the server fails as it does for Get.
+	f.mu.Lock()
+	f.files = make(map[string][]byte)
+	f.mu.Unlock()
+	if got, end, err := store.ReadRange(keys[0]); len(got) != 0 || end != 2 || !errors.Is(err, ErrCorrupted) {
+		t.Errorf("ReadRange of a lost block = %d bytes end %d, %v; want ErrCorrupted", len(got), end, err)
+	}
+}
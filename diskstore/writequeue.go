@@ -0,0 +1,139 @@
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+)
+
+// ErrClosed is returned by PutAsync once the store is closing.
+var ErrClosed = errors.New("diskstore: store closed")
+
+// Write queue: with Config.WriteQueue set, PutAsync hands blocks to a
+// background writer instead of writing them on the caller's goroutine.
+// Puts of a key that is already queued are coalesced: the queued block
+// takes the newer data and is written once. A key that is being written
+// when the same data is queued again is not written a second time.
+
+// pendingPut is a queued PutAsync.
+type pendingPut struct {
+	key      BlockKey
+	dtype    string
+	shape    []int
+	data     []byte
+	shift    int32
+	inflight bool // being written by writeLoop
+	dirty    bool // changed while in flight: write again
+}
+
+// PutAsync queues a block for Put and returns without waiting for the
+// write. It copies data, so the caller may reuse it. Validation errors
+// are returned immediately; write errors are only logged. Without a write
+// queue (Config.WriteQueue 0) it is Put. When the queue is full, PutAsync
+// waits for room. Queued blocks are not visible to Get, Has or ReadRange
+// until written; Flush waits for them.
+func (s *Store) PutAsync(key BlockKey, dtype string, shape []int, data []byte) error {
+	return s.PutAsyncShifted(key, dtype, shape, data, 0)
+}
+
+// PutAsyncShifted is PutAsync for PutShifted.
+func (s *Store) PutAsyncShifted(key BlockKey, dtype string, shape []int, data []byte, shift int32) error {
+	if s.writeQueue == 0 {
+		return s.PutShifted(key, dtype, shape, data, shift)
+	}
+	if err := checkNamespace(key.Namespace); err != nil {
+		return err
+	}
+	if err := checkPrefix(key.Prefix); err != nil {
+		return err
+	}
+	if err := s.fingerprintFor(key.Namespace).checkBlock(key, dtype, shape); err != nil {
+		return err
+	}
+
+	s.wqMu.Lock()
+	defer s.wqMu.Unlock()
+
+	k := key.String()
+	for {
+		if s.wqClosed {
+			return ErrClosed
+		}
+		if p, ok := s.wq[k]; ok {
+			if p.inflight && !p.dirty && p.shift == shift && bytes.Equal(p.data, data) {
+				s.coalesced++ // the write under way stores the same bytes
+				return nil
+			}
+			if !p.inflight || p.dirty {
+				s.coalesced++ // replaces a write that hasn't started
+				putBuf(p.data)
+			}
+			p.dtype, p.shape, p.data, p.shift = dtype, shape, cloneBuf(data), shift
+			if p.inflight && !p.dirty {
+				p.dirty = true
+				s.wqOrder = append(s.wqOrder, k)
+			}
+			s.wqCond.Broadcast()
+			return nil
+		}
+		if len(s.wqOrder) < s.writeQueue {
+			break
+		}
+		s.wqCond.Wait()
+	}
+	s.wq[k] = &pendingPut{key: key, dtype: dtype, shape: shape, data: cloneBuf(data), shift: shift}
+	s.wqOrder = append(s.wqOrder, k)
+	s.wqCond.Broadcast()
+	return nil
+}
+
+// Flush waits until every block queued by PutAsync has been written.
+func (s *Store) Flush() {
+	s.wqMu.Lock()
+	defer s.wqMu.Unlock()
+	for len(s.wq) > 0 {
+		s.wqCond.Wait()
+	}
+}
+
+// writeLoop writes queued blocks in order until the store is closed and
+// the queue is drained.
+func (s *Store) writeLoop() {
+	defer s.wg.Done()
+	s.wqMu.Lock()
+	defer s.wqMu.Unlock()
+	for {
+		for len(s.wqOrder) == 0 && !s.wqClosed {
+			s.wqCond.Wait()
+		}
+		if len(s.wqOrder) == 0 {
+			return
+		}
+		k := s.wqOrder[0]
+		s.wqOrder = s.wqOrder[1:]
+		p := s.wq[k]
+		p.inflight, p.dirty = true, false
+		key, dtype, shape, data, shift := p.key, p.dtype, p.shape, p.data, p.shift
+		s.wqMu.Unlock()
+
+		if err := s.PutShifted(key, dtype, shape, data, shift); err != nil {
+			s.log.Warn("queued put failed", "key", key, "error", err)
+		}
+
+		s.wqMu.Lock()
+		putBuf(data) // p.data is another copy by now if it was queued again
+		p.inflight = false
+		if !p.dirty {
+			delete(s.wq, k)
+		}
+		s.wqCond.Broadcast()
+	}
+}
+
+// closeWriteQueue stops PutAsync from accepting blocks; writeLoop exits
+// once it has written the rest.
+func (s *Store) closeWriteQueue() {
+	s.wqMu.Lock()
+	defer s.wqMu.Unlock()
+	s.wqClosed = true
+	s.wqCond.Broadcast()
+}
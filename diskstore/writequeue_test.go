@@ -0,0 +1,115 @@
+package diskstore
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestPutAsyncCoalesces(t *testing.T) {
+	store, err := New(Config{LocalPath: t.TempDir(), LocalBudget: 1 << 20, WriteQueue: 8})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	key := func(begin int32) BlockKey {
+		return BlockKey{Seq: 1, Layer: 0, BeginPos: begin, EndPos: begin + 16, IsKey: true}
+	}
+	a, b := bytes.Repeat([]byte{1}, 512), bytes.Repeat([]byte{2}, 256)
+
+	// Hold the store lock so the writer stalls inside the first Put.
+	store.mu.Lock()
+	store.PutAsync(key(0), "f16", []int{8}, a)
+	for {
+		store.wqMu.Lock()
+		started := len(store.wqOrder) == 0
+		store.wqMu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	store.PutAsync(key(0), "f16", []int{8}, a) // same bytes as the write under way
+	store.PutAsync(key(16), "f16", []int{8}, a)
+	store.PutAsync(key(16), "f16", []int{8}, a)
+	store.PutAsync(key(16), "f16", []int{8}, b) // newest data wins
+	store.mu.Unlock()
+	store.Flush()
+
+	st := store.Stats()
+	if st.Puts != 2 || st.Coalesced != 3 || st.Queued != 0 {
+		t.Errorf("puts %d, coalesced %d, queued %d; want 2, 3, 0", st.Puts, st.Coalesced, st.Queued)
+	}
+	if st.LocalUsed != int64(len(a)+len(b)) {
+		t.Errorf("local used %d, want %d", st.LocalUsed, len(a)+len(b))
+	}
+	if data, _, _ := store.Get(key(16)); !bytes.Equal(data, b) {
+		t.Error("coalesced block does not hold the newest data")
+	}
+
+	// A synchronous Put of an existing key replaces it too.
+	store.Put(key(16), "f16", []int{8}, a)
+	if st := store.Stats(); st.LocalUsed != int64(2*len(a)) || st.LocalBlocks != 2 {
+		t.Errorf("after replacing: %d blocks, %d bytes; want 2, %d", st.LocalBlocks, st.LocalUsed, 2*len(a))
+	}
+
+	// Close writes what is still queued.
+	store.PutAsync(key(32), "f16", []int{8}, b)
+	store.Close()
+	if err := store.PutAsync(key(48), "f16", []int{8}, b); err != ErrClosed {
+		t.Errorf("PutAsync after Close = %v, want ErrClosed", err)
+	}
+	reopened, err := New(Config{LocalPath: store.localPath, LocalBudget: 1 << 20})
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer reopened.Close()
+	if !reopened.Has(key(32)) {
+		t.Error("block queued before Close was not written")
+	}
+}
+
+func TestQueuedBlocksFollowSeqChanges(t *testing.T) {
+	store, err := New(Config{LocalPath: t.TempDir(), LocalBudget: 1 << 20, WriteQueue: 256, StatsInterval: -1})
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+	defer store.Close()
+	// queue puts n blocks of seq while the writer is held up, so they
+	// are all still queued when it returns.
+	queue := func(seq, n int) {
+		store.mu.Lock()
+		for i := range n {
+			key := BlockKey{Seq: seq, BeginPos: int32(i), EndPos: int32(i + 1), IsKey: true}
+			store.PutAsync(key, "f16", []int{8}, make([]byte, 16))
+		}
+		store.mu.Unlock()
+	}
+
+	// RemoveSeq removes what is queued too.
+	queue(1, 200)
+	if n := store.RemoveSeq(1); n != 200 {
+		t.Errorf("RemoveSeq of 200 queued blocks removed %d", n)
+	}
+	store.Flush()
+	if got := len(store.Blocks(1)); got != 0 {
+		t.Errorf("%d blocks written after RemoveSeq", got)
+	}
+
+	// Binding another session to the slot parks the queued blocks with
+	// the session that put them.
+	if err := store.BindSession("a", 0); err != nil {
+		t.Fatalf("BindSession a: %v", err)
+	}
+	queue(0, 200)
+	if err := store.BindSession("b", 0); err != nil {
+		t.Fatalf("BindSession b: %v", err)
+	}
+	store.Flush()
+	if got := len(store.Blocks(0)); got != 0 {
+		t.Errorf("session b's slot holds %d of session a's blocks", got)
+	}
+	parked, _ := store.SessionSeq("a")
+	if got := len(store.Blocks(parked)); got != 200 {
+		t.Errorf("session a parked with %d blocks, want 200", got)
+	}
+}
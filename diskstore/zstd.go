@@ -0,0 +1,109 @@
+//go:build !nozstd
+
+package diskstore
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdAvailable reports whether this build includes zstd (see the nozstd
+// build tag).
+const zstdAvailable = true
+
+// zstdTransform is the built-in compression stage used when Config.Compress
+// is set.
+type zstdTransform struct {
+	enc *zstd.Encoder
+	dec *zstd.Decoder
+}
+
+// NewZstdTransform returns the built-in zstd stage so it can be placed
+// explicitly in a chain, e.g. before an encryption transform.
+func NewZstdTransform() (Transform, error) {
+	return newZstdTransform(0)
+}
+
+// newZstdTransform returns a zstd stage able to run decoders blocks'
+// decodes at once (0 = the library default, at most 4).
+func newZstdTransform(decoders int) (*zstdTransform, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedDefault))
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: create zstd encoder: %w", err)
+	}
+	var opts []zstd.DOption
+	if decoders > 0 {
+		opts = append(opts, zstd.WithDecoderConcurrency(decoders))
+	}
+	dec, err := zstd.NewReader(nil, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: create zstd decoder: %w", err)
+	}
+	return &zstdTransform{enc: enc, dec: dec}, nil
+}
+
+// newZstdEncoder returns a zstd stage that only encodes, at level as
+// zstd's command line counts (1-22). Any zstd stage decodes its output.
+func newZstdEncoder(level int) (*zstdTransform, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	if err != nil {
+		return nil, fmt.Errorf("diskstore: create zstd encoder: %w", err)
+	}
+	return &zstdTransform{enc: enc}, nil
+}
+
+func (z *zstdTransform) Name() string { return zstdTransformName }
+
+func (z *zstdTransform) Encode(data []byte) ([]byte, error) {
+	return z.enc.EncodeAll(data, getBuf(len(data))[:0]), nil
+}
+
+func (z *zstdTransform) Decode(data []byte) ([]byte, error) {
+	return z.dec.DecodeAll(data, nil)
+}
+
+// decodeTo is Decode appending to dst. Unlike a Transform's output in
+// general, the result never shares memory with data.
+func (z *zstdTransform) decodeTo(dst, data []byte) ([]byte, error) {
+	return z.dec.DecodeAll(data, dst)
+}
+
+func (z *zstdTransform) close() {
+	z.enc.Close()
+	if z.dec != nil {
+		z.dec.Close()
+	}
+}
+
+// s2Transform is the fast compression stage of per-tier codecs (see
+// codec.go): S2, a Snappy extension compressing and decompressing at
+// LZ4-like speeds.
+type s2Transform struct{}
+
+func (s2Transform) Name() string { return s2TransformName }
+
+func (s2Transform) Encode(data []byte) ([]byte, error) {
+	return s2.Encode(nil, data), nil
+}
+
+func (s2Transform) Decode(data []byte) ([]byte, error) {
+	return s2.Decode(nil, data)
+}
+
+// newArchiveWriter compresses an export archive written to w.
+func newArchiveWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+// newArchiveReader decompresses an archive read from r. The returned
+// function releases the decoder.
+func newArchiveReader(r io.Reader) (io.Reader, func(), error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	return zr, zr.Close, nil
+}
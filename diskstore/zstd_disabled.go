@@ -0,0 +1,52 @@
+//go:build nozstd
+
+package diskstore
+
+import "io"
+
+// Built with the nozstd tag, for trees that cannot vendor
+// klauspost/compress: Config.Compress, per-tier codecs and compressing
+// profiles are ignored with a warning, and reading a zstd- or
+// s2-compressed block or a sequence archive fails with ErrNoZstd.
+
+const zstdAvailable = false
+
+// zstdTransform stands in for the compression stage; it is never
+// registered in this build.
+type zstdTransform struct{}
+
+// NewZstdTransform returns ErrNoZstd in this build.
+func NewZstdTransform() (Transform, error) {
+	return nil, ErrNoZstd
+}
+
+func newZstdTransform(decoders int) (*zstdTransform, error) {
+	return nil, ErrNoZstd
+}
+
+func newZstdEncoder(level int) (*zstdTransform, error) {
+	return nil, ErrNoZstd
+}
+
+func (z *zstdTransform) Name() string                       { return zstdTransformName }
+func (z *zstdTransform) Encode(data []byte) ([]byte, error) { return nil, ErrNoZstd }
+func (z *zstdTransform) Decode(data []byte) ([]byte, error) { return nil, ErrNoZstd }
+func (z *zstdTransform) close()                             {}
+
+func (z *zstdTransform) decodeTo(dst, data []byte) ([]byte, error) { return nil, ErrNoZstd }
+
+// s2Transform stands in for the fast compression stage; it is never
+// registered in this build.
+type s2Transform struct{}
+
+func (s2Transform) Name() string                       { return s2TransformName }
+func (s2Transform) Encode(data []byte) ([]byte, error) { return nil, ErrNoZstd }
+func (s2Transform) Decode(data []byte) ([]byte, error) { return nil, ErrNoZstd }
+
+func newArchiveWriter(w io.Writer) (io.WriteCloser, error) {
+	return nil, ErrNoZstd
+}
+
+func newArchiveReader(r io.Reader) (io.Reader, func(), error) {
+	return nil, nil, ErrNoZstd
+}
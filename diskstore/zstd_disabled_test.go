@@ -0,0 +1,48 @@
+//go:build nozstd
+
+package diskstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNoZstd(t *testing.T) {
+	dir := t.TempDir()
+	store, err := New(Config{LocalPath: dir, LocalBudget: 1 << 20, Compress: true, Profile: "agent-memory"})
+	if err != nil {
+		t.Fatalf("New with Compress: %v", err)
+	}
+	defer store.Close()
+
+	// Compression is off: blocks are stored as is.
+	key := BlockKey{Seq: 1, Layer: 0, BeginPos: 0, EndPos: 16, IsKey: true}
+	data := bytes.Repeat([]byte{7}, 1024)
+	if err := store.Put(key, "f16", []int{8}, data); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	got, meta, err := store.Get(key)
+	if err != nil || !bytes.Equal(got, data) || meta.Compressed {
+		t.Fatalf("Get = %d bytes, %+v, %v", len(got), meta, err)
+	}
+
+	// A block compressed by a full build is refused with ErrNoZstd.
+	store.mu.Lock()
+	store.index[key.String()].Transforms = []string{zstdTransformName}
+	store.mu.Unlock()
+	if _, _, err := store.Get(key); !errors.Is(err, ErrNoZstd) {
+		t.Errorf("Get of a zstd block = %v, want ErrNoZstd", err)
+	}
+
+	if _, err := store.ExportSeq(1, &bytes.Buffer{}); !errors.Is(err, ErrNoZstd) {
+		t.Errorf("ExportSeq = %v, want ErrNoZstd", err)
+	}
+	f, _ := os.Create(filepath.Join(dir, "seq.kvtar.zst"))
+	defer f.Close()
+	if _, err := store.ImportSeq(f); !errors.Is(err, ErrNoZstd) {
+		t.Errorf("ImportSeq = %v, want ErrNoZstd", err)
+	}
+}
@@ -0,0 +1,173 @@
+package kvcache
+
+import (
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// Restore audits: with TieredConfig.Audit, snapshots also store the tokens
+// at the positions they save (a diskstore token manifest), as far as the
+// runner reported them through SetPrompt and RecordTokens. A restore then
+// compares the stored tokens with the prompt it resumes first. A mismatch
+// means the rows were computed for other text, for instance an earlier
+// conversation in the same slot, and is reported as an AuditRecord.
+// AuditStrict also stops restoring the sequence until its next prompt and
+// never restores positions it cannot check, so early adopters can run
+// tiering in a safe mode while they build trust in it.
+
+// AuditMode selects how restores are checked against stored tokens.
+type AuditMode int
+
+const (
+	AuditOff    AuditMode = iota // no manifests, no checks (default)
+	AuditLog                     // report mismatches but restore anyway
+	AuditStrict                  // report mismatches and restore only verified positions
+)
+
+func (m AuditMode) String() string {
+	switch m {
+	case AuditOff:
+		return "off"
+	case AuditLog:
+		return "log"
+	case AuditStrict:
+		return "strict"
+	}
+	return fmt.Sprintf("AuditMode(%d)", int(m))
+}
+
+// ParseAuditMode parses "off", "log" or "strict". An empty string means
+// AuditOff.
+func ParseAuditMode(s string) (AuditMode, error) {
+	switch s {
+	case "", "off":
+		return AuditOff, nil
+	case "log":
+		return AuditLog, nil
+	case "strict":
+		return AuditStrict, nil
+	}
+	return 0, fmt.Errorf("kvcache: unknown audit mode %q", s)
+}
+
+// auditContext is how many tokens from the first mismatch an AuditRecord
+// carries.
+const auditContext = 16
+
+// AuditRecord describes a restore whose stored tokens differ from the
+// prompt.
+type AuditRecord struct {
+	Seq int
+
+	// Begin and End are the positions the restore was to load, Pos the
+	// first whose stored token differs from the prompt's.
+	Begin, End, Pos int32
+
+	// Mismatched counts the positions whose stored token differs, and
+	// Unverified those with no stored token or beyond the prompt.
+	Mismatched, Unverified int32
+
+	// Stored and Prompt are the stored and the prompt's tokens from Pos
+	// on, at most auditContext of them.
+	Stored, Prompt []int32
+
+	// Strict reports whether restoring the sequence was disabled.
+	Strict bool
+}
+
+// RecordTokens records the tokens at seq's positions from 0 on, such as a
+// slot's inputs before a context shift snapshots some of them, for the
+// manifests of audited restores. SetPrompt records the prompt's.
+func (t *TieredCausal) RecordTokens(seq int, tokens []int32) {
+	if t.cfg.Audit == AuditOff {
+		return
+	}
+	t.mu.Lock()
+	t.tokens[seq] = slices.Clone(tokens)
+	t.mu.Unlock()
+}
+
+// putManifest stores the recorded tokens of key's positions, taken shift
+// lower, unless some of them are unknown.
+func (t *TieredCausal) putManifest(seq int, key diskstore.BlockKey, shift int32) {
+	t.mu.Lock()
+	tokens := t.tokens[seq]
+	t.mu.Unlock()
+	begin, end := key.BeginPos-shift, key.EndPos-shift
+	if begin < 0 || int(end) > len(tokens) {
+		return
+	}
+	mk := diskstore.ManifestKey(key)
+	if err := t.store.PutAsyncShifted(mk, diskstore.ManifestDType, nil, diskstore.EncodeTokens(tokens[begin:end]), shift); err != nil {
+		slog.Warn("tiered: failed to store token manifest", "key", mk, "error", err)
+	}
+}
+
+// audit checks the stored tokens of the positions a restore of seq from
+// beginPos to end loads against its prompt, reports a mismatch and
+// returns the end the restore may go on to.
+func (t *TieredCausal) audit(rows *rowReader, seq int, beginPos, end int32) int32 {
+	strict := t.cfg.Audit == AuditStrict
+	t.mu.Lock()
+	prompt, failed := t.tokens[seq], t.auditFailed[seq]
+	t.mu.Unlock()
+	if failed {
+		return beginPos
+	}
+
+	from := beginPos
+	if t.window > 0 {
+		from = max(from, end-t.window)
+	}
+	rec := AuditRecord{Seq: seq, Begin: from, End: end, Strict: strict}
+	verified := end
+	for pos := from; pos < end; pos++ {
+		b, _ := rows.row(seq, diskstore.ManifestLayer, false, pos)
+		if len(b) != 4 || int(pos) >= len(prompt) {
+			rec.Unverified++
+			verified = min(verified, pos)
+			continue
+		}
+		stored := diskstore.DecodeTokens(b)[0]
+		if stored != prompt[pos] {
+			if rec.Mismatched == 0 {
+				rec.Pos = pos
+			}
+			rec.Mismatched++
+		}
+		if rec.Mismatched > 0 && len(rec.Stored) < auditContext {
+			rec.Stored = append(rec.Stored, stored)
+			rec.Prompt = append(rec.Prompt, prompt[pos])
+		}
+	}
+
+	if rec.Mismatched > 0 {
+		slog.Warn("tiered: restore audit found stored tokens that differ from the prompt",
+			"seq", seq, "begin", rec.Begin, "end", rec.End, "pos", rec.Pos,
+			"mismatched", rec.Mismatched, "unverified", rec.Unverified,
+			"stored", rec.Stored, "prompt", rec.Prompt, "strict", strict)
+		if t.cfg.OnAudit != nil {
+			t.cfg.OnAudit(rec)
+		}
+	}
+	switch {
+	case !strict:
+		return end
+	case rec.Mismatched > 0:
+		t.mu.Lock()
+		t.auditFailed[seq] = true
+		t.mu.Unlock()
+		return beginPos
+	case verified < end && from > beginPos:
+		// Part of a window is of no use (see restoreTo).
+		return beginPos
+	}
+	if verified < end {
+		slog.Debug("tiered: restoring only positions with stored tokens",
+			"seq", seq, "verified", verified-beginPos, "stored", end-beginPos)
+	}
+	return verified
+}
@@ -0,0 +1,78 @@
+package kvcache
+
+// Backend is what TieredCausal needs from the cache it tiers. Ollama's
+// kvcache.Causal is adapted to it by the patch in patches/; tests use an
+// in-memory implementation.
+type Backend interface {
+	// NumLayers returns one past the highest layer index.
+	NumLayers() int
+
+	// Keys and Values return a layer's cache tensors, or nil if the layer
+	// has none (e.g. layers sharing another layer's cache).
+	Keys(layer int) TensorAccessor
+	Values(layer int) TensorAccessor
+
+	// DType names the cache element type recorded with stored blocks,
+	// e.g. "f16".
+	DType() string
+
+	// Cells returns the cache's cell table.
+	Cells() CellTable
+
+	// Remove frees positions [begin, end) of seq, as Causal.Remove does.
+	Remove(seq int, begin, end int32) error
+}
+
+// Windowed is implemented by backends whose attention only looks back a
+// fixed number of positions, such as Ollama's sliding-window caches.
+// TieredCausal then restores only that many positions before the end of a
+// restored range.
+type Windowed interface {
+	// SlidingWindow returns the window in positions; 0 or less means the
+	// cache keeps every position after all.
+	SlidingWindow() int32
+}
+
+// Shifter is implemented by backends that keep a sequence's positions
+// contiguous when the middle of it is removed, as Ollama's Causal does
+// for a context shift: Remove(seq, begin, end) with end < math.MaxInt32
+// moves later positions down by end-begin and rotates their keys (RoPE)
+// to match. TieredCausal then tracks how far each sequence has shifted,
+// so snapshots keep the tokens' absolute positions, and rotates restored
+// keys stored at another shift to the positions they land at.
+type Shifter interface {
+	// ShiftCells rotates the keys held in cells to positions delta
+	// higher than the ones they were computed for.
+	ShiftCells(cells []int, delta int32) error
+}
+
+// TensorAccessor reads and writes one cache tensor a row at a time, where
+// row i holds the cache cell i.
+type TensorAccessor interface {
+	// RowSize is the size of one row in bytes.
+	RowSize() int
+
+	// Shape is the tensor shape recorded with stored blocks.
+	Shape() []int
+
+	// ReadRow returns the bytes of cell's row. The slice may alias the
+	// tensor and is only valid until the next write.
+	ReadRow(cell int) ([]byte, error)
+
+	// WriteRow overwrites cell's row with data, which is RowSize bytes.
+	WriteRow(cell int, data []byte) error
+}
+
+// CellTable is the cache's map from cells to sequence positions.
+type CellTable interface {
+	// NumCells returns the number of cells.
+	NumCells() int
+
+	// Cell returns the position held by cell i and the sequences sharing
+	// it; an empty seqs means the cell is free.
+	Cell(i int) (pos int32, seqs []int)
+
+	// Occupy assigns free cell i to position pos of seq, updating
+	// whatever per-sequence bookkeeping the cache keeps.
+	Occupy(i int, seq int, pos int32)
+}
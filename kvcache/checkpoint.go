@@ -0,0 +1,184 @@
+package kvcache
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"slices"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// Checkpoints: restarting the runner, for an upgrade or after a crash,
+// loses every slot's KV cache, and snapshots only hold what context
+// shifts evicted. Checkpoint stores what a sequence holds in memory,
+// with a token manifest, as a checkpoint sequence of its own. After a
+// restart, Resume finds the checkpoint sharing the longest prefix with a
+// prompt and gives its blocks to the prompt's sequence for RestoreRange,
+// as PullPrefill does. A checkpoint is incremental: blocks whose tokens
+// are unchanged since the sequence's last checkpoint are kept, so
+// checkpointing a slot before each request writes only what the last
+// one added.
+//
+// Checkpoints are ordinary blocks, budgeted and evicted like snapshots.
+// They outlive the process once written and indexed: with the JSON index
+// that is when the store is closed, with the journal index also after a
+// crash.
+
+// checkpointSeqBase is the first sequence checkpoints are stored under:
+// seq's is checkpointSeqBase+seq, clear of the runner's slots and below
+// the store's parked sessions.
+const checkpointSeqBase = 1 << 29
+
+// Checkpoint stores seq's positions [0, len(tokens)), which hold tokens,
+// as seq's checkpoint and returns how many positions it wrote. Call it
+// while no batch writes seq's cells, e.g. before the runner loads the
+// next prompt into a slot.
+func (t *TieredCausal) Checkpoint(seq int, tokens []int32) (int32, error) {
+	keep, stale := t.checkpointFrom(seq, tokens)
+	if !stale {
+		return 0, nil
+	}
+	if _, err := t.store.TruncateSeq(checkpointSeqBase+seq, keep); err != nil {
+		return 0, fmt.Errorf("kvcache: checkpoint seq %d: %w", seq, err)
+	}
+	return t.writeCheckpoint(seq, tokens, keep), nil
+}
+
+// checkpointFrom returns the position from which seq's checkpoint must
+// be rewritten to hold tokens, at a block boundary, and whether it needs
+// rewriting at all.
+func (t *TieredCausal) checkpointFrom(seq int, tokens []int32) (int32, bool) {
+	if !t.cfg.Enable {
+		return 0, false
+	}
+	t.loadCheckpoints()
+	t.mu.Lock()
+	stored := t.checkpoints[checkpointSeqBase+seq]
+	t.mu.Unlock()
+	n := commonPrefix(stored, tokens)
+	if n == len(stored) && n == len(tokens) {
+		return 0, false
+	}
+	return int32(n) / t.cfg.BlockSize * t.cfg.BlockSize, true
+}
+
+// writeCheckpoint stores the rows and tokens of seq's positions from
+// keep to len(tokens) under its checkpoint sequence, and returns how
+// many positions it wrote. The rows are stored at the positions they sit
+// at now, with no shift.
+func (t *TieredCausal) writeCheckpoint(seq int, tokens []int32, keep int32) int32 {
+	cseq := checkpointSeqBase + seq
+	cells := t.cellsOf(seq, keep, int32(len(tokens)))
+	end := keep
+	for run, runEnd := range t.runs(cells, keep, int32(len(tokens))) {
+		if run != end {
+			break // a gap: what follows can't be resumed
+		}
+		key := diskstore.BlockKey{Seq: cseq, BeginPos: run, EndPos: runEnd}
+		if !t.putRun(key, cells, 0) {
+			break
+		}
+		mk := diskstore.ManifestKey(key)
+		if err := t.store.PutAsync(mk, diskstore.ManifestDType, nil, diskstore.EncodeTokens(tokens[run:runEnd])); err != nil {
+			slog.Warn("tiered: failed to store token manifest", "key", mk, "error", err)
+			break
+		}
+		end = runEnd
+	}
+
+	t.mu.Lock()
+	if end > 0 {
+		t.checkpoints[cseq] = slices.Clone(tokens[:end])
+	} else {
+		delete(t.checkpoints, cseq)
+	}
+	t.mu.Unlock()
+	slog.Debug("tiered: checkpointed", "seq", seq, "kept", keep, "written", end-keep)
+	return end - keep
+}
+
+// Resume gives seq, whose prompt tokens were just set (SetPrompt), the
+// blocks of the checkpoint sharing the longest prefix with tokens, and
+// returns the length of that prefix, short of the whole prompt so that
+// one token is left to compute. RestoreRange then loads them. Resume
+// replaces seq's stored blocks, so call it only when nothing of the
+// prompt is in memory.
+func (t *TieredCausal) Resume(seq int, tokens []int32) (int32, error) {
+	if !t.cfg.Enable || len(tokens) < 2 {
+		return 0, nil
+	}
+	t.loadCheckpoints()
+	best, n := 0, 0
+	t.mu.Lock()
+	for cseq, stored := range t.checkpoints {
+		m := commonPrefix(stored, tokens)
+		if m > n || m == n && m > 0 && cseq < best {
+			best, n = cseq, m
+		}
+	}
+	t.mu.Unlock()
+	n = min(n, len(tokens)-1)
+	if n == 0 {
+		return 0, nil
+	}
+	if _, err := t.store.CopySeq(best, seq, int32(n)); err != nil {
+		return 0, fmt.Errorf("kvcache: resume seq %d: %w", seq, err)
+	}
+	slog.Debug("tiered: resuming from checkpoint", "seq", seq, "checkpoint", best-checkpointSeqBase, "positions", n)
+	return int32(n), nil
+}
+
+// loadCheckpoints reads the tokens of the checkpoints in the store, left
+// by this runner or an earlier one, the first time it is called.
+func (t *TieredCausal) loadCheckpoints() {
+	t.checkpointLoad.Do(func() {
+		found := make(map[int][]int32)
+		filter := diskstore.BlockFilter{Layers: []int{diskstore.ManifestLayer}, Namespaces: []string{""}}
+		for meta := range t.store.Iter(filter) {
+			cseq := meta.Key.Seq
+			if cseq < checkpointSeqBase || cseq >= 2*checkpointSeqBase || meta.Key.BeginPos != 0 {
+				continue
+			}
+			if tokens := t.storedCheckpoint(cseq); len(tokens) > 0 {
+				found[cseq] = tokens
+			}
+		}
+		t.mu.Lock()
+		t.checkpoints = found
+		t.mu.Unlock()
+		if len(found) > 0 {
+			slog.Info("tiered: found checkpoints", "count", len(found))
+		}
+	})
+}
+
+// storedCheckpoint returns the tokens of checkpoint sequence cseq from
+// position 0 on, as far as its manifest and rows are stored.
+func (t *TieredCausal) storedCheckpoint(cseq int) []int32 {
+	data, end, err := t.store.ReadRange(diskstore.ManifestKey(diskstore.BlockKey{Seq: cseq, EndPos: math.MaxInt32}))
+	if err != nil || end == 0 {
+		return nil
+	}
+	// Rows evicted since are stored no longer. Before the first batch
+	// the backend may have no tensors to tell which are stored.
+	if layer, isKey, ok := t.firstHalf(); ok {
+		spans := t.store.Coverage(diskstore.BlockKey{Seq: cseq, Layer: layer, IsKey: isKey, EndPos: end})
+		if len(spans) == 0 || spans[0].Begin > 0 {
+			return nil
+		}
+		end = min(end, spans[0].End)
+	}
+	return diskstore.DecodeTokens(data)[:end]
+}
+
+// commonPrefix returns how many leading tokens a and b share.
+func commonPrefix(a, b []int32) int {
+	n := min(len(a), len(b))
+	for i := range n {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
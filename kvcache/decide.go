@@ -0,0 +1,160 @@
+package kvcache
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// Restore or recompute: with TieredConfig.Adaptive, a restore first
+// compares the time to read the stored continuation, estimated from the
+// read rate the store measures per tier (diskstore.Store.EstimateRead),
+// with the time to prefill it at the measured prefill speed
+// (ObservePrefill). When prefilling is faster, for instance because the
+// blocks were evicted to a slow NFS tier, nothing is restored and the
+// caller recomputes the positions as it would without tiering.
+
+const (
+	// prefillSmoothing weighs the newest batch in the prefill speed.
+	prefillSmoothing = 0.3
+
+	// adaptiveProbeEvery makes one in this many restores that lose to
+	// recomputing read from disk anyway, so the tier rates follow a
+	// disk that got faster again.
+	adaptiveProbeEvery = 16
+)
+
+// RestoreDecision is the outcome of Decide.
+type RestoreDecision struct {
+	// Positions is how many positions from beginPos are stored.
+	Positions int32
+
+	// Disk and Recompute are the estimated times to restore and to
+	// prefill Positions, or 0 while the speed behind them is unmeasured.
+	Disk      time.Duration
+	Recompute time.Duration
+
+	// Restore reports whether restoring is expected to be faster. It is
+	// also set while either speed is unmeasured, and periodically to
+	// re-measure the disk.
+	Restore bool
+}
+
+// ObservePrefill reports that the model prefilled n positions in d. The
+// runner calls it for prompt batches; Decide compares restores against
+// the smoothed speed.
+func (t *TieredCausal) ObservePrefill(n int, d time.Duration) {
+	if n <= 0 || d <= 0 {
+		return
+	}
+	rate := float64(n) / d.Seconds()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.prefillRate > 0 {
+		rate = prefillSmoothing*rate + (1-prefillSmoothing)*t.prefillRate
+	}
+	t.prefillRate = rate
+}
+
+// PrefillRate returns the prefill speed in positions per second: measured
+// through ObservePrefill, else TieredConfig.PrefillRate.
+func (t *TieredCausal) PrefillRate() float64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.prefillRate
+}
+
+// Decide estimates whether restoring the stored continuation of
+// [beginPos, endPos) for seq is faster than recomputing it.
+func (t *TieredCausal) Decide(seq int, beginPos, endPos int32) RestoreDecision {
+	var hashes []string
+	if t.cfg.Addressing == AddressByPrefix {
+		t.mu.Lock()
+		hashes = t.prompts[seq]
+		t.mu.Unlock()
+	}
+
+	// Blocks are keyed by absolute position (see Shift).
+	shift := t.Shift(seq)
+	beginPos, endPos = beginPos+shift, endPos+shift
+
+	// Every stored half of every layer is read; positions are counted on
+	// the first.
+	var dec RestoreDecision
+	measured := true
+	first := true
+	for layer := 0; layer < t.backend.NumLayers(); layer++ {
+		for _, h := range t.storedHalves(layer) {
+			d, end, ok := t.estimateRead(seq, layer, h.isKey, beginPos, endPos, hashes)
+			if first {
+				dec.Positions, first = end-beginPos, false
+			}
+			dec.Disk += d
+			measured = measured && ok
+		}
+	}
+	if !measured {
+		dec.Disk = 0
+	}
+	rate := t.PrefillRate()
+	if rate > 0 {
+		dec.Recompute = time.Duration(float64(dec.Positions) / rate * float64(time.Second))
+	}
+	if dec.Positions == 0 {
+		return dec
+	}
+	if !measured || rate <= 0 || dec.Disk <= dec.Recompute {
+		dec.Restore = true
+		return dec
+	}
+
+	t.mu.Lock()
+	t.recomputed++
+	dec.Restore = t.recomputed%adaptiveProbeEvery == 0
+	t.mu.Unlock()
+	return dec
+}
+
+// estimateRead estimates reading positions [beginPos, endPos) of one
+// layer and half the way rowReader does, whole prompt blocks by prefix
+// first, and returns where the stored run stops.
+func (t *TieredCausal) estimateRead(seq, layer int, isKey bool, beginPos, endPos int32, hashes []string) (time.Duration, int32, bool) {
+	var total time.Duration
+	measured := true
+	bs := t.cfg.BlockSize
+	pos := beginPos
+	for pos < endPos {
+		key := diskstore.BlockKey{Seq: seq, Layer: layer, BeginPos: pos, EndPos: endPos, IsKey: isKey}
+		var d time.Duration
+		var end int32
+		var ok bool
+		if b := pos / bs; b < int32(len(hashes)) {
+			d, end, ok = t.store.EstimateRead(diskstore.PrefixKey("", hashes[b], layer, pos, (b+1)*bs, isKey))
+			key.EndPos = min(endPos, (b+1)*bs)
+		}
+		if end <= pos {
+			d, end, ok = t.store.EstimateRead(key)
+		}
+		if end <= pos {
+			break
+		}
+		total += d
+		measured = measured && ok
+		pos = min(end, endPos)
+	}
+	return total, pos, measured
+}
+
+// shouldRestore applies Decide for TieredConfig.Adaptive.
+func (t *TieredCausal) shouldRestore(seq int, beginPos, endPos int32) bool {
+	if !t.cfg.Adaptive {
+		return true
+	}
+	dec := t.Decide(seq, beginPos, endPos)
+	if !dec.Restore && dec.Positions > 0 {
+		slog.Info("tiered: recomputing instead of restoring from disk",
+			"seq", seq, "positions", dec.Positions, "disk", dec.Disk, "recompute", dec.Recompute)
+	}
+	return dec.Restore
+}
@@ -0,0 +1,124 @@
+package kvcache
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// EncoderBackend is what TieredEncoder needs from an encoder cache, such
+// as Ollama's EncoderCache: per layer a K and a V tensor holding the
+// encoder output of the last multimodal input (e.g. an image's
+// cross-attention keys and values), shared by every sequence. Row i is
+// row i of the output.
+type EncoderBackend interface {
+	// NumLayers, Keys, Values and DType are as for Backend.
+	NumLayers() int
+	Keys(layer int) TensorAccessor
+	Values(layer int) TensorAccessor
+	DType() string
+
+	// Rows returns how many rows the cached output fills, or 0 if
+	// nothing is cached.
+	Rows() int32
+
+	// MarkCached records that the tensors hold an output of n rows,
+	// written by a restore, or with n == 0 that they hold none.
+	MarkCached(n int32)
+}
+
+// TieredEncoder stores an encoder cache's output keyed by the hash of the
+// input it encoded (diskstore.ImageHash) and restores it for the same
+// input later, so the encoder need not run again.
+type TieredEncoder struct {
+	backend EncoderBackend
+	store   *diskstore.Store
+}
+
+// NewTieredEncoder tiers backend into store.
+func NewTieredEncoder(backend EncoderBackend, store *diskstore.Store) (*TieredEncoder, error) {
+	if store == nil {
+		return nil, fmt.Errorf("kvcache: TieredEncoder needs a store")
+	}
+	return &TieredEncoder{backend: backend, store: store}, nil
+}
+
+// Has reports whether the output for the input hashing to hash is stored.
+func (e *TieredEncoder) Has(hash string) bool {
+	_, ok := e.store.EncoderLen("", hash)
+	return ok
+}
+
+// Save stores the cached output as that of the input hashing to hash. It
+// does nothing if no output is cached or one is already stored for hash.
+func (e *TieredEncoder) Save(hash string) error {
+	n := e.backend.Rows()
+	if n == 0 || e.Has(hash) {
+		return nil
+	}
+	dtype := e.backend.DType()
+	for layer := 0; layer < e.backend.NumLayers(); layer++ {
+		for _, h := range e.halves(layer) {
+			data := make([]byte, 0, int(n)*h.tensor.RowSize())
+			for row := 0; row < int(n); row++ {
+				b, err := h.tensor.ReadRow(row)
+				if err != nil {
+					return fmt.Errorf("kvcache: save encoder output: %w", err)
+				}
+				data = append(data, b...)
+			}
+			key := diskstore.EncoderKey("", hash, layer, n, h.isKey)
+			if err := e.store.Put(key, dtype, h.tensor.Shape(), data); err != nil {
+				return fmt.Errorf("kvcache: save encoder output: %w", err)
+			}
+		}
+	}
+	slog.Debug("tiered: stored encoder output", "hash", hash, "rows", n)
+	return nil
+}
+
+// Load restores the output stored for the input hashing to hash and
+// reports whether it did. The cache holds no output while loading, so a
+// failed load leaves it empty rather than holding a mix of two inputs.
+func (e *TieredEncoder) Load(hash string) (bool, error) {
+	n, ok := e.store.EncoderLen("", hash)
+	if !ok {
+		return false, nil
+	}
+	e.backend.MarkCached(0)
+	var buf []byte // reused for every layer, which are copied into the tensors
+	for layer := 0; layer < e.backend.NumLayers(); layer++ {
+		for _, h := range e.halves(layer) {
+			data, _, err := e.store.GetInto(diskstore.EncoderKey("", hash, layer, n, h.isKey), buf)
+			if err != nil {
+				return false, fmt.Errorf("kvcache: load encoder output: %w", err)
+			}
+			buf = data
+			size := h.tensor.RowSize()
+			if len(data) != int(n)*size {
+				return false, fmt.Errorf("kvcache: load encoder output: layer %d has %d bytes, want %d", layer, len(data), int(n)*size)
+			}
+			for row := 0; row < int(n); row++ {
+				if err := h.tensor.WriteRow(row, data[row*size:(row+1)*size]); err != nil {
+					return false, fmt.Errorf("kvcache: load encoder output: %w", err)
+				}
+			}
+		}
+	}
+	e.backend.MarkCached(n)
+	slog.Debug("tiered: restored encoder output", "hash", hash, "rows", n)
+	return true, nil
+}
+
+// halves returns the tensors of layer.
+func (e *TieredEncoder) halves(layer int) []half {
+	var hs []half
+	if k := e.backend.Keys(layer); k != nil {
+		hs = append(hs, half{k, true})
+	}
+	if v := e.backend.Values(layer); v != nil {
+		hs = append(hs, half{v, false})
+	}
+	return hs
+}
@@ -0,0 +1,10 @@
+package kvcache
+
+import "time"
+
+// SetRestoreCost overrides the measured restore speed for tests.
+func (t *TieredCausal) SetRestoreCost(d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.restoreCost = d
+}
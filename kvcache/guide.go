@@ -0,0 +1,107 @@
+package kvcache
+
+import "fmt"
+
+// PrintIntegrationGuide prints step-by-step instructions for applying
+// the tiered cache to an Ollama checkout.
+func PrintIntegrationGuide() {
+	guide := `
+=== Ollama KV Cache Tiering — Integration Guide ===
+
+This project adds transparent disk-backed KV cache tiering to Ollama.
+When the context window fills up and Ollama evicts old tokens, the raw
+K/V tensor data is saved to disk (SSD → NFS) instead of being discarded.
+On subsequent requests with matching prefixes, the data is restored from
+disk, skipping recomputation.
+
+PREREQUISITES:
+  - Go 1.23+
+  - Ollama source (v0.16.x): git clone https://github.com/ollama/ollama
+  - gcc/cmake for CGO (Ollama's standard build deps)
+
+STEPS:
+
+1. Clone Ollama and this project side by side:
+
+     git clone https://github.com/ollama/ollama.git
+     git clone https://github.com/databloom/ollama-kv-cache-tiering.git
+
+2. Make this module a dependency of Ollama:
+
+     cd ollama
+     go mod edit -replace github.com/databloom/ollama-kv-cache-tiering=../ollama-kv-cache-tiering
+     go get github.com/databloom/ollama-kv-cache-tiering
+
+3. Check that the patch applies, then apply it to Ollama's kvcache and
+   runner:
+
+     (cd ../ollama-kv-cache-tiering && go run ./cmd/patch-ollama check -path ../ollama)
+     git apply ../ollama-kv-cache-tiering/patches/ollama-tiered-kvcache.patch
+
+   Or let patch-ollama do steps 2 and 3, with a copy of this module in
+   the checkout, and undo them later with revert:
+
+     (cd ../ollama-kv-cache-tiering && go run ./cmd/patch-ollama apply -path ../ollama)
+
+   Where git apply fails on a patch release's changes near the hooks,
+   apply places the patch structurally, by the Go syntax of the files.
+   generate, in place of apply, renders the same changes for the
+   checkout's version from the templates in patches/templates.
+
+   This patch:
+     a) Adds kvcache/tiered.go, an adapter exposing Causal to this
+        module's kvcache.TieredCausal (which holds the tiering logic)
+     b) Modifies runner/ollamarunner/cache.go:
+        - ShiftCacheSlot calls TieredCausal.Remove (snapshots before evicting)
+        - LoadCacheSlot checks disk store for extended prefix matches
+        - images already encoded skip the vision encoder (runner.go)
+     c) Adds environment variables:
+        - OLLAMA_KV_TIERING=1          (enable tiering)
+        - OLLAMA_KV_TIER_LOCAL=/path    (SSD cache dir)
+        - OLLAMA_KV_TIER_REMOTE=/path   (NFS cache dir, optional)
+        - OLLAMA_KV_TIER_LOCAL_GB=20    (local budget in GB)
+        - OLLAMA_KV_TIER_REMOTE_GB=5000 (remote budget in GB)
+        - OLLAMA_KV_TIER_COMPRESS=1     (enable zstd compression)
+
+4. Build Ollama:
+
+     go generate ./...
+     go build .
+
+5. Run with tiering enabled:
+
+     OLLAMA_KV_TIERING=1 \
+     OLLAMA_KV_TIER_LOCAL=/tmp/kv-cache \
+     OLLAMA_KV_TIER_REMOTE=/mnt/kv-cache \
+     OLLAMA_KV_TIER_LOCAL_GB=20 \
+     OLLAMA_KV_TIER_REMOTE_GB=5000 \
+     OLLAMA_KV_TIER_COMPRESS=1 \
+     ./ollama serve
+
+HOW IT WORKS:
+
+  Normal Ollama flow:
+    1. Prompt arrives → tokenize → fill KV cache → generate
+    2. Context full → ShiftCacheSlot → Remove(oldest half) → GONE
+    3. New prompt → recompute from scratch if prefix doesn't match
+
+  With tiering:
+    1. Prompt arrives → tokenize → fill KV cache → generate
+    2. Context full → ShiftCacheSlot → snapshot K/V bytes to SSD → Remove
+    3. SSD full → oldest blocks migrate to NFS (background)
+    4. New prompt → check disk for matching prefix → restore K/V from disk
+    5. Only recompute tokens not found on disk
+
+  Benefits:
+    - Context shifts are near-instant (restore from SSD: ~2ms per 256 tokens)
+    - Long conversations retain KV state across context windows
+    - System prompts cached persistently (never recomputed)
+    - NFS tier provides TB-scale cold storage for rarely-used contexts
+
+TARGET HARDWARE:
+
+  Molly  (2x GTX 1070, 16GB):  SSD local + 5.8TB NFS from Wintermute
+  Wintermute (2x M6000, 48GB): NVMe local + 12TB HDD
+`
+	fmt.Println(guide)
+}
@@ -0,0 +1,81 @@
+package kvcache
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// LayerPolicy selects the layers snapshots store. On a slow disk reading
+// every layer back may cost more than recomputing some of them, so a
+// policy can store only every Nth layer, only the layers from some depth
+// on, or both; restores rebuild the others through a LayerRecomputer.
+// The zero policy stores every layer.
+type LayerPolicy struct {
+	// From is the first layer stored; layers below it are recomputed.
+	From int
+
+	// Every stores one layer in Every, counting from From. 0 and 1 store
+	// every layer from From on.
+	Every int
+}
+
+// Stores reports whether snapshots write layer.
+func (p LayerPolicy) Stores(layer int) bool {
+	if layer < p.From {
+		return false
+	}
+	return p.Every <= 1 || (layer-p.From)%p.Every == 0
+}
+
+// All reports whether p stores every layer.
+func (p LayerPolicy) All() bool { return p.From <= 0 && p.Every <= 1 }
+
+func (p LayerPolicy) String() string {
+	var parts []string
+	if p.Every > 1 {
+		parts = append(parts, fmt.Sprintf("every:%d", p.Every))
+	}
+	if p.From > 0 {
+		parts = append(parts, fmt.Sprintf("above:%d", p.From-1))
+	}
+	if len(parts) == 0 {
+		return "all"
+	}
+	return strings.Join(parts, ",")
+}
+
+// ParseLayerPolicy parses "all", "every:N" (every Nth layer), "above:K"
+// (only layers above K) or both separated by a comma, e.g.
+// "every:2,above:7". An empty string means every layer.
+func ParseLayerPolicy(s string) (LayerPolicy, error) {
+	var p LayerPolicy
+	if s == "" || s == "all" {
+		return p, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		name, arg, _ := strings.Cut(part, ":")
+		n, err := strconv.Atoi(arg)
+		if err != nil || n < 0 {
+			return LayerPolicy{}, fmt.Errorf("kvcache: invalid layer policy %q", s)
+		}
+		switch name {
+		case "every":
+			p.Every = n
+		case "above":
+			p.From = n + 1
+		default:
+			return LayerPolicy{}, fmt.Errorf("kvcache: unknown layer policy %q", s)
+		}
+	}
+	return p, nil
+}
+
+// LayerRecomputer rebuilds the K and V rows of a position in a layer that
+// TieredConfig.Layers does not store, typically by running the layer's K
+// and V projections on hidden states the caller kept. k and v are the
+// rows of the tensors being restored into; v is nil for a layer without
+// a value tensor.
+type LayerRecomputer interface {
+	RecomputeLayer(seq, layer int, pos int32, k, v []byte) error
+}
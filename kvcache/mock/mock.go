@@ -0,0 +1,370 @@
+// Package mock implements kvcache.Backend with plain byte slices, so the
+// tiering logic can be tested and fuzzed without building Ollama.
+//
+// Row contents are a deterministic function of (seq, layer, half, pos),
+// which lets a test check restored data against what was snapshot, even
+// in another Backend:
+//
+//	b := mock.New(2, 64, 16)
+//	b.Fill(1, 0, 40)
+//	// ... snapshot, restore into b2 ...
+//	err := b2.Check(1, 1)
+package mock
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"slices"
+	"sort"
+
+	"github.com/databloom/ollama-kv-cache-tiering/kvcache"
+)
+
+var (
+	_ kvcache.Backend         = (*Backend)(nil)
+	_ kvcache.Windowed        = (*Backend)(nil)
+	_ kvcache.Shifter         = (*Shifting)(nil)
+	_ kvcache.EncoderBackend  = (*Encoder)(nil)
+	_ kvcache.Recomputer      = Recomputer{}
+	_ kvcache.LayerRecomputer = (*LayerRecomputer)(nil)
+)
+
+// Backend is an in-memory cache: per layer a K and a V tensor with one
+// row per cell, and a cell table. Setting V[layer] (or K[layer]) to nil
+// models a layer without that tensor.
+type Backend struct {
+	K, V []*Tensor
+
+	// DTypeName is reported by DType (default "f16").
+	DTypeName string
+
+	// WindowSize is reported by SlidingWindow; 0 is full attention.
+	WindowSize int32
+
+	rowSize int
+	cells   []cell
+}
+
+type cell struct {
+	pos  int32
+	seqs []int
+}
+
+// New returns a Backend with layers layers, numCells free cells and rows
+// of rowSize bytes.
+func New(layers, numCells, rowSize int) *Backend {
+	b := &Backend{DTypeName: "f16", rowSize: rowSize, cells: make([]cell, numCells)}
+	for i := 0; i < layers; i++ {
+		b.K = append(b.K, NewTensor(numCells, rowSize))
+		b.V = append(b.V, NewTensor(numCells, rowSize))
+	}
+	return b
+}
+
+// Row returns the contents Fill writes for a row. A value row is the
+// complement of the key row, so Recomputer can derive either from the
+// other.
+func (b *Backend) Row(seq, layer int, isKey bool, pos int32) []byte {
+	h := fnv.New64a()
+	var buf [12]byte
+	binary.LittleEndian.PutUint32(buf[0:], uint32(seq))
+	binary.LittleEndian.PutUint32(buf[4:], uint32(layer))
+	binary.LittleEndian.PutUint32(buf[8:], uint32(pos))
+	h.Write(buf[:])
+	x := h.Sum64() | 1
+
+	row := make([]byte, b.rowSize)
+	for i := range row {
+		x ^= x << 13
+		x ^= x >> 7
+		x ^= x << 17
+		row[i] = byte(x)
+	}
+	if !isKey {
+		for i := range row {
+			row[i] = ^row[i]
+		}
+	}
+	return row
+}
+
+// Fill stores positions [begin, end) of seq in free cells, lowest cell
+// first, and returns how many fit.
+func (b *Backend) Fill(seq int, begin, end int32) int {
+	var n int
+	next := 0
+	for pos := begin; pos < end; pos++ {
+		for next < len(b.cells) && len(b.cells[next].seqs) > 0 {
+			next++
+		}
+		if next == len(b.cells) {
+			break
+		}
+		b.cells[next] = cell{pos: pos, seqs: []int{seq}}
+		for layer := range b.K {
+			if b.K[layer] != nil {
+				b.K[layer].WriteRow(next, b.Row(seq, layer, true, pos))
+			}
+			if b.V[layer] != nil {
+				b.V[layer].WriteRow(next, b.Row(seq, layer, false, pos))
+			}
+		}
+		n++
+	}
+	return n
+}
+
+// Positions returns the positions held for seq, sorted.
+func (b *Backend) Positions(seq int) []int32 {
+	var out []int32
+	for _, c := range b.cells {
+		if slices.Contains(c.seqs, seq) {
+			out = append(out, c.pos)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+// Check verifies that every cell of seq holds the rows Fill wrote for
+// src at the cell's position, in every layer and half.
+func (b *Backend) Check(seq, src int) error {
+	for i, c := range b.cells {
+		if !slices.Contains(c.seqs, seq) {
+			continue
+		}
+		for layer := range b.K {
+			for _, isKey := range []bool{true, false} {
+				t := b.V[layer]
+				if isKey {
+					t = b.K[layer]
+				}
+				if t == nil {
+					continue
+				}
+				got, _ := t.ReadRow(i)
+				if !bytes.Equal(got, b.Row(src, layer, isKey, c.pos)) {
+					return fmt.Errorf("mock: cell %d (pos %d) layer %d key=%v does not hold seq %d's row", i, c.pos, layer, isKey, src)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// NumLayers implements kvcache.Backend.
+func (b *Backend) NumLayers() int { return len(b.K) }
+
+// Keys implements kvcache.Backend.
+func (b *Backend) Keys(layer int) kvcache.TensorAccessor { return accessor(b.K[layer]) }
+
+// Values implements kvcache.Backend.
+func (b *Backend) Values(layer int) kvcache.TensorAccessor { return accessor(b.V[layer]) }
+
+// DType implements kvcache.Backend.
+func (b *Backend) DType() string { return b.DTypeName }
+
+// SlidingWindow implements kvcache.Windowed.
+func (b *Backend) SlidingWindow() int32 { return b.WindowSize }
+
+// Cells implements kvcache.Backend.
+func (b *Backend) Cells() kvcache.CellTable { return b }
+
+// Remove implements kvcache.Backend: it frees seq's cells in [begin, end)
+// and drops seq from cells it shares.
+func (b *Backend) Remove(seq int, begin, end int32) error {
+	for i := range b.cells {
+		c := &b.cells[i]
+		if c.pos < begin || c.pos >= end || !slices.Contains(c.seqs, seq) {
+			continue
+		}
+		c.seqs = slices.DeleteFunc(slices.Clone(c.seqs), func(s int) bool { return s == seq })
+	}
+	return nil
+}
+
+// NumCells implements kvcache.CellTable.
+func (b *Backend) NumCells() int { return len(b.cells) }
+
+// Cell implements kvcache.CellTable.
+func (b *Backend) Cell(i int) (int32, []int) { return b.cells[i].pos, b.cells[i].seqs }
+
+// Occupy implements kvcache.CellTable.
+func (b *Backend) Occupy(i int, seq int, pos int32) {
+	b.cells[i] = cell{pos: pos, seqs: []int{seq}}
+}
+
+// accessor avoids wrapping a nil *Tensor in a non-nil interface.
+func accessor(t *Tensor) kvcache.TensorAccessor {
+	if t == nil {
+		return nil
+	}
+	return t
+}
+
+// Tensor is a kvcache.TensorAccessor over a byte slice.
+type Tensor struct {
+	Data    []byte
+	rowSize int
+}
+
+// NewTensor returns a zeroed tensor of rows rows.
+func NewTensor(rows, rowSize int) *Tensor {
+	return &Tensor{Data: make([]byte, rows*rowSize), rowSize: rowSize}
+}
+
+// RowSize implements kvcache.TensorAccessor.
+func (t *Tensor) RowSize() int { return t.rowSize }
+
+// Shape implements kvcache.TensorAccessor.
+func (t *Tensor) Shape() []int { return []int{t.rowSize} }
+
+// ReadRow implements kvcache.TensorAccessor.
+func (t *Tensor) ReadRow(cell int) ([]byte, error) {
+	if cell < 0 || (cell+1)*t.rowSize > len(t.Data) {
+		return nil, fmt.Errorf("mock: cell %d out of range", cell)
+	}
+	return t.Data[cell*t.rowSize : (cell+1)*t.rowSize], nil
+}
+
+// WriteRow implements kvcache.TensorAccessor.
+func (t *Tensor) WriteRow(cell int, data []byte) error {
+	row, err := t.ReadRow(cell)
+	if err != nil {
+		return err
+	}
+	copy(row, data)
+	return nil
+}
+
+// Shifting is a Backend that shifts like Ollama's Causal
+// (kvcache.Shifter): removing positions from the middle of a sequence
+// moves the later ones down. Keys are not rotated; Shifts records by
+// cell how far they would have been, so a test can check what a restore
+// applied.
+type Shifting struct {
+	*Backend
+	Shifts map[int]int32
+}
+
+// NewShifting returns a Shifting backend, as New.
+func NewShifting(layers, numCells, rowSize int) *Shifting {
+	return &Shifting{Backend: New(layers, numCells, rowSize), Shifts: make(map[int]int32)}
+}
+
+// Remove implements kvcache.Backend: it frees seq's cells in [begin, end)
+// and, unless end is math.MaxInt32, moves seq's later positions down.
+func (b *Shifting) Remove(seq int, begin, end int32) error {
+	b.Backend.Remove(seq, begin, end)
+	if end == math.MaxInt32 {
+		return nil
+	}
+	for i := range b.cells {
+		if c := &b.cells[i]; c.pos >= end && slices.Contains(c.seqs, seq) {
+			c.pos -= end - begin
+			b.Shifts[i] -= end - begin
+		}
+	}
+	return nil
+}
+
+// ShiftCells implements kvcache.Shifter.
+func (b *Shifting) ShiftCells(cells []int, delta int32) error {
+	for _, c := range cells {
+		b.Shifts[c] += delta
+	}
+	return nil
+}
+
+// Encoder is an in-memory encoder cache: Backend's tensors, one row per
+// row of the encoder output, of which the first Len are cached.
+type Encoder struct {
+	*Backend
+	Len int32
+}
+
+// NewEncoder returns an Encoder with layers layers and room for outputs
+// of up to rows rows of rowSize bytes.
+func NewEncoder(layers, rows, rowSize int) *Encoder {
+	return &Encoder{Backend: New(layers, rows, rowSize)}
+}
+
+// Encode caches an output of n rows for input, with the contents Row
+// gives for sequence input.
+func (e *Encoder) Encode(input int, n int32) {
+	for layer := range e.K {
+		for row := int32(0); row < n; row++ {
+			if e.K[layer] != nil {
+				e.K[layer].WriteRow(int(row), e.Row(input, layer, true, row))
+			}
+			if e.V[layer] != nil {
+				e.V[layer].WriteRow(int(row), e.Row(input, layer, false, row))
+			}
+		}
+	}
+	e.Len = n
+}
+
+// CheckEncoded verifies that the cached output is what Encode wrote for
+// input.
+func (e *Encoder) CheckEncoded(input int) error {
+	for layer := range e.K {
+		for row := int32(0); row < e.Len; row++ {
+			for _, isKey := range []bool{true, false} {
+				t := e.V[layer]
+				if isKey {
+					t = e.K[layer]
+				}
+				if t == nil {
+					continue
+				}
+				got, _ := t.ReadRow(int(row))
+				if !bytes.Equal(got, e.Row(input, layer, isKey, row)) {
+					return fmt.Errorf("mock: encoder row %d layer %d key=%v does not hold input %d's row", row, layer, isKey, input)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Rows implements kvcache.EncoderBackend.
+func (e *Encoder) Rows() int32 { return e.Len }
+
+// MarkCached implements kvcache.EncoderBackend.
+func (e *Encoder) MarkCached(n int32) { e.Len = n }
+
+// Recomputer implements kvcache.Recomputer for rows written by Fill:
+// each half is the complement of the other.
+type Recomputer struct{}
+
+// Recompute implements kvcache.Recomputer.
+func (Recomputer) Recompute(seq, layer int, pos int32, have, dst []byte, isKey bool) error {
+	if len(have) != len(dst) {
+		return fmt.Errorf("mock: recompute from %d bytes into %d", len(have), len(dst))
+	}
+	for i := range have {
+		dst[i] = ^have[i]
+	}
+	return nil
+}
+
+// LayerRecomputer implements kvcache.LayerRecomputer for rows written by
+// Fill, as if the layer were run again, and counts the rows it rebuilt.
+type LayerRecomputer struct {
+	Backend *Backend
+	Rows    int
+}
+
+// RecomputeLayer implements kvcache.LayerRecomputer.
+func (r *LayerRecomputer) RecomputeLayer(seq, layer int, pos int32, k, v []byte) error {
+	copy(k, r.Backend.Row(seq, layer, true, pos))
+	if v != nil {
+		copy(v, r.Backend.Row(seq, layer, false, pos))
+	}
+	r.Rows++
+	return nil
+}
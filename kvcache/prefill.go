@@ -0,0 +1,118 @@
+package kvcache
+
+import (
+	"context"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// SetPrompt records the tokens loaded into seq. The runner numbers them
+// from the start of the prompt, so seq's positions are absolute again
+// (see Shift). With AddressByPrefix, snapshots key whole blocks of this
+// prompt by prefix hash and restores look them up the same way; with
+// TieredConfig.Audit, restores are checked against it.
+func (t *TieredCausal) SetPrompt(seq int, tokens []int32) {
+	t.mu.Lock()
+	delete(t.shifts, seq)
+	delete(t.auditFailed, seq)
+	t.mu.Unlock()
+	t.RecordTokens(seq, tokens)
+	if t.cfg.Addressing != AddressByPrefix {
+		return
+	}
+	hashes := diskstore.PrefixHashes(tokens, int(t.cfg.BlockSize))
+	t.mu.Lock()
+	t.prompts[seq] = hashes
+	t.mu.Unlock()
+}
+
+// MatchPrompt returns how many leading positions of seq's prompt are
+// stored as prefix-addressed blocks, by any sequence.
+func (t *TieredCausal) MatchPrompt(seq int) int32 {
+	if t.cfg.Addressing != AddressByPrefix {
+		return 0
+	}
+	t.mu.Lock()
+	hashes := t.prompts[seq]
+	t.mu.Unlock()
+	return int32(t.store.MatchPrefix("", hashes, t.cfg.BlockSize)) * t.cfg.BlockSize
+}
+
+// Prefetch asks the store to move the blocks of seq's positions
+// [beginPos, endPos) that are on the remote tier to the local tier in the
+// background, and returns how many blocks it queued. Call it as soon as a
+// request is known to resume a stored prompt, ahead of RestoreRange.
+func (t *TieredCausal) Prefetch(seq int, beginPos, endPos int32) int {
+	if !t.cfg.Enable || endPos <= beginPos {
+		return 0
+	}
+	shift := t.Shift(seq)
+	beginPos, endPos = beginPos+shift, endPos+shift
+	var hashes []string
+	if t.cfg.Addressing == AddressByPrefix {
+		t.mu.Lock()
+		hashes = t.prompts[seq]
+		t.mu.Unlock()
+	}
+
+	bs := t.cfg.BlockSize
+	var n int
+	for layer := 0; layer < t.backend.NumLayers(); layer++ {
+		for _, h := range t.storedHalves(layer) {
+			n += t.store.PrefetchRange(diskstore.BlockKey{Seq: seq, Layer: layer, BeginPos: beginPos, EndPos: endPos, IsKey: h.isKey})
+			for b := beginPos / bs; b < int32(len(hashes)) && b*bs < endPos; b++ {
+				n += t.store.PrefetchRange(diskstore.PrefixKey("", hashes[b], layer, max(b*bs, beginPos), (b+1)*bs, h.isKey))
+			}
+		}
+	}
+	return n
+}
+
+// ServePrefill publishes every prompt loaded into a slot (PublishPrefill)
+// and snapshots its positions when a decode node pulls them. The caller
+// serves the store's BlockServiceHandler to make them reachable.
+func (t *TieredCausal) ServePrefill() {
+	t.mu.Lock()
+	t.publish = true
+	t.mu.Unlock()
+	t.store.SetExportHook(func(seq int, length int32) error {
+		// A pull arrives after the prefill request finished, so the
+		// runner no longer writes these cells.
+		t.snapshotRange(seq, 0, length)
+		return nil
+	})
+}
+
+// SetPrefillPeer makes PullPrefill fetch prompts from the prefill node at
+// peer, waiting up to wait for one that is still being computed.
+func (t *TieredCausal) SetPrefillPeer(peer *diskstore.RemoteClient, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.prefillPeer, t.prefillWait = peer, wait
+}
+
+// PublishPrefill offers the KV cache of tokens in seq to decode nodes.
+func (t *TieredCausal) PublishPrefill(seq int, tokens []int32) {
+	t.mu.Lock()
+	publish := t.publish
+	t.mu.Unlock()
+	if !publish || len(tokens) == 0 {
+		return
+	}
+	t.store.Publish(diskstore.PrefixHash(tokens), seq, int32(len(tokens)))
+}
+
+// PullPrefill fetches the KV cache of tokens from the prefill peer into
+// the store as seq, for RestoreRange to load. It reports whether any
+// blocks arrived.
+func (t *TieredCausal) PullPrefill(ctx context.Context, seq int, tokens []int32) (bool, error) {
+	t.mu.Lock()
+	peer, wait := t.prefillPeer, t.prefillWait
+	t.mu.Unlock()
+	if peer == nil || len(tokens) == 0 {
+		return false, nil
+	}
+	n, err := peer.PullPrefix(ctx, diskstore.PrefixHash(tokens), wait, t.store, seq)
+	return n > 0, err
+}
@@ -0,0 +1,41 @@
+package kvcache
+
+import (
+	"log/slog"
+)
+
+// Backpressure: with TieredConfig.MaxPressure, Remove consults the
+// store's write pressure (diskstore.Store.Pressure) before it snapshots
+// the positions it evicts, and when the pressure is at MaxPressure or
+// above frees them without a snapshot. The context shift goes on at
+// VRAM speed instead of waiting on a write queue the disk can't drain;
+// the positions skipped are recomputed if they are needed again, as
+// without tiering. Snapshots a prefill node takes for a decode node
+// (ServePrefill) are never skipped, since the node waits for them.
+
+// underPressure reports whether the snapshot of seq's [beginPos, endPos)
+// is to be skipped, and counts it if so.
+func (t *TieredCausal) underPressure(seq int, beginPos, endPos int32) bool {
+	if t.cfg.MaxPressure <= 0 {
+		return false
+	}
+	p := t.store.Pressure()
+	if p.Level < t.cfg.MaxPressure {
+		return false
+	}
+	t.mu.Lock()
+	t.skipped += int64(endPos - beginPos)
+	t.mu.Unlock()
+	slog.Debug("tiered: store under pressure, not snapshotting evicted KV",
+		"seq", seq, "begin", beginPos, "end", endPos,
+		"level", p.Level, "queued", p.QueueDepth, "latency", p.WriteLatency, "headroom", p.Headroom)
+	return true
+}
+
+// SkippedSnapshots returns how many evicted positions Remove did not
+// snapshot because the store was under pressure.
+func (t *TieredCausal) SkippedSnapshots() int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.skipped
+}
@@ -0,0 +1,627 @@
+package kvcache
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// RestoreRange loads positions [beginPos, endPos) of seq from disk into
+// free cells, stopping at the first position that is not stored for
+// every layer or when the cache runs out of free cells, and returns how
+// many positions it restored. The runner calls it when the disk extends
+// the in-memory prefix match.
+//
+// Rows are read a chunk at a time (diskstore.Store.ReadRange) and placed
+// in one contiguous run of cells when the cache has one, so a restored
+// prefix is not scattered across the cache.
+//
+// Half snapshots (TieredConfig.Snapshot) are completed through the
+// Recomputer and layers the LayerPolicy skips are rebuilt through the
+// LayerRecomputer; without the one needed nothing is restored. Runs shorter than
+// TieredConfig.MinRestoreRun are not restored either.
+//
+// Rows stored at another shift than seq's current one (see Shift) are
+// restored with their keys rotated by a Shifter backend; other backends
+// stop at them.
+//
+// A sliding-window backend (Windowed) only needs the last window of
+// positions before the new end, so only those are restored and positions
+// before them need not be stored. The result still counts every position
+// from beginPos: it is how far the sequence's prefix now extends.
+func (t *TieredCausal) RestoreRange(seq int, beginPos, endPos int32) (int32, error) {
+	return t.RestoreRangeContext(context.Background(), seq, beginPos, endPos)
+}
+
+// RestoreRangeContext is RestoreRange giving up on disk reads when ctx is
+// done, e.g. at a deadline for the restore. It then stops at the first
+// position it could not read in time and returns how far it got, which
+// stays restored, with a nil error.
+func (t *TieredCausal) RestoreRangeContext(ctx context.Context, seq int, beginPos, endPos int32) (int32, error) {
+	start := time.Now()
+	rows, avail := t.probe(ctx, seq, beginPos, endPos)
+	if rows == nil {
+		return 0, nil
+	}
+	return t.restoreTo(rows, seq, beginPos, avail, start) - beginPos, nil
+}
+
+// RestorePlan is the outcome of RestoreRecent. Positions [Begin, End)
+// were restored; the caller recomputes [From, Begin) and [End, endPos).
+// Positions in [From, Begin) that are on disk stay there, so an idle
+// caller may still load them with RestoreRange instead.
+type RestorePlan struct {
+	From, Begin, End int32
+}
+
+// Restored returns the number of positions restored.
+func (p RestorePlan) Restored() int32 { return p.End - p.Begin }
+
+// Skipped returns the number of positions before Begin left to the
+// caller.
+func (p RestorePlan) Skipped() int32 { return p.Begin - p.From }
+
+// RestoreRecent is RestoreRange for a latency budget: when restoring all
+// of the stored continuation of [beginPos, endPos) would take longer than
+// TieredConfig.RestoreBudget at the restore speed measured so far, it
+// restores only the newest positions that fit, those closest to the
+// generation frontier, newest first. Without a budget or a measurement it
+// restores everything it can, like RestoreRange.
+func (t *TieredCausal) RestoreRecent(seq int, beginPos, endPos int32) (RestorePlan, error) {
+	start := time.Now()
+	plan := RestorePlan{From: beginPos, Begin: beginPos, End: beginPos}
+	rows, avail := t.probe(context.Background(), seq, beginPos, endPos)
+	if rows == nil {
+		return plan, nil
+	}
+
+	from := beginPos
+	if t.window > 0 {
+		from = max(from, avail-t.window)
+		plan.From, plan.Begin, plan.End = from, from, from
+	}
+	if fit, ok := t.positionsWithin(t.cfg.RestoreBudget); ok && avail-from > fit {
+		from = avail - fit
+		slog.Debug("tiered: restore over budget, restoring newest positions",
+			"seq", seq, "stored", avail-beginPos, "restoring", fit, "budget", t.cfg.RestoreBudget)
+	}
+	plan.Begin, plan.End = t.restore(rows, seq, from, avail, true, start)
+	return plan, nil
+}
+
+// probe returns a reader for seq's stored rows and how far from beginPos
+// (up to endPos) they can restore the sequence (see scan and audit), or a
+// nil reader when restoring is off. The reader's reads give up when ctx
+// is done.
+func (t *TieredCausal) probe(ctx context.Context, seq int, beginPos, endPos int32) (*rowReader, int32) {
+	if !t.cfg.Enable {
+		return nil, beginPos
+	}
+	storeKeys, storeValues := t.cfg.Snapshot.StoresKeys(), t.cfg.Snapshot.StoresValues()
+	if !(storeKeys && storeValues) && t.cfg.Recompute == nil {
+		return nil, beginPos
+	}
+	if !t.cfg.Layers.All() && t.cfg.RecomputeLayers == nil {
+		return nil, beginPos
+	}
+
+	// Snapshots may still be queued (diskstore.Config.WriteQueue).
+	t.store.Flush()
+	if !t.shouldRestore(seq, beginPos, endPos) {
+		return nil, beginPos
+	}
+
+	rows := &rowReader{
+		ctx:    ctx,
+		store:  t.store,
+		chunk:  t.cfg.BlockSize,
+		offset: t.Shift(seq),
+		cached: make(map[diskstore.BlockKey]rowChunk),
+		failed: make(map[diskstore.BlockKey]error),
+	}
+	for layer := 0; layer < t.backend.NumLayers(); layer++ {
+		for _, h := range t.storedHalves(layer) {
+			rows.halves = append(rows.halves, diskstore.BlockKey{Layer: layer, IsKey: h.isKey})
+		}
+	}
+	if t.cfg.Addressing == AddressByPrefix {
+		t.mu.Lock()
+		rows.hashes = t.prompts[seq]
+		t.mu.Unlock()
+	}
+
+	avail := t.scan(rows, seq, beginPos, endPos)
+	if t.cfg.Audit != AuditOff {
+		avail = t.audit(rows, seq, beginPos, avail)
+	}
+	return rows, avail
+}
+
+// scan returns the furthest end up to endPos that seq can be restored to
+// from beginPos: the end of the stored run starting at beginPos or, with a
+// sliding window, the furthest end whose last window of positions from
+// beginPos on is stored. Only the first stored layer and half is checked.
+func (t *TieredCausal) scan(rows *rowReader, seq int, beginPos, endPos int32) int32 {
+	layer, isKey, ok := t.firstHalf()
+	if !ok {
+		return beginPos
+	}
+
+	if t.window == 0 {
+		// The prefix must be contiguous: stop at the first gap.
+		avail := beginPos
+		for avail < endPos && rows.has(seq, layer, isKey, avail) {
+			avail++
+		}
+		return avail
+	}
+
+	spans := t.coverage(rows, seq, layer, isKey, beginPos, endPos)
+	for i := len(spans) - 1; i >= 0; i-- {
+		if sp := spans[i]; sp.Begin <= beginPos || sp.End-sp.Begin >= t.window {
+			return sp.End
+		}
+	}
+	return beginPos
+}
+
+// coverage returns the stored runs of one layer and half in
+// [beginPos, endPos), by sequence and, for whole blocks of the prompt,
+// by prefix, merged regardless of their shift.
+func (t *TieredCausal) coverage(rows *rowReader, seq, layer int, isKey bool, beginPos, endPos int32) []diskstore.Span {
+	hashes, off := rows.hashes, rows.offset
+	beginPos, endPos = beginPos+off, endPos+off
+	spans := t.store.Coverage(diskstore.BlockKey{Seq: seq, Layer: layer, BeginPos: beginPos, EndPos: endPos, IsKey: isKey})
+	bs := t.cfg.BlockSize
+	for b := beginPos / bs; b < int32(len(hashes)) && b*bs < endPos; b++ {
+		key := diskstore.PrefixKey("", hashes[b], layer, max(b*bs, beginPos), min((b+1)*bs, endPos), isKey)
+		spans = append(spans, t.store.Coverage(key)...)
+	}
+	sort.Slice(spans, func(i, j int) bool { return spans[i].Begin < spans[j].Begin })
+
+	var out []diskstore.Span
+	for _, sp := range spans {
+		if n := len(out); n > 0 && sp.Begin <= out[n-1].End {
+			out[n-1].End = max(out[n-1].End, sp.End)
+			continue
+		}
+		out = append(out, sp)
+	}
+	for i := range out {
+		out[i].Begin, out[i].End = out[i].Begin-off, out[i].End-off
+	}
+	return out
+}
+
+// firstHalf returns the first layer and half that snapshots store.
+func (t *TieredCausal) firstHalf() (layer int, isKey, ok bool) {
+	for layer := 0; layer < t.backend.NumLayers(); layer++ {
+		if hs := t.storedHalves(layer); len(hs) > 0 {
+			return layer, hs[0].isKey, true
+		}
+	}
+	return 0, false, false
+}
+
+// restoreTo restores what seq needs to extend from beginPos to end: every
+// position, or with a sliding window only the last window of them. It
+// returns the end reached, which with a window is beginPos unless the
+// whole window was restored.
+func (t *TieredCausal) restoreTo(rows *rowReader, seq int, beginPos, end int32, start time.Time) int32 {
+	from := beginPos
+	if t.window > 0 {
+		from = max(beginPos, end-t.window)
+	}
+	_, reached := t.restore(rows, seq, from, end, false, start)
+	if from > beginPos && reached < end {
+		// A partial window is of no use: attention over it would miss
+		// the positions in between.
+		if reached > from {
+			t.backend.Remove(seq, from, math.MaxInt32)
+		}
+		return beginPos
+	}
+	return reached
+}
+
+// restore loads positions [from, to) into free cells, oldest or newest
+// first, and returns the range it restored. With too few free cells it
+// keeps the oldest or newest positions respectively, and it restores
+// nothing if fewer than MinRestoreRun remain. start is when the restore
+// began, for the speed estimate.
+func (t *TieredCausal) restore(rows *rowReader, seq int, from, to int32, newestFirst bool, start time.Time) (int32, int32) {
+	table := t.backend.Cells()
+	cells := freeCells(table, int(to-from))
+	if len(cells) < int(to-from) {
+		slog.Debug("tiered: not enough free cells for restore",
+			"seq", seq, "want", to-from, "free", len(cells))
+		if newestFirst {
+			from = to - int32(len(cells))
+		} else {
+			to = from + int32(len(cells))
+		}
+	}
+	if to-from < t.cfg.MinRestoreRun {
+		slog.Debug("tiered: restore run too short, recomputing",
+			"seq", seq, "positions", to-from, "min", t.cfg.MinRestoreRun)
+		if newestFirst {
+			return to, to
+		}
+		return from, from
+	}
+
+	begin, end := from, from
+	shifted := make(map[int32][]int) // restored cells by key shift
+	if newestFirst {
+		begin, end = to, to
+		for i := len(cells) - 1; i >= 0; i-- {
+			pos := from + int32(i)
+			delta, err := t.restoreRow(rows, seq, pos, cells[i])
+			if err != nil {
+				logRestoreStop(seq, pos, err)
+				break
+			}
+			table.Occupy(cells[i], seq, pos)
+			if delta != 0 {
+				shifted[delta] = append(shifted[delta], cells[i])
+			}
+			begin = pos
+		}
+	} else {
+		for i, cell := range cells {
+			pos := from + int32(i)
+			delta, err := t.restoreRow(rows, seq, pos, cell)
+			if err != nil {
+				logRestoreStop(seq, pos, err)
+				break
+			}
+			table.Occupy(cell, seq, pos)
+			if delta != 0 {
+				shifted[delta] = append(shifted[delta], cell)
+			}
+			end = pos + 1
+		}
+	}
+
+	// Keys stored at another shift still encode their old positions.
+	for delta, cells := range shifted {
+		if err := t.backend.(Shifter).ShiftCells(cells, delta); err != nil {
+			slog.Warn("tiered: failed to shift restored keys, recomputing", "seq", seq, "error", err)
+			t.backend.Remove(seq, begin, math.MaxInt32)
+			if newestFirst {
+				return to, to
+			}
+			return from, from
+		}
+	}
+
+	if n := end - begin; n > 0 {
+		t.observeRestore(n, time.Since(start))
+		t.store.RecordRestored("", int(n))
+		slog.Info("tiered: restored KV from disk",
+			"seq", seq, "begin", begin, "end", end, "restored", n)
+	}
+	return begin, end
+}
+
+// logRestoreStop logs why a restore stopped at pos: at warning level if
+// the store failed, as opposed to the rows simply not being stored.
+func logRestoreStop(seq int, pos int32, err error) {
+	if errors.Is(err, diskstore.ErrCorrupted) || errors.Is(err, diskstore.ErrTierUnavailable) {
+		slog.Warn("tiered: restore stopped", "seq", seq, "pos", pos, "error", err)
+		return
+	}
+	slog.Debug("tiered: restore stopped", "seq", seq, "pos", pos, "error", err)
+}
+
+// restoreSmoothing weighs the newest restore in the per-position cost
+// estimate.
+const restoreSmoothing = 0.3
+
+// observeRestore folds a restore of n positions that took d into the
+// per-position cost estimate.
+func (t *TieredCausal) observeRestore(n int32, d time.Duration) {
+	per := d / time.Duration(n)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.restoreCost == 0 {
+		t.restoreCost = per
+	} else {
+		t.restoreCost = time.Duration(restoreSmoothing*float64(per) + (1-restoreSmoothing)*float64(t.restoreCost))
+	}
+}
+
+// RestoreCost returns the measured time to restore one position, or 0
+// before the first restore.
+func (t *TieredCausal) RestoreCost() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.restoreCost
+}
+
+// positionsWithin returns how many positions can be restored within
+// budget, and false when that is unbounded (no budget or no estimate).
+func (t *TieredCausal) positionsWithin(budget time.Duration) (int32, bool) {
+	cost := t.RestoreCost()
+	if budget <= 0 || cost <= 0 {
+		return 0, false
+	}
+	return int32(min(int64(budget/cost), math.MaxInt32)), true
+}
+
+// freeCells picks up to n free cells, in increasing order. It prefers the
+// smallest run of free cells that fits all n, leaving larger runs for
+// later; if none does, it takes the longest runs first to keep the
+// restore in as few pieces as possible.
+func freeCells(table CellTable, n int) []int {
+	if n <= 0 {
+		return nil
+	}
+	type run struct{ start, length int }
+	var runs []run
+	for i := 0; i < table.NumCells(); {
+		if _, seqs := table.Cell(i); len(seqs) > 0 {
+			i++
+			continue
+		}
+		j := i + 1
+		for j < table.NumCells() {
+			if _, seqs := table.Cell(j); len(seqs) > 0 {
+				break
+			}
+			j++
+		}
+		runs = append(runs, run{i, j - i})
+		i = j
+	}
+
+	best := -1
+	for k, r := range runs {
+		if r.length >= n && (best < 0 || r.length < runs[best].length) {
+			best = k
+		}
+	}
+	if best >= 0 {
+		runs = []run{{runs[best].start, n}}
+	} else {
+		sort.SliceStable(runs, func(a, b int) bool { return runs[a].length > runs[b].length })
+	}
+
+	var cells []int
+	for _, r := range runs {
+		for c := r.start; c < r.start+r.length && len(cells) < n; c++ {
+			cells = append(cells, c)
+		}
+	}
+	sort.Ints(cells)
+	return cells
+}
+
+// restoreRow writes every layer's K and V row for pos into cell and
+// returns how far the keys must still be shifted (see Shifter).
+func (t *TieredCausal) restoreRow(rows *rowReader, seq int, pos int32, cell int) (int32, error) {
+	storeKeys, storeValues := t.cfg.Snapshot.StoresKeys(), t.cfg.Snapshot.StoresValues()
+	var delta int32
+	first := true
+	for layer := 0; layer < t.backend.NumLayers(); layer++ {
+		k, v := t.backend.Keys(layer), t.backend.Values(layer)
+		if k == nil {
+			continue
+		}
+		if !t.cfg.Layers.Stores(layer) {
+			if err := t.recomputeLayer(seq, layer, pos, cell, k, v); err != nil {
+				return 0, err
+			}
+			continue
+		}
+
+		// Restore the stored halves.
+		var kBytes, vBytes []byte
+		if storeKeys {
+			var shift int32
+			if kBytes, shift = rows.row(seq, layer, true, pos); len(kBytes) != k.RowSize() {
+				return 0, rows.missing(layer, true)
+			}
+			if d := shift - rows.offset; first {
+				delta, first = d, false
+			} else if d != delta {
+				return 0, fmt.Errorf("kvcache: layer %d key row stored at shift %d, layer 0 at %d", layer, shift, delta+rows.offset)
+			}
+			if err := k.WriteRow(cell, kBytes); err != nil {
+				return 0, err
+			}
+		}
+		if storeValues && v != nil {
+			if vBytes, _ = rows.row(seq, layer, false, pos); len(vBytes) != v.RowSize() {
+				return 0, rows.missing(layer, false)
+			}
+			if err := v.WriteRow(cell, vBytes); err != nil {
+				return 0, err
+			}
+		}
+
+		// Rebuild the half that was not snapshot.
+		var dst TensorAccessor
+		var have []byte
+		switch {
+		case !storeKeys:
+			dst, have = k, vBytes
+		case !storeValues && v != nil:
+			dst, have = v, kBytes
+		default:
+			continue
+		}
+		row := make([]byte, dst.RowSize())
+		if err := t.cfg.Recompute.Recompute(seq, layer, pos, have, row, !storeKeys); err != nil {
+			return 0, err
+		}
+		if err := dst.WriteRow(cell, row); err != nil {
+			return 0, err
+		}
+	}
+	if _, ok := t.backend.(Shifter); delta != 0 && !ok {
+		return 0, fmt.Errorf("kvcache: keys stored %d positions off and the backend cannot shift them", delta)
+	}
+	return delta, nil
+}
+
+// recomputeLayer rebuilds layer's rows for pos in cell through the
+// LayerRecomputer.
+func (t *TieredCausal) recomputeLayer(seq, layer int, pos int32, cell int, k, v TensorAccessor) error {
+	kRow := make([]byte, k.RowSize())
+	var vRow []byte
+	if v != nil {
+		vRow = make([]byte, v.RowSize())
+	}
+	if err := t.cfg.RecomputeLayers.RecomputeLayer(seq, layer, pos, kRow, vRow); err != nil {
+		return err
+	}
+	if err := k.WriteRow(cell, kRow); err != nil {
+		return err
+	}
+	if v != nil {
+		return v.WriteRow(cell, vRow)
+	}
+	return nil
+}
+
+// missing returns the error for a row of layer's half that row didn't
+// find, wrapping the error that stopped its chunk's read if one did.
+func (r *rowReader) missing(layer int, isKey bool) error {
+	kv := "value"
+	if isKey {
+		kv = "key"
+	}
+	if err := r.failed[diskstore.BlockKey{Layer: layer, IsKey: isKey}]; err != nil {
+		return fmt.Errorf("kvcache: layer %d %s row: %w", layer, kv, err)
+	}
+	return fmt.Errorf("kvcache: layer %d %s row missing or of the wrong size", layer, kv)
+}
+
+// rowReader serves single-position rows out of chunks read with
+// diskstore.ReadRanges, which splits or merges stored blocks as needed, so
+// data snapshot with a different block size restores the same way and
+// each stored block is decoded about once per restore. A row of one
+// stored half loads the chunks of every stored half at that position in
+// one batch, as restoring the position will need them all.
+type rowReader struct {
+	ctx    context.Context
+	store  *diskstore.Store
+	chunk  int32
+	cached map[diskstore.BlockKey]rowChunk // keyed by layer and half only
+	failed map[diskstore.BlockKey]error    // the last read error, likewise
+	halves []diskstore.BlockKey            // the stored layers and halves
+
+	// The sequence's shift: position pos is read at pos+offset.
+	offset int32
+
+	// Prefix hashes of the prompt's whole blocks, tried before the
+	// sequence's own blocks.
+	hashes []string
+}
+
+// rowChunk is a run of rows stored at one shift; begin and end are
+// absolute positions.
+type rowChunk struct {
+	data       []byte
+	begin, end int32
+	shift      int32
+}
+
+// has reports whether row finds a row for pos. Unlike row it reads only
+// the one half.
+func (r *rowReader) has(seq, layer int, isKey bool, pos int32) bool {
+	data, _ := r.read(seq, layer, isKey, pos, nil)
+	return data != nil
+}
+
+// row returns the row stored for seq's position pos and the shift it was
+// stored at, or nil if none is.
+func (r *rowReader) row(seq, layer int, isKey bool, pos int32) ([]byte, int32) {
+	return r.read(seq, layer, isKey, pos, r.halves)
+}
+
+// read is row, loading the chunks at pos of the halves in batch that
+// don't have it cached along with its own.
+func (r *rowReader) read(seq, layer int, isKey bool, pos int32, batch []diskstore.BlockKey) ([]byte, int32) {
+	pos += r.offset
+	ck := diskstore.BlockKey{Layer: layer, IsKey: isKey}
+	c, ok := r.cached[ck]
+	if !ok || pos < c.begin || pos >= c.end {
+		load := []diskstore.BlockKey{ck}
+		for _, h := range batch {
+			if c, ok := r.cached[h]; h != ck && (!ok || pos < c.begin || pos >= c.end) {
+				load = append(load, h)
+			}
+		}
+		r.load(seq, pos, load)
+		if c, ok = r.cached[ck]; !ok || pos < c.begin || pos >= c.end {
+			return nil, 0
+		}
+	}
+	rowSize := len(c.data) / int(c.end-c.begin)
+	off := int(pos-c.begin) * rowSize
+	return c.data[off : off+rowSize], c.shift
+}
+
+// load caches the chunks of halves starting at the absolute position pos,
+// by prefix where the prompt has a hash for pos and else, or if the
+// prefix has none, by sequence.
+func (r *rowReader) load(seq int, pos int32, halves []diskstore.BlockKey) {
+	keys := make([]diskstore.BlockKey, len(halves))
+	for i, h := range halves {
+		keys[i] = diskstore.BlockKey{Seq: seq, Layer: h.Layer, BeginPos: pos, EndPos: pos + r.chunk, IsKey: h.IsKey}
+		if b := pos / r.chunk; b < int32(len(r.hashes)) {
+			keys[i] = diskstore.PrefixKey("", r.hashes[b], h.Layer, pos, (b+1)*r.chunk, h.IsKey)
+		}
+	}
+	res := r.store.ReadRangesContext(r.ctx, keys)
+
+	var retry []int
+	for i, rr := range res {
+		if (rr.Err != nil || rr.End <= pos) && keys[i].Prefix != "" {
+			keys[i].Seq, keys[i].Prefix = seq, ""
+			retry = append(retry, i)
+		}
+	}
+	if len(retry) > 0 {
+		rkeys := make([]diskstore.BlockKey, len(retry))
+		for j, i := range retry {
+			rkeys[j] = keys[i]
+		}
+		for j, rr := range r.store.ReadRangesContext(r.ctx, rkeys) {
+			res[retry[j]] = rr
+		}
+	}
+
+	for i, rr := range res {
+		if rr.Err != nil {
+			r.failed[halves[i]] = rr.Err
+		} else {
+			delete(r.failed, halves[i])
+		}
+		if rr.Err != nil || rr.End <= pos {
+			continue
+		}
+		c := rowChunk{data: rr.Data, begin: pos, end: rr.End}
+
+		// Keep the chunk to rows of the first block's shift.
+		rk := keys[i]
+		rk.EndPos = rr.End
+		if spans := r.store.Coverage(rk); len(spans) > 0 {
+			c.shift = spans[0].Shift
+			if spans[0].End < rr.End {
+				rowSize := len(rr.Data) / int(rr.End-pos)
+				c.end = spans[0].End
+				c.data = rr.Data[:int(c.end-pos)*rowSize]
+			}
+		}
+		r.cached[halves[i]] = c
+	}
+}
@@ -1,26 +1,29 @@
-// Package kvcache provides a drop-in replacement for Ollama's kvcache.Causal
-// that adds transparent disk-backed tiering.
+// Package kvcache adds transparent disk-backed tiering to Ollama's KV
+// cache.
 //
-// TieredCausal wraps the standard Causal cache. When tokens are evicted
-// (via Remove or ShiftCacheSlot), the raw K/V tensor bytes for the evicted
-// positions are snapshot to disk using diskstore. When a sequence resumes
-// and Ollama detects a common prefix, TieredCausal checks whether the
-// matching prefix extends further on disk and restores the data, avoiding
-// recomputation.
+// TieredCausal wraps a cache through the small Backend interface. When
+// positions are evicted (via Remove, e.g. on a context shift), their raw
+// K/V rows are snapshot to a diskstore.Store before the cells are freed.
+// When a sequence resumes and the in-memory prefix match stops short,
+// RestoreRange loads the continuation from disk into free cells instead of
+// recomputing it.
 //
 // INTEGRATION POINT:
 //
-// In a forked Ollama, replace the cache creation in model implementations
-// (e.g. model/models/llama/model.go) to return a TieredCausal instead of
-// a plain Causal. The patch is minimal — roughly 10 lines changed.
-//
-// This file documents the exact interface contract and the modifications
-// required to Ollama's source.
+// The patch in patches/ adds a thin adapter to Ollama's kvcache package
+// that implements Backend for *kvcache.Causal, and wires TieredCausal into
+// runner/ollamarunner/cache.go. All tiering logic lives here, where it is
+// compiled and tested without an Ollama checkout.
 package kvcache
 
 import (
 	"fmt"
+	"iter"
 	"log/slog"
+	"math"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
 )
@@ -32,234 +35,517 @@ type TieredConfig struct {
 
 	// BlockSize is the number of token positions per block when
 	// snapshotting to disk. Smaller blocks = finer granularity but
-	// more I/O operations. 256 is a good default.
+	// more I/O operations. 256 is a good default. It may change between
+	// runs: restores read through diskstore.Store.ReadRange, which splits
+	// and merges blocks of any size.
 	BlockSize int32
 
 	// Enable controls whether tiering is active. When false, the cache
 	// behaves identically to upstream Causal.
 	Enable bool
+
+	// Snapshot selects which half of the KV pair is written to disk.
+	// SnapshotKeys and SnapshotValues halve disk traffic for setups that
+	// can rebuild the other half; restoring then requires Recompute.
+	Snapshot SnapshotMode
+
+	// Recompute rebuilds the half of a row that was not snapshot. It is
+	// only consulted when Snapshot is not SnapshotBoth.
+	Recompute Recomputer
+
+	// Layers selects the layers that are snapshot; the zero value stores
+	// all of them. RecomputeLayers rebuilds the others on restore, which
+	// without it does not happen.
+	Layers          LayerPolicy
+	RecomputeLayers LayerRecomputer
+
+	// Addressing selects how blocks are keyed. AddressByPrefix keys full
+	// blocks by diskstore.PrefixHashes of the prompt, so a new sequence
+	// with the same system prompt or RAG context restores them from any
+	// slot.
+	Addressing AddressMode
+
+	// RestoreBudget caps the time RestoreRecent spends restoring; when
+	// the measured restore speed says a restore won't fit, only the
+	// newest positions are restored. Zero means no budget.
+	RestoreBudget time.Duration
+
+	// Adaptive restores only when reading the positions is expected to
+	// beat recomputing them, from the read speed the store measures per
+	// tier and the prefill speed reported through ObservePrefill (see
+	// Decide). PrefillRate seeds that speed in positions per second
+	// until the first report; zero means unknown.
+	Adaptive    bool
+	PrefillRate float64
+
+	// MinRestoreRun is the fewest contiguous positions worth restoring;
+	// shorter runs are left to be recomputed, which costs less than the
+	// reads for a few scattered tokens. Zero restores runs of any length.
+	MinRestoreRun int32
+
+	// Audit stores the tokens of snapshot positions and checks restores
+	// against the prompt (see audit.go). OnAudit, if set, receives every
+	// mismatch found besides the log.
+	Audit   AuditMode
+	OnAudit func(AuditRecord)
+
+	// MaxPressure is the store's diskstore.Pressure level from which
+	// Remove frees evicted positions without snapshotting them, in
+	// (0, 1] (see pressure.go). The level is the highest of the store's
+	// signals, so any one of them reaching MaxPressure is enough. Zero
+	// always snapshots.
+	MaxPressure float64
 }
 
-// ──────────────────────────────────────────────────────────────────────────
-// Below is the integration documentation. We cannot import Ollama's ml
-// or kvcache packages directly (they are internal to Ollama's module).
-// Instead, this package provides:
-//
-// 1. The diskstore package — standalone, no Ollama deps.
-// 2. This file — documents the exact code changes needed inside Ollama.
-// 3. Patch files (in patches/) that apply the changes mechanically.
-// ──────────────────────────────────────────────────────────────────────────
+// AddressMode selects how snapshot blocks are keyed on disk.
+type AddressMode int
 
-// The following Go code is meant to be added TO OLLAMA'S kvcache package.
-// It is written here as documentation and verified against Ollama v0.16.1.
-//
-// === kvcache/tiered.go (add to ollama/kvcache/) ===
-//
-// TieredCausal wraps a *Causal with disk-backed eviction.
-//
-//	type TieredCausal struct {
-//		*Causal
-//		store     *diskstore.Store
-//		blockSize int32
-//		enabled   bool
-//	}
-//
-//	func NewTieredCausal(causal *Causal, store *diskstore.Store, blockSize int32) *TieredCausal {
-//		return &TieredCausal{
-//			Causal:    causal,
-//			store:     store,
-//			blockSize: blockSize,
-//			enabled:   true,
-//		}
-//	}
-//
-// Override Remove to snapshot evicted KV data before freeing cells:
-//
-//	func (t *TieredCausal) Remove(seq int, beginIndex, endIndex int32) error {
-//		if t.enabled && endIndex != math.MaxInt32 {
-//			t.snapshotRange(seq, beginIndex, endIndex)
-//		}
-//		return t.Causal.Remove(seq, beginIndex, endIndex)
-//	}
-//
-// snapshotRange extracts K/V tensor bytes for the given position range
-// and writes them to the disk store:
-//
-//	func (t *TieredCausal) snapshotRange(seq int, beginPos, endPos int32) {
-//		for layer, key := range t.Causal.keys {
-//			if key == nil { continue }
-//
-//			// Extract the raw bytes for the cell range.
-//			// The tensor shape is [headDim, numKVHeads, cacheSize].
-//			// Each row (one token position) is stride(2) bytes.
-//			rowSize := key.Stride(2)
-//
-//			// Find which cells hold the positions being evicted.
-//			for i, cell := range t.Causal.cells {
-//				if !slices.Contains(cell.sequences, seq) { continue }
-//				if cell.pos < beginPos || cell.pos >= endPos { continue }
-//
-//				// Read the key row bytes.
-//				offset := rowSize * i
-//				keyBytes := key.Bytes()[offset : offset+rowSize]
-//				bk := diskstore.BlockKey{
-//					Seq: seq, Layer: layer,
-//					BeginPos: cell.pos, EndPos: cell.pos + 1,
-//					IsKey: true,
-//				}
-//				t.store.Put(bk, t.DType.String(), key.Shape(), keyBytes)
-//
-//				// Read the value row bytes.
-//				val := t.Causal.values[layer]
-//				if val != nil {
-//					valRowSize := val.Stride(2)
-//					valOffset := valRowSize * i
-//					valBytes := val.Bytes()[valOffset : valOffset+valRowSize]
-//					bv := diskstore.BlockKey{
-//						Seq: seq, Layer: layer,
-//						BeginPos: cell.pos, EndPos: cell.pos + 1,
-//						IsKey: false,
-//					}
-//					t.store.Put(bv, t.DType.String(), val.Shape(), valBytes)
-//				}
-//			}
-//		}
-//		slog.Debug("tiered: snapshot evicted KV",
-//			"seq", seq, "begin", beginPos, "end", endPos)
-//	}
-//
-// RestoreRange loads KV data from disk back into the cache's tensors,
-// for use when extending a prefix match beyond what's in memory:
-//
-//	func (t *TieredCausal) RestoreRange(ctx ml.Context, seq int, beginPos, endPos int32) (int32, error) {
-//		var restored int32
-//		for layer, key := range t.Causal.keys {
-//			if key == nil { continue }
-//			rowSize := key.Stride(2)
-//
-//			for pos := beginPos; pos < endPos; pos++ {
-//				bk := diskstore.BlockKey{
-//					Seq: seq, Layer: layer,
-//					BeginPos: pos, EndPos: pos + 1,
-//					IsKey: true,
-//				}
-//				kData, _, err := t.store.Get(bk)
-//				if err != nil || kData == nil { continue }
-//
-//				// Find a free cell and write the data.
-//				cellIdx := t.findFreeCell()
-//				if cellIdx < 0 { break }
-//
-//				// Write key bytes into the tensor at cellIdx.
-//				offset := rowSize * cellIdx
-//				copy(key.Bytes()[offset:offset+rowSize], kData)
-//
-//				// Write value bytes.
-//				bv := bk
-//				bv.IsKey = false
-//				vData, _, _ := t.store.Get(bv)
-//				if vData != nil {
-//					val := t.Causal.values[layer]
-//					valRowSize := val.Stride(2)
-//					valOffset := valRowSize * cellIdx
-//					copy(val.Bytes()[valOffset:valOffset+valRowSize], vData)
-//				}
-//
-//				// Update cell metadata.
-//				t.Causal.cells[cellIdx] = cacheCell{pos: pos, sequences: []int{seq}}
-//				if layer == 0 { restored++ }
-//			}
-//		}
-//		return restored, nil
-//	}
-
-// PrintIntegrationGuide prints step-by-step instructions for applying
-// the tiered cache to an Ollama checkout.
-func PrintIntegrationGuide() {
-	guide := `
-=== Ollama KV Cache Tiering — Integration Guide ===
-
-This project adds transparent disk-backed KV cache tiering to Ollama.
-When the context window fills up and Ollama evicts old tokens, the raw
-K/V tensor data is saved to disk (SSD → NFS) instead of being discarded.
-On subsequent requests with matching prefixes, the data is restored from
-disk, skipping recomputation.
-
-PREREQUISITES:
-  - Go 1.23+
-  - Ollama source (v0.16.x): git clone https://github.com/ollama/ollama
-  - gcc/cmake for CGO (Ollama's standard build deps)
-
-STEPS:
-
-1. Clone Ollama and this project side by side:
-
-     git clone https://github.com/ollama/ollama.git
-     git clone https://github.com/databloom/ollama-kv-cache-tiering.git
-
-2. Copy the diskstore package into Ollama's vendor tree:
-
-     cp -r ollama-kv-cache-tiering/diskstore ollama/diskstore
-
-3. Apply the patch to Ollama's kvcache and runner:
-
-     cd ollama
-     git apply ../ollama-kv-cache-tiering/patches/ollama-tiered-kvcache.patch
-
-   This patch:
-     a) Adds kvcache/tiered.go (TieredCausal wrapper)
-     b) Modifies runner/ollamarunner/cache.go:
-        - ShiftCacheSlot calls TieredCausal.Remove (snapshots before evicting)
-        - LoadCacheSlot checks disk store for extended prefix matches
-     c) Adds environment variables:
-        - OLLAMA_KV_TIERING=1          (enable tiering)
-        - OLLAMA_KV_TIER_LOCAL=/path    (SSD cache dir)
-        - OLLAMA_KV_TIER_REMOTE=/path   (NFS cache dir, optional)
-        - OLLAMA_KV_TIER_LOCAL_GB=20    (local budget in GB)
-        - OLLAMA_KV_TIER_REMOTE_GB=5000 (remote budget in GB)
-        - OLLAMA_KV_TIER_COMPRESS=1     (enable zstd compression)
-
-4. Build Ollama:
-
-     go generate ./...
-     go build .
-
-5. Run with tiering enabled:
-
-     OLLAMA_KV_TIERING=1 \
-     OLLAMA_KV_TIER_LOCAL=/tmp/kv-cache \
-     OLLAMA_KV_TIER_REMOTE=/mnt/kv-cache \
-     OLLAMA_KV_TIER_LOCAL_GB=20 \
-     OLLAMA_KV_TIER_REMOTE_GB=5000 \
-     OLLAMA_KV_TIER_COMPRESS=1 \
-     ./ollama serve
-
-HOW IT WORKS:
-
-  Normal Ollama flow:
-    1. Prompt arrives → tokenize → fill KV cache → generate
-    2. Context full → ShiftCacheSlot → Remove(oldest half) → GONE
-    3. New prompt → recompute from scratch if prefix doesn't match
-
-  With tiering:
-    1. Prompt arrives → tokenize → fill KV cache → generate
-    2. Context full → ShiftCacheSlot → snapshot K/V bytes to SSD → Remove
-    3. SSD full → oldest blocks migrate to NFS (background)
-    4. New prompt → check disk for matching prefix → restore K/V from disk
-    5. Only recompute tokens not found on disk
-
-  Benefits:
-    - Context shifts are near-instant (restore from SSD: ~2ms per 256 tokens)
-    - Long conversations retain KV state across context windows
-    - System prompts cached persistently (never recomputed)
-    - NFS tier provides TB-scale cold storage for rarely-used contexts
-
-TARGET HARDWARE:
-
-  Molly  (2x GTX 1070, 16GB):  SSD local + 5.8TB NFS from Wintermute
-  Wintermute (2x M6000, 48GB): NVMe local + 12TB HDD
-`
-	fmt.Println(guide)
+const (
+	AddressBySeq    AddressMode = iota // slot and positions (default)
+	AddressByPrefix                    // hash of the tokens up to the block's end
+)
+
+func (m AddressMode) String() string {
+	switch m {
+	case AddressBySeq:
+		return "seq"
+	case AddressByPrefix:
+		return "prefix"
+	}
+	return fmt.Sprintf("AddressMode(%d)", int(m))
+}
+
+// ParseAddressMode parses "seq" or "prefix". An empty string means
+// AddressBySeq.
+func ParseAddressMode(s string) (AddressMode, error) {
+	switch s {
+	case "", "seq":
+		return AddressBySeq, nil
+	case "prefix":
+		return AddressByPrefix, nil
+	}
+	return 0, fmt.Errorf("kvcache: unknown addressing mode %q", s)
+}
+
+// SnapshotMode selects the halves of the KV cache that are tiered.
+type SnapshotMode int
+
+const (
+	SnapshotBoth   SnapshotMode = iota // keys and values (default)
+	SnapshotKeys                       // keys only; values are recomputed
+	SnapshotValues                     // values only; keys are recomputed
+)
+
+func (m SnapshotMode) String() string {
+	switch m {
+	case SnapshotBoth:
+		return "both"
+	case SnapshotKeys:
+		return "keys"
+	case SnapshotValues:
+		return "values"
+	}
+	return fmt.Sprintf("SnapshotMode(%d)", int(m))
+}
+
+// ParseSnapshotMode parses "both", "keys" or "values". An empty string
+// means SnapshotBoth.
+func ParseSnapshotMode(s string) (SnapshotMode, error) {
+	switch s {
+	case "", "both":
+		return SnapshotBoth, nil
+	case "keys", "k":
+		return SnapshotKeys, nil
+	case "values", "v":
+		return SnapshotValues, nil
+	}
+	return 0, fmt.Errorf("kvcache: unknown snapshot mode %q", s)
+}
+
+// StoresKeys reports whether key rows are written to disk.
+func (m SnapshotMode) StoresKeys() bool { return m != SnapshotValues }
+
+// StoresValues reports whether value rows are written to disk.
+func (m SnapshotMode) StoresValues() bool { return m != SnapshotKeys }
+
+// Recomputer rebuilds the missing half of a restored KV row. have holds
+// the stored half for (seq, layer, pos) and dst is the row of the tensor
+// being restored into; isKey reports which half dst is. Implementations
+// typically rerun that layer's K or V projection from saved hidden
+// states, or derive one side analytically in research setups.
+type Recomputer interface {
+	Recompute(seq, layer int, pos int32, have []byte, dst []byte, isKey bool) error
+}
+
+// Validate reports configurations that cannot restore what they store.
+func (c TieredConfig) Validate() error {
+	if err := c.check(); err != nil {
+		return err
+	}
+	if c.Snapshot != SnapshotBoth && c.Recompute == nil {
+		return fmt.Errorf("kvcache: snapshot mode %q needs a Recomputer to restore", c.Snapshot)
+	}
+	if !c.Layers.All() && c.RecomputeLayers == nil {
+		return fmt.Errorf("kvcache: layer policy %q needs a LayerRecomputer to restore", c.Layers)
+	}
+	return nil
+}
+
+// check reports configurations TieredCausal cannot run with. Unlike
+// Validate it allows half snapshots without a Recomputer, which store
+// data for measurement but never restore it.
+func (c TieredConfig) check() error {
+	if c.BlockSize <= 0 {
+		return fmt.Errorf("kvcache: block size must be positive, got %d", c.BlockSize)
+	}
+	if c.Snapshot < SnapshotBoth || c.Snapshot > SnapshotValues {
+		return fmt.Errorf("kvcache: invalid snapshot mode %d", int(c.Snapshot))
+	}
+	if c.Addressing < AddressBySeq || c.Addressing > AddressByPrefix {
+		return fmt.Errorf("kvcache: invalid addressing mode %d", int(c.Addressing))
+	}
+	if c.Layers.From < 0 || c.Layers.Every < 0 {
+		return fmt.Errorf("kvcache: invalid layer policy %+v", c.Layers)
+	}
+	if c.Audit < AuditOff || c.Audit > AuditStrict {
+		return fmt.Errorf("kvcache: invalid audit mode %d", int(c.Audit))
+	}
+	if c.MinRestoreRun < 0 {
+		return fmt.Errorf("kvcache: minimum restore run must not be negative, got %d", c.MinRestoreRun)
+	}
+	if c.PrefillRate < 0 {
+		return fmt.Errorf("kvcache: prefill rate must not be negative, got %v", c.PrefillRate)
+	}
+	if c.MaxPressure < 0 || c.MaxPressure > 1 {
+		return fmt.Errorf("kvcache: maximum pressure must be in [0, 1], got %v", c.MaxPressure)
+	}
+	return nil
+}
+
+// TieredCausal adds disk tiering to the cache behind a Backend.
+type TieredCausal struct {
+	backend Backend
+	store   *diskstore.Store
+	cfg     TieredConfig
+
+	// Sliding window of the backend in positions, or 0 (see Windowed).
+	window int32
+
+	// mu guards the fields below, which the prefill service reads from
+	// its own goroutine.
+	mu sync.Mutex
+
+	// Prefix hashes of each sequence's prompt, for AddressByPrefix.
+	prompts map[int][]string
+
+	// How far each sequence's positions were shifted down since its
+	// prompt was loaded (see Shifter).
+	shifts map[int]int32
+
+	// Tokens at each sequence's positions, and sequences whose stored
+	// tokens did not match their prompt, for TieredConfig.Audit.
+	tokens      map[int][]int32
+	auditFailed map[int]bool
+
+	// Disaggregated prefill: publish prompts for decode nodes, or pull
+	// them from a prefill node.
+	publish     bool
+	prefillPeer *diskstore.RemoteClient
+	prefillWait time.Duration
+
+	// Measured time to restore one position (see observeRestore).
+	restoreCost time.Duration
+
+	// Prefill speed in positions per second, and restores Decide turned
+	// down (see decide.go).
+	prefillRate float64
+	recomputed  int
+
+	// Evicted positions not snapshot under store pressure (see
+	// pressure.go).
+	skipped int64
+
+	// Tokens of each stored checkpoint by checkpoint sequence, read from
+	// the store once (see checkpoint.go).
+	checkpoints    map[int][]int32
+	checkpointLoad sync.Once
+}
+
+// NewTieredCausal tiers backend into cfg.DiskStore.
+func NewTieredCausal(backend Backend, cfg TieredConfig) (*TieredCausal, error) {
+	if err := cfg.check(); err != nil {
+		return nil, err
+	}
+	if cfg.DiskStore == nil {
+		return nil, fmt.Errorf("kvcache: TieredConfig.DiskStore is required")
+	}
+	t := &TieredCausal{
+		backend: backend,
+		store:   cfg.DiskStore,
+		cfg:     cfg,
+		prompts: make(map[int][]string),
+		shifts:  make(map[int]int32),
+
+		tokens:      make(map[int][]int32),
+		auditFailed: make(map[int]bool),
+
+		prefillRate: cfg.PrefillRate,
+	}
+	if w, ok := backend.(Windowed); ok {
+		if n := w.SlidingWindow(); n > 0 && n < math.MaxInt32 {
+			t.window = n
+		}
+	}
+	return t, nil
+}
+
+// Config returns the configuration t was created with.
+func (t *TieredCausal) Config() TieredConfig {
+	return t.cfg
+}
+
+// Remove snapshots positions [beginPos, endPos) of seq to disk and then
+// frees them in the backend. endPos == math.MaxInt32 clears the whole
+// sequence (e.g. on error recovery) and is not snapshot, and neither is
+// a range evicted while the store is under pressure (see pressure.go).
+// With a Shifter backend any other endPos is a context shift, which
+// Shift accumulates.
+func (t *TieredCausal) Remove(seq int, beginPos, endPos int32) error {
+	if t.cfg.Enable && endPos != math.MaxInt32 && !t.underPressure(seq, beginPos, endPos) {
+		t.snapshotRange(seq, beginPos, endPos)
+	}
+	if err := t.backend.Remove(seq, beginPos, endPos); err != nil {
+		return err
+	}
+	_, ok := t.backend.(Shifter)
+	shifted := ok && endPos != math.MaxInt32
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if shifted {
+		t.shifts[seq] += endPos - beginPos
+	}
+	// Keep the recorded tokens at the positions they now sit at.
+	if tokens := t.tokens[seq]; int(beginPos) < len(tokens) {
+		switch {
+		case endPos == math.MaxInt32:
+			t.tokens[seq] = tokens[:max(beginPos, 0)]
+		case shifted:
+			t.tokens[seq] = append(tokens[:max(beginPos, 0)], tokens[min(int(endPos), len(tokens)):]...)
+		}
+	}
+	return nil
+}
+
+// CopyPrefix follows the backend's CopyPrefix(srcSeq, dstSeq, length),
+// which the caller has already done: dstSeq's stored blocks are replaced
+// by srcSeq's up to position length (diskstore.Store.CopySeq, which
+// shares their files), and dstSeq takes over srcSeq's shift and tokens.
+func (t *TieredCausal) CopyPrefix(srcSeq, dstSeq int, length int32) error {
+	if srcSeq == dstSeq {
+		return nil
+	}
+	t.copyState(srcSeq, dstSeq, length)
+	if _, err := t.store.CopySeq(srcSeq, dstSeq, length+t.Shift(dstSeq)); err != nil {
+		return fmt.Errorf("kvcache: copy seq %d to %d: %w", srcSeq, dstSeq, err)
+	}
+	return nil
+}
+
+// copyState gives dstSeq srcSeq's shift, prompt and tokens below length.
+func (t *TieredCausal) copyState(srcSeq, dstSeq int, length int32) {
+	length = max(length, 0)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.auditFailed, dstSeq)
+	if shift, ok := t.shifts[srcSeq]; ok {
+		t.shifts[dstSeq] = shift
+	} else {
+		delete(t.shifts, dstSeq)
+	}
+	if hashes := t.prompts[srcSeq]; hashes != nil {
+		n := min(len(hashes), int(length/t.cfg.BlockSize))
+		t.prompts[dstSeq] = slices.Clone(hashes[:n])
+	} else {
+		delete(t.prompts, dstSeq)
+	}
+	if tokens := t.tokens[srcSeq]; tokens != nil {
+		t.tokens[dstSeq] = slices.Clone(tokens[:min(len(tokens), int(length))])
+	} else {
+		delete(t.tokens, dstSeq)
+	}
+}
+
+// Shift returns how far context shifts have moved seq's positions down
+// since its prompt was loaded (SetPrompt): the token at position pos is
+// at absolute position pos+Shift, which is where snapshots store it.
+func (t *TieredCausal) Shift(seq int) int32 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.shifts[seq]
+}
+
+// half is one stored tensor of a layer.
+type half struct {
+	tensor TensorAccessor
+	isKey  bool
+}
+
+// storedHalves returns the tensors of layer that snapshots write.
+func (t *TieredCausal) storedHalves(layer int) []half {
+	if !t.cfg.Layers.Stores(layer) {
+		return nil
+	}
+	var hs []half
+	if k := t.backend.Keys(layer); k != nil && t.cfg.Snapshot.StoresKeys() {
+		hs = append(hs, half{k, true})
+	}
+	if v := t.backend.Values(layer); v != nil && t.cfg.Snapshot.StoresValues() {
+		hs = append(hs, half{v, false})
+	}
+	return hs
+}
+
+// snapshotRange writes the rows of seq's positions in [beginPos, endPos)
+// to the store and returns how many positions it saved. Positions are
+// coalesced into runs within BlockSize-aligned blocks; with
+// AddressByPrefix, whole blocks of the prompt are keyed by prefix hash.
+// Blocks are keyed by absolute position, with the shift the rows were
+// computed at (see Shift).
+func (t *TieredCausal) snapshotRange(seq int, beginPos, endPos int32) int {
+	cells := t.cellsOf(seq, beginPos, endPos)
+	if len(cells) == 0 {
+		return 0
+	}
+	shift := t.Shift(seq)
+	if shift != 0 {
+		abs := make(map[int32]int, len(cells))
+		for pos, cell := range cells {
+			abs[pos+shift] = cell
+		}
+		cells, beginPos, endPos = abs, beginPos+shift, endPos+shift
+	}
+	lo, hi := endPos, beginPos
+	for pos := range cells {
+		lo, hi = min(lo, pos), max(hi, pos+1)
+	}
+
+	var hashes []string
+	if t.cfg.Addressing == AddressByPrefix {
+		t.mu.Lock()
+		hashes = t.prompts[seq]
+		t.mu.Unlock()
+	}
+
+	bs := t.cfg.BlockSize
+	var saved int
+	for run, runEnd := range t.runs(cells, lo, hi) {
+		key := diskstore.BlockKey{Seq: seq, BeginPos: run, EndPos: runEnd}
+		if b := run / bs; int(b) < len(hashes) && run == b*bs && runEnd == (b+1)*bs {
+			key = diskstore.PrefixKey("", hashes[b], 0, run, runEnd, false)
+		}
+		if t.putRun(key, cells, shift) {
+			saved += int(runEnd - run)
+			if t.cfg.Audit != AuditOff {
+				t.putManifest(seq, key, shift)
+			}
+		}
+	}
+
+	if saved > 0 {
+		slog.Debug("tiered: snapshot evicted KV",
+			"seq", seq, "begin", beginPos, "end", endPos, "positions", saved)
+	}
+	return saved
+}
+
+// runs yields the runs of consecutive positions in cells within [lo, hi)
+// as [begin, end), split at BlockSize-aligned block boundaries.
+func (t *TieredCausal) runs(cells map[int32]int, lo, hi int32) iter.Seq2[int32, int32] {
+	return func(yield func(int32, int32) bool) {
+		bs := t.cfg.BlockSize
+		for b := lo / bs; b*bs < hi; b++ {
+			blockEnd := min(hi, (b+1)*bs)
+			for run := max(lo, b*bs); run < blockEnd; {
+				if _, ok := cells[run]; !ok {
+					run++
+					continue
+				}
+				runEnd := run
+				for runEnd < blockEnd {
+					if _, ok := cells[runEnd]; !ok {
+						break
+					}
+					runEnd++
+				}
+				if !yield(run, runEnd) {
+					return
+				}
+				run = runEnd
+			}
+		}
+	}
+}
+
+// putRun stores the rows of key's positions for every layer and stored
+// half, taking Layer and IsKey from the loop. It reports whether
+// anything was written.
+func (t *TieredCausal) putRun(key diskstore.BlockKey, cells map[int32]int, shift int32) bool {
+	dtype := t.backend.DType()
+	var wrote bool
+	for layer := 0; layer < t.backend.NumLayers(); layer++ {
+		for _, h := range t.storedHalves(layer) {
+			rows := make([]byte, 0, int(key.EndPos-key.BeginPos)*h.tensor.RowSize())
+			var err error
+			for pos := key.BeginPos; pos < key.EndPos && err == nil; pos++ {
+				var row []byte
+				if row, err = h.tensor.ReadRow(cells[pos]); err == nil {
+					rows = append(rows, row...)
+				}
+			}
+			k := key
+			k.Layer, k.IsKey = layer, h.isKey
+			if err == nil {
+				err = t.store.PutAsyncShifted(k, dtype, h.tensor.Shape(), rows, shift)
+			}
+			if err != nil {
+				slog.Warn("tiered: failed to snapshot", "key", k, "error", err)
+				continue
+			}
+			wrote = true
+		}
+	}
+	return wrote
+}
+
+// cellsOf maps the positions of seq in [beginPos, endPos) to their cells.
+func (t *TieredCausal) cellsOf(seq int, beginPos, endPos int32) map[int32]int {
+	table := t.backend.Cells()
+	cells := make(map[int32]int)
+	for i := 0; i < table.NumCells(); i++ {
+		pos, seqs := table.Cell(i)
+		if pos < beginPos || pos >= endPos {
+			continue
+		}
+		for _, s := range seqs {
+			if s == seq {
+				cells[pos] = i
+				break
+			}
+		}
+	}
+	return cells
+}
+
+// DiskExpired reports, once, whether the disk cache for seq was garbage
+// collected since it was last used, so the next prompt pays full prefill.
+func (t *TieredCausal) DiskExpired(seq int) bool {
+	_, ok := t.store.TakeExpired(seq)
+	return ok
+}
+
+// DiskStats returns the disk store statistics.
+func (t *TieredCausal) DiskStats() diskstore.Stats {
+	return t.store.Stats()
 }
 
 // DefaultTieredConfig returns a sensible default configuration.
@@ -0,0 +1,898 @@
+package kvcache_test
+
+import (
+	"context"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+	"github.com/databloom/ollama-kv-cache-tiering/kvcache"
+	"github.com/databloom/ollama-kv-cache-tiering/kvcache/mock"
+)
+
+const testRowSize = 8
+
+func newTestStore(t testing.TB) *diskstore.Store {
+	t.Helper()
+	store, err := diskstore.New(diskstore.Config{
+		LocalPath:     filepath.Join(t.TempDir(), "local"),
+		LocalBudget:   1 << 30,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("diskstore.New: %v", err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+func newTiered(t testing.TB, b kvcache.Backend, cfg kvcache.TieredConfig) *kvcache.TieredCausal {
+	t.Helper()
+	tc, err := kvcache.NewTieredCausal(b, cfg)
+	if err != nil {
+		t.Fatalf("NewTieredCausal: %v", err)
+	}
+	return tc
+}
+
+// positions returns [begin, end).
+func positions(begin, end int32) []int32 {
+	var out []int32
+	for p := begin; p < end; p++ {
+		out = append(out, p)
+	}
+	return out
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+
+	b := mock.New(2, 16, testRowSize)
+	b.Fill(1, 0, 10)
+	tc := newTiered(t, b, cfg)
+	if err := tc.Remove(1, 0, 10); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	// Runs are coalesced per block: [0,4), [4,8), [8,10) for 2 layers
+	// and 2 halves.
+	if got := tc.DiskStats().LocalBlocks; got != 12 {
+		t.Errorf("stored %d blocks, want 12", got)
+	}
+	if got := b.Positions(1); len(got) != 0 {
+		t.Fatalf("positions %v still cached after Remove", got)
+	}
+
+	// A fresh cache (e.g. after a restart) restores what is on disk and
+	// stops at the end of it.
+	fresh := mock.New(2, 16, testRowSize)
+	n, err := newTiered(t, fresh, cfg).RestoreRange(1, 0, 12)
+	if err != nil || n != 10 {
+		t.Fatalf("RestoreRange = %d, %v; want 10", n, err)
+	}
+	if err := fresh.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+	if got := fresh.Positions(1); !slices.Equal(got, positions(0, 10)) {
+		t.Errorf("restored positions %v", got)
+	}
+
+	// Not enough free cells: restore what fits.
+	small := mock.New(2, 3, testRowSize)
+	if n, _ := newTiered(t, small, cfg).RestoreRange(1, 0, 10); n != 3 {
+		t.Errorf("RestoreRange into 3 cells = %d, want 3", n)
+	}
+}
+
+func TestSnapshotSkipsGapsAndSharedLayers(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 8, Enable: true}
+
+	// Layer 1 has no value tensor; seq 1 has positions 0-3 and 6-7.
+	b := mock.New(2, 16, testRowSize)
+	b.V[1] = nil
+	b.Fill(1, 0, 4)
+	b.Fill(1, 6, 8)
+	tc := newTiered(t, b, cfg)
+	tc.Remove(1, 0, 8)
+
+	// Two runs in one block, 3 tensors each.
+	if got := tc.DiskStats().LocalBlocks; got != 6 {
+		t.Errorf("stored %d blocks, want 6", got)
+	}
+	fresh := mock.New(2, 16, testRowSize)
+	fresh.V[1] = nil
+	if n, _ := newTiered(t, fresh, cfg).RestoreRange(1, 0, 8); n != 4 {
+		t.Errorf("RestoreRange across a gap = %d, want 4", n)
+	}
+	if err := fresh.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+// cellsOf returns the cells holding positions [0, n) of seq, in position
+// order, or -1 for missing ones.
+func cellsOf(b *mock.Backend, seq int, n int32) []int {
+	out := slices.Repeat([]int{-1}, int(n))
+	for i := 0; i < b.NumCells(); i++ {
+		pos, seqs := b.Cell(i)
+		if slices.Contains(seqs, seq) && pos < n {
+			out[pos] = i
+		}
+	}
+	return out
+}
+
+func TestRestoreContiguousCells(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+	src := mock.New(1, 8, testRowSize)
+	src.Fill(1, 0, 6)
+	newTiered(t, src, cfg).Remove(1, 0, 6)
+
+	// Free runs of 1, 3 and 10 cells: the restore takes the only run
+	// that fits it whole rather than the first free cells.
+	b := mock.New(1, 16, testRowSize)
+	b.Occupy(1, 9, 0)
+	b.Occupy(5, 9, 1)
+	if n, _ := newTiered(t, b, cfg).RestoreRange(1, 0, 6); n != 6 {
+		t.Fatalf("RestoreRange = %d, want 6", n)
+	}
+	if got, want := cellsOf(b, 1, 6), []int{6, 7, 8, 9, 10, 11}; !slices.Equal(got, want) {
+		t.Errorf("restored into cells %v, want %v", got, want)
+	}
+
+	// No run fits: the longest runs are used first, in cell order.
+	b = mock.New(1, 8, testRowSize)
+	b.Occupy(2, 9, 0)
+	b.Occupy(6, 9, 1)
+	b.Occupy(7, 9, 2)
+	if n, _ := newTiered(t, b, cfg).RestoreRange(1, 0, 6); n != 5 {
+		t.Fatalf("RestoreRange = %d, want 5", n)
+	}
+	if got, want := cellsOf(b, 1, 5), []int{0, 1, 3, 4, 5}; !slices.Equal(got, want) {
+		t.Errorf("restored into cells %v, want %v", got, want)
+	}
+	if err := b.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestoreRangeContext(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+	src := mock.New(2, 16, testRowSize)
+	src.Fill(1, 0, 10)
+	newTiered(t, src, cfg).Remove(1, 0, 10)
+
+	// A restore whose context is already done reads nothing, which is
+	// not an error: the caller recomputes.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	b := mock.New(2, 16, testRowSize)
+	tc := newTiered(t, b, cfg)
+	if n, err := tc.RestoreRangeContext(ctx, 1, 0, 10); err != nil || n != 0 {
+		t.Fatalf("RestoreRangeContext after cancel = %d, %v; want 0", n, err)
+	}
+	if got := b.Positions(1); len(got) != 0 {
+		t.Errorf("cancelled restore placed positions %v", got)
+	}
+	if n, err := tc.RestoreRangeContext(context.Background(), 1, 0, 10); err != nil || n != 10 {
+		t.Fatalf("RestoreRangeContext = %d, %v; want 10", n, err)
+	}
+	if err := b.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRestoreRecent(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, RestoreBudget: 10 * time.Millisecond}
+	src := mock.New(2, 16, testRowSize)
+	src.Fill(1, 0, 10)
+	newTiered(t, src, cfg).Remove(1, 0, 10)
+
+	// Without a measured speed the budget can't be applied.
+	b := mock.New(2, 16, testRowSize)
+	plan, err := newTiered(t, b, cfg).RestoreRecent(1, 0, 12)
+	if err != nil || plan != (kvcache.RestorePlan{From: 0, Begin: 0, End: 10}) {
+		t.Fatalf("RestoreRecent = %+v, %v; want all 10 positions", plan, err)
+	}
+
+	// 10ms at 4ms a position fits 2: the newest are restored.
+	b = mock.New(2, 16, testRowSize)
+	tc := newTiered(t, b, cfg)
+	tc.SetRestoreCost(4 * time.Millisecond)
+	plan, err = tc.RestoreRecent(1, 2, 12)
+	if err != nil || plan != (kvcache.RestorePlan{From: 2, Begin: 8, End: 10}) {
+		t.Fatalf("RestoreRecent = %+v, %v; want [8, 10) of [2, 10)", plan, err)
+	}
+	if plan.Restored() != 2 || plan.Skipped() != 6 {
+		t.Errorf("Restored, Skipped = %d, %d; want 2, 6", plan.Restored(), plan.Skipped())
+	}
+	if got := b.Positions(1); !slices.Equal(got, positions(8, 10)) {
+		t.Errorf("restored positions %v, want [8 9]", got)
+	}
+	if err := b.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+	if tc.RestoreCost() == 4*time.Millisecond {
+		t.Error("RestoreCost not updated by the restore")
+	}
+
+	// Too few free cells also keeps the newest positions.
+	b = mock.New(2, 3, testRowSize)
+	plan, _ = newTiered(t, b, cfg).RestoreRecent(1, 0, 10)
+	if plan != (kvcache.RestorePlan{From: 0, Begin: 7, End: 10}) {
+		t.Errorf("RestoreRecent into 3 cells = %+v, want [7, 10)", plan)
+	}
+}
+
+func TestMinRestoreRun(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, MinRestoreRun: 4}
+	src := mock.New(1, 16, testRowSize)
+	src.Fill(1, 0, 10)
+	tc := newTiered(t, src, cfg)
+	tc.Remove(1, 0, 3)
+	tc.Remove(1, 4, 10)
+
+	// [0, 3) stops at the gap at 3: too short to restore.
+	b := mock.New(1, 16, testRowSize)
+	if n, _ := newTiered(t, b, cfg).RestoreRange(1, 0, 10); n != 0 {
+		t.Errorf("RestoreRange of a 3-position run = %d, want 0", n)
+	}
+	if n, _ := newTiered(t, b, cfg).RestoreRange(1, 4, 10); n != 6 {
+		t.Errorf("RestoreRange of a 6-position run = %d, want 6", n)
+	}
+
+	// Free cells for only 3 positions: not worth it either.
+	if n, _ := newTiered(t, mock.New(1, 3, testRowSize), cfg).RestoreRange(1, 4, 10); n != 0 {
+		t.Errorf("RestoreRange into 3 cells = %d, want 0", n)
+	}
+
+	cfg.MinRestoreRun = -1
+	if _, err := kvcache.NewTieredCausal(b, cfg); err == nil {
+		t.Error("NewTieredCausal accepted a negative MinRestoreRun")
+	}
+}
+
+func TestRestoreHalfSnapshot(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, Snapshot: kvcache.SnapshotKeys}
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted keys-only snapshots without a Recomputer")
+	}
+
+	b := mock.New(2, 8, testRowSize)
+	b.Fill(1, 0, 4)
+	tc := newTiered(t, b, cfg)
+	tc.Remove(1, 0, 4)
+	if got := tc.DiskStats().LocalBlocks; got != 2 {
+		t.Errorf("stored %d blocks, want 2 (keys only)", got)
+	}
+
+	// Without a Recomputer half snapshots are never restored.
+	fresh := mock.New(2, 8, testRowSize)
+	if n, _ := newTiered(t, fresh, cfg).RestoreRange(1, 0, 4); n != 0 {
+		t.Errorf("RestoreRange without Recomputer = %d, want 0", n)
+	}
+
+	cfg.Recompute = mock.Recomputer{}
+	if n, err := newTiered(t, fresh, cfg).RestoreRange(1, 0, 4); n != 4 || err != nil {
+		t.Fatalf("RestoreRange = %d, %v; want 4", n, err)
+	}
+	if err := fresh.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestPrefixAddressedRestore(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, Addressing: kvcache.AddressByPrefix}
+
+	system := []int32{1, 887, 526, 263}
+	b := mock.New(2, 16, testRowSize)
+	b.Fill(1, 0, 8)
+	tc := newTiered(t, b, cfg)
+	tc.SetPrompt(1, append(system, 1724, 338, 278, 7483))
+	tc.Remove(1, 0, 8)
+
+	// Another slot with the same system prompt finds the first block.
+	fresh := mock.New(2, 16, testRowSize)
+	tc = newTiered(t, fresh, cfg)
+	tc.SetPrompt(2, append(system, 3750, 338, 278, 14744))
+	if got := tc.MatchPrompt(2); got != 4 {
+		t.Fatalf("MatchPrompt = %d, want 4", got)
+	}
+	n, err := tc.RestoreRange(2, 0, 8)
+	if err != nil || n != 4 {
+		t.Fatalf("RestoreRange = %d, %v; want 4", n, err)
+	}
+	// Slot 2 now holds what slot 1 computed.
+	if err := fresh.Check(2, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestNewTieredCausalConfig(t *testing.T) {
+	b := mock.New(1, 1, testRowSize)
+	if _, err := kvcache.NewTieredCausal(b, kvcache.TieredConfig{BlockSize: 4}); err == nil {
+		t.Error("NewTieredCausal accepted a config without a DiskStore")
+	}
+	if _, err := kvcache.NewTieredCausal(b, kvcache.TieredConfig{DiskStore: newTestStore(t)}); err == nil {
+		t.Error("NewTieredCausal accepted a zero block size")
+	}
+	if _, err := kvcache.NewTieredCausal(b, kvcache.TieredConfig{DiskStore: newTestStore(t), BlockSize: 4, MaxPressure: 1.5}); err == nil {
+		t.Error("NewTieredCausal accepted a maximum pressure above 1")
+	}
+}
+
+// FuzzSnapshotRestore evicts a random range of a sequence and restores it
+// into a fresh cache, which must get back exactly the evicted positions
+// with their original rows.
+func FuzzSnapshotRestore(f *testing.F) {
+	f.Add(uint8(4), uint8(20), uint8(0), uint8(20), uint8(1))
+	f.Add(uint8(4), uint8(20), uint8(3), uint8(13), uint8(2))
+	f.Add(uint8(1), uint8(7), uint8(6), uint8(7), uint8(1))
+	f.Add(uint8(16), uint8(50), uint8(17), uint8(49), uint8(3))
+	f.Fuzz(func(t *testing.T, blockSize, n, begin, end, layers uint8) {
+		if blockSize == 0 || n == 0 || layers == 0 || layers > 4 {
+			t.Skip()
+		}
+		begin, end = begin%n, min(end, n)
+		if begin >= end {
+			t.Skip()
+		}
+		cfg := kvcache.TieredConfig{DiskStore: newTestStore(t), BlockSize: int32(blockSize), Enable: true}
+
+		b := mock.New(int(layers), int(n), testRowSize)
+		b.Fill(1, 0, int32(n))
+		if err := newTiered(t, b, cfg).Remove(1, int32(begin), int32(end)); err != nil {
+			t.Fatal(err)
+		}
+		want := append(positions(0, int32(begin)), positions(int32(end), int32(n))...)
+		if got := b.Positions(1); !slices.Equal(got, want) && !(len(got) == 0 && len(want) == 0) {
+			t.Fatalf("after Remove: positions %v, want %v", got, want)
+		}
+
+		fresh := mock.New(int(layers), int(n), testRowSize)
+		tc := newTiered(t, fresh, cfg)
+		restored, err := tc.RestoreRange(1, int32(begin), int32(n))
+		if err != nil || restored != int32(end-begin) {
+			t.Fatalf("RestoreRange = %d, %v; want %d", restored, err, end-begin)
+		}
+		if got := fresh.Positions(1); !slices.Equal(got, positions(int32(begin), int32(end))) {
+			t.Fatalf("restored positions %v, want [%d, %d)", got, begin, end)
+		}
+		if err := fresh.Check(1, 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestAdaptiveRestore(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, Adaptive: true}
+	src := mock.New(2, 16, testRowSize)
+	src.Fill(1, 0, 10)
+	newTiered(t, src, cfg).Remove(1, 0, 10)
+
+	// Unmeasured speeds restore, which measures the disk.
+	b := mock.New(2, 16, testRowSize)
+	tc := newTiered(t, b, cfg)
+	if dec := tc.Decide(1, 0, 12); !dec.Restore || dec.Positions != 10 || dec.Disk != 0 {
+		t.Fatalf("Decide before any read = %+v, want a restore of 10 unmeasured", dec)
+	}
+	if n, _ := tc.RestoreRange(1, 0, 12); n != 10 {
+		t.Fatalf("RestoreRange = %d, want 10", n)
+	}
+	if store.ReadRate("local") <= 0 {
+		t.Fatal("restore did not measure the local tier")
+	}
+
+	// A slow model: restoring wins.
+	tc = newTiered(t, mock.New(2, 16, testRowSize), cfg)
+	tc.ObservePrefill(1, time.Hour)
+	if dec := tc.Decide(1, 0, 12); !dec.Restore || dec.Disk <= 0 || dec.Recompute != 10*time.Hour {
+		t.Errorf("Decide with slow prefill = %+v, want a restore", dec)
+	}
+
+	// A prefill far faster than any disk: recompute, but still read
+	// from disk now and then to follow the disk's speed.
+	b = mock.New(2, 16, testRowSize)
+	tc = newTiered(t, b, cfg)
+	tc.ObservePrefill(1e9, time.Millisecond)
+	if n, _ := tc.RestoreRange(1, 0, 12); n != 0 {
+		t.Errorf("RestoreRange with fast prefill = %d, want 0", n)
+	}
+	var restores int
+	for i := 0; i < 32; i++ {
+		if tc.Decide(1, 0, 12).Restore {
+			restores++
+		}
+	}
+	if restores == 0 || restores > 2 {
+		t.Errorf("%d of 32 decisions restored, want an occasional probe", restores)
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	dir := t.TempDir()
+	store, err := diskstore.New(diskstore.Config{
+		LocalPath:     filepath.Join(dir, "local"),
+		RemotePath:    filepath.Join(dir, "remote"),
+		LocalBudget:   1 << 30,
+		RemoteBudget:  1 << 30,
+		StatsInterval: -1,
+	})
+	if err != nil {
+		t.Fatalf("diskstore.New: %v", err)
+	}
+	defer store.Close()
+
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+	src := mock.New(2, 16, testRowSize)
+	src.Fill(1, 0, 8)
+	newTiered(t, src, cfg).Remove(1, 0, 8)
+	if _, err := store.Migrate(1, "remote"); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// Positions [4, 8): one block per layer and half.
+	b := mock.New(2, 16, testRowSize)
+	tc := newTiered(t, b, cfg)
+	if n := tc.Prefetch(1, 4, 8); n != 4 {
+		t.Fatalf("Prefetch queued %d blocks, want 4", n)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for store.Stats().Prefetched < 4 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if st := store.Stats(); st.LocalBlocks != 4 || st.RemoteBlocks != 4 {
+		t.Errorf("after Prefetch %d local, %d remote blocks; want 4 and 4", st.LocalBlocks, st.RemoteBlocks)
+	}
+	if n, _ := tc.RestoreRange(1, 0, 8); n != 8 {
+		t.Errorf("RestoreRange = %d, want 8", n)
+	}
+	if err := b.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSlidingWindowRestore(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+	src := mock.New(2, 16, testRowSize)
+	src.WindowSize = 4
+	src.Fill(1, 0, 12)
+	src.Fill(2, 0, 4)
+	tc := newTiered(t, src, cfg)
+	tc.Remove(1, 6, 12)
+	tc.Remove(2, 2, 4)
+
+	// Positions before 6 were never stored, but only the window [8, 12)
+	// is needed to continue at 12.
+	b := mock.New(2, 16, testRowSize)
+	b.WindowSize = 4
+	n, err := newTiered(t, b, cfg).RestoreRange(1, 0, 12)
+	if err != nil || n != 12 {
+		t.Fatalf("RestoreRange = %d, %v; want 12", n, err)
+	}
+	if got := b.Positions(1); !slices.Equal(got, positions(8, 12)) {
+		t.Errorf("restored positions %v, want 8-11", got)
+	}
+	if err := b.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+
+	// Two stored positions are less than a window and do not reach back
+	// to the start.
+	if n, _ := newTiered(t, b, cfg).RestoreRange(2, 0, 4); n != 0 {
+		t.Errorf("RestoreRange of a partial window = %d, want 0", n)
+	}
+	if got := b.Positions(2); len(got) != 0 {
+		t.Errorf("partial window left positions %v", got)
+	}
+}
+
+func TestTieredWrapperRestore(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+
+	// Layer 0 has global attention, layer 1 a window of 4.
+	parts := func() []*mock.Backend {
+		global, swa := mock.New(2, 16, testRowSize), mock.New(2, 16, testRowSize)
+		global.K[1], global.V[1] = nil, nil
+		swa.K[0], swa.V[0] = nil, nil
+		swa.WindowSize = 4
+		return []*mock.Backend{global, swa}
+	}
+	newWrapper := func(bs []*mock.Backend) *kvcache.TieredWrapper {
+		w, err := kvcache.NewTieredWrapper([]kvcache.Backend{bs[0], bs[1]}, cfg)
+		if err != nil {
+			t.Fatalf("NewTieredWrapper: %v", err)
+		}
+		return w
+	}
+
+	src := parts()
+	for _, b := range src {
+		b.Fill(1, 0, 12)
+	}
+	w := newWrapper(src)
+	w.Parts()[0].Remove(1, 0, 12)
+	w.Parts()[1].Remove(1, 0, 10)
+
+	// The window part only reaches 10, so both stop there.
+	dst := parts()
+	n, err := newWrapper(dst).RestoreRange(1, 0, 12)
+	if err != nil || n != 10 {
+		t.Fatalf("RestoreRange = %d, %v; want 10", n, err)
+	}
+	if got := dst[0].Positions(1); !slices.Equal(got, positions(0, 10)) {
+		t.Errorf("global part restored %v, want 0-9", got)
+	}
+	if got := dst[1].Positions(1); !slices.Equal(got, positions(6, 10)) {
+		t.Errorf("window part restored %v, want 6-9", got)
+	}
+	for _, b := range dst {
+		if err := b.Check(1, 1); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestTieredEncoder(t *testing.T) {
+	store := newTestStore(t)
+	enc := mock.NewEncoder(2, 8, testRowSize)
+	enc.V[1] = nil
+	te, err := kvcache.NewTieredEncoder(enc, store)
+	if err != nil {
+		t.Fatalf("NewTieredEncoder: %v", err)
+	}
+
+	// Image 1 is encoded and stored, then image 2 replaces it.
+	cat, dog := diskstore.ImageHash([]byte("cat")), diskstore.ImageHash([]byte("dog"))
+	enc.Encode(1, 6)
+	if err := te.Save(cat); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if !te.Has(cat) || te.Has(dog) {
+		t.Fatalf("Has(cat) = %v, Has(dog) = %v", te.Has(cat), te.Has(dog))
+	}
+	enc.Encode(2, 5)
+
+	// Sending image 1 again restores its output.
+	ok, err := te.Load(cat)
+	if err != nil || !ok {
+		t.Fatalf("Load(cat) = %v, %v", ok, err)
+	}
+	if enc.Len != 6 {
+		t.Errorf("restored %d rows, want 6", enc.Len)
+	}
+	if err := enc.CheckEncoded(1); err != nil {
+		t.Error(err)
+	}
+	if ok, err := te.Load(dog); ok || err != nil {
+		t.Errorf("Load(dog) = %v, %v; want false, nil", ok, err)
+	}
+
+	// Nothing cached, nothing saved.
+	enc.Len = 0
+	if err := te.Save(dog); err != nil || te.Has(dog) {
+		t.Errorf("Save with nothing cached = %v, stored %v", err, te.Has(dog))
+	}
+}
+
+func TestContextShiftRestore(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+
+	// Two context shifts: positions 4-11 go first, then 12-15, which
+	// sit at 4-7 by then.
+	src := mock.NewShifting(2, 32, testRowSize)
+	src.Fill(1, 0, 24)
+	tc := newTiered(t, src, cfg)
+	tc.Remove(1, 4, 12)
+	tc.Remove(1, 4, 8)
+	if got := tc.Shift(1); got != 12 {
+		t.Fatalf("Shift = %d, want 12", got)
+	}
+	got := store.Coverage(diskstore.BlockKey{Seq: 1, BeginPos: 0, EndPos: 24, IsKey: true})
+	if want := []diskstore.Span{{Begin: 4, End: 12}, {Begin: 12, End: 16, Shift: 8}}; !slices.Equal(got, want) {
+		t.Fatalf("stored %v, want %v", got, want)
+	}
+
+	// A new prompt numbers positions from 0 again. The rows stored after
+	// the first shift are restored with their keys shifted back.
+	dst := mock.NewShifting(2, 32, testRowSize)
+	dst.Fill(1, 0, 4)
+	td := newTiered(t, dst, cfg)
+	td.SetPrompt(1, nil)
+	n, err := td.RestoreRange(1, 4, 24)
+	if err != nil || n != 12 {
+		t.Fatalf("RestoreRange = %d, %v; want 12", n, err)
+	}
+	if err := dst.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+	for i := 0; i < dst.NumCells(); i++ {
+		pos, seqs := dst.Cell(i)
+		want := int32(0)
+		if len(seqs) > 0 && pos >= 12 {
+			want = 8
+		}
+		if dst.Shifts[i] != want {
+			t.Errorf("cell %d (pos %d) shifted by %d, want %d", i, pos, dst.Shifts[i], want)
+		}
+	}
+
+	// A backend that cannot shift keys stops where the shift changes.
+	plain := mock.New(2, 32, testRowSize)
+	plain.Fill(1, 0, 4)
+	n, err = newTiered(t, plain, cfg).RestoreRange(1, 4, 24)
+	if err != nil || n != 8 {
+		t.Fatalf("RestoreRange without Shifter = %d, %v; want 8", n, err)
+	}
+}
+
+func TestRestoreAudit(t *testing.T) {
+	store := newTestStore(t)
+	var records []kvcache.AuditRecord
+	cfg := kvcache.TieredConfig{
+		DiskStore: store, BlockSize: 4, Enable: true,
+		Audit:   kvcache.AuditStrict,
+		OnAudit: func(r kvcache.AuditRecord) { records = append(records, r) },
+	}
+
+	prompt := make([]int32, 12)
+	for i := range prompt {
+		prompt[i] = 100 + int32(i)
+	}
+	other := slices.Clone(prompt)
+	other[6] = 7
+
+	src := mock.New(2, 16, testRowSize)
+	src.Fill(1, 0, 12)
+	tc := newTiered(t, src, cfg)
+	tc.SetPrompt(1, prompt)
+	tc.Remove(1, 4, 12)
+
+	restore := func(cfg kvcache.TieredConfig, tokens []int32) (*kvcache.TieredCausal, int32) {
+		t.Helper()
+		b := mock.New(2, 16, testRowSize)
+		b.Fill(1, 0, 4)
+		tc := newTiered(t, b, cfg)
+		tc.SetPrompt(1, tokens)
+		n, err := tc.RestoreRange(1, 4, 12)
+		if err != nil {
+			t.Fatalf("RestoreRange: %v", err)
+		}
+		return tc, n
+	}
+
+	if _, n := restore(cfg, prompt); n != 8 || len(records) != 0 {
+		t.Fatalf("matching prompt: restored %d, records %v; want 8, none", n, records)
+	}
+
+	// Strict: a mismatch restores nothing until the next prompt.
+	tc, n := restore(cfg, other)
+	if n != 0 || len(records) != 1 {
+		t.Fatalf("other prompt: restored %d, records %v; want 0, one", n, records)
+	}
+	r := records[0]
+	if r.Seq != 1 || r.Begin != 4 || r.End != 12 || r.Pos != 6 || r.Mismatched != 1 || !r.Strict ||
+		r.Stored[0] != 106 || r.Prompt[0] != 7 || len(r.Stored) != 6 {
+		t.Errorf("record = %+v", r)
+	}
+	if n, _ := tc.RestoreRange(1, 4, 12); n != 0 {
+		t.Errorf("restored %d after a mismatch, want 0", n)
+	}
+	tc.SetPrompt(1, prompt)
+	if n, _ := tc.RestoreRange(1, 4, 12); n != 8 {
+		t.Errorf("restored %d with the matching prompt set again, want 8", n)
+	}
+
+	// Strict restores only positions it can check.
+	if _, n := restore(cfg, prompt[:10]); n != 6 {
+		t.Errorf("short prompt: restored %d, want 6", n)
+	}
+
+	// Logging reports the mismatch but restores.
+	records = nil
+	cfg.Audit = kvcache.AuditLog
+	if _, n := restore(cfg, other); n != 8 || len(records) != 1 || records[0].Strict {
+		t.Errorf("log mode: restored %d, records %+v; want 8 and one", n, records)
+	}
+}
+
+func TestLayerPolicy(t *testing.T) {
+	p, err := kvcache.ParseLayerPolicy("every:2,above:7")
+	if err != nil {
+		t.Fatalf("ParseLayerPolicy: %v", err)
+	}
+	for layer, want := range map[int]bool{0: false, 7: false, 8: true, 9: false, 10: true} {
+		if p.Stores(layer) != want {
+			t.Errorf("%v stores layer %d = %v, want %v", p, layer, !want, want)
+		}
+	}
+	if q, err := kvcache.ParseLayerPolicy(p.String()); err != nil || q != p {
+		t.Errorf("ParseLayerPolicy(%q) = %+v, %v", p, q, err)
+	}
+	if _, err := kvcache.ParseLayerPolicy("odd"); err == nil {
+		t.Error("ParseLayerPolicy accepted an unknown policy")
+	}
+
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, Layers: kvcache.LayerPolicy{Every: 2}}
+	src := mock.New(4, 16, testRowSize)
+	src.Fill(1, 0, 8)
+	newTiered(t, src, cfg).Remove(1, 0, 8)
+	// Layers 0 and 2 of two runs.
+	if got := store.Stats().LocalBlocks; got != 8 {
+		t.Errorf("stored %d blocks, want 8", got)
+	}
+
+	// Without a LayerRecomputer the skipped layers cannot be restored.
+	if err := cfg.Validate(); err == nil {
+		t.Error("Validate accepted a layer policy without a LayerRecomputer")
+	}
+	dst := mock.New(4, 16, testRowSize)
+	if n, _ := newTiered(t, dst, cfg).RestoreRange(1, 0, 8); n != 0 {
+		t.Errorf("restored %d without a LayerRecomputer, want 0", n)
+	}
+
+	rec := &mock.LayerRecomputer{Backend: dst}
+	cfg.RecomputeLayers = rec
+	n, err := newTiered(t, dst, cfg).RestoreRange(1, 0, 8)
+	if err != nil || n != 8 {
+		t.Fatalf("RestoreRange = %d, %v; want 8", n, err)
+	}
+	if err := dst.Check(1, 1); err != nil {
+		t.Error(err)
+	}
+	if rec.Rows != 16 {
+		t.Errorf("recomputed %d rows, want 16 (layers 1 and 3)", rec.Rows)
+	}
+}
+
+func TestCopyPrefix(t *testing.T) {
+	store := newTestStore(t)
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+
+	src := mock.NewShifting(2, 32, testRowSize)
+	src.Fill(1, 0, 16)
+	tc := newTiered(t, src, cfg)
+	tc.Remove(1, 0, 12)
+	src.Fill(2, 0, 4)
+	tc.Remove(2, 0, 4)
+
+	// Forking seq 1 into seq 2 replaces seq 2's blocks with seq 1's up to
+	// position 10, which is 22 before the shift, so all of them.
+	if err := tc.CopyPrefix(1, 2, 10); err != nil {
+		t.Fatalf("CopyPrefix: %v", err)
+	}
+	if got := tc.Shift(2); got != 12 {
+		t.Errorf("Shift(2) = %d, want 12", got)
+	}
+	got := store.Coverage(diskstore.BlockKey{Seq: 2, BeginPos: 0, EndPos: 32, IsKey: true})
+	if want := []diskstore.Span{{Begin: 0, End: 12}}; !slices.Equal(got, want) {
+		t.Errorf("seq 2 stores %v, want %v", got, want)
+	}
+	if got := store.Stats().LocalUsed; got != 2*2*12*testRowSize {
+		t.Errorf("LocalUsed = %d, want seq 1's blocks only", got)
+	}
+
+	dst := mock.New(2, 32, testRowSize)
+	n, err := newTiered(t, dst, cfg).RestoreRange(2, 0, 12)
+	if err != nil || n != 12 {
+		t.Fatalf("RestoreRange = %d, %v; want 12", n, err)
+	}
+	if err := dst.Check(2, 1); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestCheckpointResume(t *testing.T) {
+	dir := t.TempDir()
+	open := func() *diskstore.Store {
+		store, err := diskstore.New(diskstore.Config{LocalPath: dir, LocalBudget: 1 << 30, StatsInterval: -1})
+		if err != nil {
+			t.Fatalf("diskstore.New: %v", err)
+		}
+		return store
+	}
+	tokens := func(begin, end int32) []int32 {
+		var out []int32
+		for _, p := range positions(begin, end) {
+			out = append(out, 100+p)
+		}
+		return out
+	}
+	store := open()
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true}
+
+	b := mock.New(2, 32, testRowSize)
+	b.Fill(1, 0, 10)
+	tc := newTiered(t, b, cfg)
+	if n, err := tc.Checkpoint(1, tokens(0, 10)); err != nil || n != 10 {
+		t.Fatalf("Checkpoint = %d, %v; want 10", n, err)
+	}
+	if n, _ := tc.Checkpoint(1, tokens(0, 10)); n != 0 {
+		t.Errorf("unchanged Checkpoint wrote %d positions", n)
+	}
+	// Blocks before the first changed one are kept.
+	b.Fill(1, 10, 14)
+	if n, err := tc.Checkpoint(1, tokens(0, 14)); err != nil || n != 6 {
+		t.Fatalf("extended Checkpoint = %d, %v; want 6 from position 8", n, err)
+	}
+	store.Close()
+
+	// After a restart, a prompt sharing 12 tokens with the checkpoint
+	// resumes them in another slot, leaving the rest to compute.
+	store = open()
+	defer store.Close()
+	cfg.DiskStore = store
+	fresh := mock.New(2, 32, testRowSize)
+	tc = newTiered(t, fresh, cfg)
+	prompt := append(tokens(0, 12), 1, 2, 3)
+	tc.SetPrompt(3, prompt)
+	if n, err := tc.Resume(3, prompt); err != nil || n != 12 {
+		t.Fatalf("Resume = %d, %v; want 12", n, err)
+	}
+	if n, err := tc.RestoreRange(3, 0, int32(len(prompt))); err != nil || n != 12 {
+		t.Fatalf("RestoreRange = %d, %v; want 12", n, err)
+	}
+	if err := fresh.Check(3, 1); err != nil {
+		t.Error(err)
+	}
+
+	// A whole match leaves one token to compute; no match resumes nothing.
+	if n, _ := tc.Resume(4, tokens(0, 14)); n != 13 {
+		t.Errorf("Resume of the whole checkpoint = %d, want 13", n)
+	}
+	if n, _ := tc.Resume(5, []int32{1, 2, 3}); n != 0 {
+		t.Errorf("Resume of another prompt = %d, want 0", n)
+	}
+}
+
+func TestSkipSnapshotUnderPressure(t *testing.T) {
+	// Any write is slow against a nanosecond, so the store is under full
+	// pressure from the first one.
+	store, err := diskstore.New(diskstore.Config{
+		LocalPath:       filepath.Join(t.TempDir(), "local"),
+		LocalBudget:     1 << 30,
+		StatsInterval:   -1,
+		PressureLatency: time.Nanosecond,
+	})
+	if err != nil {
+		t.Fatalf("diskstore.New: %v", err)
+	}
+	defer store.Close()
+	cfg := kvcache.TieredConfig{DiskStore: store, BlockSize: 4, Enable: true, MaxPressure: 0.5}
+
+	b := mock.New(1, 16, testRowSize)
+	b.Fill(1, 0, 8)
+	tc := newTiered(t, b, cfg)
+	if err := tc.Remove(1, 0, 4); err != nil {
+		t.Fatalf("Remove: %v", err)
+	}
+	if got := tc.DiskStats().LocalBlocks; got != 2 {
+		t.Fatalf("idle store: stored %d blocks, want 2", got)
+	}
+	if err := tc.Remove(1, 4, 8); err != nil {
+		t.Fatalf("Remove under pressure: %v", err)
+	}
+	if got := tc.DiskStats().LocalBlocks; got != 2 {
+		t.Errorf("under pressure: stored %d blocks, want none added", got)
+	}
+	if got := b.Positions(1); len(got) != 0 {
+		t.Errorf("positions %v still cached after a skipped snapshot", got)
+	}
+	if got := tc.SkippedSnapshots(); got != 4 {
+		t.Errorf("SkippedSnapshots = %d, want 4", got)
+	}
+}
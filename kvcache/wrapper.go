@@ -0,0 +1,267 @@
+package kvcache
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/databloom/ollama-kv-cache-tiering/diskstore"
+)
+
+// Tiered is what the runner calls on a tiered cache, whether it has one
+// part (TieredCausal) or several (TieredWrapper).
+type Tiered interface {
+	Remove(seq int, beginPos, endPos int32) error
+	CopyPrefix(srcSeq, dstSeq int, length int32) error
+	RestoreRange(seq int, beginPos, endPos int32) (int32, error)
+	RestoreRangeContext(ctx context.Context, seq int, beginPos, endPos int32) (int32, error)
+	Prefetch(seq int, beginPos, endPos int32) int
+	ObservePrefill(n int, d time.Duration)
+
+	SetPrompt(seq int, tokens []int32)
+	RecordTokens(seq int, tokens []int32)
+	MatchPrompt(seq int) int32
+
+	Checkpoint(seq int, tokens []int32) (int32, error)
+	Resume(seq int, tokens []int32) (int32, error)
+
+	ServePrefill()
+	SetPrefillPeer(peer *diskstore.RemoteClient, wait time.Duration)
+	PublishPrefill(seq int, tokens []int32)
+	PullPrefill(ctx context.Context, seq int, tokens []int32) (bool, error)
+
+	DiskExpired(seq int) bool
+	DiskStats() diskstore.Stats
+}
+
+var (
+	_ Tiered = (*TieredCausal)(nil)
+	_ Tiered = (*TieredWrapper)(nil)
+)
+
+// TieredWrapper tiers a cache made of several causal caches that each
+// hold some of the layers, such as Ollama's WrapperCache for models
+// mixing sliding-window and global attention. Every part is a
+// TieredCausal on the same store; since the parts hold different layers
+// their blocks do not collide. A sequence is only restored as far as
+// every part can restore it.
+type TieredWrapper struct {
+	parts []*TieredCausal
+}
+
+// NewTieredWrapper tiers each of backends into cfg.DiskStore.
+func NewTieredWrapper(backends []Backend, cfg TieredConfig) (*TieredWrapper, error) {
+	if len(backends) == 0 {
+		return nil, fmt.Errorf("kvcache: TieredWrapper needs at least one backend")
+	}
+	w := &TieredWrapper{}
+	for _, b := range backends {
+		t, err := NewTieredCausal(b, cfg)
+		if err != nil {
+			return nil, err
+		}
+		w.parts = append(w.parts, t)
+	}
+	return w, nil
+}
+
+// Parts returns the tiering of each backend, in order.
+func (w *TieredWrapper) Parts() []*TieredCausal {
+	return w.parts
+}
+
+// Remove snapshots and removes the positions from every part, stopping at
+// the first error as Ollama's WrapperCache does.
+func (w *TieredWrapper) Remove(seq int, beginPos, endPos int32) error {
+	for _, t := range w.parts {
+		if err := t.Remove(seq, beginPos, endPos); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyPrefix follows the backend's CopyPrefix in every part (see
+// TieredCausal.CopyPrefix). The parts share the store, so dstSeq's blocks
+// are copied once for all of them.
+func (w *TieredWrapper) CopyPrefix(srcSeq, dstSeq int, length int32) error {
+	if srcSeq == dstSeq {
+		return nil
+	}
+	for _, t := range w.parts {
+		t.copyState(srcSeq, dstSeq, length)
+	}
+	t := w.parts[0]
+	if _, err := t.store.CopySeq(srcSeq, dstSeq, length+t.Shift(dstSeq)); err != nil {
+		return fmt.Errorf("kvcache: copy seq %d to %d: %w", srcSeq, dstSeq, err)
+	}
+	return nil
+}
+
+// RestoreRange restores seq in every part up to the furthest end all of
+// them can reach (see TieredCausal.RestoreRange) and returns how far the
+// prefix now extends. If a part falls short of that end, what the others
+// restored beyond it is removed again.
+func (w *TieredWrapper) RestoreRange(seq int, beginPos, endPos int32) (int32, error) {
+	return w.RestoreRangeContext(context.Background(), seq, beginPos, endPos)
+}
+
+// RestoreRangeContext is RestoreRange giving up on disk reads when ctx is
+// done (see TieredCausal.RestoreRangeContext).
+func (w *TieredWrapper) RestoreRangeContext(ctx context.Context, seq int, beginPos, endPos int32) (int32, error) {
+	start := time.Now()
+	rows := make([]*rowReader, len(w.parts))
+	target := endPos
+	for i, t := range w.parts {
+		r, avail := t.probe(ctx, seq, beginPos, endPos)
+		if r == nil {
+			return 0, nil
+		}
+		rows[i], target = r, min(target, avail)
+	}
+
+	// A window part may accept a shorter end only at a different point
+	// (its window must be stored right before the end), so lower the end
+	// until every part accepts it.
+	for target > beginPos {
+		next := target
+		for i, t := range w.parts {
+			next = min(next, t.scan(rows[i], seq, beginPos, target))
+		}
+		if next == target {
+			break
+		}
+		target = next
+	}
+	if target <= beginPos {
+		return 0, nil
+	}
+
+	reached := target
+	for i, t := range w.parts {
+		reached = min(reached, t.restoreTo(rows[i], seq, beginPos, target, start))
+	}
+	if reached < target {
+		for _, t := range w.parts {
+			t.backend.Remove(seq, reached, math.MaxInt32)
+		}
+	}
+	return reached - beginPos, nil
+}
+
+// Prefetch queues seq's remote blocks of every part (see
+// TieredCausal.Prefetch) and returns how many blocks it queued.
+func (w *TieredWrapper) Prefetch(seq int, beginPos, endPos int32) int {
+	var n int
+	for _, t := range w.parts {
+		n += t.Prefetch(seq, beginPos, endPos)
+	}
+	return n
+}
+
+// ObservePrefill reports a prefill batch to every part.
+func (w *TieredWrapper) ObservePrefill(n int, d time.Duration) {
+	for _, t := range w.parts {
+		t.ObservePrefill(n, d)
+	}
+}
+
+// SetPrompt records seq's prompt in every part.
+func (w *TieredWrapper) SetPrompt(seq int, tokens []int32) {
+	for _, t := range w.parts {
+		t.SetPrompt(seq, tokens)
+	}
+}
+
+// RecordTokens records seq's tokens in every part.
+func (w *TieredWrapper) RecordTokens(seq int, tokens []int32) {
+	for _, t := range w.parts {
+		t.RecordTokens(seq, tokens)
+	}
+}
+
+// MatchPrompt returns how many leading positions of seq's prompt every
+// part has stored as prefix-addressed blocks.
+func (w *TieredWrapper) MatchPrompt(seq int) int32 {
+	n := w.parts[0].MatchPrompt(seq)
+	for _, t := range w.parts[1:] {
+		n = min(n, t.MatchPrompt(seq))
+	}
+	return n
+}
+
+// Checkpoint stores seq's checkpoint in every part (see
+// TieredCausal.Checkpoint) and returns the fewest positions a part
+// wrote. The parts share the store, so the stale end of the checkpoint
+// is removed once, from the first position any part must rewrite.
+func (w *TieredWrapper) Checkpoint(seq int, tokens []int32) (int32, error) {
+	keep, stale := int32(math.MaxInt32), false
+	for _, t := range w.parts {
+		if from, ok := t.checkpointFrom(seq, tokens); ok {
+			keep, stale = min(keep, from), true
+		}
+	}
+	if !stale {
+		return 0, nil
+	}
+	if _, err := w.parts[0].store.TruncateSeq(checkpointSeqBase+seq, keep); err != nil {
+		return 0, fmt.Errorf("kvcache: checkpoint seq %d: %w", seq, err)
+	}
+	n := w.parts[0].writeCheckpoint(seq, tokens, keep)
+	for _, t := range w.parts[1:] {
+		n = min(n, t.writeCheckpoint(seq, tokens, keep))
+	}
+	return n, nil
+}
+
+// Resume gives seq the blocks of the checkpoint best matching tokens
+// (see TieredCausal.Resume). The store is shared, so that brings in the
+// blocks of every part; RestoreRange restores as far as all of them can.
+func (w *TieredWrapper) Resume(seq int, tokens []int32) (int32, error) {
+	return w.parts[0].Resume(seq, tokens)
+}
+
+// ServePrefill publishes prompts like TieredCausal.ServePrefill, and
+// snapshots every part when a decode node pulls one.
+func (w *TieredWrapper) ServePrefill() {
+	for _, t := range w.parts {
+		t.mu.Lock()
+		t.publish = true
+		t.mu.Unlock()
+	}
+	w.parts[0].store.SetExportHook(func(seq int, length int32) error {
+		for _, t := range w.parts {
+			t.snapshotRange(seq, 0, length)
+		}
+		return nil
+	})
+}
+
+// SetPrefillPeer sets the prefill node PullPrefill fetches from. The
+// store is shared, so one pull brings in the blocks of every part.
+func (w *TieredWrapper) SetPrefillPeer(peer *diskstore.RemoteClient, wait time.Duration) {
+	w.parts[0].SetPrefillPeer(peer, wait)
+}
+
+// PublishPrefill offers the KV cache of tokens in seq to decode nodes.
+func (w *TieredWrapper) PublishPrefill(seq int, tokens []int32) {
+	w.parts[0].PublishPrefill(seq, tokens)
+}
+
+// PullPrefill fetches the KV cache of tokens from the prefill peer (see
+// TieredCausal.PullPrefill).
+func (w *TieredWrapper) PullPrefill(ctx context.Context, seq int, tokens []int32) (bool, error) {
+	return w.parts[0].PullPrefill(ctx, seq, tokens)
+}
+
+// DiskExpired reports, once, whether the disk cache for seq was garbage
+// collected since it was last used.
+func (w *TieredWrapper) DiskExpired(seq int) bool {
+	return w.parts[0].DiskExpired(seq)
+}
+
+// DiskStats returns the statistics of the store the parts share.
+func (w *TieredWrapper) DiskStats() diskstore.Stats {
+	return w.parts[0].DiskStats()
+}